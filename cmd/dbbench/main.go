@@ -0,0 +1,452 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Command dbbench runs the standard LevelDB benchmark workloads, in the
+// manner of db_bench, so that the effect of options and ciphers can be
+// measured reproducibly.
+//
+// Usage:
+//
+//	dbbench [-db path] [-benchmarks list] [-num n] [-cipher none|xor|aes] [flags]
+//
+// The benchmarks, run in the given order on the same DB, are:
+//
+//	fillseq           write num values in key order, in a new DB
+//	fillrandom        write num values in random key order, in a new DB
+//	readrandom        read reads keys in random order
+//	readwhilewriting  as readrandom, with a goroutine writing meanwhile
+//	seekrandom        seek to reads keys in random order
+//
+// The keys are those written by the fill benchmarks, so the read benchmarks
+// should follow one of them, or use -use_existing_db. Each benchmark
+// reports its throughput and latency percentiles, as text or, with -json,
+// as JSON lines. The random keys and values are seeded by -seed, so runs are
+// comparable.
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/filter"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// Default key, used when a cipher is set without -key.
+const defaultKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+// bench holds the flags and the DB.
+type bench struct {
+	dbPath        string
+	benchmarks    string
+	num           int
+	reads         int
+	threads       int
+	valueSize     int
+	batchSize     int
+	seed          int64
+	cipher        string
+	key           string
+	writeBuffer   int
+	cacheSize     int
+	bloomBits     int
+	compression   bool
+	sync          bool
+	useExistingDB bool
+	json          bool
+
+	db     *leveldb.DB
+	values []byte // Source of the values.
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// result is the report of a benchmark.
+type result struct {
+	Name        string  `json:"name"`
+	Cipher      string  `json:"cipher"`
+	Ops         int     `json:"ops"`
+	Seconds     float64 `json:"seconds"`
+	MicrosPerOp float64 `json:"micros_per_op"`
+	MBPerSec    float64 `json:"mb_per_sec,omitempty"`
+	P50         float64 `json:"p50_micros"`
+	P95         float64 `json:"p95_micros"`
+	P99         float64 `json:"p99_micros"`
+	Max         float64 `json:"max_micros"`
+	Note        string  `json:"note,omitempty"`
+}
+
+var benchmarks = map[string]func(b *bench) (*result, error){
+	"fillseq":          func(b *bench) (*result, error) { return b.fill(false) },
+	"fillrandom":       func(b *bench) (*result, error) { return b.fill(true) },
+	"readrandom":       func(b *bench) (*result, error) { return b.read(false, false) },
+	"readwhilewriting": func(b *bench) (*result, error) { return b.read(false, true) },
+	"seekrandom":       func(b *bench) (*result, error) { return b.read(true, false) },
+}
+
+var errUsage = errors.New("usage")
+
+func main() {
+	b := &bench{stdout: os.Stdout, stderr: os.Stderr}
+	if err := b.main(os.Args[1:]); err != nil {
+		if err != errUsage {
+			fmt.Fprintln(os.Stderr, "dbbench:", err)
+		}
+		os.Exit(2)
+	}
+}
+
+func (b *bench) main(args []string) error {
+	fs := flag.NewFlagSet("dbbench", flag.ContinueOnError)
+	fs.SetOutput(b.stderr)
+	fs.StringVar(&b.dbPath, "db", filepath.Join(os.TempDir(), "dbbench"), "database path")
+	fs.StringVar(&b.benchmarks, "benchmarks", "fillseq,fillrandom,readrandom,readwhilewriting,seekrandom", "comma-separated list of benchmarks")
+	fs.IntVar(&b.num, "num", 1000000, "number of entries written")
+	fs.IntVar(&b.reads, "reads", -1, "number of reads, num if negative")
+	fs.IntVar(&b.threads, "threads", 1, "number of reading goroutines")
+	fs.IntVar(&b.valueSize, "value_size", 100, "size of the values")
+	fs.IntVar(&b.batchSize, "batch_size", 1, "number of entries per write batch")
+	fs.Int64Var(&b.seed, "seed", 301, "seed of the random keys and values")
+	fs.StringVar(&b.cipher, "cipher", "none", "encryption cipher, none, xor or aes")
+	fs.StringVar(&b.key, "key", "", "hex-encoded encryption key, a fixed key if empty")
+	fs.IntVar(&b.writeBuffer, "write_buffer_size", opt.DefaultWriteBuffer, "write buffer size")
+	fs.IntVar(&b.cacheSize, "cache_size", opt.DefaultBlockCacheCapacity, "block cache capacity")
+	fs.IntVar(&b.bloomBits, "bloom_bits", -1, "bits per key of the bloom filter, none if negative")
+	fs.BoolVar(&b.compression, "compression", true, "compress the blocks with snappy")
+	fs.BoolVar(&b.sync, "sync", false, "sync the writes")
+	fs.BoolVar(&b.useExistingDB, "use_existing_db", false, "don't delete the DB before the fill benchmarks")
+	fs.BoolVar(&b.json, "json", false, "report as JSON lines")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 {
+		return errUsage
+	}
+	if b.reads < 0 {
+		b.reads = b.num
+	}
+	if b.num <= 0 || b.threads <= 0 || b.batchSize <= 0 {
+		return errors.New("-num, -threads and -batch_size must be positive")
+	}
+	if b.valueSize < 0 || b.valueSize > 1<<20 {
+		return errors.New("-value_size must be between 0 and 1MiB")
+	}
+	var names []string
+	for _, name := range strings.Split(b.benchmarks, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if benchmarks[name] == nil {
+			return fmt.Errorf("unknown benchmark %q", name)
+		}
+		names = append(names, name)
+	}
+	if err := b.setCipher(); err != nil {
+		return err
+	}
+	b.values = newValues(rand.New(rand.NewSource(b.seed)))
+
+	if !b.json {
+		b.printHeader()
+	}
+	defer func() {
+		if b.db != nil {
+			b.db.Close()
+		}
+	}()
+	for _, name := range names {
+		res, err := benchmarks[name](b)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		res.Name, res.Cipher = name, b.cipher
+		b.report(res)
+	}
+	return nil
+}
+
+func (b *bench) setCipher() error {
+	switch b.cipher {
+	case "none":
+		leveldb.EncryptionVersion, leveldb.EncryptionKey = 0, nil
+		return nil
+	case "xor":
+		leveldb.EncryptionVersion = 1
+	case "aes":
+		leveldb.EncryptionVersion = 2
+	default:
+		return fmt.Errorf("unknown cipher %q", b.cipher)
+	}
+	key := b.key
+	if key == "" {
+		key = defaultKey
+	}
+	k, err := hex.DecodeString(key)
+	if err != nil || len(k) == 0 {
+		return fmt.Errorf("invalid -key %q", key)
+	}
+	leveldb.EncryptionKey = k
+	return nil
+}
+
+func (b *bench) printHeader() {
+	fmt.Fprintf(b.stdout, "Keys:        16 bytes each\n")
+	fmt.Fprintf(b.stdout, "Values:      %d bytes each (%d bytes after compression)\n", b.valueSize, b.valueSize/2)
+	fmt.Fprintf(b.stdout, "Entries:     %d\n", b.num)
+	fmt.Fprintf(b.stdout, "Cipher:      %s\n", b.cipher)
+	fmt.Fprintf(b.stdout, "Compression: %v\n", b.compression)
+	fmt.Fprintf(b.stdout, "------------------------------------------------\n")
+}
+
+func (b *bench) report(res *result) {
+	if b.json {
+		json.NewEncoder(b.stdout).Encode(res)
+		return
+	}
+	line := fmt.Sprintf("%-16s : %11.3f micros/op;", res.Name, res.MicrosPerOp)
+	if res.MBPerSec > 0 {
+		line += fmt.Sprintf(" %6.1f MB/s;", res.MBPerSec)
+	}
+	line += fmt.Sprintf(" p50 %.1f p95 %.1f p99 %.1f max %.1f micros", res.P50, res.P95, res.P99, res.Max)
+	if res.Note != "" {
+		line += " (" + res.Note + ")"
+	}
+	fmt.Fprintln(b.stdout, line)
+}
+
+// Opens the DB, deleting it first if fresh and not using an existing DB.
+func (b *bench) open(fresh bool) error {
+	if b.db != nil {
+		if !fresh || b.useExistingDB {
+			return nil
+		}
+		b.db.Close()
+		b.db = nil
+	}
+	if fresh && !b.useExistingDB {
+		if err := os.RemoveAll(b.dbPath); err != nil {
+			return err
+		}
+	}
+	o := &opt.Options{
+		WriteBuffer:        b.writeBuffer,
+		BlockCacheCapacity: b.cacheSize,
+	}
+	if b.bloomBits >= 0 {
+		o.Filter = filter.NewBloomFilter(b.bloomBits)
+	}
+	if !b.compression {
+		o.Compression = opt.NoCompression
+	}
+	db, err := leveldb.OpenFile(b.dbPath, o)
+	if err != nil {
+		return err
+	}
+	b.db = db
+	return nil
+}
+
+// Returns a buffer of random data compressing to about half its size, the
+// values being slices of it, as db_bench.
+func newValues(rnd *rand.Rand) []byte {
+	const size = 1 << 20
+	values := make([]byte, 0, size+100)
+	for len(values) < size {
+		piece := make([]byte, 50)
+		for i := range piece {
+			piece[i] = ' ' + byte(rnd.Intn(95))
+		}
+		values = append(values, piece...)
+		values = append(values, piece...)
+	}
+	return values
+}
+
+func (b *bench) value(rnd *rand.Rand) []byte {
+	off := rnd.Intn(len(b.values) - b.valueSize)
+	return b.values[off : off+b.valueSize]
+}
+
+func key(i int) []byte {
+	return []byte(fmt.Sprintf("%016d", i))
+}
+
+// latencies records the durations of operations.
+type latencies []time.Duration
+
+// Sets the percentiles of the result.
+func (l latencies) report(res *result) {
+	if len(l) == 0 {
+		return
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+	pct := func(p float64) float64 {
+		return float64(l[int(p*float64(len(l)-1))]) / float64(time.Microsecond)
+	}
+	res.P50, res.P95, res.P99, res.Max = pct(0.50), pct(0.95), pct(0.99), pct(1)
+}
+
+func newResult(ops int, elapsed time.Duration, bytes int64) *result {
+	res := &result{
+		Ops:     ops,
+		Seconds: elapsed.Seconds(),
+	}
+	if ops > 0 {
+		res.MicrosPerOp = float64(elapsed) / float64(time.Microsecond) / float64(ops)
+	}
+	if bytes > 0 && elapsed > 0 {
+		res.MBPerSec = float64(bytes) / (1 << 20) / elapsed.Seconds()
+	}
+	return res
+}
+
+func (b *bench) fill(random bool) (*result, error) {
+	if err := b.open(true); err != nil {
+		return nil, err
+	}
+	rnd := rand.New(rand.NewSource(b.seed))
+	wo := &opt.WriteOptions{Sync: b.sync}
+	lat := make(latencies, 0, b.num/b.batchSize+1)
+	batch := new(leveldb.Batch)
+	var bytes int64
+	start := time.Now()
+	for i := 0; i < b.num; i += b.batchSize {
+		batch.Reset()
+		for j := i; j < i+b.batchSize && j < b.num; j++ {
+			k := j
+			if random {
+				k = rnd.Intn(b.num)
+			}
+			value := b.value(rnd)
+			batch.Put(key(k), value)
+			bytes += int64(16 + len(value))
+		}
+		t := time.Now()
+		if err := b.db.Write(batch, wo); err != nil {
+			return nil, err
+		}
+		lat = append(lat, time.Since(t))
+	}
+	res := newResult(b.num, time.Since(start), bytes)
+	lat.report(res)
+	return res, nil
+}
+
+// Runs the read benchmarks, with a writer while reading if writing.
+func (b *bench) read(seek, writing bool) (*result, error) {
+	if err := b.open(false); err != nil {
+		return nil, err
+	}
+	stop := make(chan struct{})
+	writeErr := make(chan error, 1)
+	if writing {
+		go func() {
+			rnd := rand.New(rand.NewSource(b.seed + 1))
+			for {
+				select {
+				case <-stop:
+					writeErr <- nil
+					return
+				default:
+				}
+				if err := b.db.Put(key(rnd.Intn(b.num)), b.value(rnd), nil); err != nil {
+					writeErr <- err
+					return
+				}
+			}
+		}()
+	}
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		lat   = make(latencies, 0, b.reads)
+		found int
+		errs  []error
+	)
+	start := time.Now()
+	for t := 0; t < b.threads; t++ {
+		n := b.reads / b.threads
+		if t < b.reads%b.threads {
+			n++
+		}
+		wg.Add(1)
+		go func(t, n int) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(b.seed + 2 + int64(t)))
+			tlat := make(latencies, 0, n)
+			tfound := 0
+			var err error
+			if seek {
+				tfound, err = b.seekLoop(rnd, n, &tlat)
+			} else {
+				tfound, err = b.readLoop(rnd, n, &tlat)
+			}
+			mu.Lock()
+			lat = append(lat, tlat...)
+			found += tfound
+			if err != nil {
+				errs = append(errs, err)
+			}
+			mu.Unlock()
+		}(t, n)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	if writing {
+		close(stop)
+		if err := <-writeErr; err != nil {
+			return nil, err
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	res := newResult(b.reads, elapsed, 0)
+	res.Note = fmt.Sprintf("%d of %d found", found, b.reads)
+	lat.report(res)
+	return res, nil
+}
+
+func (b *bench) readLoop(rnd *rand.Rand, n int, lat *latencies) (found int, err error) {
+	for i := 0; i < n; i++ {
+		k := key(rnd.Intn(b.num))
+		t := time.Now()
+		_, err := b.db.Get(k, nil)
+		*lat = append(*lat, time.Since(t))
+		switch err {
+		case nil:
+			found++
+		case leveldb.ErrNotFound:
+		default:
+			return found, err
+		}
+	}
+	return found, nil
+}
+
+func (b *bench) seekLoop(rnd *rand.Rand, n int, lat *latencies) (found int, err error) {
+	iter := b.db.NewIterator(nil, nil)
+	defer iter.Release()
+	for i := 0; i < n; i++ {
+		k := key(rnd.Intn(b.num))
+		t := time.Now()
+		if iter.Seek(k) && string(iter.Key()) == string(k) {
+			found++
+		}
+		*lat = append(*lat, time.Since(t))
+	}
+	return found, iter.Error()
+}
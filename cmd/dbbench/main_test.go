@@ -0,0 +1,70 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestBench(t *testing.T) {
+	defer func(version int, key []byte) {
+		leveldb.EncryptionVersion, leveldb.EncryptionKey = version, key
+	}(leveldb.EncryptionVersion, leveldb.EncryptionKey)
+
+	db := filepath.Join(t.TempDir(), "db")
+	var found string
+	for _, cipher := range []string{"none", "xor", "aes"} {
+		var stdout, stderr bytes.Buffer
+		b := &bench{stdout: &stdout, stderr: &stderr}
+		err := b.main([]string{"-db", db, "-num", "2000", "-threads", "2", "-cipher", cipher, "-json"})
+		if err != nil {
+			t.Fatalf("%s: %v\n%s", cipher, err, stderr.String())
+		}
+		var names []string
+		dec := json.NewDecoder(&stdout)
+		for dec.More() {
+			var res result
+			if err := dec.Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Cipher != cipher || res.Ops != 2000 || res.MicrosPerOp <= 0 || res.Max < res.P50 {
+				t.Errorf("%s: bad result %+v", cipher, res)
+			}
+			names = append(names, res.Name)
+			// The keys written by fillrandom are the same whatever the
+			// cipher.
+			if res.Name == "readrandom" {
+				if found != "" && res.Note != found {
+					t.Errorf("%s: readrandom: got %s, want %s", cipher, res.Note, found)
+				}
+				found = res.Note
+			}
+		}
+		if got := strings.Join(names, ","); got != "fillseq,fillrandom,readrandom,readwhilewriting,seekrandom" {
+			t.Fatalf("%s: got benchmarks %s", cipher, got)
+		}
+	}
+
+	var stdout bytes.Buffer
+	b := &bench{stdout: &stdout, stderr: new(bytes.Buffer)}
+	if err := b.main([]string{"-db", db, "-num", "100", "-benchmarks", "fillseq,readrandom"}); err != nil {
+		t.Fatal(err)
+	}
+	out := stdout.String()
+	if !strings.Contains(out, "Entries:     100\n") || !strings.Contains(out, "readrandom       : ") || !strings.Contains(out, "(100 of 100 found)") {
+		t.Fatalf("got report:\n%s", out)
+	}
+	if err := b.main([]string{"-benchmarks", "nope"}); err == nil {
+		t.Fatal("unknown benchmark accepted")
+	}
+}
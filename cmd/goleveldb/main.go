@@ -0,0 +1,753 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Command goleveldb inspects and modifies a LevelDB database, including
+// encrypted ones.
+//
+// Usage:
+//
+//	goleveldb -db path [-key hex | -keyring file] [-cipher aes|xor] [-hex] command [args]
+//	goleveldb [-key hex | -keyring file] [-cipher aes|xor] sst [-blocks] [-entries] file
+//	goleveldb [-key hex] [-cipher aes|xor] [-hex] journal|manifest file
+//
+// The commands are:
+//
+//	get key                  print the value of key
+//	put key value            set the value of key, creating the DB if missing
+//	delete key               delete key
+//	scan [flags]             print the entries of a range, see goleveldb scan -h
+//	dump                     print all entries, Go-quoted, one per line
+//	export [flags]           write the entries of a range as JSON lines or
+//	                         CSV, base64-encoded, or as a RocksDB SST file,
+//	                         see goleveldb export -h
+//	import [-format f] file  put the entries of a file written by export, or
+//	                         of a RocksDB SST file, creating the DB if missing
+//	compact [flags]          compact a range, or the whole DB
+//	replay [-speed x] file   replay a workload trace recorded by
+//	                         DB.StartTrace, creating the DB if missing
+//	check                    check the consistency of the DB, without
+//	                         modifying it, and suggest fixes
+//	repair                   recover a DB with a missing or corrupted manifest
+//	stats                    print the compaction stats and the LSM tree shape
+//	sst [flags] file         print the footer, index, filters and properties
+//	                         of a table file, see goleveldb sst -h
+//	journal file             print the write batches of a journal file
+//	manifest file            print the version edits of a MANIFEST file
+//
+// With -keyring, the file holds one hex-encoded key per line, blank lines
+// and lines starting with '#' being ignored; the keys are tried in order
+// until one opens the DB or table. Repair can't tell a wrong key from a
+// corrupted table, which it would drop, nor check, journal and manifest
+// from a corrupted file, so they require a single key.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	lerrors "github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/filter"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/table"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// cli holds the global flags.
+type cli struct {
+	dbPath  string
+	key     string
+	keyring string
+	cipher  string
+	hex     bool
+
+	stdout io.Writer
+	stderr io.Writer
+}
+
+type command struct {
+	name  string
+	args  string
+	write bool // Whether the command modifies the DB.
+	run   func(c *cli, db *leveldb.DB, args []string) error
+	// runKeys is set instead of run by the commands which don't open the
+	// DB through c.open.
+	runKeys func(c *cli, keys [][]byte, args []string) error
+}
+
+var commands = []command{
+	{"get", "key", false, (*cli).get, nil},
+	{"put", "key value", true, (*cli).put, nil},
+	{"delete", "key", true, (*cli).delete, nil},
+	{"scan", "[-start key] [-limit key] [-prefix key] [-n count] [-keys]", false, (*cli).scan, nil},
+	{"dump", "", false, (*cli).dump, nil},
+	{"export", "[-format jsonl|csv|rocksdb] [-start key] [-limit key]", false, (*cli).export, nil},
+	{"import", "[-format jsonl|csv|rocksdb] file", true, (*cli).importFile, nil},
+	{"compact", "[-start key] [-limit key]", true, (*cli).compact, nil},
+	{"replay", "[-speed x] file", true, (*cli).replay, nil},
+	{"check", "", false, nil, (*cli).check},
+	{"repair", "", true, nil, (*cli).repair},
+	{"stats", "", false, (*cli).stats, nil},
+	{"sst", "[-blocks] [-entries] file", false, nil, (*cli).sst},
+	{"journal", "file", false, nil, (*cli).journal},
+	{"manifest", "file", false, nil, (*cli).manifest},
+}
+
+// Commands reading a single file, which don't need -db.
+var fileCommands = map[string]bool{"sst": true, "journal": true, "manifest": true}
+
+var errUsage = errors.New("usage")
+
+func main() {
+	c := &cli{stdout: os.Stdout, stderr: os.Stderr}
+	if err := c.main(os.Args[1:]); err != nil {
+		if err != errUsage {
+			fmt.Fprintln(os.Stderr, "goleveldb:", err)
+		}
+		os.Exit(2)
+	}
+}
+
+func (c *cli) usage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprintf(c.stderr, "usage: goleveldb -db path [flags] command [args]\n\nflags:\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(c.stderr, "\ncommands:\n")
+		for _, cmd := range commands {
+			fmt.Fprintf(c.stderr, "  %s %s\n", cmd.name, cmd.args)
+		}
+	}
+}
+
+func (c *cli) main(args []string) error {
+	fs := flag.NewFlagSet("goleveldb", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Usage = c.usage(fs)
+	fs.StringVar(&c.dbPath, "db", "", "database path")
+	fs.StringVar(&c.key, "key", "", "hex-encoded encryption key")
+	fs.StringVar(&c.keyring, "keyring", "", "file of hex-encoded encryption keys, one per line, tried in order")
+	fs.StringVar(&c.cipher, "cipher", "aes", "encryption cipher, aes or xor")
+	fs.BoolVar(&c.hex, "hex", false, "keys and values are hex-encoded, on input and output")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() == 0 || (c.dbPath == "" && !fileCommands[fs.Arg(0)]) {
+		fs.Usage()
+		return errUsage
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == fs.Arg(0) {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		return fmt.Errorf("unknown command %q", fs.Arg(0))
+	}
+
+	keys, err := c.keys()
+	if err != nil {
+		return err
+	}
+	if cmd.runKeys != nil {
+		return cmd.runKeys(c, keys, fs.Args()[1:])
+	}
+	db, err := c.open(keys, &opt.Options{
+		ErrorIfMissing: cmd.name != "put" && cmd.name != "import" && cmd.name != "replay",
+		ReadOnly:       !cmd.write,
+	})
+	if err != nil {
+		return err
+	}
+	if err := cmd.run(c, db, fs.Args()[1:]); err != nil {
+		db.Close()
+		return err
+	}
+	return db.Close()
+}
+
+// Returns the encryption keys to try, or a nil key for no encryption.
+func (c *cli) keys() ([][]byte, error) {
+	switch c.cipher {
+	case "aes":
+		leveldb.EncryptionVersion = 2
+	case "xor":
+		leveldb.EncryptionVersion = 1
+	default:
+		return nil, fmt.Errorf("unknown cipher %q", c.cipher)
+	}
+	if c.key != "" && c.keyring != "" {
+		return nil, errors.New("-key and -keyring are exclusive")
+	}
+	if c.key != "" {
+		key, err := hex.DecodeString(c.key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -key: %v", err)
+		}
+		return [][]byte{key}, nil
+	}
+	if c.keyring == "" {
+		leveldb.EncryptionVersion = 0
+		return [][]byte{nil}, nil
+	}
+
+	f, err := os.Open(c.keyring)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var keys [][]byte
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, err := hex.DecodeString(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid key: %v", c.keyring, line, err)
+		}
+		keys = append(keys, key)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no keys", c.keyring)
+	}
+	return keys, nil
+}
+
+// Opens the DB with the first key which doesn't fail with corruption.
+func (c *cli) open(keys [][]byte, o *opt.Options) (db *leveldb.DB, err error) {
+	for _, key := range keys {
+		leveldb.EncryptionKey = key
+		db, err = leveldb.OpenFile(c.dbPath, o)
+		if err == nil || !lerrors.IsCorrupted(err) {
+			break
+		}
+	}
+	return
+}
+
+// Decodes a key or value given on the command line.
+func (c *cli) decode(s string) ([]byte, error) {
+	if !c.hex {
+		return []byte(s), nil
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex %q: %v", s, err)
+	}
+	return b, nil
+}
+
+func (c *cli) encode(b []byte) string {
+	if c.hex {
+		return hex.EncodeToString(b)
+	}
+	return string(b)
+}
+
+func (c *cli) get(db *leveldb.DB, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: get key")
+	}
+	key, err := c.decode(args[0])
+	if err != nil {
+		return err
+	}
+	value, err := db.Get(key, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.stdout, c.encode(value))
+	return nil
+}
+
+func (c *cli) put(db *leveldb.DB, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: put key value")
+	}
+	key, err := c.decode(args[0])
+	if err != nil {
+		return err
+	}
+	value, err := c.decode(args[1])
+	if err != nil {
+		return err
+	}
+	return db.Put(key, value, &opt.WriteOptions{Sync: true})
+}
+
+func (c *cli) delete(db *leveldb.DB, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: delete key")
+	}
+	key, err := c.decode(args[0])
+	if err != nil {
+		return err
+	}
+	return db.Delete(key, &opt.WriteOptions{Sync: true})
+}
+
+// Parses the -start and -limit flags of a command into a range.
+func (c *cli) rangeFlags(fs *flag.FlagSet, args []string) (r util.Range, err error) {
+	var start, limit string
+	fs.SetOutput(c.stderr)
+	fs.StringVar(&start, "start", "", "first key of the range, inclusive")
+	fs.StringVar(&limit, "limit", "", "limit of the range, exclusive")
+	if err = fs.Parse(args); err != nil {
+		return
+	}
+	if fs.NArg() > 0 {
+		err = fmt.Errorf("unexpected argument %q", fs.Arg(0))
+		return
+	}
+	if start != "" {
+		if r.Start, err = c.decode(start); err != nil {
+			return
+		}
+	}
+	if limit != "" {
+		r.Limit, err = c.decode(limit)
+	}
+	return
+}
+
+func (c *cli) scan(db *leveldb.DB, args []string) error {
+	fs := flag.NewFlagSet("scan", flag.ContinueOnError)
+	prefix := fs.String("prefix", "", "only keys with the given prefix")
+	n := fs.Int("n", 0, "print at most n entries, if positive")
+	keysOnly := fs.Bool("keys", false, "print keys only")
+	r, err := c.rangeFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if *prefix != "" {
+		p, err := c.decode(*prefix)
+		if err != nil {
+			return err
+		}
+		pr := util.BytesPrefix(p)
+		if bytes.Compare(pr.Start, r.Start) > 0 {
+			r.Start = pr.Start
+		}
+		if r.Limit == nil || (pr.Limit != nil && bytes.Compare(pr.Limit, r.Limit) < 0) {
+			r.Limit = pr.Limit
+		}
+	}
+
+	iter := db.NewIterator(&r, nil)
+	defer iter.Release()
+	w := bufio.NewWriter(c.stdout)
+	for count := 0; (*n <= 0 || count < *n) && iter.Next(); count++ {
+		if *keysOnly {
+			fmt.Fprintln(w, c.encode(iter.Key()))
+		} else {
+			fmt.Fprintf(w, "%s => %s\n", c.encode(iter.Key()), c.encode(iter.Value()))
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func (c *cli) dump(db *leveldb.DB, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: dump")
+	}
+	iter := db.NewIterator(nil, nil)
+	defer iter.Release()
+	w := bufio.NewWriter(c.stdout)
+	for iter.Next() {
+		fmt.Fprintf(w, "%q %q\n", iter.Key(), iter.Value())
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func (c *cli) export(db *leveldb.DB, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	name := fs.String("format", "jsonl", "output format, jsonl, csv or rocksdb")
+	r, err := c.rangeFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if *name == "rocksdb" {
+		_, err := db.ExportRocksDB(c.stdout, &r)
+		return err
+	}
+	format, err := leveldb.ParseExportFormat(*name)
+	if err != nil {
+		return err
+	}
+	_, err = db.Export(c.stdout, format, &r)
+	return err
+}
+
+func (c *cli) importFile(db *leveldb.DB, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	name := fs.String("format", "jsonl", "input format, jsonl, csv or rocksdb")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: import [-format jsonl|csv|rocksdb] file")
+	}
+	var format leveldb.ExportFormat
+	if *name != "rocksdb" {
+		var err error
+		if format, err = leveldb.ParseExportFormat(*name); err != nil {
+			return err
+		}
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var n int
+	if *name == "rocksdb" {
+		var fi os.FileInfo
+		if fi, err = f.Stat(); err != nil {
+			return err
+		}
+		n, err = db.IngestRocksDB(f, fi.Size())
+	} else {
+		n, err = db.Import(f, format)
+	}
+	fmt.Fprintf(c.stdout, "imported %d entries\n", n)
+	return err
+}
+
+func (c *cli) compact(db *leveldb.DB, args []string) error {
+	r, err := c.rangeFlags(flag.NewFlagSet("compact", flag.ContinueOnError), args)
+	if err != nil {
+		return err
+	}
+	return db.CompactRange(r)
+}
+
+func (c *cli) replay(db *leveldb.DB, args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	speed := fs.Float64("speed", 1, "pace of the replay relative to the trace, 0 for as fast as possible")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: replay [-speed x] file")
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := db.Replay(f, &leveldb.ReplayOptions{Speed: *speed})
+	if st != nil {
+		fmt.Fprintf(c.stdout, "gets %d (%d not found), writes %d, iterator ops %d, compactions %d\n",
+			st.Gets, st.NotFound, st.Writes, st.IterOps, st.Compactions)
+		fmt.Fprintf(c.stdout, "duration %v, latency %v\n", st.Duration.Round(time.Millisecond), st.Latency.Round(time.Millisecond))
+	}
+	return err
+}
+
+func (c *cli) check(keys [][]byte, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: check")
+	}
+	if len(keys) > 1 {
+		return errors.New("check requires a single key, use -key")
+	}
+	leveldb.EncryptionKey = keys[0]
+	r, err := leveldb.CheckFile(c.dbPath, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(c.stdout, r)
+	if !r.OK() {
+		return errors.New("check found errors")
+	}
+	return nil
+}
+
+func (c *cli) repair(keys [][]byte, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: repair")
+	}
+	if len(keys) > 1 {
+		return errors.New("repair requires a single key, use -key")
+	}
+	leveldb.EncryptionKey = keys[0]
+	db, err := leveldb.RecoverFile(c.dbPath, nil)
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+func (c *cli) stats(db *leveldb.DB, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: stats")
+	}
+	for _, name := range []string{"leveldb.stats", "leveldb.lsm-shape"} {
+		value, err := db.GetProperty(name)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(c.stdout, strings.TrimRight(value, "\n"))
+	}
+	return nil
+}
+
+func (c *cli) sst(keys [][]byte, args []string) error {
+	fs := flag.NewFlagSet("sst", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	blocks := fs.Bool("blocks", false, "print every data block")
+	entries := fs.Bool("entries", false, "print every entry")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: sst [-blocks] [-entries] file")
+	}
+	path := fs.Arg(0)
+
+	// Try the keys until one reads the table without corruption.
+	var (
+		tr     *table.Reader
+		layout *table.Layout
+		err    error
+	)
+	for _, key := range keys {
+		leveldb.EncryptionKey = key
+		if tr != nil {
+			tr.Release()
+		}
+		if tr, err = leveldb.OpenTableFile(path, &opt.Options{Filter: filter.NewBloomFilter(10)}); err != nil {
+			return err
+		}
+		if layout, err = tr.Inspect(); err == nil || !lerrors.IsCorrupted(err) {
+			break
+		}
+	}
+	defer tr.Release()
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(c.stdout)
+	fmt.Fprintf(w, "footer:\n  checksum: %s\n  metaindex: %s\n  index: %s\n",
+		layout.ChecksumType, formatHandle(layout.MetaIndex), formatHandle(layout.Index))
+
+	fmt.Fprintf(w, "metaindex:\n")
+	names := make([]string, 0, len(layout.MetaEntries))
+	for name := range layout.MetaEntries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s: %s\n", name, formatHandle(layout.MetaEntries[name]))
+	}
+
+	compression := make(map[string]int)
+	var dataSize uint64
+	for _, b := range layout.DataBlocks {
+		compression[b.Compression]++
+		dataSize += b.Length
+	}
+	fmt.Fprintf(w, "index:\n  data blocks: %d, %d bytes\n", len(layout.DataBlocks), dataSize)
+	if len(layout.Partitions) > 0 {
+		fmt.Fprintf(w, "  partitions: %d\n", len(layout.Partitions))
+	}
+	kinds := make([]string, 0, len(compression))
+	for kind := range compression {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Fprintf(w, "  compression %s: %d blocks\n", kind, compression[kind])
+	}
+	if *blocks {
+		for i, b := range layout.DataBlocks {
+			fmt.Fprintf(w, "  block %d: %s %s key %s\n", i, formatHandle(b.BlockHandle), b.Compression, c.formatTableKey(b.Key))
+		}
+	}
+
+	fmt.Fprintf(w, "filters:\n")
+	if len(layout.Filters) == 0 {
+		fmt.Fprintf(w, "  none\n")
+	}
+	for _, f := range layout.Filters {
+		fmt.Fprintf(w, "  %s: %s, %d blocks, %d bytes\n", f.Name, f.Kind, f.Blocks, f.Size)
+	}
+
+	fmt.Fprintf(w, "properties:\n")
+	if p := layout.Properties; p == nil {
+		fmt.Fprintf(w, "  none\n")
+	} else {
+		fmt.Fprintf(w, "  entries: %d\n  data blocks: %d\n  raw key size: %d\n  raw value size: %d\n  data size: %d\n",
+			p.NumEntries, p.NumDataBlocks, p.RawKeySize, p.RawValueSize, p.DataSize)
+		fmt.Fprintf(w, "  smallest key: %s\n  largest key: %s\n", c.formatTableKey(p.SmallestKey), c.formatTableKey(p.LargestKey))
+		if p.CreationTime > 0 {
+			fmt.Fprintf(w, "  creation time: %s\n", time.Unix(p.CreationTime, 0).UTC().Format(time.RFC3339))
+		}
+		user := make([]string, 0, len(p.User))
+		for name := range p.User {
+			user = append(user, name)
+		}
+		sort.Strings(user)
+		for _, name := range user {
+			fmt.Fprintf(w, "  %s: %q\n", name, p.User[name])
+		}
+	}
+
+	if *entries {
+		fmt.Fprintf(w, "entries:\n")
+		iter := tr.NewIterator(nil, nil)
+		for iter.Next() {
+			if _, _, deleted, _ := leveldb.ParseTableKey(iter.Key()); deleted {
+				fmt.Fprintf(w, "  %s\n", c.formatTableKey(iter.Key()))
+			} else {
+				fmt.Fprintf(w, "  %s => %s\n", c.formatTableKey(iter.Key()), c.formatBytes(iter.Value()))
+			}
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			w.Flush()
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+func (c *cli) journal(keys [][]byte, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: journal file")
+	}
+	if len(keys) > 1 {
+		return errors.New("journal requires a single key, use -key")
+	}
+	leveldb.EncryptionKey = keys[0]
+
+	w := bufio.NewWriter(c.stdout)
+	err := leveldb.ReadJournalFile(args[0], func(b *leveldb.JournalBatch) error {
+		if b.Err != nil {
+			fmt.Fprintf(w, "corrupted: %v\n", b.Err)
+			return nil
+		}
+		compressed := ""
+		if b.Compressed {
+			compressed = ", compressed"
+		}
+		fmt.Fprintf(w, "batch seq %d, %d records, %d bytes%s\n", b.Seq, len(b.Records), b.Size, compressed)
+		for _, rec := range b.Records {
+			if rec.Deleted {
+				fmt.Fprintf(w, "  del %s @%d\n", c.formatBytes(rec.Key), rec.Seq)
+			} else {
+				fmt.Fprintf(w, "  put %s @%d, value %d bytes\n", c.formatBytes(rec.Key), rec.Seq, rec.ValueLen)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		w.Flush()
+		return err
+	}
+	return w.Flush()
+}
+
+func (c *cli) manifest(keys [][]byte, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: manifest file")
+	}
+	if len(keys) > 1 {
+		return errors.New("manifest requires a single key, use -key")
+	}
+	leveldb.EncryptionKey = keys[0]
+
+	w := bufio.NewWriter(c.stdout)
+	var n int
+	err := leveldb.ReadManifestFile(args[0], func(e *leveldb.VersionEdit) error {
+		if e.Err != nil {
+			fmt.Fprintf(w, "corrupted: %v\n", e.Err)
+			return nil
+		}
+		fmt.Fprintf(w, "edit %d:\n", n)
+		n++
+		if e.Comparer != "" {
+			fmt.Fprintf(w, "  comparer: %s\n", e.Comparer)
+		}
+		if e.JournalNum != nil {
+			fmt.Fprintf(w, "  journal num: %d\n", *e.JournalNum)
+		}
+		if e.PrevJournalNum != nil {
+			fmt.Fprintf(w, "  prev journal num: %d\n", *e.PrevJournalNum)
+		}
+		if e.NextFileNum != nil {
+			fmt.Fprintf(w, "  next file num: %d\n", *e.NextFileNum)
+		}
+		if e.SeqNum != nil {
+			fmt.Fprintf(w, "  seq num: %d\n", *e.SeqNum)
+		}
+		for _, cp := range e.CompactionPointers {
+			fmt.Fprintf(w, "  compaction pointer L%d: %s\n", cp.Level, c.formatTableKey(cp.Key))
+		}
+		for _, t := range e.DeletedTables {
+			fmt.Fprintf(w, "  del table L%d@%d\n", t.Level, t.Num)
+		}
+		for _, t := range e.AddedTables {
+			fmt.Fprintf(w, "  add table L%d@%d, %d bytes, %s .. %s\n", t.Level, t.Num, t.Size, c.formatTableKey(t.Smallest), c.formatTableKey(t.Largest))
+		}
+		return nil
+	})
+	if err != nil {
+		w.Flush()
+		return err
+	}
+	return w.Flush()
+}
+
+func formatHandle(bh table.BlockHandle) string {
+	return fmt.Sprintf("offset %d, length %d", bh.Offset, bh.Length)
+}
+
+// Formats a key or value of a table, quoted unless -hex is set.
+func (c *cli) formatBytes(b []byte) string {
+	if c.hex {
+		return hex.EncodeToString(b)
+	}
+	return strconv.Quote(string(b))
+}
+
+// Formats an internal key of a table, with its sequence number and kind.
+func (c *cli) formatTableKey(key []byte) string {
+	ukey, seq, deleted, err := leveldb.ParseTableKey(key)
+	if err != nil {
+		return fmt.Sprintf("%s (%v)", c.formatBytes(key), err)
+	}
+	kind := "put"
+	if deleted {
+		kind = "del"
+	}
+	return fmt.Sprintf("%s @%d %s", c.formatBytes(ukey), seq, kind)
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestCLI(t *testing.T) {
+	defer func(version int, key []byte) {
+		leveldb.EncryptionVersion, leveldb.EncryptionKey = version, key
+	}(leveldb.EncryptionVersion, leveldb.EncryptionKey)
+
+	dir := t.TempDir()
+	db := filepath.Join(dir, "db")
+	keyring := filepath.Join(dir, "keyring")
+	if err := os.WriteFile(keyring, []byte("# old key\n00112233445566778899aabbccddeeff\n\n0123456789abcdef0123456789abcdef\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(args ...string) (string, error) {
+		var stdout, stderr bytes.Buffer
+		c := &cli{stdout: &stdout, stderr: &stderr}
+		err := c.main(append([]string{"-db", db}, args...))
+		return stdout.String(), err
+	}
+	mustRun := func(args ...string) string {
+		out, err := run(args...)
+		if err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		return out
+	}
+
+	key := []string{"-key", "0123456789abcdef0123456789abcdef"}
+	if _, err := run(append(key, "get", "a")...); err == nil {
+		t.Fatal("get on a missing DB succeeded")
+	}
+	mustRun(append(key, "put", "a", "1")...)
+	mustRun(append(key, "put", "b", "2")...)
+	mustRun(append(key, "put", "ba", "3")...)
+	mustRun(append(key, "put", "c", "4")...)
+	mustRun(append(key, "delete", "c")...)
+
+	journals, _ := filepath.Glob(filepath.Join(db, "*.log"))
+	if len(journals) != 1 {
+		t.Fatalf("got journals %v", journals)
+	}
+	if out, err := (&cli{}).runWithoutDB(append(key, "journal", journals[0])...); err != nil || out != "batch seq 9, 1 records, 15 bytes\n  del \"c\" @9\n" {
+		t.Errorf("journal: got %q, %v", out, err)
+	}
+
+	manifests, _ := filepath.Glob(filepath.Join(db, "MANIFEST-*"))
+	if len(manifests) != 1 {
+		t.Fatalf("got manifests %v", manifests)
+	}
+	out, err := (&cli{}).runWithoutDB(append(key, "manifest", manifests[0])...)
+	if err != nil {
+		t.Fatal("manifest: ", err)
+	}
+	for _, want := range []string{
+		"edit 0:\n  comparer: leveldb.BytewiseComparator\n",
+		"  seq num: ",
+		" bytes, \"a\" @1 put .. \"a\" @1 put\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("manifest: missing %q in:\n%s", want, out)
+		}
+	}
+
+	if _, err := run("get", "a"); err == nil {
+		t.Fatal("get without the key succeeded")
+	}
+	if out := mustRun("-keyring", keyring, "get", "a"); out != "1\n" {
+		t.Errorf("get: got %q", out)
+	}
+	if out := mustRun(append(key, "scan")...); out != "a => 1\nb => 2\nba => 3\n" {
+		t.Errorf("scan: got %q", out)
+	}
+	if out := mustRun(append(key, "scan", "-prefix", "b", "-keys")...); out != "b\nba\n" {
+		t.Errorf("scan -prefix: got %q", out)
+	}
+	if out := mustRun(append(key, "-hex", "scan", "-start", "62", "-n", "1")...); out != "62 => 32\n" {
+		t.Errorf("scan -hex: got %q", out)
+	}
+	if out := mustRun(append(key, "dump")...); out != "\"a\" \"1\"\n\"b\" \"2\"\n\"ba\" \"3\"\n" {
+		t.Errorf("dump: got %q", out)
+	}
+	csvOut := mustRun(append(key, "export", "-format", "csv", "-start", "b")...)
+	if csvOut != "key,value\nYg==,Mg==\nYmE=,Mw==\n" {
+		t.Errorf("export: got %q", csvOut)
+	}
+	exported := filepath.Join(dir, "export.csv")
+	if err := os.WriteFile(exported, []byte(csvOut), 0600); err != nil {
+		t.Fatal(err)
+	}
+	copyDB := []string{"-db", filepath.Join(dir, "copy")}
+	if out, err := (&cli{}).runWithoutDB(append(copyDB, "import", "-format", "csv", exported)...); err != nil || out != "imported 2 entries\n" {
+		t.Errorf("import: got %q, %v", out, err)
+	}
+	if out, err := (&cli{}).runWithoutDB(append(copyDB, "export")...); err != nil || out != "{\"key\":\"Yg==\",\"value\":\"Mg==\"}\n{\"key\":\"YmE=\",\"value\":\"Mw==\"}\n" {
+		t.Errorf("export of imported DB: got %q, %v", out, err)
+	}
+	sst := filepath.Join(dir, "export.sst")
+	if err := os.WriteFile(sst, []byte(mustRun(append(key, "export", "-format", "rocksdb", "-limit", "b")...)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := (&cli{}).runWithoutDB(append(copyDB, "import", "-format", "rocksdb", sst)...); err != nil || out != "imported 1 entries\n" {
+		t.Errorf("import of RocksDB SST: got %q, %v", out, err)
+	}
+	if out, err := (&cli{}).runWithoutDB(append(copyDB, "get", "a")...); err != nil || out != "1\n" {
+		t.Errorf("get after import of RocksDB SST: got %q, %v", out, err)
+	}
+
+	mustRun(append(key, "compact")...)
+	if out := mustRun(append(key, "stats")...); !strings.Contains(out, "Compactions") || !strings.Contains(out, " Level | Tables |") {
+		t.Errorf("stats: got %q", out)
+	}
+	// The deletion of c and the other entries were flushed apart.
+	tables, _ := filepath.Glob(filepath.Join(db, "*.ldb"))
+	out = ""
+	for _, table := range tables {
+		o, err := (&cli{}).runWithoutDB("-keyring", keyring, "sst", "-blocks", "-entries", table)
+		if err != nil {
+			t.Fatal("sst: ", err)
+		}
+		out += o
+	}
+	for _, want := range []string{
+		"footer:\n  checksum: crc32c\n",
+		"filters:\n  none\n",
+		"properties:\n  entries: 3\n",
+		"  smallest key: \"a\" @1 put\n",
+		"  block 0: offset 0, length ",
+		"entries:\n  \"a\" @1 put => \"1\"\n  \"b\" @3 put => \"2\"\n  \"ba\" @5 put => \"3\"\n",
+		"entries:\n  \"c\" @9 del\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("sst: missing %q in:\n%s", want, out)
+		}
+	}
+	if _, err := (&cli{}).runWithoutDB("sst", tables[0]); err == nil {
+		t.Error("sst without the key succeeded")
+	}
+
+	// Warnings, e.g. about compacted tables left for the next open, are fine.
+	if out := mustRun(append(key, "check")...); !strings.HasPrefix(out, "manifest: MANIFEST-") {
+		t.Errorf("check: got %q", out)
+	}
+	if _, err := run("check"); err == nil {
+		t.Error("check without the key succeeded")
+	}
+
+	if _, err := run("-keyring", keyring, "repair"); err == nil {
+		t.Error("repair with a keyring succeeded")
+	}
+	mustRun(append(key, "repair")...)
+	if out := mustRun(append(key, "get", "ba")...); out != "3\n" {
+		t.Errorf("get after repair: got %q", out)
+	}
+
+	trace := filepath.Join(dir, "trace")
+	if err := writeTrace(trace); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := (&cli{}).runWithoutDB(append(copyDB, "replay", "-speed", "0", trace)...); err != nil || !strings.HasPrefix(out, "gets 1 (0 not found), writes 1, iterator ops 0, compactions 0\n") {
+		t.Errorf("replay: got %q, %v", out, err)
+	}
+}
+
+// Writes a trace of a put and a get of a memory-backed DB.
+func writeTrace(name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := db.StartTrace(f, nil); err != nil {
+		return err
+	}
+	db.Put([]byte("k"), []byte("v"), nil)
+	db.Get([]byte("k"), nil)
+	if err := db.EndTrace(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Runs a command with the given arguments only, e.g. sst, or a command on
+// another DB.
+func (c *cli) runWithoutDB(args ...string) (string, error) {
+	var stdout bytes.Buffer
+	c.stdout, c.stderr = &stdout, &stdout
+	err := c.main(args)
+	return stdout.String(), err
+}
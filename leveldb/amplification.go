@@ -0,0 +1,158 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Amplification statistics are recorded into time slots, which are summed
+// over the requested window; the last ampSlots slots are kept.
+const (
+	ampSlotDuration = 10 * time.Second
+	ampSlots        = 360
+	ampWindowMax    = ampSlots * ampSlotDuration
+
+	// Levels at or beyond ampLevels are accounted to the last one.
+	ampLevels = 16
+)
+
+type ampSlot struct {
+	epoch     int64 // Slot number since the Unix epoch.
+	gets      int64
+	ingested  int64
+	lookups   [ampLevels]int64
+	compacted [ampLevels]int64
+}
+
+type ampStats struct {
+	mu    sync.Mutex // Held when recycling a slot.
+	slots [ampSlots]ampSlot
+}
+
+func ampLevel(level int) int {
+	if level >= ampLevels {
+		return ampLevels - 1
+	}
+	return level
+}
+
+// Returns the slot of the given time, recycling it if it holds older stats.
+func (a *ampStats) slot(t time.Time) *ampSlot {
+	epoch := t.UnixNano() / int64(ampSlotDuration)
+	s := &a.slots[epoch%ampSlots]
+	if atomic.LoadInt64(&s.epoch) != epoch {
+		a.mu.Lock()
+		if atomic.LoadInt64(&s.epoch) != epoch {
+			atomic.StoreInt64(&s.gets, 0)
+			atomic.StoreInt64(&s.ingested, 0)
+			for i := range s.lookups {
+				atomic.StoreInt64(&s.lookups[i], 0)
+				atomic.StoreInt64(&s.compacted[i], 0)
+			}
+			atomic.StoreInt64(&s.epoch, epoch)
+		}
+		a.mu.Unlock()
+	}
+	return s
+}
+
+// Records a get which looked up the given tables.
+func (a *ampStats) addGet(t time.Time, tables []tSet) {
+	s := a.slot(t)
+	atomic.AddInt64(&s.gets, 1)
+	for _, ts := range tables {
+		if ts.level >= 0 {
+			atomic.AddInt64(&s.lookups[ampLevel(ts.level)], 1)
+		}
+	}
+}
+
+func (a *ampStats) addIngested(n int) {
+	atomic.AddInt64(&a.slot(time.Now()).ingested, int64(n))
+}
+
+func (a *ampStats) addCompacted(level int, n int64) {
+	atomic.AddInt64(&a.slot(time.Now()).compacted[ampLevel(level)], n)
+}
+
+func (a *ampStats) stats(now time.Time, window time.Duration) (s AmplificationStats) {
+	if window <= 0 || window > ampWindowMax {
+		window = ampWindowMax
+	}
+	n := int64((window + ampSlotDuration - 1) / ampSlotDuration)
+	s.Window = time.Duration(n) * ampSlotDuration
+
+	var lookups, compacted [ampLevels]int64
+	maxLevel := -1
+	last := now.UnixNano() / int64(ampSlotDuration)
+	for epoch := last - n + 1; epoch <= last; epoch++ {
+		slot := &a.slots[epoch%ampSlots]
+		if atomic.LoadInt64(&slot.epoch) != epoch {
+			continue
+		}
+		s.Gets += atomic.LoadInt64(&slot.gets)
+		s.IngestedBytes += atomic.LoadInt64(&slot.ingested)
+		for i := range lookups {
+			lookups[i] += atomic.LoadInt64(&slot.lookups[i])
+			compacted[i] += atomic.LoadInt64(&slot.compacted[i])
+			if (lookups[i] != 0 || compacted[i] != 0) && i > maxLevel {
+				maxLevel = i
+			}
+		}
+	}
+
+	s.LevelReadAmplification = make([]float64, maxLevel+1)
+	s.LevelWriteAmplification = make([]float64, maxLevel+1)
+	for level := 0; level <= maxLevel; level++ {
+		if s.Gets > 0 {
+			s.LevelReadAmplification[level] = float64(lookups[level]) / float64(s.Gets)
+			s.ReadAmplification += s.LevelReadAmplification[level]
+		}
+		if s.IngestedBytes > 0 {
+			s.LevelWriteAmplification[level] = float64(compacted[level]) / float64(s.IngestedBytes)
+			s.WriteAmplification += s.LevelWriteAmplification[level]
+		}
+	}
+	return
+}
+
+// AmplificationStats holds the read and write amplification of a DB over a
+// window of time.
+type AmplificationStats struct {
+	// Window is the covered window, the requested one rounded up to 10
+	// seconds.
+	Window time.Duration
+
+	// Gets is the number of Get and Has calls, and IngestedBytes the size
+	// of the written entries, keys and values plus 8 bytes each.
+	Gets          int64
+	IngestedBytes int64
+
+	// ReadAmplification is the average number of tables looked up per Get,
+	// and LevelReadAmplification the same by level.
+	ReadAmplification      float64
+	LevelReadAmplification []float64
+
+	// WriteAmplification is the number of bytes written to tables by
+	// flushes and compactions per byte ingested, and
+	// LevelWriteAmplification the same by destination level.
+	WriteAmplification      float64
+	LevelWriteAmplification []float64
+}
+
+// Amplification returns the read and write amplification over the given
+// window of time, at most one hour; a non-positive window means one hour.
+// It returns zero statistics unless the AmplificationStats option is set.
+func (db *DB) Amplification(window time.Duration) AmplificationStats {
+	if db.amp == nil {
+		return AmplificationStats{}
+	}
+	return db.amp.stats(time.Now(), window)
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAmpStatsWindow(t *testing.T) {
+	a := &ampStats{}
+	now := time.Unix(1000000, 0)
+	l0, l2 := tSet{level: 0}, tSet{level: 2}
+
+	// An old get, then a recent one, in the same slot as a get over an hour
+	// old which it recycles.
+	a.addGet(now.Add(-5*time.Minute), []tSet{l0, l0, l2})
+	a.addGet(now.Add(-ampWindowMax), []tSet{l0})
+	a.addGet(now, []tSet{l2, {level: -1}})
+
+	s := a.stats(now, time.Minute)
+	if s.Window != time.Minute || s.Gets != 1 || s.ReadAmplification != 1 {
+		t.Errorf("1m window: got %+v", s)
+	}
+	if len(s.LevelReadAmplification) != 3 || s.LevelReadAmplification[2] != 1 {
+		t.Errorf("1m window: got levels %v", s.LevelReadAmplification)
+	}
+
+	s = a.stats(now, 0)
+	if s.Window != ampWindowMax || s.Gets != 2 || s.ReadAmplification != 2 {
+		t.Errorf("1h window: got %+v", s)
+	}
+	if want := []float64{1, 0, 1}; len(s.LevelReadAmplification) != 3 ||
+		s.LevelReadAmplification[0] != want[0] || s.LevelReadAmplification[2] != want[2] {
+		t.Errorf("1h window: got levels %v, want %v", s.LevelReadAmplification, want)
+	}
+
+	s = a.stats(now.Add(2*ampWindowMax), 0)
+	if s.Gets != 0 || len(s.LevelReadAmplification) != 0 {
+		t.Errorf("expired: got %+v", s)
+	}
+}
@@ -11,8 +11,12 @@ import (
 	"fmt"
 	"io"
 
+	"github.com/golang/snappy"
+
 	"github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/journal"
 	"github.com/syndtr/goleveldb/leveldb/memdb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/storage"
 )
 
@@ -217,10 +221,26 @@ func (b *Batch) decode(data []byte, expectedLen int) error {
 	return nil
 }
 
-func (b *Batch) putMem(seq uint64, mdb *memdb.DB) error {
+func (b *Batch) putMem(seq uint64, mdb memdb.Memtable) error {
+	var ik []byte
+	for i, index := range b.index {
+		ik = makeInternalKey(ik, index.k(b.data), seq+uint64(i), index.keyType)
+		if err := mdb.Put(ik, index.v(b.data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putMemShard is as putMem, but only puts the records of the given shard of
+// a sharded memdb.
+func (b *Batch) putMemShard(seq uint64, mdb *memdb.DB, shard int) error {
 	var ik []byte
 	for i, index := range b.index {
 		ik = makeInternalKey(ik, index.k(b.data), seq+uint64(i), index.keyType)
+		if mdb.ShardOf(ik) != shard {
+			continue
+		}
 		if err := mdb.Put(ik, index.v(b.data)); err != nil {
 			return err
 		}
@@ -315,7 +335,7 @@ func decodeBatch(data []byte, fn func(i int, index batchIndex) error) error {
 	return nil
 }
 
-func decodeBatchToMem(data []byte, expectSeq uint64, mdb *memdb.DB) (seq uint64, batchLen int, err error) {
+func decodeBatchToMem(data []byte, expectSeq uint64, mdb memdb.Memtable) (seq uint64, batchLen int, err error) {
 	seq, batchLen, err = decodeBatchHeader(data)
 	if err != nil {
 		return 0, 0, err
@@ -371,14 +391,116 @@ func batchesLen(batches []*Batch) int {
 	return batchLen
 }
 
-func writeBatchesWithHeader(wr io.Writer, batches []*Batch, seq uint64) error {
-	if _, err := wr.Write(encodeBatchHeader(nil, seq, batchesLen(batches))); err != nil {
+func batchesInternalLen(batches []*Batch) int {
+	internalLen := 0
+	for _, batch := range batches {
+		internalLen += batch.internalLen
+	}
+	return internalLen
+}
+
+// writeBuffers writes bufs to wr in a single call if wr is a
+// journal.BuffersWriter.
+func writeBuffers(wr io.Writer, bufs [][]byte) error {
+	if bw, ok := wr.(journal.BuffersWriter); ok {
+		_, err := bw.WriteBuffers(bufs)
 		return err
 	}
-	for _, batch := range batches {
-		if _, err := wr.Write(batch.data); err != nil {
+	for _, b := range bufs {
+		if _, err := wr.Write(b); err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+// A compressed journal record keeps the batch header, with the compression
+// type stored in the top byte of the sequence number, followed by the
+// compressed batch records. The top byte is always zero for uncompressed
+// records since sequence numbers are limited to 56 bits.
+const (
+	batchNoCompression     = 0
+	batchSnappyCompression = 1
+)
+
+// journalCodec writes and reads possibly compressed journal records. It
+// reuses its buffers and so isn't safe for concurrent use.
+type journalCodec struct {
+	raw, enc []byte
+	header   [batchHeaderLen]byte
+	bufs     [][]byte
+}
+
+// writeBatches writes the batches as a single journal record with the
+// given sequence number. The header is encoded in place and the batches
+// are written as gathered slices, so no copy is made unless the record is
+// compressed.
+func (c *journalCodec) writeBatches(wr io.Writer, batches []*Batch, seq uint64, compression opt.Compression) error {
+	header := encodeBatchHeader(c.header[:], seq, batchesLen(batches))
+	bufs := append(c.bufs[:0], header)
+	if compression == opt.SnappyCompression {
+		if enc := c.compress(batches); enc != nil {
+			header[7] = batchSnappyCompression
+			bufs = append(bufs, enc)
+		}
+	}
+	if len(bufs) == 1 {
+		for _, batch := range batches {
+			bufs = append(bufs, batch.data)
+		}
+	}
+	err := writeBuffers(wr, bufs)
+	for i := range bufs {
+		bufs[i] = nil
+	}
+	c.bufs = bufs[:0]
+	return err
+}
+
+// compress returns the snappy encoded batch records, or nil if compression
+// doesn't shrink them.
+func (c *journalCodec) compress(batches []*Batch) []byte {
+	var raw []byte
+	if len(batches) == 1 {
+		raw = batches[0].data
+	} else {
+		raw = c.raw[:0]
+		for _, batch := range batches {
+			raw = append(raw, batch.data...)
+		}
+		c.raw = raw
+	}
+	if n := snappy.MaxEncodedLen(len(raw)); cap(c.enc) < n {
+		c.enc = make([]byte, n)
+	}
+	enc := snappy.Encode(c.enc[:cap(c.enc)], raw)
+	if len(enc) >= len(raw) {
+		return nil
+	}
+	return enc
+}
+
+// decode returns the uncompressed batch data of the given journal record.
+// The returned slice is only valid until the next decode call.
+func (c *journalCodec) decode(data []byte) ([]byte, error) {
+	if len(data) < batchHeaderLen || data[7] == batchNoCompression {
+		return data, nil
+	}
+	if data[7] != batchSnappyCompression {
+		return nil, newErrBatchCorrupted(fmt.Sprintf("unknown compression type %#x", data[7]))
+	}
+	decLen, err := snappy.DecodedLen(data[batchHeaderLen:])
+	if err != nil {
+		return nil, newErrBatchCorrupted("invalid compressed records")
+	}
+	if n := batchHeaderLen + decLen; cap(c.raw) < n {
+		c.raw = make([]byte, n)
+	}
+	raw := c.raw[:batchHeaderLen+decLen]
+	copy(raw, data[:batchHeaderLen])
+	raw[7] = batchNoCompression
+	if _, err := snappy.Decode(raw[batchHeaderLen:], data[batchHeaderLen:]); err != nil {
+		return nil, newErrBatchCorrupted("invalid compressed records")
+	}
+	return raw, nil
+}
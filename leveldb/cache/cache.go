@@ -35,6 +35,29 @@ type Cacher interface {
 	Evict(n *Node)
 }
 
+// CacherStats holds the statistics of a Cacher.
+type CacherStats struct {
+	Capacity int
+	// Used is the size of the 'cache node' held by the cacher, and Pinned
+	// the size of those also in use outside of the cache, which therefore
+	// aren't freed by evicting them.
+	Used   int
+	Pinned int
+	// InsertCount is the number of 'cache node' admitted by the cacher,
+	// and EvictCount the number of those evicted to make room for others.
+	InsertCount int64
+	EvictCount  int64
+}
+
+// StatsCacher is a Cacher which reports its statistics. The cachers
+// returned by NewLRU, NewStrictLRU and NewS3FIFO implement it.
+type StatsCacher interface {
+	Cacher
+
+	// Stats returns the cacher statistics.
+	Stats() CacherStats
+}
+
 // Value is a 'cache-able object'. It may implements util.Releaser, if
 // so the the Release method will be called once object is released.
 type Value interface{}
@@ -357,6 +380,11 @@ type Stats struct {
 	MissCount   int64
 	SetCount    int64
 	DelCount    int64
+
+	// EvictCount and PinnedSize are taken from the cacher, and are zero
+	// unless it implements StatsCacher.
+	EvictCount int64
+	PinnedSize int64
 }
 
 // Cache is a 'cache map'.
@@ -423,7 +451,7 @@ func (r *Cache) delete(n *Node) bool {
 
 // GetStats returns cache statistics.
 func (r *Cache) GetStats() Stats {
-	return Stats{
+	s := Stats{
 		Buckets:     len((*mHead)(atomic.LoadPointer(&r.mHead)).buckets),
 		Nodes:       atomic.LoadInt64(&r.statNodes),
 		Size:        atomic.LoadInt64(&r.statSize),
@@ -434,6 +462,12 @@ func (r *Cache) GetStats() Stats {
 		SetCount:    atomic.LoadInt64(&r.statSet),
 		DelCount:    atomic.LoadInt64(&r.statDel),
 	}
+	if cs, ok := r.cacher.(StatsCacher); ok {
+		stats := cs.Stats()
+		s.EvictCount = stats.EvictCount
+		s.PinnedSize = int64(stats.Pinned)
+	}
+	return s
 }
 
 // Nodes returns number of 'cache node' in the map.
@@ -610,6 +644,32 @@ func (r *Cache) EvictNS(ns uint64) {
 	}
 }
 
+// EnumerateKeys calls f with the namespace and key of each 'cache node'
+// in the map. The 'cache node' may be deleted or evicted by the time f is
+// called, in which case Get will return nil.
+func (r *Cache) EnumerateKeys(f func(ns, key uint64)) {
+	type nsKey struct{ ns, key uint64 }
+	var keys []nsKey
+	r.mu.RLock()
+	if !r.closed {
+		h := (*mHead)(atomic.LoadPointer(&r.mHead))
+		for x := range h.buckets {
+			b := h.initBucket(uint32(x))
+
+			b.mu.Lock()
+			for _, n := range b.nodes {
+				keys = append(keys, nsKey{n.ns, n.key})
+			}
+			b.mu.Unlock()
+		}
+	}
+	r.mu.RUnlock()
+
+	for _, k := range keys {
+		f(k.ns, k.key)
+	}
+}
+
 func (r *Cache) evictAll() {
 	r.enumerateNodesWithCB(func(nodes []*Node) {
 		for _, n := range nodes {
@@ -669,6 +729,12 @@ func (r *Cache) Close(force bool) {
 	}
 }
 
+// NodeOverhead is the approximate memory used to keep a single 'cache node'
+// in a Cache with the LRU cacher, excluding its value. Callers wanting the
+// capacity to account for bookkeeping should add it to the size returned
+// by setFunc.
+const NodeOverhead = int(unsafe.Sizeof(Node{}) + unsafe.Sizeof(Handle{}) + unsafe.Sizeof(lruNode{}) + unsafe.Sizeof(uintptr(0)))
+
 // Node is a 'cache node'.
 type Node struct {
 	r *Cache
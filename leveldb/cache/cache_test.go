@@ -259,6 +259,25 @@ func TestCacheMap_NodesAndSize(t *testing.T) {
 	require.Equal(t, 7, c.Size())
 }
 
+func TestCacheMap_EnumerateKeys(t *testing.T) {
+	c := NewCache(NewLRU(10))
+	set(c, 0, 1, 1, 1, nil).Release()
+	set(c, 0, 2, 2, 1, nil).Release()
+	set(c, 1, 1, 3, 1, nil).Release()
+	c.Delete(0, 2, nil)
+
+	keys := make(map[[2]uint64]bool)
+	c.EnumerateKeys(func(ns, key uint64) {
+		keys[[2]uint64{ns, key}] = true
+	})
+	require.Equal(t, map[[2]uint64]bool{{0, 1}: true, {1, 1}: true}, keys)
+
+	c.Close(false)
+	c.EnumerateKeys(func(ns, key uint64) {
+		t.Errorf("enumerated key %d/%d of closed cache", ns, key)
+	})
+}
+
 func TestLRUCache_Capacity(t *testing.T) {
 	c := NewCache(NewLRU(10))
 	require.Equal(t, 10, c.Capacity())
@@ -278,6 +297,136 @@ func TestLRUCache_Capacity(t *testing.T) {
 	require.Equal(t, 8, c.Size())
 }
 
+func TestS3FIFOCache_Capacity(t *testing.T) {
+	c := NewCache(NewS3FIFO(10))
+	require.Equal(t, 10, c.Capacity())
+	for i := 0; i < 20; i++ {
+		set(c, 0, uint64(i), i, 1, nil).Release()
+	}
+	require.Equal(t, 10, c.Nodes())
+	require.Equal(t, 10, c.Size())
+	c.SetCapacity(5)
+	require.Equal(t, 5, c.Capacity())
+	require.Equal(t, 5, c.Nodes())
+	require.Equal(t, 5, c.Size())
+}
+
+func TestS3FIFOCache_ScanResistance(t *testing.T) {
+	c := NewCache(NewS3FIFO(100))
+	for i := 0; i < 2; i++ {
+		for key := uint64(0); key < 50; key++ {
+			set(c, 0, key, key, 1, nil).Release()
+		}
+	}
+
+	// Scan through many keys, each read only once.
+	for key := uint64(1000); key < 2000; key++ {
+		set(c, 0, key, key, 1, nil).Release()
+	}
+	require.Equal(t, 100, c.Size())
+	for key := uint64(0); key < 50; key++ {
+		h := c.Get(0, key, nil)
+		require.NotNil(t, h, "hot key %d was evicted", key)
+		h.Release()
+	}
+
+	// Same workload evicts the hot keys from LRU.
+	c = NewCache(NewLRU(100))
+	for i := 0; i < 2; i++ {
+		for key := uint64(0); key < 50; key++ {
+			set(c, 0, key, key, 1, nil).Release()
+		}
+	}
+	for key := uint64(1000); key < 2000; key++ {
+		set(c, 0, key, key, 1, nil).Release()
+	}
+	require.Nil(t, c.Get(0, 0, nil))
+}
+
+func TestS3FIFOCache_Evict(t *testing.T) {
+	c := NewCache(NewS3FIFO(10))
+	set(c, 0, 1, 1, 1, nil).Release()
+	set(c, 0, 2, 2, 1, nil).Release()
+	h := c.Get(0, 2, nil)
+	require.NotNil(t, h)
+	h.Release()
+
+	require.True(t, c.Evict(0, 1))
+	require.Nil(t, c.Get(0, 1, nil))
+	c.EvictAll()
+	require.Zero(t, c.Nodes())
+	require.Zero(t, c.Size())
+
+	// Deleted nodes are banned.
+	h = set(c, 0, 3, 3, 1, nil)
+	require.True(t, c.Delete(0, 3, nil))
+	h.Release()
+	require.Nil(t, c.Get(0, 3, nil))
+}
+
+func TestCacherStats(t *testing.T) {
+	for name, cacher := range map[string]Cacher{"lru": NewLRU(10), "s3fifo": NewS3FIFO(10)} {
+		t.Run(name, func(t *testing.T) {
+			c := NewCache(cacher)
+			h := set(c, 0, 0, 0, 2, nil)
+			for i := 1; i <= 5; i++ {
+				set(c, 0, uint64(i), i, 1, nil).Release()
+			}
+			c.Get(0, 1, nil).Release()
+			cs := cacher.(StatsCacher).Stats()
+			require.Equal(t, CacherStats{Capacity: 10, Used: 7, Pinned: 2, InsertCount: 6}, cs)
+
+			// The oldest node is evicted, although still in use.
+			for i := 6; i <= 10; i++ {
+				set(c, 0, uint64(i), i, 1, nil).Release()
+			}
+			cs = cacher.(StatsCacher).Stats()
+			require.Equal(t, CacherStats{Capacity: 10, Used: 10, InsertCount: 11, EvictCount: 1}, cs)
+			require.Equal(t, 11, c.Nodes())
+
+			s := c.GetStats()
+			require.Equal(t, int64(11), s.SetCount)
+			require.Equal(t, int64(11), s.MissCount)
+			require.Equal(t, int64(1), s.HitCount)
+			require.Equal(t, int64(1), s.EvictCount)
+			require.Zero(t, s.PinnedSize)
+			h.Release()
+			require.Equal(t, 10, c.Nodes())
+		})
+	}
+}
+
+func TestStrictLRUCache_Capacity(t *testing.T) {
+	c := NewCache(NewStrictLRU(10))
+	h1 := set(c, 0, 1, 1, 4, nil)
+	h2 := set(c, 0, 2, 2, 4, nil)
+	set(c, 0, 3, 3, 2, nil).Release()
+	require.Equal(t, 10, c.Size())
+
+	// Only the unused node may be evicted, which is not enough room.
+	h4 := set(c, 0, 4, 4, 3, nil)
+	require.Equal(t, 13, c.Size())
+	h4.Release()
+	require.Equal(t, 10, c.Size())
+	h3 := c.Get(0, 3, nil)
+	require.NotNil(t, h3)
+	h3.Release()
+
+	// Evicts the unused node.
+	h1.Release()
+	set(c, 0, 5, 5, 6, nil).Release()
+	require.Equal(t, 10, c.Size())
+	require.Nil(t, c.Get(0, 1, nil))
+	require.Nil(t, c.Get(0, 3, nil))
+
+	// Shrinking never evicts nodes in use.
+	c.SetCapacity(2)
+	require.Equal(t, 4, c.Size())
+	h2.Release()
+	c.SetCapacity(2)
+	require.Zero(t, c.Size())
+}
+
 func TestCacheMap_NilValue(t *testing.T) {
 	c := NewCache(NewLRU(10))
 	h := c.Get(0, 0, func() (size int, value Value) {
@@ -43,6 +43,10 @@ type lru struct {
 	capacity int
 	used     int
 	recent   lruNode
+	strict   bool
+
+	insertCount int64
+	evictCount  int64
 }
 
 func (r *lru) reset() {
@@ -51,18 +55,66 @@ func (r *lru) reset() {
 	r.used = 0
 }
 
+func (r *lru) Stats() CacherStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := CacherStats{
+		Capacity:    r.capacity,
+		Used:        r.used,
+		InsertCount: r.insertCount,
+		EvictCount:  r.evictCount,
+	}
+	for rn := r.recent.next; rn != &r.recent; rn = rn.next {
+		if rn.n.Ref() > 1 {
+			s.Pinned += rn.n.Size()
+		}
+	}
+	return s
+}
+
 func (r *lru) Capacity() int {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	return r.capacity
 }
 
+// Whether there is room for n more bytes after evicting unused nodes; only
+// used in strict mode.
+func (r *lru) canFit(n int) bool {
+	used := r.used
+	for rn := r.recent.prev; rn != &r.recent && used+n > r.capacity; rn = rn.prev {
+		if rn.n.Ref() <= 1 {
+			used -= rn.n.Size()
+		}
+	}
+	return used+n <= r.capacity
+}
+
+// Evict unused nodes, oldest first, until there is room for n more bytes
+// or no more unused nodes; only used in strict mode.
+func (r *lru) evictUnused(n int, evicted []*lruNode) []*lruNode {
+	for rn := r.recent.prev; rn != &r.recent && r.used+n > r.capacity; {
+		prev := rn.prev
+		if rn.n.Ref() <= 1 {
+			rn.remove()
+			rn.n.CacheData = nil
+			r.used -= rn.n.Size()
+			evicted = append(evicted, rn)
+		}
+		rn = prev
+	}
+	return evicted
+}
+
 func (r *lru) SetCapacity(capacity int) {
 	var evicted []*lruNode
 
 	r.mu.Lock()
 	r.capacity = capacity
-	for r.used > r.capacity {
+	if r.strict {
+		evicted = r.evictUnused(0, nil)
+	}
+	for !r.strict && r.used > r.capacity {
 		rn := r.recent.prev
 		if rn == nil {
 			panic("BUG: invalid LRU used or capacity counter")
@@ -72,6 +124,7 @@ func (r *lru) SetCapacity(capacity int) {
 		r.used -= rn.n.Size()
 		evicted = append(evicted, rn)
 	}
+	r.evictCount += int64(len(evicted))
 	r.mu.Unlock()
 
 	for _, rn := range evicted {
@@ -84,11 +137,18 @@ func (r *lru) Promote(n *Node) {
 
 	r.mu.Lock()
 	if n.CacheData == nil {
-		if n.Size() <= r.capacity {
+		fit := n.Size() <= r.capacity
+		if fit && r.strict {
+			if fit = r.canFit(n.Size()); fit {
+				evicted = r.evictUnused(n.Size(), evicted)
+			}
+		}
+		if fit {
 			rn := &lruNode{n: n, h: n.GetHandle()}
 			rn.insert(&r.recent)
 			n.CacheData = unsafe.Pointer(rn)
 			r.used += n.Size()
+			r.insertCount++
 
 			for r.used > r.capacity {
 				rn := r.recent.prev
@@ -108,6 +168,7 @@ func (r *lru) Promote(n *Node) {
 			rn.insert(&r.recent)
 		}
 	}
+	r.evictCount += int64(len(evicted))
 	r.mu.Unlock()
 
 	for _, rn := range evicted {
@@ -156,3 +217,13 @@ func NewLRU(capacity int) Cacher {
 	r.reset()
 	return r
 }
+
+// NewStrictLRU create a new LRU-cache which never exceeds its capacity.
+// Unlike NewLRU, a 'cache node' still in use is never evicted; if there is
+// no room for a new 'cache node' after evicting unused ones, the new node
+// is not cached and will be deleted once released.
+func NewStrictLRU(capacity int) Cacher {
+	r := &lru{capacity: capacity, strict: true}
+	r.reset()
+	return r
+}
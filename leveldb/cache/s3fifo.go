@@ -0,0 +1,282 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package cache
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// Maximum access frequency tracked per 'cache node'.
+const s3fifoMaxFreq = 3
+
+type s3fifoNode struct {
+	n    *Node
+	h    *Handle
+	ban  bool
+	main bool
+	freq uint8
+
+	next, prev *s3fifoNode
+}
+
+func (n *s3fifoNode) insert(at *s3fifoNode) {
+	x := at.next
+	at.next = n
+	n.prev = at
+	n.next = x
+	x.prev = n
+}
+
+func (n *s3fifoNode) remove() {
+	if n.prev != nil {
+		n.prev.next = n.next
+		n.next.prev = n.prev
+		n.prev = nil
+		n.next = nil
+	} else {
+		panic("BUG: removing removed node")
+	}
+}
+
+type ghostKey struct {
+	ns, key uint64
+}
+
+type ghostNode struct {
+	k    ghostKey
+	size int
+
+	next, prev *ghostNode
+}
+
+type s3fifo struct {
+	mu        sync.Mutex
+	capacity  int
+	used      int
+	smallUsed int
+	small     s3fifoNode
+	main      s3fifoNode
+
+	ghostUsed int
+	ghost     ghostNode
+	ghostMap  map[ghostKey]*ghostNode
+
+	insertCount int64
+	evictCount  int64
+}
+
+func (r *s3fifo) reset() {
+	r.small.next = &r.small
+	r.small.prev = &r.small
+	r.main.next = &r.main
+	r.main.prev = &r.main
+	r.ghost.next = &r.ghost
+	r.ghost.prev = &r.ghost
+	r.ghostMap = make(map[ghostKey]*ghostNode)
+	r.used = 0
+	r.smallUsed = 0
+	r.ghostUsed = 0
+}
+
+func (r *s3fifo) smallCapacity() int {
+	return r.capacity / 10
+}
+
+func (r *s3fifo) ghostAdd(n *Node) {
+	k := ghostKey{n.NS(), n.Key()}
+	if _, ok := r.ghostMap[k]; ok {
+		return
+	}
+	gn := &ghostNode{k: k, size: n.Size()}
+	x := r.ghost.next
+	r.ghost.next = gn
+	gn.prev = &r.ghost
+	gn.next = x
+	x.prev = gn
+	r.ghostMap[k] = gn
+	r.ghostUsed += gn.size
+
+	// The ghost queue remembers about as many bytes as the main queue holds.
+	for r.ghostUsed > r.capacity-r.smallCapacity() && r.ghost.prev != &r.ghost {
+		r.ghostRemove(r.ghost.prev)
+	}
+}
+
+func (r *s3fifo) ghostRemove(gn *ghostNode) {
+	gn.prev.next = gn.next
+	gn.next.prev = gn.prev
+	delete(r.ghostMap, gn.k)
+	r.ghostUsed -= gn.size
+}
+
+func (r *s3fifo) unlink(rn *s3fifoNode) {
+	rn.remove()
+	rn.n.CacheData = nil
+	r.used -= rn.n.Size()
+	if !rn.main {
+		r.smallUsed -= rn.n.Size()
+	}
+}
+
+func (r *s3fifo) evict(evicted []*s3fifoNode) []*s3fifoNode {
+	for r.used > r.capacity {
+		if r.smallUsed > r.smallCapacity() || r.main.prev == &r.main {
+			rn := r.small.prev
+			if rn == &r.small {
+				panic("BUG: invalid S3-FIFO used or capacity counter")
+			}
+			if rn.freq > 0 {
+				// Accessed again while in the small queue, move to main.
+				rn.remove()
+				r.smallUsed -= rn.n.Size()
+				rn.main = true
+				rn.freq = 0
+				rn.insert(&r.main)
+				continue
+			}
+			r.unlink(rn)
+			r.ghostAdd(rn.n)
+			evicted = append(evicted, rn)
+		} else {
+			rn := r.main.prev
+			rn.remove()
+			if rn.freq > 0 {
+				rn.freq--
+				rn.insert(&r.main)
+				continue
+			}
+			rn.n.CacheData = nil
+			r.used -= rn.n.Size()
+			evicted = append(evicted, rn)
+		}
+		r.evictCount++
+	}
+	return evicted
+}
+
+func (r *s3fifo) Stats() CacherStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := CacherStats{
+		Capacity:    r.capacity,
+		Used:        r.used,
+		InsertCount: r.insertCount,
+		EvictCount:  r.evictCount,
+	}
+	for _, q := range []*s3fifoNode{&r.small, &r.main} {
+		for rn := q.next; rn != q; rn = rn.next {
+			if rn.n.Ref() > 1 {
+				s.Pinned += rn.n.Size()
+			}
+		}
+	}
+	return s
+}
+
+func (r *s3fifo) Capacity() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.capacity
+}
+
+func (r *s3fifo) SetCapacity(capacity int) {
+	r.mu.Lock()
+	r.capacity = capacity
+	evicted := r.evict(nil)
+	for r.ghostUsed > r.capacity-r.smallCapacity() && r.ghost.prev != &r.ghost {
+		r.ghostRemove(r.ghost.prev)
+	}
+	r.mu.Unlock()
+
+	for _, rn := range evicted {
+		rn.h.Release()
+	}
+}
+
+func (r *s3fifo) Promote(n *Node) {
+	var evicted []*s3fifoNode
+
+	r.mu.Lock()
+	if n.CacheData == nil {
+		if n.Size() <= r.capacity {
+			rn := &s3fifoNode{n: n, h: n.GetHandle()}
+			k := ghostKey{n.NS(), n.Key()}
+			if gn, ok := r.ghostMap[k]; ok {
+				// Recently evicted from the small queue, goes to main.
+				r.ghostRemove(gn)
+				rn.main = true
+				rn.insert(&r.main)
+			} else {
+				rn.insert(&r.small)
+				r.smallUsed += n.Size()
+			}
+			n.CacheData = unsafe.Pointer(rn)
+			r.used += n.Size()
+			r.insertCount++
+			evicted = r.evict(evicted)
+		}
+	} else {
+		rn := (*s3fifoNode)(n.CacheData)
+		if !rn.ban && rn.freq < s3fifoMaxFreq {
+			rn.freq++
+		}
+	}
+	r.mu.Unlock()
+
+	for _, rn := range evicted {
+		rn.h.Release()
+	}
+}
+
+func (r *s3fifo) Ban(n *Node) {
+	r.mu.Lock()
+	if n.CacheData == nil {
+		n.CacheData = unsafe.Pointer(&s3fifoNode{n: n, ban: true})
+	} else {
+		rn := (*s3fifoNode)(n.CacheData)
+		if !rn.ban {
+			rn.remove()
+			rn.ban = true
+			r.used -= n.Size()
+			if !rn.main {
+				r.smallUsed -= n.Size()
+			}
+			r.mu.Unlock()
+
+			rn.h.Release()
+			rn.h = nil
+			return
+		}
+	}
+	r.mu.Unlock()
+}
+
+func (r *s3fifo) Evict(n *Node) {
+	r.mu.Lock()
+	rn := (*s3fifoNode)(n.CacheData)
+	if rn == nil || rn.ban {
+		r.mu.Unlock()
+		return
+	}
+	r.unlink(rn)
+	r.mu.Unlock()
+
+	rn.h.Release()
+}
+
+// NewS3FIFO creates a new S3-FIFO cache, a scan-resistant alternative to
+// NewLRU. New 'cache node' enter a small FIFO queue holding a tenth of the
+// capacity, and only those accessed again before leaving it are moved to
+// the main queue; others are evicted, but remembered for a while so that
+// they go straight to the main queue if inserted again. This keeps a
+// one-off scan from evicting the frequently accessed nodes.
+func NewS3FIFO(capacity int) Cacher {
+	r := &s3fifo{capacity: capacity}
+	r.reset()
+	return r
+}
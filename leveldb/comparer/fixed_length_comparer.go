@@ -0,0 +1,116 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package comparer
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+type fixedLengthComparer struct {
+	n int
+}
+
+// NewFixedLengthComparer returns a comparer for keys which are all n bytes
+// long, such as big-endian encoded integers or hashes. It orders keys the
+// same way as DefaultComparer and shares its name, so an existing database
+// may switch between the two, but uses specialized code paths for 8 and 16
+// bytes keys and produces shorter separators for index blocks.
+//
+// Keys of other lengths, such as the separators themselves, are still
+// ordered correctly, only without the fast path.
+func NewFixedLengthComparer(n int) Comparer {
+	if n <= 0 {
+		panic("leveldb/comparer: invalid fixed key length")
+	}
+	switch n {
+	case 8:
+		return fixed8Comparer{fixedLengthComparer{n}}
+	case 16:
+		return fixed16Comparer{fixedLengthComparer{n}}
+	}
+	return fixedLengthComparer{n}
+}
+
+func (fixedLengthComparer) Compare(a, b []byte) int {
+	return bytes.Compare(a, b)
+}
+
+func (fixedLengthComparer) Name() string {
+	return DefaultComparer.Name()
+}
+
+// Separator shortens a like the bytewise separator does, but doesn't give up
+// when the first differing bytes are adjacent, since a longer prefix of a
+// may still be incremented. With fixed length keys this is the common case
+// for neighbouring keys.
+func (fixedLengthComparer) Separator(dst, a, b []byte) []byte {
+	i, n := 0, len(a)
+	if n > len(b) {
+		n = len(b)
+	}
+	for ; i < n && a[i] == b[i]; i++ {
+	}
+	if i >= n || a[i] >= b[i] {
+		// Do not shorten if one string is a prefix of the other.
+		return nil
+	}
+	if a[i]+1 < b[i] {
+		dst = append(dst, a[:i+1]...)
+		dst[len(dst)-1]++
+		return dst
+	}
+	// The prefix a[:i+1] is less than b whatever follows it, so increment
+	// the first byte after it that can be, if the result is shorter than a.
+	for j := i + 1; j < len(a)-1; j++ {
+		if a[j] != 0xff {
+			dst = append(dst, a[:j+1]...)
+			dst[len(dst)-1]++
+			return dst
+		}
+	}
+	return nil
+}
+
+func (fixedLengthComparer) Successor(dst, b []byte) []byte {
+	return DefaultComparer.Successor(dst, b)
+}
+
+type fixed8Comparer struct {
+	fixedLengthComparer
+}
+
+func (fixed8Comparer) Compare(a, b []byte) int {
+	if len(a) != 8 || len(b) != 8 {
+		return bytes.Compare(a, b)
+	}
+	return compareUint64(binary.BigEndian.Uint64(a), binary.BigEndian.Uint64(b))
+}
+
+type fixed16Comparer struct {
+	fixedLengthComparer
+}
+
+func (fixed16Comparer) Compare(a, b []byte) int {
+	if len(a) != 16 || len(b) != 16 {
+		return bytes.Compare(a, b)
+	}
+	if r := compareUint64(binary.BigEndian.Uint64(a), binary.BigEndian.Uint64(b)); r != 0 {
+		return r
+	}
+	return compareUint64(binary.BigEndian.Uint64(a[8:]), binary.BigEndian.Uint64(b[8:]))
+}
+
+func compareUint64(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
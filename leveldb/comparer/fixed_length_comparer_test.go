@@ -0,0 +1,66 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package comparer
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestFixedLengthComparer(t *testing.T) {
+	rnd := rand.New(rand.NewSource(0))
+	randKey := func(n int) []byte {
+		b := make([]byte, n)
+		rnd.Read(b)
+		// Make adjacent and 0xff bytes common.
+		for i := range b {
+			switch rnd.Intn(4) {
+			case 0:
+				b[i] = 0xff
+			case 1:
+				b[i] &= 0x03
+			}
+		}
+		return b
+	}
+
+	for _, n := range []int{4, 8, 16} {
+		cmp := NewFixedLengthComparer(n)
+		if cmp.Name() != DefaultComparer.Name() {
+			t.Fatalf("n=%d: got name %q", n, cmp.Name())
+		}
+		for i := 0; i < 10000; i++ {
+			a, b := randKey(n), randKey(n)
+			if i%2 == 0 {
+				// Other lengths, as separators are.
+				b = b[:rnd.Intn(n+1)]
+			}
+			if got, want := cmp.Compare(a, b), bytes.Compare(a, b); got != want {
+				t.Fatalf("n=%d: Compare(%x, %x) = %d, want %d", n, a, b, got, want)
+			}
+			if bytes.Compare(a, b) > 0 {
+				a, b = b, a
+			}
+			if x := cmp.Separator(nil, a, b); x != nil {
+				if len(x) > len(a) || cmp.Compare(a, x) >= 0 || cmp.Compare(x, b) >= 0 {
+					t.Fatalf("n=%d: Separator(%x, %x) = %x", n, a, b, x)
+				}
+			}
+		}
+	}
+
+	cmp := NewFixedLengthComparer(8)
+	a := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	b := []byte{1, 3, 0, 0, 0, 0, 0, 0}
+	if x := cmp.Separator(nil, a, b); !bytes.Equal(x, []byte{1, 2, 4}) {
+		t.Fatalf("Separator(%x, %x) = %x", a, b, x)
+	}
+	if x := DefaultComparer.Separator(nil, a, b); x != nil {
+		t.Fatalf("bytewise Separator(%x, %x) = %x", a, b, x)
+	}
+}
@@ -11,12 +11,17 @@ import (
 	"fmt"
 	"io"
 	"math/rand"
+	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/filter"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/testutil"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 const ctValSize = 1000
@@ -248,6 +253,32 @@ func TestCorruptDB_Table(t *testing.T) {
 	h.check(99, 99)
 }
 
+func TestCorruptDB_TableSkipBlockChecksum(t *testing.T) {
+	h := newDbCorruptHarnessWopt(t, &opt.Options{
+		DisableBlockCache:           true,
+		Strict:                      opt.StrictJournalChecksum | opt.StrictBlockChecksum,
+		CompactionOnlyBlockChecksum: true,
+	})
+	defer h.close()
+
+	h.build(100)
+	h.compactMem()
+	h.closeDB()
+	h.corrupt(storage.TypeTable, -1, 100, 1)
+	h.openDB()
+
+	// Only the corrupted value is bad, as the checksum isn't verified.
+	h.check(99, 99)
+
+	// The corrupted block is dropped.
+	h.db.SetVerifyBlockChecksum(true)
+	h.check(90, 98)
+
+	// Unless verification is skipped per read.
+	h.ro = &opt.ReadOptions{SkipBlockChecksum: true}
+	h.check(99, 99)
+}
+
 func TestCorruptDB_TableIndex(t *testing.T) {
 	h := newDbCorruptHarness(t)
 	defer h.close()
@@ -371,6 +402,47 @@ func TestCorruptDB_CompactionInputError(t *testing.T) {
 	h.check(10000, 10000)
 }
 
+func TestCorruptDB_CompactionConcurrencyInputError(t *testing.T) {
+	listener := &compactionCountListener{}
+	h := newDbCorruptHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		DisableSeeksCompaction:       true,
+		EventListener:                listener,
+	})
+	defer h.close()
+
+	buildCompactionRanges(&h.dbHarness, 50)
+	h.closeDB()
+	// The last table is at level-1.
+	h.corrupt(storage.TypeTable, -1, 100, 1)
+
+	h.o.CompactionTotalSize = 1
+	h.o.CompactionConcurrency = 2
+	h.openDB()
+
+	// The compactions stop rather than retry the corrupted table.
+	begun := int32(0)
+	for start := time.Now(); time.Since(start) < 5*time.Second; {
+		time.Sleep(100 * time.Millisecond)
+		n := atomic.LoadInt32(&listener.begun)
+		if n > 0 && n == begun {
+			break
+		}
+		begun = n
+	}
+	time.Sleep(200 * time.Millisecond)
+	if n := atomic.LoadInt32(&listener.begun); n != begun {
+		t.Errorf("got %d compactions begun after the corruption", n-begun)
+	}
+	if err := h.db.CompactRange(util.Range{}); !errors.IsCorrupted(err) {
+		t.Errorf("CompactRange: got error %v, want corruption", err)
+	}
+	if err := h.closeDB0(); err != nil && !errors.IsCorrupted(err) {
+		t.Error("Close: got error: ", err)
+	}
+	h.db = nil
+}
+
 func TestCorruptDB_UnrelatedKeys(t *testing.T) {
 	h := newDbCorruptHarness(t)
 	defer h.close()
@@ -484,3 +556,53 @@ func TestCorruptDB_RecoverTable(t *testing.T) {
 	}
 	h.check(985, 985)
 }
+
+func TestCorruptDB_ErrorDetails(t *testing.T) {
+	h := newDbCorruptHarnessWopt(t, &opt.Options{
+		DisableBlockCache: true,
+		Strict:            opt.StrictJournalChecksum | opt.StrictBlockChecksum,
+	})
+	defer h.close()
+
+	h.build(100)
+	h.compactMem()
+	h.closeDB()
+	h.corrupt(storage.TypeTable, -1, 100, 1)
+	h.openDB()
+
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		_, err = h.db.Get(tkey(i), nil)
+	}
+	var cerr *errors.ErrCorrupted
+	if !errors.As(err, &cerr) {
+		t.Fatalf("got %v, want corruption", err)
+	}
+	if cerr.Fd.Type != storage.TypeTable || cerr.Offset < 0 || cerr.Offset > 100 {
+		t.Errorf("got file %v offset %d", cerr.Fd, cerr.Offset)
+	}
+
+	h.closeDB()
+	fds, _ := h.stor.List(storage.TypeTable)
+	h.stor.EmulateError(testutil.ModeOpen, storage.TypeTable, os.ErrPermission)
+	h.openDB()
+	_, err = h.db.Get(tkey(0), nil)
+	var oerr *errors.OpError
+	if !errors.As(err, &oerr) || oerr.Op != "open" || oerr.Fd != fds[0] || !errors.Is(err, os.ErrPermission) {
+		t.Errorf("got %v, want open error", err)
+	}
+	h.stor.EmulateError(testutil.ModeOpen, storage.TypeTable, nil)
+
+	h.closeDB()
+	if _, err := h.db.Get(tkey(0), nil); !errors.Is(err, ErrClosed) {
+		t.Errorf("got %v, want closed", err)
+	}
+
+	if err := h.stor.Remove(fds[0]); err != nil {
+		t.Fatal(err)
+	}
+	var merr *errors.ErrMissingFiles
+	if err := h.openDB0(); !errors.IsCorrupted(err) || !errors.As(err, &merr) || len(merr.Fds) != 1 {
+		t.Errorf("got %v, want missing files", err)
+	}
+}
@@ -0,0 +1,354 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package crashtest checks the crash consistency of a DB. Run drives a
+// random workload against a Storage simulating power loss, crashes it at
+// random points, reopens the DB and verifies that:
+//
+//   - no write acknowledged as synced is lost;
+//   - the DB holds the writes of a prefix of the batches, so that no batch
+//     is partially visible, and no write is visible without the previous
+//     ones.
+//
+// Encryption is configured by Config, and set through the EncryptionVersion
+// and EncryptionKey variables of leveldb while running, so Run must not be
+// called concurrently with other code opening a DB.
+package crashtest
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Config holds the parameters of Run. The zero value is a valid config.
+type Config struct {
+	// Seed of the workload and of the crashes. The runs of a config are
+	// the same but for the scheduling of the background compactions.
+	Seed int64
+
+	// Cycles is the number of crashes.
+	//
+	// The default is 20.
+	Cycles int
+
+	// Batches is the largest number of batches written between crashes.
+	//
+	// The default is 500.
+	Batches int
+
+	// Keys is the number of distinct keys written.
+	//
+	// The default is 128.
+	Keys int
+
+	// MaxBatch is the largest number of puts and deletes of a batch.
+	//
+	// The default is 8.
+	MaxBatch int
+
+	// MaxValueSize is the largest size of a value.
+	//
+	// The default is 100.
+	MaxValueSize int
+
+	// SyncRatio is the ratio of synced writes.
+	//
+	// The default is 0.2. Negative means no synced write.
+	SyncRatio float64
+
+	// Options are the options of the DB. The default has a small write
+	// buffer, so that the memdb is often flushed.
+	Options *opt.Options
+
+	// EncryptionVersion and EncryptionKey set the encryption of the DB, see
+	// leveldb.EncryptionVersion. The default is no encryption.
+	EncryptionVersion int
+	EncryptionKey     []byte
+}
+
+func (c *Config) withDefaults() Config {
+	d := Config{
+		Cycles:       20,
+		Batches:      500,
+		Keys:         128,
+		MaxBatch:     8,
+		MaxValueSize: 100,
+		SyncRatio:    0.2,
+		Options:      &opt.Options{WriteBuffer: 16 * opt.KiB},
+	}
+	if c == nil {
+		return d
+	}
+	d.Seed = c.Seed
+	if c.Cycles > 0 {
+		d.Cycles = c.Cycles
+	}
+	if c.Batches > 0 {
+		d.Batches = c.Batches
+	}
+	if c.Keys > 0 {
+		d.Keys = c.Keys
+	}
+	if c.MaxBatch > 0 {
+		d.MaxBatch = c.MaxBatch
+	}
+	if c.MaxValueSize > 0 {
+		d.MaxValueSize = c.MaxValueSize
+	}
+	if c.SyncRatio != 0 {
+		d.SyncRatio = c.SyncRatio
+	}
+	if c.Options != nil {
+		d.Options = c.Options
+	}
+	d.EncryptionVersion = c.EncryptionVersion
+	d.EncryptionKey = c.EncryptionKey
+	return d
+}
+
+// Report summarizes a run.
+type Report struct {
+	Cycles int
+	// Batches is the number of batches acknowledged, of which
+	// SyncedBatches were synced.
+	Batches       int
+	SyncedBatches int
+	// Lost is the number of acknowledged unsynced batches lost in the
+	// crashes, which is allowed.
+	Lost int
+}
+
+// Violation is returned by Run when the DB isn't consistent after a crash.
+type Violation struct {
+	Seed  int64
+	Cycle int
+	Msg   string
+}
+
+func (v *Violation) Error() string {
+	return fmt.Sprintf("crashtest: seed %d, cycle %d: %s", v.Seed, v.Cycle, v.Msg)
+}
+
+// The key holding the ID of the last batch, written by each batch.
+const seqKey = "seq"
+
+type op struct {
+	key, value string
+	del        bool
+}
+
+type batch struct {
+	id  int
+	ops []op
+}
+
+// Run runs the workload of the config, which may be nil, returning a
+// *Violation if the DB isn't consistent after a crash.
+func Run(c *Config) (*Report, error) {
+	cfg := c.withDefaults()
+	defer func(version int, key []byte) {
+		leveldb.EncryptionVersion, leveldb.EncryptionKey = version, key
+	}(leveldb.EncryptionVersion, leveldb.EncryptionKey)
+	leveldb.EncryptionVersion, leveldb.EncryptionKey = cfg.EncryptionVersion, cfg.EncryptionKey
+
+	r := &runner{
+		cfg:  cfg,
+		rnd:  rand.New(rand.NewSource(cfg.Seed)),
+		stor: NewStorage(),
+		base: make(map[string]string),
+	}
+	return r.run()
+}
+
+type runner struct {
+	cfg  Config
+	rnd  *rand.Rand
+	stor *Storage
+	rep  Report
+
+	// The state verified after the last crash, holding the batches up to
+	// baseID, and the batches written since.
+	base    map[string]string
+	baseID  int
+	batches []batch
+	// The last batch acknowledged, and the last acknowledged as synced.
+	ackedID, syncedID int
+}
+
+func (r *runner) violation(format string, args ...interface{}) error {
+	return &Violation{Seed: r.cfg.Seed, Cycle: r.rep.Cycles, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (r *runner) run() (*Report, error) {
+	db, err := leveldb.Open(r.stor, r.cfg.Options)
+	if err != nil {
+		return nil, err
+	}
+	for r.rep.Cycles < r.cfg.Cycles {
+		r.stor.CrashAfter(r.rnd.Intn(r.cfg.Batches*4) + 1)
+		r.workload(db)
+		r.stor.Crash()
+		// The DB can't write anymore, which it may or may not have noticed.
+		db.Close()
+		r.stor.Restart(r.rnd)
+		r.rep.Cycles++
+
+		if db, err = leveldb.Open(r.stor, r.cfg.Options); err != nil {
+			return nil, r.violation("reopen: %v", err)
+		}
+		if err := r.verify(db); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	if err := db.Close(); err != nil {
+		return nil, err
+	}
+	return &r.rep, nil
+}
+
+// Writes random batches until the storage crashes or enough are written.
+func (r *runner) workload(db *leveldb.DB) {
+	nextID := r.baseID + 1
+	for i := 0; i < r.cfg.Batches; i++ {
+		if r.rnd.Intn(100) == 0 {
+			if err := db.CompactRange(r.randRange()); err != nil {
+				return
+			}
+		}
+		b := r.randBatch(nextID)
+		nextID++
+		lb := new(leveldb.Batch)
+		for _, op := range b.ops {
+			if op.del {
+				lb.Delete([]byte(op.key))
+			} else {
+				lb.Put([]byte(op.key), []byte(op.value))
+			}
+		}
+		sync := r.rnd.Float64() < r.cfg.SyncRatio
+		// A failed write may still be durable.
+		r.batches = append(r.batches, b)
+		if err := db.Write(lb, &opt.WriteOptions{Sync: sync}); err != nil {
+			return
+		}
+		r.rep.Batches++
+		r.ackedID = b.id
+		if sync {
+			r.rep.SyncedBatches++
+			r.syncedID = b.id
+		}
+	}
+}
+
+func (r *runner) key(i int) string {
+	return fmt.Sprintf("k%05d", i)
+}
+
+func (r *runner) randRange() util.Range {
+	i, j := r.rnd.Intn(r.cfg.Keys), r.rnd.Intn(r.cfg.Keys)
+	if i > j {
+		i, j = j, i
+	}
+	return util.Range{Start: []byte(r.key(i)), Limit: []byte(r.key(j + 1))}
+}
+
+func (r *runner) randBatch(id int) batch {
+	b := batch{id: id}
+	for n := r.rnd.Intn(r.cfg.MaxBatch) + 1; n > 0; n-- {
+		key := r.key(r.rnd.Intn(r.cfg.Keys))
+		if r.rnd.Intn(4) == 0 {
+			b.ops = append(b.ops, op{key: key, del: true})
+			continue
+		}
+		value := make([]byte, r.rnd.Intn(r.cfg.MaxValueSize+1))
+		r.rnd.Read(value)
+		b.ops = append(b.ops, op{key: key, value: fmt.Sprintf("%d:%x", id, value)})
+	}
+	b.ops = append(b.ops, op{key: seqKey, value: fmt.Sprint(id)})
+	return b
+}
+
+// Verifies that the DB holds a prefix of the batches including the synced
+// ones, and makes it the new base.
+func (r *runner) verify(db *leveldb.DB) error {
+	got := make(map[string]string)
+	iter := db.NewIterator(nil, nil)
+	for iter.Next() {
+		got[string(iter.Key())] = string(iter.Value())
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return r.violation("iterating: %v", err)
+	}
+
+	id := 0
+	if s, ok := got[seqKey]; ok {
+		if _, err := fmt.Sscan(s, &id); err != nil {
+			return r.violation("invalid %s value %q", seqKey, s)
+		}
+	}
+	lastID := r.baseID + len(r.batches)
+	switch {
+	case id < r.syncedID:
+		return r.violation("synced batch %d lost, recovered up to batch %d", r.syncedID, id)
+	case id < r.baseID || id > lastID:
+		return r.violation("recovered batch %d, not in [%d, %d]", id, r.baseID, lastID)
+	}
+
+	want := r.base
+	for _, b := range r.batches[:id-r.baseID] {
+		for _, op := range b.ops {
+			if op.del {
+				delete(want, op.key)
+			} else {
+				want[op.key] = op.value
+			}
+		}
+	}
+	if diff := diff(want, got); diff != "" {
+		return r.violation("state after batch %d: %s", id, diff)
+	}
+	if r.ackedID > id {
+		r.rep.Lost += r.ackedID - id
+	}
+	r.base, r.baseID, r.batches = want, id, nil
+	return nil
+}
+
+// Returns a description of the first differences between the maps, or ""
+// if they're equal.
+func diff(want, got map[string]string) string {
+	var keys []string
+	for k, v := range want {
+		if gv, ok := got[k]; !ok || gv != v {
+			keys = append(keys, k)
+		}
+	}
+	for k := range got {
+		if _, ok := want[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	sort.Strings(keys)
+	s := fmt.Sprintf("%d keys differ", len(keys))
+	for i, k := range keys {
+		if i == 3 {
+			s += ", ..."
+			break
+		}
+		s += fmt.Sprintf(", %q: want %.20q, got %.20q", k, want[k], got[k])
+	}
+	return s
+}
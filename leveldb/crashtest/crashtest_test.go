@@ -0,0 +1,88 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package crashtest
+
+import (
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestStorage(t *testing.T) {
+	s := NewStorage()
+	fd := storage.FileDesc{Type: storage.TypeJournal, Num: 1}
+	w, err := s.Create(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("synced"))
+	w.Sync()
+	w.Write([]byte("unsynced"))
+	s.CrashAfter(0)
+	if _, err := w.Write([]byte("x")); err != ErrCrashed {
+		t.Fatalf("write after crash: got %v", err)
+	}
+	if _, err := s.Open(fd); err != ErrCrashed {
+		t.Fatalf("open after crash: got %v", err)
+	}
+
+	s.Restart(rand.New(rand.NewSource(1)))
+	if err := w.Sync(); err != ErrCrashed {
+		t.Fatalf("sync of the crashed process: got %v", err)
+	}
+	r, err := s.Open(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(r)
+	if len(data) < len("synced") || string(data) != "syncedunsynced"[:len(data)] {
+		t.Fatalf("got %q", data)
+	}
+	if l, err := s.Lock(); err != nil {
+		t.Fatalf("lock after restart: %v", err)
+	} else {
+		l.Unlock()
+	}
+}
+
+func TestRun(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	for _, c := range []struct {
+		name    string
+		version int
+	}{{"none", 0}, {"xor", 1}, {"aes", 2}} {
+		for seed := int64(0); seed < 3; seed++ {
+			rep, err := Run(&Config{Seed: seed, Cycles: 10, EncryptionVersion: c.version, EncryptionKey: key})
+			if err != nil {
+				t.Fatalf("%s: %v", c.name, err)
+			}
+			if rep.Cycles != 10 || rep.SyncedBatches == 0 || rep.Batches <= rep.SyncedBatches {
+				t.Fatalf("%s: bad report %+v", c.name, rep)
+			}
+		}
+	}
+}
+
+func TestRunViolation(t *testing.T) {
+	// Without fsync, the synced writes are lost.
+	for seed := int64(0); seed < 20; seed++ {
+		_, err := Run(&Config{Seed: seed, Cycles: 5, SyncRatio: 1, Options: &opt.Options{NoSync: true}})
+		if v, ok := err.(*Violation); ok {
+			if v.Seed != seed {
+				t.Fatalf("got seed %d, want %d", v.Seed, seed)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Fatal("no violation found")
+}
@@ -0,0 +1,305 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package crashtest
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"os"
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// ErrCrashed is returned by the operations of a Storage after a crash, until
+// it's restarted.
+var ErrCrashed = errors.New("crashtest: storage crashed")
+
+// file is the content of a file; only the first synced bytes survive a
+// crash.
+type file struct {
+	data   []byte
+	synced int
+}
+
+// Storage is a memory-backed storage simulating power loss. The writes to
+// a file are durable once the file is synced; at a crash, each file keeps
+// its synced content plus a random part of the following writes. Creating,
+// renaming and removing files, and setting the meta, are durable at once,
+// as the file storage syncs the directory.
+//
+// Storage is safe for concurrent use.
+type Storage struct {
+	mu     sync.Mutex
+	files  map[storage.FileDesc]*file
+	meta   storage.FileDesc
+	locked bool
+
+	crashed bool
+	// Number of modifications before the crash, negative if none is
+	// scheduled.
+	crashIn int
+	// Incremented at each restart, invalidating the handles of the crashed
+	// process.
+	gen int
+}
+
+// NewStorage returns a new empty Storage.
+func NewStorage() *Storage {
+	return &Storage{
+		files:   make(map[storage.FileDesc]*file),
+		crashIn: -1,
+	}
+}
+
+// CrashAfter schedules a crash after the given number of modifications:
+// writes, syncs, and creating, renaming and removing files. The
+// modification hitting it fails, as all the operations until Restart.
+func (s *Storage) CrashAfter(n int) {
+	s.mu.Lock()
+	s.crashIn = n
+	s.mu.Unlock()
+}
+
+// Crash crashes the storage now, if not already crashed.
+func (s *Storage) Crash() {
+	s.mu.Lock()
+	s.crashed = true
+	s.crashIn = -1
+	s.mu.Unlock()
+}
+
+// Crashed returns whether the storage is crashed.
+func (s *Storage) Crashed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.crashed
+}
+
+// Restart simulates the reboot following a crash, crashing first if not
+// crashed: the unsynced writes are lost, but for a random part of them, and
+// the lock is released.
+func (s *Storage) Restart(rnd *rand.Rand) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range s.files {
+		n := f.synced + rnd.Intn(len(f.data)-f.synced+1)
+		// Limit the capacity, so that the next writes don't share the
+		// array of the readers of the crashed process.
+		f.data = f.data[:n:n]
+		f.synced = n
+	}
+	s.crashed = false
+	s.crashIn = -1
+	s.locked = false
+	s.gen++
+}
+
+// Counts a modification, returning ErrCrashed if the storage is crashed,
+// or crashes now. Must be called with the lock held.
+func (s *Storage) tick(gen int) error {
+	if s.crashed || gen != s.gen {
+		return ErrCrashed
+	}
+	if s.crashIn == 0 {
+		s.crashed = true
+		s.crashIn = -1
+		return ErrCrashed
+	}
+	if s.crashIn > 0 {
+		s.crashIn--
+	}
+	return nil
+}
+
+func (s *Storage) check() error {
+	if s.crashed {
+		return ErrCrashed
+	}
+	return nil
+}
+
+type lock struct {
+	s   *Storage
+	gen int
+}
+
+func (l lock) Unlock() {
+	l.s.mu.Lock()
+	if l.gen == l.s.gen {
+		l.s.locked = false
+	}
+	l.s.mu.Unlock()
+}
+
+func (s *Storage) Lock() (storage.Locker, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locked {
+		return nil, storage.ErrLocked
+	}
+	s.locked = true
+	return lock{s, s.gen}, nil
+}
+
+func (*Storage) Log(str string) {}
+
+func (s *Storage) SetMeta(fd storage.FileDesc) error {
+	if !storage.FileDescOk(fd) {
+		return storage.ErrInvalidFile
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.tick(s.gen); err != nil {
+		return err
+	}
+	s.meta = fd
+	return nil
+}
+
+func (s *Storage) GetMeta() (storage.FileDesc, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.check(); err != nil {
+		return storage.FileDesc{}, err
+	}
+	if _, ok := s.files[s.meta]; !ok {
+		return storage.FileDesc{}, os.ErrNotExist
+	}
+	return s.meta, nil
+}
+
+func (s *Storage) List(ft storage.FileType) ([]storage.FileDesc, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	var fds []storage.FileDesc
+	for fd := range s.files {
+		if fd.Type&ft != 0 {
+			fds = append(fds, fd)
+		}
+	}
+	return fds, nil
+}
+
+func (s *Storage) Open(fd storage.FileDesc) (storage.Reader, error) {
+	if !storage.FileDescOk(fd) {
+		return nil, storage.ErrInvalidFile
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	f, ok := s.files[fd]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return reader{bytes.NewReader(f.data)}, nil
+}
+
+func (s *Storage) Create(fd storage.FileDesc) (storage.Writer, error) {
+	if !storage.FileDescOk(fd) {
+		return nil, storage.ErrInvalidFile
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.tick(s.gen); err != nil {
+		return nil, err
+	}
+	f := new(file)
+	s.files[fd] = f
+	return &writer{s: s, f: f, gen: s.gen}, nil
+}
+
+func (s *Storage) Remove(fd storage.FileDesc) error {
+	if !storage.FileDescOk(fd) {
+		return storage.ErrInvalidFile
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.tick(s.gen); err != nil {
+		return err
+	}
+	if _, ok := s.files[fd]; !ok {
+		return os.ErrNotExist
+	}
+	delete(s.files, fd)
+	return nil
+}
+
+func (s *Storage) Rename(oldfd, newfd storage.FileDesc) error {
+	if !storage.FileDescOk(oldfd) || !storage.FileDescOk(newfd) {
+		return storage.ErrInvalidFile
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.tick(s.gen); err != nil {
+		return err
+	}
+	f, ok := s.files[oldfd]
+	if !ok {
+		return os.ErrNotExist
+	}
+	delete(s.files, oldfd)
+	s.files[newfd] = f
+	return nil
+}
+
+func (*Storage) Close() error { return nil }
+
+type reader struct {
+	*bytes.Reader
+}
+
+func (reader) Close() error { return nil }
+
+type writer struct {
+	s      *Storage
+	f      *file
+	gen    int
+	closed bool
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	w.s.mu.Lock()
+	defer w.s.mu.Unlock()
+	if w.closed {
+		return 0, storage.ErrClosed
+	}
+	if err := w.s.tick(w.gen); err != nil {
+		return 0, err
+	}
+	w.f.data = append(w.f.data, p...)
+	return len(p), nil
+}
+
+func (w *writer) Sync() error {
+	w.s.mu.Lock()
+	defer w.s.mu.Unlock()
+	if w.closed {
+		return storage.ErrClosed
+	}
+	if err := w.s.tick(w.gen); err != nil {
+		return err
+	}
+	w.f.synced = len(w.f.data)
+	return nil
+}
+
+func (w *writer) Close() error {
+	w.s.mu.Lock()
+	defer w.s.mu.Unlock()
+	if w.closed {
+		return storage.ErrClosed
+	}
+	w.closed = true
+	return nil
+}
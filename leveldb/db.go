@@ -8,6 +8,7 @@ package leveldb
 
 import (
 	"container/list"
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -35,32 +36,54 @@ type DB struct {
 
 	// Stats. Need 64-bit alignment.
 	cWriteDelay            int64 // The cumulative duration of write delays
+	cExpiredSnaps          int64 // The cumulative number of expired snapshots
 	cWriteDelayN           int32 // The cumulative number of write delays
 	inWritePaused          int32 // The indicator whether write operation is paused by compaction
 	aliveSnaps, aliveIters int32
+	skipBlockChecksum      int32 // Whether user reads skip block checksum verification
 
 	// Compaction statistic
 	memComp       uint32 // The cumulative number of memory compaction
 	level0Comp    uint32 // The cumulative number of level0 compaction
 	nonLevel0Comp uint32 // The cumulative number of non-level0 compaction
 	seekComp      uint32 // The cumulative number of seek compaction
+	latency       *dbLatency
+	amp           *ampStats
+	running       runningJobs
+
+	// Workload trace, holding a *traceWriter, see StartTrace.
+	traceMu sync.Mutex
+	trace   atomic.Value
+
+	// History of the recent write batches, see NewWALIterator.
+	wal *walLog
 
 	// Session.
 	s *session
 
 	// MemDB.
-	memMu           sync.RWMutex
-	memPool         chan *memdb.DB
-	mem, frozenMem  *memDB
-	journal         *journal.Writer
-	journalWriter   storage.Writer
-	journalFd       storage.FileDesc
-	frozenJournalFd storage.FileDesc
-	frozenSeq       uint64
+	memMu         sync.RWMutex
+	memPool       chan memdb.Memtable
+	mem           *memDB
+	frozenMems    []*memDB // Oldest first.
+	journal       *journal.Writer
+	journalWriter storage.Writer
+	journalFd     storage.FileDesc
+	recycleFds    []storage.FileDesc // Obsolete journals kept for reuse.
+	journalCodec  journalCodec       // Guarded by the write lock.
+
+	// Obsolete journals to archive, see jArchive.
+	archiveMu sync.Mutex
+	archiveQ  []storage.FileDesc // Oldest first.
+	archiveC  chan struct{}
+
+	// Get.
+	getKeyPool sync.Pool // Of *internalKey, the scratch space of gets.
 
 	// Snapshot.
 	snapsMu   sync.Mutex
 	snapsList *list.List
+	snapsHeld *list.List // Of *snapshotRecord, oldest first.
 
 	// Write.
 	batchPool    sync.Pool
@@ -72,6 +95,9 @@ type DB struct {
 	writeDelayN  int
 	tr           *Transaction
 
+	writeStall       opt.WriteStallCondition
+	writeStallReason opt.WriteStallReason
+
 	// Compaction.
 	compCommitLk     sync.Mutex
 	tcompCmdC        chan cCmd
@@ -84,6 +110,15 @@ type DB struct {
 	compStats        cStats
 	memdbMaxLevel    int // For testing.
 
+	// Table compaction workers, see CompactionConcurrency.
+	compWorkerMu     sync.Mutex
+	compWorkers      int
+	compWorkerL0     bool
+	compWorkerBusy   map[int64]struct{} // Input tables of the workers.
+	compWorkerExit   bool               // Whether a worker exited its transact.
+	compWorkerC      chan struct{}
+	compWorkerPauseC chan chan<- struct{}
+
 	// Close.
 	closeW sync.WaitGroup
 	closeC chan struct{}
@@ -92,32 +127,50 @@ type DB struct {
 }
 
 func openDB(s *session) (*DB, error) {
-	s.log("db@open opening")
+	s.logInfo("db@open opening")
 	start := time.Now()
 	db := &DB{
 		s: s,
 		// Initial sequence
 		seq: s.stSeqNum,
 		// MemDB
-		memPool: make(chan *memdb.DB, 1),
+		memPool: make(chan memdb.Memtable, 1),
 		// Snapshot
 		snapsList: list.New(),
+		snapsHeld: list.New(),
 		// Write
+		getKeyPool:   sync.Pool{New: func() interface{} { return new(internalKey) }},
 		batchPool:    sync.Pool{New: newBatch},
 		writeMergeC:  make(chan writeMerge),
 		writeMergedC: make(chan bool),
 		writeLockC:   make(chan struct{}, 1),
 		writeAckC:    make(chan error),
+		// Journal archive
+		archiveC: make(chan struct{}, 1),
 		// Compaction
-		tcompCmdC:   make(chan cCmd),
-		tcompPauseC: make(chan chan<- struct{}),
-		mcompCmdC:   make(chan cCmd),
-		compErrC:    make(chan error),
-		compPerErrC: make(chan error),
-		compErrSetC: make(chan error),
+		tcompCmdC:        make(chan cCmd),
+		tcompPauseC:      make(chan chan<- struct{}),
+		mcompCmdC:        make(chan cCmd),
+		compErrC:         make(chan error),
+		compPerErrC:      make(chan error),
+		compErrSetC:      make(chan error),
+		compWorkerC:      make(chan struct{}, 1),
+		compWorkerPauseC: make(chan chan<- struct{}),
 		// Close
 		closeC: make(chan struct{}),
 	}
+	if s.o.GetCompactionOnlyBlockChecksum() {
+		db.skipBlockChecksum = 1
+	}
+	if s.o.GetLatencyHistograms() {
+		db.latency = &dbLatency{}
+	}
+	if s.o.GetAmplificationStats() {
+		db.amp = &ampStats{}
+	}
+	if n := s.o.GetWALRetentionSize(); n > 0 {
+		db.wal = newWALLog(n, db.seq)
+	}
 
 	// Read-only mode.
 	readOnly := s.o.GetReadOnly()
@@ -149,7 +202,7 @@ func openDB(s *session) (*DB, error) {
 	go db.mpoolDrain()
 
 	if readOnly {
-		if err := db.SetReadOnly(); err != nil {
+		if err := db.setReadOnly(); err != nil {
 			return nil, err
 		}
 	} else {
@@ -157,9 +210,13 @@ func openDB(s *session) (*DB, error) {
 		go db.tCompaction()
 		go db.mCompaction()
 		// go db.jWriter()
+		if s.o.GetJournalArchive() != nil {
+			db.closeW.Add(1)
+			go db.jArchive()
+		}
 	}
 
-	s.logf("db@open done T·%v", time.Since(start))
+	s.logInfo("db@open done", "duration", time.Since(start))
 
 	runtime.SetFinalizer(db, (*DB).Close)
 	return db, nil
@@ -174,14 +231,25 @@ func openDB(s *session) (*DB, error) {
 // detected in the DB. Use errors.IsCorrupted to test whether an error is
 // due to corruption. Corrupted DB can be recovered with Recover function.
 //
+// Open waits for the lock of the storage up to Options.LockTimeout.
+//
 // The returned DB instance is safe for concurrent use.
 // The DB must be closed after use, by calling Close method.
 func Open(stor storage.Storage, o *opt.Options) (db *DB, err error) {
-	s, err := newSession(stor, o)
+	return OpenContext(context.Background(), stor, o)
+}
+
+// OpenContext is as Open, but stops waiting for the lock of the storage when
+// the context is done, returning its error. The context isn't used once the
+// DB is open.
+func OpenContext(ctx context.Context, stor storage.Storage, o *opt.Options) (db *DB, err error) {
+	s, err := newSessionContext(ctx, stor, o)
 	if err != nil {
 		return
 	}
+	var created bool
 	defer func() {
+		s.recordAdmin(AdminOpen, err, "created", created, "read-only", s.o.GetReadOnly())
 		if err != nil {
 			s.close()
 			s.release()
@@ -190,9 +258,10 @@ func Open(stor storage.Storage, o *opt.Options) (db *DB, err error) {
 
 	err = s.recover()
 	if err != nil {
-		if !os.IsNotExist(err) || s.o.GetErrorIfMissing() || s.o.GetReadOnly() {
+		if !errors.Is(err, os.ErrNotExist) || s.o.GetErrorIfMissing() || s.o.GetReadOnly() {
 			return
 		}
+		created = true
 		err = s.create()
 		if err != nil {
 			return
@@ -217,14 +286,28 @@ func Open(stor storage.Storage, o *opt.Options) (db *DB, err error) {
 // detected in the DB. Use errors.IsCorrupted to test whether an error is
 // due to corruption. Corrupted DB can be recovered with Recover function.
 //
+// OpenFile waits for the LOCK file, held e.g. by another process, up to
+// Options.LockTimeout.
+//
 // The returned DB instance is safe for concurrent use.
 // The DB must be closed after use, by calling Close method.
 func OpenFile(path string, o *opt.Options) (db *DB, err error) {
-	stor, err := storage.OpenFile(path, o.GetReadOnly())
+	return OpenFileContext(context.Background(), path, o)
+}
+
+// OpenFileContext is as OpenFile, but stops waiting for the LOCK file when
+// the context is done, returning its error. The context isn't used once the
+// DB is open.
+func OpenFileContext(ctx context.Context, path string, o *opt.Options) (db *DB, err error) {
+	var stor storage.Storage
+	err = retryLocked(ctx, o.GetLockTimeout(), func() (err error) {
+		stor, err = storage.OpenFile(path, o.GetReadOnly())
+		return
+	})
 	if err != nil {
 		return
 	}
-	db, err = Open(stor, o)
+	db, err = OpenContext(ctx, stor, o)
 	if err != nil {
 		stor.Close()
 	} else {
@@ -233,6 +316,20 @@ func OpenFile(path string, o *opt.Options) (db *DB, err error) {
 	return
 }
 
+// OpenWith is as Open, with the options set by the given functional
+// options, see opt.New:
+//
+//	db, err := leveldb.OpenWith(stor, opt.WithPreset(opt.ForSSD()), opt.WithNoSync())
+func OpenWith(stor storage.Storage, opts ...opt.Option) (*DB, error) {
+	return Open(stor, opt.New(opts...))
+}
+
+// OpenFileWith is as OpenFile, with the options set by the given
+// functional options, see opt.New.
+func OpenFileWith(path string, opts ...opt.Option) (*DB, error) {
+	return OpenFile(path, opt.New(opts...))
+}
+
 // Recover recovers and opens a DB with missing or corrupted manifest files
 // for the given storage. It will ignore any manifest files, valid or not.
 // The DB must already exist or it will returns an error.
@@ -246,6 +343,7 @@ func Recover(stor storage.Storage, o *opt.Options) (db *DB, err error) {
 		return
 	}
 	defer func() {
+		s.recordAdmin(AdminRecover, err)
 		if err != nil {
 			s.close()
 			s.release()
@@ -357,7 +455,7 @@ func recoverTable(s *session, o *opt.Options) error {
 		return
 	}
 	recoverTable := func(fd storage.FileDesc) error {
-		s.logf("table@recovery recovering @%d", fd.Num)
+		s.logInfo("table@recovery recovering", "num", fd.Num)
 		reader, err := s.stor.Open(fd)
 		if err != nil {
 			return err
@@ -388,7 +486,7 @@ func recoverTable(s *session, o *opt.Options) error {
 		if itererr, ok := iter.(iterator.ErrorCallbackSetter); ok {
 			itererr.SetErrorCallback(func(err error) {
 				if errors.IsCorrupted(err) {
-					s.logf("table@recovery block corruption @%d %q", fd.Num, err)
+					s.logWarn("table@recovery block corruption", "num", fd.Num, "err", err)
 					tcorruptedBlock++
 				}
 			})
@@ -423,14 +521,14 @@ func recoverTable(s *session, o *opt.Options) error {
 
 		if strict && (tcorruptedKey > 0 || tcorruptedBlock > 0) {
 			droppedTable++
-			s.logf("table@recovery dropped @%d Gk·%d Ck·%d Cb·%d S·%d Q·%d", fd.Num, tgoodKey, tcorruptedKey, tcorruptedBlock, size, tSeq)
+			s.logWarn("table@recovery dropped", "num", fd.Num, "keys.good", tgoodKey, "keys.corrupted", tcorruptedKey, "blocks.corrupted", tcorruptedBlock, "size", size, "seq", tSeq)
 			return nil
 		}
 
 		if tgoodKey > 0 {
 			if tcorruptedKey > 0 || tcorruptedBlock > 0 {
 				// Rebuild the table.
-				s.logf("table@recovery rebuilding @%d", fd.Num)
+				s.logInfo("table@recovery rebuilding", "num", fd.Num)
 				iter := tr.NewIterator(nil, nil)
 				tmpFd, newSize, err := buildTable(iter)
 				iter.Release()
@@ -450,10 +548,10 @@ func recoverTable(s *session, o *opt.Options) error {
 			recoveredKey += tgoodKey
 			// Add table to level 0.
 			rec.addTable(0, fd.Num, size, imin, imax)
-			s.logf("table@recovery recovered @%d Gk·%d Ck·%d Cb·%d S·%d Q·%d", fd.Num, tgoodKey, tcorruptedKey, tcorruptedBlock, size, tSeq)
+			s.logInfo("table@recovery recovered", "num", fd.Num, "keys.good", tgoodKey, "keys.corrupted", tcorruptedKey, "blocks.corrupted", tcorruptedBlock, "size", size, "seq", tSeq)
 		} else {
 			droppedTable++
-			s.logf("table@recovery unrecoverable @%d Ck·%d Cb·%d S·%d", fd.Num, tcorruptedKey, tcorruptedBlock, size)
+			s.logError("table@recovery unrecoverable", "num", fd.Num, "keys.corrupted", tcorruptedKey, "blocks.corrupted", tcorruptedBlock, "size", size)
 		}
 
 		return nil
@@ -461,7 +559,7 @@ func recoverTable(s *session, o *opt.Options) error {
 
 	// Recover all tables.
 	if len(fds) > 0 {
-		s.logf("table@recovery F·%d", len(fds))
+		s.logInfo("table@recovery", "files", len(fds))
 
 		// Mark file number as used.
 		s.markFileNum(fds[len(fds)-1].Num)
@@ -472,7 +570,7 @@ func recoverTable(s *session, o *opt.Options) error {
 			}
 		}
 
-		s.logf("table@recovery recovered F·%d N·%d Gk·%d Ck·%d Q·%d", len(fds), recoveredKey, goodKey, corruptedKey, maxSeq)
+		s.logInfo("table@recovery recovered", "files", len(fds), "keys.recovered", recoveredKey, "keys.good", goodKey, "keys.corrupted", corruptedKey, "seq", maxSeq)
 	}
 
 	// Set sequence number.
@@ -487,7 +585,7 @@ func recoverTable(s *session, o *opt.Options) error {
 	return s.commit(rec, false)
 }
 
-func (db *DB) recoverJournal() error {
+func (db *DB) recoverJournal() (err error) {
 	// Get all journals and sort it by file number.
 	rawFds, err := db.s.stor.List(storage.TypeJournal)
 	if err != nil {
@@ -503,6 +601,17 @@ func (db *DB) recoverJournal() error {
 		}
 	}
 
+	listener := db.s.o.GetEventListener()
+	listener.OnRecoveryBegin(opt.RecoveryInfo{Journals: len(fds)})
+	defer func(start time.Time) {
+		listener.OnRecoveryEnd(opt.RecoveryInfo{
+			Journals: len(fds),
+			SeqNum:   db.seq,
+			Duration: time.Since(start),
+			Err:      err,
+		})
+	}(time.Now())
+
 	var (
 		ofd storage.FileDesc // Obsolete file.
 		rec = &sessionRecord{}
@@ -510,7 +619,7 @@ func (db *DB) recoverJournal() error {
 
 	// Recover journals.
 	if len(fds) > 0 {
-		db.logf("journal@recovery F·%d", len(fds))
+		db.logInfo("journal@recovery", "files", len(fds))
 
 		// Mark file number as used.
 		db.s.markFileNum(fds[len(fds)-1].Num)
@@ -524,16 +633,17 @@ func (db *DB) recoverJournal() error {
 			jr       *journal.Reader
 			mdb      = memdb.New(db.s.icmp, writeBuffer)
 			buf      = &util.Buffer{}
+			codec    journalCodec
 			batchSeq uint64
 			batchLen int
 		)
 
 		for _, fd := range fds {
-			db.logf("journal@recovery recovering @%d", fd.Num)
+			db.logInfo("journal@recovery recovering", "num", fd.Num)
 
 			fr, err := db.s.stor.Open(fd)
 			if err != nil {
-				return err
+				return errors.NewOpError("open", fd, err)
 			}
 
 			// Create or reset journal reader instance.
@@ -543,11 +653,12 @@ func (db *DB) recoverJournal() error {
 				// Ignore the error here
 				_ = jr.Reset(fr, dropper{db.s, fd}, strict, checksum)
 			}
+			jr.SetLogNum(uint32(fd.Num))
 
 			// Flush memdb and remove obsolete journal file.
 			if !ofd.Zero() {
 				if mdb.Len() > 0 {
-					if _, err := db.s.flushMemdb(rec, mdb, 0); err != nil {
+					if _, err := db.s.flushMemdb(rec, mdb, 0, opt.TableReasonRecovery); err != nil {
 						fr.Close()
 						return err
 					}
@@ -561,7 +672,7 @@ func (db *DB) recoverJournal() error {
 				}
 				rec.resetAddedTables()
 
-				if err := db.s.stor.Remove(ofd); err != nil {
+				if err := db.removeJournal(ofd); err != nil {
 					fr.Close()
 					return err
 				}
@@ -591,10 +702,13 @@ func (db *DB) recoverJournal() error {
 					fr.Close()
 					return errors.SetFd(err, fd)
 				}
-				batchSeq, batchLen, err = decodeBatchToMem(buf.Bytes(), db.seq, mdb)
+				data, err := codec.decode(buf.Bytes())
+				if err == nil {
+					batchSeq, batchLen, err = decodeBatchToMem(data, db.seq, mdb)
+				}
 				if err != nil {
 					if !strict && errors.IsCorrupted(err) {
-						db.s.logf("journal error: %v (skipped)", err)
+						db.logWarn("journal@recovery skipped error", "err", err)
 						// We won't apply sequence number as it might be corrupted.
 						continue
 					}
@@ -608,7 +722,7 @@ func (db *DB) recoverJournal() error {
 
 				// Flush it if large enough.
 				if mdb.Size() >= writeBuffer {
-					if _, err := db.s.flushMemdb(rec, mdb, 0); err != nil {
+					if _, err := db.s.flushMemdb(rec, mdb, 0, opt.TableReasonRecovery); err != nil {
 						fr.Close()
 						return err
 					}
@@ -623,7 +737,7 @@ func (db *DB) recoverJournal() error {
 
 		// Flush the last memdb.
 		if mdb.Len() > 0 {
-			if _, err := db.s.flushMemdb(rec, mdb, 0); err != nil {
+			if _, err := db.s.flushMemdb(rec, mdb, 0, opt.TableReasonRecovery); err != nil {
 				return err
 			}
 		}
@@ -648,7 +762,7 @@ func (db *DB) recoverJournal() error {
 
 	// Remove the last obsolete journal file.
 	if !ofd.Zero() {
-		if err := db.s.stor.Remove(ofd); err != nil {
+		if err := db.removeJournal(ofd); err != nil {
 			return err
 		}
 	}
@@ -656,7 +770,7 @@ func (db *DB) recoverJournal() error {
 	return nil
 }
 
-func (db *DB) recoverJournalRO() error {
+func (db *DB) recoverJournalRO() (err error) {
 	// Get all journals and sort it by file number.
 	rawFds, err := db.s.stor.List(storage.TypeJournal)
 	if err != nil {
@@ -672,6 +786,17 @@ func (db *DB) recoverJournalRO() error {
 		}
 	}
 
+	listener := db.s.o.GetEventListener()
+	listener.OnRecoveryBegin(opt.RecoveryInfo{Journals: len(fds)})
+	defer func(start time.Time) {
+		listener.OnRecoveryEnd(opt.RecoveryInfo{
+			Journals: len(fds),
+			SeqNum:   db.seq,
+			Duration: time.Since(start),
+			Err:      err,
+		})
+	}(time.Now())
+
 	var (
 		// Options.
 		strict      = db.s.o.GetStrict(opt.StrictJournal)
@@ -683,21 +808,22 @@ func (db *DB) recoverJournalRO() error {
 
 	// Recover journals.
 	if len(fds) > 0 {
-		db.logf("journal@recovery RO·Mode F·%d", len(fds))
+		db.logInfo("journal@recovery read-only", "files", len(fds))
 
 		var (
 			jr       *journal.Reader
 			buf      = &util.Buffer{}
+			codec    journalCodec
 			batchSeq uint64
 			batchLen int
 		)
 
 		for _, fd := range fds {
-			db.logf("journal@recovery recovering @%d", fd.Num)
+			db.logInfo("journal@recovery recovering", "num", fd.Num)
 
 			fr, err := db.s.stor.Open(fd)
 			if err != nil {
-				return err
+				return errors.NewOpError("open", fd, err)
 			}
 
 			// Create or reset journal reader instance.
@@ -708,6 +834,7 @@ func (db *DB) recoverJournalRO() error {
 					return err
 				}
 			}
+			jr.SetLogNum(uint32(fd.Num))
 
 			// Replay journal to memdb.
 			for {
@@ -731,10 +858,13 @@ func (db *DB) recoverJournalRO() error {
 					fr.Close()
 					return errors.SetFd(err, fd)
 				}
-				batchSeq, batchLen, err = decodeBatchToMem(buf.Bytes(), db.seq, mdb)
+				data, err := codec.decode(buf.Bytes())
+				if err == nil {
+					batchSeq, batchLen, err = decodeBatchToMem(data, db.seq, mdb)
+				}
 				if err != nil {
 					if !strict && errors.IsCorrupted(err) {
-						db.s.logf("journal error: %v (skipped)", err)
+						db.logWarn("journal@recovery skipped error", "err", err)
 						// We won't apply sequence number as it might be corrupted.
 						continue
 					}
@@ -752,12 +882,15 @@ func (db *DB) recoverJournalRO() error {
 	}
 
 	// Set memDB.
-	db.mem = &memDB{db: db, DB: mdb, ref: 1}
+	db.mem = &memDB{db: db, Memtable: mdb, ref: 1}
 
 	return nil
 }
 
-func memGet(mdb *memdb.DB, ikey internalKey, icmp *iComparer) (ok bool, mv []byte, err error) {
+func memGet(mdb memdb.Memtable, ikey internalKey, icmp *iComparer) (ok bool, mv []byte, err error) {
+	if !mdb.MayContain(ikey) {
+		return
+	}
 	mk, mv, err := mdb.Find(ikey)
 	if err == nil {
 		ukey, _, kt, kerr := parseInternalKey(mk)
@@ -778,29 +911,41 @@ func memGet(mdb *memdb.DB, ikey internalKey, icmp *iComparer) (ok bool, mv []byt
 	return
 }
 
-func (db *DB) get(auxm *memdb.DB, auxt tFiles, key []byte, seq uint64, ro *opt.ReadOptions) (value []byte, err error) {
-	ikey := makeInternalKey(nil, key, seq, keyTypeSeek)
+// The value is appended to dst.
+func (db *DB) get(auxm memdb.Memtable, auxt tFiles, key []byte, seq uint64, ro *opt.ReadOptions, dst []byte) (value []byte, err error) {
+	touched, done := db.trackGet("db@get slow", key)
+	if done != nil {
+		defer func() { done(err) }()
+	}
+
+	kp := db.getKeyPool.Get().(*internalKey)
+	ikey := makeInternalKey(*kp, key, seq, keyTypeSeek)
+	*kp = ikey
+	defer db.getKeyPool.Put(kp)
 
 	if auxm != nil {
 		if ok, mv, me := memGet(auxm, ikey, db.s.icmp); ok {
-			return append([]byte(nil), mv...), me
+			return append(dst, mv...), me
 		}
 	}
 
-	em, fm := db.getMems()
-	for _, m := range [...]*memDB{em, fm} {
-		if m == nil {
-			continue
-		}
-		defer m.decref()
-
-		if ok, mv, me := memGet(m.DB, ikey, db.s.icmp); ok {
-			return append([]byte(nil), mv...), me
+	var memsBuf [4]*memDB
+	mems, found := db.appendMems(memsBuf[:0]), false
+	for _, m := range mems {
+		if ok, mv, me := memGet(m.Memtable, ikey, db.s.icmp); ok {
+			value, err, found = append(dst, mv...), me, true
+			break
 		}
 	}
+	for _, m := range mems {
+		m.decref()
+	}
+	if found {
+		return
+	}
 
 	v := db.s.version()
-	value, cSched, err := v.get(auxt, ikey, ro, false)
+	value, cSched, err := v.get(auxt, ikey, db.userReadOptions(ro), false, dst, touched)
 	v.release()
 	if cSched {
 		// Trigger table compaction.
@@ -816,8 +961,16 @@ func nilIfNotFound(err error) error {
 	return err
 }
 
-func (db *DB) has(auxm *memdb.DB, auxt tFiles, key []byte, seq uint64, ro *opt.ReadOptions) (ret bool, err error) {
-	ikey := makeInternalKey(nil, key, seq, keyTypeSeek)
+func (db *DB) has(auxm memdb.Memtable, auxt tFiles, key []byte, seq uint64, ro *opt.ReadOptions) (ret bool, err error) {
+	touched, done := db.trackGet("db@has slow", key)
+	if done != nil {
+		defer func() { done(err) }()
+	}
+
+	kp := db.getKeyPool.Get().(*internalKey)
+	ikey := makeInternalKey(*kp, key, seq, keyTypeSeek)
+	*kp = ikey
+	defer db.getKeyPool.Put(kp)
 
 	if auxm != nil {
 		if ok, _, me := memGet(auxm, ikey, db.s.icmp); ok {
@@ -825,20 +978,23 @@ func (db *DB) has(auxm *memdb.DB, auxt tFiles, key []byte, seq uint64, ro *opt.R
 		}
 	}
 
-	em, fm := db.getMems()
-	for _, m := range [...]*memDB{em, fm} {
-		if m == nil {
-			continue
-		}
-		defer m.decref()
-
-		if ok, _, me := memGet(m.DB, ikey, db.s.icmp); ok {
-			return me == nil, nilIfNotFound(me)
+	var memsBuf [4]*memDB
+	mems, found := db.appendMems(memsBuf[:0]), false
+	for _, m := range mems {
+		if ok, _, me := memGet(m.Memtable, ikey, db.s.icmp); ok {
+			ret, err, found = me == nil, nilIfNotFound(me), true
+			break
 		}
 	}
+	for _, m := range mems {
+		m.decref()
+	}
+	if found {
+		return
+	}
 
 	v := db.s.version()
-	_, cSched, err := v.get(auxt, ikey, ro, true)
+	_, cSched, err := v.get(auxt, ikey, db.userReadOptions(ro), true, nil, touched)
 	v.release()
 	if cSched {
 		// Trigger table compaction.
@@ -859,14 +1015,145 @@ func (db *DB) has(auxm *memdb.DB, auxt tFiles, key []byte, seq uint64, ro *opt.R
 // of the returned slice.
 // It is safe to modify the contents of the argument after Get returns.
 func (db *DB) Get(key []byte, ro *opt.ReadOptions) (value []byte, err error) {
+	if span := db.startSpan("leveldb.Get"); span != nil {
+		defer func() { endReadSpan(span, err) }()
+	}
+	if l := db.latency; l != nil {
+		defer l.get.since(time.Now())
+	}
+
 	err = db.ok()
 	if err != nil {
 		return
 	}
+	if t := db.tracing(); t != nil {
+		t.key(traceGet, key)
+	}
+
+	se := db.acquireSnapshot()
+	defer db.releaseSnapshot(se)
+	return db.get(nil, nil, key, se.seq, ro, nil)
+}
+
+// GetTo is like Get, but appends the value to dst and returns the updated
+// slice, so that reusing dst across calls avoids allocating the value. On
+// error, including ErrNotFound, dst is returned as is.
+//
+// It is safe to modify the contents of the argument after GetTo returns.
+func (db *DB) GetTo(key, dst []byte, ro *opt.ReadOptions) (value []byte, err error) {
+	if span := db.startSpan("leveldb.Get"); span != nil {
+		defer func() { endReadSpan(span, err) }()
+	}
+	if l := db.latency; l != nil {
+		defer l.get.since(time.Now())
+	}
+
+	if err = db.ok(); err != nil {
+		return dst, err
+	}
+	if t := db.tracing(); t != nil {
+		t.key(traceGet, key)
+	}
+
+	se := db.acquireSnapshot()
+	defer db.releaseSnapshot(se)
+	value, err = db.get(nil, nil, key, se.seq, ro, dst)
+	if err != nil {
+		return dst, err
+	}
+	return value, nil
+}
+
+// GetMulti gets the values for the given keys, as Get would for each of
+// them from a single snapshot of the DB, but probing the filter of each
+// 'sorted table' once for all the keys it may contain, see
+// filter.BatchFilter. values[i] is the value of keys[i], or nil if the DB
+// does not contain it.
+//
+// The returned slices are their own copies, it is safe to modify their
+// contents. It is safe to modify the contents of the arguments after
+// GetMulti returns.
+func (db *DB) GetMulti(keys [][]byte, ro *opt.ReadOptions) (values [][]byte, err error) {
+	if span := db.startSpan("leveldb.GetMulti"); span != nil {
+		span.SetAttribute("keys", int64(len(keys)))
+		defer func() { endMultiReadSpan(span, values, err) }()
+	}
+	if l := db.latency; l != nil {
+		defer l.get.since(time.Now())
+	}
+
+	err = db.ok()
+	if err != nil {
+		return
+	}
+	if t := db.tracing(); t != nil {
+		for _, key := range keys {
+			t.key(traceGet, key)
+		}
+	}
 
 	se := db.acquireSnapshot()
 	defer db.releaseSnapshot(se)
-	return db.get(nil, nil, key, se.seq, ro)
+	return db.getMulti(keys, se.seq, ro)
+}
+
+func (db *DB) getMulti(keys [][]byte, seq uint64, ro *opt.ReadOptions) ([][]byte, error) {
+	values := make([][]byte, len(keys))
+	mems := db.getMems()
+	defer func() {
+		for _, m := range mems {
+			m.decref()
+		}
+	}()
+
+	// The keys not found in the memdbs, and their index in keys.
+	var (
+		ikeys []internalKey
+		index []int
+	)
+next:
+	for i, key := range keys {
+		ikey := makeInternalKey(nil, key, seq, keyTypeSeek)
+		for _, m := range mems {
+			if ok, mv, me := memGet(m.Memtable, ikey, db.s.icmp); ok {
+				switch me {
+				case nil:
+					values[i] = append([]byte{}, mv...)
+				case ErrNotFound:
+				default:
+					return nil, me
+				}
+				continue next
+			}
+		}
+		ikeys = append(ikeys, ikey)
+		index = append(index, i)
+	}
+	if len(ikeys) == 0 {
+		return values, nil
+	}
+
+	tvalues, errs := make([][]byte, len(ikeys)), make([]error, len(ikeys))
+	v := db.s.version()
+	cSched := v.getMulti(ikeys, db.userReadOptions(ro), tvalues, errs)
+	v.release()
+	if cSched {
+		// Trigger table compaction.
+		db.compTrigger(db.tcompCmdC)
+	}
+	for j, i := range index {
+		switch errs[j] {
+		case nil:
+			values[i] = tvalues[j]
+			if values[i] == nil {
+				values[i] = []byte{}
+			}
+		case ErrNotFound:
+		default:
+			return nil, errs[j]
+		}
+	}
+	return values, nil
 }
 
 // Has returns true if the DB does contains the given key.
@@ -877,12 +1164,47 @@ func (db *DB) Has(key []byte, ro *opt.ReadOptions) (ret bool, err error) {
 	if err != nil {
 		return
 	}
+	if t := db.tracing(); t != nil {
+		t.key(traceHas, key)
+	}
 
 	se := db.acquireSnapshot()
 	defer db.releaseSnapshot(se)
 	return db.has(nil, nil, key, se.seq, ro)
 }
 
+// SetVerifyBlockChecksum sets whether reads verify the checksum of the
+// 'sorted table' data blocks they read, as given by StrictBlockChecksum.
+// Disabling it trades safety for speed, e.g. for bulk reads of trusted data
+// that was recently verified, and it may be re-enabled anytime. Compaction
+// reads are not affected. Initially verification is enabled, unless the
+// CompactionOnlyBlockChecksum option is set.
+//
+// It is safe to call SetVerifyBlockChecksum concurrently with reads;
+// iterators already created keep the setting they were created with.
+func (db *DB) SetVerifyBlockChecksum(verify bool) {
+	db.s.recordAdmin(AdminSetOption, nil, "VerifyBlockChecksum", verify)
+	if verify {
+		atomic.StoreInt32(&db.skipBlockChecksum, 0)
+	} else {
+		atomic.StoreInt32(&db.skipBlockChecksum, 1)
+	}
+}
+
+// Returns the options of a user read, with block checksum verification
+// skipped if disabled by SetVerifyBlockChecksum.
+func (db *DB) userReadOptions(ro *opt.ReadOptions) *opt.ReadOptions {
+	if atomic.LoadInt32(&db.skipBlockChecksum) == 0 || ro.GetSkipBlockChecksum() {
+		return ro
+	}
+	nro := &opt.ReadOptions{}
+	if ro != nil {
+		*nro = *ro
+	}
+	nro.SkipBlockChecksum = true
+	return nro
+}
+
 // NewIterator returns an iterator for the latest snapshot of the
 // underlying DB.
 // The returned iterator is not safe for concurrent use, but it is safe to use
@@ -912,7 +1234,11 @@ func (db *DB) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Itera
 	defer db.releaseSnapshot(se)
 	// Iterator holds 'version' lock, 'version' is immutable so snapshot
 	// can be released after iterator created.
-	return db.newIterator(nil, nil, se.seq, slice, ro)
+	iter := db.newIterator(nil, nil, se.seq, slice, ro)
+	if t := db.tracing(); t != nil {
+		return t.newIter(iter, slice)
+	}
+	return iter
 }
 
 // GetSnapshot returns a latest snapshot of the underlying DB. A snapshot
@@ -937,18 +1263,39 @@ func (db *DB) GetSnapshot() (*Snapshot, error) {
 //		Returns statistics of the underlying DB.
 //	leveldb.iostats
 //		Returns statistics of effective disk read and write.
+//	leveldb.cipherstats
+//		Returns the time spent encrypting and decrypting, and the bytes
+//		processed, for each file type.
 //	leveldb.writedelay
 //		Returns cumulative write delay caused by compaction.
 //	leveldb.sstables
 //		Returns sstables list for each level.
+//	leveldb.lsm-shape
+//		Returns the size, compaction score, oldest table age and reads of
+//		each level, see Levels.
+//	leveldb.lsm-shape-json
+//		Returns the result of Levels as JSON.
 //	leveldb.blockpool
 //		Returns block pool stats.
 //	leveldb.cachedblock
 //		Returns size of cached block.
+//	leveldb.blockcachestats
+//		Returns block cache hits, misses, inserts, evictions, size and
+//		size of the blocks in use.
+//	leveldb.filecachestats
+//		Returns the same statistics for the open files cache.
+//	leveldb.compressedcachedblock
+//		Returns size of compressed cached block.
+//	leveldb.pinnedblock
+//		Returns size of pinned index and filter blocks.
 //	leveldb.openedtables
 //		Returns number of opened tables.
 //	leveldb.alivesnaps
 //		Returns number of alive snapshots.
+//	leveldb.snapshots
+//		Returns the number of alive and expired snapshots, then the
+//		sequence number and age of each alive snapshot, oldest first,
+//		with the stack of its caller if TrackSnapshots is set.
 //	leveldb.aliveiters
 //		Returns number of alive iterators.
 func (db *DB) GetProperty(name string) (value string, err error) {
@@ -1008,6 +1355,13 @@ func (db *DB) GetProperty(name string) (value string, err error) {
 		value = fmt.Sprintf("Read(MB):%.5f Write(MB):%.5f",
 			float64(db.s.stor.reads())/1048576.0,
 			float64(db.s.stor.writes())/1048576.0)
+	case p == "cipherstats":
+		for _, ft := range []storage.FileType{storage.TypeManifest, storage.TypeJournal, storage.TypeTable} {
+			cs := db.s.stor.cipherStats(ft)
+			value += fmt.Sprintf("%s Encrypt(MB):%.5f Encrypt(sec):%.5f Decrypt(MB):%.5f Decrypt(sec):%.5f\n", ft,
+				float64(cs.EncryptBytes)/1048576.0, cs.EncryptDuration.Seconds(),
+				float64(cs.DecryptBytes)/1048576.0, cs.DecryptDuration.Seconds())
+		}
 	case p == "writedelay":
 		writeDelayN, writeDelay := atomic.LoadInt32(&db.cWriteDelayN), time.Duration(atomic.LoadInt64(&db.cWriteDelay))
 		paused := atomic.LoadInt32(&db.inWritePaused) == 1
@@ -1019,6 +1373,10 @@ func (db *DB) GetProperty(name string) (value string, err error) {
 				value += fmt.Sprintf("%d:%d[%q .. %q]\n", t.fd.Num, t.size, t.imin, t.imax)
 			}
 		}
+	case p == "lsm-shape":
+		value = formatLSMShape(db.Levels(), time.Now())
+	case p == "lsm-shape-json":
+		value, err = formatLSMShapeJSON(db.Levels())
 	case p == "blockpool":
 		value = fmt.Sprintf("%v", db.s.tops.blockBuffer)
 	case p == "cachedblock":
@@ -1027,10 +1385,32 @@ func (db *DB) GetProperty(name string) (value string, err error) {
 		} else {
 			value = "<nil>"
 		}
+	case p == "blockcachestats":
+		if db.s.tops.blockCache != nil {
+			value = formatCacheStats(db.s.tops.blockCache.GetStats())
+		} else {
+			value = "<nil>"
+		}
+	case p == "filecachestats":
+		value = formatCacheStats(db.s.tops.fileCache.GetStats())
+	case p == "compressedcachedblock":
+		if db.s.tops.compressedBlockCache != nil {
+			value = fmt.Sprintf("%d", db.s.tops.compressedBlockCache.Size())
+		} else {
+			value = "<nil>"
+		}
+	case p == "pinnedblock":
+		value = fmt.Sprintf("%d", atomic.LoadInt64(&db.s.tops.pinned))
 	case p == "openedtables":
 		value = fmt.Sprintf("%d", db.s.tops.fileCache.Size())
 	case p == "alivesnaps":
 		value = fmt.Sprintf("%d", atomic.LoadInt32(&db.aliveSnaps))
+	case p == "snapshots":
+		recs := db.heldSnapshots()
+		value = fmt.Sprintf("Alive:%d Expired:%d\n", len(recs), atomic.LoadInt64(&db.cExpiredSnaps))
+		for _, rec := range recs {
+			value += rec.String() + "\n"
+		}
 	case p == "aliveiters":
 		value = fmt.Sprintf("%d", atomic.LoadInt32(&db.aliveIters))
 	default:
@@ -1049,6 +1429,12 @@ type DBStats struct {
 	AliveSnapshots int32
 	AliveIterators int32
 
+	// OldestSnapshotAge is the age of the oldest alive snapshot, and
+	// ExpiredSnapshots the number of snapshots released for being held
+	// longer than MaxSnapshotLifetime.
+	OldestSnapshotAge time.Duration
+	ExpiredSnapshots  int64
+
 	IOWrite uint64
 	IORead  uint64
 
@@ -1058,6 +1444,11 @@ type DBStats struct {
 	FileCache  cache.Stats
 	BlockCache cache.Stats
 
+	// Time and bytes spent encrypting and decrypting, by file type.
+	ManifestCipher CipherStats
+	JournalCipher  CipherStats
+	TableCipher    CipherStats
+
 	LevelSizes        Sizes
 	LevelTablesCounts []int
 	LevelRead         Sizes
@@ -1068,6 +1459,16 @@ type DBStats struct {
 	Level0Comp    uint32
 	NonLevel0Comp uint32
 	SeekComp      uint32
+
+	// Latency histograms, only maintained if the LatencyHistograms option
+	// is set. See also ResetLatencyStats.
+	GetLatency        LatencyStats
+	WriteLatency      LatencyStats
+	SyncWriteLatency  LatencyStats
+	SeekLatency       LatencyStats
+	NextLatency       LatencyStats
+	FlushLatency      LatencyStats
+	CompactionLatency LatencyStats
 }
 
 // Stats populates s with database statistics.
@@ -1097,8 +1498,19 @@ func (db *DB) Stats(s *DBStats) error {
 		s.BlockCache = cache.Stats{}
 	}
 
+	s.ManifestCipher = db.s.stor.cipherStats(storage.TypeManifest)
+	s.JournalCipher = db.s.stor.cipherStats(storage.TypeJournal)
+	s.TableCipher = db.s.stor.cipherStats(storage.TypeTable)
+
 	s.AliveIterators = atomic.LoadInt32(&db.aliveIters)
+	// The expired snapshots are released first.
+	if recs := db.heldSnapshots(); len(recs) > 0 {
+		s.OldestSnapshotAge = time.Since(recs[0].created)
+	} else {
+		s.OldestSnapshotAge = 0
+	}
 	s.AliveSnapshots = atomic.LoadInt32(&db.aliveSnaps)
+	s.ExpiredSnapshots = atomic.LoadInt64(&db.cExpiredSnaps)
 
 	s.LevelDurations = s.LevelDurations[:0]
 	s.LevelRead = s.LevelRead[:0]
@@ -1122,6 +1534,9 @@ func (db *DB) Stats(s *DBStats) error {
 	s.Level0Comp = atomic.LoadUint32(&db.level0Comp)
 	s.NonLevel0Comp = atomic.LoadUint32(&db.nonLevel0Comp)
 	s.SeekComp = atomic.LoadUint32(&db.seekComp)
+	if db.latency != nil {
+		db.latency.fillStats(s)
+	}
 	return nil
 }
 
@@ -1164,16 +1579,86 @@ func (db *DB) SizeOf(ranges []util.Range) (Sizes, error) {
 // Close closes the DB. This will also releases any outstanding snapshot,
 // abort any in-flight compaction and discard open transaction.
 //
+// Close first flushes the memtable, syncs the journal and waits for the
+// compactions if set by Options.CloseFlushMemtable, CloseSyncJournal and
+// CloseWaitCompaction, see CloseWithContext.
+//
 // It is not safe to close a DB until all outstanding iterators are released.
 // It is valid to call Close multiple times. Other methods should not be
 // called after the DB has been closed.
 func (db *DB) Close() error {
+	return db.CloseWithContext(context.Background())
+}
+
+// CloseWithContext closes the DB as Close, giving up flushing the memtable,
+// syncing the journal and waiting for the compactions when the context is
+// done. The DB is closed anyway, and the error of the context returned.
+// Flushing the memtable or syncing the journal waits for the open
+// transaction, if any, and stops the writes meanwhile.
+func (db *DB) CloseWithContext(ctx context.Context) error {
+	if db.isClosed() {
+		return ErrClosed
+	}
+	locked, err := db.prepareClose(ctx)
+	if cerr := db.close(locked); err == nil || cerr == ErrClosed {
+		err = cerr
+	}
+	return err
+}
+
+// Flushes the memtable, syncs the journal and waits for the compactions,
+// as set by the options, before closing. It tells whether it holds the
+// write lock, which close then keeps.
+func (db *DB) prepareClose(ctx context.Context) (locked bool, err error) {
+	o := db.s.o
+	if (o.GetCloseFlushMemtable() || o.GetCloseSyncJournal()) && !o.GetReadOnly() {
+		// Lock writer.
+		select {
+		case db.writeLockC <- struct{}{}:
+			locked = true
+		case err = <-db.compPerErrC:
+			if err == ErrReadOnly {
+				err = nil
+			}
+		case <-db.closeC:
+			return false, ErrClosed
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+	if locked && o.GetCloseSyncJournal() && db.journalWriter != nil {
+		if err = db.journalWriter.Sync(); err != nil {
+			return
+		}
+	}
+	if locked && o.GetCloseFlushMemtable() {
+		if mdb := db.getEffectiveMem(); mdb != nil {
+			n := mdb.Len()
+			mdb.decref()
+			if n > 0 {
+				if _, err = db.rotateMem(0, false); err != nil {
+					return
+				}
+			}
+		}
+		if err = db.compTriggerWaitContext(ctx, db.mcompCmdC); err != nil {
+			return
+		}
+	}
+	if o.GetCloseWaitCompaction() {
+		err = db.compTriggerWaitContext(ctx, db.tcompCmdC)
+	}
+	return
+}
+
+// Closes the DB, holding the write lock already if locked.
+func (db *DB) close(locked bool) error {
 	if !db.setClosed() {
 		return ErrClosed
 	}
 
 	start := time.Now()
-	db.log("db@close closing")
+	db.logInfo("db@close closing")
 
 	// Clear the finalizer.
 	runtime.SetFinalizer(db, nil)
@@ -1190,6 +1675,9 @@ func (db *DB) Close() error {
 
 	// Signal all goroutines.
 	close(db.closeC)
+	if db.wal != nil {
+		db.wal.close()
+	}
 
 	// Discard open transaction.
 	if db.tr != nil {
@@ -1197,7 +1685,9 @@ func (db *DB) Close() error {
 	}
 
 	// Acquire writer lock.
-	db.writeLockC <- struct{}{}
+	if !locked {
+		db.writeLockC <- struct{}{}
+	}
 
 	// Wait for all gorotines to exit.
 	db.closeW.Wait()
@@ -1211,12 +1701,14 @@ func (db *DB) Close() error {
 	}
 
 	if db.writeDelayN > 0 {
-		db.logf("db@write was delayed N·%d T·%v", db.writeDelayN, db.writeDelay)
+		db.logWarn("db@write was delayed", "count", db.writeDelayN, "duration", db.writeDelay)
 	}
 
+	db.s.recordAdmin(AdminClose, err, "duration", time.Since(start))
+
 	// Close session.
 	db.s.close()
-	db.logf("db@close done T·%v", time.Since(start))
+	db.logInfo("db@close done", "duration", time.Since(start))
 	db.s.release()
 
 	if db.closer != nil {
@@ -0,0 +1,150 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// AdminEventKind is the kind of an AdminEvent.
+type AdminEventKind string
+
+// Kinds of administrative events.
+const (
+	AdminOpen         AdminEventKind = "open"
+	AdminRecover      AdminEventKind = "recover"
+	AdminClose        AdminEventKind = "close"
+	AdminCompactRange AdminEventKind = "compact-range"
+	AdminSetReadOnly  AdminEventKind = "set-read-only"
+	AdminSetOption    AdminEventKind = "set-option"
+	AdminBackup       AdminEventKind = "backup"
+)
+
+// AdminEvent is an administrative or lifecycle event of a DB, such as its
+// opening or a manual compaction.
+type AdminEvent struct {
+	Time time.Time      `json:"time"`
+	Kind AdminEventKind `json:"kind"`
+	// Details holds the parameters of the event in key=value form.
+	Details string `json:"details,omitempty"`
+	// Error is the error the operation failed with, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// Number of events kept for AdminEvents.
+const adminEventsLen = 64
+
+// adminJournal keeps the last administrative events of a session.
+type adminJournal struct {
+	mu     sync.Mutex
+	events []AdminEvent
+}
+
+func (j *adminJournal) add(e AdminEvent) {
+	j.mu.Lock()
+	if len(j.events) == adminEventsLen {
+		copy(j.events, j.events[1:])
+		j.events = j.events[:adminEventsLen-1]
+	}
+	j.events = append(j.events, e)
+	j.mu.Unlock()
+}
+
+func (j *adminJournal) get() []AdminEvent {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]AdminEvent(nil), j.events...)
+}
+
+// Records an administrative event, persisting it to the storage if the
+// AdminLog option is set.
+func (s *session) recordAdmin(kind AdminEventKind, err error, kv ...interface{}) {
+	e := AdminEvent{Time: time.Now(), Kind: kind}
+	if len(kv) > 0 {
+		var buf bytes.Buffer
+		writeLogFields(&buf, kv)
+		e.Details = buf.String()[1:]
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	s.admin.add(e)
+
+	if !s.o.GetAdminLog() || s.o.GetReadOnly() {
+		return
+	}
+	al, ok := s.stor.Storage.(storage.AdminLogger)
+	if !ok {
+		return
+	}
+	rec, merr := json.Marshal(e)
+	if merr != nil {
+		return
+	}
+	if aerr := al.AppendAdminLog(append(rec, '\n')); aerr != nil {
+		s.logWarn("admin@log", "kind", string(kind), "err", aerr)
+	}
+}
+
+// AdminEvents returns the administrative events of the DB since it was
+// opened, at most the last 64, oldest first. See ReadAdminLog for the
+// events persisted by previous sessions.
+func (db *DB) AdminEvents() []AdminEvent {
+	return db.s.admin.get()
+}
+
+// AdminLog returns the administrative events persisted to the storage of
+// the DB, see ReadAdminLog.
+func (db *DB) AdminLog() ([]AdminEvent, error) {
+	return ReadAdminLog(db.s.stor.Storage)
+}
+
+// ReadAdminLog returns the administrative events persisted to the given
+// storage by DBs opened with the AdminLog option, oldest first. It returns
+// no events if the storage doesn't implement storage.AdminLogger or nothing
+// was logged. A truncated last event, e.g. due to a crash while logging it,
+// is ignored.
+func ReadAdminLog(stor storage.Storage) ([]AdminEvent, error) {
+	al, ok := stor.(storage.AdminLogger)
+	if !ok {
+		return nil, nil
+	}
+	r, err := al.OpenAdminLog()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer r.Close()
+
+	var events []AdminEvent
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var e AdminEvent
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, errors.New("leveldb: corrupted admin log: " + err.Error())
+		}
+		events = append(events, e)
+	}
+}
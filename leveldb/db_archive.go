@@ -0,0 +1,258 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/journal"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Interval between the attempts to archive a journal file.
+const journalArchiveRetry = 10 * time.Second
+
+// Removes an obsolete journal file, or queues it to be archived then
+// removed by jArchive if the JournalArchive option is set, so that a slow or
+// unavailable archive holds up neither the flushes nor the opening of the DB.
+func (db *DB) removeJournal(fd storage.FileDesc) error {
+	if db.s.o.GetJournalArchive() == nil {
+		return db.s.stor.Remove(fd)
+	}
+	db.archiveMu.Lock()
+	for _, qfd := range db.archiveQ {
+		if qfd == fd {
+			// Queued by the journal recovery, then found by the janitor.
+			db.archiveMu.Unlock()
+			return nil
+		}
+	}
+	db.archiveQ = append(db.archiveQ, fd)
+	db.archiveMu.Unlock()
+	select {
+	case db.archiveC <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Archives the queued journal files in order, removing each once copied.
+// A journal failing to be archived stays in the DB and is retried, as are
+// the ones queued after it, so that the archive has no gap. On close, the
+// queue is drained without retrying; the journals left are archived once
+// the DB is reopened.
+func (db *DB) jArchive() {
+	defer db.closeW.Done()
+
+	ar := db.s.o.GetJournalArchive()
+	var retry <-chan time.Time
+	for {
+		closing := false
+		select {
+		case <-db.archiveC:
+		case <-retry:
+		case <-db.closeC:
+			closing = true
+		}
+		retry = nil
+		for {
+			db.archiveMu.Lock()
+			if len(db.archiveQ) == 0 {
+				db.archiveMu.Unlock()
+				break
+			}
+			fd := db.archiveQ[0]
+			db.archiveMu.Unlock()
+
+			if err := db.archiveJournal(ar, fd); err != nil {
+				db.logWarn("journal@archive archiving", "num", fd.Num, "err", err)
+				retry = time.After(journalArchiveRetry)
+				break
+			}
+			db.logInfo("journal@archive archived", "num", fd.Num)
+			db.archiveMu.Lock()
+			db.archiveQ = db.archiveQ[1:]
+			db.archiveMu.Unlock()
+			if err := db.s.stor.Remove(fd); err != nil {
+				db.logWarn("journal@remove removing", "num", fd.Num, "err", err)
+			} else {
+				db.logInfo("journal@remove removed", "num", fd.Num)
+			}
+			db.pruneJournalArchive(ar)
+		}
+		if closing {
+			return
+		}
+	}
+}
+
+// Copies the journal file to the archive, as stored.
+func (db *DB) archiveJournal(ar storage.Storage, fd storage.FileDesc) error {
+	_, err := copyFile(ar, fd, db.s.stor.Storage, fd, !db.s.o.GetNoSync())
+	return err
+}
+
+// Deletes the oldest archived journals beyond the retention limits.
+func (db *DB) pruneJournalArchive(ar storage.Storage) {
+	maxFiles, maxSize := db.s.o.GetJournalArchiveMaxFiles(), db.s.o.GetJournalArchiveMaxSize()
+	if maxFiles == 0 && maxSize == 0 {
+		return
+	}
+	journals, err := ListJournalArchive(ar)
+	if err != nil {
+		db.logWarn("journal@archive listing", "err", err)
+		return
+	}
+	var size int64
+	for _, j := range journals {
+		size += j.Size
+	}
+	for i := 0; i < len(journals)-1; i++ {
+		if (maxFiles == 0 || len(journals)-i <= maxFiles) && (maxSize == 0 || size <= maxSize) {
+			break
+		}
+		fd := storage.FileDesc{Type: storage.TypeJournal, Num: journals[i].Num}
+		if err := ar.Remove(fd); err != nil {
+			db.logWarn("journal@archive removing", "num", fd.Num, "err", err)
+			return
+		}
+		db.logInfo("journal@archive removed", "num", fd.Num)
+		size -= journals[i].Size
+	}
+}
+
+// ArchivedJournal is a journal file of an archive, see the JournalArchive
+// option.
+type ArchivedJournal struct {
+	Num  int64
+	Size int64
+}
+
+// ListJournalArchive returns the journal files of an archive, oldest first.
+func ListJournalArchive(ar storage.Storage) ([]ArchivedJournal, error) {
+	fds, err := ar.List(storage.TypeJournal)
+	if err != nil {
+		return nil, err
+	}
+	sortFds(fds)
+	journals := make([]ArchivedJournal, 0, len(fds))
+	for _, fd := range fds {
+		r, err := ar.Open(fd)
+		if err != nil {
+			return nil, err
+		}
+		size, err := r.Seek(0, io.SeekEnd)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		journals = append(journals, ArchivedJournal{Num: fd.Num, Size: size})
+	}
+	return journals, nil
+}
+
+// ReadJournalArchive decodes the journal files of an archive into their
+// write batches, calling fn for each of them in order, with the sequence
+// number of its first record. The files are decrypted as set by
+// EncryptionVersion and EncryptionKey.
+//
+// Corrupted parts of the files, e.g. a write torn by a crash, are skipped,
+// as when the DB recovers its journals. The sequence numbers may skip the
+// writes which don't go through the journal, such as transactions.
+// ReadJournalArchive stops and returns the error of fn, if any.
+func ReadJournalArchive(ar storage.Storage, fn func(seq uint64, b *Batch) error) error {
+	fds, err := ar.List(storage.TypeJournal)
+	if err != nil {
+		return err
+	}
+	sortFds(fds)
+	var (
+		buf   util.Buffer
+		codec journalCodec
+	)
+	for _, fd := range fds {
+		if err := readArchivedJournal(ar, fd, &buf, &codec, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readArchivedJournal(ar storage.Storage, fd storage.FileDesc, buf *util.Buffer, codec *journalCodec, fn func(seq uint64, b *Batch) error) error {
+	r, err := newIStorage(ar).Open(fd)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	jr := journal.NewReader(r, &journalDropper{}, false, true)
+	jr.SetLogNum(uint32(fd.Num))
+	for {
+		rec, err := jr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err == nil {
+			buf.Reset()
+			_, err = buf.ReadFrom(rec)
+		}
+		if err == io.ErrUnexpectedEOF {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		data, err := codec.decode(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		seq, batchLen, err := decodeBatchHeader(data)
+		if err != nil {
+			return err
+		}
+		b := new(Batch)
+		if err := b.decode(append([]byte(nil), data[batchHeaderLen:]...), batchLen); err != nil {
+			return err
+		}
+		if err := fn(seq, b); err != nil {
+			return err
+		}
+	}
+}
+
+var errReplayDone = errors.New("leveldb: replay done")
+
+// ReplayJournalArchive writes to the DB the batches of the archived
+// journals starting after the sequence number from, up to the last one
+// ending at or before to, see ReadJournalArchive. It brings e.g. a DB
+// restored from a backup of the archiving DB, taken at from, see
+// Snapshot.Seq, to the state of the archiving DB at to. It returns the
+// sequence number, of the archiving DB, of the last write applied, or from
+// if none.
+func (db *DB) ReplayJournalArchive(ar storage.Storage, from, to uint64) (uint64, error) {
+	last := from
+	err := ReadJournalArchive(ar, func(seq uint64, b *Batch) error {
+		if seq <= from || b.Len() == 0 {
+			return nil
+		}
+		end := seq + uint64(b.Len()) - 1
+		if end > to {
+			return errReplayDone
+		}
+		if err := db.Write(b, nil); err != nil {
+			return err
+		}
+		last = end
+		return nil
+	})
+	if err == errReplayDone {
+		err = nil
+	}
+	return last, err
+}
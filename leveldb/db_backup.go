@@ -0,0 +1,604 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/journal"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// BackupInfo describes a backup made by DB.Backup.
+type BackupInfo struct {
+	// Seq is the sequence number of the last write the backup holds.
+	Seq uint64
+
+	// Files and Size are the number and the total size of the files of the
+	// backup, and CopiedFiles and CopiedSize those copied by this backup,
+	// the others being kept from the previous one.
+	Files       int
+	Size        int64
+	CopiedFiles int
+	CopiedSize  int64
+
+	Duration time.Duration
+}
+
+// BackupOptions holds the optional parameters of DB.Backup and
+// RestoreBackup.
+type BackupOptions struct {
+	// Encryption is the encryption of the backup, independent of that of
+	// the DB: the files are re-encrypted on the fly. A backup records a
+	// fingerprint of its encryption, so that the next one copies all the
+	// tables again, rather than keeping those of the previous one, if the
+	// encryption changed.
+	//
+	// The default is nil, which means the backup is encrypted as the DB.
+	Encryption *Encryption
+}
+
+func (o *BackupOptions) validate() error {
+	if o == nil || o.Encryption == nil {
+		return nil
+	}
+	if err := o.Encryption.validate(); err != nil {
+		return fmt.Errorf("%w: BackupOptions.Encryption: %v", ErrEncryption, err)
+	}
+	return nil
+}
+
+func (o *BackupOptions) encryption() *Encryption {
+	if o == nil || o.Encryption == nil {
+		return dbEncryption()
+	}
+	return o.Encryption
+}
+
+// Backup makes a backup of the DB into the given storage, e.g. a
+// storage.OpenFile directory or an objstore bucket, holding the writes
+// committed before Backup was called. The backup is a DB of its own: it's
+// restored by opening the storage, or a copy of it.
+//
+// Backups are incremental: the tables are immutable, so only those missing
+// from the previous backup in the storage are copied, along with the
+// journals of the writes not yet flushed to tables. All the tables are
+// copied if the previous backup has another encryption. The files of the
+// previous backup which are no longer needed are then deleted. The backup
+// replaces the previous one atomically, so that the storage holds either
+// of them if Backup fails midway. The files are encrypted as the DB, or as
+// set by the options, which may be nil; see RestoreBackup.
+//
+// Backup locks the storage, which must not be that of a DB, while running.
+// It doesn't block the writes but for a journal rotation.
+func (db *DB) Backup(dst storage.Storage, o *BackupOptions) (info *BackupInfo, err error) {
+	if err := db.ok(); err != nil {
+		return nil, err
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	defer func() {
+		db.s.recordAdmin(AdminBackup, err, "duration", time.Since(start))
+	}()
+	lock, err := dst.Lock()
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	info = &BackupInfo{}
+	have, err := listSizes(dst, storage.TypeTable)
+	if err != nil {
+		return nil, err
+	}
+	sync := !db.s.o.GetNoSync()
+	from, to := dbEncryption(), o.encryption()
+	fp := to.fingerprint()
+	if prev, ok := backupFingerprint(dst, to); len(have) > 0 && (!ok || prev != nil && !bytes.Equal(prev, fp)) {
+		// Don't mix the keys of two encryptions in a backup.
+		db.logInfo("db@backup encryption changed, copying all tables", "tables", len(have))
+		have = nil
+	}
+	var journals []storage.FileDesc
+	rec, mfd, seq, err := db.checkpoint(func(f checkpointFile) error {
+		if f.fd.Type == storage.TypeJournal {
+			journals = append(journals, f.fd)
+		} else if size, ok := have[f.fd.Num]; ok && size == f.size {
+			info.Files++
+			info.Size += size
+			return nil
+		}
+		return backupFile(dst, f.fd, info, sync, func(w io.Writer) (int64, error) {
+			return f.copy(newRecryptWriter(w, from, to))
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	info.Seq = seq
+	rec.setBackupFingerprint(fp)
+	if err := writeBackupManifest(dst, mfd, rec, sync, to); err != nil {
+		return nil, err
+	}
+
+	// Delete the files of the previous backup.
+	fds, err := dst.List(storage.TypeManifest | storage.TypeJournal | storage.TypeTable | storage.TypeTemp)
+	if err != nil {
+		return nil, err
+	}
+	keep := make(map[storage.FileDesc]bool)
+	keep[mfd] = true
+	for _, fd := range journals {
+		keep[fd] = true
+	}
+	for _, t := range rec.addedTables {
+		keep[storage.FileDesc{Type: storage.TypeTable, Num: t.num}] = true
+	}
+	for _, fd := range fds {
+		if !keep[fd] {
+			if err := dst.Remove(fd); err != nil {
+				return nil, err
+			}
+		}
+	}
+	info.Duration = time.Since(start)
+	db.logInfo("db@backup done", "seq", seq, "files", info.Files, "copied", info.CopiedFiles, "size", info.CopiedSize, "duration", info.Duration)
+	return info, nil
+}
+
+// A file of a checkpoint, see DB.checkpoint.
+type checkpointFile struct {
+	fd   storage.FileDesc
+	size int64
+	// Copies the file as stored, i.e. encrypted if the DB is.
+	copy func(w io.Writer) (int64, error)
+}
+
+// Makes a consistent copy of the DB, calling fn for each of its files: the
+// journals of the writes not yet flushed to tables, then the tables. It
+// returns the manifest record of the copy, the descriptor of its manifest,
+// which takes a file number not used by the DB yet, and the sequence
+// number of the last write it holds. It doesn't block the writes but for a
+// journal rotation.
+func (db *DB) checkpoint(fn func(f checkpointFile) error) (rec *sessionRecord, mfd storage.FileDesc, seq uint64, err error) {
+	// Rotate the journal, so that the journals to copy are complete.
+	select {
+	case db.writeLockC <- struct{}{}:
+	case err := <-db.compPerErrC:
+		return nil, mfd, 0, err
+	case <-db.closeC:
+		return nil, mfd, 0, ErrClosed
+	}
+	seq = db.seq
+	_, err = db.rotateMem(0, false)
+	<-db.writeLockC
+	if err != nil {
+		return nil, mfd, 0, err
+	}
+
+	// The commits of flushes and compactions are locked out while the
+	// journals are copied, so that the tables of the version and the
+	// journals match.
+	rec = &sessionRecord{}
+	db.compCommitLk.Lock()
+	v := db.s.version()
+	defer v.release()
+	db.s.fillRecord(rec, true)
+	if db.s.stPrevJournalNum != 0 {
+		rec.setPrevJournalNum(db.s.stPrevJournalNum)
+	}
+	var journals []storage.FileDesc
+	db.memMu.RLock()
+	for _, mem := range db.frozenMems {
+		if num := mem.journalFd.Num; num >= rec.journalNum || num == rec.prevJournalNum {
+			journals = append(journals, mem.journalFd)
+		}
+	}
+	db.memMu.RUnlock()
+	for _, fd := range journals {
+		if err = db.checkpointJournal(fd, fn); err != nil {
+			break
+		}
+	}
+	db.compCommitLk.Unlock()
+	if err != nil {
+		return nil, mfd, 0, err
+	}
+
+	// The tables are kept by the version reference meanwhile.
+	v.fillRecord(rec)
+	for _, tables := range v.levels {
+		for _, t := range tables {
+			t := t
+			if err := fn(checkpointFile{t.fd, t.size, func(w io.Writer) (int64, error) {
+				return db.s.tops.copyFile(t, w)
+			}}); err != nil {
+				return nil, mfd, 0, err
+			}
+		}
+	}
+
+	mfd = storage.FileDesc{Type: storage.TypeManifest, Num: rec.nextFileNum}
+	rec.setNextFileNum(mfd.Num + 1)
+	return rec, mfd, seq, nil
+}
+
+func (db *DB) checkpointJournal(fd storage.FileDesc, fn func(f checkpointFile) error) error {
+	r, err := db.s.stor.Storage.Open(fd)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	size, err := r.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = r.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return err
+	}
+	return fn(checkpointFile{fd, size, func(w io.Writer) (int64, error) {
+		return io.Copy(w, r)
+	}})
+}
+
+// Copies a file of the DB to the backup, under a temporary name first so
+// that the backup never holds a partial copy, unless the storage creates
+// files atomically.
+func backupFile(dst storage.Storage, fd storage.FileDesc, info *BackupInfo, sync bool, copy func(w io.Writer) (int64, error)) error {
+	ac, atomic := dst.(storage.AtomicCreator)
+	cfd := fd
+	if !atomic {
+		cfd = storage.FileDesc{Type: storage.TypeTemp, Num: fd.Num}
+	}
+	w, err := dst.Create(cfd)
+	if err != nil {
+		return err
+	}
+	n, err := copy(w)
+	if err == nil && sync {
+		err = w.Sync()
+	}
+	if err != nil && atomic {
+		ac.Abort(w)
+		return err
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && !atomic {
+		err = dst.Rename(cfd, fd)
+	}
+	if err != nil {
+		if !atomic {
+			dst.Remove(cfd)
+		}
+		return err
+	}
+	info.Files++
+	info.Size += n
+	info.CopiedFiles++
+	info.CopiedSize += n
+	return nil
+}
+
+// Copies a file between storages, as stored, returning its size.
+func copyFile(dst storage.Storage, dfd storage.FileDesc, src storage.Storage, sfd storage.FileDesc, sync bool) (int64, error) {
+	r, err := src.Open(sfd)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	w, err := dst.Create(dfd)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(w, r)
+	if err == nil && sync {
+		err = w.Sync()
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// Returns the sizes of the files of a type of the storage, by file number.
+func listSizes(stor storage.Storage, ft storage.FileType) (map[int64]int64, error) {
+	fds, err := stor.List(ft)
+	if err != nil {
+		return nil, err
+	}
+	sizes := make(map[int64]int64, len(fds))
+	for _, fd := range fds {
+		r, err := stor.Open(fd)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		size, err := r.Seek(0, io.SeekEnd)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		sizes[fd.Num] = size
+	}
+	return sizes, nil
+}
+
+// Encodes the manifest of a copy of the DB, encrypted as given.
+func encodeManifest(fd storage.FileDesc, rec *sessionRecord, e *Encryption) ([]byte, error) {
+	var buf bytes.Buffer
+	jw := journal.NewWriter(&iStorageWriter{nopSyncWriter{&buf}, newIStorage(nil), e.cipher(), 0, fd})
+	w, err := jw.Next()
+	if err != nil {
+		return nil, err
+	}
+	if err := rec.encode(w); err != nil {
+		return nil, err
+	}
+	if err := jw.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type nopSyncWriter struct {
+	io.Writer
+}
+
+func (nopSyncWriter) Sync() error  { return nil }
+func (nopSyncWriter) Close() error { return nil }
+
+// Writes the manifest of a backup, and makes it current.
+func writeBackupManifest(dst storage.Storage, fd storage.FileDesc, rec *sessionRecord, sync bool, e *Encryption) error {
+	data, err := encodeManifest(fd, rec, e)
+	if err != nil {
+		return err
+	}
+	w, err := dst.Create(fd)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	if err == nil && sync {
+		err = w.Sync()
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		dst.Remove(fd)
+		return err
+	}
+	return dst.SetMeta(fd)
+}
+
+// Returns the encryption fingerprint recorded by the backup in the storage,
+// read with the given encryption. It returns false if the manifest can't be
+// read, e.g. if the backup has another encryption, and a nil fingerprint if
+// none is recorded, e.g. if the backup was opened in place since.
+func backupFingerprint(dst storage.Storage, e *Encryption) ([]byte, bool) {
+	mfd, err := dst.GetMeta()
+	if err != nil {
+		return nil, false
+	}
+	r, err := dst.Open(mfd)
+	if err != nil {
+		return nil, false
+	}
+	defer r.Close()
+	jr := journal.NewReader(&iStorageReader{r, newIStorage(nil), e.cipher(), 0, mfd}, nil, true, true)
+	x, err := jr.Next()
+	if err != nil {
+		return nil, false
+	}
+	rec := &sessionRecord{}
+	if err := rec.decode(x); err != nil {
+		return nil, false
+	}
+	return rec.fingerprint, true
+}
+
+// RestoreBackup copies a backup made by DB.Backup into the storage of a DB
+// to open, re-encrypting the files from the encryption of the backup, as
+// set by the options, to that of the DB, see EncryptionVersion. The
+// options may be nil, if the backup is encrypted as the DB; it may then
+// be opened in place instead.
+func RestoreBackup(src, dst storage.Storage, o *BackupOptions) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+	slock, err := src.Lock()
+	if err != nil {
+		return err
+	}
+	defer slock.Unlock()
+	dlock, err := dst.Lock()
+	if err != nil {
+		return err
+	}
+	defer dlock.Unlock()
+
+	mfd, err := src.GetMeta()
+	if err != nil {
+		return err
+	}
+	fds, err := src.List(storage.TypeManifest | storage.TypeJournal | storage.TypeTable)
+	if err != nil {
+		return err
+	}
+	from, to := o.encryption(), dbEncryption()
+	for _, fd := range fds {
+		if err := restoreFile(src, dst, fd, from, to); err != nil {
+			return err
+		}
+	}
+	return dst.SetMeta(mfd)
+}
+
+func restoreFile(src, dst storage.Storage, fd storage.FileDesc, from, to *Encryption) error {
+	r, err := src.Open(fd)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := dst.Create(fd)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(newRecryptWriter(w, from, to), r)
+	if err == nil {
+		err = w.Sync()
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Name of the entry of an archive naming its manifest, as the CURRENT file
+// of a DB.
+const archiveCurrent = "CURRENT"
+
+// ExportArchive writes a consistent copy of the DB to w, as a tar stream
+// of its files as stored, i.e. encrypted if the DB is, and of a manifest.
+// The copy is materialized by ImportArchive, e.g. to move the DB to
+// another host. ExportArchive holds the writes committed before it was
+// called, and doesn't block the writes but for a journal rotation.
+func (db *DB) ExportArchive(w io.Writer) (info *BackupInfo, err error) {
+	if err := db.ok(); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	tw := tar.NewWriter(w)
+	entry := func(name string, size int64) error {
+		return tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Size:     size,
+			Mode:     0644,
+			ModTime:  start,
+		})
+	}
+	info = &BackupInfo{}
+	rec, mfd, seq, err := db.checkpoint(func(f checkpointFile) error {
+		if err := entry(f.fd.String(), f.size); err != nil {
+			return err
+		}
+		n, err := f.copy(tw)
+		if err == nil && n != f.size {
+			err = fmt.Errorf("leveldb: %s changed while archived", f.fd)
+		}
+		info.Files++
+		info.Size += n
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := encodeManifest(mfd, rec, dbEncryption())
+	if err != nil {
+		return nil, err
+	}
+	current := []byte(mfd.String() + "\n")
+	for _, e := range []struct {
+		name string
+		data []byte
+	}{{mfd.String(), manifest}, {archiveCurrent, current}} {
+		if err := entry(e.name, int64(len(e.data))); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(e.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	info.Files++
+	info.Size += int64(len(manifest))
+	info.CopiedFiles, info.CopiedSize = info.Files, info.Size
+	info.Seq = seq
+	info.Duration = time.Since(start)
+	db.logInfo("db@archive exported", "seq", seq, "files", info.Files, "size", info.Size, "duration", info.Duration)
+	return info, nil
+}
+
+// ImportArchive materializes a copy of a DB written by DB.ExportArchive
+// into the given storage, which must not hold a DB, to be opened then.
+func ImportArchive(r io.Reader, dst storage.Storage) error {
+	lock, err := dst.Lock()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	if _, err := dst.GetMeta(); err == nil {
+		return errors.New("leveldb: importing an archive into an existing DB")
+	}
+
+	tr := tar.NewReader(r)
+	var current storage.FileDesc
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Name == archiveCurrent {
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			fd, ok := storage.ParseFileDesc(strings.TrimSuffix(string(data), "\n"))
+			if !ok || fd.Type != storage.TypeManifest {
+				return errors.New("leveldb: invalid archive manifest name " + strconv.Quote(string(data)))
+			}
+			current = fd
+			continue
+		}
+		fd, ok := storage.ParseFileDesc(hdr.Name)
+		if !ok || fd.Type == storage.TypeTemp {
+			return errors.New("leveldb: invalid archive entry " + strconv.Quote(hdr.Name))
+		}
+		if err := importArchiveFile(dst, fd, tr); err != nil {
+			return err
+		}
+	}
+	if current.Zero() {
+		return errors.New("leveldb: archive without manifest")
+	}
+	return dst.SetMeta(current)
+}
+
+func importArchiveFile(dst storage.Storage, fd storage.FileDesc, r io.Reader) error {
+	w, err := dst.Create(fd)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	if err == nil {
+		err = w.Sync()
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"sort"
+
+	"github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/table"
+)
+
+const blockCacheDumpMagic = "leveldb.blockcache.1\n"
+
+var errBlockCacheDump = errors.New("leveldb: invalid block cache dump")
+
+type cachedBlockRec struct {
+	num int64
+	table.CachedBlock
+}
+
+func sortCachedBlockRecs(recs []cachedBlockRec) {
+	sort.Slice(recs, func(i, j int) bool {
+		if recs[i].num != recs[j].num {
+			return recs[i].num < recs[j].num
+		}
+		return recs[i].Offset < recs[j].Offset
+	})
+}
+
+// DumpBlockCache writes the identities of the blocks currently held in the
+// block cache to w, to be passed to WarmBlockCache after the DB is reopened.
+// Only the identities are written, not the block contents, so the dump is
+// small. It is meant to be called just before the DB is closed.
+//
+// It is safe to call DumpBlockCache concurrently with other DB methods,
+// though blocks cached or evicted meanwhile may or may not be included.
+func (db *DB) DumpBlockCache(w io.Writer) error {
+	if err := db.ok(); err != nil {
+		return err
+	}
+
+	var recs []cachedBlockRec
+	if bc := db.s.tops.blockCache; bc != nil {
+		bc.EnumerateKeys(func(ns, key uint64) {
+			ch := bc.Get(ns, key, nil)
+			if ch == nil {
+				return
+			}
+			if cb, ok := table.GetCachedBlock(ch.Value()); ok {
+				recs = append(recs, cachedBlockRec{int64(ns), cb})
+			}
+			ch.Release()
+		})
+	}
+	sortCachedBlockRecs(recs)
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(blockCacheDumpMagic); err != nil {
+		return err
+	}
+	var buf [3*binary.MaxVarintLen64 + 1]byte
+	for _, rec := range recs {
+		n := binary.PutUvarint(buf[:], uint64(rec.num))
+		n += binary.PutUvarint(buf[n:], rec.Offset)
+		n += binary.PutUvarint(buf[n:], rec.Length)
+		if rec.Filter {
+			buf[n] = 1
+		} else {
+			buf[n] = 0
+		}
+		n++
+		if _, err := bw.Write(buf[:n]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func readBlockCacheDump(r io.Reader) ([]cachedBlockRec, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(blockCacheDumpMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != blockCacheDumpMagic {
+		return nil, errBlockCacheDump
+	}
+
+	var recs []cachedBlockRec
+	for {
+		num, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return recs, nil
+		} else if err != nil {
+			return nil, errBlockCacheDump
+		}
+		var rec cachedBlockRec
+		rec.num = int64(num)
+		if rec.Offset, err = binary.ReadUvarint(br); err != nil {
+			return nil, errBlockCacheDump
+		}
+		if rec.Length, err = binary.ReadUvarint(br); err != nil {
+			return nil, errBlockCacheDump
+		}
+		kind, err := br.ReadByte()
+		if err != nil || kind > 1 {
+			return nil, errBlockCacheDump
+		}
+		rec.Filter = kind == 1
+		recs = append(recs, rec)
+	}
+}
+
+// WarmBlockCache reads block identities written by DumpBlockCache from r
+// and loads those blocks into the block cache, so that a reopened DB
+// doesn't start with a cold cache. Blocks of tables which no longer exist
+// are skipped, and loading stops once the block cache is full.
+//
+// WarmBlockCache returns an error if the dump is malformed or a block
+// can't be read; blocks loaded so far are kept in the block cache.
+func (db *DB) WarmBlockCache(r io.Reader) error {
+	if err := db.ok(); err != nil {
+		return err
+	}
+
+	recs, err := readBlockCacheDump(r)
+	if err != nil {
+		return err
+	}
+	bc := db.s.tops.blockCache
+	if bc == nil {
+		return nil
+	}
+	sortCachedBlockRecs(recs)
+
+	v := db.s.version()
+	defer v.release()
+	tables := make(map[int64]*tFile)
+	for _, tf := range v.levels {
+		for _, t := range tf {
+			tables[t.fd.Num] = t
+		}
+	}
+
+	for i := 0; i < len(recs); {
+		t := tables[recs[i].num]
+		if t == nil {
+			i++
+			continue
+		}
+		ch, err := db.s.tops.open(t)
+		if err != nil {
+			return err
+		}
+		tr := ch.Value().(*table.Reader)
+		for ; i < len(recs) && recs[i].num == t.fd.Num; i++ {
+			if bc.Size() >= bc.Capacity() {
+				ch.Release()
+				return nil
+			}
+			if err := tr.LoadBlock(recs[i].CachedBlock); err != nil {
+				ch.Release()
+				return err
+			}
+		}
+		ch.Release()
+	}
+	return nil
+}
@@ -0,0 +1,437 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/journal"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/table"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// CheckSeverity is the severity of a CheckProblem.
+type CheckSeverity int
+
+const (
+	// CheckWarning is a problem the DB copes with, e.g. a table left
+	// behind by a crash, which is removed on the next open.
+	CheckWarning CheckSeverity = iota
+	// CheckError is a problem which prevents the DB from opening, or loses
+	// or corrupts data.
+	CheckError
+)
+
+func (s CheckSeverity) String() string {
+	if s == CheckError {
+		return "error"
+	}
+	return "warning"
+}
+
+// CheckProblem is a problem found by Check.
+type CheckProblem struct {
+	Severity CheckSeverity
+	// Fd is the file the problem is about, or zero.
+	Fd      storage.FileDesc
+	Problem string
+	// Fix is the suggested fix, or empty if nothing needs to be done.
+	Fix string
+}
+
+func (p CheckProblem) String() string {
+	var b strings.Builder
+	b.WriteString(p.Severity.String())
+	b.WriteString(": ")
+	if !p.Fd.Zero() {
+		b.WriteString(p.Fd.String())
+		b.WriteString(": ")
+	}
+	b.WriteString(p.Problem)
+	if p.Fix != "" {
+		b.WriteString("; fix: ")
+		b.WriteString(p.Fix)
+	}
+	return b.String()
+}
+
+// CheckReport is the result of Check.
+type CheckReport struct {
+	// Manifest is the MANIFEST checked, or zero if there is none.
+	Manifest storage.FileDesc
+	// Tables and Journals are the number of files checked.
+	Tables, Journals int
+	Problems         []CheckProblem
+}
+
+// OK returns whether no problem of severity CheckError was found.
+func (r *CheckReport) OK() bool {
+	for _, p := range r.Problems {
+		if p.Severity == CheckError {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *CheckReport) String() string {
+	var b strings.Builder
+	if r.Manifest.Zero() {
+		b.WriteString("manifest: none\n")
+	} else {
+		fmt.Fprintf(&b, "manifest: %s\n", r.Manifest)
+	}
+	fmt.Fprintf(&b, "checked %d tables, %d journals\n", r.Tables, r.Journals)
+	for _, p := range r.Problems {
+		b.WriteString(p.String())
+		b.WriteByte('\n')
+	}
+	if len(r.Problems) == 0 {
+		b.WriteString("no problems found\n")
+	}
+	return b.String()
+}
+
+func (r *CheckReport) add(severity CheckSeverity, fd storage.FileDesc, fix string, format string, a ...interface{}) {
+	r.Problems = append(r.Problems, CheckProblem{severity, fd, fmt.Sprintf(format, a...), fix})
+}
+
+// Suggested fixes.
+const (
+	fixRecover   = "run Recover, which rebuilds the MANIFEST from the tables"
+	fixCorrupted = "run Recover, which rewrites the table without its corrupted blocks"
+)
+
+// Check checks the consistency of the DB in the given storage, without
+// modifying it: the MANIFEST against the files in the storage, the key
+// ranges of the tables against the level invariants, the sequence numbers,
+// and the checksums of the tables and live journals. It's meant to be run
+// before reaching for Recover, which rewrites the MANIFEST and drops
+// corrupted data, and reports the problems found with a suggested fix.
+//
+// The options should be the ones the DB is opened with, giving the comparer
+// and strictness. Check locks the storage, so it fails if the DB is open.
+// The returned error is only set if the check couldn't be run; problems
+// found are part of the report.
+func Check(stor storage.Storage, o *opt.Options) (*CheckReport, error) {
+	s, err := newSession(stor, o)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		s.close()
+		s.release()
+	}()
+
+	fds, err := s.stor.List(storage.TypeAll)
+	if err != nil {
+		return nil, err
+	}
+	if len(fds) == 0 {
+		return nil, os.ErrNotExist
+	}
+	r := &CheckReport{}
+
+	rec, v, err := checkManifest(s, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Files against the MANIFEST.
+	var tables, journals []storage.FileDesc
+	if v != nil {
+		onDisk := make(map[int64]bool)
+		for _, fd := range fds {
+			if fd.Type == storage.TypeTable {
+				onDisk[fd.Num] = true
+			}
+		}
+		live := make(map[int64]bool)
+		for level, tt := range v.levels {
+			for _, t := range tt {
+				live[t.fd.Num] = true
+				if !onDisk[t.fd.Num] {
+					r.add(CheckError, t.fd, "restore the table from a backup, or "+fixRecover+" from the tables left",
+						"missing, referenced by the MANIFEST at level-%d", level)
+					continue
+				}
+				tables = append(tables, t.fd)
+			}
+		}
+		for _, fd := range fds {
+			if fd.Num >= rec.nextFileNum && (fd.Type == storage.TypeTable || fd.Type == storage.TypeJournal) {
+				r.add(CheckError, fd, fixRecover, "file number not below the next file number %d of the MANIFEST", rec.nextFileNum)
+			}
+			switch fd.Type {
+			case storage.TypeTable:
+				if !live[fd.Num] {
+					r.add(CheckWarning, fd, "if it holds lost data, run Recover before opening the DB",
+						"not referenced by the MANIFEST, removed on the next open")
+				}
+			case storage.TypeJournal:
+				if fd.Num >= rec.journalNum || fd.Num == rec.prevJournalNum {
+					journals = append(journals, fd)
+				}
+			}
+		}
+	} else {
+		// Without MANIFEST, check the tables Recover would rebuild it from.
+		for _, fd := range fds {
+			if fd.Type == storage.TypeTable {
+				tables = append(tables, fd)
+			}
+		}
+	}
+	sortFds(tables)
+	sortFds(journals)
+
+	// Level invariants.
+	tfiles := make(map[int64]*tFile)
+	if v != nil {
+		for level, tt := range v.levels {
+			for i, t := range tt {
+				tfiles[t.fd.Num] = t
+				if s.icmp.Compare(t.imin, t.imax) > 0 {
+					r.add(CheckError, t.fd, fixRecover, "smallest key %q above largest key %q in the MANIFEST", t.imin, t.imax)
+				}
+				if level > 0 && i > 0 && s.icmp.uCompare(tt[i-1].imax.ukey(), t.imin.ukey()) >= 0 {
+					r.add(CheckError, t.fd, fixRecover, "overlaps %s at level-%d", tt[i-1].fd, level)
+				}
+			}
+		}
+	}
+
+	// Tables content.
+	for _, fd := range tables {
+		if err := checkTable(s, r, fd, tfiles[fd.Num], rec); err != nil {
+			return nil, err
+		}
+		r.Tables++
+	}
+
+	// Live journals.
+	for _, fd := range journals {
+		if err := checkJournal(s, r, fd); err != nil {
+			return nil, err
+		}
+		r.Journals++
+	}
+	return r, nil
+}
+
+// CheckFile is like Check for the DB at the given path, using the standard
+// file-system backed storage opened read-only.
+func CheckFile(path string, o *opt.Options) (*CheckReport, error) {
+	stor, err := storage.OpenFile(path, true)
+	if err != nil {
+		return nil, err
+	}
+	defer stor.Close()
+	return Check(stor, o)
+}
+
+// Reads the MANIFEST into a version, like session.recover but reporting
+// the problems instead of failing. The version is nil if there is no usable
+// MANIFEST.
+func checkManifest(s *session, r *CheckReport) (*sessionRecord, *version, error) {
+	fd, err := s.stor.GetMeta()
+	if err != nil {
+		if os.IsNotExist(err) || errors.IsCorrupted(err) {
+			r.add(CheckError, storage.FileDesc{}, fixRecover, "no valid MANIFEST: %v", err)
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	r.Manifest = fd
+
+	reader, err := s.stor.Open(fd)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer reader.Close()
+
+	var (
+		strict  = s.o.GetStrict(opt.StrictManifest)
+		d       = &journalDropper{}
+		jr      = journal.NewReader(reader, d, false, true)
+		rec     = &sessionRecord{}
+		staging = s.stVersion.newStaging()
+	)
+	for {
+		var rr io.Reader
+		rr, err = jr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := rec.decode(rr); err == nil {
+			staging.commit(rec)
+		} else if errors.IsCorrupted(err) {
+			d.Drop(err)
+		} else {
+			return nil, nil, err
+		}
+		rec.resetCompPtrs()
+		rec.resetAddedTables()
+		rec.resetDeletedTables()
+	}
+	for _, err := range d.errs {
+		if strict {
+			r.add(CheckError, fd, "open the DB without opt.StrictManifest to skip it, or "+fixRecover,
+				"corrupted record: %v", err)
+		} else {
+			r.add(CheckError, fd, fixRecover, "corrupted record, skipped on open, losing track of tables: %v", err)
+		}
+	}
+
+	var missing []string
+	switch {
+	case !rec.has(recComparer):
+		missing = append(missing, "comparer")
+	case rec.comparer != s.icmp.uName():
+		r.add(CheckError, fd, fmt.Sprintf("open the DB with the %q comparer", rec.comparer),
+			"comparer mismatch: want %q, got %q", s.icmp.uName(), rec.comparer)
+	}
+	if !rec.has(recNextFileNum) {
+		missing = append(missing, "next file number")
+	}
+	if !rec.has(recJournalNum) {
+		missing = append(missing, "journal number")
+	}
+	if !rec.has(recSeqNum) {
+		missing = append(missing, "sequence number")
+	}
+	if len(missing) > 0 {
+		r.add(CheckError, fd, fixRecover, "missing %s", strings.Join(missing, ", "))
+		return nil, nil, nil
+	}
+	return rec, staging.finish(false), nil
+}
+
+// Verifies the checksums and keys of a table, and checks them against the
+// MANIFEST if the table is referenced by it.
+func checkTable(s *session, r *CheckReport, fd storage.FileDesc, t *tFile, rec *sessionRecord) error {
+	reader, err := s.stor.Open(fd)
+	if err != nil {
+		return err
+	}
+	size, err := reader.Seek(0, io.SeekEnd)
+	if err != nil {
+		reader.Close()
+		return err
+	}
+	if t != nil && size != t.size {
+		r.add(CheckError, fd, fixRecover, "size %d, the MANIFEST says %d", size, t.size)
+	}
+
+	o := dupOptions(s.o.Options)
+	o.Strict = opt.StrictBlockChecksum
+	tr, err := table.NewReader(reader, size, fd, nil, util.NewBufferPool(o.GetBlockSize()+5), o)
+	if err != nil {
+		reader.Close()
+		if errors.IsCorrupted(err) {
+			r.add(CheckError, fd, fixRecover+", dropping the table", "unreadable: %v", err)
+			return nil
+		}
+		return err
+	}
+	// Releasing the table reader closes the file.
+	defer tr.Release()
+
+	var (
+		corruptedBlocks, corruptedKeys int
+		outOfOrder, outOfRange         bool
+		maxSeq                         uint64
+		prev                           []byte
+	)
+	iter := tr.NewIterator(nil, nil)
+	defer iter.Release()
+	if itererr, ok := iter.(iterator.ErrorCallbackSetter); ok {
+		itererr.SetErrorCallback(func(err error) {
+			if errors.IsCorrupted(err) {
+				corruptedBlocks++
+			}
+		})
+	}
+	for iter.Next() {
+		key := iter.Key()
+		_, seq, _, kerr := parseInternalKey(key)
+		if kerr != nil {
+			corruptedKeys++
+			continue
+		}
+		if seq > maxSeq {
+			maxSeq = seq
+		}
+		if prev != nil && s.icmp.Compare(prev, key) >= 0 {
+			outOfOrder = true
+		}
+		prev = append(prev[:0], key...)
+		if t != nil && (s.icmp.Compare(key, t.imin) < 0 || s.icmp.Compare(key, t.imax) > 0) {
+			outOfRange = true
+		}
+	}
+	if err := iter.Error(); err != nil && !errors.IsCorrupted(err) {
+		return err
+	}
+
+	if corruptedBlocks > 0 || corruptedKeys > 0 {
+		r.add(CheckError, fd, fixCorrupted, "%d corrupted blocks, %d corrupted keys", corruptedBlocks, corruptedKeys)
+	}
+	if outOfOrder {
+		r.add(CheckError, fd, fixRecover+", dropping the table", "keys out of order")
+	}
+	if outOfRange {
+		r.add(CheckError, fd, fixRecover, "keys outside of the range given by the MANIFEST")
+	}
+	if rec != nil && t != nil && maxSeq > rec.seqNum {
+		r.add(CheckError, fd, fixRecover, "sequence number %d above the last sequence number %d of the MANIFEST", maxSeq, rec.seqNum)
+	}
+	return nil
+}
+
+// Verifies the checksums of a journal, which would be replayed on open.
+func checkJournal(s *session, r *CheckReport, fd storage.FileDesc) error {
+	reader, err := s.stor.Open(fd)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	d := &journalDropper{}
+	jr := journal.NewReader(reader, d, false, true)
+	jr.SetLogNum(uint32(fd.Num))
+	for {
+		rr, err := jr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			_, err = io.Copy(io.Discard, rr)
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+	}
+	for _, err := range d.errs {
+		if s.o.GetStrict(opt.StrictJournal) {
+			r.add(CheckError, fd, "open the DB without opt.StrictJournal to skip it", "corrupted chunk: %v", err)
+		} else {
+			r.add(CheckWarning, fd, "", "corrupted chunk, skipped on open, losing its writes: %v", err)
+		}
+	}
+	return nil
+}
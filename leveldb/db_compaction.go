@@ -7,6 +7,7 @@
 package leveldb
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"sync/atomic"
@@ -15,12 +16,17 @@ import (
 	"github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 var (
 	errCompactionTransactExiting = errors.New("leveldb: compaction transact exiting")
 )
 
+// Interval at which the compactions waiting for the compaction policy
+// check it again.
+const compactionPolicyRecheck = time.Minute
+
 type cStat struct {
 	duration time.Duration
 	read     int64
@@ -154,7 +160,7 @@ func (db *DB) compactionTransact(name string, t compactionTransactInterface) {
 		if x := recover(); x != nil {
 			if x == errCompactionTransactExiting {
 				if err := t.revert(); err != nil {
-					db.logf("%s revert error %q", name, err)
+					db.logError(name+" revert error", "err", err)
 				}
 			}
 			panic(x)
@@ -176,17 +182,17 @@ func (db *DB) compactionTransact(name string, t compactionTransactInterface) {
 	for n := 0; ; n++ {
 		// Check whether the DB is closed.
 		if db.isClosed() {
-			db.logf("%s exiting", name)
+			db.logInfo(name + " exiting")
 			db.compactionExitTransact()
 		} else if n > 0 {
-			db.logf("%s retrying N·%d", name, n)
+			db.logWarn(name+" retrying", "retry", n)
 		}
 
 		// Execute.
 		cnt := compactionTransactCounter(0)
 		err := t.run(&cnt)
 		if err != nil {
-			db.logf("%s error I·%d %q", name, cnt, err)
+			db.logWarn(name+" error", "progress", cnt, "err", err)
 		}
 
 		// Set compaction error status.
@@ -194,18 +200,18 @@ func (db *DB) compactionTransact(name string, t compactionTransactInterface) {
 		case db.compErrSetC <- err:
 		case perr := <-db.compPerErrC:
 			if err != nil {
-				db.logf("%s exiting (persistent error %q)", name, perr)
+				db.logError(name+" exiting on persistent error", "err", perr)
 				db.compactionExitTransact()
 			}
 		case <-db.closeC:
-			db.logf("%s exiting", name)
+			db.logInfo(name + " exiting")
 			db.compactionExitTransact()
 		}
 		if err == nil {
 			return
 		}
 		if errors.IsCorrupted(err) {
-			db.logf("%s exiting (corruption detected)", name)
+			db.logError(name + " exiting on corruption")
 			db.compactionExitTransact()
 		}
 
@@ -227,7 +233,7 @@ func (db *DB) compactionTransact(name string, t compactionTransactInterface) {
 			select {
 			case <-backoffT.C:
 			case <-db.closeC:
-				db.logf("%s exiting", name)
+				db.logInfo(name + " exiting")
 				db.compactionExitTransact()
 			}
 		}
@@ -267,20 +273,47 @@ func (db *DB) compactionCommit(name string, rec *sessionRecord) {
 }
 
 func (db *DB) memCompaction() {
+	for db.flushFrozenMem() {
+	}
+}
+
+// Flush oldest frozen memdb; returns false if there is none.
+func (db *DB) flushFrozenMem() bool {
 	mdb := db.getFrozenMem()
 	if mdb == nil {
-		return
+		return false
 	}
 	defer mdb.decref()
 
-	db.logf("memdb@flush N·%d S·%s", mdb.Len(), shortenb(int64(mdb.Size())))
+	// Acquire flush job slot.
+	if sched := db.s.o.GetScheduler(); sched != nil {
+		if !sched.Acquire(util.JobFlush, db.closeC) {
+			db.compactionExitTransact()
+		}
+		defer sched.Release(util.JobFlush)
+	}
+
+	db.logInfo("memdb@flush", "entries", mdb.Len(), "size", mdb.Size())
 
 	// Don't compact empty memdb.
 	if mdb.Len() == 0 {
-		db.logf("memdb@flush skipping")
-		// drop frozen memdb
+		db.logDebug("memdb@flush skipping")
+		// drop frozen memdb; a checkpoint may be copying its journal,
+		// which it does with the commits locked out.
+		db.compCommitLk.Lock()
 		db.dropFrozenMem()
-		return
+		db.compCommitLk.Unlock()
+		return true
+	}
+
+	listener := db.s.o.GetEventListener()
+	flushInfo := opt.FlushInfo{Entries: mdb.Len(), Size: mdb.Size()}
+	listener.OnFlushBegin(flushInfo)
+	defer db.trackJob(RunningJob{Flush: true, FlushInfo: flushInfo})()
+	span := db.startSpan("leveldb.Flush")
+	if span != nil {
+		span.SetAttribute("entries", int64(mdb.Len()))
+		span.SetAttribute("bytes", int64(mdb.Size()))
 	}
 
 	// Pause table compaction.
@@ -303,12 +336,12 @@ func (db *DB) memCompaction() {
 	// Generate tables.
 	db.compactionTransactFunc("memdb@flush", func(cnt *compactionTransactCounter) (err error) {
 		stats.startTimer()
-		flushLevel, err = db.s.flushMemdb(rec, mdb.DB, db.memdbMaxLevel)
+		flushLevel, err = db.s.flushMemdb(rec, mdb.Memtable, db.memdbMaxLevel, opt.TableReasonFlush)
 		stats.stopTimer()
 		return
 	}, func() error {
 		for _, r := range rec.addedTables {
-			db.logf("memdb@flush revert @%d", r.num)
+			db.logWarn("memdb@flush revert", "num", r.num)
 			if err := db.s.stor.Remove(storage.FileDesc{Type: storage.TypeTable, Num: r.num}); err != nil {
 				return err
 			}
@@ -316,22 +349,40 @@ func (db *DB) memCompaction() {
 		return nil
 	})
 
-	rec.setJournalNum(db.journalFd.Num)
-	rec.setSeqNum(db.frozenSeq)
+	rec.setJournalNum(db.nextJournalFd().Num)
+	rec.setSeqNum(mdb.seq)
 
 	// Commit.
 	stats.startTimer()
 	db.compactionCommit("memdb", rec)
 	stats.stopTimer()
 
-	db.logf("memdb@flush committed F·%d T·%v", len(rec.addedTables), stats.duration)
+	db.logInfo("memdb@flush committed", "tables", len(rec.addedTables), "duration", stats.duration)
 
 	// Save compaction stats
 	for _, r := range rec.addedTables {
 		stats.write += r.size
 	}
-	db.compStats.addStat(flushLevel, stats)
+	db.addCompStat(flushLevel, stats)
 	atomic.AddUint32(&db.memComp, 1)
+	listener.OnFlushEnd(opt.FlushInfo{
+		Entries:  mdb.Len(),
+		Size:     mdb.Size(),
+		Level:    flushLevel,
+		Tables:   tableInfosFromRecords(rec.addedTables, opt.TableReasonFlush),
+		Duration: stats.duration,
+	})
+	if db.latency != nil {
+		db.latency.flush.add(stats.duration)
+	}
+	db.logSlowCompaction("memdb@flush slow", stats.duration, "entries", mdb.Len(), "size", mdb.Size(), "level", flushLevel,
+		"tables", tableInfoNames(tableInfosFromRecords(rec.addedTables, opt.TableReasonFlush)))
+	if span != nil {
+		span.SetAttribute("level", int64(flushLevel))
+		span.SetAttribute("tables", int64(len(rec.addedTables)))
+		span.SetAttribute("bytes.written", stats.write)
+		span.End(nil)
+	}
 
 	// Drop frozen memdb.
 	db.dropFrozenMem()
@@ -348,6 +399,7 @@ func (db *DB) memCompaction() {
 
 	// Trigger table compaction.
 	db.compTrigger(db.tcompCmdC)
+	return true
 }
 
 type tableCompactionBuilder struct {
@@ -370,6 +422,7 @@ type tableCompactionBuilder struct {
 	minSeq    uint64
 	strict    bool
 	tableSize int
+	pauseC    <-chan chan<- struct{}
 
 	tw *tWriter
 }
@@ -380,7 +433,7 @@ func (b *tableCompactionBuilder) appendKV(key, value []byte) error {
 		// Check for pause event.
 		if b.db != nil {
 			select {
-			case ch := <-b.db.tcompPauseC:
+			case ch := <-b.pauseC:
 				b.db.pauseCompaction(ch)
 			case <-b.db.closeC:
 				b.db.compactionExitTransact()
@@ -390,7 +443,7 @@ func (b *tableCompactionBuilder) appendKV(key, value []byte) error {
 
 		// Create new table.
 		var err error
-		b.tw, err = b.s.tops.create(b.tableSize)
+		b.tw, err = b.s.tops.create(b.c.sourceLevel+1, b.tableSize)
 		if err != nil {
 			return err
 		}
@@ -411,7 +464,8 @@ func (b *tableCompactionBuilder) flush() error {
 	}
 	b.rec.addTableFile(b.c.sourceLevel+1, t)
 	b.stat1.write += t.size
-	b.s.logf("table@build created L%d@%d N·%d S·%s %q:%q", b.c.sourceLevel+1, t.fd.Num, b.tw.tw.EntriesLen(), shortenb(t.size), t.imin, t.imax)
+	b.s.logDebug("table@build created", "level", b.c.sourceLevel+1, "num", t.fd.Num, "entries", b.tw.tw.EntriesLen(), "size", t.size, "min", t.imin, "max", t.imax)
+	b.s.o.GetEventListener().OnTableCreated(opt.TableInfo{Num: t.fd.Num, Level: b.c.sourceLevel + 1, Size: t.size, Reason: opt.TableReasonCompaction})
 	b.tw = nil
 	return nil
 }
@@ -544,7 +598,7 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) (err error)
 
 func (b *tableCompactionBuilder) revert() error {
 	for _, at := range b.rec.addedTables {
-		b.s.logf("table@build revert @%d", at.num)
+		b.s.logWarn("table@build revert", "num", at.num)
 		if err := b.s.stor.Remove(storage.FileDesc{Type: storage.TypeTable, Num: at.num}); err != nil {
 			return err
 		}
@@ -552,18 +606,70 @@ func (b *tableCompactionBuilder) revert() error {
 	return nil
 }
 
-func (db *DB) tableCompaction(c *compaction, noTrivial bool) {
+// Compacts the tables; pause requests are received from pauseC.
+func (db *DB) tableCompaction(c *compaction, noTrivial bool, pauseC <-chan chan<- struct{}) {
 	defer c.release()
 
+	// Acquire compaction job slot.
+	if sched := db.s.o.GetScheduler(); sched != nil {
+		db.acquireCompactionJob(sched, pauseC)
+		defer sched.Release(util.JobCompaction)
+	}
+
 	rec := &sessionRecord{}
 	rec.addCompPtr(c.sourceLevel, c.imax)
 
+	listener := db.s.o.GetEventListener()
+	info := opt.CompactionInfo{
+		Reason:      c.reason(),
+		SourceLevel: c.sourceLevel,
+	}
+	for i, tables := range c.levels {
+		for _, t := range tables {
+			info.Inputs = append(info.Inputs, opt.TableInfo{Num: t.fd.Num, Level: c.sourceLevel + i, Size: t.size})
+			info.InputBytes += t.size
+		}
+	}
+
+	defer db.trackJob(RunningJob{CompactionInfo: info})()
+	span := db.startSpan("leveldb.Compaction")
+	compactionDone := func() {
+		if db.latency != nil {
+			db.latency.compaction.add(info.Duration)
+		}
+		db.logSlowCompaction("table@compaction slow", info.Duration, "reason", info.Reason, "level", info.SourceLevel,
+			"trivial", info.Trivial, "inputs", tableInfoNames(info.Inputs), "outputs", tableInfoNames(info.Outputs),
+			"bytes.read", info.InputBytes, "bytes.written", info.OutputBytes)
+		if span == nil {
+			return
+		}
+		trivial := int64(0)
+		if info.Trivial {
+			trivial = 1
+		}
+		span.SetAttribute("level", int64(info.SourceLevel))
+		span.SetAttribute("trivial", trivial)
+		span.SetAttribute("tables.input", int64(len(info.Inputs)))
+		span.SetAttribute("bytes.read", info.InputBytes)
+		span.SetAttribute("tables.output", int64(len(info.Outputs)))
+		span.SetAttribute("bytes.written", info.OutputBytes)
+		span.End(nil)
+	}
+
 	if !noTrivial && c.trivial() {
 		t := c.levels[0][0]
-		db.logf("table@move L%d@%d -> L%d", c.sourceLevel, t.fd.Num, c.sourceLevel+1)
+		info.Trivial = true
+		listener.OnCompactionBegin(info)
+		start := time.Now()
+		db.logInfo("table@move", "num", t.fd.Num, "level", c.sourceLevel, "to", c.sourceLevel+1)
 		rec.delTable(c.sourceLevel, t.fd.Num)
 		rec.addTableFile(c.sourceLevel+1, t)
 		db.compactionCommit("table-move", rec)
+		info.Outputs = []opt.TableInfo{{Num: t.fd.Num, Level: c.sourceLevel + 1, Size: t.size}}
+		info.OutputBytes = t.size
+		info.Duration = time.Since(start)
+		listener.OnCompactionEnd(info)
+		compactionDone()
 		return
 	}
 
@@ -576,8 +682,11 @@ func (db *DB) tableCompaction(c *compaction, noTrivial bool) {
 		}
 	}
 	sourceSize := stats[0].read + stats[1].read
+	// Expired snapshots don't hold the entries they see.
+	db.expireSnapshots()
 	minSeq := db.minSeq()
-	db.logf("table@compaction L%d·%d -> L%d·%d S·%s Q·%d", c.sourceLevel, len(c.levels[0]), c.sourceLevel+1, len(c.levels[1]), shortenb(sourceSize), minSeq)
+	db.logInfo("table@compaction", "level", c.sourceLevel, "files", len(c.levels[0]), "files.next", len(c.levels[1]), "size", sourceSize, "seq", minSeq)
+	listener.OnCompactionBegin(info)
 
 	b := &tableCompactionBuilder{
 		db:        db,
@@ -588,6 +697,7 @@ func (db *DB) tableCompaction(c *compaction, noTrivial bool) {
 		minSeq:    minSeq,
 		strict:    db.s.o.GetStrict(opt.StrictCompaction),
 		tableSize: db.s.o.GetCompactionTableSize(c.sourceLevel + 1),
+		pauseC:    pauseC,
 	}
 	db.compactionTransact("table@build", b)
 
@@ -597,11 +707,11 @@ func (db *DB) tableCompaction(c *compaction, noTrivial bool) {
 	stats[1].stopTimer()
 
 	resultSize := stats[1].write
-	db.logf("table@compaction committed F%s S%s Ke·%d D·%d T·%v", sint(len(rec.addedTables)-len(rec.deletedTables)), sshortenb(resultSize-sourceSize), b.kerrCnt, b.dropCnt, stats[1].duration)
+	db.logInfo("table@compaction committed", "tables", len(rec.addedTables)-len(rec.deletedTables), "size", resultSize-sourceSize, "keys.error", b.kerrCnt, "keys.dropped", b.dropCnt, "duration", stats[1].duration)
 
 	// Save compaction stats
 	for i := range stats {
-		db.compStats.addStat(c.sourceLevel+1, &stats[i])
+		db.addCompStat(c.sourceLevel+1, &stats[i])
 	}
 	switch c.typ {
 	case level0Compaction:
@@ -611,13 +721,27 @@ func (db *DB) tableCompaction(c *compaction, noTrivial bool) {
 	case seekCompaction:
 		atomic.AddUint32(&db.seekComp, 1)
 	}
+
+	info.Outputs = tableInfosFromRecords(rec.addedTables, opt.TableReasonCompaction)
+	info.OutputBytes = resultSize
+	info.Duration = stats[0].duration + stats[1].duration
+	listener.OnCompactionEnd(info)
+	compactionDone()
+}
+
+func tableInfosFromRecords(records []atRecord, reason opt.TableReason) []opt.TableInfo {
+	infos := make([]opt.TableInfo, 0, len(records))
+	for _, r := range records {
+		infos = append(infos, opt.TableInfo{Num: r.num, Level: r.level, Size: r.size, Reason: reason})
+	}
+	return infos
 }
 
 func (db *DB) tableRangeCompaction(level int, umin, umax []byte) error {
-	db.logf("table@compaction range L%d %q:%q", level, umin, umax)
+	db.logInfo("table@compaction range", "level", level, "min", umin, "max", umax)
 	if level >= 0 {
 		if c := db.s.getCompactionRange(level, umin, umax, true); c != nil {
-			db.tableCompaction(c, true)
+			db.tableCompaction(c, true, db.tcompPauseC)
 		}
 	} else {
 		// Retry until nothing to compact.
@@ -637,7 +761,7 @@ func (db *DB) tableRangeCompaction(level int, umin, umax []byte) error {
 
 			for level := 0; level < m; level++ {
 				if c := db.s.getCompactionRange(level, umin, umax, false); c != nil {
-					db.tableCompaction(c, true)
+					db.tableCompaction(c, true, db.tcompPauseC)
 					compacted = true
 				}
 			}
@@ -651,9 +775,160 @@ func (db *DB) tableRangeCompaction(level int, umin, umax []byte) error {
 	return nil
 }
 
-func (db *DB) tableAutoCompaction() {
-	if c := db.s.pickCompaction(); c != nil {
-		db.tableCompaction(c, false)
+// Runs the next automatic table compaction, handing it over to a worker
+// if CompactionConcurrency allows; returns false if there is none to run
+// besides those of the workers.
+func (db *DB) tableAutoCompaction() bool {
+	if db.s.o.GetCompactionConcurrency() <= 1 {
+		if c := db.s.pickCompaction(nil); c != nil {
+			db.tableCompaction(c, false, db.tcompPauseC)
+			return true
+		}
+		return false
+	}
+	db.checkCompactionWorkers()
+	if db.compactionWorkers() >= db.s.o.GetCompactionConcurrency() {
+		return false
+	}
+	// The compaction pointers are set by the commits of the workers.
+	db.compCommitLk.Lock()
+	c := db.s.pickCompaction(db.compactionBusy)
+	db.compCommitLk.Unlock()
+	if c == nil {
+		return false
+	}
+	db.compWorkerMu.Lock()
+	if db.compWorkerBusy == nil {
+		db.compWorkerBusy = make(map[int64]struct{})
+	}
+	for _, tables := range c.levels {
+		for _, t := range tables {
+			db.compWorkerBusy[t.fd.Num] = struct{}{}
+		}
+	}
+	if c.sourceLevel == 0 {
+		db.compWorkerL0 = true
+	}
+	db.compWorkers++
+	db.compWorkerMu.Unlock()
+	db.closeW.Add(1)
+	go db.tableCompactionWorker(c)
+	return true
+}
+
+// Tells whether the compaction can't run along those of the workers: both
+// would compact a table, or level-0.
+func (db *DB) compactionBusy(c *compaction) bool {
+	db.compWorkerMu.Lock()
+	defer db.compWorkerMu.Unlock()
+	if c.sourceLevel == 0 && db.compWorkerL0 {
+		return true
+	}
+	for _, tables := range c.levels {
+		for _, t := range tables {
+			if _, ok := db.compWorkerBusy[t.fd.Num]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (db *DB) compactionWorkers() int {
+	db.compWorkerMu.Lock()
+	defer db.compWorkerMu.Unlock()
+	return db.compWorkers
+}
+
+// Exits the transact if a worker did, on close or on a persistent error,
+// as the compaction goroutine would have.
+func (db *DB) checkCompactionWorkers() {
+	db.compWorkerMu.Lock()
+	exit := db.compWorkerExit
+	db.compWorkerMu.Unlock()
+	if exit {
+		db.compactionExitTransact()
+	}
+}
+
+func (db *DB) tableCompactionWorker(c *compaction) {
+	exit := false
+	defer func() {
+		if x := recover(); x != nil {
+			if x != errCompactionTransactExiting {
+				panic(x)
+			}
+			exit = true
+		}
+		db.compWorkerMu.Lock()
+		for _, tables := range c.levels {
+			for _, t := range tables {
+				delete(db.compWorkerBusy, t.fd.Num)
+			}
+		}
+		if c.sourceLevel == 0 {
+			db.compWorkerL0 = false
+		}
+		if exit {
+			db.compWorkerExit = true
+		}
+		db.compWorkers--
+		db.compWorkerMu.Unlock()
+		select {
+		case db.compWorkerC <- struct{}{}:
+		default:
+		}
+		db.closeW.Done()
+	}()
+
+	db.tableCompaction(c, false, db.compWorkerPauseC)
+}
+
+// Waits until a compaction worker is done, if any is running, or a pause
+// request is served.
+func (db *DB) waitCompactionWorker() {
+	if db.compactionWorkers() == 0 {
+		return
+	}
+	select {
+	case <-db.compWorkerC:
+	case ch := <-db.tcompPauseC:
+		db.pauseTableCompaction(ch)
+	case <-db.closeC:
+		db.compactionExitTransact()
+	}
+	db.checkCompactionWorkers()
+}
+
+// Waits until all the compaction workers are done.
+func (db *DB) waitCompactionWorkers() {
+	for db.compactionWorkers() > 0 {
+		db.waitCompactionWorker()
+	}
+}
+
+// Pauses the table compactions, including those of the workers, until the
+// 'memdb' flush sending the pause request is done. The workers pause at
+// their next table, so they may still be running as the flush starts.
+func (db *DB) pauseTableCompaction(ch chan<- struct{}) {
+	resumeC := make(chan struct{})
+	paused := 0
+	for paused < db.compactionWorkers() {
+		select {
+		case db.compWorkerPauseC <- (chan<- struct{})(resumeC):
+			paused++
+		case <-db.compWorkerC:
+		case <-db.closeC:
+			db.compactionExitTransact()
+		}
+	}
+	db.pauseCompaction(ch)
+	for ; paused > 0; paused-- {
+		select {
+		case <-resumeC:
+		case <-db.closeC:
+			db.compactionExitTransact()
+		}
 	}
 }
 
@@ -663,11 +938,56 @@ func (db *DB) tableNeedCompaction() bool {
 	return v.needCompaction()
 }
 
+// Tells whether the automatic table compactions wait for the compaction
+// policy, updating and logging the state.
+func (db *DB) compactionDeferred(deferred *bool) bool {
+	policy := db.s.o.GetCompactionPolicy()
+	d := false
+	if policy != nil && !policy(time.Now()) {
+		v := db.s.version()
+		d = v.tLen(0) < db.s.o.GetCompactionPolicyL0Trigger() && !db.pendingBytesPause(v)
+		v.release()
+	}
+	if d != *deferred {
+		if d {
+			db.s.logInfo("table@compaction deferred by policy")
+		} else {
+			db.s.logInfo("table@compaction resumed")
+		}
+		*deferred = d
+	}
+	return d
+}
+
 // resumeWrite returns an indicator whether we should resume write operation if enough level0 files are compacted.
 func (db *DB) resumeWrite() bool {
 	v := db.s.version()
 	defer v.release()
-	return v.tLen(0) < db.s.o.GetWriteL0PauseTrigger()
+	return v.tLen(0) < db.s.o.GetWriteL0PauseTrigger() && !db.pendingBytesPause(v)
+}
+
+// Tells whether the pending compaction bytes of the version pause writes.
+func (db *DB) pendingBytesPause(v *version) bool {
+	pauseTrigger := db.s.o.GetWritePendingCompactionBytesPauseTrigger()
+	return pauseTrigger > 0 && v.cPendingBytes >= pauseTrigger
+}
+
+// Wait until a compaction job slot is acquired. Pause request will be
+// served while waiting.
+func (db *DB) acquireCompactionJob(sched *util.Scheduler, pauseC <-chan chan<- struct{}) {
+	for {
+		notifyC := sched.Notify()
+		if sched.TryAcquire(util.JobCompaction) {
+			return
+		}
+		select {
+		case <-notifyC:
+		case ch := <-pauseC:
+			db.pauseCompaction(ch)
+		case <-db.closeC:
+			db.compactionExitTransact()
+		}
+	}
 }
 
 func (db *DB) pauseCompaction(ch chan<- struct{}) {
@@ -721,6 +1041,11 @@ func (db *DB) compTrigger(compC chan<- cCmd) {
 
 // This will trigger auto compaction and/or wait for all compaction to be done.
 func (db *DB) compTriggerWait(compC chan<- cCmd) (err error) {
+	return db.compTriggerWaitContext(context.Background(), compC)
+}
+
+// As compTriggerWait, but stops waiting when the context is done.
+func (db *DB) compTriggerWaitContext(ctx context.Context, compC chan<- cCmd) (err error) {
 	ch := make(chan error)
 	defer close(ch)
 	// Send cmd.
@@ -730,6 +1055,8 @@ func (db *DB) compTriggerWait(compC chan<- cCmd) (err error) {
 		return
 	case <-db.closeC:
 		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 	// Wait cmd.
 	select {
@@ -737,6 +1064,8 @@ func (db *DB) compTriggerWait(compC chan<- cCmd) (err error) {
 	case err = <-db.compErrC:
 	case <-db.closeC:
 		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 	return err
 }
@@ -797,8 +1126,9 @@ func (db *DB) mCompaction() {
 
 func (db *DB) tCompaction() {
 	var (
-		x     cCmd
-		waitQ []cCmd
+		x        cCmd
+		waitQ    []cCmd
+		deferred bool
 	)
 
 	defer func() {
@@ -818,11 +1148,12 @@ func (db *DB) tCompaction() {
 	}()
 
 	for {
-		if db.tableNeedCompaction() {
+		need := db.tableNeedCompaction()
+		if need && !db.compactionDeferred(&deferred) {
 			select {
 			case x = <-db.tcompCmdC:
 			case ch := <-db.tcompPauseC:
-				db.pauseCompaction(ch)
+				db.pauseTableCompaction(ch)
 				continue
 			case <-db.closeC:
 				return
@@ -837,16 +1168,27 @@ func (db *DB) tCompaction() {
 				waitQ = waitQ[:0]
 			}
 		} else {
+			// Waiting commands are done only along the running
+			// compactions.
+			if db.compactionWorkers() > 0 {
+				db.waitCompactionWorker()
+				continue
+			}
 			for i := range waitQ {
 				waitQ[i].ack(nil)
 				waitQ[i] = nil
 			}
 			waitQ = waitQ[:0]
+			var recheckC <-chan time.Time
+			if need {
+				recheckC = time.After(compactionPolicyRecheck)
+			}
 			select {
 			case x = <-db.tcompCmdC:
 			case ch := <-db.tcompPauseC:
-				db.pauseCompaction(ch)
+				db.pauseTableCompaction(ch)
 				continue
+			case <-recheckC:
 			case <-db.closeC:
 				return
 			}
@@ -855,6 +1197,9 @@ func (db *DB) tCompaction() {
 			switch cmd := x.(type) {
 			case cAuto:
 				if cmd.ackC != nil {
+					// As without workers, the running compactions are
+					// done before the command is served.
+					db.waitCompactionWorkers()
 					// Check the write pause state before caching it.
 					if db.resumeWrite() {
 						x.ack(nil)
@@ -863,12 +1208,15 @@ func (db *DB) tCompaction() {
 					}
 				}
 			case cRange:
+				db.waitCompactionWorkers()
 				x.ack(db.tableRangeCompaction(cmd.level, cmd.min, cmd.max))
 			default:
 				panic("leveldb: unknown command")
 			}
 			x = nil
 		}
-		db.tableAutoCompaction()
+		if !db.compactionDeferred(&deferred) && !db.tableAutoCompaction() {
+			db.waitCompactionWorker()
+		}
 	}
 }
@@ -0,0 +1,118 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// Number of events kept for RecentEvents.
+const recentEventsLen = 128
+
+// LogEvent is an event logged by the DB, see opt.Logger.
+type LogEvent struct {
+	Time          time.Time
+	Level         opt.LogLevel
+	Message       string
+	KeysAndValues []interface{}
+}
+
+// eventRing keeps the last logged events.
+type eventRing struct {
+	mu     sync.Mutex
+	events [recentEventsLen]LogEvent
+	next   int
+	full   bool
+}
+
+func (r *eventRing) add(level opt.LogLevel, msg string, kv []interface{}) {
+	r.mu.Lock()
+	r.events[r.next] = LogEvent{
+		Time:          time.Now(),
+		Level:         level,
+		Message:       msg,
+		KeysAndValues: kv,
+	}
+	if r.next++; r.next == len(r.events) {
+		r.next, r.full = 0, true
+	}
+	r.mu.Unlock()
+}
+
+func (r *eventRing) get() []LogEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]LogEvent(nil), r.events[:r.next]...)
+	}
+	return append(append([]LogEvent(nil), r.events[r.next:]...), r.events[:r.next]...)
+}
+
+// RecentEvents returns the last events logged by the DB at info level or
+// above, oldest first, whatever the LogLevel option.
+func (db *DB) RecentEvents() []LogEvent {
+	return db.s.events.get()
+}
+
+// RunningJob describes a running 'memdb' flush or table compaction.
+type RunningJob struct {
+	// Flush is true for a 'memdb' flush, described by FlushInfo; otherwise
+	// the job is a table compaction described by CompactionInfo.
+	Flush          bool
+	FlushInfo      opt.FlushInfo
+	CompactionInfo opt.CompactionInfo
+
+	Started time.Time
+}
+
+type runningJobs struct {
+	mu   sync.Mutex
+	jobs map[*RunningJob]struct{}
+}
+
+// Records the given job as running until the returned function is called.
+func (db *DB) trackJob(job RunningJob) (done func()) {
+	job.Started = time.Now()
+	j := &db.running
+	j.mu.Lock()
+	if j.jobs == nil {
+		j.jobs = make(map[*RunningJob]struct{})
+	}
+	j.jobs[&job] = struct{}{}
+	j.mu.Unlock()
+	return func() {
+		j.mu.Lock()
+		delete(j.jobs, &job)
+		j.mu.Unlock()
+	}
+}
+
+// RunningJobs returns the running 'memdb' flushes and table compactions,
+// oldest first.
+func (db *DB) RunningJobs() []RunningJob {
+	j := &db.running
+	j.mu.Lock()
+	jobs := make([]RunningJob, 0, len(j.jobs))
+	for job := range j.jobs {
+		jobs = append(jobs, *job)
+	}
+	j.mu.Unlock()
+	sort.Slice(jobs, func(i, k int) bool {
+		return jobs[i].Started.Before(jobs[k].Started)
+	})
+	return jobs
+}
+
+// Options returns a copy of the options the DB was opened with.
+func (db *DB) Options() *opt.Options {
+	o := *db.s.uo
+	return &o
+}
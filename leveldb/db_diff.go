@@ -0,0 +1,366 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/syndtr/goleveldb/leveldb/comparer"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/table"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// DiffKind is the kind of a difference between two DBs, see Diff.
+type DiffKind int
+
+const (
+	// DiffOnlyInA is a key found only in the first DB.
+	DiffOnlyInA DiffKind = iota
+	// DiffOnlyInB is a key found only in the second DB.
+	DiffOnlyInB
+	// DiffValueMismatch is a key found in both DBs with different values.
+	DiffValueMismatch
+)
+
+func (k DiffKind) String() string {
+	switch k {
+	case DiffOnlyInA:
+		return "only-in-a"
+	case DiffOnlyInB:
+		return "only-in-b"
+	case DiffValueMismatch:
+		return "value-mismatch"
+	}
+	return fmt.Sprintf("DiffKind(%d)", int(k))
+}
+
+// DiffIterator iterates over the keys differing between two DBs, in key
+// order, see Diff. It's not safe for concurrent use.
+type DiffIterator struct {
+	ucmp   comparer.BasicComparer
+	a, b   iterator.Iterator
+	okA    bool
+	okB    bool
+	skip   []util.Range
+	pruned int
+
+	kind           DiffKind
+	key            []byte
+	valueA, valueB []byte
+	err            error
+}
+
+// Diff returns an iterator over the keys of the given range differing
+// between the DBs a and b, each taken at a snapshot. The range may be nil
+// for all keys. Both DBs must use the same comparer.
+//
+// The key ranges of tables found identical in both DBs, i.e. of the same
+// size, bounds and properties, and overlapped by no other table nor
+// memtable entry, are skipped without being read. This is the case of the
+// tables copied from one DB to the other, e.g. by a backup, restore or
+// archive import.
+//
+// The iterator must be released after use.
+func Diff(a, b *DB, r *util.Range) *DiffIterator {
+	it := &DiffIterator{ucmp: a.s.icmp.ucmp}
+	if err := a.ok(); err != nil {
+		it.err = err
+		return it
+	}
+	if err := b.ok(); err != nil {
+		it.err = err
+		return it
+	}
+	if a.s.icmp.uName() != b.s.icmp.uName() {
+		it.err = fmt.Errorf("leveldb: diff of DBs with comparers %q and %q", a.s.icmp.uName(), b.s.icmp.uName())
+		return it
+	}
+
+	va, vb := a.acquireDiffView(), b.acquireDiffView()
+	skip, err := diffIdenticalRanges(va, vb, r)
+	if err == nil {
+		it.a = a.newIterator(nil, nil, va.se.seq, r, nil)
+		it.b = b.newIterator(nil, nil, vb.se.seq, r, nil)
+	}
+	va.release()
+	vb.release()
+	if err != nil {
+		it.err = err
+		return it
+	}
+	it.skip, it.pruned = skip, len(skip)
+	it.okA, it.okB = it.a.First(), it.b.First()
+	return it
+}
+
+// The memtables and version of a DB, holding all the writes up to a
+// snapshot, and no table holding later writes.
+type diffView struct {
+	db   *DB
+	mems []*memDB
+	v    *version
+	se   *snapshotElement
+}
+
+// Takes the memtables and version before the snapshot, so that no table
+// holds writes after it, and again if the memtable was rotated meanwhile,
+// so that the writes up to it are in the memtables taken.
+func (db *DB) acquireDiffView() *diffView {
+	for {
+		dv := &diffView{db: db, mems: db.getMems(), v: db.s.version()}
+		dv.se = db.acquireSnapshot()
+		mems := db.getMems()
+		same := len(mems) == len(dv.mems)
+		for i, m := range mems {
+			same = same && m == dv.mems[i]
+			m.decref()
+		}
+		if same {
+			return dv
+		}
+		dv.release()
+	}
+}
+
+func (dv *diffView) release() {
+	for _, m := range dv.mems {
+		m.decref()
+	}
+	dv.v.release()
+	dv.db.releaseSnapshot(dv.se)
+}
+
+// Returns the sorted user key ranges, limit included, of the tables of the
+// range found identical in both views.
+func diffIdenticalRanges(va, vb *diffView, r *util.Range) ([]util.Range, error) {
+	a, b := va.db, vb.db
+	var umin, umax []byte
+	if r != nil {
+		umin, umax = r.Start, r.Limit
+	}
+	type tableID struct {
+		imin, imax string
+		size       int64
+	}
+	candidates := make(map[tableID]*tFile)
+	for _, tables := range vb.v.levels {
+		for _, t := range tables {
+			if t.overlaps(b.s.icmp, umin, umax) {
+				candidates[tableID{string(t.imin), string(t.imax), t.size}] = t
+			}
+		}
+	}
+
+	var skip []util.Range
+	for _, tables := range va.v.levels {
+		for _, ta := range tables {
+			tb := candidates[tableID{string(ta.imin), string(ta.imax), ta.size}]
+			if tb == nil {
+				continue
+			}
+			lo, hi := ta.imin.ukey(), ta.imax.ukey()
+			if !va.alone(ta, lo, hi) || !vb.alone(tb, lo, hi) {
+				continue
+			}
+			pa, err := a.tableProperties(ta)
+			if err != nil {
+				return nil, err
+			}
+			pb, err := b.tableProperties(tb)
+			if err != nil {
+				return nil, err
+			}
+			if pa == nil || pb == nil || !equalTableProperties(pa, pb) {
+				continue
+			}
+			skip = append(skip, util.Range{Start: lo, Limit: hi})
+		}
+	}
+	sort.Slice(skip, func(i, j int) bool {
+		return a.s.icmp.uCompare(skip[i].Start, skip[j].Start) < 0
+	})
+	return skip, nil
+}
+
+// Tells whether t is the only table of the view overlapping the user keys
+// from lo to hi, included, and no memtable holds any of them.
+func (dv *diffView) alone(t *tFile, lo, hi []byte) bool {
+	icmp := dv.db.s.icmp
+	for level, tables := range dv.v.levels {
+		if level == 0 {
+			for _, o := range tables {
+				if o != t && o.overlaps(icmp, lo, hi) {
+					return false
+				}
+			}
+			continue
+		}
+		for _, o := range tables.getOverlaps(nil, icmp, lo, hi, false) {
+			if o != t {
+				return false
+			}
+		}
+	}
+	ikey := makeInternalKey(nil, lo, keyMaxSeq, keyTypeSeek)
+	for _, m := range dv.mems {
+		it := m.NewIterator(nil)
+		found := it.Seek(ikey) && icmp.uCompare(internalKey(it.Key()).ukey(), hi) <= 0
+		it.Release()
+		if found {
+			return false
+		}
+	}
+	return true
+}
+
+func equalTableProperties(a, b *table.Properties) bool {
+	if a.NumEntries != b.NumEntries || a.NumDataBlocks != b.NumDataBlocks ||
+		a.RawKeySize != b.RawKeySize || a.RawValueSize != b.RawValueSize ||
+		a.DataSize != b.DataSize || a.CreationTime != b.CreationTime ||
+		!bytes.Equal(a.SmallestKey, b.SmallestKey) || !bytes.Equal(a.LargestKey, b.LargestKey) ||
+		len(a.User) != len(b.User) {
+		return false
+	}
+	for name, v := range a.User {
+		if w, ok := b.User[name]; !ok || !bytes.Equal(v, w) {
+			return false
+		}
+	}
+	return true
+}
+
+// Moves the iterators past the identical range holding the smallest of
+// their keys, if any, and tells whether it did.
+func (it *DiffIterator) skipIdentical() bool {
+	key := it.a.Key()
+	if !it.okA || (it.okB && it.ucmp.Compare(it.b.Key(), key) < 0) {
+		key = it.b.Key()
+	}
+	for len(it.skip) > 0 && it.ucmp.Compare(it.skip[0].Limit, key) < 0 {
+		it.skip = it.skip[1:]
+	}
+	if len(it.skip) == 0 || it.ucmp.Compare(it.skip[0].Start, key) > 0 {
+		return false
+	}
+	hi := it.skip[0].Limit
+	it.skip = it.skip[1:]
+	it.okA = skipPast(it.ucmp, it.a, it.okA, hi)
+	it.okB = skipPast(it.ucmp, it.b, it.okB, hi)
+	return true
+}
+
+func skipPast(ucmp comparer.BasicComparer, iter iterator.Iterator, ok bool, hi []byte) bool {
+	if !ok || ucmp.Compare(iter.Key(), hi) > 0 {
+		return ok
+	}
+	if !iter.Seek(hi) {
+		return false
+	}
+	if ucmp.Compare(iter.Key(), hi) == 0 {
+		return iter.Next()
+	}
+	return true
+}
+
+// Next moves the iterator to the next differing key, and tells whether
+// there is one.
+func (it *DiffIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	for it.okA || it.okB {
+		if it.skipIdentical() {
+			continue
+		}
+		var c int
+		switch {
+		case !it.okB:
+			c = -1
+		case !it.okA:
+			c = 1
+		default:
+			c = it.ucmp.Compare(it.a.Key(), it.b.Key())
+		}
+		switch {
+		case c < 0:
+			it.set(DiffOnlyInA, it.a.Key(), it.a.Value(), nil)
+			it.okA = it.a.Next()
+			return true
+		case c > 0:
+			it.set(DiffOnlyInB, it.b.Key(), nil, it.b.Value())
+			it.okB = it.b.Next()
+			return true
+		}
+		differ := !bytes.Equal(it.a.Value(), it.b.Value())
+		if differ {
+			it.set(DiffValueMismatch, it.a.Key(), it.a.Value(), it.b.Value())
+		}
+		it.okA, it.okB = it.a.Next(), it.b.Next()
+		if differ {
+			return true
+		}
+	}
+	if err := it.a.Error(); err != nil {
+		it.err = err
+	} else if err := it.b.Error(); err != nil {
+		it.err = err
+	}
+	it.key, it.valueA, it.valueB = nil, nil, nil
+	return false
+}
+
+func (it *DiffIterator) set(kind DiffKind, key, valueA, valueB []byte) {
+	it.kind = kind
+	it.key = append(it.key[:0], key...)
+	it.valueA = copyValue(it.valueA, valueA)
+	it.valueB = copyValue(it.valueB, valueB)
+}
+
+// Copies v into buf, keeping nil for a value not found.
+func copyValue(buf, v []byte) []byte {
+	if v == nil {
+		return nil
+	}
+	return append(buf[:0:cap(buf)], v...)
+}
+
+// Kind returns the kind of the current difference.
+func (it *DiffIterator) Kind() DiffKind { return it.kind }
+
+// Key returns the current key. The caller should not modify its content,
+// which is valid until the next call to Next.
+func (it *DiffIterator) Key() []byte { return it.key }
+
+// ValueA returns the value of the current key in the first DB, nil if not
+// found there. The caller should not modify its content, which is valid
+// until the next call to Next.
+func (it *DiffIterator) ValueA() []byte { return it.valueA }
+
+// ValueB returns the value of the current key in the second DB, as ValueA.
+func (it *DiffIterator) ValueB() []byte { return it.valueB }
+
+// PrunedTables returns the number of tables found identical in both DBs,
+// whose keys were not compared.
+func (it *DiffIterator) PrunedTables() int { return it.pruned }
+
+// Error returns the error of the iteration, if any.
+func (it *DiffIterator) Error() error { return it.err }
+
+// Release releases the iterator.
+func (it *DiffIterator) Release() {
+	if it.a != nil {
+		it.a.Release()
+		it.b.Release()
+		it.a, it.b = nil, nil
+	}
+	if it.err == nil {
+		it.err = iterator.ErrIterReleased
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/syndtr/goleveldb/leveldb/comparer"
+	"github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/table"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// ExportFormat is the format of the entries written by DB.Export and read
+// by DB.Import. Keys and values are base64-encoded, with padding, as they
+// may be binary.
+type ExportFormat int
+
+const (
+	// ExportJSONL is JSON lines, one object per entry:
+	//
+	//	{"key":"Zm9v","value":"YmFy"}
+	ExportJSONL ExportFormat = iota
+	// ExportCSV is RFC 4180 CSV, with a key,value header row.
+	ExportCSV
+)
+
+func (f ExportFormat) String() string {
+	switch f {
+	case ExportJSONL:
+		return "jsonl"
+	case ExportCSV:
+		return "csv"
+	}
+	return fmt.Sprintf("ExportFormat(%d)", int(f))
+}
+
+// ParseExportFormat returns the ExportFormat named "jsonl" or "csv".
+func ParseExportFormat(name string) (ExportFormat, error) {
+	switch name {
+	case "jsonl":
+		return ExportJSONL, nil
+	case "csv":
+		return ExportCSV, nil
+	}
+	return 0, fmt.Errorf("leveldb: unknown export format %q", name)
+}
+
+type exportEntry struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+var csvHeader = []string{"key", "value"}
+
+// Size of the batches written by Import and IngestRocksDB.
+const importBatchSize = 1 << 20
+
+// Export writes the entries of the given key range to w in the given
+// format, in key order, from a consistent snapshot of the DB. A nil Range
+// exports the whole DB. It returns the number of entries written.
+func (db *DB) Export(w io.Writer, format ExportFormat, slice *util.Range) (n int, err error) {
+	if format != ExportJSONL && format != ExportCSV {
+		return 0, fmt.Errorf("leveldb: unknown export format %v", format)
+	}
+	iter := db.NewIterator(slice, nil)
+	defer iter.Release()
+
+	bw := bufio.NewWriter(w)
+	cw := csv.NewWriter(bw)
+	enc := json.NewEncoder(bw)
+	if format == ExportCSV {
+		if err := cw.Write(csvHeader); err != nil {
+			return 0, err
+		}
+	}
+	for iter.Next() {
+		switch format {
+		case ExportJSONL:
+			err = enc.Encode(exportEntry{iter.Key(), iter.Value()})
+		case ExportCSV:
+			err = cw.Write([]string{
+				base64.StdEncoding.EncodeToString(iter.Key()),
+				base64.StdEncoding.EncodeToString(iter.Value()),
+			})
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := iter.Error(); err != nil {
+		return n, err
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return n, err
+	}
+	return n, bw.Flush()
+}
+
+// Import puts the entries read from r in the given format, as written by
+// Export, into the DB. The entries are written in batches as they are
+// read, so a failed import may be partially applied; importing the same
+// input again is harmless. It returns the number of entries put.
+func (db *DB) Import(r io.Reader, format ExportFormat) (n int, err error) {
+	return db.ImportWith(r, format, nil)
+}
+
+// ImportOptions holds the optional parameters of DB.ImportWith.
+type ImportOptions struct {
+	// Resolve, if set, is called for every entry read with its key, the
+	// value found in the DB, or nil if none, and the value read. It returns
+	// the value to put, and whether to put it, e.g. to merge independently
+	// written DBs. The values must not be modified nor retained.
+	//
+	// The value found is read before the batch holding the entry is
+	// written, so the input should hold every key once, as Export outputs
+	// do, and the keys should not be written concurrently.
+	Resolve func(key, current, incoming []byte) (value []byte, put bool, err error)
+}
+
+// ImportWith is as Import, with the given options, which may be nil.
+func (db *DB) ImportWith(r io.Reader, format ExportFormat, o *ImportOptions) (n int, err error) {
+	var next func() (key, value []byte, err error)
+	switch format {
+	case ExportJSONL:
+		dec := json.NewDecoder(bufio.NewReader(r))
+		next = func() ([]byte, []byte, error) {
+			var e exportEntry
+			if err := dec.Decode(&e); err != nil {
+				return nil, nil, err
+			}
+			if e.Key == nil {
+				return nil, nil, errors.New("missing key")
+			}
+			return e.Key, e.Value, nil
+		}
+	case ExportCSV:
+		cr := csv.NewReader(bufio.NewReader(r))
+		cr.FieldsPerRecord = 2
+		cr.ReuseRecord = true
+		first := true
+		next = func() ([]byte, []byte, error) {
+			rec, err := cr.Read()
+			// The header is optional; "key" isn't valid base64.
+			if err == nil && first && rec[0] == csvHeader[0] && rec[1] == csvHeader[1] {
+				rec, err = cr.Read()
+			}
+			first = false
+			if err != nil {
+				return nil, nil, err
+			}
+			key, err := base64.StdEncoding.DecodeString(rec[0])
+			if err != nil {
+				return nil, nil, fmt.Errorf("key: %v", err)
+			}
+			value, err := base64.StdEncoding.DecodeString(rec[1])
+			if err != nil {
+				return nil, nil, fmt.Errorf("value: %v", err)
+			}
+			return key, value, nil
+		}
+	default:
+		return 0, fmt.Errorf("leveldb: unknown export format %v", format)
+	}
+
+	b := new(Batch)
+	for read := 1; ; read++ {
+		key, value, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, fmt.Errorf("leveldb: import: entry %d: %v", read, err)
+		}
+		if o != nil && o.Resolve != nil {
+			current, err := db.Get(key, nil)
+			if err != nil && err != ErrNotFound {
+				return n, err
+			}
+			var put bool
+			if value, put, err = o.Resolve(key, current, value); err != nil {
+				return n, fmt.Errorf("leveldb: import: entry %d: %v", read, err)
+			}
+			if !put {
+				continue
+			}
+		}
+		b.Put(key, value)
+		if b.internalLen >= importBatchSize {
+			if err := db.Write(b, nil); err != nil {
+				return n, err
+			}
+			n += b.Len()
+			b.Reset()
+		}
+	}
+	if b.Len() > 0 {
+		if err := db.Write(b, nil); err != nil {
+			return n, err
+		}
+		n += b.Len()
+	}
+	return n, nil
+}
+
+// ExportRocksDB writes the entries of the given key range to w as a RocksDB
+// SST file, as written by RocksDB's SstFileWriter, from a consistent
+// snapshot of the DB. The file can be ingested by a RocksDB using the default
+// bytewise comparator, see table.RocksDBWriter. A nil Range exports the
+// whole DB. It returns the number of entries written.
+//
+// The DB must use the default comparer, or one ordering keys the same way.
+func (db *DB) ExportRocksDB(w io.Writer, slice *util.Range) (n int, err error) {
+	if name := db.s.icmp.uName(); name != comparer.DefaultComparer.Name() {
+		return 0, fmt.Errorf("leveldb: cannot export to RocksDB with comparer %q", name)
+	}
+	return db.exportRocksDB(w, db.NewIterator(slice, nil))
+}
+
+// ExportRocksDB is as DB.ExportRocksDB, exporting the entries of the
+// snapshot.
+func (snap *Snapshot) ExportRocksDB(w io.Writer, slice *util.Range) (n int, err error) {
+	db := snap.db
+	if name := db.s.icmp.uName(); name != comparer.DefaultComparer.Name() {
+		return 0, fmt.Errorf("leveldb: cannot export to RocksDB with comparer %q", name)
+	}
+	return db.exportRocksDB(w, snap.NewIterator(slice, nil))
+}
+
+func (db *DB) exportRocksDB(w io.Writer, iter iterator.Iterator) (n int, err error) {
+	defer iter.Release()
+
+	bw := bufio.NewWriter(w)
+	tw := table.NewRocksDBWriter(bw, db.s.o.Options)
+	for iter.Next() {
+		if err := tw.Append(iter.Key(), iter.Value()); err != nil {
+			return tw.EntriesLen(), err
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return tw.EntriesLen(), err
+	}
+	if err := tw.Close(); err != nil {
+		return tw.EntriesLen(), err
+	}
+	return tw.EntriesLen(), bw.Flush()
+}
+
+// IngestRocksDB applies the entries of the RocksDB SST file read from r,
+// of the given size, to the DB: the newest version of each key is put, or
+// deleted if it is a deletion. Plain BlockBasedTable files are supported,
+// as written by RocksDB's SstFileWriter or by its flushes and compactions
+// with default options; see table.ReadRocksDBTable for what isn't.
+//
+// The entries go through the write path in batches, with new sequence
+// numbers, so a failed ingest may be partially applied; ingesting the same
+// file again is harmless. It returns the number of entries applied.
+func (db *DB) IngestRocksDB(r io.ReaderAt, size int64) (n int, err error) {
+	b := new(Batch)
+	err = table.ReadRocksDBTable(r, size, func(key, value []byte, deleted bool) error {
+		if deleted {
+			b.Delete(key)
+		} else {
+			b.Put(key, value)
+		}
+		if b.internalLen >= importBatchSize {
+			if err := db.Write(b, nil); err != nil {
+				return err
+			}
+			n += b.Len()
+			b.Reset()
+		}
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	if b.Len() > 0 {
+		if err := db.Write(b, nil); err != nil {
+			return n, err
+		}
+		n += b.Len()
+	}
+	return n, nil
+}
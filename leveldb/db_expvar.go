@@ -0,0 +1,63 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"sync"
+)
+
+// expvarPublishMu serializes PublishExpvar, since expvar.Publish panics on
+// duplicate names.
+var expvarPublishMu sync.Mutex
+
+// dbVar is an expvar.Var reporting the statistics of a DB.
+type dbVar struct {
+	mu sync.Mutex
+	db *DB
+}
+
+func (v *dbVar) String() string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var s DBStats
+	if v.db == nil || v.db.Stats(&s) != nil {
+		return "null"
+	}
+	b, err := json.Marshal(&s)
+	if err != nil {
+		return "null"
+	}
+	return string(b)
+}
+
+// PublishExpvar publishes the DB statistics with the expvar package under
+// the given name, as a JSON object holding the fields of DBStats. They are
+// then exposed by the /debug/vars HTTP handler along with the other
+// published variables.
+//
+// Since published variables can't be removed, the variable reports null
+// once the DB is closed, and publishing another DB under the same name
+// makes the variable report that DB instead. PublishExpvar returns an
+// error if the name is taken by a variable not published by PublishExpvar.
+func (db *DB) PublishExpvar(name string) error {
+	expvarPublishMu.Lock()
+	defer expvarPublishMu.Unlock()
+	switch v := expvar.Get(name).(type) {
+	case nil:
+		expvar.Publish(name, &dbVar{db: db})
+	case *dbVar:
+		v.mu.Lock()
+		v.db = db
+		v.mu.Unlock()
+	default:
+		return fmt.Errorf("leveldb: expvar %q is already published", name)
+	}
+	return nil
+}
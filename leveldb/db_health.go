@@ -0,0 +1,239 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// HealthProbeKey is the key read by the read probe of HealthCheck, when
+// no WriteProbeKey is given.
+const HealthProbeKey = "\x00leveldb-health-probe"
+
+const (
+	// Bound of a health check whose context has no earlier deadline.
+	healthCheckTimeout = 5 * time.Second
+	// Number of entries read by the iterate probe.
+	healthIterateLen = 100
+	// Fraction of the disk space under which the disk probe is degraded.
+	healthLowDiskRatio = 0.05
+	// Detail of the probes of a DB set read-only.
+	healthReadOnly = "read-only"
+)
+
+// HealthStatus is the status of a HealthReport, or of one of its probes.
+type HealthStatus int
+
+const (
+	// HealthOK is a DB serving reads and writes.
+	HealthOK HealthStatus = iota
+	// HealthDegraded is a DB serving, but at risk or slowed down, e.g. low
+	// on disk space, with writes paused, retrying a failed compaction, or
+	// read-only.
+	HealthDegraded
+	// HealthFailed is a DB failing to serve, e.g. closed, with a persistent
+	// error, or too slow to answer a probe.
+	HealthFailed
+)
+
+func (s HealthStatus) String() string {
+	switch s {
+	case HealthOK:
+		return "ok"
+	case HealthDegraded:
+		return "degraded"
+	default:
+		return "failed"
+	}
+}
+
+// HealthProbe is a probe of HealthCheck.
+type HealthProbe struct {
+	// Name is "background", "write", "read", "iterate" or "disk".
+	Name     string
+	Status   HealthStatus
+	Duration time.Duration
+	// Detail tells why the probe isn't ok, or is empty.
+	Detail string
+}
+
+func (p HealthProbe) String() string {
+	s := fmt.Sprintf("%s: %s (%v)", p.Name, p.Status, p.Duration)
+	if p.Detail != "" {
+		s += ": " + p.Detail
+	}
+	return s
+}
+
+// HealthCheckOptions holds the optional parameters of HealthCheck.
+type HealthCheckOptions struct {
+	// WriteProbeKey enables the write probe, which deletes the given key,
+	// and the read probe then reads it. The key must not be used by the
+	// application, e.g. under a prefix of its own.
+	//
+	// The write probe is skipped if nil.
+	WriteProbeKey []byte
+}
+
+// HealthReport is the result of HealthCheck.
+type HealthReport struct {
+	// Status is the worst status of the probes.
+	Status HealthStatus
+	Probes []HealthProbe
+	// DiskAvail and DiskTotal are the space available to the DB and the
+	// total space of its device, in bytes, or zero if the storage doesn't
+	// tell, see storage.SpaceReporter.
+	DiskAvail, DiskTotal uint64
+}
+
+// OK returns whether the DB serves, i.e. the status isn't HealthFailed, as
+// a readiness probe would.
+func (r *HealthReport) OK() bool {
+	return r.Status != HealthFailed
+}
+
+func (r *HealthReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "status: %s\n", r.Status)
+	for _, p := range r.Probes {
+		b.WriteString(p.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func (r *HealthReport) add(p HealthProbe) {
+	if p.Status > r.Status {
+		r.Status = p.Status
+	}
+	r.Probes = append(r.Probes, p)
+}
+
+// HealthCheck probes the DB, e.g. for a readiness probe: its background
+// error state, a read and a short iteration, and the disk space of the
+// storage. A write is probed only if given a HealthCheckOptions.WriteProbeKey,
+// and is skipped if the DB is read-only. A probe unanswered when the context
+// is done, or after a few seconds, fails; the check itself doesn't wait
+// further.
+func (db *DB) HealthCheck(ctx context.Context, o *HealthCheckOptions) *HealthReport {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	key := []byte(HealthProbeKey)
+	if o != nil && o.WriteProbeKey != nil {
+		key = o.WriteProbeKey
+	}
+	r := &HealthReport{}
+	bg := db.healthProbe(ctx, "background", db.healthBackground)
+	r.add(bg)
+	if o != nil && o.WriteProbeKey != nil && !db.s.o.GetReadOnly() && bg.Detail != healthReadOnly {
+		r.add(db.healthProbe(ctx, "write", func() (HealthStatus, string) {
+			err := db.Delete(key, nil)
+			if errors.Is(err, ErrReadOnly) {
+				// Set read-only since the background probe.
+				return HealthDegraded, healthReadOnly
+			}
+			return healthErr(err)
+		}))
+	}
+	r.add(db.healthProbe(ctx, "read", func() (HealthStatus, string) {
+		_, err := db.Get(key, nil)
+		if errors.Is(err, ErrNotFound) {
+			err = nil
+		}
+		return healthErr(err)
+	}))
+	r.add(db.healthProbe(ctx, "iterate", func() (HealthStatus, string) {
+		iter := db.NewIterator(nil, nil)
+		defer iter.Release()
+		for i := 0; i < healthIterateLen && iter.Next(); i++ {
+		}
+		return healthErr(iter.Error())
+	}))
+	if sr, ok := db.s.stor.Storage.(storage.SpaceReporter); ok {
+		space := make(chan [2]uint64, 1)
+		p := db.healthProbe(ctx, "disk", func() (HealthStatus, string) {
+			avail, total, err := sr.DiskSpace()
+			if err != nil {
+				if errors.Is(err, errors.ErrUnsupported) {
+					return HealthOK, "space unknown"
+				}
+				return healthErr(err)
+			}
+			space <- [2]uint64{avail, total}
+			if float64(avail) < float64(total)*healthLowDiskRatio {
+				return HealthDegraded, fmt.Sprintf("low on space: %d of %d bytes available", avail, total)
+			}
+			return HealthOK, ""
+		})
+		select {
+		case sp := <-space:
+			r.DiskAvail, r.DiskTotal = sp[0], sp[1]
+		default:
+		}
+		r.add(p)
+	}
+	return r
+}
+
+// Runs a probe, failing it if the context is done first.
+func (db *DB) healthProbe(ctx context.Context, name string, probe func() (HealthStatus, string)) HealthProbe {
+	p := HealthProbe{Name: name}
+	start := time.Now()
+	done := make(chan struct{})
+	var status HealthStatus
+	var detail string
+	go func() {
+		status, detail = probe()
+		close(done)
+	}()
+	select {
+	case <-done:
+		p.Status, p.Detail = status, detail
+	case <-ctx.Done():
+		p.Status, p.Detail = HealthFailed, ctx.Err().Error()
+	}
+	p.Duration = time.Since(start)
+	return p
+}
+
+func (db *DB) healthBackground() (HealthStatus, string) {
+	if db.isClosed() {
+		return HealthFailed, ErrClosed.Error()
+	}
+	select {
+	case err := <-db.compPerErrC:
+		if errors.Is(err, ErrReadOnly) {
+			return HealthDegraded, healthReadOnly
+		}
+		return HealthFailed, "persistent error: " + err.Error()
+	default:
+	}
+	select {
+	case err := <-db.compErrC:
+		return HealthDegraded, "compaction error: " + err.Error()
+	default:
+	}
+	if atomic.LoadInt32(&db.inWritePaused) == 1 {
+		return HealthDegraded, "writes paused"
+	}
+	return HealthOK, ""
+}
+
+func healthErr(err error) (HealthStatus, string) {
+	if err != nil {
+		return HealthFailed, err.Error()
+	}
+	return HealthOK, ""
+}
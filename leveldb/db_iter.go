@@ -11,6 +11,7 @@ import (
 	"runtime"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
@@ -29,13 +30,25 @@ func (mr *memdbReleaser) Release() {
 }
 
 func (db *DB) newRawIterator(auxm *memDB, auxt tFiles, slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	return db.reuseRawIterator(nil, nil, auxm, auxt, slice, ro)
+}
+
+// Like newRawIterator, but reuses prev, a released raw iterator, and the
+// backing array of *itsBuf, if not nil.
+func (db *DB) reuseRawIterator(prev iterator.Iterator, itsBuf *[]iterator.Iterator, auxm *memDB, auxt tFiles, slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
 	strict := opt.GetStrict(db.s.o.Options, ro, opt.StrictReader)
-	em, fm := db.getMems()
+	ro = db.userReadOptions(ro)
+	mems := db.getMems()
 	v := db.s.version()
 
 	tableIts := v.getIterators(slice, ro)
-	n := len(tableIts) + len(auxt) + 3
-	its := make([]iterator.Iterator, 0, n)
+	n := len(tableIts) + len(auxt) + len(mems) + 1
+	var its []iterator.Iterator
+	if itsBuf != nil && cap(*itsBuf) >= n {
+		its = (*itsBuf)[:0]
+	} else {
+		its = make([]iterator.Iterator, 0, n)
+	}
 
 	if auxm != nil {
 		ami := auxm.NewIterator(slice)
@@ -46,47 +59,46 @@ func (db *DB) newRawIterator(auxm *memDB, auxt tFiles, slice *util.Range, ro *op
 		its = append(its, v.s.tops.newIterator(t, slice, ro))
 	}
 
-	emi := em.NewIterator(slice)
-	emi.SetReleaser(&memdbReleaser{m: em})
-	its = append(its, emi)
-	if fm != nil {
-		fmi := fm.NewIterator(slice)
-		fmi.SetReleaser(&memdbReleaser{m: fm})
-		its = append(its, fmi)
+	for _, m := range mems {
+		mi := m.NewIterator(slice)
+		mi.SetReleaser(&memdbReleaser{m: m})
+		its = append(its, mi)
 	}
 	its = append(its, tableIts...)
-	mi := iterator.NewMergedIterator(its, db.s.icmp, strict)
+	if itsBuf != nil {
+		*itsBuf = its
+	}
+	mi := iterator.ReuseMergedIterator(prev, its, db.s.icmp, strict)
 	mi.SetReleaser(&versionReleaser{v: v})
 	return mi
 }
 
+// Converts slice to internal keys range, reusing the buffers of dst.
+func makeInternalRange(dst, slice *util.Range) *util.Range {
+	if slice.Start != nil {
+		dst.Start = makeInternalKey(dst.Start, slice.Start, keyMaxSeq, keyTypeSeek)
+	} else {
+		dst.Start = nil
+	}
+	if slice.Limit != nil {
+		dst.Limit = makeInternalKey(dst.Limit, slice.Limit, keyMaxSeq, keyTypeSeek)
+	} else {
+		dst.Limit = nil
+	}
+	return dst
+}
+
 func (db *DB) newIterator(auxm *memDB, auxt tFiles, seq uint64, slice *util.Range, ro *opt.ReadOptions) *dbIter {
 	var islice *util.Range
 	if slice != nil {
-		islice = &util.Range{}
-		if slice.Start != nil {
-			islice.Start = makeInternalKey(nil, slice.Start, keyMaxSeq, keyTypeSeek)
-		}
-		if slice.Limit != nil {
-			islice.Limit = makeInternalKey(nil, slice.Limit, keyMaxSeq, keyTypeSeek)
-		}
+		islice = makeInternalRange(&util.Range{}, slice)
 	}
 	rawIter := db.newRawIterator(auxm, auxt, islice, ro)
 	iter := &dbIter{
-		db:              db,
-		icmp:            db.s.icmp,
-		iter:            rawIter,
-		seq:             seq,
-		strict:          opt.GetStrict(db.s.o.Options, ro, opt.StrictReader),
-		disableSampling: db.s.o.GetDisableSeeksCompaction() || db.s.o.GetIteratorSamplingRate() <= 0,
-		key:             make([]byte, 0),
-		value:           make([]byte, 0),
+		key:   make([]byte, 0),
+		value: make([]byte, 0),
 	}
-	if !iter.disableSampling {
-		iter.samplingGap = db.iterSamplingRate()
-	}
-	atomic.AddInt32(&db.aliveIters, 1)
-	runtime.SetFinalizer(iter, (*dbIter).Release)
+	iter.init(db, rawIter, seq, ro)
 	return iter
 }
 
@@ -119,6 +131,29 @@ type dbIter struct {
 	value       []byte
 	err         error
 	releaser    util.Releaser
+	span        opt.Span
+	latency     *dbLatency
+}
+
+// Initializes the iterator over rawIter, keeping its key and value buffers.
+func (i *dbIter) init(db *DB, rawIter iterator.Iterator, seq uint64, ro *opt.ReadOptions) {
+	*i = dbIter{
+		db:              db,
+		icmp:            db.s.icmp,
+		iter:            rawIter,
+		seq:             seq,
+		strict:          opt.GetStrict(db.s.o.Options, ro, opt.StrictReader),
+		disableSampling: db.s.o.GetDisableSeeksCompaction() || db.s.o.GetIteratorSamplingRate() <= 0,
+		key:             i.key[:0],
+		value:           i.value[:0],
+	}
+	if !i.disableSampling {
+		i.samplingGap = db.iterSamplingRate()
+	}
+	i.span = db.startSpan("leveldb.Iterator")
+	i.latency = db.latency
+	atomic.AddInt32(&db.aliveIters, 1)
+	runtime.SetFinalizer(i, (*dbIter).Release)
 }
 
 func (i *dbIter) sampleSeek() {
@@ -184,6 +219,9 @@ func (i *dbIter) Last() bool {
 }
 
 func (i *dbIter) Seek(key []byte) bool {
+	if l := i.latency; l != nil {
+		defer l.seek.since(time.Now())
+	}
 	if i.err != nil {
 		return false
 	} else if i.dir == dirReleased {
@@ -234,6 +272,9 @@ func (i *dbIter) next() bool {
 }
 
 func (i *dbIter) Next() bool {
+	if l := i.latency; l != nil {
+		defer l.next.since(time.Now())
+	}
 	if i.dir == dirEOI || i.err != nil {
 		return false
 	} else if i.dir == dirReleased {
@@ -331,24 +372,37 @@ func (i *dbIter) Value() []byte {
 
 func (i *dbIter) Release() {
 	if i.dir != dirReleased {
-		// Clear the finalizer.
-		runtime.SetFinalizer(i, nil)
-
-		if i.releaser != nil {
-			i.releaser.Release()
-			i.releaser = nil
-		}
-
-		i.dir = dirReleased
+		i.release()
 		i.key = nil
 		i.value = nil
-		i.iter.Release()
-		i.iter = nil
-		atomic.AddInt32(&i.db.aliveIters, -1)
-		i.db = nil
 	}
 }
 
+// Releases the iterator, but keeps its key and value buffers. Returns the
+// released raw iterator.
+func (i *dbIter) release() iterator.Iterator {
+	// Clear the finalizer.
+	runtime.SetFinalizer(i, nil)
+
+	if i.releaser != nil {
+		i.releaser.Release()
+		i.releaser = nil
+	}
+
+	if i.span != nil {
+		i.span.End(i.err)
+		i.span = nil
+	}
+
+	i.dir = dirReleased
+	rawIter := i.iter
+	rawIter.Release()
+	i.iter = nil
+	atomic.AddInt32(&i.db.aliveIters, -1)
+	i.db = nil
+	return rawIter
+}
+
 func (i *dbIter) SetReleaser(releaser util.Releaser) {
 	if i.dir == dirReleased {
 		panic(util.ErrReleased)
@@ -362,3 +416,77 @@ func (i *dbIter) SetReleaser(releaser util.Releaser) {
 func (i *dbIter) Error() error {
 	return i.err
 }
+
+// ReusableIterator is an iterator over the DB which can be reset to iterate
+// again, over the latest snapshot, while reusing most of its allocations.
+// It suits services which create many short-lived iterators.
+//
+// Like iterators returned by NewIterator, a ReusableIterator is not safe for
+// concurrent use, and must be released after use.
+type ReusableIterator struct {
+	*dbIter
+	db       *DB
+	rawIter  iterator.Iterator
+	its      []iterator.Iterator
+	islice   util.Range
+	released bool
+}
+
+// NewReusableIterator returns a ReusableIterator for the latest snapshot of
+// the underlying DB. See NewIterator for the meaning of slice and ro.
+//
+// If the DB is closed, the iterator is empty and its Error method returns
+// the error.
+func (db *DB) NewReusableIterator(slice *util.Range, ro *opt.ReadOptions) *ReusableIterator {
+	it := &ReusableIterator{
+		dbIter: &dbIter{
+			dir:   dirReleased,
+			key:   make([]byte, 0),
+			value: make([]byte, 0),
+		},
+		db: db,
+	}
+	if err := it.Reset(slice, ro); err != nil {
+		it.err = err
+	}
+	return it
+}
+
+// Reset releases the current iteration, and makes the iterator iterate over
+// the latest snapshot of the DB with the given slice and read options, as
+// a newly created iterator.
+//
+// Reset returns ErrIterReleased if the iterator is released, and an error
+// if the DB is closed, in which case the iterator is left empty.
+func (it *ReusableIterator) Reset(slice *util.Range, ro *opt.ReadOptions) error {
+	if it.released {
+		return ErrIterReleased
+	}
+	if it.dir != dirReleased {
+		it.rawIter = it.release()
+	}
+	it.err = nil
+	if err := it.db.ok(); err != nil {
+		it.err = err
+		return err
+	}
+
+	var islice *util.Range
+	if slice != nil {
+		islice = makeInternalRange(&it.islice, slice)
+	}
+	se := it.db.acquireSnapshot()
+	defer it.db.releaseSnapshot(se)
+	rawIter := it.db.reuseRawIterator(it.rawIter, &it.its, nil, nil, islice, ro)
+	it.rawIter = nil
+	it.init(it.db, rawIter, se.seq, ro)
+	return nil
+}
+
+// Release releases the iterator for good; it can't be reset afterward.
+func (it *ReusableIterator) Release() {
+	it.released = true
+	it.rawIter = nil
+	it.its = nil
+	it.dbIter.Release()
+}
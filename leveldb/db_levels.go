@@ -0,0 +1,145 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// LevelInfo describes a level of the LSM tree.
+type LevelInfo struct {
+	Level int
+	// Size is the total size of the tables of the level.
+	Size int64
+	// TargetSize is the size above which the level is compacted into the
+	// next one. It's zero for level-0, which is compacted based on its
+	// number of tables instead.
+	TargetSize int64
+	// Score is the compaction score of the level; the level needs
+	// compaction when it's at least 1.
+	Score float64
+	// Tables holds the tables of the level, in key order, or by file number
+	// for level-0.
+	Tables []LevelTableInfo
+}
+
+// LevelTableInfo describes a table of a level.
+type LevelTableInfo struct {
+	Num  int64
+	Size int64
+	// Smallest and Largest are the user keys range of the table.
+	Smallest, Largest []byte
+	// CreationTime is when the table was written. It's zero if unknown,
+	// e.g. for tables written before table properties were introduced.
+	CreationTime time.Time
+	// SeeksLeft is the number of seeks the table may still take before
+	// being scheduled for a seek compaction.
+	SeeksLeft int32
+	// Reads is the number of gets which looked up the table and of
+	// iterators which read it, and LastRead the time of the last of them,
+	// or zero if none. They're counted since the table was added to its
+	// level, or since the DB was opened.
+	Reads    int64
+	LastRead time.Time
+}
+
+// Levels returns the current shape of the LSM tree, one LevelInfo per
+// level. Getting the creation time of the tables may open them, through the
+// open files cache. It returns nil if the DB is closed.
+func (db *DB) Levels() []LevelInfo {
+	if db.ok() != nil {
+		return nil
+	}
+
+	v := db.s.version()
+	defer v.release()
+
+	levels := make([]LevelInfo, len(v.levels))
+	for level, tables := range v.levels {
+		info := &levels[level]
+		info.Level = level
+		info.Size = tables.size()
+		if level > 0 {
+			info.TargetSize = db.s.o.GetCompactionTotalSize(level)
+		}
+		info.Score = v.levelScore(level, tables)
+		info.Tables = make([]LevelTableInfo, len(tables))
+		for i, t := range tables {
+			tinfo := LevelTableInfo{
+				Num:       t.fd.Num,
+				Size:      t.size,
+				Smallest:  append([]byte(nil), t.imin.ukey()...),
+				Largest:   append([]byte(nil), t.imax.ukey()...),
+				SeeksLeft: atomic.LoadInt32(&t.seekLeft),
+				Reads:     atomic.LoadInt64(&t.reads),
+			}
+			if lastRead := atomic.LoadInt64(&t.lastRead); lastRead != 0 {
+				tinfo.LastRead = time.Unix(0, lastRead)
+			}
+			if props, err := db.tableProperties(t); err == nil && props != nil && props.CreationTime > 0 {
+				tinfo.CreationTime = time.Unix(props.CreationTime, 0)
+			}
+			info.Tables[i] = tinfo
+		}
+	}
+	return levels
+}
+
+// Width of the size bars of the lsm-shape property.
+const lsmShapeBarWidth = 40
+
+// Formats the levels as a table with a size bar per level, relative to the
+// largest level. Reads are summed over the tables of each level.
+func formatLSMShape(levels []LevelInfo, now time.Time) string {
+	var maxSize int64
+	for _, l := range levels {
+		if l.Size > maxSize {
+			maxSize = l.Size
+		}
+	}
+	var buf bytes.Buffer
+	buf.WriteString(" Level | Tables |   Size   |  Target  | Score |  Oldest  |   Reads    |\n")
+	buf.WriteString("-------+--------+----------+----------+-------+----------+------------+\n")
+	for _, l := range levels {
+		target := "-"
+		if l.TargetSize > 0 {
+			target = shortenb(l.TargetSize)
+		}
+		oldest := "-"
+		var created time.Time
+		var reads int64
+		for _, t := range l.Tables {
+			reads += t.Reads
+			if !t.CreationTime.IsZero() && (created.IsZero() || t.CreationTime.Before(created)) {
+				created = t.CreationTime
+			}
+		}
+		if !created.IsZero() {
+			oldest = now.Sub(created).Truncate(time.Second).String()
+		}
+		var bar int
+		if maxSize > 0 {
+			bar = int((l.Size*lsmShapeBarWidth + maxSize - 1) / maxSize)
+		}
+		fmt.Fprintf(&buf, " %5d | %6d | %8s | %8s | %5.2f | %8s | %10d | %s\n",
+			l.Level, len(l.Tables), shortenb(l.Size), target, l.Score, oldest, reads, strings.Repeat("#", bar))
+	}
+	return buf.String()
+}
+
+func formatLSMShapeJSON(levels []LevelInfo) (string, error) {
+	b, err := json.Marshal(levels)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// KV is the interface of the key/value operations of a DB, implemented by
+// both DB and the views returned by Namespace.
+type KV interface {
+	Reader
+	Has(key []byte, ro *opt.ReadOptions) (bool, error)
+	Put(key, value []byte, wo *opt.WriteOptions) error
+	Delete(key []byte, wo *opt.WriteOptions) error
+	Write(batch *Batch, wo *opt.WriteOptions) error
+}
+
+// SnapshotReader is the interface of the read operations of a snapshot,
+// implemented by both Snapshot and the snapshots of the views returned by
+// Namespace.
+type SnapshotReader interface {
+	Reader
+	Has(key []byte, ro *opt.ReadOptions) (bool, error)
+	Release()
+}
+
+var (
+	_ KV             = (*DB)(nil)
+	_ KV             = (*namespace)(nil)
+	_ SnapshotReader = (*Snapshot)(nil)
+	_ SnapshotReader = (*namespaceSnapshot)(nil)
+)
+
+// Namespace returns a view of the entries of the DB under the given key
+// prefix, e.g. those of a tenant. The keys given to the view, and those of
+// its iterators, exclude the prefix, and its iterators never go past the
+// entries under the prefix. The view holds no resources, and is safe for
+// concurrent use, as the DB.
+//
+// The view also has a GetSnapshot method, returning a SnapshotReader of the
+// entries under the prefix:
+//
+//	snap, err := db.Namespace(prefix).(interface {
+//		GetSnapshot() (leveldb.SnapshotReader, error)
+//	}).GetSnapshot()
+//
+// The prefix should not be the prefix of another namespace, e.g. "a" and
+// "ab", as their entries would mix. Ranges of the view assume the comparer
+// keeps the keys under a prefix together, as the default comparer does.
+func (db *DB) Namespace(prefix []byte) KV {
+	prefix = append([]byte(nil), prefix...)
+	return &namespace{namespaceReader{db, prefix}, db}
+}
+
+// namespaceReader reads the entries under a prefix from a DB or a
+// snapshot.
+type namespaceReader struct {
+	r interface {
+		Reader
+		Has(key []byte, ro *opt.ReadOptions) (bool, error)
+	}
+	prefix []byte
+}
+
+func (ns namespaceReader) key(key []byte) []byte {
+	k := make([]byte, len(ns.prefix)+len(key))
+	copy(k, ns.prefix)
+	copy(k[len(ns.prefix):], key)
+	return k
+}
+
+func (ns namespaceReader) Get(key []byte, ro *opt.ReadOptions) ([]byte, error) {
+	return ns.r.Get(ns.key(key), ro)
+}
+
+func (ns namespaceReader) Has(key []byte, ro *opt.ReadOptions) (bool, error) {
+	return ns.r.Has(ns.key(key), ro)
+}
+
+func (ns namespaceReader) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	r := util.BytesPrefix(ns.prefix)
+	if slice != nil {
+		if slice.Start != nil {
+			r.Start = ns.key(slice.Start)
+		}
+		if slice.Limit != nil {
+			r.Limit = ns.key(slice.Limit)
+		}
+	}
+	return &namespaceIter{Iterator: ns.r.NewIterator(r, ro), ns: ns}
+}
+
+type namespace struct {
+	namespaceReader
+	db *DB
+}
+
+func (ns *namespace) Put(key, value []byte, wo *opt.WriteOptions) error {
+	return ns.db.Put(ns.key(key), value, wo)
+}
+
+func (ns *namespace) Delete(key []byte, wo *opt.WriteOptions) error {
+	return ns.db.Delete(ns.key(key), wo)
+}
+
+// Write applies the batch with its keys under the prefix. The batch itself
+// is left as is.
+func (ns *namespace) Write(batch *Batch, wo *opt.WriteOptions) error {
+	if batch == nil || batch.Len() == 0 {
+		return ns.db.Write(batch, wo)
+	}
+	b := MakeBatch(batch.internalLen + batch.Len()*len(ns.prefix))
+	batch.replayInternal(func(i int, kt keyType, k, v []byte) error {
+		b.appendRec(kt, ns.key(k), v)
+		return nil
+	})
+	return ns.db.Write(b, wo)
+}
+
+// GetSnapshot returns a snapshot of the entries under the prefix, see
+// DB.GetSnapshot.
+func (ns *namespace) GetSnapshot() (SnapshotReader, error) {
+	snap, err := ns.db.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &namespaceSnapshot{namespaceReader{snap, ns.prefix}, snap}, nil
+}
+
+type namespaceSnapshot struct {
+	namespaceReader
+	snap *Snapshot
+}
+
+func (ns *namespaceSnapshot) Release() {
+	ns.snap.Release()
+}
+
+// namespaceIter trims the prefix of the keys of a namespace.
+type namespaceIter struct {
+	iterator.Iterator
+	ns namespaceReader
+}
+
+func (i *namespaceIter) Seek(key []byte) bool {
+	return i.Iterator.Seek(i.ns.key(key))
+}
+
+func (i *namespaceIter) Key() []byte {
+	k := i.Iterator.Key()
+	if k == nil {
+		return nil
+	}
+	return k[len(i.ns.prefix):]
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// ErrInvalidCursor is returned by ScanPage for a cursor it didn't return,
+// or returned for the other direction.
+var ErrInvalidCursor = errors.New("leveldb: invalid page cursor")
+
+// First byte of the cursors of ScanPage, telling their direction.
+const (
+	pageCursorForward = 'f'
+	pageCursorReverse = 'r'
+)
+
+// PageOptions holds the optional parameters of ScanPage.
+type PageOptions struct {
+	// Reverse returns the entries in decreasing key order, from the end of
+	// the range.
+	Reverse bool
+
+	// KeysOnly omits the values of the entries.
+	KeysOnly bool
+}
+
+// PageEntry is an entry of a Page.
+type PageEntry struct {
+	Key, Value []byte
+}
+
+// Page is a page of entries returned by ScanPage.
+type Page struct {
+	Entries []PageEntry
+
+	// Cursor is passed to ScanPage to get the next page, or is nil if
+	// there are no more entries. It's opaque, but holds the key of the next
+	// entry, so it shouldn't be exposed where the keys are secret.
+	Cursor []byte
+}
+
+// ScanPage returns a page of at most pageSize entries of the given range,
+// starting at start, included, and ending before limit, either nil for no
+// bound, e.g. for an HTTP API listing the entries. The cursor is nil for
+// the first page, and is the Cursor of the previous page for the next
+// ones, with the same range and options.
+//
+// A page is read from a consistent snapshot of the DB. The pages of a scan
+// aren't, unless the reader is a Snapshot kept for the whole scan: an entry
+// written between two pages is then returned if it comes after the cursor,
+// and each entry is returned at most once.
+func ScanPage(r Reader, start, limit []byte, pageSize int, cursor []byte, o *PageOptions) (*Page, error) {
+	if pageSize <= 0 {
+		return nil, errors.New("leveldb: invalid page size")
+	}
+	var reverse, keysOnly bool
+	if o != nil {
+		reverse, keysOnly = o.Reverse, o.KeysOnly
+	}
+	dir := byte(pageCursorForward)
+	if reverse {
+		dir = pageCursorReverse
+	}
+	var from []byte
+	if cursor != nil {
+		if len(cursor) == 0 || cursor[0] != dir {
+			return nil, ErrInvalidCursor
+		}
+		from = cursor[1:]
+	}
+
+	iter := r.NewIterator(&util.Range{Start: start, Limit: limit}, nil)
+	defer iter.Release()
+	var ok bool
+	switch {
+	case from == nil && reverse:
+		ok = iter.Last()
+	case from == nil:
+		ok = iter.First()
+	case reverse:
+		// The last entry up to the cursor key, included.
+		if ok = iter.Seek(from); ok {
+			if !bytes.Equal(iter.Key(), from) {
+				ok = iter.Prev()
+			}
+		} else if iter.Error() == nil {
+			ok = iter.Last()
+		}
+	default:
+		ok = iter.Seek(from)
+	}
+	p := &Page{Entries: []PageEntry{}}
+	for ok && len(p.Entries) < pageSize {
+		e := PageEntry{Key: append([]byte{}, iter.Key()...)}
+		if !keysOnly {
+			e.Value = append([]byte{}, iter.Value()...)
+		}
+		p.Entries = append(p.Entries, e)
+		if reverse {
+			ok = iter.Prev()
+		} else {
+			ok = iter.Next()
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	if ok {
+		p.Cursor = append([]byte{dir}, iter.Key()...)
+	}
+	return p, nil
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/table"
+)
+
+// Passes user keys to the collector, rather than internal keys.
+type iTablePropertiesCollector struct {
+	opt.TablePropertiesCollector
+}
+
+func (c iTablePropertiesCollector) Add(key, value []byte, _ bool) {
+	ukey, _, kt, err := parseInternalKey(key)
+	if err != nil {
+		c.TablePropertiesCollector.Add(key, value, false)
+		return
+	}
+	c.TablePropertiesCollector.Add(ukey, value, kt == keyTypeDel)
+}
+
+// TableProperties holds the properties of a live table.
+type TableProperties struct {
+	// Level is the level of the table, and Num its file number.
+	Level int
+	Num   int64
+	// Size is the size of the table file.
+	Size int64
+
+	// Properties as stored in the table. The smallest and largest keys are
+	// user keys. It's nil for tables written before properties were
+	// introduced.
+	*table.Properties
+}
+
+// TableProperties returns the properties of every live table of the DB, by
+// level then by key order, or file number for level-0.
+func (db *DB) TableProperties() ([]TableProperties, error) {
+	if err := db.ok(); err != nil {
+		return nil, err
+	}
+
+	v := db.s.version()
+	defer v.release()
+
+	var res []TableProperties
+	for level, tables := range v.levels {
+		for _, t := range tables {
+			props, err := db.tableProperties(t)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, TableProperties{
+				Level:      level,
+				Num:        t.fd.Num,
+				Size:       t.size,
+				Properties: props,
+			})
+		}
+	}
+	return res, nil
+}
+
+func (db *DB) tableProperties(t *tFile) (*table.Properties, error) {
+	ch, err := db.s.tops.open(t)
+	if err != nil {
+		return nil, err
+	}
+	defer ch.Release()
+	props, err := ch.Value().(*table.Reader).Properties()
+	if err != nil {
+		if err == errors.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if ukey, _, _, err := parseInternalKey(props.SmallestKey); err == nil {
+		props.SmallestKey = ukey
+	}
+	if ukey, _, _, err := parseInternalKey(props.LargestKey); err == nil {
+		props.LargestKey = ukey
+	}
+	return props, nil
+}
@@ -12,7 +12,9 @@ import (
 	"runtime"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/util"
@@ -38,6 +40,10 @@ func (db *DB) acquireSnapshot() *snapshotElement {
 			return se
 		} else if seq < se.seq {
 			panic("leveldb: sequence number is not increasing")
+		} else if se.ref == 0 {
+			// Reuse the idle element, see unrefSnapshot.
+			se.seq, se.ref = seq, 1
+			return se
 		}
 	}
 	se := &snapshotElement{seq: seq, ref: 1}
@@ -49,11 +55,19 @@ func (db *DB) acquireSnapshot() *snapshotElement {
 func (db *DB) releaseSnapshot(se *snapshotElement) {
 	db.snapsMu.Lock()
 	defer db.snapsMu.Unlock()
+	db.unrefSnapshot(se)
+}
 
+// Releases given snapshot element. The snapsMu must be held.
+func (db *DB) unrefSnapshot(se *snapshotElement) {
 	se.ref--
 	if se.ref == 0 {
-		db.snapsList.Remove(se.e)
-		se.e = nil
+		// The last element is kept idle, so that the snapshots of reads
+		// mostly don't allocate.
+		if se.e != db.snapsList.Back() {
+			db.snapsList.Remove(se.e)
+			se.e = nil
+		}
 	} else if se.ref < 0 {
 		panic("leveldb: Snapshot: negative element reference")
 	}
@@ -65,31 +79,148 @@ func (db *DB) minSeq() uint64 {
 	defer db.snapsMu.Unlock()
 
 	if e := db.snapsList.Front(); e != nil {
-		return e.Value.(*snapshotElement).seq
+		if se := e.Value.(*snapshotElement); se.ref > 0 {
+			return se.seq
+		}
 	}
 
 	return db.getSeq()
 }
 
+// snapshotRecord accounts for a Snapshot held by the user. It doesn't
+// refer to the Snapshot, which is released when unreachable.
+type snapshotRecord struct {
+	se      *snapshotElement
+	created time.Time
+	stack   []uintptr // Set if the snapshots are tracked.
+	e       *list.Element
+	expired int32
+}
+
+func (rec *snapshotRecord) String() string {
+	s := fmt.Sprintf("seq=%d age=%v", rec.se.seq, time.Since(rec.created).Round(time.Millisecond))
+	if rec.stack != nil {
+		frames := runtime.CallersFrames(rec.stack)
+		for {
+			f, more := frames.Next()
+			s += fmt.Sprintf("\n\t%s\n\t\t%s:%d", f.Function, f.File, f.Line)
+			if !more {
+				break
+			}
+		}
+	}
+	return s
+}
+
+// Expires the snapshots held longer than the maximum lifetime, releasing
+// their elements.
+func (db *DB) expireSnapshots() {
+	db.snapsMu.Lock()
+	db.expireSnapshotsLocked()
+	db.snapsMu.Unlock()
+}
+
+// Same as expireSnapshots. The snapsMu must be held.
+func (db *DB) expireSnapshotsLocked() {
+	lifetime := db.s.o.GetMaxSnapshotLifetime()
+	if lifetime <= 0 {
+		return
+	}
+	now := time.Now()
+	for e := db.snapsHeld.Front(); e != nil; e = db.snapsHeld.Front() {
+		rec := e.Value.(*snapshotRecord)
+		if now.Sub(rec.created) < lifetime {
+			break
+		}
+		db.snapsHeld.Remove(e)
+		rec.e = nil
+		atomic.StoreInt32(&rec.expired, 1)
+		db.unrefSnapshot(rec.se)
+		atomic.AddInt32(&db.aliveSnaps, -1)
+		atomic.AddInt64(&db.cExpiredSnaps, 1)
+		db.s.logWarn("snapshot@expire expired", "snapshot", rec)
+	}
+}
+
+// Returns the records of the snapshots held, oldest first.
+func (db *DB) heldSnapshots() []*snapshotRecord {
+	db.snapsMu.Lock()
+	defer db.snapsMu.Unlock()
+	db.expireSnapshotsLocked()
+
+	recs := make([]*snapshotRecord, 0, db.snapsHeld.Len())
+	for e := db.snapsHeld.Front(); e != nil; e = e.Next() {
+		recs = append(recs, e.Value.(*snapshotRecord))
+	}
+	return recs
+}
+
 // Snapshot is a DB snapshot.
 type Snapshot struct {
 	db       *DB
 	elem     *snapshotElement
+	rec      *snapshotRecord
 	mu       sync.RWMutex
 	released bool
 }
 
 // Creates new snapshot object.
 func (db *DB) newSnapshot() *Snapshot {
+	rec := &snapshotRecord{created: time.Now()}
+	if db.s.o.GetTrackSnapshots() {
+		var pcs [32]uintptr
+		// Skip runtime.Callers, newSnapshot and GetSnapshot.
+		n := runtime.Callers(3, pcs[:])
+		rec.stack = append([]uintptr(nil), pcs[:n]...)
+	}
+	rec.se = db.acquireSnapshot()
 	snap := &Snapshot{
 		db:   db,
-		elem: db.acquireSnapshot(),
+		elem: rec.se,
+		rec:  rec,
 	}
+	db.snapsMu.Lock()
+	db.expireSnapshotsLocked()
+	rec.e = db.snapsHeld.PushBack(rec)
+	db.snapsMu.Unlock()
 	atomic.AddInt32(&db.aliveSnaps, 1)
 	runtime.SetFinalizer(snap, (*Snapshot).Release)
 	return snap
 }
 
+// Returns the error of the use of the snapshot, if released or expired.
+// The snap.mu must be held.
+func (snap *Snapshot) check() error {
+	if snap.released {
+		return ErrSnapshotReleased
+	}
+	if lifetime := snap.db.s.o.GetMaxSnapshotLifetime(); lifetime > 0 {
+		if atomic.LoadInt32(&snap.rec.expired) == 0 && time.Since(snap.rec.created) >= lifetime {
+			snap.db.expireSnapshots()
+		}
+	}
+	if err := snap.expired(); err != nil {
+		return err
+	}
+	return snap.db.ok()
+}
+
+// Returns ErrSnapshotExpired if the snapshot expired. A read checks it
+// again once done, as the snapshot may expire during the read, and a
+// compaction then drop the entries it sees. The snap.mu must be held.
+func (snap *Snapshot) expired() error {
+	if atomic.LoadInt32(&snap.rec.expired) == 1 {
+		return &errors.ErrSnapshotExpired{Seq: snap.elem.seq, Lifetime: snap.db.s.o.GetMaxSnapshotLifetime()}
+	}
+	return nil
+}
+
+// Seq returns the sequence number of the snapshot, that of the last write
+// it sees, see DB.NewWALIterator.
+func (snap *Snapshot) Seq() uint64 {
+	return snap.elem.seq
+}
+
 func (snap *Snapshot) String() string {
 	return fmt.Sprintf("leveldb.Snapshot{%d}", snap.elem.seq)
 }
@@ -102,15 +233,35 @@ func (snap *Snapshot) String() string {
 func (snap *Snapshot) Get(key []byte, ro *opt.ReadOptions) (value []byte, err error) {
 	snap.mu.RLock()
 	defer snap.mu.RUnlock()
-	if snap.released {
-		err = ErrSnapshotReleased
+	if err = snap.check(); err != nil {
 		return
 	}
-	err = snap.db.ok()
+	value, err = snap.db.get(nil, nil, key, snap.elem.seq, ro, nil)
+	if eerr := snap.expired(); eerr != nil {
+		return nil, eerr
+	}
+	return
+}
+
+// GetTo is like Get, but appends the value to dst and returns the updated
+// slice, so that reusing dst across calls avoids allocating the value. On
+// error, including ErrNotFound, dst is returned as is.
+//
+// It is safe to modify the contents of the argument after GetTo returns.
+func (snap *Snapshot) GetTo(key, dst []byte, ro *opt.ReadOptions) ([]byte, error) {
+	snap.mu.RLock()
+	defer snap.mu.RUnlock()
+	if err := snap.check(); err != nil {
+		return dst, err
+	}
+	value, err := snap.db.get(nil, nil, key, snap.elem.seq, ro, dst)
+	if err == nil {
+		err = snap.expired()
+	}
 	if err != nil {
-		return
+		return dst, err
 	}
-	return snap.db.get(nil, nil, key, snap.elem.seq, ro)
+	return value, nil
 }
 
 // Has returns true if the DB does contains the given key.
@@ -119,15 +270,14 @@ func (snap *Snapshot) Get(key []byte, ro *opt.ReadOptions) (value []byte, err er
 func (snap *Snapshot) Has(key []byte, ro *opt.ReadOptions) (ret bool, err error) {
 	snap.mu.RLock()
 	defer snap.mu.RUnlock()
-	if snap.released {
-		err = ErrSnapshotReleased
+	if err = snap.check(); err != nil {
 		return
 	}
-	err = snap.db.ok()
-	if err != nil {
-		return
+	ret, err = snap.db.has(nil, nil, key, snap.elem.seq, ro)
+	if eerr := snap.expired(); eerr != nil {
+		return false, eerr
 	}
-	return snap.db.has(nil, nil, key, snap.elem.seq, ro)
+	return
 }
 
 // NewIterator returns an iterator for the snapshot of the underlying DB.
@@ -154,14 +304,11 @@ func (snap *Snapshot) Has(key []byte, ro *opt.ReadOptions) (ret bool, err error)
 func (snap *Snapshot) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
 	snap.mu.Lock()
 	defer snap.mu.Unlock()
-	if snap.released {
-		return iterator.NewEmptyIterator(ErrSnapshotReleased)
-	}
-	if err := snap.db.ok(); err != nil {
+	if err := snap.check(); err != nil {
 		return iterator.NewEmptyIterator(err)
 	}
 	// Since iterator already hold version ref, it doesn't need to
-	// hold snapshot ref.
+	// hold snapshot ref, and its entries outlive an expiry.
 	return snap.db.newIterator(nil, nil, snap.elem.seq, slice, ro)
 }
 
@@ -179,9 +326,18 @@ func (snap *Snapshot) Release() {
 		runtime.SetFinalizer(snap, nil)
 
 		snap.released = true
-		snap.db.releaseSnapshot(snap.elem)
-		atomic.AddInt32(&snap.db.aliveSnaps, -1)
+		db := snap.db
+		db.snapsMu.Lock()
+		// An expired snapshot is already released.
+		if snap.rec.e != nil {
+			db.snapsHeld.Remove(snap.rec.e)
+			snap.rec.e = nil
+			db.unrefSnapshot(snap.elem)
+			atomic.AddInt32(&db.aliveSnaps, -1)
+		}
+		db.snapsMu.Unlock()
 		snap.db = nil
 		snap.elem = nil
+		snap.rec = nil
 	}
 }
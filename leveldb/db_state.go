@@ -7,12 +7,13 @@
 package leveldb
 
 import (
-	"errors"
 	"sync/atomic"
 	"time"
 
+	"github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/journal"
 	"github.com/syndtr/goleveldb/leveldb/memdb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/storage"
 )
 
@@ -22,8 +23,13 @@ var (
 
 type memDB struct {
 	db *DB
-	*memdb.DB
+	memdb.Memtable
 	ref int32
+
+	// Only set once frozen: the journal backing the memdb and the last
+	// sequence number written to it.
+	journalFd storage.FileDesc
+	seq       uint64
 }
 
 func (m *memDB) getref() int32 {
@@ -39,10 +45,10 @@ func (m *memDB) decref() {
 		// Only put back memdb with std capacity.
 		if m.Capacity() == m.db.s.o.GetWriteBuffer() {
 			m.Reset()
-			m.db.mpoolPut(m.DB)
+			m.db.mpoolPut(m.Memtable)
 		}
 		m.db = nil
-		m.DB = nil
+		m.Memtable = nil
 	} else if ref < 0 {
 		panic("negative memdb ref")
 	}
@@ -71,7 +77,7 @@ func (db *DB) sampleSeek(ikey internalKey) {
 	v.release()
 }
 
-func (db *DB) mpoolPut(mem *memdb.DB) {
+func (db *DB) mpoolPut(mem memdb.Memtable) {
 	if !db.isClosed() {
 		select {
 		case db.memPool <- mem:
@@ -81,20 +87,42 @@ func (db *DB) mpoolPut(mem *memdb.DB) {
 }
 
 func (db *DB) mpoolGet(n int) *memDB {
-	var mdb *memdb.DB
+	var mdb memdb.Memtable
 	select {
 	case mdb = <-db.memPool:
 	default:
 	}
 	if mdb == nil || mdb.Capacity() < n {
-		mdb = memdb.New(db.s.icmp, maxInt(db.s.o.GetWriteBuffer(), n))
+		mdb = db.newMemtable(maxInt(db.s.o.GetWriteBuffer(), n))
 	}
 	return &memDB{
-		db: db,
-		DB: mdb,
+		db:       db,
+		Memtable: mdb,
 	}
 }
 
+func (db *DB) newMemtable(capacity int) memdb.Memtable {
+	ukey := func(ikey []byte) []byte {
+		return internalKey(ikey).ukey()
+	}
+	var (
+		mdb  memdb.Memtable
+		base *memdb.DB
+	)
+	switch db.s.o.GetMemtable() {
+	case opt.HashSkiplistMemtable:
+		hdb := memdb.NewHash(db.s.icmp, capacity, ukey)
+		mdb, base = hdb, hdb.DB
+	default:
+		base = memdb.NewSharded(db.s.icmp, capacity, db.s.o.GetWriteBufferShards(), ukey)
+		mdb = base
+	}
+	if ratio := db.s.o.GetWriteBufferFilterRatio(); ratio > 0 {
+		base.SetFilter(int(float64(capacity)*ratio*8), ukey)
+	}
+	return mdb
+}
+
 func (db *DB) mpoolDrain() {
 	ticker := time.NewTicker(30 * time.Second)
 	for {
@@ -121,7 +149,7 @@ func (db *DB) mpoolDrain() {
 // newMem only called synchronously by the writer.
 func (db *DB) newMem(n int) (mem *memDB, err error) {
 	fd := storage.FileDesc{Type: storage.TypeJournal, Num: db.s.allocFileNum()}
-	w, err := db.s.stor.Create(fd)
+	w, err := db.createJournal(fd)
 	if err != nil {
 		db.s.reuseFileNum(fd.Num)
 		return
@@ -130,47 +158,106 @@ func (db *DB) newMem(n int) (mem *memDB, err error) {
 	db.memMu.Lock()
 	defer db.memMu.Unlock()
 
-	if db.frozenMem != nil {
+	if len(db.frozenMems) >= db.s.o.GetMaxFrozenWriteBuffer() {
 		return nil, errHasFrozenMem
 	}
 
+	recycle := db.recycleJournals()
 	if db.journal == nil {
-		db.journal = journal.NewWriter(w)
+		if recycle {
+			db.journal = journal.NewRecyclableWriter(w, uint32(fd.Num))
+		} else {
+			db.journal = journal.NewWriter(w)
+		}
 	} else {
-		if err := db.journal.Reset(w); err != nil {
+		if recycle {
+			err = db.journal.ResetRecyclable(w, uint32(fd.Num))
+		} else {
+			err = db.journal.Reset(w)
+		}
+		if err != nil {
 			return nil, err
 		}
+		// Sync the old journal, so that its unsynced writes can't be lost
+		// while the following ones, synced to the new journal, survive a
+		// crash.
+		if !db.s.o.GetNoSync() {
+			if err := db.journalWriter.Sync(); err != nil {
+				return nil, err
+			}
+		}
 		if err := db.journalWriter.Close(); err != nil {
 			return nil, err
 		}
-		db.frozenJournalFd = db.journalFd
+		// The seq only incremented by the writer. And whoever called newMem
+		// should hold write lock, so no need additional synchronization here.
+		db.mem.journalFd = db.journalFd
+		db.mem.seq = db.seq
+		db.frozenMems = append(db.frozenMems, db.mem)
 	}
 	db.journalWriter = w
 	db.journalFd = fd
-	db.frozenMem = db.mem
 	mem = db.mpoolGet(n)
 	mem.incref() // for self
 	mem.incref() // for caller
 	db.mem = mem
-	// The seq only incremented by the writer. And whoever called newMem
-	// should hold write lock, so no need additional synchronization here.
-	db.frozenSeq = db.seq
 	return
 }
 
-// Get all memdbs.
-func (db *DB) getMems() (e, f *memDB) {
+// Whether journals are written in the recyclable format, and obsolete ones
+// kept for reuse.
+func (db *DB) recycleJournals() bool {
+	return db.s.o.GetRecycleJournalFiles() > 0 && db.s.stor.canRecycle()
+}
+
+// Create the journal file, reusing the oldest kept obsolete journal if any.
+func (db *DB) createJournal(fd storage.FileDesc) (storage.Writer, error) {
+	db.memMu.Lock()
+	var rfd storage.FileDesc
+	if len(db.recycleFds) > 0 {
+		rfd = db.recycleFds[0]
+		db.recycleFds = db.recycleFds[1:]
+	}
+	db.memMu.Unlock()
+
+	if !rfd.Zero() {
+		w, err := db.s.stor.Recycle(rfd, fd)
+		if err == nil {
+			db.logInfo("journal@recycle reused", "num", rfd.Num, "as", fd.Num)
+			return w, nil
+		}
+		db.logWarn("journal@recycle reusing", "num", rfd.Num, "err", err)
+		if err := db.s.stor.Remove(rfd); err != nil {
+			db.logWarn("journal@recycle removing", "num", rfd.Num, "err", err)
+		}
+	}
+	w, err := db.s.stor.Create(fd)
+	if err != nil {
+		return nil, errors.NewOpError("create", fd, err)
+	}
+	return w, nil
+}
+
+// Get all memdbs, newest first.
+func (db *DB) getMems() []*memDB {
+	return db.appendMems(nil)
+}
+
+// Appends all memdbs to mems, newest first, e.g. into stack space.
+func (db *DB) appendMems(mems []*memDB) []*memDB {
 	db.memMu.RLock()
 	defer db.memMu.RUnlock()
 	if db.mem != nil {
 		db.mem.incref()
+		mems = append(mems, db.mem)
 	} else if !db.isClosed() {
 		panic("nil effective mem")
 	}
-	if db.frozenMem != nil {
-		db.frozenMem.incref()
+	for i := len(db.frozenMems) - 1; i >= 0; i-- {
+		db.frozenMems[i].incref()
+		mems = append(mems, db.frozenMems[i])
 	}
-	return db.mem, db.frozenMem
+	return mems
 }
 
 // Get effective memdb.
@@ -185,35 +272,66 @@ func (db *DB) getEffectiveMem() *memDB {
 	return db.mem
 }
 
-// Get frozen memdb.
+// Get oldest frozen memdb.
 func (db *DB) getFrozenMem() *memDB {
 	db.memMu.RLock()
 	defer db.memMu.RUnlock()
-	if db.frozenMem != nil {
-		db.frozenMem.incref()
+	if len(db.frozenMems) == 0 {
+		return nil
 	}
-	return db.frozenMem
+	db.frozenMems[0].incref()
+	return db.frozenMems[0]
+}
+
+// Get number of frozen memdbs.
+func (db *DB) frozenMemLen() int {
+	db.memMu.RLock()
+	defer db.memMu.RUnlock()
+	return len(db.frozenMems)
 }
 
-// Drop frozen memdb; assume that frozen memdb isn't nil.
+// Get oldest journal that is still needed once the oldest frozen memdb
+// is dropped.
+func (db *DB) nextJournalFd() storage.FileDesc {
+	db.memMu.RLock()
+	defer db.memMu.RUnlock()
+	if len(db.frozenMems) > 1 {
+		return db.frozenMems[1].journalFd
+	}
+	return db.journalFd
+}
+
+// Drop oldest frozen memdb; assume that frozen memdb isn't nil.
 func (db *DB) dropFrozenMem() {
 	db.memMu.Lock()
-	if err := db.s.stor.Remove(db.frozenJournalFd); err != nil {
-		db.logf("journal@remove removing @%d %q", db.frozenJournalFd.Num, err)
-	} else {
-		db.logf("journal@remove removed @%d", db.frozenJournalFd.Num)
+	mem := db.frozenMems[0]
+	fd := mem.journalFd
+	keep := db.recycleJournals() && len(db.recycleFds) < db.s.o.GetRecycleJournalFiles()
+	if keep {
+		db.recycleFds = append(db.recycleFds, fd)
+		db.logInfo("journal@recycle kept", "num", fd.Num)
 	}
-	db.frozenJournalFd = storage.FileDesc{}
-	db.frozenMem.decref()
-	db.frozenMem = nil
+	db.frozenMems[0] = nil
+	db.frozenMems = db.frozenMems[1:]
+	mem.decref()
 	db.memMu.Unlock()
+
+	if keep {
+		return
+	}
+	if err := db.removeJournal(fd); err != nil {
+		db.logWarn("journal@remove removing", "num", fd.Num, "err", err)
+	} else if db.s.o.GetJournalArchive() == nil {
+		// Otherwise logged once archived.
+		db.logInfo("journal@remove removed", "num", fd.Num)
+	}
 }
 
 // Clear mems ptr; used by DB.Close().
 func (db *DB) clearMems() {
 	db.memMu.Lock()
 	db.mem = nil
-	db.frozenMem = nil
+	db.frozenMems = nil
 	db.memMu.Unlock()
 }
 
@@ -9,12 +9,18 @@ package leveldb
 import (
 	"bytes"
 	"container/list"
+	"context"
 	crand "crypto/rand"
 	"encoding/binary"
+	"encoding/json"
+	"expvar"
 	"fmt"
+	"log/slog"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
 	"runtime"
 	"strings"
 	"sync"
@@ -31,6 +37,7 @@ import (
 	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/table"
 	"github.com/syndtr/goleveldb/leveldb/testutil"
 	"github.com/syndtr/goleveldb/leveldb/util"
 )
@@ -655,6 +662,119 @@ func TestDB_GetFromFrozen(t *testing.T) {
 	h.get("k2", true)
 }
 
+func TestDB_GetTo(t *testing.T) {
+	trun(t, func(h *dbHarness) {
+		h.put("a", "va1")
+		h.put("b", "vb1")
+		h.put("z", "vz1")
+		h.compactMem()
+		h.put("a", "va2")
+		h.put("z", "vz2")
+		h.compactMem()
+		h.put("a", "va3")
+		h.delete("b")
+
+		snap := h.getSnapshot()
+		defer snap.Release()
+		h.put("a", "va4")
+
+		getTo := func(get func(key, dst []byte, ro *opt.ReadOptions) ([]byte, error), key, want string) {
+			t.Helper()
+			dst := append(make([]byte, 0, 64), "prefix:"...)
+			v, err := get([]byte(key), dst, h.ro)
+			if want == "" {
+				if err != ErrNotFound {
+					t.Errorf("GetTo %q: got error %v, want ErrNotFound", key, err)
+				}
+				if string(v) != "prefix:" {
+					t.Errorf("GetTo %q: got %q on error, want dst", key, v)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("GetTo %q: got error: %v", key, err)
+			} else if string(v) != "prefix:"+want {
+				t.Errorf("GetTo %q: got %q, want %q", key, v, "prefix:"+want)
+			} else if &v[0] != &dst[0] {
+				t.Errorf("GetTo %q: didn't reuse dst", key)
+			}
+		}
+		getTo(h.db.GetTo, "a", "va4")
+		getTo(h.db.GetTo, "b", "")
+		getTo(h.db.GetTo, "z", "vz2")
+		getTo(h.db.GetTo, "x", "")
+		getTo(snap.GetTo, "a", "va3")
+		getTo(snap.GetTo, "z", "vz2")
+
+		tr, err := h.db.OpenTransaction()
+		if err != nil {
+			t.Fatal("OpenTransaction: got error: ", err)
+		}
+		if err := tr.Put([]byte("b"), []byte("vb2"), nil); err != nil {
+			t.Fatal("Put: got error: ", err)
+		}
+		getTo(tr.GetTo, "a", "va4")
+		getTo(tr.GetTo, "b", "vb2")
+		tr.Discard()
+
+		// The value isn't allocated when dst is large enough.
+		h.compactMem()
+		key, buf := []byte("z"), make([]byte, 0, 64)
+		getAllocs := testing.AllocsPerRun(100, func() {
+			_, _ = h.db.Get(key, h.ro)
+		})
+		getToAllocs := testing.AllocsPerRun(100, func() {
+			buf, _ = h.db.GetTo(key, buf[:0], h.ro)
+		})
+		if getToAllocs >= getAllocs {
+			t.Errorf("GetTo allocates %v times, not less than Get %v times", getToAllocs, getAllocs)
+		}
+
+		// Nor anything else on a memdb hit.
+		h.put("m", "vm")
+		key = []byte("m")
+		if n := testing.AllocsPerRun(100, func() {
+			buf, _ = h.db.GetTo(key, buf[:0], h.ro)
+		}); n != 0 {
+			t.Errorf("GetTo allocates %v times on a memdb hit, want 0", n)
+		}
+	})
+}
+
+func TestDB_MultipleFrozenMems(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		WriteBuffer:                  100100,
+		MaxFrozenWriteBuffer:         3,
+	})
+	defer h.close()
+
+	h.put("foo", "v1")
+
+	h.stor.Stall(testutil.ModeSync, storage.TypeTable) // Block sync calls
+	for i := 0; i < 4; i++ {
+		h.put(fmt.Sprintf("k%d", i), strings.Repeat("x", 100000))
+	}
+	for i := 0; h.db.frozenMemLen() < 3 && i < 100; i++ {
+		time.Sleep(10 * time.Microsecond)
+	}
+	if n := h.db.frozenMemLen(); n != 3 {
+		h.stor.Release(testutil.ModeSync, storage.TypeTable)
+		t.Fatalf("invalid number of frozen mems, want=3 got=%d", n)
+	}
+	h.getVal("foo", "v1")
+	for i := 0; i < 4; i++ {
+		h.get(fmt.Sprintf("k%d", i), true)
+	}
+	h.stor.Release(testutil.ModeSync, storage.TypeTable) // Release sync calls
+
+	h.reopenDB()
+	h.getVal("foo", "v1")
+	for i := 0; i < 4; i++ {
+		h.get(fmt.Sprintf("k%d", i), true)
+	}
+}
+
 func TestDB_GetFromTable(t *testing.T) {
 	trun(t, func(h *dbHarness) {
 		h.put("foo", "v1")
@@ -697,6 +817,425 @@ func TestDB_GetSnapshot(t *testing.T) {
 	})
 }
 
+func TestDB_HashSkiplistMemtable(t *testing.T) {
+	truno(t, &opt.Options{Memtable: opt.HashSkiplistMemtable}, func(h *dbHarness) {
+		var snaps []*Snapshot
+		for i := 0; i < 40; i++ {
+			h.put("foo", fmt.Sprintf("v%d", i))
+			h.put(fmt.Sprintf("foo%d", i), "x")
+			snap, err := h.db.GetSnapshot()
+			if err != nil {
+				t.Fatal("GetSnapshot: got error: ", err)
+			}
+			snaps = append(snaps, snap)
+		}
+		h.delete("foo")
+		h.get("foo", false)
+		h.get("fo", false)
+		h.getVal("foo0", "x")
+		for i, snap := range snaps {
+			h.getValr(snap, "foo", fmt.Sprintf("v%d", i))
+			snap.Release()
+		}
+
+		h.put("foo", "v")
+		h.getVal("foo", "v")
+		h.compactMem()
+		h.getVal("foo", "v")
+	})
+}
+
+func TestDB_MemdbFilter(t *testing.T) {
+	truno(t, &opt.Options{WriteBufferFilterRatio: 0.01}, func(h *dbHarness) {
+		h.put("foo", "v1")
+		snap, err := h.db.GetSnapshot()
+		if err != nil {
+			t.Fatal("GetSnapshot: got error: ", err)
+		}
+		defer snap.Release()
+		h.put("foo", "v2")
+		h.delete("bar")
+		h.getVal("foo", "v2")
+		h.getValr(snap, "foo", "v1")
+		h.get("bar", false)
+		h.get("baz", false)
+
+		h.compactMem()
+		h.put("baz", "v1")
+		h.getVal("foo", "v2")
+		h.getVal("baz", "v1")
+	})
+}
+
+func TestDB_SharedBlockCache(t *testing.T) {
+	gomega.RegisterTestingT(t)
+	const capacity = 64 * opt.KiB
+	// A passthrough cacher has a capacity of its own.
+	o := &opt.Options{
+		BlockCacher:                  opt.NewStrictLRU(capacity),
+		DisableLargeBatchTransaction: true,
+	}
+
+	var dbs []*DB
+	for i := 0; i < 2; i++ {
+		stor := testutil.NewStorage()
+		defer stor.Close()
+		db, err := Open(stor, o)
+		if err != nil {
+			t.Fatal("Open: got error: ", err)
+		}
+		defer db.Close()
+		dbs = append(dbs, db)
+	}
+
+	value := bytes.Repeat([]byte{'x'}, 1000)
+	for _, db := range dbs {
+		for i := 0; i < 200; i++ {
+			if err := db.Put([]byte(fmt.Sprintf("%06d", i)), value, nil); err != nil {
+				t.Fatal("Put: got error: ", err)
+			}
+		}
+		if err := db.CompactRange(util.Range{}); err != nil {
+			t.Fatal("CompactRange: got error: ", err)
+		}
+	}
+	readAll := func(db *DB) {
+		for i := 0; i < 200; i++ {
+			v, err := db.Get([]byte(fmt.Sprintf("%06d", i)), nil)
+			if err != nil {
+				t.Fatal("Get: got error: ", err)
+			}
+			if !bytes.Equal(v, value) {
+				t.Fatalf("Get: invalid value for key %06d", i)
+			}
+		}
+	}
+	for _, db := range dbs {
+		readAll(db)
+	}
+
+	if n := dbs[0].s.tops.blockCache.Capacity(); n != capacity {
+		t.Errorf("block cache capacity: got %d, want %d", n, capacity)
+	}
+	size0, size1 := dbs[0].s.tops.blockCache.Size(), dbs[1].s.tops.blockCache.Size()
+	if size1 == 0 {
+		t.Error("block cache is empty after reads")
+	}
+	if size0+size1 > capacity {
+		t.Errorf("combined block cache size %d exceeds capacity %d", size0+size1, capacity)
+	}
+
+	// Closing one DB must not disturb the other.
+	if err := dbs[0].Close(); err != nil {
+		t.Fatal("Close: got error: ", err)
+	}
+	readAll(dbs[1])
+	if n := dbs[1].s.tops.blockCache.Size(); n == 0 {
+		t.Error("block cache of remaining DB is empty")
+	}
+}
+
+func TestDB_BlockCacheDump(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		Filter:                       filter.NewBloomFilter(10),
+		DisableLargeBatchTransaction: true,
+	})
+	defer h.close()
+
+	value := strings.Repeat("x", 1000)
+	for i := 0; i < 200; i++ {
+		h.put(fmt.Sprintf("%06d", i), value)
+	}
+	h.compactMem()
+	for i := 0; i < 200; i += 20 {
+		h.getVal(fmt.Sprintf("%06d", i), value)
+	}
+	cached := h.db.s.tops.blockCache.Size()
+	if cached == 0 {
+		t.Fatal("block cache is empty after reads")
+	}
+
+	var dump bytes.Buffer
+	if err := h.db.DumpBlockCache(&dump); err != nil {
+		t.Fatal("DumpBlockCache: got error: ", err)
+	}
+	h.reopenDB()
+	if n := h.db.s.tops.blockCache.Size(); n != 0 {
+		t.Fatalf("block cache is not empty after reopen, got %d", n)
+	}
+	if err := h.db.WarmBlockCache(bytes.NewReader(dump.Bytes())); err != nil {
+		t.Fatal("WarmBlockCache: got error: ", err)
+	}
+	// Block buffers may be sized differently, so only roughly compare.
+	if n := h.db.s.tops.blockCache.Size(); n < cached*9/10 {
+		t.Errorf("block cache size after warm-up: got %d, want about %d", n, cached)
+	}
+	misses := h.db.s.tops.blockCache.GetStats().MissCount
+	for i := 0; i < 200; i += 20 {
+		h.getVal(fmt.Sprintf("%06d", i), value)
+	}
+	if n := h.db.s.tops.blockCache.GetStats().MissCount - misses; n != 0 {
+		t.Errorf("reads after warm-up missed the block cache %d times", n)
+	}
+
+	// Blocks of tables which no longer exist are skipped.
+	h.compactRange("", "")
+	if err := h.db.WarmBlockCache(bytes.NewReader(dump.Bytes())); err != nil {
+		t.Fatal("WarmBlockCache: got error: ", err)
+	}
+
+	if err := h.db.WarmBlockCache(bytes.NewReader(dump.Bytes()[:dump.Len()-1])); err == nil {
+		t.Error("WarmBlockCache: expected error on truncated dump")
+	}
+	if err := h.db.WarmBlockCache(strings.NewReader("garbage")); err == nil {
+		t.Error("WarmBlockCache: expected error on invalid dump")
+	}
+}
+
+func TestDB_CompressedBlockCache(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		BlockCacheCapacity:           8 * opt.KiB,
+		CompressedBlockCacheCapacity: opt.MiB,
+		DisableLargeBatchTransaction: true,
+	})
+	defer h.close()
+
+	value := strings.Repeat("x", 1000)
+	for i := 0; i < 200; i++ {
+		h.put(fmt.Sprintf("%06d", i), value)
+	}
+	h.compactMem()
+
+	readAll := func() {
+		for i := 0; i < 200; i++ {
+			h.getVal(fmt.Sprintf("%06d", i), value)
+		}
+	}
+	readAll()
+	ccache := h.db.s.tops.compressedBlockCache
+	if ccache.Size() == 0 {
+		t.Fatal("compressed block cache is empty after reads")
+	}
+	if ccache.Size() >= h.db.s.tops.blockCache.Capacity()*4 {
+		t.Errorf("compressed block cache holds uncompressed blocks, size %d", ccache.Size())
+	}
+
+	// Blocks evicted from the block cache are served by the compressed
+	// block cache.
+	hits := ccache.GetStats().HitCount
+	readAll()
+	if ccache.GetStats().HitCount == hits {
+		t.Error("reads didn't hit the compressed block cache")
+	}
+	if v, err := h.db.GetProperty("leveldb.compressedcachedblock"); err != nil || v == "0" {
+		t.Errorf("leveldb.compressedcachedblock: got %q, %v", v, err)
+	}
+}
+
+func TestDB_BlockSizePerLevel(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		BlockSizePerLevel:            []int{256, 64 * opt.KiB},
+		BlockRestartIntervalPerLevel: []int{0, 32},
+	})
+	defer h.close()
+
+	// Index size grows with the number of data blocks.
+	indexSize := func(level int) int {
+		v := h.db.s.version()
+		defer v.release()
+		if len(v.levels) <= level || len(v.levels[level]) == 0 {
+			t.Fatalf("no table at level-%d", level)
+		}
+		ch, err := h.db.s.tops.open(v.levels[level][0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ch.Release()
+		return ch.Value().(*table.Reader).MetaBlocksSize()
+	}
+
+	value := strings.Repeat("x", 100)
+	for n := 0; n < 2; n++ {
+		for i := 0; i < 500; i++ {
+			h.put(fmt.Sprintf("%06d", i), value)
+		}
+		h.compactMem()
+	}
+	h.tablesPerLevel("2")
+	l0Size := indexSize(0)
+
+	h.compactRangeAt(0, "", "")
+	h.tablesPerLevel("0,1")
+	if l1Size := indexSize(1); l1Size*10 > l0Size {
+		t.Errorf("level-1 table doesn't use larger blocks, index size %d vs %d at level-0", l1Size, l0Size)
+	}
+	for i := 0; i < 500; i++ {
+		h.getVal(fmt.Sprintf("%06d", i), value)
+	}
+}
+
+func TestDB_PinIndexAndFilterBlocks(t *testing.T) {
+	truno(t, &opt.Options{
+		Filter:                  filter.NewBloomFilter(10),
+		PinIndexAndFilterBlocks: true,
+	}, func(h *dbHarness) {
+		h.put("foo", "v1")
+		h.put("bar", "v1")
+		h.compactMem()
+		h.getVal("foo", "v1")
+		h.get("baz", false)
+
+		pinned, err := h.db.GetProperty("leveldb.pinnedblock")
+		if err != nil {
+			t.Fatal("GetProperty: got error: ", err)
+		}
+		if pinned == "0" {
+			t.Error("index and filter blocks are not pinned")
+		}
+
+		// Pinned blocks are released along with the table.
+		h.db.s.tops.fileCache.EvictAll()
+		pinned, err = h.db.GetProperty("leveldb.pinnedblock")
+		if err != nil {
+			t.Fatal("GetProperty: got error: ", err)
+		}
+		if pinned != "0" {
+			t.Errorf("pinned blocks after tables closed: got %s, want 0", pinned)
+		}
+		h.getVal("foo", "v1")
+	})
+}
+
+func TestDB_PinIndexAndFilterBlocksBudget(t *testing.T) {
+	truno(t, &opt.Options{
+		PinIndexAndFilterBlocks:       true,
+		PinIndexAndFilterBlocksBudget: 1,
+	}, func(h *dbHarness) {
+		h.put("foo", "v1")
+		h.compactMem()
+		h.getVal("foo", "v1")
+		if pinned := atomic.LoadInt64(&h.db.s.tops.pinned); pinned != 0 {
+			t.Errorf("pinned blocks over budget: got %d, want 0", pinned)
+		}
+	})
+}
+
+func TestDB_PartitionedIndex(t *testing.T) {
+	truno(t, &opt.Options{
+		BlockSize:          256,
+		IndexPartitionSize: 128,
+		Filter:             filter.NewBloomFilter(10),
+	}, func(h *dbHarness) {
+		const n = 500
+		for i := 0; i < n; i++ {
+			h.put(fmt.Sprintf("key%05d", i), fmt.Sprintf("value%05d", i))
+		}
+		h.compactMem()
+		for i := 0; i < n; i++ {
+			h.getVal(fmt.Sprintf("key%05d", i), fmt.Sprintf("value%05d", i))
+		}
+		h.get("key", false)
+		h.get("key00000x", false)
+		h.get("zzz", false)
+
+		iter := h.db.NewIterator(&util.Range{Start: []byte("key00100"), Limit: []byte("key00200")}, nil)
+		var count int
+		for iter.Next() {
+			count++
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			t.Fatal("iterator: got error: ", err)
+		}
+		if count != 100 {
+			t.Errorf("iterator: got %d keys, want 100", count)
+		}
+	})
+}
+
+func TestDB_FullTableFilter(t *testing.T) {
+	truno(t, &opt.Options{
+		Filter:          filter.NewBloomFilter(10),
+		FullTableFilter: true,
+	}, func(h *dbHarness) {
+		h.put("foo", "v1")
+		h.put("bar", "v1")
+		h.compactMem()
+		h.put("foo", "v2")
+		h.compactMem()
+		h.getVal("foo", "v2")
+		h.getVal("bar", "v1")
+		h.get("baz", false)
+
+		// Tables without full filter remain readable.
+		h.o.FullTableFilter = false
+		h.reopenDB()
+		h.put("baz", "v1")
+		h.compactMem()
+		h.getVal("foo", "v2")
+		h.getVal("bar", "v1")
+		h.getVal("baz", "v1")
+		h.get("qux", false)
+	})
+}
+
+func TestDB_PrefixFilter(t *testing.T) {
+	for _, o := range []*opt.Options{
+		{Filter: filter.NewFixedPrefixFilter(filter.NewBloomFilter(10), 3)},
+		{Filter: filter.NewFixedPrefixFilter(filter.NewBloomFilter(10), 3), FullTableFilter: true},
+		{Filter: filter.NewFixedPrefixFilter(filter.NewBloomFilter(10), 3), IndexPartitionSize: 64},
+	} {
+		func() {
+			h := newDbHarnessWopt(t, o)
+			defer h.close()
+			h.db.memdbMaxLevel = 0
+			for i := 0; i < 50; i++ {
+				h.put(fmt.Sprintf("aaa%03d", i), "v")
+				h.put(fmt.Sprintf("ccc%03d", i), "v")
+			}
+			h.compactMem()
+			for i := 0; i < 50; i++ {
+				h.put(fmt.Sprintf("bbb%03d", i), "v")
+			}
+			h.compactMem()
+			h.put("bbb", "v")
+
+			// Both tables overlap "bbb", only one holds such prefix.
+			slice := util.BytesPrefix([]byte("bbb"))
+			islice := &util.Range{
+				Start: makeInternalKey(nil, slice.Start, keyMaxSeq, keyTypeSeek),
+				Limit: makeInternalKey(nil, slice.Limit, keyMaxSeq, keyTypeSeek),
+			}
+			v := h.db.s.version()
+			its := v.getIterators(islice, nil)
+			for _, it := range its {
+				it.Release()
+			}
+			v.release()
+			if len(its) != 1 {
+				t.Errorf("prefix iterators: got %d table iterators, want 1", len(its))
+			}
+
+			iter := h.db.NewIterator(slice, nil)
+			var n int
+			for iter.Next() {
+				n++
+			}
+			if iter.Seek([]byte("bbb025")) && string(iter.Key()) != "bbb025" {
+				t.Errorf("prefix seek: got key %q", iter.Key())
+			}
+			iter.Release()
+			if err := iter.Error(); err != nil {
+				t.Fatal("iterator: got error: ", err)
+			}
+			if n != 51 {
+				t.Errorf("prefix iterator: got %d keys, want 51", n)
+			}
+		}()
+	}
+}
+
 func TestDB_GetLevel0Ordering(t *testing.T) {
 	trun(t, func(h *dbHarness) {
 		h.db.memdbMaxLevel = 2
@@ -1745,17 +2284,86 @@ func TestDB_BloomFilter(t *testing.T) {
 	h.stor.Release(testutil.ModeSync, storage.TypeTable)
 }
 
-func TestDB_Concurrent(t *testing.T) {
-	const n, secs, maxkey = 4, 6, 1000
-	h := newDbHarness(t)
-	defer h.close()
-
-	runtime.GOMAXPROCS(runtime.NumCPU())
+func TestDB_GetMulti(t *testing.T) {
+	for _, o := range []*opt.Options{
+		{Filter: filter.NewBloomFilter(10)},
+		{Filter: filter.NewBlockedBloomFilter(10), FullTableFilter: true},
+		{Filter: filter.NewBlockedBloomFilter(10), IndexPartitionSize: 64},
+	} {
+		func() {
+			name := o.Filter.Name()
+			o.DisableBlockCache = true
+			// Seek compactions would read the tables along.
+			o.DisableSeeksCompaction = true
+			h := newDbHarnessWopt(t, o)
+			defer h.close()
 
-	var (
-		closeWg sync.WaitGroup
-		stop    uint32
-		cnt     [n]uint32
+			key := func(i int) string {
+				return fmt.Sprintf("key%04d", i)
+			}
+			const n = 1000
+			for i := 0; i < n; i++ {
+				h.put(key(i), key(i))
+			}
+			h.compactMem()
+			for i := 0; i < n; i += 10 {
+				h.put(key(i), "v2")
+			}
+			h.compactMem()
+			h.delete(key(1))
+			h.put(key(2), "")
+			h.put(key(3), "v3")
+
+			var keys [][]byte
+			for i := 0; i < n; i += 3 {
+				keys = append(keys, []byte(key(i)), []byte(key(i)+".missing"))
+			}
+			values, err := h.db.GetMulti(keys, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, k := range keys {
+				want, err := h.db.Get(k, nil)
+				if err == ErrNotFound {
+					want = nil
+				} else if want == nil {
+					want = []byte{}
+				}
+				if (values[i] == nil) != (want == nil) || !bytes.Equal(values[i], want) {
+					t.Errorf("%s: key %q: got %q, want %q", name, k, values[i], want)
+				}
+			}
+
+			// Missing keys shouldn't read the tables more than Get does.
+			keys = keys[:0]
+			h.stor.ResetCounter(testutil.ModeRead, storage.TypeTable)
+			for i := 0; i < n; i++ {
+				keys = append(keys, []byte(key(i)+".missing"))
+				h.get(key(i)+".missing", false)
+			}
+			max, _ := h.stor.Counter(testutil.ModeRead, storage.TypeTable)
+			h.stor.ResetCounter(testutil.ModeRead, storage.TypeTable)
+			if _, err := h.db.GetMulti(keys, nil); err != nil {
+				t.Fatal(err)
+			}
+			if cnt, _ := h.stor.Counter(testutil.ModeRead, storage.TypeTable); cnt > max {
+				t.Errorf("%s: num of sstable I/O reads of missing keys was more than %d, got %d", name, max, cnt)
+			}
+		}()
+	}
+}
+
+func TestDB_Concurrent(t *testing.T) {
+	const n, secs, maxkey = 4, 6, 1000
+	h := newDbHarness(t)
+	defer h.close()
+
+	runtime.GOMAXPROCS(runtime.NumCPU())
+
+	var (
+		closeWg sync.WaitGroup
+		stop    uint32
+		cnt     [n]uint32
 	)
 
 	for i := 0; i < n; i++ {
@@ -1925,6 +2533,171 @@ func TestDB_ConcurrentWrite(t *testing.T) {
 	wg.Wait()
 }
 
+func TestDB_WriteMergeOptions(t *testing.T) {
+	const n, niter = 8, 5
+	test := func(o *opt.Options, mixed bool) (syncs int) {
+		h := newDbHarnessWopt(t, o)
+		defer h.close()
+
+		h.stor.ResetCounter(testutil.ModeSync, storage.TypeJournal)
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				wo := &opt.WriteOptions{Sync: !mixed || i%2 == 0}
+				for k := 0; k < niter; k++ {
+					key := []byte(fmt.Sprintf("%d.%d", i, k))
+					if err := h.db.Put(key, bytes.Repeat([]byte{'v'}, 100*i), wo); err != nil {
+						t.Error("Put: got error: ", err)
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		for i := 0; i < n; i++ {
+			for k := 0; k < niter; k++ {
+				h.getVal(fmt.Sprintf("%d.%d", i, k), strings.Repeat("v", 100*i))
+			}
+		}
+		syncs, _ = h.stor.Counter(testutil.ModeSync, storage.TypeJournal)
+		return
+	}
+
+	// Waiting for concurrent writes merges sync writes.
+	o := &opt.Options{
+		DisableLargeBatchTransaction: true,
+		WriteMergeWait:               20 * time.Millisecond,
+	}
+	if syncs := test(o, false); syncs > n*niter/2 {
+		t.Errorf("sync writes weren't merged, got %d syncs for %d writes", syncs, n*niter)
+	}
+
+	// Merged writes are limited in size.
+	o.WriteMergeMaxSize = 8
+	if syncs := test(o, false); syncs != n*niter {
+		t.Errorf("writes were merged beyond limit, got %d syncs for %d writes", syncs, n*niter)
+	}
+
+	// Sync and non-sync writes aren't merged, only sync writes are synced.
+	o.WriteMergeMaxSize = 0
+	o.WriteMergeNoMixedSync = true
+	if syncs := test(o, true); syncs > n*niter/2 {
+		t.Errorf("got %d syncs for %d sync writes", syncs, n*niter/2)
+	}
+}
+
+func TestDB_RecycleJournalFiles(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		RecycleJournalFiles:          1,
+	})
+	defer h.close()
+
+	// Fill the first journal with large records.
+	for i := 0; i < 100; i++ {
+		h.put(fmt.Sprintf("k%03d", i), strings.Repeat("x", 1000))
+	}
+	h.compactMem()
+	for i := 0; i < 100; i++ {
+		h.delete(fmt.Sprintf("k%03d", i))
+	}
+	h.compactMem()
+	if n, _ := h.stor.Counter(testutil.ModeRename, storage.TypeJournal); n != 1 {
+		t.Fatalf("expected one journal to be recycled, got %d", n)
+	}
+
+	// The current journal reuses the first one, which is then only
+	// partially overwritten.
+	h.put("foo", "v1")
+	h.reopenDB()
+	h.getVal("foo", "v1")
+	for i := 0; i < 100; i++ {
+		h.get(fmt.Sprintf("k%03d", i), false)
+	}
+}
+
+func TestDB_JournalCompression(t *testing.T) {
+	test := func(o *opt.Options) (written int64) {
+		h := newDbHarnessWopt(t, o)
+		defer h.close()
+
+		for i := 0; i < 100; i++ {
+			h.put(fmt.Sprintf("k%03d", i), strings.Repeat(fmt.Sprintf("v%03d", i), 250))
+		}
+		h.put("small", "v")
+		_, written = h.stor.Counter(testutil.ModeWrite, storage.TypeJournal)
+
+		// Journal is replayed regardless of the compression option.
+		o.JournalCompression = opt.NoCompression
+		h.reopenDB()
+		for i := 0; i < 100; i++ {
+			h.getVal(fmt.Sprintf("k%03d", i), strings.Repeat(fmt.Sprintf("v%03d", i), 250))
+		}
+		h.getVal("small", "v")
+		return
+	}
+
+	plain := test(&opt.Options{})
+	compressed := test(&opt.Options{JournalCompression: opt.SnappyCompression})
+	if compressed*4 > plain {
+		t.Errorf("journal records weren't compressed, got %d bytes vs %d bytes uncompressed", compressed, plain)
+	}
+}
+
+func TestDB_ConcurrentWriteShardedMemdb(t *testing.T) {
+	const n, niter = 10, 1000
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		WriteBufferShards:            4,
+	})
+	defer h.close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for k := 0; k < niter; k++ {
+				kstr := fmt.Sprintf("put-%d.%d", i, k)
+				vstr := fmt.Sprintf("v%d", k)
+				h.put(kstr, vstr)
+				h.getVal(kstr, vstr)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	iter := h.db.NewIterator(nil, nil)
+	var count int
+	for iter.Next() {
+		count++
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		t.Fatal("iterator error: ", err)
+	}
+	if count != n*niter {
+		t.Fatalf("invalid number of keys, want=%d got=%d", n*niter, count)
+	}
+
+	// Batches large enough to be put by a goroutine per shard, overwriting
+	// the keys so that they have several versions.
+	for round := 0; round < 3; round++ {
+		b := new(Batch)
+		for k := 0; k < 1000; k++ {
+			b.Put([]byte(fmt.Sprintf("big-%d", k)), []byte(strings.Repeat(fmt.Sprint(round), 100)))
+		}
+		if err := h.db.Write(b, nil); err != nil {
+			t.Fatal("write error: ", err)
+		}
+	}
+	for k := 0; k < 1000; k++ {
+		h.getVal(fmt.Sprintf("big-%d", k), strings.Repeat("2", 100))
+	}
+}
+
 func TestDB_CreateReopenDbOnFile(t *testing.T) {
 	dbpath := filepath.Join(os.TempDir(), fmt.Sprintf("goleveldbtestCreateReopenDbOnFile-%d", os.Getuid()))
 	if err := os.RemoveAll(dbpath); err != nil {
@@ -2611,7 +3384,7 @@ func TestDB_TableCompactionBuilder(t *testing.T) {
 		value      = bytes.Repeat([]byte{'0'}, 100)
 	)
 	for i := 0; i < 2; i++ {
-		tw, err := s.tops.create(0)
+		tw, err := s.tops.create(0, 0)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -3000,3 +3773,2397 @@ func TestDB_GracefulClose(t *testing.T) {
 	iter.Release()
 	closeWait.Wait()
 }
+
+func TestDB_WriteStallHandler(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		infos []opt.WriteStallInfo
+	)
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		CompactionL0Trigger:          100,
+		WriteL0SlowdownTrigger:       3,
+		WriteL0PauseTrigger:          100,
+		WriteStallHandler: func(info opt.WriteStallInfo) {
+			mu.Lock()
+			infos = append(infos, info)
+			mu.Unlock()
+		},
+	})
+	defer h.close()
+
+	lastInfo := func() (info opt.WriteStallInfo, n int) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(infos) > 0 {
+			info = infos[len(infos)-1]
+		}
+		return info, len(infos)
+	}
+
+	h.putMulti(3, "a", "z")
+	h.tablesPerLevel("3")
+	h.put("foo", "v1")
+
+	info, n := lastInfo()
+	if n == 0 {
+		t.Fatal("write stall handler not called")
+	}
+	if info.Condition != opt.WriteStallDelayed || info.Reason != opt.WriteStallReasonL0Files {
+		t.Fatalf("unexpected write stall info: %+v", info)
+	}
+	if info.Level0Tables != 3 {
+		t.Errorf("invalid level-0 tables count, want=3 got=%d", info.Level0Tables)
+	}
+
+	// Condition shouldn't change, so the handler shouldn't be called.
+	h.put("bar", "v1")
+	if _, n1 := lastInfo(); n1 != n {
+		t.Errorf("write stall handler called on unchanged condition")
+	}
+
+	h.compactRange("", "")
+	h.put("foo", "v2")
+	info, _ = lastInfo()
+	if info.Condition != opt.WriteStallNormal || info.PrevCondition != opt.WriteStallDelayed {
+		t.Fatalf("unexpected write stall info: %+v", info)
+	}
+}
+
+func TestDB_WriteStallPendingCompactionBytes(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		infos []opt.WriteStallInfo
+		allow atomic.Bool
+	)
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		CompactionL0Trigger:          2,
+		CompactionPolicy:             func(time.Time) bool { return allow.Load() },
+		CompactionPolicyL0Trigger:    100,
+		WriteL0SlowdownTrigger:       100,
+		WriteL0PauseTrigger:          100,
+
+		WritePendingCompactionBytesSlowdownTrigger: 1,
+		WriteStallHandler: func(info opt.WriteStallInfo) {
+			mu.Lock()
+			infos = append(infos, info)
+			mu.Unlock()
+		},
+	})
+	defer h.close()
+
+	lastInfo := func() (info opt.WriteStallInfo) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(infos) > 0 {
+			info = infos[len(infos)-1]
+		}
+		return info
+	}
+
+	// Level-0 needs compaction, which the policy defers.
+	h.putMulti(3, "a", "z")
+	h.tablesPerLevel("3")
+	h.put("foo", "v1")
+	info := lastInfo()
+	if info.Condition != opt.WriteStallDelayed || info.Reason != opt.WriteStallReasonPendingCompactionBytes {
+		t.Fatalf("unexpected write stall info: %+v", info)
+	}
+	if info.PendingCompactionBytes <= 0 {
+		t.Errorf("invalid pending compaction bytes, got %d", info.PendingCompactionBytes)
+	}
+
+	allow.Store(true)
+	h.compactRange("", "")
+	h.put("foo", "v2")
+	info = lastInfo()
+	if info.Condition != opt.WriteStallNormal || info.PrevCondition != opt.WriteStallDelayed {
+		t.Fatalf("unexpected write stall info: %+v", info)
+	}
+	if info.PendingCompactionBytes != 0 {
+		t.Errorf("invalid pending compaction bytes, want=0 got=%d", info.PendingCompactionBytes)
+	}
+
+	if err := (&opt.Options{
+		WritePendingCompactionBytesSlowdownTrigger: 2,
+		WritePendingCompactionBytesPauseTrigger:    1,
+	}).Validate(); err == nil {
+		t.Error("slowdown trigger above the pause trigger is valid")
+	}
+}
+
+type testEventListener struct {
+	opt.NoopEventListener
+
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *testEventListener) add(format string, v ...interface{}) {
+	l.mu.Lock()
+	l.events = append(l.events, fmt.Sprintf(format, v...))
+	l.mu.Unlock()
+}
+
+func (l *testEventListener) has(prefix string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, e := range l.events {
+		if strings.HasPrefix(e, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *testEventListener) OnFlushBegin(info opt.FlushInfo) {
+	l.add("flush-begin N·%d", info.Entries)
+}
+
+func (l *testEventListener) OnFlushEnd(info opt.FlushInfo) {
+	l.add("flush-end N·%d F·%d", info.Entries, len(info.Tables))
+}
+
+func (l *testEventListener) OnCompactionBegin(info opt.CompactionInfo) {
+	l.add("compaction-begin %s L%d", info.Reason, info.SourceLevel)
+}
+
+func (l *testEventListener) OnCompactionEnd(info opt.CompactionInfo) {
+	l.add("compaction-end %s L%d", info.Reason, info.SourceLevel)
+}
+
+func (l *testEventListener) OnTableCreated(info opt.TableInfo) {
+	l.add("table-created %s", info.Reason)
+}
+
+func (l *testEventListener) OnTableDeleted(info opt.TableInfo) {
+	l.add("table-deleted @%d", info.Num)
+}
+
+func (l *testEventListener) OnRecoveryBegin(info opt.RecoveryInfo) {
+	l.add("recovery-begin F·%d", info.Journals)
+}
+
+func (l *testEventListener) OnRecoveryEnd(info opt.RecoveryInfo) {
+	l.add("recovery-end F·%d", info.Journals)
+}
+
+func TestDB_EventListener(t *testing.T) {
+	listener := &testEventListener{}
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		EventListener:                listener,
+	})
+	defer h.close()
+
+	h.put("foo", "v1")
+	h.put("bar", "v1")
+	h.compactMem()
+	h.put("foo", "v2")
+	h.put("bar", "v2")
+	h.compactMem()
+	h.compactRange("", "")
+	// Obsolete tables are removed asynchronously.
+	for i := 0; i < 100 && !listener.has("table-deleted"); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	h.reopenDB()
+	h.getVal("foo", "v2")
+
+	for _, want := range []string{
+		"recovery-begin",
+		"recovery-end",
+		"flush-begin N·2",
+		"flush-end N·2 F·1",
+		"table-created flush",
+		"compaction-begin",
+		"compaction-end",
+		"table-created compaction",
+		"table-deleted",
+	} {
+		if !listener.has(want) {
+			t.Errorf("missing event %q, got %q", want, listener.events)
+		}
+	}
+}
+
+func TestDB_SharedScheduler(t *testing.T) {
+	sched := util.NewScheduler(1, 0, 0)
+	o := &opt.Options{
+		DisableLargeBatchTransaction: true,
+		WriteBuffer:                  10 * opt.KiB,
+		CompactionTableSize:          10 * opt.KiB,
+		Scheduler:                    sched,
+	}
+	h1 := newDbHarnessWopt(t, o)
+	defer h1.close()
+	h2 := newDbHarnessWopt(t, o)
+	defer h2.close()
+
+	const n = 2000
+	var wg sync.WaitGroup
+	for _, h := range []*dbHarness{h1, h2} {
+		wg.Add(1)
+		go func(h *dbHarness) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				if err := h.db.Put(tkey(i), tval(i, 100), nil); err != nil {
+					t.Errorf("Put: got error: %v", err)
+					return
+				}
+			}
+		}(h)
+	}
+	wg.Wait()
+
+	for _, h := range []*dbHarness{h1, h2} {
+		h.compactRange("", "")
+		for i := 0; i < n; i += 100 {
+			h.getVal(string(tkey(i)), string(tval(i, 100)))
+		}
+	}
+	if n := sched.Running(util.JobFlush) + sched.Running(util.JobCompaction); n != 0 {
+		t.Errorf("leaked scheduler jobs: %d", n)
+	}
+}
+
+// Counts the table compactions, and those running at a time.
+type compactionCountListener struct {
+	opt.NoopEventListener
+	begun, running, maxRunning int32
+}
+
+func (l *compactionCountListener) OnCompactionBegin(info opt.CompactionInfo) {
+	atomic.AddInt32(&l.begun, 1)
+	n := atomic.AddInt32(&l.running, 1)
+	for {
+		max := atomic.LoadInt32(&l.maxRunning)
+		if n <= max || atomic.CompareAndSwapInt32(&l.maxRunning, max, n) {
+			return
+		}
+	}
+}
+
+func (l *compactionCountListener) OnCompactionEnd(info opt.CompactionInfo) {
+	atomic.AddInt32(&l.running, -1)
+}
+
+// Builds four disjoint ranges of n keys, each with a level-1 table over a
+// level-2 one.
+func buildCompactionRanges(h *dbHarness, n int) {
+	h.db.memdbMaxLevel = 2
+	for _, v := range []string{"old", "new"} {
+		for r := 0; r < 4; r++ {
+			for i := 0; i < n; i++ {
+				h.put(fmt.Sprintf("%c%03d", 'a'+r, i), v)
+			}
+			h.compactMem()
+		}
+	}
+	h.tablesPerLevel("0,4,4")
+}
+
+func TestDB_CompactionConcurrency(t *testing.T) {
+	listener := &compactionCountListener{}
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		DisableSeeksCompaction:       true,
+		EventListener:                listener,
+	})
+	defer h.close()
+
+	const n = 50
+	buildCompactionRanges(h, n)
+
+	// With level-1 over its size, its tables are compacted together.
+	h.o.CompactionTotalSize = 1
+	h.o.CompactionConcurrency = 2
+	h.closeDB()
+	h.stor.Stall(testutil.ModeSync, storage.TypeTable)
+	h.openDB()
+
+	for start := time.Now(); atomic.LoadInt32(&listener.maxRunning) < 2 && time.Since(start) < 5*time.Second; {
+		time.Sleep(10 * time.Millisecond)
+	}
+	h.stor.Release(testutil.ModeSync, storage.TypeTable)
+	if n := atomic.LoadInt32(&listener.maxRunning); n != 2 {
+		t.Errorf("got %d compactions running at a time, want 2", n)
+	}
+
+	h.waitCompaction()
+	for r := 0; r < 4; r++ {
+		for i := 0; i < n; i++ {
+			h.getVal(fmt.Sprintf("%c%03d", 'a'+r, i), "new")
+		}
+	}
+	h.reopenDB()
+	h.getVal("a000", "new")
+	h.getVal(fmt.Sprintf("d%03d", n-1), "new")
+}
+
+func TestDB_CompactionConcurrencyStress(t *testing.T) {
+	// Picking a compaction races with the commits of the workers, if
+	// unsynchronized; run with -race.
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		WriteBuffer:                  16 * opt.KiB,
+		CompactionTableSize:          8 * opt.KiB,
+		CompactionTotalSize:          16 * opt.KiB,
+		CompactionConcurrency:        4,
+	})
+	defer h.close()
+
+	const n = 2000
+	writes := 50 * n
+	if testing.Short() {
+		writes = 10 * n
+	}
+	rnd := rand.New(rand.NewSource(0))
+	want := make(map[string]string)
+	for i := 0; i < writes; i++ {
+		key := fmt.Sprintf("%05d", rnd.Intn(n))
+		if rnd.Intn(10) == 0 {
+			h.delete(key)
+			delete(want, key)
+			continue
+		}
+		value := string(randomString(rnd, 100+rnd.Intn(300)))
+		h.put(key, value)
+		want[key] = value
+	}
+	check := func() {
+		for i := 0; i < n; i++ {
+			key := fmt.Sprintf("%05d", i)
+			if value, ok := want[key]; ok {
+				h.getVal(key, value)
+			} else {
+				h.get(key, false)
+			}
+		}
+	}
+	h.waitCompaction()
+	check()
+	h.reopenDB()
+	check()
+}
+
+func TestDB_ReusableIterator(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	for i := 0; i < 10; i++ {
+		h.put(fmt.Sprintf("k%d", i), fmt.Sprintf("v%d", i))
+	}
+	h.compactMem()
+	h.put("k3", "v3'")
+
+	collect := func(iter iterator.Iterator) (res []string) {
+		for iter.Next() {
+			res = append(res, string(iter.Key())+"="+string(iter.Value()))
+		}
+		if err := iter.Error(); err != nil {
+			t.Fatal("iterator error: ", err)
+		}
+		return
+	}
+
+	it := h.db.NewReusableIterator(&util.Range{Start: []byte("k2"), Limit: []byte("k5")}, nil)
+	if got, want := strings.Join(collect(it), ","), "k2=v2,k3=v3',k4=v4"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	// Reset sees writes made after the iterator was created.
+	h.put("k5", "v5'")
+	h.delete("k2")
+	if err := it.Reset(&util.Range{Start: []byte("k2"), Limit: []byte("k6")}, nil); err != nil {
+		t.Fatal("Reset: ", err)
+	}
+	if got, want := strings.Join(collect(it), ","), "k3=v3',k4=v4,k5=v5'"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if err := it.Reset(nil, nil); err != nil {
+		t.Fatal("Reset: ", err)
+	}
+	if got := len(collect(it)); got != 9 {
+		t.Fatalf("got %d entries, want 9", got)
+	}
+
+	slice := &util.Range{Start: []byte("k7")}
+	reuseAllocs := testing.AllocsPerRun(100, func() {
+		if err := it.Reset(slice, nil); err != nil {
+			t.Fatal("Reset: ", err)
+		}
+		it.Next()
+	})
+	newAllocs := testing.AllocsPerRun(100, func() {
+		iter := h.db.NewIterator(slice, nil)
+		iter.Next()
+		iter.Release()
+	})
+	if reuseAllocs >= newAllocs {
+		t.Errorf("Reset allocates %v times, NewIterator %v times", reuseAllocs, newAllocs)
+	}
+
+	it.Release()
+	if err := it.Reset(nil, nil); err != ErrIterReleased {
+		t.Fatalf("Reset after Release: got error %v, want %v", err, ErrIterReleased)
+	}
+	if n := atomic.LoadInt32(&h.db.aliveIters); n != 0 {
+		t.Fatalf("%d iterators still alive", n)
+	}
+}
+
+type deletionCounter struct {
+	n, deleted int
+}
+
+func (c *deletionCounter) Add(key, value []byte, deleted bool) {
+	c.n++
+	if deleted {
+		c.deleted++
+	}
+}
+
+func (c *deletionCounter) Finish(props map[string][]byte) {
+	props["deletions"] = []byte(fmt.Sprintf("%d/%d", c.deleted, c.n))
+}
+
+func TestDB_TableProperties(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		TablePropertiesCollectors: []func() opt.TablePropertiesCollector{
+			func() opt.TablePropertiesCollector { return &deletionCounter{} },
+		},
+	})
+	defer h.close()
+
+	for i := 0; i < 10; i++ {
+		h.put(fmt.Sprintf("k%d", i), fmt.Sprintf("v%d", i))
+	}
+	h.delete("k3")
+	h.delete("k7")
+	h.compactMem()
+
+	props, err := h.db.TableProperties()
+	if err != nil {
+		t.Fatal("TableProperties: ", err)
+	}
+	if len(props) != 1 {
+		t.Fatalf("got %d tables, want 1", len(props))
+	}
+	p := props[0]
+	if p.Properties == nil {
+		t.Fatal("table has no properties")
+	}
+	v := h.db.s.version()
+	defer v.release()
+	if p.Num != v.levels[p.Level][0].fd.Num || p.Size == 0 {
+		t.Errorf("bad table: level=%d num=%d size=%d", p.Level, p.Num, p.Size)
+	}
+	if p.NumEntries != 12 {
+		t.Errorf("got %d entries, want 12", p.NumEntries)
+	}
+	if string(p.SmallestKey) != "k0" || string(p.LargestKey) != "k9" {
+		t.Errorf("got key range %q..%q, want \"k0\"..\"k9\"", p.SmallestKey, p.LargestKey)
+	}
+	if got := string(p.User["deletions"]); got != "2/12" {
+		t.Errorf("got deletions property %q, want \"2/12\"", got)
+	}
+}
+
+func TestDB_PublishExpvar(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	const name = "leveldb_test_stats"
+	if err := h.db.PublishExpvar(name); err != nil {
+		t.Fatal("PublishExpvar: ", err)
+	}
+	h.put("foo", "v1")
+	h.compactMem()
+
+	var s DBStats
+	if err := json.Unmarshal([]byte(expvar.Get(name).String()), &s); err != nil {
+		t.Fatal("invalid expvar: ", err)
+	}
+	if s.IOWrite == 0 || s.MemComp != 1 {
+		t.Errorf("got IOWrite=%d MemComp=%d, want non-zero IOWrite and MemComp=1", s.IOWrite, s.MemComp)
+	}
+
+	// Publishing again under the same name, as after a reopen, is fine.
+	if err := h.db.PublishExpvar(name); err != nil {
+		t.Fatal("PublishExpvar again: ", err)
+	}
+	expvar.NewInt("leveldb_test_int")
+	if err := h.db.PublishExpvar("leveldb_test_int"); err == nil {
+		t.Error("PublishExpvar over another variable didn't fail")
+	}
+
+	h.closeDB()
+	if got := expvar.Get(name).String(); got != "null" {
+		t.Errorf("got %s after close, want null", got)
+	}
+}
+
+type testSpan struct {
+	name  string
+	attrs map[string]int64
+	ended bool
+	err   error
+}
+
+func (s *testSpan) SetAttribute(key string, value int64) { s.attrs[key] = value }
+
+func (s *testSpan) End(err error) {
+	s.ended = true
+	s.err = err
+}
+
+type testTracer struct {
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+func (t *testTracer) Start(name string) opt.Span {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := &testSpan{name: name, attrs: make(map[string]int64)}
+	t.spans = append(t.spans, s)
+	return s
+}
+
+// Returns the ended spans with the given name.
+func (t *testTracer) ended(name string) (res []*testSpan) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, s := range t.spans {
+		if s.name == name && s.ended {
+			res = append(res, s)
+		}
+	}
+	return
+}
+
+func TestDB_Tracer(t *testing.T) {
+	tracer := &testTracer{}
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		Tracer:                       tracer,
+	})
+	defer h.close()
+
+	h.put("foo", "v1")
+	b := new(Batch)
+	b.Put([]byte("bar"), []byte("v2"))
+	b.Delete([]byte("baz"))
+	if err := h.db.Write(b, nil); err != nil {
+		t.Fatal("Write: ", err)
+	}
+	h.getVal("foo", "v1")
+	h.get("missing", false)
+
+	writes := tracer.ended("leveldb.Write")
+	if len(writes) != 2 || writes[0].attrs["batch.records"] != 1 || writes[1].attrs["batch.records"] != 2 {
+		t.Errorf("bad write spans: %+v", writes)
+	}
+	gets := tracer.ended("leveldb.Get")
+	if len(gets) != 2 || gets[0].attrs["found"] != 1 || gets[1].attrs["found"] != 0 || gets[1].err != nil {
+		t.Errorf("bad get spans: %+v", gets)
+	}
+	if _, err := h.db.GetTo([]byte("foo"), nil, nil); err != nil {
+		t.Fatal("GetTo: ", err)
+	}
+	if gets := tracer.ended("leveldb.Get"); len(gets) != 3 || gets[2].attrs["found"] != 1 {
+		t.Errorf("bad GetTo span: %+v", gets)
+	}
+	if _, err := h.db.GetMulti([][]byte{[]byte("foo"), []byte("bar"), []byte("missing")}, nil); err != nil {
+		t.Fatal("GetMulti: ", err)
+	}
+	multis := tracer.ended("leveldb.GetMulti")
+	if len(multis) != 1 || multis[0].attrs["keys"] != 3 || multis[0].attrs["found"] != 2 {
+		t.Errorf("bad GetMulti spans: %+v", multis)
+	}
+
+	iter := h.db.NewIterator(nil, nil)
+	for iter.Next() {
+	}
+	if n := len(tracer.ended("leveldb.Iterator")); n != 0 {
+		t.Errorf("iterator span ended before release")
+	}
+	iter.Release()
+	if n := len(tracer.ended("leveldb.Iterator")); n != 1 {
+		t.Errorf("got %d iterator spans, want 1", n)
+	}
+
+	h.compactMem()
+	flushes := tracer.ended("leveldb.Flush")
+	if len(flushes) != 1 || flushes[0].attrs["entries"] != 3 || flushes[0].attrs["tables"] != 1 || flushes[0].attrs["bytes.written"] == 0 {
+		t.Errorf("bad flush spans: %+v", flushes)
+	}
+
+	h.put("foo", "v3")
+	h.compactMem()
+	h.compactRange("", "")
+	compactions := tracer.ended("leveldb.Compaction")
+	if len(compactions) == 0 {
+		t.Fatal("no compaction span")
+	}
+	for _, c := range compactions {
+		if c.attrs["tables.input"] == 0 || c.attrs["bytes.read"] == 0 {
+			t.Errorf("bad compaction span: %+v", c)
+		}
+	}
+}
+
+func TestDB_LatencyHistograms(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		LatencyHistograms:            true,
+	})
+	defer h.close()
+
+	for i := 0; i < 10; i++ {
+		h.put(fmt.Sprintf("k%d", i), "v")
+	}
+	if err := h.db.Put([]byte("sync"), []byte("v"), &opt.WriteOptions{Sync: true}); err != nil {
+		t.Fatal("Put: ", err)
+	}
+	h.getVal("k1", "v")
+	if _, err := h.db.GetTo([]byte("k2"), nil, nil); err != nil {
+		t.Fatal("GetTo: ", err)
+	}
+	if _, err := h.db.GetMulti([][]byte{[]byte("k3"), []byte("k4")}, nil); err != nil {
+		t.Fatal("GetMulti: ", err)
+	}
+	iter := h.db.NewIterator(nil, nil)
+	iter.Seek([]byte("k5"))
+	for iter.Next() {
+	}
+	iter.Release()
+	h.compactMem()
+
+	var s DBStats
+	if err := h.db.Stats(&s); err != nil {
+		t.Fatal("Stats: ", err)
+	}
+	for _, c := range []struct {
+		name  string
+		l     LatencyStats
+		count int64
+	}{
+		{"get", s.GetLatency, 3},
+		{"write", s.WriteLatency, 10},
+		{"sync write", s.SyncWriteLatency, 1},
+		{"seek", s.SeekLatency, 1},
+		{"next", s.NextLatency, 6},
+		{"flush", s.FlushLatency, 1},
+	} {
+		if c.l.Count != c.count {
+			t.Errorf("%s: got count %d, want %d", c.name, c.l.Count, c.count)
+		}
+		if c.l.Max <= 0 || c.l.P50 > c.l.P99 || c.l.P99 > c.l.Max {
+			t.Errorf("%s: bad latencies %+v", c.name, c.l)
+		}
+	}
+
+	h.db.ResetLatencyStats()
+	if err := h.db.Stats(&s); err != nil {
+		t.Fatal("Stats: ", err)
+	}
+	if s.GetLatency.Count != 0 || s.FlushLatency.Count != 0 {
+		t.Errorf("latencies not reset: %+v %+v", s.GetLatency, s.FlushLatency)
+	}
+}
+
+func TestDB_Logger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	h := newDbHarnessWopt(t, &opt.Options{
+		Logger:   logger,
+		LogLevel: opt.LogInfo,
+	})
+	h.put("foo", "v1")
+	h.compactMem()
+	h.close()
+
+	out := buf.String()
+	for _, want := range []string{
+		`level=INFO msg="db@open done" duration=`,
+		`level=INFO msg="memdb@flush committed" tables=1`,
+		`level=INFO msg="db@close done"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log output doesn't contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "level=DEBUG") {
+		t.Errorf("debug events logged with LogInfo level:\n%s", out)
+	}
+}
+
+func TestStorageLogger(t *testing.T) {
+	stor := testutil.NewStorage()
+	defer stor.Close()
+	var lines []string
+	stor.OnLog(func(string) {})
+	stor.OnLog(func(log string) { lines = append(lines, log) })
+
+	l := storageLogger{stor}
+	l.Info("table@compaction", "level", 1, "min", makeInternalKey(nil, []byte("a b"), 2, keyTypeVal), "err", errors.New("x"))
+	l.Warn("odd", "key")
+	want := []string{
+		`table@compaction level=1 min="a b,v2" err="x"`,
+		`odd key=<missing>`,
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d: %q", len(lines), len(want), lines)
+	}
+	for i := range want {
+		if !strings.HasSuffix(lines[i], want[i]) {
+			t.Errorf("line %d: got %q, want suffix %q", i, lines[i], want[i])
+		}
+	}
+}
+
+func TestDB_SlowOperationLogging(t *testing.T) {
+	buf := new(bytes.Buffer)
+	h := newDbHarnessWopt(t, &opt.Options{
+		Logger:                 slog.New(slog.NewTextHandler(buf, nil)),
+		LogLevel:               opt.LogWarn,
+		SlowOperationThreshold: time.Nanosecond,
+	})
+	h.put("foo", "v1")
+	h.compactMem()
+	h.getVal("foo", "v1")
+	h.compactRange("", "")
+	h.close()
+
+	out := buf.String()
+	v := regexp.MustCompile(`msg="db@get slow" duration=\S+ key="foo" tables=\[L(\d)@(\d+)\]`).FindStringSubmatch(out)
+	if v == nil {
+		t.Fatalf("slow get not logged:\n%s", out)
+	}
+	for _, want := range []string{
+		`msg="db@write slow"`,
+		`msg="memdb@flush slow"`,
+		`msg="table@compaction slow" duration=`,
+		fmt.Sprintf(`inputs=[L%s@%s]`, v[1], v[2]),
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log output doesn't contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestDB_Levels(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	h.put("a", "v1")
+	h.put("c", "v2")
+	h.compactMem()
+
+	levels := h.db.Levels()
+	if len(levels) == 0 {
+		t.Fatal("no levels")
+	}
+	var found bool
+	for _, l := range levels {
+		if len(l.Tables) == 0 {
+			continue
+		}
+		if found || len(l.Tables) != 1 {
+			t.Fatalf("got tables %+v, want a single table", levels)
+		}
+		found = true
+		tbl := l.Tables[0]
+		if string(tbl.Smallest) != "a" || string(tbl.Largest) != "c" {
+			t.Errorf("got key range %q..%q, want \"a\"..\"c\"", tbl.Smallest, tbl.Largest)
+		}
+		if tbl.Size == 0 || l.Size != tbl.Size || tbl.SeeksLeft < 100 {
+			t.Errorf("bad level %+v", l)
+		}
+		if time.Since(tbl.CreationTime) > time.Minute {
+			t.Errorf("bad creation time %v", tbl.CreationTime)
+		}
+		if l.Level > 0 && (l.TargetSize == 0 || l.Score <= 0) {
+			t.Errorf("bad level %+v", l)
+		}
+	}
+	if !found {
+		t.Fatal("table not found")
+	}
+
+	shape, err := h.db.GetProperty("leveldb.lsm-shape")
+	if err != nil {
+		t.Fatal("GetProperty: ", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(shape), "\n"); len(lines) != len(levels)+2 || !strings.Contains(shape, "#") {
+		t.Errorf("bad lsm-shape:\n%s", shape)
+	}
+	shape, err = h.db.GetProperty("leveldb.lsm-shape-json")
+	if err != nil {
+		t.Fatal("GetProperty: ", err)
+	}
+	var decoded []LevelInfo
+	if err := json.Unmarshal([]byte(shape), &decoded); err != nil {
+		t.Fatal("Unmarshal: ", err)
+	}
+	if len(decoded) != len(levels) {
+		t.Errorf("got %d levels, want %d", len(decoded), len(levels))
+	}
+}
+
+func TestDB_Amplification(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		AmplificationStats: true,
+	})
+	defer h.close()
+
+	h.put("foo", "v1")
+	h.put("bar", "v2")
+	h.compactMem()
+	h.getVal("foo", "v1")
+	h.getVal("bar", "v2")
+	h.get("qux", false)
+
+	s := h.db.Amplification(time.Minute)
+	if s.Window != time.Minute || s.Gets != 3 {
+		t.Errorf("got %+v", s)
+	}
+	// Each get looks up the single table, except for the missing key which
+	// is outside of its range.
+	if want := 2.0 / 3; s.ReadAmplification != want {
+		t.Errorf("got read amplification %v, want %v", s.ReadAmplification, want)
+	}
+	if s.IngestedBytes != 2*(3+2+8) || s.WriteAmplification <= 0 {
+		t.Errorf("got %+v", s)
+	}
+	var sum float64
+	for _, wa := range s.LevelWriteAmplification {
+		sum += wa
+	}
+	if sum != s.WriteAmplification {
+		t.Errorf("got level write amplification %v, total %v", s.LevelWriteAmplification, s.WriteAmplification)
+	}
+}
+
+func TestDB_CacheStatsProperty(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	h.put("foo", "v1")
+	h.compactMem()
+	h.getVal("foo", "v1")
+	h.getVal("foo", "v1")
+
+	for _, name := range []string{"leveldb.blockcachestats", "leveldb.filecachestats"} {
+		value, err := h.db.GetProperty(name)
+		if err != nil {
+			t.Fatalf("GetProperty(%q): %v", name, err)
+		}
+		var hits, misses, inserts, evictions, size, pinned int64
+		if _, err := fmt.Sscanf(value, "Hits:%d Misses:%d Inserts:%d Evictions:%d Size:%d Pinned:%d",
+			&hits, &misses, &inserts, &evictions, &size, &pinned); err != nil {
+			t.Fatalf("%s: bad value %q: %v", name, value, err)
+		}
+		if hits == 0 || misses == 0 || inserts == 0 || size == 0 {
+			t.Errorf("%s: got %q", name, value)
+		}
+	}
+}
+
+func TestDB_TableReads(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	h.put("a", "v1")
+	h.put("c", "v2")
+	h.compactMem()
+
+	tableInfo := func() LevelTableInfo {
+		for _, l := range h.db.Levels() {
+			if len(l.Tables) > 0 {
+				return l.Tables[0]
+			}
+		}
+		t.Fatal("table not found")
+		return LevelTableInfo{}
+	}
+	if ti := tableInfo(); ti.Reads != 0 || !ti.LastRead.IsZero() {
+		t.Fatalf("got reads %d at %v before any read", ti.Reads, ti.LastRead)
+	}
+
+	start := time.Now()
+	h.getVal("a", "v1")
+	h.get("b", false)
+	// Outside of the table range.
+	h.get("d", false)
+	if ti := tableInfo(); ti.Reads != 2 || ti.LastRead.Before(start) {
+		t.Errorf("got reads %d at %v after gets", ti.Reads, ti.LastRead)
+	}
+
+	iter := h.db.NewIterator(nil, nil)
+	for iter.Next() {
+	}
+	iter.Release()
+	if ti := tableInfo(); ti.Reads != 3 {
+		t.Errorf("got reads %d after iteration", ti.Reads)
+	}
+}
+
+func TestDB_CipherStats(t *testing.T) {
+	defer func(version int, key []byte) {
+		EncryptionVersion, EncryptionKey = version, key
+	}(EncryptionVersion, EncryptionKey)
+	EncryptionVersion, EncryptionKey = 2, []byte("0123456789abcdef")
+
+	h := newDbHarness(t)
+	defer h.close()
+
+	h.put("foo", "v1")
+	h.compactMem()
+	h.getVal("foo", "v1")
+
+	var s DBStats
+	if err := h.db.Stats(&s); err != nil {
+		t.Fatal("Stats: ", err)
+	}
+	if s.JournalCipher.EncryptBytes == 0 || s.ManifestCipher.EncryptBytes == 0 {
+		t.Errorf("journal and manifest not encrypted: %+v %+v", s.JournalCipher, s.ManifestCipher)
+	}
+	if cs := s.TableCipher; cs.EncryptBytes == 0 || cs.DecryptBytes == 0 || cs.EncryptDuration <= 0 || cs.DecryptDuration <= 0 {
+		t.Errorf("got table cipher stats %+v", cs)
+	}
+
+	value, err := h.db.GetProperty("leveldb.cipherstats")
+	if err != nil {
+		t.Fatal("GetProperty: ", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(value), "\n"); len(lines) != 3 || !strings.HasPrefix(lines[2], "table Encrypt(MB):") {
+		t.Errorf("bad cipherstats:\n%s", value)
+	}
+}
+
+func TestDB_AdminEvents(t *testing.T) {
+	stor := storage.NewMemStorage()
+	o := &opt.Options{AdminLog: true}
+	db, err := Open(stor, o)
+	if err != nil {
+		t.Fatal("Open: ", err)
+	}
+	if err := db.Put([]byte("foo"), []byte("v1"), nil); err != nil {
+		t.Fatal("Put: ", err)
+	}
+	if err := db.CompactRange(util.Range{Start: []byte("a"), Limit: []byte("z")}); err != nil {
+		t.Fatal("CompactRange: ", err)
+	}
+	db.SetVerifyBlockChecksum(false)
+	if err := db.SetReadOnly(); err != nil {
+		t.Fatal("SetReadOnly: ", err)
+	}
+
+	var kinds []AdminEventKind
+	for _, e := range db.AdminEvents() {
+		kinds = append(kinds, e.Kind)
+	}
+	want := []AdminEventKind{AdminOpen, AdminCompactRange, AdminSetOption, AdminSetReadOnly}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Fatalf("got events %v, want %v", kinds, want)
+	}
+	if e := db.AdminEvents()[1]; !strings.HasPrefix(e.Details, `start="a" limit="z" duration=`) || e.Error != "" {
+		t.Errorf("got compact-range event %+v", e)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal("Close: ", err)
+	}
+
+	// The events survive reopening.
+	db, err = Open(stor, o)
+	if err != nil {
+		t.Fatal("Open: ", err)
+	}
+	events, err := db.AdminLog()
+	if err != nil {
+		t.Fatal("AdminLog: ", err)
+	}
+	kinds = kinds[:0]
+	for _, e := range events {
+		kinds = append(kinds, e.Kind)
+	}
+	want = []AdminEventKind{AdminOpen, AdminCompactRange, AdminSetOption, AdminSetReadOnly, AdminClose, AdminOpen}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Fatalf("got logged events %v, want %v", kinds, want)
+	}
+	if events[0].Details != "created=true read-only=false" || events[5].Details != "created=false read-only=false" {
+		t.Errorf("got open events %+v and %+v", events[0], events[5])
+	}
+	if len(db.AdminEvents()) != 1 {
+		t.Errorf("got %d events after reopening", len(db.AdminEvents()))
+	}
+	db.Close()
+}
+
+func TestReadJournalFile(t *testing.T) {
+	dir := t.TempDir()
+	db, err := OpenFile(dir, &opt.Options{JournalCompression: opt.SnappyCompression})
+	if err != nil {
+		t.Fatal("OpenFile: ", err)
+	}
+	b := new(Batch)
+	b.Put([]byte("foo"), bytes.Repeat([]byte("v"), 100))
+	b.Delete([]byte("bar"))
+	if err := db.Write(b, nil); err != nil {
+		t.Fatal("Write: ", err)
+	}
+	if err := db.Put([]byte("baz"), []byte("v2"), nil); err != nil {
+		t.Fatal("Put: ", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal("Close: ", err)
+	}
+
+	journals, _ := filepath.Glob(filepath.Join(dir, "*.log"))
+	if len(journals) != 1 {
+		t.Fatalf("got journals %v", journals)
+	}
+	var batches []*JournalBatch
+	if err := ReadJournalFile(journals[0], func(b *JournalBatch) error {
+		batches = append(batches, b)
+		return nil
+	}); err != nil {
+		t.Fatal("ReadJournalFile: ", err)
+	}
+	if len(batches) != 2 {
+		t.Fatalf("got %d batches", len(batches))
+	}
+	if b := batches[0]; b.Err != nil || b.Seq != 1 || !b.Compressed || !reflect.DeepEqual(b.Records, []JournalRecord{
+		{Seq: 1, Key: []byte("foo"), ValueLen: 100},
+		{Seq: 2, Deleted: true, Key: []byte("bar")},
+	}) {
+		t.Errorf("got first batch %+v", b)
+	}
+	if b := batches[1]; b.Err != nil || b.Seq != 3 || b.Compressed || len(b.Records) != 1 || b.Records[0].ValueLen != 2 {
+		t.Errorf("got second batch %+v", b)
+	}
+
+	// Corrupt the first batch.
+	data, err := os.ReadFile(journals[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[10] ^= 0xff
+	if err := os.WriteFile(journals[0], data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	batches = batches[:0]
+	if err := ReadJournalFile(journals[0], func(b *JournalBatch) error {
+		batches = append(batches, b)
+		return nil
+	}); err != nil {
+		t.Fatal("ReadJournalFile: ", err)
+	}
+	// The rest of the journal block is dropped along.
+	if len(batches) != 1 || batches[0].Err == nil || !strings.Contains(batches[0].Err.Error(), "checksum mismatch") {
+		t.Errorf("got batches %+v after corruption", batches)
+	}
+}
+
+func TestReadManifestFile(t *testing.T) {
+	dir := t.TempDir()
+	db, err := OpenFile(dir, nil)
+	if err != nil {
+		t.Fatal("OpenFile: ", err)
+	}
+	if err := db.Put([]byte("foo"), []byte("v1"), nil); err != nil {
+		t.Fatal("Put: ", err)
+	}
+	if err := db.CompactRange(util.Range{}); err != nil {
+		t.Fatal("CompactRange: ", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal("Close: ", err)
+	}
+
+	manifests, _ := filepath.Glob(filepath.Join(dir, "MANIFEST-*"))
+	if len(manifests) != 1 {
+		t.Fatalf("got manifests %v", manifests)
+	}
+	var edits []*VersionEdit
+	if err := ReadManifestFile(manifests[0], func(e *VersionEdit) error {
+		edits = append(edits, e)
+		return nil
+	}); err != nil {
+		t.Fatal("ReadManifestFile: ", err)
+	}
+	if len(edits) == 0 || edits[0].Comparer != "leveldb.BytewiseComparator" || edits[0].NextFileNum == nil {
+		t.Fatalf("got first edit %+v", edits)
+	}
+	var added, deleted []string
+	for _, e := range edits {
+		if e.Err != nil {
+			t.Fatal("corrupted edit: ", e.Err)
+		}
+		for _, at := range e.AddedTables {
+			ukey, _, _, err := ParseTableKey(at.Smallest)
+			if err != nil {
+				t.Fatal("ParseTableKey: ", err)
+			}
+			added = append(added, fmt.Sprintf("L%d@%d %s", at.Level, at.Num, ukey))
+		}
+		for _, dt := range e.DeletedTables {
+			deleted = append(deleted, fmt.Sprintf("L%d@%d", dt.Level, dt.Num))
+		}
+	}
+	// The flushed table is compacted into level-1.
+	if !reflect.DeepEqual(added, []string{"L0@3 foo", "L1@4 foo"}) || !reflect.DeepEqual(deleted, []string{"L0@3"}) {
+		t.Errorf("got added tables %v, deleted tables %v", added, deleted)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	db, err := OpenFile(dir, nil)
+	if err != nil {
+		t.Fatal("OpenFile: ", err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key%03d", i)), bytes.Repeat([]byte{'v'}, 100), nil); err != nil {
+			t.Fatal("Put: ", err)
+		}
+	}
+	if err := db.CompactRange(util.Range{}); err != nil {
+		t.Fatal("CompactRange: ", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal("Close: ", err)
+	}
+	// The tables replaced by the compaction may outlive Close, the next
+	// open removes them.
+	if db, err = OpenFile(dir, nil); err != nil {
+		t.Fatal("OpenFile: ", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal("Close: ", err)
+	}
+
+	r, err := CheckFile(dir, nil)
+	if err != nil {
+		t.Fatal("CheckFile: ", err)
+	}
+	if !r.OK() || len(r.Problems) != 0 || r.Tables != 1 || r.Journals != 1 {
+		t.Fatalf("got report on healthy DB:\n%s", r)
+	}
+
+	tables, _ := filepath.Glob(filepath.Join(dir, "*.ldb"))
+	if len(tables) != 1 {
+		t.Fatalf("got tables %v", tables)
+	}
+	data, err := os.ReadFile(tables[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Leftover table, and corrupted data block.
+	if err := os.WriteFile(filepath.Join(dir, "000100.ldb"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	data[10] ^= 0xff
+	if err := os.WriteFile(tables[0], data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err = CheckFile(dir, nil)
+	if err != nil {
+		t.Fatal("CheckFile: ", err)
+	}
+	if r.OK() || len(r.Problems) != 3 {
+		t.Fatalf("got report on corrupted DB:\n%s", r)
+	}
+	for i, want := range []string{"file number not below", "not referenced by the MANIFEST", "corrupted blocks"} {
+		if !strings.Contains(r.Problems[i].Problem, want) {
+			t.Errorf("problem %d: got %q, want %q", i, r.Problems[i].Problem, want)
+		}
+	}
+
+	// Nothing was modified.
+	if got, err := os.ReadFile(tables[0]); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("table modified by check: %v", err)
+	}
+
+	if err := os.Remove(tables[0]); err != nil {
+		t.Fatal(err)
+	}
+	r, err = CheckFile(dir, nil)
+	if err != nil {
+		t.Fatal("CheckFile: ", err)
+	}
+	if r.OK() || !strings.Contains(r.Problems[0].Problem, "missing") {
+		t.Errorf("got report on DB with missing table:\n%s", r)
+	}
+}
+
+func TestDB_ExportImport(t *testing.T) {
+	for _, format := range []ExportFormat{ExportJSONL, ExportCSV} {
+		t.Run(format.String(), func(t *testing.T) {
+			src := newDbHarness(t)
+			defer src.close()
+			src.put("a", "1")
+			src.put("b\x00\xff", "binary\n\"value\"")
+			src.put("c", "")
+			src.put("d", "4")
+
+			var buf bytes.Buffer
+			n, err := src.db.Export(&buf, format, &util.Range{Limit: []byte("d")})
+			if err != nil || n != 3 {
+				t.Fatalf("Export: got %d entries, %v", n, err)
+			}
+
+			dst := newDbHarness(t)
+			defer dst.close()
+			if n, err := dst.db.Import(bytes.NewReader(buf.Bytes()), format); err != nil || n != 3 {
+				t.Fatalf("Import: got %d entries, %v", n, err)
+			}
+			dst.getVal("a", "1")
+			dst.getVal("b\x00\xff", "binary\n\"value\"")
+			dst.getVal("c", "")
+			dst.get("d", false)
+		})
+	}
+
+	h := newDbHarness(t)
+	defer h.close()
+	if _, err := h.db.Import(strings.NewReader("a,b\n"), ExportCSV); err == nil || !strings.Contains(err.Error(), "entry 1") {
+		t.Errorf("Import of invalid base64: got %v", err)
+	}
+	if _, err := h.db.Import(strings.NewReader(`{"value":"YQ=="}`), ExportJSONL); err == nil {
+		t.Error("Import of entry without key succeeded")
+	}
+}
+
+func TestDB_RocksDBExportIngest(t *testing.T) {
+	src := newDbHarness(t)
+	defer src.close()
+	src.put("a", "1")
+	src.put("b\x00\xff", "binary")
+	src.put("c", "")
+	src.put("d", "4")
+
+	var buf bytes.Buffer
+	n, err := src.db.ExportRocksDB(&buf, &util.Range{Limit: []byte("d")})
+	if err != nil || n != 3 {
+		t.Fatalf("ExportRocksDB: got %d entries, %v", n, err)
+	}
+
+	dst := newDbHarness(t)
+	defer dst.close()
+	dst.put("a", "old")
+	if n, err := dst.db.IngestRocksDB(bytes.NewReader(buf.Bytes()), int64(buf.Len())); err != nil || n != 3 {
+		t.Fatalf("IngestRocksDB: got %d entries, %v", n, err)
+	}
+	dst.getVal("a", "1")
+	dst.getVal("b\x00\xff", "binary")
+	dst.getVal("c", "")
+	dst.get("d", false)
+
+	if _, err := dst.db.IngestRocksDB(strings.NewReader("not a table"), 11); err == nil {
+		t.Error("IngestRocksDB of garbage succeeded")
+	}
+}
+
+func TestDB_TraceReplay(t *testing.T) {
+	src := newDbHarness(t)
+	defer src.close()
+	var trace bytes.Buffer
+	if err := src.db.StartTrace(&trace, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.db.StartTrace(new(bytes.Buffer), nil); err == nil {
+		t.Fatal("StartTrace while tracing succeeded")
+	}
+	src.put("a", "1")
+	src.put("b", "2")
+	src.delete("a")
+	b := new(Batch)
+	b.Put([]byte("c"), []byte("3"))
+	b.Put([]byte("d"), nil)
+	if err := src.db.Write(b, &opt.WriteOptions{Sync: true}); err != nil {
+		t.Fatal(err)
+	}
+	src.get("a", false)
+	src.getVal("b", "2")
+	iter := src.db.NewIterator(&util.Range{Start: []byte("b")}, nil)
+	for iter.Seek([]byte("c")); iter.Valid(); iter.Next() {
+	}
+	iter.Release()
+	if err := src.db.CompactRange(util.Range{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.db.EndTrace(); err != nil {
+		t.Fatal(err)
+	}
+	src.put("e", "5")
+
+	dst := newDbHarness(t)
+	defer dst.close()
+	stats, err := dst.db.Replay(bytes.NewReader(trace.Bytes()), &ReplayOptions{Speed: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ReplayStats{Gets: 2, Writes: 4, IterOps: 5, Compactions: 1, NotFound: 1}
+	if stats.Gets != want.Gets || stats.Writes != want.Writes || stats.IterOps != want.IterOps ||
+		stats.Compactions != want.Compactions || stats.NotFound != want.NotFound {
+		t.Fatalf("got stats %+v, want %+v", stats, want)
+	}
+	dst.get("a", false)
+	dst.getVal("b", "2")
+	dst.getVal("c", "3")
+	dst.getVal("d", "")
+	dst.get("e", false)
+
+	// With hashed keys and omitted values, the replay writes as many keys,
+	// with values of the same length.
+	trace.Reset()
+	if err := src.db.StartTrace(&trace, &TraceOptions{HashKeys: true, OmitValues: true}); err != nil {
+		t.Fatal(err)
+	}
+	src.put("f", "value")
+	src.get("f", true)
+	if err := src.db.EndTrace(); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(trace.Bytes(), []byte("value")) {
+		t.Fatal("trace holds the value")
+	}
+	h := newDbHarness(t)
+	defer h.close()
+	if stats, err := h.db.Replay(&trace, nil); err != nil || stats.Gets != 1 || stats.NotFound != 0 {
+		t.Fatalf("got %+v, %v", stats, err)
+	}
+	iter = h.db.NewIterator(nil, nil)
+	if !iter.First() || len(iter.Key()) != 8 || len(iter.Value()) != len("value") || iter.Next() {
+		t.Fatalf("got %q=%q", iter.Key(), iter.Value())
+	}
+	iter.Release()
+
+	if _, err := h.db.Replay(strings.NewReader("garbage"), nil); err == nil {
+		t.Fatal("Replay of garbage succeeded")
+	}
+}
+
+func TestDB_WALIterator(t *testing.T) {
+	h := newDbHarness(t)
+	if _, err := h.db.NewWALIterator(0); err != ErrWALDisabled {
+		t.Fatalf("without retention: got %v", err)
+	}
+	h.close()
+
+	h = newDbHarnessWopt(t, &opt.Options{WALRetentionSize: 100})
+	defer h.close()
+	it, err := h.db.NewWALIterator(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if it.Next() {
+		t.Fatal("Next before any write succeeded")
+	}
+	select {
+	case <-it.Wait():
+		t.Fatal("Wait before any write returned")
+	default:
+	}
+	waitC := it.Wait()
+	h.put("a", "1")
+	b := new(Batch)
+	b.Put([]byte("b"), []byte("2"))
+	b.Delete([]byte("a"))
+	if err := h.db.Write(b, nil); err != nil {
+		t.Fatal(err)
+	}
+	<-waitC
+
+	var got []string
+	for it.Next() {
+		got = append(got, fmt.Sprintf("%d:%d", it.Seq(), it.Batch().Len()))
+	}
+	if it.Error() != nil || strings.Join(got, " ") != "1:1 2:2" || it.LastSeq() != 3 {
+		t.Fatalf("got %v, last seq %d, %v", got, it.LastSeq(), it.Error())
+	}
+	if _, err := h.db.NewWALIterator(4); err != ErrWALTruncated {
+		t.Fatalf("iterator ahead of the DB: got %v", err)
+	}
+
+	// Overflow the retention.
+	h.put("c", strings.Repeat("x", 200))
+	if it.Next() || it.Error() != ErrWALTruncated {
+		t.Fatalf("truncated history: got %v", it.Error())
+	}
+	if _, err := h.db.NewWALIterator(3); err != ErrWALTruncated {
+		t.Fatalf("truncated history: got %v", err)
+	}
+	it, err = h.db.NewWALIterator(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.put("d", "4")
+	if !it.Next() || it.Seq() != 5 {
+		t.Fatalf("got seq %d, %v", it.Seq(), it.Error())
+	}
+	r := it.Batch().Dump()
+	if !bytes.Contains(r, []byte("d")) {
+		t.Fatalf("got batch %q", r)
+	}
+
+	// Transactions drop the history.
+	tr, err := h.db.OpenTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.Put([]byte("e"), []byte("5"), nil)
+	if err := tr.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if it.Next() || it.Error() != ErrWALTruncated {
+		t.Fatalf("after transaction: got %v", it.Error())
+	}
+
+	it, err = h.db.NewWALIterator(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.closeDB()
+	<-it.Wait()
+	if it.Next() || it.Error() != ErrClosed {
+		t.Fatalf("after close: got %v", it.Error())
+	}
+}
+
+func TestDB_JournalArchive(t *testing.T) {
+	ar := storage.NewMemStorage()
+	h := newDbHarnessWopt(t, &opt.Options{JournalArchive: ar})
+	h.put("a", "1")
+	h.compactMem()
+	snap, err := h.db.GetSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := snap.Seq()
+	snap.Release()
+	h.put("b", "2")
+	h.delete("a")
+	h.compactMem()
+	h.put("c", "3")
+	to := from + 3
+	h.put("d", "4")
+	// The current journal is archived once recovered.
+	h.reopenDB()
+	h.close()
+
+	journals, err := ListJournalArchive(ar)
+	if err != nil || len(journals) != 3 {
+		t.Fatalf("got archived journals %v, %v", journals, err)
+	}
+	var seqs []uint64
+	if err := ReadJournalArchive(ar, func(seq uint64, b *Batch) error {
+		seqs = append(seqs, seq)
+		return nil
+	}); err != nil || fmt.Sprint(seqs) != "[1 2 3 4 5]" {
+		t.Fatalf("got batches at %v, %v", seqs, err)
+	}
+
+	// Point-in-time recovery from a backup.
+	db, err := Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.Put([]byte("a"), []byte("1"), nil)
+	last, err := db.ReplayJournalArchive(ar, from, to)
+	if err != nil || last != to {
+		t.Fatalf("replay: got %d, %v", last, err)
+	}
+	for key, want := range map[string]string{"a": "", "b": "2", "c": "3", "d": ""} {
+		v, err := db.Get([]byte(key), nil)
+		if string(v) != want || (want == "") != (err == ErrNotFound) {
+			t.Errorf("%s: got %q, %v, want %q", key, v, err, want)
+		}
+	}
+}
+
+func TestDB_JournalArchiveRetention(t *testing.T) {
+	ar := storage.NewMemStorage()
+	h := newDbHarnessWopt(t, &opt.Options{JournalArchive: ar, JournalArchiveMaxFiles: 2})
+	for i := 0; i < 4; i++ {
+		h.put("k", fmt.Sprint(i))
+		h.compactMem()
+	}
+	// Archived in the background, up to the close.
+	h.close()
+	journals, err := ListJournalArchive(ar)
+	if err != nil || len(journals) != 2 || journals[0].Num >= journals[1].Num {
+		t.Fatalf("got archived journals %v, %v", journals, err)
+	}
+	var seqs []uint64
+	ReadJournalArchive(ar, func(seq uint64, b *Batch) error {
+		seqs = append(seqs, seq)
+		return nil
+	})
+	if fmt.Sprint(seqs) != "[3 4]" {
+		t.Fatalf("got batches at %v", seqs)
+	}
+}
+
+// A storage failing to create files while unavailable.
+type unavailableStorage struct {
+	storage.Storage
+	unavailable int32
+}
+
+func (s *unavailableStorage) Create(fd storage.FileDesc) (storage.Writer, error) {
+	if atomic.LoadInt32(&s.unavailable) == 1 {
+		return nil, errors.New("storage unavailable")
+	}
+	return s.Storage.Create(fd)
+}
+
+func TestDB_JournalArchiveUnavailable(t *testing.T) {
+	ar := &unavailableStorage{Storage: storage.NewMemStorage(), unavailable: 1}
+	h := newDbHarnessWopt(t, &opt.Options{JournalArchive: ar})
+	h.put("a", "1")
+	h.compactMem()
+	h.put("b", "2")
+	h.compactMem()
+	// Kept in the DB across a reopen.
+	h.reopenDB()
+	h.put("c", "3")
+	h.compactMem()
+	h.getVal("a", "1")
+	if fds, err := h.stor.List(storage.TypeJournal); err != nil || len(fds) < 4 {
+		t.Errorf("got journals %v, %v", fds, err)
+	}
+
+	atomic.StoreInt32(&ar.unavailable, 0)
+	h.reopenDB()
+	h.close()
+	var seqs []uint64
+	if err := ReadJournalArchive(ar, func(seq uint64, b *Batch) error {
+		seqs = append(seqs, seq)
+		return nil
+	}); err != nil || fmt.Sprint(seqs) != "[1 2 3]" {
+		t.Fatalf("got batches at %v, %v", seqs, err)
+	}
+}
+
+func TestDB_Backup(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{CompactionL0Trigger: 10})
+	defer h.close()
+	dst := storage.NewMemStorage()
+	restore := func(want map[string]string) {
+		t.Helper()
+		db, err := Open(dst, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		got := make(map[string]string)
+		iter := db.NewIterator(nil, nil)
+		for iter.Next() {
+			got[string(iter.Key())] = string(iter.Value())
+		}
+		iter.Release()
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("restored %v, want %v", got, want)
+		}
+	}
+
+	want := make(map[string]string)
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 10; j++ {
+			key := fmt.Sprintf("k%d%d", i, j)
+			h.put(key, key)
+			want[key] = key
+		}
+		h.compactMem()
+	}
+	h.put("unflushed", "1")
+	want["unflushed"] = "1"
+	info, err := h.db.Backup(dst, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Files != 4 || info.CopiedFiles != 4 || info.Seq != 31 {
+		t.Fatalf("first backup: got %+v", info)
+	}
+	h.put("after", "1")
+	restore(want)
+
+	// Only the new files are copied: the tables of the journal of the
+	// first backup and of the put, and the journal of the delete.
+	h.put("k00", "2")
+	want["after"], want["k00"] = "1", "2"
+	h.compactMem()
+	h.delete("k01")
+	delete(want, "k01")
+	info, err = h.db.Backup(dst, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.CopiedFiles != 3 || info.Files != 6 || info.CopiedSize >= info.Size {
+		t.Fatalf("second backup: got %+v", info)
+	}
+	restore(want)
+}
+
+func TestDB_BackupEncryption(t *testing.T) {
+	defer func(version int, key []byte) {
+		EncryptionVersion, EncryptionKey = version, key
+	}(EncryptionVersion, EncryptionKey)
+	dbKey, backupKey := []byte("0123456789abcdef"), []byte("fedcba9876543210")
+	EncryptionVersion, EncryptionKey = 2, dbKey
+
+	h := newDbHarness(t)
+	defer h.close()
+	h.put("foo", "v1")
+	h.compactMem()
+	h.put("bar", "v2")
+	dst := storage.NewMemStorage()
+	bo := &BackupOptions{Encryption: &Encryption{Version: 2, Key: backupKey}}
+	if _, err := h.db.Backup(dst, bo); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(stor storage.Storage) {
+		t.Helper()
+		db, err := Open(stor, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		for key, want := range map[string]string{"foo": "v1", "bar": "v2"} {
+			if v, err := db.Get([]byte(key), nil); string(v) != want {
+				t.Errorf("%s: got %q, %v, want %q", key, v, err, want)
+			}
+		}
+	}
+	if db, err := Open(dst, nil); err == nil {
+		db.Close()
+		t.Fatal("backup opened with the key of the DB")
+	}
+	restored := storage.NewMemStorage()
+	if err := RestoreBackup(dst, restored, bo); err != nil {
+		t.Fatal(err)
+	}
+	check(restored)
+	EncryptionKey = backupKey
+	check(dst)
+	EncryptionKey = dbKey
+
+	// The tables are kept by a backup with the same encryption only.
+	info, err := h.db.Backup(dst, bo)
+	if err != nil || info.CopiedFiles == info.Files {
+		t.Fatalf("same key: got %+v, %v", info, err)
+	}
+	bo2 := &BackupOptions{Encryption: &Encryption{Version: 2, Key: []byte("0123456789ABCDEF")}}
+	if info, err = h.db.Backup(dst, bo2); err != nil || info.CopiedFiles != info.Files {
+		t.Fatalf("new key: got %+v, %v", info, err)
+	}
+	restored = storage.NewMemStorage()
+	if err := RestoreBackup(dst, restored, bo2); err != nil {
+		t.Fatal(err)
+	}
+	check(restored)
+
+	// Encrypted export.
+	var buf bytes.Buffer
+	if _, err := h.db.Export(NewEncryptWriter(&buf, *bo.Encryption), ExportJSONL, nil); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("foo")) {
+		t.Fatal("export not encrypted")
+	}
+	db, err := Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if n, err := db.Import(NewDecryptReader(&buf, *bo.Encryption), ExportJSONL); n != 2 || err != nil {
+		t.Fatalf("import: got %d, %v", n, err)
+	}
+}
+
+func TestDB_ExportArchive(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+	h.put("foo", "v1")
+	h.compactMem()
+	h.put("bar", "v2")
+	h.delete("foo")
+	h.put("baz", "v3")
+
+	var buf bytes.Buffer
+	info, err := h.db.ExportArchive(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Files == 0 || info.Size == 0 {
+		t.Fatalf("got %+v", info)
+	}
+	h.put("later", "v4")
+
+	stor := storage.NewMemStorage()
+	if err := ImportArchive(bytes.NewReader(buf.Bytes()), stor); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(stor, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"bar": "v2", "baz": "v3"} {
+		if v, err := db.Get([]byte(key), nil); string(v) != want {
+			t.Errorf("%s: got %q, %v, want %q", key, v, err, want)
+		}
+	}
+	for _, key := range []string{"foo", "later"} {
+		if _, err := db.Get([]byte(key), nil); err != ErrNotFound {
+			t.Errorf("%s: got %v", key, err)
+		}
+	}
+	db.Close()
+
+	if err := ImportArchive(bytes.NewReader(buf.Bytes()), stor); err == nil {
+		t.Fatal("imported into an existing DB")
+	}
+}
+
+func TestDB_Diff(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+	for i := 0; i < 100; i++ {
+		h.put(fmt.Sprintf("k%03d", i), "v")
+		if i == 49 {
+			h.compactMem()
+		}
+	}
+	h.compactMem()
+	backup := storage.NewMemStorage()
+	if _, err := h.db.Backup(backup, nil); err != nil {
+		t.Fatal(err)
+	}
+	stor := storage.NewMemStorage()
+	if err := RestoreBackup(backup, stor, nil); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(stor, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	diff := func(r *util.Range) (string, int) {
+		t.Helper()
+		it := Diff(h.db, db, r)
+		defer it.Release()
+		var res []string
+		for it.Next() {
+			res = append(res, fmt.Sprintf("%s:%s:%q:%q", it.Kind(), it.Key(), it.ValueA(), it.ValueB()))
+		}
+		if err := it.Error(); err != nil {
+			t.Fatal(err)
+		}
+		return strings.Join(res, " "), it.PrunedTables()
+	}
+	if res, pruned := diff(nil); res != "" || pruned != 2 {
+		t.Fatalf("copy: got %q, %d pruned", res, pruned)
+	}
+
+	h.put("aaa", "")
+	db.Put([]byte("k010"), []byte("w"), nil)
+	db.Delete([]byte("k020"), nil)
+	db.Put([]byte("zzz"), []byte("v"), nil)
+	want := `only-in-a:aaa:"":"" value-mismatch:k010:"v":"w" only-in-a:k020:"v":"" only-in-b:zzz:"":"v"`
+	if res, pruned := diff(nil); res != want || pruned != 1 {
+		t.Fatalf("got %q, %d pruned, want %q", res, pruned, want)
+	}
+	want = `value-mismatch:k010:"v":"w"`
+	if res, _ := diff(&util.Range{Start: []byte("k005"), Limit: []byte("k015")}); res != want {
+		t.Fatalf("range: got %q, want %q", res, want)
+	}
+}
+
+func TestDB_OpenWith(t *testing.T) {
+	for name, preset := range map[string]*opt.Options{
+		"ssd": opt.ForSSD(), "hdd": opt.ForHDD(), "low-memory": opt.LowMemory(), "bulk-load": opt.BulkLoad(),
+	} {
+		stor := storage.NewMemStorage()
+		db, err := OpenWith(stor, opt.WithPreset(preset), opt.WithWriteBuffer(64*opt.KiB), opt.WithNoSync())
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if o := db.Options(); o.GetWriteBuffer() != 64*opt.KiB || !o.GetNoSync() || o.GetBlockCacheCapacity() != preset.GetBlockCacheCapacity() {
+			t.Errorf("%s: options not applied", name)
+		}
+		for i := 0; i < 1000; i++ {
+			if err := db.Put([]byte(fmt.Sprintf("%s%04d", name, i)), bytes.Repeat([]byte{'v'}, 100), nil); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+		db.Close()
+
+		db, err = OpenWith(stor, opt.WithPreset(preset), opt.WithErrorIfMissing())
+		if err != nil {
+			t.Fatalf("%s: reopen: %v", name, err)
+		}
+		if v, err := db.Get([]byte(name+"0999"), nil); err != nil || len(v) != 100 {
+			t.Errorf("%s: got %q, %v", name, v, err)
+		}
+		db.Close()
+	}
+
+	if _, err := OpenWith(storage.NewMemStorage(), opt.WithErrorIfMissing()); err == nil {
+		t.Fatal("missing DB opened")
+	}
+}
+
+func TestDB_InvalidOptions(t *testing.T) {
+	for _, test := range []struct {
+		o      *opt.Options
+		fields string
+	}{
+		{&opt.Options{WriteBuffer: -1}, "WriteBuffer"},
+		{&opt.Options{Compression: 42}, "Compression"},
+		{&opt.Options{BlockSize: 64 * opt.KiB, WriteBuffer: 32 * opt.KiB}, "BlockSize WriteBuffer"},
+		{&opt.Options{WriteL0SlowdownTrigger: 20}, "WriteL0SlowdownTrigger WriteL0PauseTrigger"},
+		{&opt.Options{ErrorIfExist: true, ReadOnly: true}, "ErrorIfExist ReadOnly"},
+		{&opt.Options{CompressedBlockCacheCapacity: opt.MiB, BlockCacher: opt.NoCacher}, "CompressedBlockCacheCapacity BlockCacher"},
+		{&opt.Options{JournalArchiveMaxFiles: 10}, "JournalArchiveMaxFiles JournalArchive"},
+	} {
+		_, err := Open(storage.NewMemStorage(), test.o)
+		ie, ok := err.(*opt.InvalidError)
+		if !ok || strings.Join(ie.Fields, " ") != test.fields {
+			t.Errorf("%s: got %v", test.fields, err)
+		}
+	}
+
+	defer func(version int, key []byte) {
+		EncryptionVersion, EncryptionKey = version, key
+	}(EncryptionVersion, EncryptionKey)
+	for _, e := range []Encryption{{1, nil}, {2, []byte{}}, {3, []byte("key")}} {
+		EncryptionVersion, EncryptionKey = e.Version, e.Key
+		if db, err := Open(storage.NewMemStorage(), nil); !errors.Is(err, ErrEncryption) {
+			if err == nil {
+				db.Close()
+			}
+			t.Errorf("encryption %d %q: got %v", e.Version, e.Key, err)
+		}
+	}
+	EncryptionVersion, EncryptionKey = 0, nil
+
+	h := newDbHarness(t)
+	defer h.close()
+	if _, err := h.db.Backup(storage.NewMemStorage(), &BackupOptions{Encryption: &Encryption{Version: 1}}); !errors.Is(err, ErrEncryption) {
+		t.Error("backup with encryption without key")
+	}
+}
+
+func TestDB_WriteLarge(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		WriteBuffer:    64 * opt.KiB,
+		WriteChunkSize: 4 * opt.KiB,
+	})
+	defer h.close()
+
+	batch := new(Batch)
+	for i := 0; i < 1000; i++ {
+		batch.Put(tkey(i), tval(i, 100))
+	}
+	batch.Delete(tkey(0))
+	seq := h.db.getSeq()
+	n, err := h.db.WriteLarge(batch, nil)
+	if err != nil || n != batch.Len() {
+		t.Fatalf("got %d %v, want %d", n, err, batch.Len())
+	}
+	if h.db.getSeq() != seq+uint64(batch.Len()) {
+		t.Errorf("got seq %d, want %d", h.db.getSeq(), seq+uint64(batch.Len()))
+	}
+	h.get(string(tkey(0)), false)
+	for i := 1; i < 1000; i++ {
+		h.getVal(string(tkey(i)), string(tval(i, 100)))
+	}
+
+	h.closeDB()
+	if n, err := h.db.WriteLarge(batch, nil); n != 0 || err != ErrClosed {
+		t.Errorf("got %d %v after close", n, err)
+	}
+}
+
+func TestDB_MaxKeyValueSize(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{MaxKeySize: 8, MaxValueSize: 16})
+	defer h.close()
+
+	check := func(err error, what string, size int) {
+		t.Helper()
+		var terr *errors.ErrTooLarge
+		if !errors.As(err, &terr) || terr.What != what || terr.Size != size {
+			t.Errorf("got %v, want %s of %d bytes too large", err, what, size)
+		}
+	}
+	check(h.db.Put([]byte("too long key"), []byte("v"), nil), "key", 12)
+	check(h.db.Put([]byte("k"), make([]byte, 17), nil), "value", 17)
+	check(h.db.Delete([]byte("too long key"), nil), "key", 12)
+	if err := h.db.Put([]byte("key"), make([]byte, 16), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// None of a batch is written.
+	batch := new(Batch)
+	batch.Put([]byte("a"), []byte("v"))
+	batch.Put([]byte("b"), make([]byte, 100))
+	check(h.db.Write(batch, nil), "value", 100)
+	_, err := h.db.WriteLarge(batch, nil)
+	check(err, "value", 100)
+	h.get("a", false)
+
+	tr, err := h.db.OpenTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(tr.Write(batch, nil), "value", 100)
+	check(tr.Put([]byte("too long key"), nil, nil), "key", 12)
+	tr.Discard()
+	h.get("a", false)
+}
+
+func TestDB_OpenLockTimeout(t *testing.T) {
+	stor := storage.NewMemStorage()
+	defer stor.Close()
+	db, err := Open(stor, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(stor, nil); !errors.Is(err, storage.ErrLocked) {
+		t.Fatalf("got %v, want locked", err)
+	}
+	if _, err := Open(stor, &opt.Options{LockTimeout: 30 * time.Millisecond}); !errors.Is(err, storage.ErrLocked) {
+		t.Fatalf("after timeout: got %v, want locked", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if _, err := OpenContext(ctx, stor, &opt.Options{LockTimeout: time.Minute}); err != context.DeadlineExceeded {
+		t.Fatalf("after deadline: got %v", err)
+	}
+
+	time.AfterFunc(50*time.Millisecond, func() { db.Close() })
+	db, err = Open(stor, &opt.Options{LockTimeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// The LOCK file.
+	path := t.TempDir()
+	if db, err = OpenFile(path, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenFile(path, nil); !errors.Is(err, storage.ErrLocked) {
+		t.Fatalf("file: got %v, want locked", err)
+	}
+	time.AfterFunc(50*time.Millisecond, func() { db.Close() })
+	db, err = OpenFileContext(context.Background(), path, &opt.Options{LockTimeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+}
+
+func TestDB_CloseWithContext(t *testing.T) {
+	h := newDbHarnessWopt(t, &opt.Options{
+		CloseFlushMemtable:  true,
+		CloseSyncJournal:    true,
+		CloseWaitCompaction: true,
+	})
+	defer h.close()
+
+	h.put("foo", "v1")
+	h.stor.ResetCounter(testutil.ModeSync, storage.TypeJournal)
+	if err := h.db.CloseWithContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := h.stor.Counter(testutil.ModeSync, storage.TypeJournal); n == 0 {
+		t.Error("journal not synced")
+	}
+	if fds, _ := h.stor.List(storage.TypeTable); len(fds) != 1 {
+		t.Errorf("got %d tables, want the memtable flushed", len(fds))
+	}
+	if err := h.db.CloseWithContext(context.Background()); err != ErrClosed {
+		t.Errorf("second close: got %v", err)
+	}
+
+	// The open transaction holds the write lock.
+	h.openDB()
+	h.getVal("foo", "v1")
+	tr, err := h.db.OpenTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if err := h.db.CloseWithContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want deadline exceeded", err)
+	}
+	if !h.db.isClosed() {
+		t.Error("not closed")
+	}
+	if err := tr.Put([]byte("bar"), []byte("v"), nil); err == nil {
+		t.Error("transaction not discarded")
+	}
+}
+
+func TestDB_HealthCheck(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	h.put("foo", "v1")
+	probes := func(r *HealthReport) string {
+		var names []string
+		for _, p := range r.Probes {
+			names = append(names, p.Name)
+		}
+		return strings.Join(names, " ")
+	}
+	seq := h.db.getSeq()
+	r := h.db.HealthCheck(context.Background(), nil)
+	if r.Status != HealthOK || !r.OK() || probes(r) != "background read iterate" {
+		t.Errorf("got %v", r)
+	}
+	if h.db.getSeq() != seq {
+		t.Error("written without a probe key")
+	}
+	o := &HealthCheckOptions{WriteProbeKey: []byte("health")}
+	r = h.db.HealthCheck(context.Background(), o)
+	if r.Status != HealthOK || probes(r) != "background write read iterate" {
+		t.Errorf("write probe: got %v", r)
+	}
+	if h.db.getSeq() != seq+1 {
+		t.Error("probe key not written")
+	}
+
+	if err := h.db.SetReadOnly(); err != nil {
+		t.Fatal(err)
+	}
+	if r := h.db.HealthCheck(context.Background(), o); r.Status != HealthDegraded {
+		t.Errorf("read-only: got %v", r)
+	}
+
+	h.closeDB()
+	if r := h.db.HealthCheck(context.Background(), o); r.Status != HealthFailed || r.OK() {
+		t.Errorf("closed: got %v", r)
+	}
+
+	db, err := OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	r = db.HealthCheck(context.Background(), nil)
+	if last := r.Probes[len(r.Probes)-1]; last.Name != "disk" || r.DiskTotal == 0 || r.DiskAvail > r.DiskTotal {
+		t.Errorf("disk: got %v, %d of %d bytes", r, r.DiskAvail, r.DiskTotal)
+	}
+}
+
+func TestDB_Namespace(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	h.put("a", "v")
+	h.put("t1/z", "outside")
+	a, b := h.db.Namespace([]byte("t0/")), h.db.Namespace([]byte("t1/"))
+	if err := a.Put([]byte("k1"), []byte("a1"), nil); err != nil {
+		t.Fatal(err)
+	}
+	batch := new(Batch)
+	batch.Put([]byte("k2"), []byte("a2"))
+	batch.Put([]byte("k3"), []byte("a3"))
+	batch.Delete([]byte("k1"))
+	dump := append([]byte(nil), batch.Dump()...)
+	if err := a.Write(batch, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(batch.Dump(), dump) {
+		t.Error("batch modified")
+	}
+	if err := b.Put([]byte("k1"), []byte("b1"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete([]byte("z"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	h.getVal("t0/k2", "a2")
+	h.get("t0/k1", false)
+	h.get("t1/z", false)
+	if v, err := b.Get([]byte("k1"), nil); err != nil || string(v) != "b1" {
+		t.Errorf("got %q, %v", v, err)
+	}
+	if _, err := a.Get([]byte("k1"), nil); err != ErrNotFound {
+		t.Errorf("got %v, want not found", err)
+	}
+	if ok, err := a.Has([]byte("k2"), nil); err != nil || !ok {
+		t.Errorf("has: got %v, %v", ok, err)
+	}
+	if ok, err := b.Has([]byte("z"), nil); err != nil || ok {
+		t.Errorf("has deleted: got %v, %v", ok, err)
+	}
+	snap, err := a.(interface {
+		GetSnapshot() (SnapshotReader, error)
+	}).GetSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.put("t0/k2", "new")
+	if v, err := snap.Get([]byte("k2"), nil); err != nil || string(v) != "a2" {
+		t.Errorf("snapshot: got %q, %v", v, err)
+	}
+	snap.Release()
+	h.put("t0/k2", "a2")
+
+	scan := func(kv KV, slice *util.Range) string {
+		var entries []string
+		iter := kv.NewIterator(slice, nil)
+		defer iter.Release()
+		for iter.Next() {
+			entries = append(entries, string(iter.Key())+"="+string(iter.Value()))
+		}
+		if err := iter.Error(); err != nil {
+			t.Fatal(err)
+		}
+		return strings.Join(entries, " ")
+	}
+	for _, c := range []struct {
+		kv    KV
+		slice *util.Range
+		want  string
+	}{
+		{a, nil, "k2=a2 k3=a3"},
+		{b, nil, "k1=b1"},
+		{a, &util.Range{Start: []byte("k3")}, "k3=a3"},
+		{a, &util.Range{Limit: []byte("k3")}, "k2=a2"},
+		{h.db.Namespace(nil), nil, "a=v t0/k2=a2 t0/k3=a3 t1/k1=b1"},
+	} {
+		if got := scan(c.kv, c.slice); got != c.want {
+			t.Errorf("%v: got %q, want %q", c.slice, got, c.want)
+		}
+	}
+
+	iter := a.NewIterator(nil, nil)
+	defer iter.Release()
+	if !iter.Seek([]byte("k3")) || string(iter.Key()) != "k3" {
+		t.Errorf("seek: got %q", iter.Key())
+	}
+	if iter.Seek([]byte("k4")) || iter.Key() != nil {
+		t.Errorf("seek past: got %q", iter.Key())
+	}
+	if !iter.Last() || string(iter.Key()) != "k3" || iter.Next() {
+		t.Errorf("last: got %q", iter.Key())
+	}
+}
+
+func TestScanPage(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	for _, k := range []string{"a", "b", "c", "d", "e", "f"} {
+		h.put(k, "v"+k)
+	}
+	bound := func(k string) []byte {
+		if k == "" {
+			return nil
+		}
+		return []byte(k)
+	}
+	scan := func(r Reader, start, limit string, o *PageOptions) string {
+		var pages []string
+		var cursor []byte
+		for {
+			p, err := ScanPage(r, bound(start), bound(limit), 2, cursor, o)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			for _, e := range p.Entries {
+				keys = append(keys, string(e.Key)+"="+string(e.Value))
+			}
+			pages = append(pages, strings.Join(keys, ","))
+			if cursor = p.Cursor; cursor == nil {
+				return strings.Join(pages, " ")
+			}
+		}
+	}
+	for _, c := range []struct {
+		start, limit string
+		o            *PageOptions
+		want         string
+	}{
+		{"", "", nil, "a=va,b=vb c=vc,d=vd e=ve,f=vf"},
+		{"b", "f", nil, "b=vb,c=vc d=vd,e=ve"},
+		{"b", "f", &PageOptions{Reverse: true}, "e=ve,d=vd c=vc,b=vb"},
+		{"", "", &PageOptions{Reverse: true, KeysOnly: true}, "f=,e= d=,c= b=,a="},
+		{"x", "", nil, ""},
+	} {
+		if got := scan(h.db, c.start, c.limit, c.o); got != c.want {
+			t.Errorf("%q-%q %+v: got %q, want %q", c.start, c.limit, c.o, got, c.want)
+		}
+	}
+
+	// Entries written between pages, around the cursor.
+	snap, err := h.db.GetSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Release()
+	p, err := ScanPage(h.db, nil, nil, 2, nil, &PageOptions{Reverse: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.delete("d")
+	h.put("cc", "vcc")
+	h.put("ee", "vee")
+	if p, err = ScanPage(h.db, nil, nil, 2, p.Cursor, &PageOptions{Reverse: true}); err != nil {
+		t.Fatal(err)
+	} else if len(p.Entries) != 2 || string(p.Entries[0].Key) != "cc" || string(p.Entries[1].Key) != "c" {
+		t.Errorf("got %v", p.Entries)
+	}
+	if got := scan(snap, "", "", nil); got != "a=va,b=vb c=vc,d=vd e=ve,f=vf" {
+		t.Errorf("snapshot: got %q", got)
+	}
+
+	if _, err := ScanPage(h.db, nil, nil, 2, p.Cursor, nil); err != ErrInvalidCursor {
+		t.Errorf("other direction: got %v", err)
+	}
+	if _, err := ScanPage(h.db, nil, nil, 0, nil, nil); err == nil {
+		t.Error("zero page size: no error")
+	}
+}
+
+func TestDB_CompactionPolicy(t *testing.T) {
+	var allow atomic.Bool
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		CompactionL0Trigger:          2,
+		CompactionPolicy:             func(time.Time) bool { return allow.Load() },
+		CompactionPolicyL0Trigger:    4,
+	})
+	defer h.close()
+
+	// The second wait returns once the compaction started by the first
+	// one, if any, is done.
+	settle := func() {
+		h.waitCompaction()
+		h.waitCompaction()
+	}
+	flush := func(n int) {
+		for i := 0; i < n; i++ {
+			h.put("a", "v")
+			h.put("z", "v")
+			h.compactMem()
+		}
+		settle()
+	}
+	level0 := func() int {
+		v := h.db.s.version()
+		defer v.release()
+		return v.tLen(0)
+	}
+
+	flush(3)
+	if n := level0(); n != 3 {
+		t.Errorf("deferred: got %d level-0 tables, want 3", n)
+	}
+	allow.Store(true)
+	settle()
+	if n := level0(); n != 0 {
+		t.Errorf("allowed: got %d level-0 tables, want 0", n)
+	}
+	allow.Store(false)
+	flush(3)
+	if n := level0(); n != 3 {
+		t.Errorf("deferred again: got %d level-0 tables, want 3", n)
+	}
+	// Level-0 pressure overrides the policy.
+	flush(1)
+	if n := level0(); n != 0 {
+		t.Errorf("pressure: got %d level-0 tables, want 0", n)
+	}
+
+	night := opt.CompactionWindows(opt.TimeWindow{Start: 22 * time.Hour, End: 6 * time.Hour}, opt.TimeWindow{Start: 12 * time.Hour, End: 13 * time.Hour})
+	for _, c := range []struct {
+		hour, min int
+		want      bool
+	}{
+		{23, 0, true}, {0, 30, true}, {6, 0, false}, {12, 59, true}, {13, 0, false}, {21, 59, false}, {22, 0, true},
+	} {
+		if got := night(time.Date(2024, 1, 1, c.hour, c.min, 0, 0, time.Local)); got != c.want {
+			t.Errorf("%02d:%02d: got %v, want %v", c.hour, c.min, got, c.want)
+		}
+	}
+
+	if err := (&opt.Options{CompactionPolicyL0Trigger: 20}).Validate(); err == nil {
+		t.Error("policy trigger above the pause trigger: no error")
+	}
+}
+
+func TestDB_SnapshotLifetime(t *testing.T) {
+	const lifetime = 50 * time.Millisecond
+	h := newDbHarnessWopt(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		MaxSnapshotLifetime:          lifetime,
+		TrackSnapshots:               true,
+	})
+	defer h.close()
+
+	h.put("foo", "v1")
+	snap := h.getSnapshot()
+	defer snap.Release()
+	h.put("foo", "v2")
+	h.getValr(snap, "foo", "v1")
+	if v, err := h.db.GetProperty("leveldb.snapshots"); err != nil {
+		t.Fatal(err)
+	} else if !strings.HasPrefix(v, "Alive:1 Expired:0\n") || !strings.Contains(v, "TestDB_SnapshotLifetime") {
+		t.Errorf("snapshots property: got %q", v)
+	}
+	var stats DBStats
+	if err := h.db.Stats(&stats); err != nil {
+		t.Fatal(err)
+	} else if stats.OldestSnapshotAge <= 0 || stats.AliveSnapshots != 1 {
+		t.Errorf("got oldest snapshot age %v, %d alive", stats.OldestSnapshotAge, stats.AliveSnapshots)
+	}
+
+	// Expired on use.
+	time.Sleep(lifetime)
+	_, err := snap.Get([]byte("foo"), nil)
+	var eerr *errors.ErrSnapshotExpired
+	if !errors.As(err, &eerr) || !errors.Is(err, ErrSnapshotReleased) || eerr.Lifetime != lifetime {
+		t.Errorf("got %v, want expired", err)
+	}
+	iter := snap.NewIterator(nil, nil)
+	if iter.Next() || !errors.As(iter.Error(), &eerr) {
+		t.Errorf("iterator: got %v, want expired", iter.Error())
+	}
+	iter.Release()
+
+	// Expired unused.
+	snap2 := h.getSnapshot()
+	defer snap2.Release()
+	h.put("foo", "v3")
+	time.Sleep(lifetime)
+	if err := h.db.Stats(&stats); err != nil {
+		t.Fatal(err)
+	} else if stats.ExpiredSnapshots != 2 || stats.AliveSnapshots != 0 || stats.OldestSnapshotAge != 0 {
+		t.Errorf("got %d expired, %d alive, oldest age %v", stats.ExpiredSnapshots, stats.AliveSnapshots, stats.OldestSnapshotAge)
+	}
+	if seq := h.db.minSeq(); seq != h.db.getSeq() {
+		t.Errorf("got min sequence %d, want %d", seq, h.db.getSeq())
+	}
+	if _, err := snap2.Has([]byte("foo"), nil); !errors.Is(err, ErrSnapshotReleased) {
+		t.Errorf("got %v, want expired", err)
+	}
+}
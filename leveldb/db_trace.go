@@ -0,0 +1,541 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// A workload trace starts with traceMagic and a byte of trace flags,
+// followed by the records of the operations. Each record is a byte of
+// record type, the uvarint number of nanoseconds since the start of the
+// trace, and the arguments of the operation:
+//
+//	get, has          key
+//	write             sync byte, uvarint count, count times:
+//	                  put byte 1, key, value, or delete byte 0, key
+//	iter              uvarint id, start, limit
+//	seek              uvarint id, key
+//	first, last, next uvarint id
+//	prev, release     uvarint id
+//	compact           start, limit
+//
+// A key is a uvarint length plus one, zero for nil, and the bytes; with
+// traceHashedKeys, keys but nil are 8 bytes. A value is the same, but only
+// the length is recorded with traceNoValues.
+const traceMagic = "goleveldb-trace\n"
+
+// Trace flags.
+const (
+	traceHashedKeys = 1 << iota
+	traceNoValues
+)
+
+// Trace record types.
+const (
+	traceGet byte = iota + 1
+	traceHas
+	traceWrite
+	traceIter
+	traceSeek
+	traceFirst
+	traceLast
+	traceNext
+	tracePrev
+	traceRelease
+	traceCompact
+)
+
+// TraceOptions holds the optional parameters of DB.StartTrace.
+type TraceOptions struct {
+	// HashKeys records keys as their 64-bit FNV-1a hash, so that the trace
+	// doesn't disclose them. The replay then reads and writes the same keys
+	// as the traced workload, but in another order.
+	HashKeys bool
+
+	// OmitValues records only the length of the values, the replay writing
+	// values of the same length.
+	OmitValues bool
+}
+
+var (
+	errTracing    = errors.New("leveldb: already tracing")
+	errTraceEnded = errors.New("leveldb: trace ended")
+)
+
+// traceWriter records the operations of a DB. It's safe for concurrent use.
+type traceWriter struct {
+	hashKeys, noValues bool
+	start              time.Time
+
+	mu       sync.Mutex
+	w        *bufio.Writer
+	err      error
+	nextIter uint64
+	buf      []byte
+}
+
+// StartTrace starts recording the operations of the DB to w, until
+// EndTrace: Get, Has, Put, Delete, Write, the iterators of NewIterator and
+// CompactRange, along with their timing. Reads of snapshots and
+// transactions aren't recorded. The trace can be replayed by DB.Replay,
+// e.g. against another DB or options to reproduce a performance problem.
+//
+// Recording has a small cost on each operation; w should be buffered if it
+// may block.
+func (db *DB) StartTrace(w io.Writer, o *TraceOptions) error {
+	if err := db.ok(); err != nil {
+		return err
+	}
+	t := &traceWriter{w: bufio.NewWriter(w), start: time.Now()}
+	var flags byte
+	if o != nil {
+		t.hashKeys, t.noValues = o.HashKeys, o.OmitValues
+		if t.hashKeys {
+			flags |= traceHashedKeys
+		}
+		if t.noValues {
+			flags |= traceNoValues
+		}
+	}
+	db.traceMu.Lock()
+	defer db.traceMu.Unlock()
+	if db.tracing() != nil {
+		return errTracing
+	}
+	t.w.WriteString(traceMagic)
+	t.w.WriteByte(flags)
+	db.trace.Store(t)
+	return nil
+}
+
+// EndTrace stops recording the operations of the DB, and flushes the
+// trace. It returns the first error writing the trace, which stops
+// recording.
+func (db *DB) EndTrace() error {
+	db.traceMu.Lock()
+	defer db.traceMu.Unlock()
+	t := db.tracing()
+	if t == nil {
+		return errors.New("leveldb: not tracing")
+	}
+	db.trace.Store((*traceWriter)(nil))
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err == nil {
+		t.err = t.w.Flush()
+	}
+	err := t.err
+	// The iterators created while tracing stop recording.
+	if t.err == nil {
+		t.err = errTraceEnded
+	}
+	return err
+}
+
+// Returns the trace writer, or nil if not tracing.
+func (db *DB) tracing() *traceWriter {
+	t, _ := db.trace.Load().(*traceWriter)
+	return t
+}
+
+// Starts a record; must be followed by end.
+func (t *traceWriter) begin(typ byte) {
+	t.mu.Lock()
+	t.buf = append(t.buf[:0], typ)
+	t.buf = binary.AppendUvarint(t.buf, uint64(time.Since(t.start)))
+}
+
+func (t *traceWriter) end() {
+	if t.err == nil {
+		_, t.err = t.w.Write(t.buf)
+	}
+	t.mu.Unlock()
+}
+
+func (t *traceWriter) appendUvarint(x uint64) {
+	t.buf = binary.AppendUvarint(t.buf, x)
+}
+
+func (t *traceWriter) appendKey(key []byte) {
+	if key == nil {
+		t.buf = append(t.buf, 0)
+		return
+	}
+	if t.hashKeys {
+		h := fnv.New64a()
+		h.Write(key)
+		t.buf = append(t.buf, 9)
+		t.buf = h.Sum(t.buf)
+		return
+	}
+	t.buf = binary.AppendUvarint(t.buf, uint64(len(key))+1)
+	t.buf = append(t.buf, key...)
+}
+
+func (t *traceWriter) appendValue(value []byte) {
+	t.buf = binary.AppendUvarint(t.buf, uint64(len(value))+1)
+	if !t.noValues {
+		t.buf = append(t.buf, value...)
+	}
+}
+
+func (t *traceWriter) key(typ byte, key []byte) {
+	t.begin(typ)
+	t.appendKey(key)
+	t.end()
+}
+
+func (t *traceWriter) put(key, value []byte) {
+	t.buf = append(t.buf, 1)
+	t.appendKey(key)
+	t.appendValue(value)
+}
+
+func (t *traceWriter) del(key []byte) {
+	t.buf = append(t.buf, 0)
+	t.appendKey(key)
+}
+
+// traceBatch records the records of a batch, see Batch.Replay.
+type traceBatch struct {
+	*traceWriter
+}
+
+func (t traceBatch) Put(key, value []byte) { t.put(key, value) }
+func (t traceBatch) Delete(key []byte)     { t.del(key) }
+
+func (t *traceWriter) write(batch *Batch, wo *opt.WriteOptions) {
+	t.begin(traceWrite)
+	if wo.GetSync() {
+		t.buf = append(t.buf, 1)
+	} else {
+		t.buf = append(t.buf, 0)
+	}
+	t.appendUvarint(uint64(batch.Len()))
+	batch.Replay(traceBatch{t})
+	t.end()
+}
+
+func (t *traceWriter) rec(kt keyType, key, value []byte, wo *opt.WriteOptions) {
+	t.begin(traceWrite)
+	if wo.GetSync() {
+		t.buf = append(t.buf, 1)
+	} else {
+		t.buf = append(t.buf, 0)
+	}
+	t.appendUvarint(1)
+	if kt == keyTypeVal {
+		t.put(key, value)
+	} else {
+		t.del(key)
+	}
+	t.end()
+}
+
+func (t *traceWriter) compact(r util.Range) {
+	t.begin(traceCompact)
+	t.appendKey(r.Start)
+	t.appendKey(r.Limit)
+	t.end()
+}
+
+func (t *traceWriter) newIter(iter iterator.Iterator, slice *util.Range) iterator.Iterator {
+	t.begin(traceIter)
+	t.nextIter++
+	id := t.nextIter
+	t.appendUvarint(id)
+	if slice != nil {
+		t.appendKey(slice.Start)
+		t.appendKey(slice.Limit)
+	} else {
+		t.buf = append(t.buf, 0, 0)
+	}
+	t.end()
+	return &tracedIter{Iterator: iter, t: t, id: id}
+}
+
+// tracedIter records the moves of an iterator.
+type tracedIter struct {
+	iterator.Iterator
+	t  *traceWriter
+	id uint64
+}
+
+func (i *tracedIter) op(typ byte, key []byte) {
+	// Released iterators aren't traced.
+	if i.t == nil {
+		return
+	}
+	i.t.begin(typ)
+	i.t.appendUvarint(i.id)
+	if typ == traceSeek {
+		i.t.appendKey(key)
+	}
+	i.t.end()
+}
+
+func (i *tracedIter) First() bool {
+	i.op(traceFirst, nil)
+	return i.Iterator.First()
+}
+
+func (i *tracedIter) Last() bool {
+	i.op(traceLast, nil)
+	return i.Iterator.Last()
+}
+
+func (i *tracedIter) Seek(key []byte) bool {
+	i.op(traceSeek, key)
+	return i.Iterator.Seek(key)
+}
+
+func (i *tracedIter) Next() bool {
+	i.op(traceNext, nil)
+	return i.Iterator.Next()
+}
+
+func (i *tracedIter) Prev() bool {
+	i.op(tracePrev, nil)
+	return i.Iterator.Prev()
+}
+
+func (i *tracedIter) Release() {
+	i.op(traceRelease, nil)
+	i.t = nil
+	i.Iterator.Release()
+}
+
+// ReplayOptions holds the optional parameters of DB.Replay.
+type ReplayOptions struct {
+	// Speed scales the pace of the replay: 1 issues the operations with
+	// the recorded timing, 2 twice as fast. Zero issues them as fast as
+	// possible.
+	Speed float64
+}
+
+// ReplayStats holds the statistics of a replay.
+type ReplayStats struct {
+	Gets, Writes, IterOps, Compactions int
+	// NotFound is the number of gets not finding their key.
+	NotFound int
+	// Duration is the time spent replaying, and Latency the total time
+	// spent in the operations.
+	Duration time.Duration
+	Latency  time.Duration
+}
+
+// traceReader decodes a trace.
+type traceReader struct {
+	r        *bufio.Reader
+	noValues bool
+	value    []byte // Synthetic values, with noValues.
+	err      error  // Sticky decoding error.
+}
+
+func (r *traceReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	x, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		r.err = err
+	}
+	return x
+}
+
+func (r *traceReader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	b, err := r.r.ReadByte()
+	if err != nil {
+		r.err = err
+	}
+	return b
+}
+
+func (r *traceReader) bytes(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > 1<<30 {
+		r.err = errors.New("length too large")
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		r.err = err
+	}
+	return b
+}
+
+func (r *traceReader) key() []byte {
+	n := r.uvarint()
+	if n == 0 {
+		return nil
+	}
+	return r.bytes(n - 1)
+}
+
+func (r *traceReader) valueOf() []byte {
+	n := r.uvarint()
+	if n == 0 {
+		return nil
+	}
+	if !r.noValues {
+		return r.bytes(n - 1)
+	}
+	for uint64(len(r.value)) < n-1 {
+		r.value = append(r.value, "0123456789abcdefghijklmnopqrstuvwxyz"...)
+	}
+	return r.value[:n-1]
+}
+
+// Replay replays a trace recorded by DB.StartTrace against the DB. The
+// operations are issued one after another, so the concurrency of the
+// traced workload isn't reproduced, but their timing is, as set by the
+// options, which may be nil. Replay stops at the first error but
+// ErrNotFound, or at the end of the trace.
+func (db *DB) Replay(r io.Reader, o *ReplayOptions) (*ReplayStats, error) {
+	tr := &traceReader{r: bufio.NewReader(r)}
+	magic := make([]byte, len(traceMagic)+1)
+	if _, err := io.ReadFull(tr.r, magic); err != nil || string(magic[:len(traceMagic)]) != traceMagic {
+		return nil, errors.New("leveldb: replay: not a trace")
+	}
+	tr.noValues = magic[len(traceMagic)]&traceNoValues != 0
+	speed := 0.0
+	if o != nil && o.Speed > 0 {
+		speed = o.Speed
+	}
+
+	stats := new(ReplayStats)
+	iters := make(map[uint64]iterator.Iterator)
+	defer func() {
+		for _, iter := range iters {
+			iter.Release()
+		}
+	}()
+	start := time.Now()
+	for n := 1; ; n++ {
+		typ, err := tr.r.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, err
+		}
+		at := time.Duration(tr.uvarint())
+		if speed > 0 {
+			if d := time.Duration(float64(at)/speed) - time.Since(start); d > 0 {
+				time.Sleep(d)
+			}
+		}
+
+		opStart := time.Now()
+		err = nil
+		switch typ {
+		case traceGet, traceHas:
+			key := tr.key()
+			if tr.err != nil {
+				break
+			}
+			if typ == traceGet {
+				_, err = db.Get(key, nil)
+			} else {
+				var found bool
+				if found, err = db.Has(key, nil); err == nil && !found {
+					err = ErrNotFound
+				}
+			}
+			stats.Gets++
+			if err == ErrNotFound {
+				stats.NotFound++
+				err = nil
+			}
+		case traceWrite:
+			wo := &opt.WriteOptions{Sync: tr.byte() == 1}
+			b := new(Batch)
+			for count := tr.uvarint(); count > 0 && tr.err == nil; count-- {
+				if tr.byte() == 1 {
+					b.Put(tr.key(), tr.valueOf())
+				} else {
+					b.Delete(tr.key())
+				}
+			}
+			if tr.err == nil {
+				err = db.Write(b, wo)
+				stats.Writes++
+			}
+		case traceIter:
+			id := tr.uvarint()
+			slice := &util.Range{Start: tr.key(), Limit: tr.key()}
+			if tr.err == nil {
+				iters[id] = db.NewIterator(slice, nil)
+				stats.IterOps++
+			}
+		case traceSeek, traceFirst, traceLast, traceNext, tracePrev, traceRelease:
+			id := tr.uvarint()
+			var key []byte
+			if typ == traceSeek {
+				key = tr.key()
+			}
+			iter := iters[id]
+			if tr.err != nil || iter == nil {
+				break
+			}
+			switch typ {
+			case traceSeek:
+				iter.Seek(key)
+			case traceFirst:
+				iter.First()
+			case traceLast:
+				iter.Last()
+			case traceNext:
+				iter.Next()
+			case tracePrev:
+				iter.Prev()
+			case traceRelease:
+				err = iter.Error()
+				iter.Release()
+				delete(iters, id)
+			}
+			stats.IterOps++
+		case traceCompact:
+			rg := util.Range{Start: tr.key(), Limit: tr.key()}
+			if tr.err == nil {
+				err = db.CompactRange(rg)
+				stats.Compactions++
+			}
+		default:
+			tr.err = fmt.Errorf("unknown record type %d", typ)
+		}
+		stats.Latency += time.Since(opStart)
+		if tr.err != nil {
+			if tr.err == io.EOF {
+				tr.err = io.ErrUnexpectedEOF
+			}
+			return stats, fmt.Errorf("leveldb: replay: record %d: %v", n, tr.err)
+		}
+		if err != nil {
+			return stats, err
+		}
+	}
+	stats.Duration = time.Since(start)
+	return stats, nil
+}
@@ -28,6 +28,7 @@ type Transaction struct {
 	ikScratch []byte
 	rec       sessionRecord
 	stats     cStatStaging
+	ingested  int // Size of the put entries, for amplification stats.
 	closed    bool
 }
 
@@ -43,7 +44,25 @@ func (tr *Transaction) Get(key []byte, ro *opt.ReadOptions) ([]byte, error) {
 	if tr.closed {
 		return nil, errTransactionDone
 	}
-	return tr.db.get(tr.mem.DB, tr.tables, key, tr.seq, ro)
+	return tr.db.get(tr.mem.Memtable, tr.tables, key, tr.seq, ro, nil)
+}
+
+// GetTo is like Get, but appends the value to dst and returns the updated
+// slice, so that reusing dst across calls avoids allocating the value. On
+// error, including ErrNotFound, dst is returned as is.
+//
+// It is safe to modify the contents of the argument after GetTo returns.
+func (tr *Transaction) GetTo(key, dst []byte, ro *opt.ReadOptions) ([]byte, error) {
+	tr.lk.RLock()
+	defer tr.lk.RUnlock()
+	if tr.closed {
+		return dst, errTransactionDone
+	}
+	value, err := tr.db.get(tr.mem.Memtable, tr.tables, key, tr.seq, ro, dst)
+	if err != nil {
+		return dst, err
+	}
+	return value, nil
 }
 
 // Has returns true if the DB does contains the given key.
@@ -55,7 +74,7 @@ func (tr *Transaction) Has(key []byte, ro *opt.ReadOptions) (bool, error) {
 	if tr.closed {
 		return false, errTransactionDone
 	}
-	return tr.db.has(tr.mem.DB, tr.tables, key, tr.seq, ro)
+	return tr.db.has(tr.mem.Memtable, tr.tables, key, tr.seq, ro)
 }
 
 // NewIterator returns an iterator for the latest snapshot of the transaction.
@@ -110,7 +129,8 @@ func (tr *Transaction) flush() error {
 		tr.tables = append(tr.tables, t)
 		tr.rec.addTableFile(0, t)
 		tr.stats.write += t.size
-		tr.db.logf("transaction@flush created L0@%d N·%d S·%s %q:%q", t.fd.Num, n, shortenb(t.size), t.imin, t.imax)
+		tr.db.logInfo("transaction@flush created", "level", 0, "num", t.fd.Num, "entries", n, "size", t.size, "min", t.imin, "max", t.imax)
+		tr.db.s.o.GetEventListener().OnTableCreated(opt.TableInfo{Num: t.fd.Num, Level: 0, Size: t.size, Reason: opt.TableReasonTransaction})
 	}
 	return nil
 }
@@ -125,6 +145,7 @@ func (tr *Transaction) put(kt keyType, key, value []byte) error {
 	if err := tr.mem.Put(tr.ikScratch, value); err != nil {
 		return err
 	}
+	tr.ingested += len(tr.ikScratch) + len(value)
 	tr.seq++
 	return nil
 }
@@ -141,6 +162,9 @@ func (tr *Transaction) Put(key, value []byte, wo *opt.WriteOptions) error {
 	if tr.closed {
 		return errTransactionDone
 	}
+	if err := tr.db.checkRec(key, value); err != nil {
+		return err
+	}
 	return tr.put(keyTypeVal, key, value)
 }
 
@@ -155,6 +179,9 @@ func (tr *Transaction) Delete(key []byte, wo *opt.WriteOptions) error {
 	if tr.closed {
 		return errTransactionDone
 	}
+	if err := tr.db.checkRec(key, nil); err != nil {
+		return err
+	}
 	return tr.put(keyTypeDel, key, nil)
 }
 
@@ -174,6 +201,9 @@ func (tr *Transaction) Write(b *Batch, wo *opt.WriteOptions) error {
 	if tr.closed {
 		return errTransactionDone
 	}
+	if err := tr.db.checkBatch(b); err != nil {
+		return err
+	}
 	return b.replayInternal(func(i int, kt keyType, k, v []byte) error {
 		return tr.put(kt, k, v)
 	})
@@ -214,17 +244,20 @@ func (tr *Transaction) Commit() error {
 		for retry := 0; retry < 3; retry++ {
 			cerr = tr.db.s.commit(&tr.rec, false)
 			if cerr != nil {
-				tr.db.logf("transaction@commit error R·%d %q", retry, cerr)
+				tr.db.logWarn("transaction@commit error", "retry", retry, "err", cerr)
 				select {
 				case <-time.After(time.Second):
 				case <-tr.db.closeC:
-					tr.db.logf("transaction@commit exiting")
+					tr.db.logInfo("transaction@commit exiting")
 					tr.db.compCommitLk.Unlock()
 					return cerr
 				}
 			} else {
 				// Success. Set db.seq.
 				tr.db.setSeq(tr.seq)
+				if tr.db.wal != nil {
+					tr.db.wal.reset(tr.seq)
+				}
 				break
 			}
 		}
@@ -236,7 +269,10 @@ func (tr *Transaction) Commit() error {
 		}
 
 		// Update compaction stats. This is safe as long as we hold compCommitLk.
-		tr.db.compStats.addStat(0, &tr.stats)
+		tr.db.addCompStat(0, &tr.stats)
+		if tr.db.amp != nil {
+			tr.db.amp.addIngested(tr.ingested)
+		}
 
 		// Trigger table auto-compaction.
 		tr.db.compTrigger(tr.db.tcompCmdC)
@@ -254,7 +290,7 @@ func (tr *Transaction) Commit() error {
 func (tr *Transaction) discard() {
 	// Discard transaction.
 	for _, t := range tr.tables {
-		tr.db.logf("transaction@discard @%d", t.fd.Num)
+		tr.db.logInfo("transaction@discard", "num", t.fd.Num)
 		// Iterator may still use the table, so we use tOps.remove here.
 		tr.db.s.tops.remove(t.fd)
 	}
@@ -7,6 +7,11 @@
 package leveldb
 
 import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/cache"
 	"github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
@@ -14,6 +19,44 @@ import (
 	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
+// Bounds of the delay between the attempts of retryLocked.
+const (
+	minLockRetryDelay = 10 * time.Millisecond
+	maxLockRetryDelay = 250 * time.Millisecond
+)
+
+// Calls lock until it doesn't fail as the storage is locked, e.g. by a
+// process still exiting, the timeout expires, or the context is done.
+func retryLocked(ctx context.Context, timeout time.Duration, lock func() error) error {
+	err := lock()
+	if timeout <= 0 || !errors.Is(err, storage.ErrLocked) {
+		return err
+	}
+	deadline := time.Now().Add(timeout)
+	for delay := minLockRetryDelay; ; delay *= 2 {
+		left := time.Until(deadline)
+		if left <= 0 {
+			return err
+		}
+		if delay > maxLockRetryDelay {
+			delay = maxLockRetryDelay
+		}
+		if delay > left {
+			delay = left
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+		if err = lock(); !errors.Is(err, storage.ErrLocked) {
+			return err
+		}
+	}
+}
+
 // Reader is the interface that wraps basic Get and NewIterator methods.
 // This interface implemented by both DB and Snapshot.
 type Reader interface {
@@ -34,8 +77,104 @@ func (sizes Sizes) Sum() int64 {
 }
 
 // Logging.
-func (db *DB) log(v ...interface{})                 { db.s.log(v...) }
-func (db *DB) logf(format string, v ...interface{}) { db.s.logf(format, v...) }
+// Starts a span, or returns nil if there is no tracer.
+func (db *DB) startSpan(name string) opt.Span {
+	if tracer := db.s.o.GetTracer(); tracer != nil {
+		return tracer.Start(name)
+	}
+	return nil
+}
+
+// Ends a read span, where not found isn't an error.
+func endReadSpan(span opt.Span, err error) {
+	if err == ErrNotFound {
+		span.SetAttribute("found", 0)
+		err = nil
+	} else if err == nil {
+		span.SetAttribute("found", 1)
+	}
+	span.End(err)
+}
+
+// Ends a read span of several keys, counting those found.
+func endMultiReadSpan(span opt.Span, values [][]byte, err error) {
+	if err == nil {
+		var found int64
+		for _, v := range values {
+			if v != nil {
+				found++
+			}
+		}
+		span.SetAttribute("found", found)
+	}
+	span.End(err)
+}
+
+func (db *DB) logDebug(msg string, kv ...interface{}) { db.s.logDebug(msg, kv...) }
+func (db *DB) logInfo(msg string, kv ...interface{})  { db.s.logInfo(msg, kv...) }
+func (db *DB) logWarn(msg string, kv ...interface{})  { db.s.logWarn(msg, kv...) }
+func (db *DB) logError(msg string, kv ...interface{}) { db.s.logError(msg, kv...) }
+
+// Returns where to record the tables looked up by a Get or Has, and the
+// function to call with its error once done, if the tables are needed for
+// the amplification statistics or for logging slow gets. Otherwise both are
+// nil.
+func (db *DB) trackGet(msg string, key []byte) (touched *[]tSet, done func(err error)) {
+	threshold, amp := db.s.o.GetSlowOperationThreshold(), db.amp
+	if threshold <= 0 && amp == nil {
+		return nil, nil
+	}
+	start, tables := time.Now(), []tSet(nil)
+	return &tables, func(err error) {
+		if amp != nil {
+			amp.addGet(start, tables)
+		}
+		if d := time.Since(start); threshold > 0 && d >= threshold {
+			names := make([]string, len(tables))
+			for i, t := range tables {
+				names[i] = tableName(t.level, t.table.fd.Num)
+			}
+			db.logWarn(msg, "duration", d, "key", key, "tables", names, "err", err)
+		}
+	}
+}
+
+// Records the compaction stats of the given level.
+func (db *DB) addCompStat(level int, n *cStatStaging) {
+	db.compStats.addStat(level, n)
+	if db.amp != nil {
+		db.amp.addCompacted(level, n.write)
+	}
+}
+
+// Logs a flush or compaction exceeding the slow operation threshold.
+func (db *DB) logSlowCompaction(msg string, d time.Duration, kv ...interface{}) {
+	if threshold := db.s.o.GetSlowOperationThreshold(); threshold > 0 && d >= threshold {
+		db.logWarn(msg, append([]interface{}{"duration", d}, kv...)...)
+	}
+}
+
+func formatCacheStats(s cache.Stats) string {
+	return fmt.Sprintf("Hits:%d Misses:%d Inserts:%d Evictions:%d Size:%d Pinned:%d",
+		s.HitCount, s.MissCount, s.SetCount, s.EvictCount, s.Size, s.PinnedSize)
+}
+
+func tableInfoNames(infos []opt.TableInfo) []string {
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = tableName(info.Level, info.Num)
+	}
+	return names
+}
+
+// Returns the name of a table for logging, e.g. "L1@12", or "aux@12" for a
+// table not yet in a level.
+func tableName(level int, num int64) string {
+	if level < 0 {
+		return fmt.Sprintf("aux@%d", num)
+	}
+	return fmt.Sprintf("L%d@%d", level, num)
+}
 
 // Check and clean files.
 func (db *DB) checkAndCleanFiles() error {
@@ -62,8 +201,8 @@ func (db *DB) checkAndCleanFiles() error {
 		case storage.TypeManifest:
 			keep = fd.Num >= db.s.manifestFd.Num
 		case storage.TypeJournal:
-			if !db.frozenJournalFd.Zero() {
-				keep = fd.Num >= db.frozenJournalFd.Num
+			if len(db.frozenMems) > 0 {
+				keep = fd.Num >= db.frozenMems[0].journalFd.Num
 			} else {
 				keep = fd.Num >= db.journalFd.Num
 			}
@@ -85,16 +224,21 @@ func (db *DB) checkAndCleanFiles() error {
 		for num, present := range tmap {
 			if !present {
 				mfds = append(mfds, storage.FileDesc{Type: storage.TypeTable, Num: num})
-				db.logf("db@janitor table missing @%d", num)
+				db.logWarn("db@janitor table missing", "num", num)
 			}
 		}
 		return errors.NewErrCorrupted(storage.FileDesc{}, &errors.ErrMissingFiles{Fds: mfds})
 	}
 
-	db.logf("db@janitor F·%d G·%d", len(fds), len(rem))
+	db.logDebug("db@janitor", "files", len(fds), "garbage", len(rem))
 	for _, fd := range rem {
-		db.logf("db@janitor removing %s-%d", fd.Type, fd.Num)
-		if err := db.s.stor.Remove(fd); err != nil {
+		db.logInfo("db@janitor removing", "type", fd.Type, "num", fd.Num)
+		if fd.Type == storage.TypeJournal {
+			err = db.removeJournal(fd)
+		} else {
+			err = db.s.stor.Remove(fd)
+		}
+		if err != nil {
 			return err
 		}
 	}
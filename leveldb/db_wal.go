@@ -0,0 +1,206 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+var (
+	// ErrWALDisabled is returned by NewWALIterator when the DB keeps no
+	// history of the write batches, see opt.Options.WALRetentionSize.
+	ErrWALDisabled = errors.New("leveldb: WAL retention disabled")
+
+	// ErrWALTruncated is returned by NewWALIterator, and by the Error
+	// method of a WALIterator, when the batches following the sequence
+	// number are no longer kept, or weren't written since the DB was
+	// opened, or when the sequence number is ahead of the DB.
+	ErrWALTruncated = errors.New("leveldb: WAL history truncated")
+)
+
+type walBatch struct {
+	seq  uint64
+	n    int // Number of records.
+	data []byte
+}
+
+// walLog is the history of the recent write batches.
+type walLog struct {
+	mu      sync.Mutex
+	max     int
+	size    int
+	batches []walBatch
+	// The last sequence numbers before the oldest batch kept and of the
+	// newest one.
+	start, last uint64
+	// Closed and replaced when a batch is added, or the DB is closed.
+	notifyC chan struct{}
+	closed  bool
+}
+
+func newWALLog(max int, seq uint64) *walLog {
+	return &walLog{
+		max:     max,
+		start:   seq,
+		last:    seq,
+		notifyC: make(chan struct{}),
+	}
+}
+
+func (l *walLog) notifyLocked() {
+	close(l.notifyC)
+	l.notifyC = make(chan struct{})
+}
+
+// Adds the batches written at the given sequence number. Must be called
+// with the write lock held, so that the batches are added in order.
+func (l *walLog) add(batches []*Batch, seq uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, b := range batches {
+		if b.Len() == 0 {
+			continue
+		}
+		data := append([]byte(nil), b.data...)
+		l.batches = append(l.batches, walBatch{seq: seq, n: b.Len(), data: data})
+		l.size += len(data)
+		seq += uint64(b.Len())
+	}
+	l.last = seq - 1
+	for l.size > l.max && len(l.batches) > 0 {
+		b := l.batches[0]
+		l.batches[0] = walBatch{}
+		l.batches = l.batches[1:]
+		l.size -= len(b.data)
+		l.start = b.seq + uint64(b.n) - 1
+	}
+	l.notifyLocked()
+}
+
+// Drops the history, which continues at the given sequence number, e.g.
+// after a transaction, whose writes don't go through the write path.
+func (l *walLog) reset(seq uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.batches, l.size = nil, 0
+	l.start, l.last = seq, seq
+	l.notifyLocked()
+}
+
+func (l *walLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	l.notifyLocked()
+}
+
+// WALIterator iterates over the write batches committed after a sequence
+// number, in order, see DB.NewWALIterator. It reads the batches committed
+// meanwhile, so it may be used to tail the writes of the DB: Next returns
+// false once it's caught up, and Wait tells when to call it again.
+//
+// A WALIterator isn't safe for concurrent use.
+type WALIterator struct {
+	l     *walLog
+	seq   uint64
+	bseq  uint64
+	batch *Batch
+	err   error
+}
+
+// NewWALIterator returns an iterator over the write batches committed after
+// the given sequence number, which must be that of a write kept in the
+// history, or the last sequence number before it, e.g. that of a snapshot
+// or of the DB. The history is kept in memory, as set by the
+// WALRetentionSize option, and starts at the opening of the DB.
+//
+// Transactions don't go through the write path, and drop the history when
+// committed.
+func (db *DB) NewWALIterator(seq uint64) (*WALIterator, error) {
+	if err := db.ok(); err != nil {
+		return nil, err
+	}
+	if db.wal == nil {
+		return nil, ErrWALDisabled
+	}
+	l := db.wal
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if seq < l.start || seq > l.last {
+		return nil, ErrWALTruncated
+	}
+	return &WALIterator{l: l, seq: seq}, nil
+}
+
+// Next moves to the next batch, returning false if there is none yet, or
+// on error.
+func (it *WALIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	l := it.l
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch {
+	case l.closed:
+		it.err = ErrClosed
+		return false
+	case it.seq < l.start:
+		it.err = ErrWALTruncated
+		return false
+	case it.seq >= l.last:
+		return false
+	}
+	i := sort.Search(len(l.batches), func(i int) bool {
+		return l.batches[i].seq > it.seq
+	})
+	b := l.batches[i]
+	batch := new(Batch)
+	if err := batch.decode(b.data[:len(b.data):len(b.data)], b.n); err != nil {
+		it.err = err
+		return false
+	}
+	it.batch, it.bseq = batch, b.seq
+	it.seq = b.seq + uint64(b.n) - 1
+	return true
+}
+
+// Seq returns the sequence number of the first record of the current batch.
+func (it *WALIterator) Seq() uint64 {
+	return it.bseq
+}
+
+// LastSeq returns the sequence number of the last record of the current
+// batch, or the one the iterator was created at if Next wasn't called.
+func (it *WALIterator) LastSeq() uint64 {
+	return it.seq
+}
+
+// Batch returns the current batch, which belongs to the caller.
+func (it *WALIterator) Batch() *Batch {
+	return it.batch
+}
+
+// Error returns the error of the iterator, if any.
+func (it *WALIterator) Error() error {
+	return it.err
+}
+
+// Wait returns a channel closed when Next may return a new batch or fail.
+func (it *WALIterator) Wait() <-chan struct{} {
+	l := it.l
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if it.err != nil || l.closed || it.seq != l.last {
+		c := make(chan struct{})
+		close(c)
+		return c
+	}
+	return l.notifyC
+}
@@ -7,20 +7,55 @@
 package leveldb
 
 import (
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/memdb"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
+// Minimum total size of the merged batches to be put into a sharded memdb
+// concurrently; smaller writes don't pay for the goroutines.
+const putMemConcurrentMinSize = 64 * opt.KiB
+
+// putMemConcurrent puts merged batches into a sharded memdb, each shard by
+// its own goroutine, so that they don't contend on the shard locks. It
+// returns the first error, if any.
+func putMemConcurrent(batches []*Batch, seq uint64, mdb *memdb.DB) error {
+	errs := make([]error, mdb.Shards())
+	var wg sync.WaitGroup
+	wg.Add(len(errs))
+	for shard := range errs {
+		go func(shard int) {
+			defer wg.Done()
+			seq := seq
+			for _, batch := range batches {
+				if err := batch.putMemShard(seq, mdb, shard); err != nil {
+					errs[shard] = err
+					return
+				}
+				seq += uint64(batch.Len())
+			}
+		}(shard)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (db *DB) writeJournal(batches []*Batch, seq uint64, sync bool) error {
 	wr, err := db.journal.Next()
 	if err != nil {
 		return err
 	}
-	if err := writeBatchesWithHeader(wr, batches, seq); err != nil {
+	if err := db.journalCodec.writeBatches(wr, batches, seq, db.s.o.GetJournalCompression()); err != nil {
 		return err
 	}
 	if err := db.journal.Flush(); err != nil {
@@ -32,13 +67,69 @@ func (db *DB) writeJournal(batches []*Batch, seq uint64, sync bool) error {
 	return nil
 }
 
+// Set write stall condition and call the write stall handler if the
+// condition changed; need write lock.
+func (db *DB) setWriteStall(cond opt.WriteStallCondition, reason opt.WriteStallReason) {
+	if db.writeStall == cond && db.writeStallReason == reason {
+		return
+	}
+	prev := db.writeStall
+	db.writeStall = cond
+	db.writeStallReason = reason
+	if handler := db.s.o.GetWriteStallHandler(); handler != nil {
+		handler(opt.WriteStallInfo{
+			Condition:     cond,
+			PrevCondition: prev,
+			Reason:        reason,
+			Level0Tables:  db.s.tLen(0),
+
+			PendingCompactionBytes: db.s.pendingCompactionBytes(),
+		})
+	}
+}
+
+// Reevaluate write stall condition based on number of level-0 tables and
+// the pending compaction bytes; need write lock. Writes pausing on the
+// pending compaction bytes are delayed until the 'memdb' is full.
+func (db *DB) updateWriteStall() {
+	switch {
+	case db.s.tLen(0) >= db.s.o.GetWriteL0SlowdownTrigger():
+		db.setWriteStall(opt.WriteStallDelayed, opt.WriteStallReasonL0Files)
+	case db.pendingBytesStall(db.s.pendingCompactionBytes()):
+		db.setWriteStall(opt.WriteStallDelayed, opt.WriteStallReasonPendingCompactionBytes)
+	default:
+		db.setWriteStall(opt.WriteStallNormal, opt.WriteStallReasonNone)
+	}
+}
+
+// Tells whether the given pending compaction bytes reach either of their
+// triggers.
+func (db *DB) pendingBytesStall(pending int64) bool {
+	slowdownTrigger := db.s.o.GetWritePendingCompactionBytesSlowdownTrigger()
+	pauseTrigger := db.s.o.GetWritePendingCompactionBytesPauseTrigger()
+	return (slowdownTrigger > 0 && pending >= slowdownTrigger) || (pauseTrigger > 0 && pending >= pauseTrigger)
+}
+
 func (db *DB) rotateMem(n int, wait bool) (mem *memDB, err error) {
 	retryLimit := 3
+	stalled := false
+	defer func() {
+		if stalled {
+			db.updateWriteStall()
+		}
+	}()
 retry:
-	// Wait for pending memdb compaction.
-	err = db.compTriggerWait(db.mcompCmdC)
-	if err != nil {
-		return
+	// Writes are stopped until pending memdb compaction done, if frozen
+	// memdbs queue is full.
+	if db.frozenMemLen() >= db.s.o.GetMaxFrozenWriteBuffer() {
+		db.setWriteStall(opt.WriteStallStopped, opt.WriteStallReasonMemdb)
+		stalled = true
+
+		// Wait for pending memdb compaction.
+		err = db.compTriggerWait(db.mcompCmdC)
+		if err != nil {
+			return
+		}
 	}
 	retryLimit--
 
@@ -67,6 +158,8 @@ func (db *DB) flush(n int) (mdb *memDB, mdbFree int, err error) {
 	delayed := false
 	slowdownTrigger := db.s.o.GetWriteL0SlowdownTrigger()
 	pauseTrigger := db.s.o.GetWriteL0PauseTrigger()
+	pendingSlowdownTrigger := db.s.o.GetWritePendingCompactionBytesSlowdownTrigger()
+	pendingPauseTrigger := db.s.o.GetWritePendingCompactionBytesPauseTrigger()
 	flush := func() (retry bool) {
 		mdb = db.getEffectiveMem()
 		if mdb == nil {
@@ -80,15 +173,22 @@ func (db *DB) flush(n int) (mdb *memDB, mdbFree int, err error) {
 			}
 		}()
 		tLen := db.s.tLen(0)
+		pending := db.s.pendingCompactionBytes()
 		mdbFree = mdb.Free()
 		switch {
 		case tLen >= slowdownTrigger && !delayed:
 			delayed = true
+			db.setWriteStall(opt.WriteStallDelayed, opt.WriteStallReasonL0Files)
+			time.Sleep(time.Millisecond)
+		case pendingSlowdownTrigger > 0 && pending >= pendingSlowdownTrigger && !delayed:
+			delayed = true
+			db.setWriteStall(opt.WriteStallDelayed, opt.WriteStallReasonPendingCompactionBytes)
 			time.Sleep(time.Millisecond)
 		case mdbFree >= n:
 			return false
 		case tLen >= pauseTrigger:
 			delayed = true
+			db.setWriteStall(opt.WriteStallStopped, opt.WriteStallReasonL0Files)
 			// Set the write paused flag explicitly.
 			atomic.StoreInt32(&db.inWritePaused, 1)
 			err = db.compTriggerWait(db.tcompCmdC)
@@ -97,6 +197,15 @@ func (db *DB) flush(n int) (mdb *memDB, mdbFree int, err error) {
 			if err != nil {
 				return false
 			}
+		case pendingPauseTrigger > 0 && pending >= pendingPauseTrigger:
+			delayed = true
+			db.setWriteStall(opt.WriteStallStopped, opt.WriteStallReasonPendingCompactionBytes)
+			atomic.StoreInt32(&db.inWritePaused, 1)
+			err = db.compTriggerWait(db.tcompCmdC)
+			atomic.StoreInt32(&db.inWritePaused, 0)
+			if err != nil {
+				return false
+			}
 		default:
 			// Allow memdb to grow if it has no entry.
 			if mdb.Len() == 0 {
@@ -117,11 +226,14 @@ func (db *DB) flush(n int) (mdb *memDB, mdbFree int, err error) {
 	start := time.Now()
 	for flush() {
 	}
+	if err == nil {
+		db.updateWriteStall()
+	}
 	if delayed {
 		db.writeDelay += time.Since(start)
 		db.writeDelayN++
 	} else if db.writeDelayN > 0 {
-		db.logf("db@write was delayed N·%d T·%v", db.writeDelayN, db.writeDelay)
+		db.logWarn("db@write was delayed", "count", db.writeDelayN, "duration", db.writeDelay)
 		atomic.AddInt32(&db.cWriteDelayN, int32(db.writeDelayN))
 		atomic.AddInt64(&db.cWriteDelay, int64(db.writeDelay))
 		db.writeDelay = 0
@@ -170,52 +282,73 @@ func (db *DB) writeLocked(batch, ourBatch *Batch, merge, sync bool) error {
 	if merge {
 		// Merge limit.
 		var mergeLimit int
-		if batch.internalLen > 128<<10 {
-			mergeLimit = (1 << 20) - batch.internalLen
+		maxSize := db.s.o.GetWriteMergeMaxSize()
+		if batch.internalLen > maxSize/8 {
+			mergeLimit = maxSize - batch.internalLen
 		} else {
-			mergeLimit = 128 << 10
+			mergeLimit = maxSize / 8
 		}
 		mergeCap := mdbFree - batch.internalLen
 		if mergeLimit > mergeCap {
 			mergeLimit = mergeCap
 		}
 
+		// Merge wait.
+		var waitC <-chan time.Time
+		if wait := db.s.o.GetWriteMergeWait(); wait > 0 {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			waitC = timer.C
+		}
+		noMixedSync := db.s.o.GetWriteMergeNoMixedSync()
+
 	merge:
 		for mergeLimit > 0 {
+			var incoming writeMerge
 			select {
-			case incoming := <-db.writeMergeC:
-				if incoming.batch != nil {
-					// Merge batch.
-					if incoming.batch.internalLen > mergeLimit {
-						overflow = true
-						break merge
-					}
-					batches = append(batches, incoming.batch)
-					mergeLimit -= incoming.batch.internalLen
-				} else {
-					// Merge put.
-					internalLen := len(incoming.key) + len(incoming.value) + 8
-					if internalLen > mergeLimit {
-						overflow = true
-						break merge
-					}
-					if ourBatch == nil {
-						ourBatch = db.batchPool.Get().(*Batch)
-						ourBatch.Reset()
-						batches = append(batches, ourBatch)
-					}
-					// We can use same batch since concurrent write doesn't
-					// guarantee write order.
-					ourBatch.appendRec(incoming.keyType, incoming.key, incoming.value)
-					mergeLimit -= internalLen
-				}
-				sync = sync || incoming.sync
-				merged++
-				db.writeMergedC <- true
-
+			case incoming = <-db.writeMergeC:
 			default:
+				if waitC == nil {
+					break merge
+				}
+				select {
+				case incoming = <-db.writeMergeC:
+				case <-waitC:
+					break merge
+				}
+			}
+			if noMixedSync && incoming.sync != sync {
+				overflow = true
 				break merge
 			}
+			if incoming.batch != nil {
+				// Merge batch.
+				if incoming.batch.internalLen > mergeLimit {
+					overflow = true
+					break merge
+				}
+				batches = append(batches, incoming.batch)
+				mergeLimit -= incoming.batch.internalLen
+			} else {
+				// Merge put.
+				internalLen := len(incoming.key) + len(incoming.value) + 8
+				if internalLen > mergeLimit {
+					overflow = true
+					break merge
+				}
+				if ourBatch == nil {
+					ourBatch = db.batchPool.Get().(*Batch)
+					ourBatch.Reset()
+					batches = append(batches, ourBatch)
+				}
+				// We can use same batch since concurrent write doesn't
+				// guarantee write order.
+				ourBatch.appendRec(incoming.keyType, incoming.key, incoming.value)
+				mergeLimit -= internalLen
+			}
+			sync = sync || incoming.sync
+			merged++
+			db.writeMergedC <- true
 		}
 	}
 
@@ -234,15 +367,30 @@ func (db *DB) writeLocked(batch, ourBatch *Batch, merge, sync bool) error {
 	}
 
 	// Put batches.
-	for _, batch := range batches {
-		if err := batch.putMem(seq, mdb.DB); err != nil {
-			panic(err)
+	if sdb, ok := mdb.Memtable.(*memdb.DB); ok && sdb.Shards() > 1 && batchesInternalLen(batches) >= putMemConcurrentMinSize {
+		err = putMemConcurrent(batches, seq, sdb)
+	} else {
+		for _, batch := range batches {
+			if err = batch.putMem(seq, mdb.Memtable); err != nil {
+				break
+			}
+			seq += uint64(batch.Len())
 		}
-		seq += uint64(batch.Len())
+	}
+	if err != nil {
+		db.unlockWrite(overflow, merged, err)
+		return err
+	}
+
+	if db.wal != nil {
+		db.wal.add(batches, db.seq+1)
 	}
 
 	// Incr seq number.
 	db.addSeq(uint64(batchesLen(batches)))
+	if db.amp != nil {
+		db.amp.addIngested(batchesInternalLen(batches))
+	}
 
 	// Rotate memdb if it's reach the threshold.
 	if batch.internalLen >= mdbFree {
@@ -256,17 +404,62 @@ func (db *DB) writeLocked(batch, ourBatch *Batch, merge, sync bool) error {
 	return nil
 }
 
+// Checks the sizes of a record against the limits of the options.
+func (db *DB) checkRec(key, value []byte) error {
+	if max := db.s.o.GetMaxKeySize(); max > 0 && len(key) > max {
+		return &errors.ErrTooLarge{What: "key", Size: len(key), Limit: max}
+	}
+	if max := db.s.o.GetMaxValueSize(); max > 0 && len(value) > max {
+		return &errors.ErrTooLarge{What: "value", Size: len(value), Limit: max}
+	}
+	return nil
+}
+
+// Checks the sizes of the records of a batch, see checkRec.
+func (db *DB) checkBatch(batch *Batch) error {
+	if db.s.o.GetMaxKeySize() == 0 && db.s.o.GetMaxValueSize() == 0 {
+		return nil
+	}
+	for _, index := range batch.index {
+		if err := db.checkRec(index.k(batch.data), index.v(batch.data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Write apply the given batch to the DB. The batch records will be applied
 // sequentially. Write might be used concurrently, when used concurrently and
 // batch is small enough, write will try to merge the batches. Set NoWriteMerge
 // option to true to disable write merge.
 //
+// Write fails with errors.ErrTooLarge, applying none of the batch, if a
+// record exceeds Options.MaxKeySize or MaxValueSize.
+//
 // It is safe to modify the contents of the arguments after Write returns but
 // not before. Write will not modify content of the batch.
-func (db *DB) Write(batch *Batch, wo *opt.WriteOptions) error {
+func (db *DB) Write(batch *Batch, wo *opt.WriteOptions) (err error) {
 	if err := db.ok(); err != nil || batch == nil || batch.Len() == 0 {
 		return err
 	}
+	if err := db.checkBatch(batch); err != nil {
+		return err
+	}
+	if t := db.tracing(); t != nil {
+		t.write(batch, wo)
+	}
+	if span := db.startSpan("leveldb.Write"); span != nil {
+		span.SetAttribute("batch.records", int64(batch.Len()))
+		span.SetAttribute("batch.bytes", int64(len(batch.data)))
+		defer func() { span.End(err) }()
+	}
+	if l := db.latency; l != nil {
+		defer l.writeHistogram(wo.GetSync() && !db.s.o.GetNoSync()).since(time.Now())
+	}
+	if threshold := db.s.o.GetSlowOperationThreshold(); threshold > 0 {
+		start := time.Now()
+		defer func() { db.logSlowWrite(start, threshold, batch.Len(), len(batch.data), wo, err) }()
+	}
 
 	// If the batch size is larger than write buffer, it may justified to write
 	// using transaction instead. Using transaction the batch will be written
@@ -320,10 +513,35 @@ func (db *DB) Write(batch *Batch, wo *opt.WriteOptions) error {
 	return db.writeLocked(batch, nil, merge, sync)
 }
 
-func (db *DB) putRec(kt keyType, key, value []byte, wo *opt.WriteOptions) error {
+// Logs a write exceeding the slow operation threshold.
+func (db *DB) logSlowWrite(start time.Time, threshold time.Duration, records, size int, wo *opt.WriteOptions, err error) {
+	if d := time.Since(start); d >= threshold {
+		db.logWarn("db@write slow", "duration", d, "records", records, "size", size, "sync", wo.GetSync() && !db.s.o.GetNoSync(), "err", err)
+	}
+}
+
+func (db *DB) putRec(kt keyType, key, value []byte, wo *opt.WriteOptions) (err error) {
 	if err := db.ok(); err != nil {
 		return err
 	}
+	if err := db.checkRec(key, value); err != nil {
+		return err
+	}
+	if t := db.tracing(); t != nil {
+		t.rec(kt, key, value, wo)
+	}
+	if span := db.startSpan("leveldb.Write"); span != nil {
+		span.SetAttribute("batch.records", 1)
+		span.SetAttribute("batch.bytes", int64(len(key)+len(value)))
+		defer func() { span.End(err) }()
+	}
+	if l := db.latency; l != nil {
+		defer l.writeHistogram(wo.GetSync() && !db.s.o.GetNoSync()).since(time.Now())
+	}
+	if threshold := db.s.o.GetSlowOperationThreshold(); threshold > 0 {
+		start := time.Now()
+		defer func() { db.logSlowWrite(start, threshold, 1, len(key)+len(value), wo, err) }()
+	}
 
 	merge := !wo.GetNoWriteMerge() && !db.s.o.GetNoWriteMerge()
 	sync := wo.GetSync() && !db.s.o.GetNoSync()
@@ -384,7 +602,53 @@ func (db *DB) Delete(key []byte, wo *opt.WriteOptions) error {
 	return db.putRec(keyTypeDel, key, nil, wo)
 }
 
-func isMemOverlaps(icmp *iComparer, mem *memdb.DB, min, max []byte) bool {
+// WriteLarge applies the given batch to the DB in chunks of about
+// Options.WriteChunkSize, in order, rather than at once as Write does.
+//
+// It returns n, the number of leading records of the batch that are
+// committed, also when it returns an error: records 0 to n-1 are applied,
+// and the others are not.
+//
+// Each chunk is committed atomically, but the batch as a whole is not:
+// concurrent reads may see a part of the batch applied, and a crash may
+// keep only the first chunks. Unlike a large batch given to Write, which
+// goes through a transaction, or holds the write lock until all of it is
+// journaled, the commits let concurrent writes through, and are journaled
+// with the memory of a chunk. Use Write, or OpenTransaction, where the
+// whole batch must be atomic.
+//
+// It is safe to modify the contents of the arguments after WriteLarge
+// returns but not before.
+func (db *DB) WriteLarge(batch *Batch, wo *opt.WriteOptions) (n int, err error) {
+	if err := db.ok(); err != nil || batch == nil {
+		return 0, err
+	}
+	if err := db.checkBatch(batch); err != nil {
+		return 0, err
+	}
+	size := db.s.o.GetWriteChunkSize()
+	chunk := new(Batch)
+	err = batch.replayInternal(func(i int, kt keyType, k, v []byte) error {
+		if chunk.Len() > 0 && chunk.internalLen+len(k)+len(v)+8 > size {
+			if err := db.Write(chunk, wo); err != nil {
+				return err
+			}
+			n += chunk.Len()
+			chunk.Reset()
+		}
+		chunk.appendRec(kt, k, v)
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	if err := db.Write(chunk, wo); err != nil {
+		return n, err
+	}
+	return n + chunk.Len(), nil
+}
+
+func isMemOverlaps(icmp *iComparer, mem memdb.Memtable, min, max []byte) bool {
 	iter := mem.NewIterator(nil)
 	defer iter.Release()
 	return (max == nil || (iter.First() && icmp.uCompare(max, internalKey(iter.Key()).ukey()) >= 0)) &&
@@ -400,10 +664,17 @@ func isMemOverlaps(icmp *iComparer, mem *memdb.DB, min, max []byte) bool {
 // A nil Range.Start is treated as a key before all keys in the DB.
 // And a nil Range.Limit is treated as a key after all keys in the DB.
 // Therefore if both is nil then it will compact entire DB.
-func (db *DB) CompactRange(r util.Range) error {
+func (db *DB) CompactRange(r util.Range) (err error) {
 	if err := db.ok(); err != nil {
 		return err
 	}
+	if t := db.tracing(); t != nil {
+		t.compact(r)
+	}
+	start := time.Now()
+	defer func() {
+		db.s.recordAdmin(AdminCompactRange, err, "start", r.Start, "limit", r.Limit, "duration", time.Since(start))
+	}()
 
 	// Lock writer.
 	select {
@@ -420,7 +691,7 @@ func (db *DB) CompactRange(r util.Range) error {
 		return ErrClosed
 	}
 	defer mdb.decref()
-	if isMemOverlaps(db.s.icmp, mdb.DB, r.Start, r.Limit) {
+	if isMemOverlaps(db.s.icmp, mdb.Memtable, r.Start, r.Limit) {
 		// Memdb compaction.
 		if _, err := db.rotateMem(0, false); err != nil {
 			<-db.writeLockC
@@ -439,7 +710,17 @@ func (db *DB) CompactRange(r util.Range) error {
 }
 
 // SetReadOnly makes DB read-only. It will stay read-only until reopened.
-func (db *DB) SetReadOnly() error {
+func (db *DB) SetReadOnly() (err error) {
+	if err := db.ok(); err != nil {
+		return err
+	}
+	defer func() {
+		db.s.recordAdmin(AdminSetReadOnly, err)
+	}()
+	return db.setReadOnly()
+}
+
+func (db *DB) setReadOnly() error {
 	if err := db.ok(); err != nil {
 		return err
 	}
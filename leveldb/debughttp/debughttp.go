@@ -0,0 +1,191 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package debughttp provides an http.Handler rendering the live state of a
+// DB as plain text, for on-call debugging, in the spirit of net/http/pprof.
+// It shows the options, the LSM tree shape, the running flushes and
+// compactions, the write stall state, the caches statistics and the recent
+// events:
+//
+//	http.Handle("/debug/leveldb", debughttp.NewHandler(db))
+//
+// The page may expose keys, through table ranges and events, so it should
+// only be served to trusted clients.
+package debughttp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// Handler renders the state of a DB. It's safe for concurrent use.
+type Handler struct {
+	db *leveldb.DB
+}
+
+// NewHandler returns a Handler for the given DB.
+func NewHandler(db *leveldb.DB) *Handler {
+	return &Handler{db: db}
+}
+
+// ServeHTTP renders the state of the DB as plain text.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if _, err := h.WriteTo(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// WriteTo writes the state of the DB to w as plain text.
+func (h *Handler) WriteTo(w io.Writer) (int64, error) {
+	pw := &pageWriter{w: bufio.NewWriter(w)}
+	now := time.Now()
+
+	var stats leveldb.DBStats
+	if err := h.db.Stats(&stats); err != nil {
+		pw.printf("DB unavailable: %v\n", err)
+		return pw.flush()
+	}
+
+	pw.section("Options")
+	writeOptions(pw, h.db.Options())
+
+	pw.section("LSM shape")
+	pw.property(h.db, "leveldb.lsm-shape")
+
+	pw.section("Running jobs")
+	jobs := h.db.RunningJobs()
+	if len(jobs) == 0 {
+		pw.printf("none\n")
+	}
+	for _, job := range jobs {
+		elapsed := now.Sub(job.Started).Truncate(time.Millisecond)
+		if job.Flush {
+			pw.printf("flush       running %v, entries %d, size %d\n", elapsed, job.FlushInfo.Entries, job.FlushInfo.Size)
+			continue
+		}
+		ci := job.CompactionInfo
+		pw.printf("compaction  running %v, reason %s, level %d -> %d, %d tables, %d bytes\n",
+			elapsed, ci.Reason, ci.SourceLevel, ci.SourceLevel+1, len(ci.Inputs), ci.InputBytes)
+	}
+
+	pw.section("Write stall")
+	pw.printf("paused %t, delays %d, total delay %v\n", stats.WritePaused, stats.WriteDelayCount, stats.WriteDelayDuration)
+
+	pw.section("Caches")
+	pw.printf("block cache:  ")
+	pw.property(h.db, "leveldb.blockcachestats")
+	pw.printf("file cache:   ")
+	pw.property(h.db, "leveldb.filecachestats")
+
+	pw.section("Compactions")
+	pw.property(h.db, "leveldb.stats")
+
+	pw.section("Recent events")
+	events := h.db.RecentEvents()
+	if len(events) == 0 {
+		pw.printf("none\n")
+	}
+	for _, e := range events {
+		pw.printf("%s %-5s %s", e.Time.Format("2006-01-02T15:04:05.000"), strings.ToUpper(e.Level.String()), e.Message)
+		for i := 0; i+1 < len(e.KeysAndValues); i += 2 {
+			pw.printf(" %v=%v", e.KeysAndValues[i], formatValue(e.KeysAndValues[i+1]))
+		}
+		pw.printf("\n")
+	}
+	return pw.flush()
+}
+
+// Writes the options set to a non-zero value, the others having their
+// default value.
+func writeOptions(pw *pageWriter, o *opt.Options) {
+	v := reflect.ValueOf(o).Elem()
+	var set int
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		if f.IsZero() {
+			continue
+		}
+		set++
+		name := v.Type().Field(i).Name
+		switch f.Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Func:
+			if n, ok := f.Interface().(interface{ Name() string }); ok {
+				pw.printf("%s: %s\n", name, n.Name())
+			} else {
+				pw.printf("%s: %T\n", name, f.Interface())
+			}
+		default:
+			pw.printf("%s: %v\n", name, f.Interface())
+		}
+	}
+	if set == 0 {
+		pw.printf("all default\n")
+	} else {
+		pw.printf("(others default)\n")
+	}
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case error:
+		return fmt.Sprintf("%q", v.Error())
+	case []byte:
+		return fmt.Sprintf("%q", v)
+	case string:
+		return fmt.Sprintf("%q", v)
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(v)
+}
+
+// pageWriter writes the page, keeping the first error.
+type pageWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (pw *pageWriter) printf(format string, a ...interface{}) {
+	if pw.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(pw.w, format, a...)
+	pw.n += int64(n)
+	pw.err = err
+}
+
+func (pw *pageWriter) section(title string) {
+	if pw.n > 0 {
+		pw.printf("\n")
+	}
+	pw.printf("== %s ==\n", title)
+}
+
+func (pw *pageWriter) property(db *leveldb.DB, name string) {
+	value, err := db.GetProperty(name)
+	if err != nil {
+		pw.printf("%s: %v\n", name, err)
+		return
+	}
+	pw.printf("%s\n", strings.TrimRight(value, "\n"))
+}
+
+func (pw *pageWriter) flush() (int64, error) {
+	if pw.err == nil {
+		pw.err = pw.w.Flush()
+	}
+	return pw.n, pw.err
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package debughttp
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+func TestHandler(t *testing.T) {
+	db, err := leveldb.Open(storage.NewMemStorage(), &opt.Options{WriteBuffer: 64 * 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err := db.Put([]byte(key), []byte("v"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.CompactRange(util.Range{}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	NewHandler(db).ServeHTTP(rec, httptest.NewRequest("GET", "/debug/leveldb", nil))
+	if rec.Code != 200 {
+		t.Fatalf("got status %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("got content type %q", ct)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	page := string(body)
+	for _, want := range []string{
+		"== Options ==\n",
+		"\nWriteBuffer: 65536\n",
+		"== LSM shape ==\n Level | Tables |",
+		"== Running jobs ==\n",
+		"== Write stall ==\npaused false, delays 0, total delay 0s\n",
+		"== Caches ==\nblock cache:  Hits:",
+		"file cache:   Hits:",
+		"== Compactions ==\n",
+		"== Recent events ==\n",
+		" INFO  memdb@flush ",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("missing %q in:\n%s", want, page)
+		}
+	}
+
+	db.Close()
+	rec = httptest.NewRecorder()
+	NewHandler(db).ServeHTTP(rec, httptest.NewRequest("GET", "/debug/leveldb", nil))
+	if body := rec.Body.String(); !strings.HasPrefix(body, "DB unavailable: ") {
+		t.Fatalf("got %q for a closed DB", body)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"io"
+)
+
+// Encryption is an encryption setting like EncryptionVersion and
+// EncryptionKey, independent of them, e.g. for the backups of a DB handled
+// by another trust domain than the DB, see BackupOptions.
+type Encryption struct {
+	Version int // 0 NONE, 1 XOR, 2 AES
+	Key     []byte
+}
+
+func (e *Encryption) cipher() iCipher {
+	return newCipherVersion(e.Version, e.Key)
+}
+
+// Checks for an unknown version, or a missing key.
+func (e *Encryption) validate() error {
+	switch {
+	case e.Version < 0 || e.Version > 2:
+		return fmt.Errorf("unknown version %d", e.Version)
+	case e.Version != 0 && len(e.Key) == 0:
+		return fmt.Errorf("version %d without key", e.Version)
+	}
+	return nil
+}
+
+// Returns a short digest of the encryption, telling whether two are the
+// same without revealing the key.
+func (e *Encryption) fingerprint() []byte {
+	var key []byte
+	if e.Version != 0 {
+		key = e.Key
+	}
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "leveldb.Encryption.%d", e.Version)
+	return mac.Sum(nil)[:8]
+}
+
+// Returns the encryption of the DB files.
+func dbEncryption() *Encryption {
+	return &Encryption{Version: EncryptionVersion, Key: EncryptionKey}
+}
+
+// recryptWriter decrypts the data written to it from an encryption, and
+// encrypts it to another, either cipher being nil for no encryption.
+type recryptWriter struct {
+	w        io.Writer
+	dec, enc iCipher
+	offset   int64
+}
+
+func (w *recryptWriter) Write(p []byte) (int, error) {
+	data := p
+	if w.dec != nil {
+		data = w.dec.DecryptAt(data, w.offset)
+	}
+	if w.enc != nil {
+		data = w.enc.EncryptAt(data, w.offset)
+	}
+	n, err := w.w.Write(data)
+	w.offset += int64(n)
+	return n, err
+}
+
+// Returns a writer to w re-encrypting the data from an encryption to
+// another, or w if they're the same.
+func newRecryptWriter(w io.Writer, from, to *Encryption) io.Writer {
+	dec, enc := from.cipher(), to.cipher()
+	if dec == nil && enc == nil || from.Version == to.Version && string(from.Key) == string(to.Key) {
+		return w
+	}
+	return &recryptWriter{w: w, dec: dec, enc: enc}
+}
+
+// NewEncryptWriter returns a writer encrypting the data written to w as
+// the files of a DB are, e.g. for an export of the DB, see DB.Export.
+func NewEncryptWriter(w io.Writer, e Encryption) io.Writer {
+	return newRecryptWriter(w, &Encryption{}, &e)
+}
+
+type decryptReader struct {
+	r      io.Reader
+	c      iCipher
+	offset int64
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	copy(p, r.c.DecryptAt(p[:n], r.offset))
+	r.offset += int64(n)
+	return n, err
+}
+
+// NewDecryptReader returns a reader decrypting the data read from r, e.g.
+// written by NewEncryptWriter.
+func NewDecryptReader(r io.Reader, e Encryption) io.Reader {
+	c := e.cipher()
+	if c == nil {
+		return r
+	}
+	return &decryptReader{r: r, c: c}
+}
+
+type decryptReaderAt struct {
+	r io.ReaderAt
+	c iCipher
+}
+
+func (r decryptReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.r.ReadAt(p, off)
+	copy(p, r.c.DecryptAt(p[:n], off))
+	return n, err
+}
+
+// NewDecryptReaderAt returns a reader decrypting the data read from r, e.g.
+// an encrypted export to ingest, see DB.IngestRocksDB.
+func NewDecryptReaderAt(r io.ReaderAt, e Encryption) io.ReaderAt {
+	c := e.cipher()
+	if c == nil {
+		return r
+	}
+	return decryptReaderAt{r: r, c: c}
+}
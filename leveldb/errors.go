@@ -10,11 +10,12 @@ import (
 	"github.com/syndtr/goleveldb/leveldb/errors"
 )
 
-// Common errors.
+// Common errors, see package errors for testing the errors returned.
 var (
 	ErrNotFound         = errors.ErrNotFound
-	ErrReadOnly         = errors.New("leveldb: read-only mode")
-	ErrSnapshotReleased = errors.New("leveldb: snapshot released")
-	ErrIterReleased     = errors.New("leveldb: iterator released")
-	ErrClosed           = errors.New("leveldb: closed")
+	ErrReadOnly         = errors.ErrReadOnly
+	ErrSnapshotReleased = errors.ErrSnapshotReleased
+	ErrIterReleased     = errors.ErrIterReleased
+	ErrClosed           = errors.ErrClosed
+	ErrEncryption       = errors.ErrEncryption
 )
@@ -5,11 +5,20 @@
 // found in the LICENSE file.
 
 // Package errors provides common error types used throughout leveldb.
+//
+// The errors returned by leveldb are either the sentinel errors below, or
+// wrap them or the error types below, to be tested with Is and As, e.g.:
+//
+//	var cerr *errors.ErrCorrupted
+//	if errors.As(err, &cerr) {
+//		log.Printf("corrupted %v at offset %d", cerr.Fd, cerr.Offset)
+//	}
 package errors
 
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/syndtr/goleveldb/leveldb/storage"
 	"github.com/syndtr/goleveldb/leveldb/util"
@@ -17,9 +26,14 @@ import (
 
 // Common errors.
 var (
-	ErrNotFound    = New("leveldb: not found")
-	ErrReleased    = util.ErrReleased
-	ErrHasReleaser = util.ErrHasReleaser
+	ErrNotFound         = New("leveldb: not found")
+	ErrReadOnly         = New("leveldb: read-only mode")
+	ErrSnapshotReleased = New("leveldb: snapshot released")
+	ErrIterReleased     = New("leveldb: iterator released")
+	ErrClosed           = New("leveldb: closed")
+	ErrEncryption       = New("leveldb: invalid encryption")
+	ErrReleased         = util.ErrReleased
+	ErrHasReleaser      = util.ErrHasReleaser
 )
 
 // New returns an error that formats as the given text.
@@ -27,35 +41,65 @@ func New(text string) error {
 	return errors.New(text)
 }
 
+// Is reports whether any error in err's chain matches target, see the
+// standard errors package.
+func Is(err, target error) bool {
+	return errors.Is(err, target)
+}
+
+// As finds the first error in err's chain that matches target, and if one
+// is found, sets target to that error value and returns true, see the
+// standard errors package.
+func As(err error, target interface{}) bool {
+	return errors.As(err, target)
+}
+
 // ErrCorrupted is the type that wraps errors that indicate corruption in
 // the database.
+//
+// Use NewErrCorrupted or NewErrCorruptedAt to create one: since the Offset
+// field was added, unkeyed literals no longer compile, and keyed literals
+// omitting it report offset 0 rather than an unknown offset.
 type ErrCorrupted struct {
 	Fd  storage.FileDesc
 	Err error
+
+	// Offset is the offset of the corrupted data in the file, or -1 if
+	// unknown.
+	Offset int64
 }
 
 func (e *ErrCorrupted) Error() string {
-	if !e.Fd.Zero() {
+	switch {
+	case e.Fd.Zero():
+		return e.Err.Error()
+	case e.Offset >= 0:
+		return fmt.Sprintf("%v [file=%v offset=%d]", e.Err, e.Fd, e.Offset)
+	default:
 		return fmt.Sprintf("%v [file=%v]", e.Err, e.Fd)
 	}
-	return e.Err.Error()
 }
 
-// NewErrCorrupted creates new ErrCorrupted error.
+// Unwrap returns the underlying error.
+func (e *ErrCorrupted) Unwrap() error { return e.Err }
+
+// NewErrCorrupted creates new ErrCorrupted error, at an unknown offset.
 func NewErrCorrupted(fd storage.FileDesc, err error) error {
-	return &ErrCorrupted{fd, err}
+	return &ErrCorrupted{Fd: fd, Err: err, Offset: -1}
+}
+
+// NewErrCorruptedAt creates new ErrCorrupted error, at the given offset in
+// the file.
+func NewErrCorruptedAt(fd storage.FileDesc, offset int64, err error) error {
+	return &ErrCorrupted{Fd: fd, Err: err, Offset: offset}
 }
 
 // IsCorrupted returns a boolean indicating whether the error is indicating
-// a corruption.
+// a corruption, or wraps one.
 func IsCorrupted(err error) bool {
-	switch err.(type) {
-	case *ErrCorrupted:
-		return true
-	case *storage.ErrCorrupted:
-		return true
-	}
-	return false
+	var cerr *ErrCorrupted
+	var scerr *storage.ErrCorrupted
+	return errors.As(err, &cerr) || errors.As(err, &scerr)
 }
 
 // ErrMissingFiles is the type that indicating a corruption due to missing
@@ -66,14 +110,65 @@ type ErrMissingFiles struct {
 
 func (e *ErrMissingFiles) Error() string { return "file missing" }
 
+// ErrTooLarge is the type of the error of a write of a key or value larger
+// than allowed, see opt.Options.MaxKeySize and MaxValueSize.
+type ErrTooLarge struct {
+	// What is "key" or "value".
+	What  string
+	Size  int
+	Limit int
+}
+
+func (e *ErrTooLarge) Error() string {
+	return fmt.Sprintf("leveldb: %s too large: %d bytes, limit %d", e.What, e.Size, e.Limit)
+}
+
+// ErrSnapshotExpired is the type of the error of the use of a snapshot
+// released by the DB for being held longer than allowed, see
+// opt.Options.MaxSnapshotLifetime. It matches ErrSnapshotReleased.
+type ErrSnapshotExpired struct {
+	Seq      uint64
+	Lifetime time.Duration
+}
+
+func (e *ErrSnapshotExpired) Error() string {
+	return fmt.Sprintf("leveldb: snapshot %d expired after %v", e.Seq, e.Lifetime)
+}
+
+// Is tells whether target is ErrSnapshotReleased.
+func (e *ErrSnapshotExpired) Is(target error) bool { return target == ErrSnapshotReleased }
+
+// OpError is the type that wraps the errors of the storage, with the
+// operation and the file it failed on.
+type OpError struct {
+	// Op is the operation, e.g. "open" or "create".
+	Op  string
+	Fd  storage.FileDesc
+	Err error
+}
+
+func (e *OpError) Error() string {
+	return fmt.Sprintf("leveldb: %s %v: %v", e.Op, e.Fd, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *OpError) Unwrap() error { return e.Err }
+
+// NewOpError creates new OpError error, nil if err is nil.
+func NewOpError(op string, fd storage.FileDesc, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &OpError{Op: op, Fd: fd, Err: err}
+}
+
 // SetFd sets 'file info' of the given error with the given file.
-// Currently only ErrCorrupted is supported, otherwise will do nothing.
+// Currently only ErrCorrupted is supported, also wrapped, otherwise will do
+// nothing.
 func SetFd(err error, fd storage.FileDesc) error {
-	switch x := err.(type) {
-	case *ErrCorrupted:
-		x.Fd = fd
-		return x
-	default:
-		return err
+	var cerr *ErrCorrupted
+	if errors.As(err, &cerr) {
+		cerr.Fd = fd
 	}
+	return err
 }
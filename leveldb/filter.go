@@ -18,6 +18,23 @@ func (f iFilter) Contains(filter, key []byte) bool {
 	return f.Filter.Contains(filter, internalKey(key).ukey())
 }
 
+func (f iFilter) ContainsBatch(data []byte, keys [][]byte, result []bool) {
+	ukeys := make([][]byte, len(keys))
+	for i, key := range keys {
+		ukeys[i] = internalKey(key).ukey()
+	}
+	filter.ContainsBatch(f.Filter, data, ukeys, result)
+}
+
+// ContainsPrefix returns true if the filter contains a key with the given
+// user key prefix, or if the filter doesn't hold prefixes.
+func (f iFilter) ContainsPrefix(data, prefix []byte) bool {
+	if pf, ok := f.Filter.(filter.PrefixFilter); ok {
+		return pf.ContainsPrefix(data, prefix)
+	}
+	return true
+}
+
 func (f iFilter) NewGenerator() filter.FilterGenerator {
 	return iFilterGenerator{f.Filter.NewGenerator()}
 }
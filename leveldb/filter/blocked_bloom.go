@@ -0,0 +1,156 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package filter
+
+import (
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	// Each key's probes fall in a single cache line of that many bits.
+	blockedBloomLineBits = 512
+	blockedBloomLineLen  = blockedBloomLineBits / 8
+)
+
+// The upper half selects the cache line, the lower half the bits within it,
+// both from a single hash of the key.
+func blockedBloomHash(key []byte) uint64 {
+	return util.XXHash64(key)
+}
+
+// Probes the k bits of hash h in the cache lines of data.
+func blockedBloomProbe(data []byte, k uint8, h uint64) bool {
+	nLines := uint64(len(data) / blockedBloomLineLen)
+	line := data[(((h>>32)*nLines)>>32)*blockedBloomLineLen:]
+	line = line[:blockedBloomLineLen]
+	h2 := uint32(h)
+	for j := uint8(0); j < k; j++ {
+		// The top 9 bits pick a bit of the line.
+		bitpos := h2 >> (32 - 9)
+		if line[bitpos/8]&(1<<(bitpos%8)) == 0 {
+			return false
+		}
+		h2 *= 0x9e3779b9
+	}
+	return true
+}
+
+type blockedBloomFilter int
+
+// Name: The blocked bloom filter serializes its parameters and is backward
+// compatible with respect to them. Therefor, its parameters are not added
+// to its name.
+func (blockedBloomFilter) Name() string {
+	return "leveldb.BlockedBloomFilter"
+}
+
+// Returns the cache lines and number of probes of the filter, or ok false
+// if the filter should be considered a match.
+func (blockedBloomFilter) decode(filter []byte) (data []byte, k uint8, ok bool) {
+	n := len(filter) - 1
+	if n < blockedBloomLineLen || n%blockedBloomLineLen != 0 {
+		return nil, 0, false
+	}
+	k = filter[n]
+	if k == 0 || k > 30 {
+		// Reserved for potentially new encodings.
+		return nil, 0, false
+	}
+	return filter[:n], k, true
+}
+
+func (f blockedBloomFilter) Contains(filter, key []byte) bool {
+	data, k, ok := f.decode(filter)
+	if !ok {
+		return len(filter) != 0
+	}
+	return blockedBloomProbe(data, k, blockedBloomHash(key))
+}
+
+func (f blockedBloomFilter) ContainsBatch(filter []byte, keys [][]byte, result []bool) {
+	data, k, ok := f.decode(filter)
+	if !ok {
+		for i := range keys {
+			result[i] = len(filter) != 0
+		}
+		return
+	}
+	// Hash every key before probing, so the probes don't wait on each
+	// other's hashing.
+	var buf [16]uint64
+	hashes := buf[:0]
+	if len(keys) > len(buf) {
+		hashes = make([]uint64, 0, len(keys))
+	}
+	for _, key := range keys {
+		hashes = append(hashes, blockedBloomHash(key))
+	}
+	for i, h := range hashes {
+		result[i] = blockedBloomProbe(data, k, h)
+	}
+}
+
+func (f blockedBloomFilter) NewGenerator() FilterGenerator {
+	// Probes within a cache line collide more often than probes over the
+	// whole filter, which one more probe makes up for.
+	k := uint8(f*69/100) + 1
+	if k < 1 {
+		k = 1
+	} else if k > 30 {
+		k = 30
+	}
+	return &blockedBloomFilterGenerator{
+		n: int(f),
+		k: k,
+	}
+}
+
+type blockedBloomFilterGenerator struct {
+	n int
+	k uint8
+
+	keyHashes []uint64
+}
+
+func (g *blockedBloomFilterGenerator) Add(key []byte) {
+	g.keyHashes = append(g.keyHashes, blockedBloomHash(key))
+}
+
+func (g *blockedBloomFilterGenerator) Generate(b Buffer) {
+	nLines := (len(g.keyHashes)*g.n + blockedBloomLineBits - 1) / blockedBloomLineBits
+	if nLines < 1 {
+		nLines = 1
+	}
+	dest := b.Alloc(nLines*blockedBloomLineLen + 1)
+	for i := range dest {
+		dest[i] = 0
+	}
+	dest[len(dest)-1] = g.k
+	for _, h := range g.keyHashes {
+		line := dest[(((h>>32)*uint64(nLines))>>32)*blockedBloomLineLen:]
+		h2 := uint32(h)
+		for j := uint8(0); j < g.k; j++ {
+			bitpos := h2 >> (32 - 9)
+			line[bitpos/8] |= 1 << (bitpos % 8)
+			h2 *= 0x9e3779b9
+		}
+	}
+
+	g.keyHashes = g.keyHashes[:0]
+}
+
+// NewBlockedBloomFilter creates a new initialized blocked bloom filter for
+// given bitsPerKey. Unlike the filter returned by NewBloomFilter, the
+// probes of a key all fall in a single 64 bytes cache line and no modulo is
+// computed per probe, which makes probing considerably faster for a slightly
+// higher false positive rate. Its encoding isn't compatible with the bloom
+// filter's, hence it has a different name.
+//
+// The filter implements BatchFilter.
+func NewBlockedBloomFilter(bitsPerKey int) Filter {
+	return blockedBloomFilter(bitsPerKey)
+}
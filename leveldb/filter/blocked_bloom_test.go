@@ -0,0 +1,130 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package filter
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func newBlockedBloomHarness(t *testing.T) *harness {
+	bloom := NewBlockedBloomFilter(10)
+	return &harness{
+		t:         t,
+		bloom:     bloom,
+		generator: bloom.NewGenerator(),
+	}
+}
+
+func TestBlockedBloomFilter_Empty(t *testing.T) {
+	h := newBlockedBloomHarness(t)
+	h.assert([]byte("hello"), false, false)
+	h.build()
+	h.assert([]byte("hello"), false, false)
+	h.assert([]byte("world"), false, false)
+}
+
+func TestBlockedBloomFilter_Small(t *testing.T) {
+	h := newBlockedBloomHarness(t)
+	h.add([]byte("hello"))
+	h.add([]byte("world"))
+	h.build()
+	h.assert([]byte("hello"), true, false)
+	h.assert([]byte("world"), true, false)
+	h.assert([]byte("x"), false, false)
+	h.assert([]byte("foo"), false, false)
+}
+
+func TestBlockedBloomFilter_VaryingLengths(t *testing.T) {
+	h := newBlockedBloomHarness(t)
+	var mediocre, good int
+	for n := 1; n < 10000; n = nextN(n) {
+		h.reset()
+		for i := 0; i < n; i++ {
+			h.addNum(uint32(i))
+		}
+		h.build()
+
+		got := h.filterLen()
+		want := (n*10/8 + blockedBloomLineLen) + 1
+		if got > want {
+			t.Errorf("filter len test failed, '%d' > '%d'", got, want)
+		}
+
+		for i := 0; i < n; i++ {
+			h.assertNum(uint32(i), true, false)
+		}
+
+		var rate float32
+		for i := 0; i < 10000; i++ {
+			if h.assertNum(uint32(i+1000000000), true, true) {
+				rate++
+			}
+		}
+		rate /= 10000
+		if rate > 0.03 {
+			t.Errorf("false positive rate is more than 3%%, got %v, at len %d", rate, n)
+		}
+		if rate > 0.0125 {
+			mediocre++
+		} else {
+			good++
+		}
+	}
+	t.Logf("false positive: good=%d mediocre=%d", good, mediocre)
+	if mediocre > good/5 {
+		t.Error("mediocre false positive rate is more than expected")
+	}
+}
+
+func TestFilter_ContainsBatch(t *testing.T) {
+	for name, f := range map[string]Filter{
+		"bloom":         NewBloomFilter(10),
+		"blocked-bloom": NewBlockedBloomFilter(10),
+		"ribbon":        NewRibbonFilter(7),
+		"prefix":        NewFixedPrefixFilter(NewBlockedBloomFilter(10), 2),
+	} {
+		h := &harness{t: t, bloom: f, generator: f.NewGenerator()}
+		for i := 0; i < 1000; i++ {
+			h.addNum(uint32(i))
+		}
+		h.build()
+
+		keys := make([][]byte, 100)
+		for i := range keys {
+			keys[i] = make([]byte, 4)
+			binary.LittleEndian.PutUint32(keys[i], uint32(i*20))
+		}
+		result := make([]bool, len(keys))
+		ContainsBatch(f, h.filter, keys, result)
+		for i, key := range keys {
+			if want := f.Contains(h.filter, key); result[i] != want {
+				t.Errorf("%s: ContainsBatch result for key %x is %v, want %v", name, key, result[i], want)
+			}
+		}
+	}
+}
+
+func BenchmarkFilter_Contains(b *testing.B) {
+	for name, f := range map[string]Filter{
+		"bloom":         NewBloomFilter(10),
+		"blocked-bloom": NewBlockedBloomFilter(10),
+	} {
+		h := &harness{bloom: f, generator: f.NewGenerator()}
+		for i := 0; i < 100000; i++ {
+			h.addNum(uint32(i))
+		}
+		h.build()
+		var key [4]byte
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				binary.LittleEndian.PutUint32(key[:], uint32(i))
+				f.Contains(h.filter, key[:])
+			}
+		})
+	}
+}
@@ -23,27 +23,31 @@ func (bloomFilter) Name() string {
 	return "leveldb.BuiltinBloomFilter"
 }
 
-func (f bloomFilter) Contains(filter, key []byte) bool {
+// Returns the bits and number of probes of the filter, or ok false if the
+// filter should be considered a match.
+func (bloomFilter) decode(filter []byte) (data []byte, k uint8, ok bool) {
 	nBytes := len(filter) - 1
 	if nBytes < 1 {
-		return false
+		return nil, 0, false
 	}
-	nBits := uint32(nBytes * 8)
 
 	// Use the encoded k so that we can read filters generated by
 	// bloom filters created using different parameters.
-	k := filter[nBytes]
+	k = filter[nBytes]
 	if k > 30 {
 		// Reserved for potentially new encodings for short bloom filters.
 		// Consider it a match.
-		return true
+		return nil, 0, false
 	}
+	return filter[:nBytes], k, true
+}
 
-	kh := bloomHash(key)
+func bloomProbe(data []byte, k uint8, kh uint32) bool {
+	nBits := uint32(len(data) * 8)
 	delta := (kh >> 17) | (kh << 15) // Rotate right 17 bits
 	for j := uint8(0); j < k; j++ {
 		bitpos := kh % nBits
-		if (uint32(filter[bitpos/8]) & (1 << (bitpos % 8))) == 0 {
+		if (uint32(data[bitpos/8]) & (1 << (bitpos % 8))) == 0 {
 			return false
 		}
 		kh += delta
@@ -51,6 +55,27 @@ func (f bloomFilter) Contains(filter, key []byte) bool {
 	return true
 }
 
+func (f bloomFilter) Contains(filter, key []byte) bool {
+	data, k, ok := f.decode(filter)
+	if !ok {
+		return len(filter) > 1
+	}
+	return bloomProbe(data, k, bloomHash(key))
+}
+
+func (f bloomFilter) ContainsBatch(filter []byte, keys [][]byte, result []bool) {
+	data, k, ok := f.decode(filter)
+	if !ok {
+		for i := range keys {
+			result[i] = len(filter) > 1
+		}
+		return
+	}
+	for i, key := range keys {
+		result[i] = bloomProbe(data, k, bloomHash(key))
+	}
+}
+
 func (f bloomFilter) NewGenerator() FilterGenerator {
 	// Round down to reduce probing cost a little bit.
 	k := uint8(f * 69 / 100) // 0.69 =~ ln(2)
@@ -58,3 +58,27 @@ type FilterGenerator interface {
 	// to Generate the filter generator maybe resetted, depends on implementation.
 	Generate(b Buffer)
 }
+
+// BatchFilter is a filter which can probe several keys at once, such as for
+// a multi-key lookup. Computing every hash before probing lets the memory
+// accesses of the probes overlap.
+type BatchFilter interface {
+	Filter
+
+	// ContainsBatch sets result[i] to whether the filter contains keys[i].
+	// The result must be at least as long as keys.
+	ContainsBatch(filter []byte, keys [][]byte, result []bool)
+}
+
+// ContainsBatch sets result[i] to whether the filter contains keys[i],
+// using f.ContainsBatch if f is a BatchFilter. The result must be at least
+// as long as keys.
+func ContainsBatch(f Filter, filter []byte, keys [][]byte, result []bool) {
+	if bf, ok := f.(BatchFilter); ok {
+		bf.ContainsBatch(filter, keys, result)
+		return
+	}
+	for i, key := range keys {
+		result[i] = f.Contains(filter, key)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package filter
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// Appended to prefixes to tell them apart from whole keys in the filter.
+// A key which happens to look like a tagged prefix only causes false
+// positives.
+const prefixTag = "\xff\x00prefix"
+
+// PrefixFilter is a filter which, in addition to whole keys, holds the
+// prefixes of its keys. This allows telling whether a key with a given
+// prefix may exist, which is used to skip tables during iteration over
+// all keys with a given prefix, as in util.BytesPrefix.
+type PrefixFilter interface {
+	Filter
+
+	// Prefix returns the prefix of the given key, which must be a leading
+	// part of the key, or nil if the key has no prefix.
+	Prefix(key []byte) []byte
+
+	// ContainsPrefix returns true if the filter contains a key with the
+	// given prefix.
+	ContainsPrefix(filter, prefix []byte) bool
+}
+
+type prefixFilter struct {
+	Filter
+	name   string
+	prefix func(key []byte) []byte
+}
+
+func (f *prefixFilter) Name() string {
+	return f.Filter.Name() + ".prefix." + f.name
+}
+
+func (f *prefixFilter) Prefix(key []byte) []byte {
+	p := f.prefix(key)
+	if len(p) == 0 || !bytes.HasPrefix(key, p) {
+		return nil
+	}
+	return p
+}
+
+func (f *prefixFilter) ContainsPrefix(filter, prefix []byte) bool {
+	key := make([]byte, 0, len(prefix)+len(prefixTag))
+	key = append(append(key, prefix...), prefixTag...)
+	return f.Filter.Contains(filter, key)
+}
+
+func (f *prefixFilter) ContainsBatch(filter []byte, keys [][]byte, result []bool) {
+	ContainsBatch(f.Filter, filter, keys, result)
+}
+
+func (f *prefixFilter) NewGenerator() FilterGenerator {
+	return &prefixFilterGenerator{
+		FilterGenerator: f.Filter.NewGenerator(),
+		f:               f,
+	}
+}
+
+type prefixFilterGenerator struct {
+	FilterGenerator
+	f *prefixFilter
+
+	// Keys are added in order, so prefixes are only added once.
+	last    []byte
+	hasLast bool
+	scratch []byte
+}
+
+func (g *prefixFilterGenerator) Add(key []byte) {
+	g.FilterGenerator.Add(key)
+	p := g.f.Prefix(key)
+	if p == nil || (g.hasLast && bytes.Equal(p, g.last)) {
+		return
+	}
+	g.last = append(g.last[:0], p...)
+	g.hasLast = true
+	g.scratch = append(append(g.scratch[:0], p...), prefixTag...)
+	g.FilterGenerator.Add(g.scratch)
+}
+
+func (g *prefixFilterGenerator) Generate(b Buffer) {
+	g.FilterGenerator.Generate(b)
+	g.hasLast = false
+}
+
+// NewPrefixFilter creates a new initialized prefix filter, which holds keys
+// and their prefixes in the given filter. The prefix function extracts the
+// prefix of a key, and the name identifies it; the name must be changed if
+// the prefix function changes, as it is part of the filter name stored on
+// disk.
+func NewPrefixFilter(f Filter, name string, prefix func(key []byte) []byte) PrefixFilter {
+	return &prefixFilter{
+		Filter: f,
+		name:   name,
+		prefix: prefix,
+	}
+}
+
+// NewFixedPrefixFilter creates a new initialized prefix filter whose key
+// prefixes are the first n bytes of each key. Keys shorter than n bytes
+// have no prefix.
+func NewFixedPrefixFilter(f Filter, n int) PrefixFilter {
+	return NewPrefixFilter(f, "fixed"+strconv.Itoa(n), func(key []byte) []byte {
+		if len(key) < n {
+			return nil
+		}
+		return key[:n]
+	})
+}
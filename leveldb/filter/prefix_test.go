@@ -0,0 +1,60 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package filter
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+func TestPrefixFilter(t *testing.T) {
+	f := NewFixedPrefixFilter(NewBloomFilter(10), 4)
+	if name := f.Name(); name != "leveldb.BuiltinBloomFilter.prefix.fixed4" {
+		t.Errorf("invalid name, got %q", name)
+	}
+
+	g := f.NewGenerator()
+	for i := 0; i < 100; i++ {
+		for j := 0; j < 10; j++ {
+			g.Add([]byte(fmt.Sprintf("%04d:%d", i*2, j)))
+		}
+	}
+	g.Add([]byte("abc"))
+	b := &util.Buffer{}
+	g.Generate(b)
+	data := b.Bytes()
+
+	for i := 0; i < 100; i++ {
+		if !f.ContainsPrefix(data, []byte(fmt.Sprintf("%04d", i*2))) {
+			t.Errorf("prefix %04d not found", i*2)
+		}
+		for j := 0; j < 10; j++ {
+			key := []byte(fmt.Sprintf("%04d:%d", i*2, j))
+			if !f.Contains(data, key) {
+				t.Errorf("key %q not found", key)
+			}
+		}
+	}
+	if !f.Contains(data, []byte("abc")) {
+		t.Error("key without prefix not found")
+	}
+	if p := f.Prefix([]byte("abc")); p != nil {
+		t.Errorf("short key has prefix %q", p)
+	}
+
+	var fp int
+	for i := 0; i < 1000; i++ {
+		if f.ContainsPrefix(data, []byte(fmt.Sprintf("x%03d", i))) {
+			fp++
+		}
+	}
+	if fp > 50 {
+		t.Errorf("too many false positive prefixes, got %d", fp)
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package filter
+
+import (
+	"encoding/binary"
+	"math/bits"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	// Number of columns each key's equation spans.
+	ribbonWidth = 64
+	// Trailer holds number of rows (4-bytes), seed and fingerprint bits.
+	ribbonTrailerLen = 6
+)
+
+func ribbonHash(key []byte) uint64 {
+	return uint64(util.Hash(key, 0xbc9f1d34))<<32 | uint64(util.Hash(key, 0x9e3779b9))
+}
+
+func ribbonMix(z uint64) uint64 {
+	z ^= z >> 30
+	z *= 0xbf58476d1ce4e5b9
+	z ^= z >> 27
+	z *= 0x94d049bb133111eb
+	z ^= z >> 31
+	return z
+}
+
+// Derives the equation of a key: the first row it spans, its coefficients
+// (bit 0 always set) and its fingerprint.
+func ribbonEquation(h uint64, seed uint8, m uint32, r uint) (start uint32, coeff uint64, fp uint32) {
+	a := ribbonMix(h + uint64(seed)*0x9e3779b97f4a7c15)
+	b := ribbonMix(a)
+	start = uint32((uint64(uint32(a>>32)) * uint64(m-ribbonWidth+1)) >> 32)
+	coeff = b | 1
+	fp = uint32(a) & (1<<r - 1)
+	return
+}
+
+// Returns ribbonWidth bits of the column starting at row i; rows past the
+// end of the column are zero.
+func ribbonWindow(col []byte, i uint32) uint64 {
+	o, sh := int(i/8), i%8
+	var x uint64
+	if o+8 <= len(col) {
+		x = binary.LittleEndian.Uint64(col[o:])
+	} else {
+		for j := len(col) - 1; j >= o; j-- {
+			x = x<<8 | uint64(col[j])
+		}
+	}
+	x >>= sh
+	if sh > 0 && o+8 < len(col) {
+		x |= uint64(col[o+8]) << (64 - sh)
+	}
+	return x
+}
+
+type ribbonFilter int
+
+// Name: The ribbon filter serializes its parameters and is backward
+// compatible with respect to them. Therefor, its parameters are not added
+// to its name.
+func (ribbonFilter) Name() string {
+	return "leveldb.RibbonFilter"
+}
+
+func (f ribbonFilter) Contains(filter, key []byte) bool {
+	n := len(filter) - ribbonTrailerLen
+	if n < 0 {
+		return false
+	}
+	m := binary.LittleEndian.Uint32(filter[n:])
+	seed, r := filter[n+4], uint(filter[n+5])
+	if m == 0 {
+		// Empty set.
+		return false
+	}
+	colLen := int(m+7) / 8
+	if r < 1 || r > 32 || m < ribbonWidth || n != int(r)*colLen {
+		// Reserved for potentially new encodings, or failed construction.
+		// Consider it a match.
+		return true
+	}
+
+	start, coeff, fp := ribbonEquation(ribbonHash(key), seed, m, r)
+	for b := uint(0); b < r; b++ {
+		col := filter[int(b)*colLen : int(b+1)*colLen]
+		if uint32(bits.OnesCount64(ribbonWindow(col, start)&coeff)&1) != (fp>>b)&1 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f ribbonFilter) NewGenerator() FilterGenerator {
+	r := int(f)
+	if r < 1 {
+		r = 1
+	} else if r > 32 {
+		r = 32
+	}
+	return &ribbonFilterGenerator{r: uint(r)}
+}
+
+type ribbonFilterGenerator struct {
+	r uint
+
+	keyHashes []uint64
+	coeffs    []uint64
+	results   []uint32
+}
+
+func (g *ribbonFilterGenerator) Add(key []byte) {
+	g.keyHashes = append(g.keyHashes, ribbonHash(key))
+}
+
+// Gaussian elimination of the key equations into m rows, which fails if
+// equations are inconsistent.
+func (g *ribbonFilterGenerator) band(m uint32, seed uint8) bool {
+	if cap(g.coeffs) < int(m) {
+		g.coeffs = make([]uint64, m)
+		g.results = make([]uint32, m)
+	} else {
+		g.coeffs = g.coeffs[:m]
+		g.results = g.results[:m]
+		for i := range g.coeffs {
+			g.coeffs[i] = 0
+			g.results[i] = 0
+		}
+	}
+	for _, h := range g.keyHashes {
+		i, c, fp := ribbonEquation(h, seed, m, g.r)
+		for {
+			if g.coeffs[i] == 0 {
+				g.coeffs[i] = c
+				g.results[i] = fp
+				break
+			}
+			c ^= g.coeffs[i]
+			fp ^= g.results[i]
+			if c == 0 {
+				if fp != 0 {
+					return false
+				}
+				// Duplicate key.
+				break
+			}
+			tz := bits.TrailingZeros64(c)
+			i += uint32(tz)
+			c >>= uint(tz)
+		}
+	}
+	return true
+}
+
+func (g *ribbonFilterGenerator) Generate(b Buffer) {
+	defer func() {
+		g.keyHashes = g.keyHashes[:0]
+	}()
+
+	n := len(g.keyHashes)
+	if n == 0 {
+		trailer := b.Alloc(ribbonTrailerLen)
+		for i := range trailer {
+			trailer[i] = 0
+		}
+		return
+	}
+
+	// Each failed attempt retries with a new seed and more rows.
+	m := uint32(n + n/16 + 8)
+	if m < ribbonWidth {
+		m = ribbonWidth
+	}
+	var seed uint8
+	for !g.band(m, seed) {
+		if seed == 255 {
+			// Give up, encode an always matching filter.
+			trailer := b.Alloc(ribbonTrailerLen)
+			binary.LittleEndian.PutUint32(trailer, m)
+			trailer[4], trailer[5] = 0, 0
+			return
+		}
+		seed++
+		m += m / 32
+	}
+
+	// Back substitution, solving from the last row. Free rows are zero.
+	colLen := int(m+7) / 8
+	data := b.Alloc(int(g.r)*colLen + ribbonTrailerLen)
+	for i := range data {
+		data[i] = 0
+	}
+	for i := int(m) - 1; i >= 0; i-- {
+		c := g.coeffs[i]
+		if c == 0 {
+			continue
+		}
+		res := g.results[i]
+		for bit := uint(0); bit < g.r; bit++ {
+			col := data[int(bit)*colLen : int(bit+1)*colLen]
+			s := (res >> bit) & 1
+			s ^= uint32(bits.OnesCount64(ribbonWindow(col, uint32(i))&c) & 1)
+			if s != 0 {
+				col[i/8] |= 1 << (uint(i) % 8)
+			}
+		}
+	}
+	trailer := data[int(g.r)*colLen:]
+	binary.LittleEndian.PutUint32(trailer, m)
+	trailer[4], trailer[5] = seed, byte(g.r)
+}
+
+// NewRibbonFilter creates a new initialized ribbon filter for given
+// fingerprintBits, which may range from 1 to 32.
+//
+// A ribbon filter has false positive rate of about 2^-fingerprintBits and
+// takes about 1.1*fingerprintBits bits per key, which is about 30% smaller
+// than a bloom filter with the same false positive rate; for example,
+// NewRibbonFilter(7) has lower false positive rate than NewBloomFilter(10)
+// while being about 25% smaller. This comes at the cost of slower filter
+// generation, and of at least 64*fingerprintBits bits per filter, so it is
+// best used along with opt.Options.FullTableFilter or
+// opt.Options.IndexPartitionSize.
+//
+// Since fingerprintBits is persisted individually for each ribbon filter
+// serialization, ribbon filters are backwards compatible with respect to
+// changing fingerprintBits.
+func NewRibbonFilter(fingerprintBits int) Filter {
+	return ribbonFilter(fingerprintBits)
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package filter
+
+import (
+	"testing"
+)
+
+func newRibbonHarness(t *testing.T, fingerprintBits int) *harness {
+	ribbon := NewRibbonFilter(fingerprintBits)
+	return &harness{
+		t:         t,
+		bloom:     ribbon,
+		generator: ribbon.NewGenerator(),
+	}
+}
+
+func TestRibbonFilter_Empty(t *testing.T) {
+	h := newRibbonHarness(t, 7)
+	h.build()
+	h.assert([]byte("hello"), false, false)
+	h.assert([]byte("world"), false, false)
+}
+
+func TestRibbonFilter_Small(t *testing.T) {
+	h := newRibbonHarness(t, 7)
+	h.add([]byte("hello"))
+	h.add([]byte("world"))
+	h.add([]byte("hello"))
+	h.build()
+	h.assert([]byte("hello"), true, false)
+	h.assert([]byte("world"), true, false)
+}
+
+func TestRibbonFilter_VaryingLengths(t *testing.T) {
+	h := newRibbonHarness(t, 7)
+	for n := 1; n < 10000; n = nextN(n) {
+		h.reset()
+		for i := 0; i < n; i++ {
+			h.addNum(uint32(i))
+		}
+		h.build()
+
+		// Allow for retries with more rows.
+		got := h.filterLen()
+		rows := n + n/8 + 16
+		if rows < 64 {
+			rows = 64
+		}
+		want := (rows*7+7)/8 + ribbonTrailerLen
+		if got > want {
+			t.Errorf("filter len test failed, '%d' > '%d', at len %d", got, want, n)
+		}
+
+		for i := 0; i < n; i++ {
+			h.assertNum(uint32(i), true, false)
+		}
+
+		var rate float32
+		for i := 0; i < 10000; i++ {
+			if h.assertNum(uint32(i+1000000000), true, true) {
+				rate++
+			}
+		}
+		rate /= 10000
+		if rate > 0.02 {
+			t.Errorf("false positive rate is more than 2%%, got %v, at len %d", rate, n)
+		}
+	}
+}
+
+func TestRibbonFilter_SmallerThanBloom(t *testing.T) {
+	const n = 100000
+	bloom, ribbon := newHarness(t), newRibbonHarness(t, 7)
+	for i := 0; i < n; i++ {
+		bloom.addNum(uint32(i))
+		ribbon.addNum(uint32(i))
+	}
+	bloom.build()
+	ribbon.build()
+
+	rate := func(h *harness) (rate float64) {
+		for i := 0; i < n; i++ {
+			if h.assertNum(uint32(i+1000000000), true, true) {
+				rate++
+			}
+		}
+		return rate / n
+	}
+	bloomRate, ribbonRate := rate(bloom), rate(ribbon)
+	t.Logf("bloom: %d bytes, %v false positive; ribbon: %d bytes, %v false positive",
+		bloom.filterLen(), bloomRate, ribbon.filterLen(), ribbonRate)
+	if ribbon.filterLen() > bloom.filterLen()*80/100 {
+		t.Errorf("ribbon filter is not smaller, got %d bytes, bloom is %d bytes", ribbon.filterLen(), bloom.filterLen())
+	}
+	if ribbonRate > bloomRate*1.5 {
+		t.Errorf("ribbon filter false positive rate too high, got %v, bloom is %v", ribbonRate, bloomRate)
+	}
+}
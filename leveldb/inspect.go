@@ -0,0 +1,324 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/syndtr/goleveldb/leveldb/filter"
+	"github.com/syndtr/goleveldb/leveldb/journal"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/table"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Opens the file at the given path for reading, decrypted as set by
+// EncryptionVersion and EncryptionKey. The file number is taken from the
+// file name if possible, e.g. 000012.log, which recyclable journals need.
+func openInspectFile(path string, ft storage.FileType) (r *iStorageReader, size int64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	fd := storage.FileDesc{Type: ft}
+	name := filepath.Base(path)
+	if num, err := strconv.ParseInt(strings.TrimSuffix(name, filepath.Ext(name)), 10, 64); err == nil {
+		fd.Num = num
+	}
+	return &iStorageReader{f, newIStorage(nil), newCipher(EncryptionKey), 0, fd}, fi.Size(), nil
+}
+
+// OpenTableFile opens the table file at the given path for inspection,
+// outside of any DB, e.g. a copy of a table attached to a corruption
+// report. The file is decrypted as set by EncryptionVersion and
+// EncryptionKey. The options should give the comparer and filters the table
+// was written with.
+//
+// The keys of the returned reader are internal keys, see ParseTableKey, and
+// its Find methods expect internal keys. The caller should release the
+// reader, which closes the file.
+func OpenTableFile(path string, o *opt.Options) (*table.Reader, error) {
+	r, size, err := openInspectFile(path, storage.TypeTable)
+	if err != nil {
+		return nil, err
+	}
+
+	no := dupOptions(o)
+	no.Comparer = &iComparer{o.GetComparer()}
+	if filter := o.GetFilter(); filter != nil {
+		no.Filter = &iFilter{filter}
+	}
+	if filters := o.GetAltFilters(); len(filters) > 0 {
+		no.AltFilters = make([]filter.Filter, len(filters))
+		for i, filter := range filters {
+			no.AltFilters[i] = &iFilter{filter}
+		}
+	}
+	tr, err := table.NewReader(r, size, r.fd, nil, nil, no)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return tr, nil
+}
+
+// ParseTableKey splits an internal key, as found in table files, into its
+// user key, its sequence number and whether it's a deletion marker.
+func ParseTableKey(key []byte) (ukey []byte, seq uint64, deleted bool, err error) {
+	ukey, seq, kt, err := parseInternalKey(key)
+	return ukey, seq, kt == keyTypeDel, err
+}
+
+// JournalRecord is a record of a JournalBatch.
+type JournalRecord struct {
+	Seq     uint64
+	Deleted bool
+	Key     []byte
+	// ValueLen is the length of the value, zero for a deletion.
+	ValueLen int
+}
+
+// JournalBatch is a write batch as found in a journal file, or a corrupted
+// part of the file.
+type JournalBatch struct {
+	// Seq is the sequence number of the first record.
+	Seq uint64
+	// Size is the size of the batch as stored, and Compressed whether it
+	// was compressed, see the JournalCompression option.
+	Size       int
+	Compressed bool
+	Records    []JournalRecord
+	// Err is set, and the other fields are zero, for a corrupted chunk
+	// that was skipped, or a batch that couldn't be decoded.
+	Err error
+}
+
+// ReadJournalFile decodes the journal file at the given path into its write
+// batches, calling fn for each of them in order, for inspection outside of
+// any DB, e.g. to find which writes were in flight during a crash. The
+// file is decrypted as set by EncryptionVersion and EncryptionKey.
+//
+// Corrupted parts of the file are skipped, and reported as batches with Err
+// set. ReadJournalFile stops and returns the error of fn, if any.
+func ReadJournalFile(path string, fn func(b *JournalBatch) error) error {
+	f, _, err := openInspectFile(path, storage.TypeJournal)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	d := &journalDropper{}
+	jr := journal.NewReader(f, d, false, true)
+	jr.SetLogNum(uint32(f.fd.Num))
+	var (
+		buf   util.Buffer
+		codec journalCodec
+	)
+	for {
+		r, err := jr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			buf.Reset()
+			_, err = buf.ReadFrom(r)
+		}
+		for _, derr := range d.errs {
+			if ferr := fn(&JournalBatch{Err: derr}); ferr != nil {
+				return ferr
+			}
+		}
+		d.errs = d.errs[:0]
+		if err == io.ErrUnexpectedEOF {
+			// Corrupted chunk, already reported.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		b := &JournalBatch{Size: buf.Len()}
+		if err := decodeJournalBatch(&codec, buf.Bytes(), b); err != nil {
+			b = &JournalBatch{Err: err}
+		}
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+	for _, derr := range d.errs {
+		if err := fn(&JournalBatch{Err: derr}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeJournalBatch(codec *journalCodec, record []byte, b *JournalBatch) error {
+	data, err := codec.decode(record)
+	if err != nil {
+		return err
+	}
+	b.Compressed = len(record) >= batchHeaderLen && record[7] != batchNoCompression
+	seq, batchLen, err := decodeBatchHeader(data)
+	if err != nil {
+		return err
+	}
+	b.Seq = seq
+	data = data[batchHeaderLen:]
+	err = decodeBatch(data, func(i int, index batchIndex) error {
+		b.Records = append(b.Records, JournalRecord{
+			Seq:      seq + uint64(i),
+			Deleted:  index.keyType == keyTypeDel,
+			Key:      append([]byte(nil), index.k(data)...),
+			ValueLen: index.valueLen,
+		})
+		return nil
+	})
+	if err == nil && len(b.Records) != batchLen {
+		err = newErrBatchCorrupted(fmt.Sprintf("invalid records length: %d vs %d", batchLen, len(b.Records)))
+	}
+	return err
+}
+
+// journalDropper collects the corruptions met by a journal reader.
+type journalDropper struct {
+	errs []error
+}
+
+func (d *journalDropper) Drop(err error) {
+	d.errs = append(d.errs, err)
+}
+
+// VersionEdit is a record of a MANIFEST file, that is a change to the set of
+// tables of a DB and its bookkeeping numbers, or a corrupted part of the
+// file. Its keys are internal keys, see ParseTableKey.
+type VersionEdit struct {
+	// Comparer is the name of the comparer, or empty if not recorded.
+	Comparer string
+	// The numbers are nil if not recorded.
+	JournalNum     *int64
+	PrevJournalNum *int64
+	NextFileNum    *int64
+	SeqNum         *uint64
+
+	CompactionPointers []CompactionPointer
+	AddedTables        []AddedTable
+	DeletedTables      []DeletedTable
+
+	// Err is set, and the other fields are zero, for a corrupted chunk
+	// that was skipped, or a record that couldn't be decoded.
+	Err error
+}
+
+// CompactionPointer is the key from which the next compaction of a level
+// starts.
+type CompactionPointer struct {
+	Level int
+	Key   []byte
+}
+
+// AddedTable is a table added to a level by a VersionEdit.
+type AddedTable struct {
+	Level             int
+	Num, Size         int64
+	Smallest, Largest []byte
+}
+
+// DeletedTable is a table removed from a level by a VersionEdit.
+type DeletedTable struct {
+	Level int
+	Num   int64
+}
+
+// ReadManifestFile decodes the MANIFEST file at the given path into its
+// version edits, calling fn for each of them in order, for inspection
+// outside of any DB. The file is decrypted as set by EncryptionVersion and
+// EncryptionKey.
+//
+// Corrupted parts of the file are skipped, and reported as edits with Err
+// set. ReadManifestFile stops and returns the error of fn, if any.
+func ReadManifestFile(path string, fn func(e *VersionEdit) error) error {
+	f, _, err := openInspectFile(path, storage.TypeManifest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	d := &journalDropper{}
+	jr := journal.NewReader(f, d, false, true)
+	report := func() error {
+		for _, derr := range d.errs {
+			if err := fn(&VersionEdit{Err: derr}); err != nil {
+				return err
+			}
+		}
+		d.errs = d.errs[:0]
+		return nil
+	}
+	for {
+		r, err := jr.Next()
+		if err == io.EOF {
+			return report()
+		}
+		if err != nil {
+			return err
+		}
+
+		rec := &sessionRecord{}
+		err = rec.decode(r)
+		if rerr := report(); rerr != nil {
+			return rerr
+		}
+		e := &VersionEdit{Err: err}
+		if err == nil {
+			e = rec.versionEdit()
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *sessionRecord) versionEdit() *VersionEdit {
+	e := &VersionEdit{}
+	if p.has(recComparer) {
+		e.Comparer = p.comparer
+	}
+	if p.has(recJournalNum) {
+		e.JournalNum = &p.journalNum
+	}
+	if p.has(recPrevJournalNum) {
+		e.PrevJournalNum = &p.prevJournalNum
+	}
+	if p.has(recNextFileNum) {
+		e.NextFileNum = &p.nextFileNum
+	}
+	if p.has(recSeqNum) {
+		e.SeqNum = &p.seqNum
+	}
+	for _, r := range p.compPtrs {
+		e.CompactionPointers = append(e.CompactionPointers, CompactionPointer{r.level, append([]byte(nil), r.ikey...)})
+	}
+	for _, r := range p.addedTables {
+		e.AddedTables = append(e.AddedTables, AddedTable{r.level, r.num, r.size, append([]byte(nil), r.imin...), append([]byte(nil), r.imax...)})
+	}
+	for _, r := range p.deletedTables {
+		e.DeletedTables = append(e.DeletedTables, DeletedTable{r.level, r.num})
+	}
+	return e
+}
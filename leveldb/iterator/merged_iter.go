@@ -265,8 +265,12 @@ func (i *mergedIterator) Release() {
 			iter.Release()
 		}
 		i.iters = nil
-		i.keys = nil
-		i.indexes = nil
+		// Keep the slices for ReuseMergedIterator, but not the keys.
+		for k := range i.keys {
+			i.keys[k] = nil
+		}
+		i.keys = i.keys[:0]
+		i.indexes = i.indexes[:0]
 		if i.releaser != nil {
 			i.releaser.Release()
 			i.releaser = nil
@@ -316,6 +320,28 @@ func NewMergedIterator(iters []Iterator, cmp comparer.Comparer, strict bool) Ite
 	}
 }
 
+// ReuseMergedIterator is like NewMergedIterator, but reuses the allocations
+// of iter if it is a released iterator returned by NewMergedIterator or
+// ReuseMergedIterator. Iter must no longer be used by the caller.
+func ReuseMergedIterator(iter Iterator, iters []Iterator, cmp comparer.Comparer, strict bool) Iterator {
+	i, ok := iter.(*mergedIterator)
+	if !ok || i.dir != dirReleased {
+		return NewMergedIterator(iters, cmp, strict)
+	}
+	keys := i.keys[:0]
+	for range iters {
+		keys = append(keys, nil)
+	}
+	*i = mergedIterator{
+		iters:   iters,
+		cmp:     cmp,
+		strict:  strict,
+		keys:    keys,
+		indexes: i.indexes[:0],
+	}
+	return i
+}
+
 // indexHeap implements heap.Interface.
 type indexHeap mergedIterator
 
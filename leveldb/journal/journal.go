@@ -72,6 +72,13 @@
 // first, middle or last chunk of a multi-chunk journal. A multi-chunk journal
 // has one first chunk, zero or more middle chunks, and one last chunk.
 //
+// A recyclable writer uses four more chunk types, mirroring the above, whose
+// header is 11 bytes: the 7 byte header followed by a 4 byte little-endian
+// log number, which is also covered by the checksum. This allows a file to be
+// overwritten in place: once the reader has seen a recyclable chunk, a chunk
+// with a different log number, an invalid chunk or a legacy chunk marks the
+// end of the journals, rather than corruption.
+//
 // The wire format allows for limited recovery in the face of data corruption:
 // on a format error (such as a checksum mismatch), the reader moves to the
 // next block and looks for the next full or first chunk.
@@ -93,11 +100,17 @@ const (
 	firstChunkType  = 2
 	middleChunkType = 3
 	lastChunkType   = 4
+
+	recyclableFullChunkType   = 5
+	recyclableFirstChunkType  = 6
+	recyclableMiddleChunkType = 7
+	recyclableLastChunkType   = 8
 )
 
 const (
-	blockSize  = 32 * 1024
-	headerSize = 7
+	blockSize            = 32 * 1024
+	headerSize           = 7
+	recyclableHeaderSize = headerSize + 4
 )
 
 type flusher interface {
@@ -130,6 +143,12 @@ type Reader struct {
 	strict bool
 	// checksum flag.
 	checksum bool
+	// logNum is the expected log number of recyclable chunks, zero if any.
+	logNum uint32
+	// recycled is whether a recyclable chunk has been read.
+	recycled bool
+	// stale is whether the end of the recyclable chunks has been reached.
+	stale bool
 	// seq is the sequence number of the current journal.
 	seq int
 	// buf[i:j] is the unread portion of the current chunk's payload.
@@ -159,6 +178,14 @@ func NewReader(r io.Reader, dropper Dropper, strict, checksum bool) *Reader {
 	}
 }
 
+// SetLogNum sets the log number that recyclable chunks are expected to
+// carry; chunks with another log number are leftovers of a previous use of
+// the file. If zero, the log number of the first recyclable chunk is used.
+// SetLogNum should be called before the first Next call.
+func (r *Reader) SetLogNum(logNum uint32) {
+	r.logNum = logNum
+}
+
 var errSkip = errors.New("leveldb/journal: skipped")
 
 func (r *Reader) corrupt(n int, reason string, skip bool) error {
@@ -176,38 +203,88 @@ func (r *Reader) corrupt(n int, reason string, skip bool) error {
 // next block into the buffer if necessary.
 func (r *Reader) nextChunk(first bool) error {
 	for {
+		if r.stale {
+			if !first {
+				return r.corrupt(0, "missing chunk part", false)
+			}
+			r.err = io.EOF
+			return r.err
+		}
+		if r.recycled && r.j+headerSize <= r.n && r.j+recyclableHeaderSize > r.n {
+			// Block trailer of a recyclable journal.
+			r.i = r.n
+			r.j = r.n
+		}
 		if r.j+headerSize <= r.n {
 			checksum := binary.LittleEndian.Uint32(r.buf[r.j+0 : r.j+4])
 			length := binary.LittleEndian.Uint16(r.buf[r.j+4 : r.j+6])
 			chunkType := r.buf[r.j+6]
 			unprocBlock := r.n - r.j
+			recyclable := chunkType >= recyclableFullChunkType && chunkType <= recyclableLastChunkType
+			if r.recycled && !recyclable {
+				// Leftover of a previous use of the file.
+				r.stale = true
+				continue
+			}
 			if checksum == 0 && length == 0 && chunkType == 0 {
 				// Drop entire block.
 				r.i = r.n
 				r.j = r.n
 				return r.corrupt(unprocBlock, "zero header", false)
 			}
-			if chunkType < fullChunkType || chunkType > lastChunkType {
+			if chunkType < fullChunkType || chunkType > recyclableLastChunkType {
 				// Drop entire block.
 				r.i = r.n
 				r.j = r.n
 				return r.corrupt(unprocBlock, fmt.Sprintf("invalid chunk type %#x", chunkType), false)
 			}
-			r.i = r.j + headerSize
-			r.j = r.j + headerSize + int(length)
+			hdrSize := headerSize
+			if recyclable {
+				hdrSize = recyclableHeaderSize
+				if r.j+hdrSize > r.n {
+					// Drop entire block.
+					r.i = r.n
+					r.j = r.n
+					return r.corrupt(unprocBlock, "chunk header overflows block", false)
+				}
+			}
+			start := r.j
+			r.i = r.j + hdrSize
+			r.j = r.j + hdrSize + int(length)
 			if r.j > r.n {
+				if r.recycled {
+					r.stale = true
+					continue
+				}
 				// Drop entire block.
 				r.i = r.n
 				r.j = r.n
 				return r.corrupt(unprocBlock, "chunk length overflows block", false)
-			} else if r.checksum && checksum != util.NewCRC(r.buf[r.i-1:r.j]).Value() {
+			} else if r.checksum && checksum != util.NewCRC(r.buf[start+6:r.j]).Value() {
+				if r.recycled {
+					r.stale = true
+					continue
+				}
 				// Drop entire block.
 				r.i = r.n
 				r.j = r.n
 				return r.corrupt(unprocBlock, "checksum mismatch", false)
 			}
+			if recyclable {
+				logNum := binary.LittleEndian.Uint32(r.buf[start+7 : start+11])
+				if r.logNum == 0 {
+					r.logNum = logNum
+				}
+				r.recycled = true
+				if logNum != r.logNum {
+					// Leftover of a previous use of the file.
+					r.stale = true
+					continue
+				}
+				chunkType -= recyclableFullChunkType - fullChunkType
+			}
 			if first && chunkType != fullChunkType && chunkType != firstChunkType {
-				chunkLength := (r.j - r.i) + headerSize
+				chunkLength := (r.j - r.i) + hdrSize
 				r.i = r.j
 				// Report the error, but skip it.
 				return r.corrupt(chunkLength, "orphan chunk", true)
@@ -270,6 +347,9 @@ func (r *Reader) Reset(reader io.Reader, dropper Dropper, strict, checksum bool)
 	r.dropper = dropper
 	r.strict = strict
 	r.checksum = checksum
+	r.logNum = 0
+	r.recycled = false
+	r.stale = false
 	r.i = 0
 	r.j = 0
 	r.n = 0
@@ -360,6 +440,10 @@ type Writer struct {
 	first bool
 	// pending is whether a chunk is buffered but not yet written.
 	pending bool
+	// recyclable is whether chunks are written in the recyclable format,
+	// carrying logNum.
+	recyclable bool
+	logNum     uint32
 	// err is any accumulated error.
 	err error
 	// buf is the buffer.
@@ -375,26 +459,51 @@ func NewWriter(w io.Writer) *Writer {
 	}
 }
 
+// NewRecyclableWriter returns a new Writer which writes chunks in the
+// recyclable format, tagged with the given log number. The underlying
+// writer may overwrite a file previously written by a recyclable writer
+// with another log number.
+func NewRecyclableWriter(w io.Writer, logNum uint32) *Writer {
+	jw := NewWriter(w)
+	jw.recyclable = true
+	jw.logNum = logNum
+	return jw
+}
+
+func (w *Writer) headerSize() int {
+	if w.recyclable {
+		return recyclableHeaderSize
+	}
+	return headerSize
+}
+
 // fillHeader fills in the header for the pending chunk.
 func (w *Writer) fillHeader(last bool) {
-	if w.i+headerSize > w.j || w.j > blockSize {
+	hdrSize := w.headerSize()
+	if w.i+hdrSize > w.j || w.j > blockSize {
 		panic("leveldb/journal: bad writer state")
 	}
+	var chunkType byte
 	if last {
 		if w.first {
-			w.buf[w.i+6] = fullChunkType
+			chunkType = fullChunkType
 		} else {
-			w.buf[w.i+6] = lastChunkType
+			chunkType = lastChunkType
 		}
 	} else {
 		if w.first {
-			w.buf[w.i+6] = firstChunkType
+			chunkType = firstChunkType
 		} else {
-			w.buf[w.i+6] = middleChunkType
+			chunkType = middleChunkType
 		}
 	}
+	if w.recyclable {
+		chunkType += recyclableFullChunkType - fullChunkType
+		binary.LittleEndian.PutUint32(w.buf[w.i+7:w.i+11], w.logNum)
+	}
+	w.buf[w.i+6] = chunkType
 	binary.LittleEndian.PutUint32(w.buf[w.i+0:w.i+4], util.NewCRC(w.buf[w.i+6:w.j]).Value())
-	binary.LittleEndian.PutUint16(w.buf[w.i+4:w.i+6], uint16(w.j-w.i-headerSize))
+	binary.LittleEndian.PutUint16(w.buf[w.i+4:w.i+6], uint16(w.j-w.i-hdrSize))
 }
 
 // writeBlock writes the buffered block to the underlying writer, and reserves
@@ -402,7 +511,7 @@ func (w *Writer) fillHeader(last bool) {
 func (w *Writer) writeBlock() {
 	_, w.err = w.w.Write(w.buf[w.written:])
 	w.i = 0
-	w.j = headerSize
+	w.j = w.headerSize()
 	w.written = 0
 	w.blockNumber++
 }
@@ -463,10 +572,21 @@ func (w *Writer) Reset(writer io.Writer) (err error) {
 	w.blockNumber = 0
 	w.first = false
 	w.pending = false
+	w.recyclable = false
+	w.logNum = 0
 	w.err = nil
 	return
 }
 
+// ResetRecyclable is like Reset, but the journal writer will then write
+// chunks in the recyclable format tagged with the given log number.
+func (w *Writer) ResetRecyclable(writer io.Writer, logNum uint32) (err error) {
+	err = w.Reset(writer)
+	w.recyclable = true
+	w.logNum = logNum
+	return
+}
+
 // Next returns a writer for the next journal. The writer returned becomes stale
 // after the next Close, Flush or Next call, and should no longer be used.
 func (w *Writer) Next() (io.Writer, error) {
@@ -478,7 +598,7 @@ func (w *Writer) Next() (io.Writer, error) {
 		w.fillHeader(true)
 	}
 	w.i = w.j
-	w.j += headerSize
+	w.j += w.headerSize()
 	// Check if there is room in the block for the header.
 	if w.j > blockSize {
 		// Fill in the rest of the block with zeroes.
@@ -503,6 +623,14 @@ func (w *Writer) Size() int64 {
 	return w.blockNumber*blockSize + int64(w.j)
 }
 
+// BuffersWriter is implemented by the writers returned by Writer.Next. It
+// writes the given byte slices in order as a single call, like writev, so
+// callers can gather a journal from several buffers without joining them
+// first.
+type BuffersWriter interface {
+	WriteBuffers(bufs [][]byte) (int, error)
+}
+
 type singleWriter struct {
 	w   *Writer
 	seq int
@@ -516,6 +644,30 @@ func (x singleWriter) Write(p []byte) (int, error) {
 	if w.err != nil {
 		return 0, w.err
 	}
+	return w.write(p)
+}
+
+func (x singleWriter) WriteBuffers(bufs [][]byte) (int, error) {
+	w := x.w
+	if w.seq != x.seq {
+		return 0, errors.New("leveldb/journal: stale writer")
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	var n int
+	for _, p := range bufs {
+		nn, err := w.write(p)
+		n += nn
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// write copies p into the current journal, writing out full blocks.
+func (w *Writer) write(p []byte) (int, error) {
 	n0 := len(p)
 	for len(p) > 0 {
 		// Write a block, if it is full.
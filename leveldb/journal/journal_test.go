@@ -854,3 +854,113 @@ func TestCorrupt_MiddleChuckLengthOverflow(t *testing.T) {
 		t.Fatalf("last next: unexpected error: %v", err)
 	}
 }
+
+func writeRecyclable(t *testing.T, logNum uint32, ss []string) []byte {
+	buf := new(bytes.Buffer)
+	w := NewRecyclableWriter(buf, logNum)
+	for _, s := range ss {
+		ww, err := w.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ww.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func readRecyclable(t *testing.T, b []byte, logNum uint32) []string {
+	r := NewReader(bytes.NewReader(b), dropper{t}, true, true)
+	r.SetLogNum(logNum)
+	var ss []string
+	for {
+		rr, err := r.Next()
+		if err == io.EOF {
+			return ss
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		x, err := ioutil.ReadAll(rr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ss = append(ss, string(x))
+	}
+}
+
+func TestRecyclable(t *testing.T) {
+	for i := blockSize - 24; i < blockSize+8; i++ {
+		ss := []string{big("abcd", i), "", "x", big("ABCDE", blockSize)}
+		got := readRecyclable(t, writeRecyclable(t, 7, ss), 7)
+		require.Equal(t, ss, got, "size %d", i)
+	}
+}
+
+func TestRecyclable_Overwrite(t *testing.T) {
+	var old []string
+	for i := 0; i < 100; i++ {
+		old = append(old, big(fmt.Sprintf("old%d.", i), 1000+i*37))
+	}
+	b := writeRecyclable(t, 1, old)
+
+	// Nothing written yet to the reused file.
+	require.Empty(t, readRecyclable(t, b, 2))
+
+	// Partially overwrite the old journal in place.
+	ss := []string{big("new0.", blockSize+100), "new1", big("new2.", 500)}
+	nb := writeRecyclable(t, 2, ss)
+	require.Less(t, len(nb), len(b))
+	copy(b, nb)
+	require.Equal(t, ss, readRecyclable(t, b, 2))
+
+	// The old log number no longer matches the head of the file.
+	require.Empty(t, readRecyclable(t, b, 1))
+}
+
+func TestRecyclable_AnyLogNum(t *testing.T) {
+	ss := []string{"a", big("b", 3*blockSize), "c"}
+	b := writeRecyclable(t, 3, ss)
+	r := NewReader(bytes.NewReader(b), dropper{t}, true, true)
+	for _, s := range ss {
+		rr, err := r.Next()
+		require.NoError(t, err)
+		x, err := ioutil.ReadAll(rr)
+		require.NoError(t, err)
+		require.Equal(t, s, string(x))
+	}
+	_, err := r.Next()
+	require.Equal(t, io.EOF, err)
+}
+
+func TestWriteBuffers(t *testing.T) {
+	bufs := [][]byte{[]byte("header"), []byte(big("a", blockSize)), nil, []byte(big("b", 2*blockSize))}
+	var want []byte
+	for _, b := range bufs {
+		want = append(want, b...)
+	}
+
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	ww, err := w.Next()
+	require.NoError(t, err)
+	n, err := ww.(BuffersWriter).WriteBuffers(bufs)
+	require.NoError(t, err)
+	require.Equal(t, len(want), n)
+	require.NoError(t, w.Close())
+
+	r := NewReader(buf, dropper{t}, true, true)
+	rr, err := r.Next()
+	require.NoError(t, err)
+	x, err := ioutil.ReadAll(rr)
+	require.NoError(t, err)
+	require.Equal(t, want, x)
+
+	// The writer becomes stale after Close.
+	_, err = ww.(BuffersWriter).WriteBuffers(bufs)
+	require.Error(t, err)
+}
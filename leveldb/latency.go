@@ -0,0 +1,147 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// Latencies are recorded in log-linear buckets: each power of two range is
+// split into 1<<latencySubBits buckets, which bounds the relative error of
+// the reported percentiles to about 6%.
+const (
+	latencySubBits = 4
+	latencySub     = 1 << latencySubBits
+	latencyBuckets = (64-latencySubBits-1)*latencySub + 2*latencySub
+)
+
+func latencyBucket(v uint64) int {
+	var shift uint
+	if n := bits.Len64(v); n > latencySubBits+1 {
+		shift = uint(n - latencySubBits - 1)
+	}
+	return int(shift)*latencySub + int(v>>shift)
+}
+
+// Returns the largest value falling in the given bucket.
+func latencyBucketMax(b int) uint64 {
+	if b < 2*latencySub {
+		return uint64(b)
+	}
+	shift := uint(b/latencySub - 1)
+	mantissa := uint64(b - int(shift)*latencySub)
+	return (mantissa+1)<<shift - 1
+}
+
+// latencyHistogram is a lock-free histogram of durations.
+type latencyHistogram struct {
+	counts [latencyBuckets]int64
+	max    int64
+}
+
+func (h *latencyHistogram) add(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	atomic.AddInt64(&h.counts[latencyBucket(uint64(d))], 1)
+	for {
+		max := atomic.LoadInt64(&h.max)
+		if int64(d) <= max || atomic.CompareAndSwapInt64(&h.max, max, int64(d)) {
+			break
+		}
+	}
+}
+
+func (h *latencyHistogram) since(start time.Time) {
+	h.add(time.Since(start))
+}
+
+func (h *latencyHistogram) reset() {
+	for i := range h.counts {
+		atomic.StoreInt64(&h.counts[i], 0)
+	}
+	atomic.StoreInt64(&h.max, 0)
+}
+
+func (h *latencyHistogram) stats() (s LatencyStats) {
+	var counts [latencyBuckets]int64
+	for i := range counts {
+		counts[i] = atomic.LoadInt64(&h.counts[i])
+		s.Count += counts[i]
+	}
+	s.Max = time.Duration(atomic.LoadInt64(&h.max))
+	if s.Count == 0 {
+		return
+	}
+	percentile := func(p int64) time.Duration {
+		// The rank of the percentile, rounded up.
+		rank := (s.Count*p + 99) / 100
+		var cum int64
+		for b, n := range counts {
+			cum += n
+			if cum >= rank {
+				if d := time.Duration(latencyBucketMax(b)); d < s.Max {
+					return d
+				}
+				break
+			}
+		}
+		return s.Max
+	}
+	s.P50, s.P95, s.P99 = percentile(50), percentile(95), percentile(99)
+	return
+}
+
+// LatencyStats summarizes the latencies of an operation.
+type LatencyStats struct {
+	Count int64
+
+	// Percentiles are upper bounds, with a relative error of about 6%.
+	P50, P95, P99 time.Duration
+	Max           time.Duration
+}
+
+// Latency histograms of DB operations, only maintained if enabled by the
+// LatencyHistograms option.
+type dbLatency struct {
+	get, write, syncWrite latencyHistogram
+	seek, next            latencyHistogram
+	flush, compaction     latencyHistogram
+}
+
+func (l *dbLatency) writeHistogram(sync bool) *latencyHistogram {
+	if sync {
+		return &l.syncWrite
+	}
+	return &l.write
+}
+
+func (l *dbLatency) fillStats(s *DBStats) {
+	s.GetLatency = l.get.stats()
+	s.WriteLatency = l.write.stats()
+	s.SyncWriteLatency = l.syncWrite.stats()
+	s.SeekLatency = l.seek.stats()
+	s.NextLatency = l.next.stats()
+	s.FlushLatency = l.flush.stats()
+	s.CompactionLatency = l.compaction.stats()
+}
+
+func (l *dbLatency) reset() {
+	for _, h := range []*latencyHistogram{&l.get, &l.write, &l.syncWrite, &l.seek, &l.next, &l.flush, &l.compaction} {
+		h.reset()
+	}
+}
+
+// ResetLatencyStats resets the latency histograms reported by Stats. It
+// does nothing unless the LatencyHistograms option is set.
+func (db *DB) ResetLatencyStats() {
+	if db.latency != nil {
+		db.latency.reset()
+	}
+}
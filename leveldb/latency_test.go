@@ -0,0 +1,54 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leveldb
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestLatencyBucket(t *testing.T) {
+	prev := -1
+	for _, v := range []uint64{0, 1, 31, 32, 33, 34, 1000, 1 << 40, math.MaxUint64} {
+		b := latencyBucket(v)
+		if b < prev || b >= latencyBuckets {
+			t.Fatalf("bad bucket %d for %d", b, v)
+		}
+		prev = b
+		if max := latencyBucketMax(b); max < v || float64(max-v) > float64(v)/latencySub {
+			t.Errorf("bucket %d of %d has max %d", b, v, max)
+		}
+	}
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	var h latencyHistogram
+	for i := 1; i <= 1000; i++ {
+		h.add(time.Duration(i) * time.Microsecond)
+	}
+	s := h.stats()
+	if s.Count != 1000 || s.Max != time.Millisecond {
+		t.Fatalf("got count %d max %v", s.Count, s.Max)
+	}
+	for _, c := range []struct {
+		got, want time.Duration
+	}{
+		{s.P50, 500 * time.Microsecond},
+		{s.P95, 950 * time.Microsecond},
+		{s.P99, 990 * time.Microsecond},
+	} {
+		if c.got < c.want || c.got > c.want+c.want/latencySub {
+			t.Errorf("got percentile %v, want about %v", c.got, c.want)
+		}
+	}
+
+	h.reset()
+	if s := h.stats(); s != (LatencyStats{}) {
+		t.Errorf("got %+v after reset", s)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package lww
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Timestamp is a hybrid logical clock timestamp: the physical time of the
+// node which wrote a record, the logical counter ordering the writes within
+// that time, and the node, breaking ties between writers.
+type Timestamp struct {
+	// Wall is the physical time, in nanoseconds since the Unix epoch.
+	Wall int64
+	// Logical is the logical counter.
+	Logical uint32
+	// Node is the ID of the writer.
+	Node uint32
+}
+
+// Length of an encoded Timestamp.
+const timestampLen = 16
+
+// Compare returns -1, 0 or 1 if ts is before, equal to or after o.
+func (ts Timestamp) Compare(o Timestamp) int {
+	switch {
+	case ts.Wall != o.Wall:
+		return cmp(ts.Wall < o.Wall)
+	case ts.Logical != o.Logical:
+		return cmp(ts.Logical < o.Logical)
+	case ts.Node != o.Node:
+		return cmp(ts.Node < o.Node)
+	}
+	return 0
+}
+
+func cmp(less bool) int {
+	if less {
+		return -1
+	}
+	return 1
+}
+
+func (ts Timestamp) String() string {
+	return fmt.Sprintf("%s+%d@%d", time.Unix(0, ts.Wall).UTC().Format(time.RFC3339Nano), ts.Logical, ts.Node)
+}
+
+// Encodes ts so that the encodings sort as the timestamps, for the
+// non-negative wall times.
+func (ts Timestamp) append(dst []byte) []byte {
+	dst = binary.BigEndian.AppendUint64(dst, uint64(ts.Wall))
+	dst = binary.BigEndian.AppendUint32(dst, ts.Logical)
+	return binary.BigEndian.AppendUint32(dst, ts.Node)
+}
+
+func decodeTimestamp(b []byte) Timestamp {
+	return Timestamp{
+		Wall:    int64(binary.BigEndian.Uint64(b)),
+		Logical: binary.BigEndian.Uint32(b[8:]),
+		Node:    binary.BigEndian.Uint32(b[12:]),
+	}
+}
+
+// Clock is a hybrid logical clock. Its timestamps follow the physical time
+// of the node, and every timestamp it observed, so that a write always
+// wins over the writes it could see. It's safe for concurrent use.
+type Clock struct {
+	node uint32
+	now  func() time.Time
+
+	mu   sync.Mutex
+	wall int64
+	n    uint32
+}
+
+// NewClock returns a clock for the given node, reading the physical time
+// with now, time.Now if nil.
+func NewClock(node uint32, now func() time.Time) *Clock {
+	if now == nil {
+		now = time.Now
+	}
+	return &Clock{node: node, now: now}
+}
+
+// Now returns a timestamp after every timestamp returned or observed.
+func (c *Clock) Now() Timestamp {
+	pt := c.now().UnixNano()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pt > c.wall {
+		c.wall, c.n = pt, 0
+	} else {
+		c.n++
+	}
+	return Timestamp{Wall: c.wall, Logical: c.n, Node: c.node}
+}
+
+// Observe moves the clock to the given timestamp, of another node, if
+// after it.
+func (c *Clock) Observe(ts Timestamp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ts.Wall > c.wall || (ts.Wall == c.wall && ts.Logical > c.n) {
+		c.wall, c.n = ts.Wall, ts.Logical
+	}
+}
+
+// Offset returns how far ahead of the physical time of the node the given
+// timestamp is, or zero if it isn't.
+func (c *Clock) Offset(ts Timestamp) time.Duration {
+	if d := time.Duration(ts.Wall - c.now().UnixNano()); d > 0 {
+		return d
+	}
+	return 0
+}
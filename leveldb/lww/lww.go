@@ -0,0 +1,293 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package lww stores records tagged with a hybrid logical clock timestamp
+// in a DB, so that DBs written independently, e.g. on offline edge nodes,
+// can be merged deterministically, the last write of each key winning:
+//
+//	db := lww.New(ldb, &lww.Options{Node: 1})
+//	err := db.Put([]byte("key"), []byte("value"), nil)
+//	...
+//	// Once back online, with a copy of the DB of another node.
+//	stats, err := db.MergeDB(other)
+//
+// Each value of the DB is a record: the value written, or a tombstone for
+// a deleted key, and the timestamp of the write, see Record. Deleted keys
+// keep their tombstone, so that a merge doesn't bring back an older value.
+// The DB must only be written through this package.
+//
+// A merge keeps, for each key, the record with the latest timestamp, or,
+// for equal timestamps, the greatest encoded record, so that merging two
+// DBs in either order gives the same content. The clock of the DB observes
+// the timestamps merged, so that the writes following a merge win over
+// the records merged, even from a node whose clock is ahead.
+package lww
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// Kinds of record.
+const (
+	recordValue     = 1
+	recordTombstone = 2
+)
+
+// Length of the header of an encoded record: its kind and timestamp.
+const recordHeaderLen = 1 + timestampLen
+
+// Number of entries per batch written by a merge.
+const mergeBatchLen = 1000
+
+var (
+	// ErrInvalidRecord is returned for values which aren't records.
+	ErrInvalidRecord = errors.New("lww: invalid record")
+	// ErrClockOffset is returned when merging a record too far ahead of
+	// the physical time of the node, see Options.MaxOffset.
+	ErrClockOffset = errors.New("lww: record timestamp ahead of the clock")
+)
+
+// Record is a value of the DB.
+type Record struct {
+	// Time is the timestamp of the write.
+	Time Timestamp
+	// Deleted tells whether the key was deleted.
+	Deleted bool
+	// Value is the value written, nil for a deleted key.
+	Value []byte
+}
+
+// Append appends the encoding of the record to dst and returns the
+// extended buffer.
+func (r Record) Append(dst []byte) []byte {
+	if r.Deleted {
+		return r.Time.append(append(dst, recordTombstone))
+	}
+	return append(r.Time.append(append(dst, recordValue)), r.Value...)
+}
+
+// DecodeRecord decodes a value of the DB. The value of the record returned
+// is a slice of b.
+func DecodeRecord(b []byte) (Record, error) {
+	if len(b) < recordHeaderLen {
+		return Record{}, ErrInvalidRecord
+	}
+	r := Record{Time: decodeTimestamp(b[1:])}
+	switch b[0] {
+	case recordValue:
+		r.Value = b[recordHeaderLen:]
+	case recordTombstone:
+		if len(b) != recordHeaderLen {
+			return Record{}, ErrInvalidRecord
+		}
+		r.Deleted = true
+	default:
+		return Record{}, ErrInvalidRecord
+	}
+	return r, nil
+}
+
+// Options holds the optional parameters of a DB.
+type Options struct {
+	// Node is the ID of the node writing the DB, which must be unique
+	// among the nodes whose DBs are merged.
+	//
+	// The default is zero.
+	Node uint32
+
+	// MaxOffset is how far ahead of the physical time of the node the
+	// records merged may be, to catch the nodes with a wrong clock. A
+	// merge fails with ErrClockOffset on a record further ahead.
+	//
+	// The default is zero, which means no limit.
+	MaxOffset time.Duration
+
+	// Now returns the physical time of the node.
+	//
+	// The default is time.Now.
+	Now func() time.Time
+}
+
+// MergeStats holds the counts of records of a merge.
+type MergeStats struct {
+	// Applied is the number of records written, newer than those of the
+	// DB.
+	Applied int
+	// Skipped is the number of records not newer than those of the DB.
+	Skipped int
+}
+
+// DB is a DB holding records. It's safe for concurrent use.
+type DB struct {
+	db        *leveldb.DB
+	clock     *Clock
+	maxOffset time.Duration
+
+	// Serializes the writes, so that a merge doesn't overwrite a newer
+	// record written meanwhile.
+	mu sync.Mutex
+}
+
+// New returns a DB storing the records in the given DB. The options may be
+// nil.
+func New(db *leveldb.DB, o *Options) *DB {
+	var node uint32
+	var now func() time.Time
+	d := &DB{db: db}
+	if o != nil {
+		node, now = o.Node, o.Now
+		d.maxOffset = o.MaxOffset
+	}
+	d.clock = NewClock(node, now)
+	return d
+}
+
+// Clock returns the clock of the DB.
+func (d *DB) Clock() *Clock {
+	return d.clock
+}
+
+// Put sets the value for the given key, with a new timestamp.
+func (d *DB) Put(key, value []byte, wo *opt.WriteOptions) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.db.Put(key, Record{Time: d.clock.Now(), Value: value}.Append(nil), wo)
+}
+
+// Delete deletes the value for the given key, writing a tombstone with a
+// new timestamp.
+func (d *DB) Delete(key []byte, wo *opt.WriteOptions) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.db.Put(key, Record{Time: d.clock.Now(), Deleted: true}.Append(nil), wo)
+}
+
+// Get gets the value for the given key. It returns leveldb.ErrNotFound if
+// the key isn't found or is deleted.
+func (d *DB) Get(key []byte) ([]byte, error) {
+	r, err := d.GetRecord(key)
+	if err != nil {
+		return nil, err
+	}
+	if r.Deleted {
+		return nil, leveldb.ErrNotFound
+	}
+	return r.Value, nil
+}
+
+// GetRecord gets the record for the given key, which may be a tombstone.
+// It returns leveldb.ErrNotFound if the key isn't found.
+func (d *DB) GetRecord(key []byte) (Record, error) {
+	v, err := d.db.Get(key, nil)
+	if err != nil {
+		return Record{}, err
+	}
+	r, err := DecodeRecord(v)
+	if err != nil {
+		return Record{}, fmt.Errorf("lww: value of %q: %v", key, err)
+	}
+	return r, nil
+}
+
+// Tells whether the incoming record wins over the current one, nil if
+// none, observing its timestamp.
+func (d *DB) resolve(key, current, incoming []byte) (bool, error) {
+	in, err := DecodeRecord(incoming)
+	if err != nil {
+		return false, fmt.Errorf("lww: merged value of %q: %v", key, err)
+	}
+	if d.maxOffset > 0 && d.clock.Offset(in.Time) > d.maxOffset {
+		return false, ErrClockOffset
+	}
+	d.clock.Observe(in.Time)
+	if current == nil {
+		return true, nil
+	}
+	cur, err := DecodeRecord(current)
+	if err != nil {
+		return false, fmt.Errorf("lww: value of %q: %v", key, err)
+	}
+	if c := in.Time.Compare(cur.Time); c != 0 {
+		return c > 0, nil
+	}
+	return bytes.Compare(incoming, current) > 0, nil
+}
+
+// Merge merges the records of the given iterator, e.g. over a DB of
+// another node, into the DB, see the package documentation. The records
+// are written in batches, so a failed merge may be partially applied;
+// merging the same records again is harmless. The writes to the DB wait
+// for the merge.
+func (d *DB) Merge(iter iterator.Iterator) (stats MergeStats, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	b := new(leveldb.Batch)
+	for iter.Next() {
+		current, err := d.db.Get(iter.Key(), nil)
+		if err != nil && err != leveldb.ErrNotFound {
+			return stats, err
+		}
+		ok, err := d.resolve(iter.Key(), current, iter.Value())
+		if err != nil {
+			return stats, err
+		}
+		if !ok {
+			stats.Skipped++
+			continue
+		}
+		b.Put(iter.Key(), iter.Value())
+		if b.Len() == mergeBatchLen {
+			if err := d.db.Write(b, nil); err != nil {
+				return stats, err
+			}
+			stats.Applied += b.Len()
+			b.Reset()
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return stats, err
+	}
+	if err := d.db.Write(b, nil); err != nil {
+		return stats, err
+	}
+	stats.Applied += b.Len()
+	return stats, nil
+}
+
+// MergeDB merges the records of a snapshot of the given DB, written by
+// this package, into the DB, as Merge.
+func (d *DB) MergeDB(src *leveldb.DB) (MergeStats, error) {
+	iter := src.NewIterator(nil, nil)
+	defer iter.Release()
+	return d.Merge(iter)
+}
+
+// Import merges the records read from r in the given format, e.g. an
+// export of a DB of another node, see leveldb.DB.Export, into the DB, as
+// Merge.
+func (d *DB) Import(r io.Reader, format leveldb.ExportFormat) (stats MergeStats, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	stats.Applied, err = d.db.ImportWith(r, format, &leveldb.ImportOptions{
+		Resolve: func(key, current, incoming []byte) ([]byte, bool, error) {
+			ok, err := d.resolve(key, current, incoming)
+			if err == nil && !ok {
+				stats.Skipped++
+			}
+			return incoming, ok, err
+		},
+	})
+	return stats, err
+}
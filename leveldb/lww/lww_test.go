@@ -0,0 +1,161 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package lww
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// A physical clock only moving when told to.
+type fakeTime struct {
+	t time.Time
+}
+
+func (f *fakeTime) now() time.Time { return f.t }
+
+func openDB(t *testing.T, o *Options) *DB {
+	t.Helper()
+	ldb, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ldb.Close() })
+	return New(ldb, o)
+}
+
+func TestClock(t *testing.T) {
+	ft := &fakeTime{time.Unix(100, 0)}
+	c := NewClock(7, ft.now)
+	a := c.Now()
+	b := c.Now()
+	if b.Compare(a) <= 0 || b.Logical != 1 || b.Node != 7 {
+		t.Fatalf("stalled time: got %v after %v", b, a)
+	}
+	c.Observe(Timestamp{Wall: time.Unix(200, 0).UnixNano(), Logical: 5, Node: 1})
+	if ts := c.Now(); ts.Wall != time.Unix(200, 0).UnixNano() || ts.Logical != 6 {
+		t.Fatalf("after observing: got %v", ts)
+	}
+	ft.t = time.Unix(300, 0)
+	if ts := c.Now(); ts.Wall != ft.t.UnixNano() || ts.Logical != 0 {
+		t.Fatalf("after time moved: got %v", ts)
+	}
+	if d := c.Offset(Timestamp{Wall: time.Unix(310, 0).UnixNano()}); d != 10*time.Second {
+		t.Fatalf("offset: got %v", d)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	ts := Timestamp{Wall: 1, Logical: 2, Node: 3}
+	for _, r := range []Record{{Time: ts, Value: []byte("v")}, {Time: ts, Value: []byte{}}, {Time: ts, Deleted: true}} {
+		got, err := DecodeRecord(r.Append(nil))
+		if err != nil || got.Time != r.Time || got.Deleted != r.Deleted || !bytes.Equal(got.Value, r.Value) {
+			t.Errorf("%+v: got %+v, %v", r, got, err)
+		}
+	}
+	for _, b := range [][]byte{nil, []byte("value"), append(Record{Time: ts, Deleted: true}.Append(nil), 'x')} {
+		if _, err := DecodeRecord(b); err != ErrInvalidRecord {
+			t.Errorf("%q: got %v", b, err)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	tA, tB := &fakeTime{time.Unix(100, 0)}, &fakeTime{time.Unix(100, 0)}
+	a := openDB(t, &Options{Node: 1, Now: tA.now})
+	b := openDB(t, &Options{Node: 2, Now: tB.now})
+
+	// Same wall time and logical counter, the greatest node wins.
+	a.Put([]byte("tie"), []byte("a"), nil)
+	b.Put([]byte("tie"), []byte("b"), nil)
+	a.Put([]byte("both"), []byte("a"), nil)
+	a.Put([]byte("only-a"), []byte("a"), nil)
+	tB.t = time.Unix(101, 0)
+	b.Put([]byte("both"), []byte("b"), nil)
+	b.Put([]byte("deleted"), []byte("b"), nil)
+	tA.t = time.Unix(102, 0)
+	a.Delete([]byte("deleted"), nil)
+
+	// Merge both ways, through copies of the DBs.
+	copyDB := func(d *DB, o *Options) *DB {
+		c := openDB(t, o)
+		if _, err := c.MergeDB(d.db); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	ab := copyDB(a, &Options{Node: 1, Now: tA.now})
+	if stats, err := ab.MergeDB(b.db); err != nil || stats.Applied != 2 || stats.Skipped != 1 {
+		t.Fatalf("merge b into a: got %+v, %v", stats, err)
+	}
+	ba := copyDB(b, &Options{Node: 2, Now: tB.now})
+	if stats, err := ba.MergeDB(a.db); err != nil || stats.Applied != 2 || stats.Skipped != 2 {
+		t.Fatalf("merge a into b: got %+v, %v", stats, err)
+	}
+	diff := leveldb.Diff(ab.db, ba.db, nil)
+	for diff.Next() {
+		t.Errorf("%v %q", diff.Kind(), diff.Key())
+	}
+	diff.Release()
+
+	for key, want := range map[string]string{"both": "b", "only-a": "a", "tie": "b"} {
+		if v, err := ab.Get([]byte(key)); string(v) != want {
+			t.Errorf("%s: got %q, %v, want %q", key, v, err, want)
+		}
+	}
+	if _, err := ab.Get([]byte("deleted")); err != leveldb.ErrNotFound {
+		t.Errorf("deleted: got %v", err)
+	}
+
+	// Later writes win over the records merged, even from a clock ahead.
+	tA.t = time.Unix(200, 0)
+	a.Put([]byte("ahead"), []byte("a"), nil)
+	if _, err := b.MergeDB(a.db); err != nil {
+		t.Fatal(err)
+	}
+	b.Put([]byte("ahead"), []byte("b"), nil)
+	if stats, err := b.MergeDB(a.db); err != nil || stats.Applied != 0 {
+		t.Fatalf("merge again: got %+v, %v", stats, err)
+	}
+	if v, _ := b.Get([]byte("ahead")); string(v) != "b" {
+		t.Fatalf("ahead: got %q", v)
+	}
+}
+
+func TestImport(t *testing.T) {
+	tA, tB := &fakeTime{time.Unix(100, 0)}, &fakeTime{time.Unix(100, 0)}
+	a := openDB(t, &Options{Node: 1, Now: tA.now})
+	b := openDB(t, &Options{Node: 2, Now: tB.now, MaxOffset: time.Minute})
+	a.Put([]byte("k1"), []byte("a"), nil)
+	tB.t = time.Unix(101, 0)
+	b.Put([]byte("k1"), []byte("b"), nil)
+	a.Put([]byte("k2"), []byte("a"), nil)
+
+	var buf bytes.Buffer
+	if _, err := a.db.Export(&buf, leveldb.ExportJSONL, nil); err != nil {
+		t.Fatal(err)
+	}
+	export := buf.Bytes()
+	if stats, err := b.Import(bytes.NewReader(export), leveldb.ExportJSONL); err != nil || stats.Applied != 1 || stats.Skipped != 1 {
+		t.Fatalf("import: got %+v, %v", stats, err)
+	}
+	if v, _ := b.Get([]byte("k1")); string(v) != "b" {
+		t.Fatalf("k1: got %q", v)
+	}
+
+	tA.t = time.Unix(1000, 0)
+	a.Put([]byte("k3"), []byte("a"), nil)
+	buf.Reset()
+	a.db.Export(&buf, leveldb.ExportJSONL, nil)
+	if _, err := b.Import(&buf, leveldb.ExportJSONL); err == nil {
+		t.Fatal("imported a record ahead of the clock")
+	}
+}
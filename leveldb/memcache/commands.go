@@ -0,0 +1,346 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package memcache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// errBadChunk is returned when a data block isn't terminated by CRLF; the
+// connection is then out of sync with the client and is closed.
+var errBadChunk = errors.New("memcache: bad data chunk")
+
+// Exptimes larger than this are Unix times rather than relative, as
+// memcached.
+const maxRelativeExptime = 30 * 24 * 60 * 60
+
+// Executes a command and writes its reply, returning whether the client
+// quits. An error means the connection can't be served anymore.
+func (c *client) execute(fields [][]byte) (quit bool, err error) {
+	name, args := string(fields[0]), fields[1:]
+	switch name {
+	case "get":
+		return false, c.get(args)
+	case "set", "add", "replace":
+		return false, c.store(name, args)
+	case "delete":
+		return false, c.delete(args)
+	case "touch":
+		return false, c.touch(args)
+	case "version":
+		c.writeLine("VERSION " + Version)
+	case "quit":
+		return true, nil
+	default:
+		c.writeLine("ERROR")
+	}
+	return false, nil
+}
+
+// Strips a trailing noreply from the arguments.
+func noreply(args [][]byte) ([][]byte, bool) {
+	if n := len(args); n > 0 && string(args[n-1]) == "noreply" {
+		return args[:n-1], true
+	}
+	return args, false
+}
+
+func validKey(key []byte) bool {
+	return len(key) <= maxKeyLen
+}
+
+// Replies the server error, unless it's nil.
+func (c *client) serverError(err error) error {
+	if err != nil {
+		c.writeLine("SERVER_ERROR " + err.Error())
+	}
+	return nil
+}
+
+func (c *client) get(args [][]byte) error {
+	if len(args) == 0 {
+		c.writeLine("ERROR")
+		return nil
+	}
+	for _, key := range args {
+		if !validKey(key) {
+			c.writeLine("CLIENT_ERROR bad command line format")
+			return nil
+		}
+	}
+	for _, key := range args {
+		value, m, err := c.s.lookup(key)
+		if err != nil {
+			return c.serverError(err)
+		}
+		if value != nil {
+			c.writeValue(key, m.flags, value)
+		}
+	}
+	c.writeLine("END")
+	return nil
+}
+
+// Serves set, add and replace: <cmd> <key> <flags> <exptime> <bytes>
+// [noreply], followed by the data block.
+func (c *client) store(cmd string, args [][]byte) error {
+	args, quiet := noreply(args)
+	if len(args) != 4 {
+		c.writeLine("ERROR")
+		return nil
+	}
+	n, err := strconv.Atoi(string(args[3]))
+	if err != nil || n < 0 {
+		// The data block can't be skipped.
+		c.writeLine("CLIENT_ERROR bad command line format")
+		return errBadChunk
+	}
+	if n > c.s.maxItemSize {
+		if _, err := c.r.Discard(n + 2); err != nil {
+			return err
+		}
+		c.writeLine("SERVER_ERROR object too large for cache")
+		return nil
+	}
+	value, err := c.readData(n)
+	if err != nil {
+		if err == errBadChunk {
+			c.writeLine("CLIENT_ERROR bad data chunk")
+		}
+		return err
+	}
+	key := args[0]
+	flags, err1 := strconv.ParseUint(string(args[1]), 10, 32)
+	exptime, err2 := strconv.ParseInt(string(args[2]), 10, 64)
+	if err1 != nil || err2 != nil || !validKey(key) {
+		c.writeLine("CLIENT_ERROR bad command line format")
+		return nil
+	}
+	if c.s.reserved(key) {
+		c.writeLine("CLIENT_ERROR key is reserved")
+		return nil
+	}
+
+	s := c.s
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if cmd != "set" {
+		old, _, err := s.get(key)
+		if err != nil {
+			return c.serverError(err)
+		}
+		if cmd == "add" && old != nil || cmd == "replace" && old == nil {
+			if !quiet {
+				c.writeLine("NOT_STORED")
+			}
+			return nil
+		}
+	}
+	b := new(leveldb.Batch)
+	if dl, expired := deadline(exptime); expired {
+		b.Delete(key)
+		b.Delete(s.metaKey(key))
+	} else {
+		b.Put(key, value)
+		s.putMeta(b, key, meta{uint32(flags), dl})
+	}
+	if err := s.db.Write(b, nil); err != nil {
+		return c.serverError(err)
+	}
+	if !quiet {
+		c.writeLine("STORED")
+	}
+	return nil
+}
+
+// Serves delete <key> [0] [noreply].
+func (c *client) delete(args [][]byte) error {
+	args, quiet := noreply(args)
+	if len(args) == 2 && string(args[1]) == "0" {
+		args = args[:1]
+	}
+	if len(args) != 1 {
+		c.writeLine("CLIENT_ERROR bad command line format.  Usage: delete <key> [noreply]")
+		return nil
+	}
+	key := args[0]
+	if !validKey(key) {
+		c.writeLine("CLIENT_ERROR bad command line format")
+		return nil
+	}
+
+	s := c.s
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	value, _, err := s.get(key)
+	if err != nil {
+		return c.serverError(err)
+	}
+	reply := "NOT_FOUND"
+	if value != nil {
+		reply = "DELETED"
+	}
+	// Delete an expired item too.
+	if !s.reserved(key) {
+		b := new(leveldb.Batch)
+		b.Delete(key)
+		b.Delete(s.metaKey(key))
+		if err := s.db.Write(b, nil); err != nil {
+			return c.serverError(err)
+		}
+	}
+	if !quiet {
+		c.writeLine(reply)
+	}
+	return nil
+}
+
+// Serves touch <key> <exptime> [noreply].
+func (c *client) touch(args [][]byte) error {
+	args, quiet := noreply(args)
+	if len(args) != 2 {
+		c.writeLine("ERROR")
+		return nil
+	}
+	key := args[0]
+	exptime, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil || !validKey(key) {
+		c.writeLine("CLIENT_ERROR bad command line format")
+		return nil
+	}
+
+	s := c.s
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	value, m, err := s.get(key)
+	if err != nil {
+		return c.serverError(err)
+	}
+	if value == nil {
+		if !quiet {
+			c.writeLine("NOT_FOUND")
+		}
+		return nil
+	}
+	b := new(leveldb.Batch)
+	dl, expired := deadline(exptime)
+	if expired {
+		b.Delete(key)
+		b.Delete(s.metaKey(key))
+	} else {
+		s.putMeta(b, key, meta{m.flags, dl})
+	}
+	if err := s.db.Write(b, nil); err != nil {
+		return c.serverError(err)
+	}
+	if !quiet {
+		c.writeLine("TOUCHED")
+	}
+	return nil
+}
+
+// Returns the deadline of an exptime, in Unix milliseconds, zero if none,
+// or whether the item expires at once.
+func deadline(exptime int64) (dl int64, expired bool) {
+	now := time.Now()
+	switch {
+	case exptime == 0:
+		return 0, false
+	case exptime < 0:
+		return 0, true
+	case exptime <= maxRelativeExptime:
+		return now.Add(time.Duration(exptime) * time.Second).UnixMilli(), false
+	}
+	dl = exptime * 1000
+	return dl, dl <= now.UnixMilli()
+}
+
+// meta holds the flags and the deadline of an item, stored under the meta
+// prefix unless both are zero.
+type meta struct {
+	flags uint32
+	dl    int64
+}
+
+func (s *Server) reserved(key []byte) bool {
+	return bytes.HasPrefix(key, s.metaPrefix)
+}
+
+func (s *Server) metaKey(key []byte) []byte {
+	return append(append([]byte(nil), s.metaPrefix...), key...)
+}
+
+func (s *Server) putMeta(b *leveldb.Batch, key []byte, m meta) {
+	if m == (meta{}) {
+		b.Delete(s.metaKey(key))
+		return
+	}
+	v := binary.BigEndian.AppendUint32(nil, m.flags)
+	v = binary.BigEndian.AppendUint64(v, uint64(m.dl))
+	b.Put(s.metaKey(key), v)
+}
+
+// Returns the meta of an item. A malformed meta is ignored.
+func (s *Server) meta(key []byte) (meta, error) {
+	v, err := s.db.Get(s.metaKey(key), nil)
+	if err == leveldb.ErrNotFound || err == nil && len(v) != 12 {
+		return meta{}, nil
+	}
+	if err != nil {
+		return meta{}, err
+	}
+	return meta{binary.BigEndian.Uint32(v), int64(binary.BigEndian.Uint64(v[4:]))}, nil
+}
+
+// Returns the value of an item and its meta, or a nil value if the item
+// doesn't exist or has expired.
+func (s *Server) get(key []byte) (value []byte, m meta, err error) {
+	if s.reserved(key) {
+		return nil, meta{}, nil
+	}
+	value, err = s.db.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, meta{}, nil
+	}
+	if err != nil {
+		return nil, meta{}, err
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	if m, err = s.meta(key); err != nil {
+		return nil, meta{}, err
+	}
+	if m.dl != 0 && m.dl <= time.Now().UnixMilli() {
+		return nil, m, nil
+	}
+	return value, m, nil
+}
+
+// As get, deleting the item if it has expired.
+func (s *Server) lookup(key []byte) (value []byte, m meta, err error) {
+	value, m, err = s.get(key)
+	if err != nil || value != nil || m.dl == 0 {
+		return
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	// It may have been set again meanwhile.
+	if value, m, err = s.get(key); err != nil || value != nil || m.dl == 0 {
+		return
+	}
+	b := new(leveldb.Batch)
+	b.Delete(key)
+	b.Delete(s.metaKey(key))
+	return nil, meta{}, s.db.Write(b, nil)
+}
@@ -0,0 +1,250 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package memcache serves a subset of the memcached text protocol on top of
+// a DB, so that memcache clients can be pointed at a persistent store:
+//
+//	l, err := net.Listen("tcp", "127.0.0.1:11211")
+//	...
+//	go memcache.NewServer(db, nil).Serve(l)
+//
+// The supported commands are get, set, add, replace, delete, touch,
+// version and quit. Values are stored as is under their keys, so the DB
+// may be shared with other code; there is no CAS and no eviction.
+//
+// The DB has no native expiry, so the flags and deadlines of items are
+// stored under Options.MetaPrefix, and expired items are deleted when they
+// are accessed. An encrypted DB is served decrypted and memcached has no
+// authentication; use a listener on a trusted network or wrapped with TLS.
+package memcache
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// Options holds the optional parameters of a Server.
+type Options struct {
+	// MetaPrefix is the prefix of the keys holding the flags and the
+	// expiry deadlines of the items. Keys starting with it are hidden from
+	// the clients.
+	//
+	// The default is "\xff\xffmemcache.meta/".
+	MetaPrefix []byte
+
+	// MaxItemSize is the largest value accepted from a client.
+	//
+	// The default is 1MiB, as memcached.
+	MaxItemSize int
+}
+
+// DefaultMetaPrefix is the default Options.MetaPrefix.
+var DefaultMetaPrefix = []byte("\xff\xffmemcache.meta/")
+
+// Version is the version replied to the version command.
+const Version = "1.6.0-goleveldb"
+
+// Longest key accepted, as memcached.
+const maxKeyLen = 250
+
+// Server serves the memcached text protocol on top of a DB. It's safe for
+// concurrent use.
+type Server struct {
+	db          *leveldb.DB
+	metaPrefix  []byte
+	maxItemSize int
+
+	// Serializes the writes, so that deleting an expired item doesn't race
+	// with setting it again.
+	writeMu sync.Mutex
+
+	mu        sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+}
+
+// NewServer returns a Server for the given DB. The options may be nil.
+func NewServer(db *leveldb.DB, o *Options) *Server {
+	s := &Server{
+		db:          db,
+		metaPrefix:  DefaultMetaPrefix,
+		maxItemSize: 1 << 20,
+		listeners:   make(map[net.Listener]struct{}),
+		conns:       make(map[net.Conn]struct{}),
+	}
+	if o != nil {
+		if len(o.MetaPrefix) > 0 {
+			s.metaPrefix = o.MetaPrefix
+		}
+		if o.MaxItemSize > 0 {
+			s.maxItemSize = o.MaxItemSize
+		}
+	}
+	return s
+}
+
+// ErrServerClosed is returned by Serve after Close.
+var ErrServerClosed = errors.New("memcache: server closed")
+
+// Serve accepts the connections of the listener, serving each in its own
+// goroutine, until the listener fails or the server is closed. It closes
+// the listener.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves a single connection until the client quits or the
+// connection fails, and closes it.
+func (s *Server) ServeConn(conn net.Conn) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.conns[conn] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	c := &client{
+		s: s,
+		r: bufio.NewReader(conn),
+		w: bufio.NewWriter(conn),
+	}
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			if err == bufio.ErrBufferFull {
+				c.w.WriteString("CLIENT_ERROR line too long\r\n")
+				c.w.Flush()
+			}
+			return
+		}
+		fields := bytes.Fields(line)
+		if len(fields) == 0 {
+			c.w.WriteString("ERROR\r\n")
+			continue
+		}
+		quit, err := c.execute(fields)
+		if err != nil {
+			// The connection is out of sync with the client.
+			c.w.Flush()
+			return
+		}
+		// Pipelined commands are answered together.
+		if c.r.Buffered() == 0 || quit {
+			if err := c.w.Flush(); err != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// Close closes the listeners and the connections. It doesn't close the DB.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return nil
+}
+
+// client is the state of a connection.
+type client struct {
+	s *Server
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// Reads a command line, without its CRLF. Lines longer than the buffer
+// fail with bufio.ErrBufferFull. The line is copied, as the data block may
+// be read before it's used.
+func (c *client) readLine() ([]byte, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err != nil {
+		if err == io.EOF && len(line) != 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	line = bytes.TrimSuffix(line[:len(line)-1], []byte("\r"))
+	return append([]byte(nil), line...), nil
+}
+
+// Reads the data block of a storage command, of n bytes plus CRLF.
+func (c *client) readData(n int) ([]byte, error) {
+	data := make([]byte, n+2)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if data[n] != '\r' || data[n+1] != '\n' {
+		return nil, errBadChunk
+	}
+	return data[:n], nil
+}
+
+func (c *client) writeLine(s string) {
+	c.w.WriteString(s + "\r\n")
+}
+
+func (c *client) writeValue(key []byte, flags uint32, value []byte) {
+	c.w.WriteString("VALUE ")
+	c.w.Write(key)
+	c.w.WriteString(" " + strconv.FormatUint(uint64(flags), 10) + " " + strconv.Itoa(len(value)) + "\r\n")
+	c.w.Write(value)
+	c.w.WriteString("\r\n")
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package memcache
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// Sends a request and returns the reply lines up to the given terminal
+// line, or the first one if none is given, joined by "|".
+func (c *testClient) do(req string, end ...string) string {
+	c.t.Helper()
+	if _, err := io.WriteString(c.conn, req); err != nil {
+		c.t.Fatal(err)
+	}
+	var lines []string
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\r\n")
+		lines = append(lines, line)
+		if len(end) == 0 || line == end[0] || strings.HasSuffix(line, "ERROR") {
+			return strings.Join(lines, "|")
+		}
+	}
+}
+
+func newTestServer(t *testing.T, o *Options) (*leveldb.DB, func() *testClient) {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(db, o)
+	done := make(chan error)
+	go func() { done <- s.Serve(l) }()
+	t.Cleanup(func() {
+		s.Close()
+		if err := <-done; err != ErrServerClosed {
+			t.Errorf("Serve: got %v", err)
+		}
+		db.Close()
+	})
+	return db, func() *testClient {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return &testClient{t, conn, bufio.NewReader(conn)}
+	}
+}
+
+func TestServer(t *testing.T) {
+	db, dial := newTestServer(t, &Options{MaxItemSize: 10})
+	c := dial()
+
+	for _, tc := range []struct {
+		req, end, want string
+	}{
+		{"get a\r\n", "END", "END"},
+		{"set a 5 0 3\r\nfoo\r\n", "", "STORED"},
+		{"get a b\r\n", "END", "VALUE a 5 3|foo|END"},
+		{"add a 0 0 1\r\nx\r\n", "", "NOT_STORED"},
+		{"replace b 0 0 1\r\nx\r\n", "", "NOT_STORED"},
+		{"add b 0 0 0\r\n\r\n", "", "STORED"},
+		{"replace a 0 100 3\r\nbar\r\n", "", "STORED"},
+		{"get a b\r\n", "END", "VALUE a 0 3|bar|VALUE b 0 0||END"},
+		{"touch a 0\r\n", "", "TOUCHED"},
+		{"touch c 0\r\n", "", "NOT_FOUND"},
+		{"delete b\r\n", "", "DELETED"},
+		{"delete b\r\n", "", "NOT_FOUND"},
+		{"set c 0 0 11\r\n01234567890\r\n", "", "SERVER_ERROR object too large for cache"},
+		{"set c x 0 1\r\nx\r\n", "", "CLIENT_ERROR bad command line format"},
+		{"set c 0 0 1\r\n", "", ""}, // Pipelined with the data below.
+		{"x\r\nget c\r\n", "END", "STORED|VALUE c 0 1|x|END"},
+		{"set c 0 0 1 noreply\r\ny\r\nget c\r\n", "END", "VALUE c 0 1|y|END"},
+		{"set \xff\xffmemcache.meta/a 0 0 1\r\nx\r\n", "", "CLIENT_ERROR key is reserved"},
+		{"get \xff\xffmemcache.meta/a\r\n", "END", "END"},
+		{"flush_all\r\n", "", "ERROR"},
+		{"version\r\n", "", "VERSION " + Version},
+	} {
+		if tc.want == "" {
+			io.WriteString(c.conn, tc.req)
+			continue
+		}
+		var end []string
+		if tc.end != "" {
+			end = []string{tc.end}
+		}
+		if got := c.do(tc.req, end...); got != tc.want {
+			t.Errorf("%q: got %q, want %q", tc.req, got, tc.want)
+		}
+	}
+
+	// Expiry.
+	c.do("set e 0 -1 1\r\nx\r\n")
+	c.do("set f 0 0 1\r\nx\r\n")
+	c.do("set g 0 1 1\r\nx\r\n")
+	c.do("touch f -1\r\n")
+	if got := c.do("get e f g\r\n", "END"); got != "VALUE g 0 1|x|END" {
+		t.Errorf("get of expired items: got %q", got)
+	}
+	c.do("set h 0 1 1\r\nx\r\n")
+	// A past Unix time.
+	c.do("touch g 1000000000\r\n")
+	if ok, _ := db.Has(append(DefaultMetaPrefix, 'h'), nil); !ok {
+		t.Error("deadline not stored")
+	}
+	if got := c.do("get g\r\n", "END"); got != "END" {
+		t.Errorf("get of expired item: got %q", got)
+	}
+	for _, key := range []string{"e", "f", "g"} {
+		if ok, _ := db.Has([]byte(key), nil); ok {
+			t.Errorf("expired item %q not deleted", key)
+		}
+		if ok, _ := db.Has(append(DefaultMetaPrefix, key...), nil); ok {
+			t.Errorf("meta of expired item %q not deleted", key)
+		}
+	}
+	if dl, expired := deadline(2); expired || time.Until(time.UnixMilli(dl)) > 2*time.Second {
+		t.Errorf("deadline of relative exptime: got %v, %v", dl, expired)
+	}
+
+	if _, err := io.WriteString(c.conn, "quit\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Errorf("connection not closed after quit: %v", err)
+	}
+
+	c = dial()
+	if got := c.do("set a 0 0 1\r\nxy\r\n"); got != "CLIENT_ERROR bad data chunk" {
+		t.Errorf("bad data chunk: got %q", got)
+	}
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Errorf("connection not closed after bad data chunk: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package memdb
+
+const arenaBlockSize = 64 << 10
+
+// arena is an append-only byte allocator backed by fixed-size blocks.
+// Unlike a growing slice, it never copies existing data when it needs more
+// room, and blocks are allocated lazily and kept across reset. An
+// allocation is addressed by its offset, block index times block size
+// plus position within the block; allocations larger than a block get a
+// dedicated block and reserve the offset space of as many blocks as they
+// span.
+type arena struct {
+	blocks   [][]byte
+	pos      int
+	capacity int
+}
+
+func (a *arena) block(i, n int) []byte {
+	for len(a.blocks) <= i {
+		a.blocks = append(a.blocks, nil)
+	}
+	if len(a.blocks[i]) < n {
+		a.blocks[i] = make([]byte, n)
+	}
+	return a.blocks[i]
+}
+
+// alloc copies key and value into the arena, contiguously, and returns
+// their offset.
+func (a *arena) alloc(key, value []byte) int {
+	n := len(key) + len(value)
+	i, p := a.pos/arenaBlockSize, a.pos%arenaBlockSize
+	if p > 0 && p+n > arenaBlockSize {
+		i, p = i+1, 0
+	}
+	var b []byte
+	if n > arenaBlockSize {
+		b = a.block(i, n)
+		end := i + (n+arenaBlockSize-1)/arenaBlockSize
+		// The spanned slots are reserved, so that cap covers them.
+		for len(a.blocks) < end {
+			a.blocks = append(a.blocks, nil)
+		}
+		a.pos = end * arenaBlockSize
+	} else {
+		b = a.block(i, arenaBlockSize)
+		a.pos = i*arenaBlockSize + p + n
+	}
+	copy(b[p:], key)
+	copy(b[p+len(key):], value)
+	return i*arenaBlockSize + p
+}
+
+// get returns n bytes located skip bytes after the given allocation offset.
+func (a *arena) get(off, skip, n int) []byte {
+	b := a.blocks[off/arenaBlockSize]
+	p := off%arenaBlockSize + skip
+	return b[p : p+n]
+}
+
+// size returns the offset space in use.
+func (a *arena) size() int {
+	return a.pos
+}
+
+// cap returns the advisory capacity, or the reserved offset space if the
+// arena has grown beyond it.
+func (a *arena) cap() int {
+	if n := len(a.blocks) * arenaBlockSize; n > a.capacity {
+		return n
+	}
+	return a.capacity
+}
+
+// reset releases all allocations at once. Regular blocks are kept for
+// reuse, oversized ones are dropped.
+func (a *arena) reset() {
+	for i, b := range a.blocks {
+		if len(b) != arenaBlockSize {
+			a.blocks[i] = nil
+		}
+	}
+	a.pos = 0
+}
+
+func newArena(capacity int) arena {
+	return arena{capacity: capacity}
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package memdb
+
+import (
+	"sync/atomic"
+)
+
+// Number of probes per key; optimal for about 10 bits per key.
+const filterProbes = 6
+
+// filter is a bloom filter over key prefixes. It is safe for concurrent
+// use; bits are only ever set, until reset.
+type filter struct {
+	prefix func(key []byte) []byte
+	words  []uint64
+}
+
+func (f *filter) keyPrefix(key []byte) []byte {
+	if f.prefix == nil {
+		return key
+	}
+	return f.prefix(key)
+}
+
+func (f *filter) add(key []byte) {
+	nbits := uint64(len(f.words)) * 64
+	h := hashPrefix(f.keyPrefix(key))
+	delta := h>>33 | h<<31
+	for i := 0; i < filterProbes; i++ {
+		bit := h % nbits
+		atomic.OrUint64(&f.words[bit/64], 1<<(bit%64))
+		h += delta
+	}
+}
+
+func (f *filter) mayContain(key []byte) bool {
+	nbits := uint64(len(f.words)) * 64
+	h := hashPrefix(f.keyPrefix(key))
+	delta := h>>33 | h<<31
+	for i := 0; i < filterProbes; i++ {
+		bit := h % nbits
+		if atomic.LoadUint64(&f.words[bit/64])&(1<<(bit%64)) == 0 {
+			return false
+		}
+		h += delta
+	}
+	return true
+}
+
+func (f *filter) reset() {
+	for i := range f.words {
+		atomic.StoreUint64(&f.words[i], 0)
+	}
+}
+
+func newFilter(bits int, prefix func(key []byte) []byte) *filter {
+	if bits < 64 {
+		bits = 64
+	}
+	return &filter{
+		prefix: prefix,
+		words:  make([]uint64, (bits+63)/64),
+	}
+}
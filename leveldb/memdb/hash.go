@@ -0,0 +1,179 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package memdb
+
+import (
+	"bytes"
+
+	"github.com/syndtr/goleveldb/leveldb/comparer"
+)
+
+// Maximum number of nodes walked from the hash index before falling back to
+// skiplist search.
+const hashMaxWalk = 16
+
+// HashDB is an in-memory key/value database which, in addition to the
+// skiplist, maintains a hash index from key prefix to the first entry having
+// that prefix. Lookups of keys whose prefix is already in the DB are served
+// from the index without searching the skiplist, which makes point lookups
+// of hot keys cheaper. Lookups of other keys and iteration behave the same
+// as DB.
+//
+// The prefix function must return a prefix of the given key such that keys
+// sharing a prefix are contiguous in the comparer order.
+type HashDB struct {
+	*DB
+	prefix func(key []byte) []byte
+	index  map[uint64]int
+}
+
+func hashPrefix(b []byte) uint64 {
+	// FNV-1a.
+	h := uint64(14695981039346656037)
+	for _, c := range b {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+	return h
+}
+
+func (p *HashDB) keyPrefix(key []byte) []byte {
+	if p.prefix == nil {
+		return key
+	}
+	return p.prefix(key)
+}
+
+// Must hold RW-lock.
+func (p *HashDB) findGE(key []byte) (int, bool) {
+	pre := p.keyPrefix(key)
+	if node, ok := p.index[hashPrefix(pre)]; ok && bytes.Equal(p.keyPrefix(p.nodeKey(node)), pre) {
+		for i := 0; i < hashMaxWalk && node != 0; i++ {
+			if cmp := p.cmp.Compare(p.nodeKey(node), key); cmp >= 0 {
+				return node, cmp == 0
+			}
+			node = p.nodeData[node+nNext]
+		}
+	}
+	return p.DB.findGE(key, false)
+}
+
+// Put sets the value for the given key. It overwrites any previous value
+// for that key; a DB is not a multi-map.
+//
+// It is safe to modify the contents of the arguments after Put returns.
+func (p *HashDB) Put(key []byte, value []byte) error {
+	if p.filter != nil {
+		p.filter.add(key)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	node := p.put(key, value)
+	// The node is the first having its prefix if its predecessor doesn't
+	// share it.
+	pre := p.keyPrefix(key)
+	if prev := p.prevNode[0]; prev == 0 || !bytes.Equal(p.keyPrefix(p.nodeKey(prev)), pre) {
+		p.index[hashPrefix(pre)] = node
+	}
+	return nil
+}
+
+// Delete deletes the value for the given key. It returns ErrNotFound if
+// the DB does not contain the key.
+//
+// It is safe to modify the contents of the arguments after Delete returns.
+func (p *HashDB) Delete(key []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	node := p.delete(key)
+	if node == 0 {
+		return ErrNotFound
+	}
+	pre := p.keyPrefix(key)
+	h := hashPrefix(pre)
+	if p.index[h] == node {
+		if next := p.nodeData[node+nNext]; next != 0 && bytes.Equal(p.keyPrefix(p.nodeKey(next)), pre) {
+			p.index[h] = next
+		} else {
+			delete(p.index, h)
+		}
+	}
+	return nil
+}
+
+// Contains returns true if the given key are in the DB.
+//
+// It is safe to modify the contents of the arguments after Contains returns.
+func (p *HashDB) Contains(key []byte) bool {
+	p.mu.RLock()
+	_, exact := p.findGE(key)
+	p.mu.RUnlock()
+	return exact
+}
+
+// Get gets the value for the given key. It returns error.ErrNotFound if the
+// DB does not contain the key.
+//
+// The caller should not modify the contents of the returned slice, but
+// it is safe to modify the contents of the argument after Get returns.
+func (p *HashDB) Get(key []byte) (value []byte, err error) {
+	p.mu.RLock()
+	if node, exact := p.findGE(key); exact {
+		value = p.nodeValue(node)
+	} else {
+		err = ErrNotFound
+	}
+	p.mu.RUnlock()
+	return
+}
+
+// Find finds key/value pair whose key is greater than or equal to the
+// given key. It returns ErrNotFound if the table doesn't contain
+// such pair.
+//
+// The caller should not modify the contents of the returned slice, but
+// it is safe to modify the contents of the argument after Find returns.
+func (p *HashDB) Find(key []byte) (rkey, value []byte, err error) {
+	p.mu.RLock()
+	if node, _ := p.findGE(key); node != 0 {
+		rkey = p.nodeKey(node)
+		value = p.nodeValue(node)
+	} else {
+		err = ErrNotFound
+	}
+	p.mu.RUnlock()
+	return
+}
+
+// Reset resets the DB to initial empty state. Allows reuse the buffer.
+func (p *HashDB) Reset() {
+	if p.filter != nil {
+		p.filter.reset()
+	}
+
+	p.mu.Lock()
+	p.reset()
+	clear(p.index)
+	p.mu.Unlock()
+}
+
+// NewHash creates a new initialized hash indexed in-memory key/value DB.
+// The prefix function extracts the indexed prefix of a key, if nil the
+// whole key is indexed. The capacity is the initial key/value buffer
+// capacity. The capacity is advisory, not enforced.
+//
+// The returned DB instance is safe for concurrent use.
+func NewHash(cmp comparer.BasicComparer, capacity int, prefix func(key []byte) []byte) *HashDB {
+	return &HashDB{
+		DB:     New(cmp, capacity),
+		prefix: prefix,
+		index:  make(map[uint64]int),
+	}
+}
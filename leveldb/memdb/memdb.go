@@ -8,6 +8,7 @@
 package memdb
 
 import (
+	"bytes"
 	"math/rand"
 	"sync"
 
@@ -25,6 +26,51 @@ var (
 
 const tMaxHeight = 12
 
+// Memtable is an in-memory sorted key/value table. Both DB and HashDB
+// implement Memtable.
+type Memtable interface {
+	// Put sets the value for the given key, overwriting any previous value.
+	Put(key []byte, value []byte) error
+
+	// Delete deletes the value for the given key. It returns ErrNotFound
+	// if the table does not contain the key.
+	Delete(key []byte) error
+
+	// Contains returns true if the given key are in the table.
+	Contains(key []byte) bool
+
+	// MayContain returns false if the table definitely does not contain
+	// any key having the same prefix as the given key.
+	MayContain(key []byte) bool
+
+	// Get gets the value for the given key. It returns ErrNotFound if the
+	// table does not contain the key.
+	Get(key []byte) (value []byte, err error)
+
+	// Find finds key/value pair whose key is greater than or equal to the
+	// given key. It returns ErrNotFound if the table doesn't contain
+	// such pair.
+	Find(key []byte) (rkey, value []byte, err error)
+
+	// NewIterator returns an iterator of the table.
+	NewIterator(slice *util.Range) iterator.Iterator
+
+	// Capacity returns keys/values buffer capacity.
+	Capacity() int
+
+	// Size returns sum of keys and values length.
+	Size() int
+
+	// Free returns keys/values free buffer before need to grow.
+	Free() int
+
+	// Len returns the number of entries in the table.
+	Len() int
+
+	// Reset resets the table to initial empty state.
+	Reset()
+}
+
 type dbIter struct {
 	util.BasicReleaser
 	p          *DB
@@ -37,9 +83,7 @@ type dbIter struct {
 
 func (i *dbIter) fill(checkStart, checkLimit bool) bool {
 	if i.node != 0 {
-		n := i.p.nodeData[i.node]
-		m := n + i.p.nodeData[i.node+nKey]
-		i.key = i.p.kvData[n:m]
+		i.key = i.p.nodeKey(i.node)
 		if i.slice != nil {
 			switch {
 			case checkLimit && i.slice.Limit != nil && i.p.cmp.Compare(i.key, i.slice.Limit) >= 0:
@@ -49,7 +93,7 @@ func (i *dbIter) fill(checkStart, checkLimit bool) bool {
 				goto bail
 			}
 		}
-		i.value = i.p.kvData[m : m+i.p.nodeData[i.node+nVal]]
+		i.value = i.p.nodeValue(i.node)
 		return true
 	}
 bail:
@@ -184,7 +228,7 @@ type DB struct {
 	rnd *rand.Rand
 
 	mu     sync.RWMutex
-	kvData []byte
+	kvData arena
 	// Node data:
 	// [0]         : KV offset
 	// [1]         : Key length
@@ -196,6 +240,45 @@ type DB struct {
 	maxHeight int
 	n         int
 	kvSize    int
+
+	// Independent sub-DBs of a sharded DB, nil if not sharded. Keys are
+	// distributed by the hash of their prefix so writers on different
+	// shards don't contend, and the keys sharing a prefix are in a single
+	// shard.
+	shards      []*DB
+	shardPrefix func(key []byte) []byte
+
+	// Optional bloom filter over key prefixes.
+	filter *filter
+}
+
+func (p *DB) keyPrefix(key []byte) []byte {
+	if p.shardPrefix == nil {
+		return key
+	}
+	return p.shardPrefix(key)
+}
+
+func (p *DB) shardIndex(key []byte) int {
+	// FNV-1a.
+	h := uint32(2166136261)
+	for _, c := range p.keyPrefix(key) {
+		h ^= uint32(c)
+		h *= 16777619
+	}
+	return int(h % uint32(len(p.shards)))
+}
+
+func (p *DB) shard(key []byte) *DB {
+	return p.shards[p.shardIndex(key)]
+}
+
+func (p *DB) nodeKey(node int) []byte {
+	return p.kvData.get(p.nodeData[node], 0, p.nodeData[node+nKey])
+}
+
+func (p *DB) nodeValue(node int) []byte {
+	return p.kvData.get(p.nodeData[node], p.nodeData[node+nKey], p.nodeData[node+nVal])
 }
 
 func (p *DB) randHeight() (h int) {
@@ -215,8 +298,7 @@ func (p *DB) findGE(key []byte, prev bool) (int, bool) {
 		next := p.nodeData[node+nNext+h]
 		cmp := 1
 		if next != 0 {
-			o := p.nodeData[next]
-			cmp = p.cmp.Compare(p.kvData[o:o+p.nodeData[next+nKey]], key)
+			cmp = p.cmp.Compare(p.nodeKey(next), key)
 		}
 		if cmp < 0 {
 			// Keep searching in this list
@@ -240,8 +322,7 @@ func (p *DB) findLT(key []byte) int {
 	h := p.maxHeight - 1
 	for {
 		next := p.nodeData[node+nNext+h]
-		o := p.nodeData[next]
-		if next == 0 || p.cmp.Compare(p.kvData[o:o+p.nodeData[next+nKey]], key) >= 0 {
+		if next == 0 || p.cmp.Compare(p.nodeKey(next), key) >= 0 {
 			if h == 0 {
 				break
 			}
@@ -275,18 +356,28 @@ func (p *DB) findLast() int {
 //
 // It is safe to modify the contents of the arguments after Put returns.
 func (p *DB) Put(key []byte, value []byte) error {
+	if p.filter != nil {
+		p.filter.add(key)
+	}
+	if p.shards != nil {
+		return p.shard(key).Put(key, value)
+	}
+
 	p.mu.Lock()
-	defer p.mu.Unlock()
+	p.put(key, value)
+	p.mu.Unlock()
+	return nil
+}
 
+// Must hold RW-lock. Returns the node of the key.
+func (p *DB) put(key []byte, value []byte) int {
 	if node, exact := p.findGE(key, true); exact {
-		kvOffset := len(p.kvData)
-		p.kvData = append(p.kvData, key...)
-		p.kvData = append(p.kvData, value...)
+		kvOffset := p.kvData.alloc(key, value)
 		p.nodeData[node] = kvOffset
 		m := p.nodeData[node+nVal]
 		p.nodeData[node+nVal] = len(value)
 		p.kvSize += len(value) - m
-		return nil
+		return node
 	}
 
 	h := p.randHeight()
@@ -297,9 +388,7 @@ func (p *DB) Put(key []byte, value []byte) error {
 		p.maxHeight = h
 	}
 
-	kvOffset := len(p.kvData)
-	p.kvData = append(p.kvData, key...)
-	p.kvData = append(p.kvData, value...)
+	kvOffset := p.kvData.alloc(key, value)
 	// Node
 	node := len(p.nodeData)
 	p.nodeData = append(p.nodeData, kvOffset, len(key), len(value), h)
@@ -311,7 +400,7 @@ func (p *DB) Put(key []byte, value []byte) error {
 
 	p.kvSize += len(key) + len(value)
 	p.n++
-	return nil
+	return node
 }
 
 // Delete deletes the value for the given key. It returns ErrNotFound if
@@ -319,12 +408,25 @@ func (p *DB) Put(key []byte, value []byte) error {
 //
 // It is safe to modify the contents of the arguments after Delete returns.
 func (p *DB) Delete(key []byte) error {
+	if p.shards != nil {
+		return p.shard(key).Delete(key)
+	}
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	if p.delete(key) == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Must hold RW-lock. Returns the unlinked node, or zero if the key is not
+// found. The node next pointers are left intact.
+func (p *DB) delete(key []byte) int {
 	node, exact := p.findGE(key, true)
 	if !exact {
-		return ErrNotFound
+		return 0
 	}
 
 	h := p.nodeData[node+nHeight]
@@ -335,13 +437,17 @@ func (p *DB) Delete(key []byte) error {
 
 	p.kvSize -= p.nodeData[node+nKey] + p.nodeData[node+nVal]
 	p.n--
-	return nil
+	return node
 }
 
 // Contains returns true if the given key are in the DB.
 //
 // It is safe to modify the contents of the arguments after Contains returns.
 func (p *DB) Contains(key []byte) bool {
+	if p.shards != nil {
+		return p.shard(key).Contains(key)
+	}
+
 	p.mu.RLock()
 	_, exact := p.findGE(key, false)
 	p.mu.RUnlock()
@@ -354,10 +460,13 @@ func (p *DB) Contains(key []byte) bool {
 // The caller should not modify the contents of the returned slice, but
 // it is safe to modify the contents of the argument after Get returns.
 func (p *DB) Get(key []byte) (value []byte, err error) {
+	if p.shards != nil {
+		return p.shard(key).Get(key)
+	}
+
 	p.mu.RLock()
 	if node, exact := p.findGE(key, false); exact {
-		o := p.nodeData[node] + p.nodeData[node+nKey]
-		value = p.kvData[o : o+p.nodeData[node+nVal]]
+		value = p.nodeValue(node)
 	} else {
 		err = ErrNotFound
 	}
@@ -372,12 +481,26 @@ func (p *DB) Get(key []byte) (value []byte, err error) {
 // The caller should not modify the contents of the returned slice, but
 // it is safe to modify the contents of the argument after Find returns.
 func (p *DB) Find(key []byte) (rkey, value []byte, err error) {
+	if p.shards != nil {
+		// The keys sharing the prefix of the given key are all in its shard,
+		// so one found there is the first of the DB.
+		s := p.shard(key)
+		if k, v, e := s.Find(key); e == nil && bytes.Equal(p.keyPrefix(k), p.keyPrefix(key)) {
+			return k, v, nil
+		}
+		err = ErrNotFound
+		for _, s := range p.shards {
+			if k, v, e := s.Find(key); e == nil && (rkey == nil || p.cmp.Compare(k, rkey) < 0) {
+				rkey, value, err = k, v, nil
+			}
+		}
+		return
+	}
+
 	p.mu.RLock()
 	if node, _ := p.findGE(key, false); node != 0 {
-		n := p.nodeData[node]
-		m := n + p.nodeData[node+nKey]
-		rkey = p.kvData[n:m]
-		value = p.kvData[m : m+p.nodeData[node+nVal]]
+		rkey = p.nodeKey(node)
+		value = p.nodeValue(node)
 	} else {
 		err = ErrNotFound
 	}
@@ -405,20 +528,74 @@ func (p *DB) Find(key []byte) (rkey, value []byte, err error) {
 //
 // Also read Iterator documentation of the leveldb/iterator package.
 func (p *DB) NewIterator(slice *util.Range) iterator.Iterator {
+	if p.shards != nil {
+		iters := make([]iterator.Iterator, len(p.shards))
+		for i, s := range p.shards {
+			iters[i] = s.NewIterator(slice)
+		}
+		cmp, ok := p.cmp.(comparer.Comparer)
+		if !ok {
+			cmp = basicComparer{p.cmp}
+		}
+		return iterator.NewMergedIterator(iters, cmp, true)
+	}
 	return &dbIter{p: p, slice: slice}
 }
 
+// SetFilter enables a bloom filter of the given size in bits over key
+// prefixes extracted by the prefix function, if nil the whole key is used.
+// The prefix function must return a prefix of the given key. SetFilter must
+// be called before the DB is used.
+func (p *DB) SetFilter(bits int, prefix func(key []byte) []byte) {
+	p.filter = newFilter(bits, prefix)
+}
+
+// MayContain returns false if the DB definitely does not contain any key
+// having the same prefix as the given key, as defined by SetFilter. It
+// always returns true if the DB has no filter.
+//
+// It is safe to modify the contents of the arguments after MayContain
+// returns.
+func (p *DB) MayContain(key []byte) bool {
+	return p.filter == nil || p.filter.mayContain(key)
+}
+
+// Shards returns number of shards of the DB, 1 if the DB is not sharded.
+func (p *DB) Shards() int {
+	if p.shards != nil {
+		return len(p.shards)
+	}
+	return 1
+}
+
+// ShardOf returns the index of the shard holding the given key, less than
+// Shards. Puts of keys of distinct shards don't contend.
+func (p *DB) ShardOf(key []byte) int {
+	if p.shards != nil {
+		return p.shardIndex(key)
+	}
+	return 0
+}
+
 // Capacity returns keys/values buffer capacity.
 func (p *DB) Capacity() int {
+	if p.shards != nil {
+		return p.sum((*DB).Capacity)
+	}
+
 	p.mu.RLock()
 	defer p.mu.RUnlock()
-	return cap(p.kvData)
+	return p.kvData.cap()
 }
 
 // Size returns sum of keys and values length. Note that deleted
 // key/value will not be accounted for, but it will still consume
 // the buffer, since the buffer is append only.
 func (p *DB) Size() int {
+	if p.shards != nil {
+		return p.sum((*DB).Size)
+	}
+
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 	return p.kvSize
@@ -426,26 +603,57 @@ func (p *DB) Size() int {
 
 // Free returns keys/values free buffer before need to grow.
 func (p *DB) Free() int {
+	if p.shards != nil {
+		return p.sum((*DB).Free)
+	}
+
 	p.mu.RLock()
 	defer p.mu.RUnlock()
-	return cap(p.kvData) - len(p.kvData)
+	return p.kvData.cap() - p.kvData.size()
 }
 
 // Len returns the number of entries in the DB.
 func (p *DB) Len() int {
+	if p.shards != nil {
+		return p.sum((*DB).Len)
+	}
+
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 	return p.n
 }
 
+func (p *DB) sum(fn func(*DB) int) (n int) {
+	for _, s := range p.shards {
+		n += fn(s)
+	}
+	return
+}
+
 // Reset resets the DB to initial empty state. Allows reuse the buffer.
 func (p *DB) Reset() {
+	if p.filter != nil {
+		p.filter.reset()
+	}
+	if p.shards != nil {
+		for _, s := range p.shards {
+			s.Reset()
+		}
+		return
+	}
+
 	p.mu.Lock()
+	p.reset()
+	p.mu.Unlock()
+}
+
+// Must hold RW-lock.
+func (p *DB) reset() {
 	p.rnd = rand.New(rand.NewSource(0xdeadbeef))
 	p.maxHeight = 1
 	p.n = 0
 	p.kvSize = 0
-	p.kvData = p.kvData[:0]
+	p.kvData.reset()
 	p.nodeData = p.nodeData[:nNext+tMaxHeight]
 	p.nodeData[nKV] = 0
 	p.nodeData[nKey] = 0
@@ -455,7 +663,6 @@ func (p *DB) Reset() {
 		p.nodeData[nNext+n] = 0
 		p.prevNode[n] = 0
 	}
-	p.mu.Unlock()
 }
 
 // New creates a new initialized in-memory key/value DB. The capacity
@@ -463,7 +670,9 @@ func (p *DB) Reset() {
 // not enforced.
 //
 // This DB is append-only, deleting an entry would remove entry node but not
-// reclaim KV buffer.
+// reclaim KV buffer. The KV buffer is an arena of fixed-size blocks which are
+// allocated as needed and released all at once by Reset, so growing it
+// never copies existing entries.
 //
 // The returned DB instance is safe for concurrent use.
 func New(cmp comparer.BasicComparer, capacity int) *DB {
@@ -471,9 +680,52 @@ func New(cmp comparer.BasicComparer, capacity int) *DB {
 		cmp:       cmp,
 		rnd:       rand.New(rand.NewSource(0xdeadbeef)),
 		maxHeight: 1,
-		kvData:    make([]byte, 0, capacity),
+		kvData:    newArena(capacity),
 		nodeData:  make([]int, 4+tMaxHeight),
 	}
 	p.nodeData[nHeight] = tMaxHeight
 	return p
 }
+
+// NewSharded creates a new initialized in-memory key/value DB which is
+// split into the given number of shards, each one is an independent
+// skiplist with its own lock. Writes to keys which fall into different
+// shards may proceed concurrently, at the cost of merging shards on
+// iteration, and on Find of a key whose prefix isn't in the DB. The
+// capacity is divided evenly between shards.
+//
+// Keys are assigned to shards by the given prefix function, if nil by the
+// whole key. The keys sharing a prefix must be contiguous in the order of
+// the comparer, as are the internal keys of a user key.
+//
+// If shards is less than or equal to 1 then it is equivalent to New.
+//
+// The returned DB instance is safe for concurrent use.
+func NewSharded(cmp comparer.BasicComparer, capacity, shards int, prefix func(key []byte) []byte) *DB {
+	if shards <= 1 {
+		return New(cmp, capacity)
+	}
+	p := &DB{
+		cmp:         cmp,
+		shards:      make([]*DB, shards),
+		shardPrefix: prefix,
+	}
+	for i := range p.shards {
+		n := capacity / shards
+		if i == 0 {
+			n += capacity % shards
+		}
+		p.shards[i] = New(cmp, n)
+	}
+	return p
+}
+
+// basicComparer adapts a BasicComparer for merging shard iterators, which
+// only uses Compare.
+type basicComparer struct {
+	comparer.BasicComparer
+}
+
+func (basicComparer) Name() string                      { return "" }
+func (basicComparer) Separator(dst, a, b []byte) []byte { return nil }
+func (basicComparer) Successor(dst, b []byte) []byte    { return nil }
@@ -7,6 +7,9 @@
 package memdb
 
 import (
+	"bytes"
+	"fmt"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
@@ -19,10 +22,8 @@ import (
 func (p *DB) TestFindLT(key []byte) (rkey, value []byte, err error) {
 	p.mu.RLock()
 	if node := p.findLT(key); node != 0 {
-		n := p.nodeData[node]
-		m := n + p.nodeData[node+nKey]
-		rkey = p.kvData[n:m]
-		value = p.kvData[m : m+p.nodeData[node+nVal]]
+		rkey = p.nodeKey(node)
+		value = p.nodeValue(node)
 	} else {
 		err = ErrNotFound
 	}
@@ -33,10 +34,8 @@ func (p *DB) TestFindLT(key []byte) (rkey, value []byte, err error) {
 func (p *DB) TestFindLast() (rkey, value []byte, err error) {
 	p.mu.RLock()
 	if node := p.findLast(); node != 0 {
-		n := p.nodeData[node]
-		m := n + p.nodeData[node+nKey]
-		rkey = p.kvData[n:m]
-		value = p.kvData[m : m+p.nodeData[node+nVal]]
+		rkey = p.nodeKey(node)
+		value = p.nodeValue(node)
 	} else {
 		err = ErrNotFound
 	}
@@ -129,5 +128,139 @@ var _ = testutil.Defer(func() {
 				return db
 			}, nil, nil)
 		})
+
+		Describe("arena", func() {
+			It("should keep entries across blocks", func() {
+				db := New(comparer.DefaultComparer, 0)
+				sizes := []int{arenaBlockSize - 1, 0, 10, arenaBlockSize - 5, 100, 3*arenaBlockSize + 1, 7}
+				for round := 0; round < 2; round++ {
+					for i, n := range sizes {
+						Expect(db.Put([]byte{byte(i)}, bytes.Repeat([]byte{byte(i)}, n))).ShouldNot(HaveOccurred())
+					}
+					for i, n := range sizes {
+						Expect(db.Get([]byte{byte(i)})).Should(Equal(bytes.Repeat([]byte{byte(i)}, n)))
+					}
+					Expect(db.Free()).Should(BeNumerically(">=", 0))
+					db.Reset()
+					Expect(db.Len()).Should(Equal(0))
+				}
+			})
+
+			It("should count a value larger than the capacity in use", func() {
+				db := New(comparer.DefaultComparer, arenaBlockSize)
+				Expect(db.Put([]byte("k"), make([]byte, 3*arenaBlockSize))).ShouldNot(HaveOccurred())
+				Expect(db.Free()).Should(BeNumerically(">=", 0))
+				Expect(db.Capacity()).Should(BeNumerically(">=", db.Size()))
+			})
+		})
+
+		Describe("hash", func() {
+			prefix := func(key []byte) []byte {
+				if len(key) > 2 {
+					return key[:2]
+				}
+				return key
+			}
+
+			It("should do write correctly", func() {
+				db := NewHash(comparer.DefaultComparer, 0, prefix)
+				t := testutil.DBTesting{
+					DB:      db,
+					Deleted: testutil.KeyValue_Generate(nil, 1000, 1, 1, 30, 5, 5).Clone(),
+					PostFn: func(t *testutil.DBTesting) {
+						Expect(db.Len()).Should(Equal(t.Present.Len()))
+						Expect(db.Size()).Should(Equal(t.Present.Size()))
+						switch t.Act {
+						case testutil.DBPut, testutil.DBOverwrite:
+							Expect(db.Contains(t.ActKey)).Should(BeTrue())
+						default:
+							Expect(db.Contains(t.ActKey)).Should(BeFalse())
+						}
+					},
+				}
+				testutil.DoDBTesting(&t)
+			})
+
+			testutil.AllKeyValueTesting(nil, func(kv testutil.KeyValue) testutil.DB {
+				db := NewHash(comparer.DefaultComparer, 0, prefix)
+				kv.IterateShuffled(nil, func(i int, key, value []byte) {
+					Expect(db.Put(key, value)).ShouldNot(HaveOccurred())
+				})
+				return db
+			}, nil, nil)
+		})
+
+		Describe("filter", func() {
+			It("should not have false negatives", func() {
+				db := NewSharded(comparer.DefaultComparer, 0, 2, nil)
+				db.SetFilter(10*1000, nil)
+				for i := 0; i < 1000; i++ {
+					Expect(db.Put([]byte(fmt.Sprintf("key%d", i)), nil)).ShouldNot(HaveOccurred())
+				}
+				var fp int
+				for i := 0; i < 1000; i++ {
+					Expect(db.MayContain([]byte(fmt.Sprintf("key%d", i)))).Should(BeTrue())
+					if db.MayContain([]byte(fmt.Sprintf("absent%d", i))) {
+						fp++
+					}
+				}
+				Expect(fp).Should(BeNumerically("<", 50))
+
+				db.Reset()
+				Expect(db.MayContain([]byte("key0"))).Should(BeFalse())
+			})
+		})
+
+		Describe("sharded", func() {
+			It("should do write correctly", func() {
+				db := NewSharded(comparer.DefaultComparer, 0, 4, nil)
+				t := testutil.DBTesting{
+					DB:      db,
+					Deleted: testutil.KeyValue_Generate(nil, 1000, 1, 1, 30, 5, 5).Clone(),
+					PostFn: func(t *testutil.DBTesting) {
+						Expect(db.Len()).Should(Equal(t.Present.Len()))
+						Expect(db.Size()).Should(Equal(t.Present.Size()))
+					},
+				}
+				testutil.DoDBTesting(&t)
+			})
+
+			testutil.AllKeyValueTesting(nil, func(kv testutil.KeyValue) testutil.DB {
+				db := NewSharded(comparer.DefaultComparer, 0, 4, nil)
+				kv.IterateShuffled(nil, func(i int, key, value []byte) {
+					Expect(db.Put(key, value)).ShouldNot(HaveOccurred())
+				})
+				return db
+			}, nil, nil)
+
+			It("should keep the keys of a prefix in a shard", func() {
+				prefix := func(key []byte) []byte {
+					if len(key) > 2 {
+						return key[:2]
+					}
+					return key
+				}
+				db := NewSharded(comparer.DefaultComparer, 0, 4, prefix)
+				for i := 0; i < 100; i++ {
+					for j := 0; j < 10; j++ {
+						key := []byte(fmt.Sprintf("%02d%d", i, j))
+						Expect(db.ShardOf(key)).Should(Equal(db.ShardOf(key[:2])))
+						Expect(db.Put(key, nil)).ShouldNot(HaveOccurred())
+					}
+				}
+				for i := 0; i < 100; i++ {
+					rkey, _, err := db.Find([]byte(fmt.Sprintf("%02d", i)))
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(rkey).Should(Equal([]byte(fmt.Sprintf("%02d0", i))))
+					rkey, _, err = db.Find([]byte(fmt.Sprintf("%02d9~", i)))
+					if i < 99 {
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(rkey).Should(Equal([]byte(fmt.Sprintf("%02d0", i+1))))
+					} else {
+						Expect(err).Should(Equal(ErrNotFound))
+					}
+				}
+			})
+		})
 	})
 })
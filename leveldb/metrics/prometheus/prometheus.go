@@ -0,0 +1,320 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package prometheus exports the metrics of a DB in the Prometheus text
+// exposition format, so they can be scraped without depending on the
+// Prometheus client library.
+//
+// The exported metrics cover the DB statistics, that is storage I/O, write
+// stalls, caches, levels and compactions, along with the count, errors and
+// latency of the operations done through the Exporter's instrumented
+// methods:
+//
+//	e := prometheus.NewExporter(db, "myapp_leveldb")
+//	http.Handle("/metrics", e)
+//	...
+//	value, err := e.Get(key, nil)
+package prometheus
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// DefaultNamespace is the metrics name prefix used when none is given.
+const DefaultNamespace = "leveldb"
+
+// Upper bounds, in seconds, of the operation latency histogram buckets.
+var latencyBuckets = [...]float64{
+	0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1,
+}
+
+// Instrumented operations.
+const (
+	opGet = iota
+	opHas
+	opPut
+	opDelete
+	opWrite
+	nOps
+)
+
+var opNames = [nOps]string{"get", "has", "put", "delete", "write"}
+
+type opMetrics struct {
+	errors  uint64
+	count   uint64
+	sumNano uint64
+	buckets [len(latencyBuckets)]uint64 // Not cumulative.
+}
+
+func (m *opMetrics) observe(start time.Time, err error) {
+	d := time.Since(start)
+	atomic.AddUint64(&m.count, 1)
+	atomic.AddUint64(&m.sumNano, uint64(d))
+	for i, le := range latencyBuckets {
+		if d.Seconds() <= le {
+			atomic.AddUint64(&m.buckets[i], 1)
+			break
+		}
+	}
+	if err != nil && err != errors.ErrNotFound {
+		atomic.AddUint64(&m.errors, 1)
+	}
+}
+
+// Exporter exports the metrics of a DB. It's an http.Handler serving them
+// in the Prometheus text exposition format.
+//
+// Exporter is safe for concurrent use.
+type Exporter struct {
+	db        *leveldb.DB
+	namespace string
+	ops       [nOps]opMetrics
+
+	mu    sync.Mutex
+	stats leveldb.DBStats
+}
+
+// NewExporter returns an Exporter for the given DB. All metric names are
+// prefixed with namespace and an underscore; DefaultNamespace is used if
+// the namespace is empty.
+func NewExporter(db *leveldb.DB, namespace string) *Exporter {
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	return &Exporter{
+		db:        db,
+		namespace: namespace,
+	}
+}
+
+// Get calls DB.Get, recording its latency.
+func (e *Exporter) Get(key []byte, ro *opt.ReadOptions) (value []byte, err error) {
+	start := time.Now()
+	value, err = e.db.Get(key, ro)
+	e.ops[opGet].observe(start, err)
+	return
+}
+
+// Has calls DB.Has, recording its latency.
+func (e *Exporter) Has(key []byte, ro *opt.ReadOptions) (ret bool, err error) {
+	start := time.Now()
+	ret, err = e.db.Has(key, ro)
+	e.ops[opHas].observe(start, err)
+	return
+}
+
+// Put calls DB.Put, recording its latency.
+func (e *Exporter) Put(key, value []byte, wo *opt.WriteOptions) error {
+	start := time.Now()
+	err := e.db.Put(key, value, wo)
+	e.ops[opPut].observe(start, err)
+	return err
+}
+
+// Delete calls DB.Delete, recording its latency.
+func (e *Exporter) Delete(key []byte, wo *opt.WriteOptions) error {
+	start := time.Now()
+	err := e.db.Delete(key, wo)
+	e.ops[opDelete].observe(start, err)
+	return err
+}
+
+// Write calls DB.Write, recording its latency.
+func (e *Exporter) Write(batch *leveldb.Batch, wo *opt.WriteOptions) error {
+	start := time.Now()
+	err := e.db.Write(batch, wo)
+	e.ops[opWrite].observe(start, err)
+	return err
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if _, err := e.WriteTo(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// WriteTo writes the metrics to w in the Prometheus text exposition format.
+// The DB statistics are omitted if the DB is closed.
+func (e *Exporter) WriteTo(w io.Writer) (int64, error) {
+	mw := &metricsWriter{w: bufio.NewWriter(w), namespace: e.namespace}
+	e.writeOps(mw)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.db.Stats(&e.stats); err == nil {
+		e.writeStats(mw, &e.stats)
+	}
+	if mw.err == nil {
+		mw.err = mw.w.Flush()
+	}
+	return mw.n, mw.err
+}
+
+func (e *Exporter) writeOps(mw *metricsWriter) {
+	mw.header("operations_total", "counter", "Number of DB operations.")
+	for op := range e.ops {
+		mw.value("operations_total", "op", opNames[op], float64(atomic.LoadUint64(&e.ops[op].count)))
+	}
+	mw.header("operation_errors_total", "counter", "Number of failed DB operations.")
+	for op := range e.ops {
+		mw.value("operation_errors_total", "op", opNames[op], float64(atomic.LoadUint64(&e.ops[op].errors)))
+	}
+	mw.header("operation_duration_seconds", "histogram", "Latency of DB operations.")
+	for op := range e.ops {
+		m := &e.ops[op]
+		var cum uint64
+		for i, le := range latencyBuckets {
+			cum += atomic.LoadUint64(&m.buckets[i])
+			mw.printf("%s_operation_duration_seconds_bucket{op=%q,le=%q} %d\n", mw.namespace, opNames[op], formatFloat(le), cum)
+		}
+		count := atomic.LoadUint64(&m.count)
+		mw.printf("%s_operation_duration_seconds_bucket{op=%q,le=\"+Inf\"} %d\n", mw.namespace, opNames[op], count)
+		mw.value("operation_duration_seconds_sum", "op", opNames[op], time.Duration(atomic.LoadUint64(&m.sumNano)).Seconds())
+		mw.value("operation_duration_seconds_count", "op", opNames[op], float64(count))
+	}
+}
+
+func (e *Exporter) writeStats(mw *metricsWriter, s *leveldb.DBStats) {
+	mw.metric("io_read_bytes_total", "counter", "Bytes read from the storage, including decrypted bytes.", float64(s.IORead))
+	mw.metric("io_write_bytes_total", "counter", "Bytes written to the storage, including encrypted bytes.", float64(s.IOWrite))
+
+	ciphers := []struct {
+		typ string
+		cs  *leveldb.CipherStats
+	}{
+		{"manifest", &s.ManifestCipher},
+		{"journal", &s.JournalCipher},
+		{"table", &s.TableCipher},
+	}
+	mw.header("cipher_bytes_total", "counter", "Bytes encrypted or decrypted, by file type.")
+	for _, c := range ciphers {
+		mw.printf("%s_cipher_bytes_total{type=%q,op=\"encrypt\"} %d\n", mw.namespace, c.typ, c.cs.EncryptBytes)
+		mw.printf("%s_cipher_bytes_total{type=%q,op=\"decrypt\"} %d\n", mw.namespace, c.typ, c.cs.DecryptBytes)
+	}
+	mw.header("cipher_seconds_total", "counter", "Time spent encrypting or decrypting, by file type.")
+	for _, c := range ciphers {
+		mw.printf("%s_cipher_seconds_total{type=%q,op=\"encrypt\"} %s\n", mw.namespace, c.typ, formatFloat(c.cs.EncryptDuration.Seconds()))
+		mw.printf("%s_cipher_seconds_total{type=%q,op=\"decrypt\"} %s\n", mw.namespace, c.typ, formatFloat(c.cs.DecryptDuration.Seconds()))
+	}
+
+	mw.metric("write_delays_total", "counter", "Number of writes delayed by a write stall.", float64(s.WriteDelayCount))
+	mw.metric("write_delay_seconds_total", "counter", "Time writes spent delayed by write stalls.", s.WriteDelayDuration.Seconds())
+	paused := 0.0
+	if s.WritePaused {
+		paused = 1
+	}
+	mw.metric("write_paused", "gauge", "Whether writes are paused by a write stall.", paused)
+
+	mw.metric("alive_snapshots", "gauge", "Number of snapshots not yet released.", float64(s.AliveSnapshots))
+	mw.metric("alive_iterators", "gauge", "Number of iterators not yet released.", float64(s.AliveIterators))
+	mw.metric("open_tables", "gauge", "Number of tables in the open files cache.", float64(s.OpenedTablesCount))
+	mw.metric("block_cache_size_bytes", "gauge", "Size of the block cache.", float64(s.BlockCacheSize))
+
+	mw.header("cache_hits_total", "counter", "Number of cache lookups which hit.")
+	mw.value("cache_hits_total", "cache", "block", float64(s.BlockCache.HitCount))
+	mw.value("cache_hits_total", "cache", "file", float64(s.FileCache.HitCount))
+	mw.header("cache_misses_total", "counter", "Number of cache lookups which missed.")
+	mw.value("cache_misses_total", "cache", "block", float64(s.BlockCache.MissCount))
+	mw.value("cache_misses_total", "cache", "file", float64(s.FileCache.MissCount))
+	mw.header("cache_inserts_total", "counter", "Number of cache inserts.")
+	mw.value("cache_inserts_total", "cache", "block", float64(s.BlockCache.SetCount))
+	mw.value("cache_inserts_total", "cache", "file", float64(s.FileCache.SetCount))
+	mw.header("cache_evictions_total", "counter", "Number of cache evictions to make room.")
+	mw.value("cache_evictions_total", "cache", "block", float64(s.BlockCache.EvictCount))
+	mw.value("cache_evictions_total", "cache", "file", float64(s.FileCache.EvictCount))
+	mw.header("cache_pinned_bytes", "gauge", "Size of the cached objects in use.")
+	mw.value("cache_pinned_bytes", "cache", "block", float64(s.BlockCache.PinnedSize))
+	mw.value("cache_pinned_bytes", "cache", "file", float64(s.FileCache.PinnedSize))
+	mw.header("cache_hit_ratio", "gauge", "Ratio of cache lookups which hit since the DB was opened.")
+	mw.value("cache_hit_ratio", "cache", "block", hitRatio(s.BlockCache.HitCount, s.BlockCache.MissCount))
+	mw.value("cache_hit_ratio", "cache", "file", hitRatio(s.FileCache.HitCount, s.FileCache.MissCount))
+
+	mw.header("level_tables", "gauge", "Number of tables per level.")
+	for level, n := range s.LevelTablesCounts {
+		mw.value("level_tables", "level", strconv.Itoa(level), float64(n))
+	}
+	mw.header("level_size_bytes", "gauge", "Size of the tables per level.")
+	for level, size := range s.LevelSizes {
+		mw.value("level_size_bytes", "level", strconv.Itoa(level), float64(size))
+	}
+	mw.header("compaction_read_bytes_total", "counter", "Bytes read by compactions into each level.")
+	for level, n := range s.LevelRead {
+		mw.value("compaction_read_bytes_total", "level", strconv.Itoa(level), float64(n))
+	}
+	mw.header("compaction_write_bytes_total", "counter", "Bytes written by compactions into each level.")
+	for level, n := range s.LevelWrite {
+		mw.value("compaction_write_bytes_total", "level", strconv.Itoa(level), float64(n))
+	}
+	mw.header("compaction_seconds_total", "counter", "Time spent compacting into each level.")
+	for level, d := range s.LevelDurations {
+		mw.value("compaction_seconds_total", "level", strconv.Itoa(level), d.Seconds())
+	}
+	mw.header("compactions_total", "counter", "Number of compactions by kind.")
+	mw.value("compactions_total", "kind", "memdb", float64(s.MemComp))
+	mw.value("compactions_total", "kind", "level0", float64(s.Level0Comp))
+	mw.value("compactions_total", "kind", "nonlevel0", float64(s.NonLevel0Comp))
+	mw.value("compactions_total", "kind", "seek", float64(s.SeekComp))
+}
+
+func hitRatio(hits, misses int64) float64 {
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// metricsWriter writes metrics in the text exposition format, keeping the
+// first error.
+type metricsWriter struct {
+	w         *bufio.Writer
+	namespace string
+	n         int64
+	err       error
+}
+
+func (mw *metricsWriter) printf(format string, a ...interface{}) {
+	if mw.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(mw.w, format, a...)
+	mw.n += int64(n)
+	mw.err = err
+}
+
+func (mw *metricsWriter) header(name, typ, help string) {
+	mw.printf("# HELP %s_%s %s\n# TYPE %s_%s %s\n", mw.namespace, name, help, mw.namespace, name, typ)
+}
+
+func (mw *metricsWriter) value(name, label, labelValue string, v float64) {
+	mw.printf("%s_%s{%s=%q} %s\n", mw.namespace, name, label, labelValue, formatFloat(v))
+}
+
+func (mw *metricsWriter) metric(name, typ, help string, v float64) {
+	mw.header(name, typ, help)
+	mw.printf("%s_%s %s\n", mw.namespace, name, formatFloat(v))
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package prometheus
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+func TestExporter(t *testing.T) {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	e := NewExporter(db, "")
+	for _, key := range []string{"a", "b", "c"} {
+		if err := e.Put([]byte(key), []byte("v"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := e.Get([]byte("a"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Get([]byte("x"), nil); err != leveldb.ErrNotFound {
+		t.Fatalf("got error %v, want %v", err, leveldb.ErrNotFound)
+	}
+	if err := db.CompactRange(util.Range{}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != 200 {
+		t.Fatalf("got status %d", rec.Code)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	metrics := string(body)
+	for _, want := range []string{
+		"# TYPE leveldb_operations_total counter\n",
+		`leveldb_operations_total{op="put"} 3` + "\n",
+		`leveldb_operations_total{op="get"} 2` + "\n",
+		`leveldb_operation_errors_total{op="get"} 0` + "\n",
+		`leveldb_operation_duration_seconds_bucket{op="put",le="+Inf"} 3` + "\n",
+		`leveldb_operation_duration_seconds_count{op="get"} 2` + "\n",
+		"# TYPE leveldb_io_write_bytes_total counter\n",
+		`leveldb_compactions_total{kind="memdb"} 1` + "\n",
+		`leveldb_cache_hit_ratio{cache="block"} `,
+		`leveldb_level_tables{level="0"} `,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics don't contain %q:\n%s", want, metrics)
+		}
+	}
+	if strings.Contains(metrics, "leveldb_io_write_bytes_total 0\n") {
+		t.Error("no bytes written reported")
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+/*
+Badger backups:
+
+A backup, as written by badger's DB.Backup and read by DB.Load, is a
+sequence of protobuf KVList messages, each prefixed by its length as a
+little-endian uint64:
+
+	message KVList { repeated KV kv = 1; }
+	message KV {
+		bytes key = 1;
+		bytes value = 2;
+		bytes user_meta = 3;
+		uint64 version = 4;
+		uint64 expires_at = 5;
+		bytes meta = 6;
+	}
+
+The versions of a key are in the same KVList, newest first. A deleted key
+has the delete bit set in its meta.
+*/
+
+const (
+	badgerBitDelete = 1 << 0
+
+	// Size of the KVLists written by ToBadgerBackup, as badger's streams.
+	badgerListSize = 4 << 20
+	// Largest KVList read by FromBadgerBackup.
+	badgerMaxListSize = 1 << 30
+)
+
+// Fields of the KV and KVList messages.
+const (
+	badgerFieldKV        = 1
+	badgerFieldKey       = 1
+	badgerFieldValue     = 2
+	badgerFieldVersion   = 4
+	badgerFieldExpiresAt = 5
+	badgerFieldMeta      = 6
+)
+
+type badgerKV struct {
+	key, value []byte
+	version    uint64
+	expiresAt  uint64
+	meta       byte
+}
+
+// Decodes the fields of a protobuf message, calling fn with each field
+// number and its value: the integer for varint fields, the bytes for length
+// delimited ones. Fixed-size fields are skipped.
+func decodeProto(b []byte, fn func(field int, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.New("bad field tag")
+		}
+		b = b[n:]
+		var (
+			v    uint64
+			data []byte
+		)
+		switch tag & 7 {
+		case 0:
+			if v, n = binary.Uvarint(b); n <= 0 {
+				return errors.New("bad varint field")
+			}
+			b = b[n:]
+		case 1:
+			if len(b) < 8 {
+				return errors.New("bad fixed64 field")
+			}
+			b = b[8:]
+			continue
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return errors.New("bad length delimited field")
+			}
+			data, b = b[n:n+int(l)], b[n+int(l):]
+		case 5:
+			if len(b) < 4 {
+				return errors.New("bad fixed32 field")
+			}
+			b = b[4:]
+			continue
+		default:
+			return fmt.Errorf("unknown wire type %d", tag&7)
+		}
+		if err := fn(int(tag>>3), v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeBadgerKV(b []byte) (kv badgerKV, err error) {
+	err = decodeProto(b, func(field int, v uint64, data []byte) error {
+		switch field {
+		case badgerFieldKey:
+			kv.key = data
+		case badgerFieldValue:
+			kv.value = data
+		case badgerFieldVersion:
+			kv.version = v
+		case badgerFieldExpiresAt:
+			kv.expiresAt = v
+		case badgerFieldMeta:
+			if len(data) > 0 {
+				kv.meta = data[0]
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func appendProtoBytes(dst []byte, field int, b []byte) []byte {
+	dst = binary.AppendUvarint(dst, uint64(field)<<3|2)
+	dst = binary.AppendUvarint(dst, uint64(len(b)))
+	return append(dst, b...)
+}
+
+// Encodes a KV of the given key and value at version 1, as written by a
+// badger transaction.
+func appendBadgerKV(dst, scratch, key, value []byte) (kvList, kv []byte) {
+	kv = appendProtoBytes(scratch[:0], badgerFieldKey, key)
+	kv = appendProtoBytes(kv, badgerFieldValue, value)
+	kv = binary.AppendUvarint(kv, badgerFieldVersion<<3)
+	kv = binary.AppendUvarint(kv, 1)
+	kv = appendProtoBytes(kv, badgerFieldMeta, []byte{0})
+	return appendProtoBytes(dst, badgerFieldKV, kv), kv
+}
+
+// FromBadgerBackup imports a badger backup, as written by badger's
+// DB.Backup or the badger backup command, into the DB, and returns the
+// number of keys imported. The newest version of each key is put; deleted
+// and expired keys are deleted.
+//
+// As FromBolt, the entries are written by a single DB transaction. An
+// encrypted target only needs leveldb.EncryptionVersion and
+// leveldb.EncryptionKey to be set when the DB is opened, as for any write.
+func FromBadgerBackup(db *leveldb.DB, r io.Reader) (n int, err error) {
+	tr, err := db.OpenTransaction()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			tr.Discard()
+		}
+	}()
+
+	br := bufio.NewReader(r)
+	now := uint64(time.Now().Unix())
+	var (
+		sizeBuf [8]byte
+		buf     []byte
+		prevKey []byte
+	)
+	for list := 1; ; list++ {
+		if _, err = io.ReadFull(br, sizeBuf[:]); err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, fmt.Errorf("migrate: badger backup: KVList %d: %v", list, err)
+		}
+		size := binary.LittleEndian.Uint64(sizeBuf[:])
+		if size > badgerMaxListSize {
+			return 0, fmt.Errorf("migrate: badger backup: KVList %d: size %d too large", list, size)
+		}
+		if uint64(cap(buf)) < size {
+			buf = make([]byte, size)
+		}
+		buf = buf[:size]
+		if _, err = io.ReadFull(br, buf); err != nil {
+			return 0, fmt.Errorf("migrate: badger backup: KVList %d: %v", list, err)
+		}
+		prevKey = prevKey[:0]
+		first := true
+		err = decodeProto(buf, func(field int, _ uint64, b []byte) error {
+			if field != badgerFieldKV {
+				return nil
+			}
+			kv, err := decodeBadgerKV(b)
+			if err != nil {
+				return err
+			}
+			// Older versions follow the newest.
+			if !first && bytes.Equal(kv.key, prevKey) {
+				return nil
+			}
+			first = false
+			prevKey = append(prevKey[:0], kv.key...)
+			n++
+			if kv.meta&badgerBitDelete != 0 || (kv.expiresAt != 0 && kv.expiresAt <= now) {
+				return tr.Delete(kv.key, nil)
+			}
+			return tr.Put(kv.key, kv.value, nil)
+		})
+		if err != nil {
+			return 0, fmt.Errorf("migrate: badger backup: KVList %d: %v", list, err)
+		}
+	}
+	if err = tr.Commit(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// ToBadgerBackup writes the entries of the given key range of the DB to w
+// as a badger backup, which badger's DB.Load or the badger restore command
+// can load, from a consistent snapshot of the DB. A nil Range exports the
+// whole DB. It returns the number of entries written.
+//
+// The entries are at version 1, without expiry nor user meta. The DB is
+// read decrypted, so the backup is plain even if the DB is encrypted.
+func ToBadgerBackup(w io.Writer, db *leveldb.DB, slice *util.Range) (n int, err error) {
+	iter := db.NewIterator(slice, nil)
+	defer iter.Release()
+
+	bw := bufio.NewWriter(w)
+	var list, scratch []byte
+	flush := func() error {
+		var size [8]byte
+		binary.LittleEndian.PutUint64(size[:], uint64(len(list)))
+		if _, err := bw.Write(size[:]); err != nil {
+			return err
+		}
+		_, err := bw.Write(list)
+		list = list[:0]
+		return err
+	}
+	for iter.Next() {
+		list, scratch = appendBadgerKV(list, scratch, iter.Key(), iter.Value())
+		n++
+		if len(list) >= badgerListSize {
+			if err := flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return n, err
+	}
+	if len(list) > 0 {
+		if err := flush(); err != nil {
+			return n, err
+		}
+	}
+	return n, bw.Flush()
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package migrate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Encodes a KV as badger does, with an unknown field.
+func encodeBadgerKV(key, value string, version, expiresAt uint64, meta byte) []byte {
+	kv := appendProtoBytes(nil, badgerFieldKey, []byte(key))
+	kv = appendProtoBytes(kv, badgerFieldValue, []byte(value))
+	kv = appendProtoBytes(kv, 3, []byte{0x42})
+	kv = binary.AppendUvarint(kv, badgerFieldVersion<<3)
+	kv = binary.AppendUvarint(kv, version)
+	kv = binary.AppendUvarint(kv, badgerFieldExpiresAt<<3)
+	kv = binary.AppendUvarint(kv, expiresAt)
+	return appendProtoBytes(kv, badgerFieldMeta, []byte{meta})
+}
+
+func appendBadgerList(dst []byte, kvs ...[]byte) []byte {
+	var list []byte
+	for _, kv := range kvs {
+		list = appendProtoBytes(list, badgerFieldKV, kv)
+	}
+	dst = binary.LittleEndian.AppendUint64(dst, uint64(len(list)))
+	return append(dst, list...)
+}
+
+func TestFromBadgerBackup(t *testing.T) {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Put([]byte("deleted"), []byte("old"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	backup := appendBadgerList(nil,
+		encodeBadgerKV("a", "new", 3, 0, 0),
+		encodeBadgerKV("a", "old", 2, 0, 0),
+		encodeBadgerKV("deleted", "", 4, 0, badgerBitDelete),
+		encodeBadgerKV("expired", "x", 1, 1, 0),
+	)
+	backup = appendBadgerList(backup, encodeBadgerKV("b", "2", 1, 0, 0))
+	n, err := FromBadgerBackup(db, bytes.NewReader(backup))
+	if err != nil || n != 4 {
+		t.Fatalf("got %d keys, %v", n, err)
+	}
+	for key, want := range map[string]string{"a": "new", "b": "2", "deleted": "", "expired": ""} {
+		if got, _ := db.Get([]byte(key), nil); string(got) != want {
+			t.Errorf("got %q = %q, want %q", key, got, want)
+		}
+	}
+
+	if _, err := FromBadgerBackup(db, bytes.NewReader(backup[:len(backup)-1])); err == nil {
+		t.Error("import of truncated backup succeeded")
+	}
+}
+
+func TestBadgerBackupRoundTrip(t *testing.T) {
+	// The target is encrypted.
+	defer func(version int, key []byte) {
+		leveldb.EncryptionVersion, leveldb.EncryptionKey = version, key
+	}(leveldb.EncryptionVersion, leveldb.EncryptionKey)
+	leveldb.EncryptionVersion, leveldb.EncryptionKey = 2, []byte("0123456789abcdef")
+
+	src, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if err := src.Put([]byte(key), bytes.Repeat([]byte(key), 100), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	n, err := ToBadgerBackup(&buf, src, &util.Range{Start: []byte("b")})
+	if err != nil || n != 3 {
+		t.Fatalf("ToBadgerBackup: got %d entries, %v", n, err)
+	}
+
+	dst, err := leveldb.OpenFile(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if n, err := FromBadgerBackup(dst, &buf); err != nil || n != 3 {
+		t.Fatalf("FromBadgerBackup: got %d keys, %v", n, err)
+	}
+	for _, key := range []string{"b", "c", "d"} {
+		if got, err := dst.Get([]byte(key), nil); err != nil || !bytes.Equal(got, bytes.Repeat([]byte(key), 100)) {
+			t.Errorf("got %q = %q, %v", key, got, err)
+		}
+	}
+	if ok, _ := dst.Has([]byte("a"), nil); ok {
+		t.Error("got key a outside of the exported range")
+	}
+}
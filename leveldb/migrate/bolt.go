@@ -0,0 +1,138 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package migrate moves data between a DB and other embedded key/value
+// stores, for projects switching storage engines. It imports bbolt files,
+// and imports and exports badger backups:
+//
+//	n, err := migrate.FromBolt(db, "app.bolt", &migrate.BoltOptions{
+//		Buckets: map[string][]byte{"users": []byte("u/"), "sessions": []byte("s/")},
+//	})
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// BoltOptions holds the optional parameters of FromBolt.
+type BoltOptions struct {
+	// Buckets maps the names of the top-level buckets to import to the
+	// prefix of their keys in the DB. Buckets not in the map are skipped.
+	//
+	// The default is to import every top-level bucket, prefixed with its
+	// name followed by Separator.
+	Buckets map[string][]byte
+
+	// Separator follows the name of a nested bucket in the prefix of its
+	// keys, which is the prefix of its parent bucket followed by the name.
+	//
+	// The default is "/".
+	Separator string
+
+	// Timeout is how long to wait for the lock on the bbolt file, which is
+	// held by any process having it open for writing.
+	//
+	// The default is one second.
+	Timeout time.Duration
+}
+
+func (o *BoltOptions) prefix(name []byte) ([]byte, bool) {
+	if o == nil || o.Buckets == nil {
+		return append(append([]byte(nil), name...), o.separator()...), true
+	}
+	prefix, ok := o.Buckets[string(name)]
+	return prefix, ok
+}
+
+func (o *BoltOptions) separator() string {
+	if o == nil || o.Separator == "" {
+		return "/"
+	}
+	return o.Separator
+}
+
+func (o *BoltOptions) timeout() time.Duration {
+	if o == nil || o.Timeout == 0 {
+		return time.Second
+	}
+	return o.Timeout
+}
+
+// FromBolt imports the buckets of the bbolt file at the given path into the
+// DB, as mapped by the options, and returns the number of entries imported.
+// The file is opened read-only and read from a single consistent read
+// transaction, streaming the entries in key order.
+//
+// The entries are written by a single DB transaction, which flushes them
+// straight to tables rather than through the journal, so the import is
+// atomic and fast, but blocks the other writes to the DB until it's done.
+// Existing keys are overwritten.
+func FromBolt(db *leveldb.DB, path string, o *BoltOptions) (n int, err error) {
+	bdb, err := bolt.Open(path, 0, &bolt.Options{ReadOnly: true, Timeout: o.timeout()})
+	if err != nil {
+		return 0, fmt.Errorf("migrate: %v", err)
+	}
+	defer bdb.Close()
+
+	tr, err := db.OpenTransaction()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			tr.Discard()
+		}
+	}()
+
+	sep := []byte(o.separator())
+	var key []byte
+	var importBucket func(b *bolt.Bucket, prefix []byte) error
+	importBucket = func(b *bolt.Bucket, prefix []byte) error {
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if v == nil {
+				// Nested bucket.
+				nested := b.Bucket(k)
+				if nested == nil {
+					return errors.New("migrate: nil value outside of a nested bucket")
+				}
+				np := append(append(append([]byte(nil), prefix...), k...), sep...)
+				if err := importBucket(nested, np); err != nil {
+					return err
+				}
+				continue
+			}
+			key = append(append(key[:0], prefix...), k...)
+			if err := tr.Put(key, v, nil); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	}
+	err = bdb.View(func(btx *bolt.Tx) error {
+		return btx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			prefix, ok := o.prefix(name)
+			if !ok {
+				return nil
+			}
+			return importBucket(b, prefix)
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err = tr.Commit(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package migrate
+
+import (
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestFromBolt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.bolt")
+	bdb, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bdb.Update(func(tx *bolt.Tx) error {
+		users, err := tx.CreateBucket([]byte("users"))
+		if err != nil {
+			return err
+		}
+		if err := users.Put([]byte("alice"), []byte("1")); err != nil {
+			return err
+		}
+		roles, err := users.CreateBucket([]byte("roles"))
+		if err != nil {
+			return err
+		}
+		if err := roles.Put([]byte("admin"), []byte("alice")); err != nil {
+			return err
+		}
+		logs, err := tx.CreateBucket([]byte("logs"))
+		if err != nil {
+			return err
+		}
+		return logs.Put([]byte("1"), []byte("started"))
+	})
+	bdb.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dump := func(o *BoltOptions) (int, map[string]string) {
+		db, err := leveldb.Open(storage.NewMemStorage(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		n, err := FromBolt(db, path, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]string)
+		iter := db.NewIterator(nil, nil)
+		defer iter.Release()
+		for iter.Next() {
+			got[string(iter.Key())] = string(iter.Value())
+		}
+		return n, got
+	}
+
+	n, got := dump(nil)
+	want := map[string]string{"users/alice": "1", "users/roles/admin": "alice", "logs/1": "started"}
+	if n != 3 || len(got) != len(want) {
+		t.Errorf("default options: got %d entries, %v", n, got)
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("default options: got %q = %q, want %q", key, got[key], value)
+		}
+	}
+
+	n, got = dump(&BoltOptions{Buckets: map[string][]byte{"users": []byte("u:")}, Separator: ":"})
+	if n != 2 || len(got) != 2 || got["u:alice"] != "1" || got["u:roles:admin"] != "alice" {
+		t.Errorf("bucket mapping: got %d entries, %v", n, got)
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// MemBucket is a Bucket held in memory, e.g. for tests.
+type MemBucket struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	uploads map[string]*memUpload
+	nextID  int
+}
+
+type memUpload struct {
+	key   string
+	parts map[int][]byte
+}
+
+// NewMemBucket returns an empty MemBucket.
+func NewMemBucket() *MemBucket {
+	return &MemBucket{
+		objects: make(map[string][]byte),
+		uploads: make(map[string]*memUpload),
+	}
+}
+
+func notExist(key string) error {
+	return &os.PathError{Op: "get", Path: key, Err: os.ErrNotExist}
+}
+
+func checkMD5(data, sum []byte) error {
+	if got := md5.Sum(data); !bytes.Equal(got[:], sum) {
+		return errors.New("objstore: bad content digest")
+	}
+	return nil
+}
+
+func (b *MemBucket) Get(ctx context.Context, key string, off, n int64) (io.ReadCloser, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, ok := b.objects[key]
+	if !ok {
+		return nil, notExist(key)
+	}
+	if off > int64(len(data)) {
+		off = int64(len(data))
+	}
+	data = data[off:]
+	if n >= 0 && n < int64(len(data)) {
+		data = data[:n]
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (b *MemBucket) Size(ctx context.Context, key string) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, ok := b.objects[key]
+	if !ok {
+		return 0, notExist(key)
+	}
+	return int64(len(data)), nil
+}
+
+func (b *MemBucket) List(ctx context.Context, prefix string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var keys []string
+	for key := range b.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (b *MemBucket) Put(ctx context.Context, key string, data, md5 []byte) error {
+	if err := checkMD5(data, md5); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.objects[key] = append([]byte(nil), data...)
+	return nil
+}
+
+func (b *MemBucket) Copy(ctx context.Context, src, dst string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, ok := b.objects[src]
+	if !ok {
+		return notExist(src)
+	}
+	b.objects[dst] = data
+	return nil
+}
+
+func (b *MemBucket) Delete(ctx context.Context, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.objects, key)
+	return nil
+}
+
+func (b *MemBucket) Uploads(ctx context.Context, key string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var ids []string
+	for id, u := range b.uploads {
+		if u.key == key {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (b *MemBucket) CreateUpload(ctx context.Context, key string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID++
+	id := fmt.Sprint(b.nextID)
+	b.uploads[id] = &memUpload{key: key, parts: make(map[int][]byte)}
+	return id, nil
+}
+
+func (b *MemBucket) upload(key, id string) (*memUpload, error) {
+	u, ok := b.uploads[id]
+	if !ok || u.key != key {
+		return nil, &os.PathError{Op: "upload", Path: key, Err: os.ErrNotExist}
+	}
+	return u, nil
+}
+
+func (b *MemBucket) UploadPart(ctx context.Context, key, id string, number int, data, sum []byte) (string, error) {
+	if err := checkMD5(data, sum); err != nil {
+		return "", err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	u, err := b.upload(key, id)
+	if err != nil {
+		return "", err
+	}
+	u.parts[number] = append([]byte(nil), data...)
+	return `"` + hex.EncodeToString(sum) + `"`, nil
+}
+
+func (b *MemBucket) Parts(ctx context.Context, key, id string) ([]Part, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	u, err := b.upload(key, id)
+	if err != nil {
+		return nil, err
+	}
+	var parts []Part
+	for number, data := range u.parts {
+		sum := md5.Sum(data)
+		parts = append(parts, Part{Number: number, Size: int64(len(data)), ETag: `"` + hex.EncodeToString(sum[:]) + `"`})
+	}
+	return parts, nil
+}
+
+func (b *MemBucket) CompleteUpload(ctx context.Context, key, id string, parts []Part) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	u, err := b.upload(key, id)
+	if err != nil {
+		return err
+	}
+	var data []byte
+	for _, p := range parts {
+		pdata, ok := u.parts[p.Number]
+		if !ok {
+			return fmt.Errorf("objstore: part %d of %s not uploaded", p.Number, key)
+		}
+		data = append(data, pdata...)
+	}
+	b.objects[key] = data
+	delete(b.uploads, id)
+	return nil
+}
+
+func (b *MemBucket) AbortUpload(ctx context.Context, key, id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, err := b.upload(key, id); err != nil {
+		return err
+	}
+	delete(b.uploads, id)
+	return nil
+}
@@ -0,0 +1,593 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package objstore provides a storage.Storage backed by an object storage
+// bucket, such as S3 or GCS, e.g. to back up a DB directly to the bucket,
+// without a local staging copy:
+//
+//	stor := objstore.New(bucket, &objstore.Options{Prefix: "backups/db1/"})
+//	defer stor.Close()
+//	info, err := db.Backup(stor, nil)
+//	...
+//	err = leveldb.RestoreBackup(stor, localStor, nil)
+//
+// The bucket is reached through the Bucket interface, implemented by a thin
+// adapter over the client of the service, e.g. the AWS SDK.
+//
+// Files are uploaded in parts, through multipart uploads, and only appear
+// once fully written, see storage.AtomicCreator. An upload interrupted by
+// a failure is resumed when the file is created again: the parts already
+// uploaded with the same content are skipped. Each part is sent with its
+// MD5 digest, for the service to verify, and the size of each uploaded
+// object is checked. Failed requests are retried.
+//
+// The storage isn't meant to hold a live DB: its files can't be appended
+// to durably, and it's only locked within the process.
+package objstore
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// Part is a part of a multipart upload.
+type Part struct {
+	// Number is the number of the part, from 1.
+	Number int
+	Size   int64
+	// ETag is the entity tag of the part returned by the service, the hex
+	// MD5 digest of its content for unencrypted S3 objects.
+	ETag string
+}
+
+// Bucket is an object storage bucket. Its methods must return an error
+// satisfying errors.Is(err, os.ErrNotExist) for a missing object.
+type Bucket interface {
+	// Get returns the content of the object from the given offset, n bytes
+	// of it or up to the end if n is negative.
+	Get(ctx context.Context, key string, off, n int64) (io.ReadCloser, error)
+
+	// Size returns the size of the object.
+	Size(ctx context.Context, key string) (int64, error)
+
+	// List returns the keys of the objects starting with the prefix.
+	List(ctx context.Context, prefix string) ([]string, error)
+
+	// Put writes the object, whose content has the given MD5 digest.
+	Put(ctx context.Context, key string, data, md5 []byte) error
+
+	// Copy copies an object within the bucket.
+	Copy(ctx context.Context, src, dst string) error
+
+	// Delete deletes the object.
+	Delete(ctx context.Context, key string) error
+
+	// Uploads returns the IDs of the multipart uploads of the key in
+	// progress.
+	Uploads(ctx context.Context, key string) ([]string, error)
+
+	// CreateUpload starts a multipart upload of the key, returning its ID.
+	CreateUpload(ctx context.Context, key string) (string, error)
+
+	// UploadPart uploads a part, whose content has the given MD5 digest,
+	// returning its entity tag.
+	UploadPart(ctx context.Context, key, id string, number int, data, md5 []byte) (etag string, err error)
+
+	// Parts returns the parts uploaded so far.
+	Parts(ctx context.Context, key, id string) ([]Part, error)
+
+	// CompleteUpload makes the object of the given parts, ending the
+	// upload.
+	CompleteUpload(ctx context.Context, key, id string, parts []Part) error
+
+	// AbortUpload ends the upload, discarding its parts.
+	AbortUpload(ctx context.Context, key, id string) error
+}
+
+// Options holds the optional parameters of a Storage.
+type Options struct {
+	// Prefix is prepended to the names of the files to make their keys,
+	// e.g. "backups/db1/".
+	Prefix string
+
+	// PartSize is the size of the parts of the uploads. It must be at
+	// least the minimum of the service, e.g. 5MiB for S3.
+	//
+	// The default is 8MiB.
+	PartSize int
+
+	// Retries is the number of times a failed request is retried, the
+	// first one after RetryInterval, then doubling it.
+	//
+	// The default is 3, and the default RetryInterval 1 second.
+	Retries       int
+	RetryInterval time.Duration
+}
+
+const metaName = "CURRENT"
+
+var errWriterClosed = errors.New("objstore: writer closed")
+
+// Storage is a storage.Storage backed by a Bucket. It's safe for concurrent
+// use.
+type Storage struct {
+	b             Bucket
+	prefix        string
+	partSize      int
+	retries       int
+	retryInterval time.Duration
+	ctx           context.Context
+	cancel        context.CancelFunc
+
+	mu     sync.Mutex
+	lock   *storageLock
+	closed bool
+}
+
+var (
+	_ storage.Storage       = (*Storage)(nil)
+	_ storage.AtomicCreator = (*Storage)(nil)
+)
+
+// New returns a Storage of the files under the prefix of the bucket. The
+// options may be nil.
+func New(b Bucket, o *Options) *Storage {
+	s := &Storage{
+		b:             b,
+		partSize:      8 << 20,
+		retries:       3,
+		retryInterval: time.Second,
+	}
+	if o != nil {
+		s.prefix = o.Prefix
+		if o.PartSize > 0 {
+			s.partSize = o.PartSize
+		}
+		if o.Retries > 0 {
+			s.retries = o.Retries
+		}
+		if o.RetryInterval > 0 {
+			s.retryInterval = o.RetryInterval
+		}
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s
+}
+
+// Calls fn until it succeeds, or fails too many times or with a missing
+// object.
+func (s *Storage) do(fn func(ctx context.Context) error) error {
+	if s.ctx.Err() != nil {
+		return storage.ErrClosed
+	}
+	interval := s.retryInterval
+	for i := 0; ; i++ {
+		err := fn(s.ctx)
+		if err == nil || i == s.retries || errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		select {
+		case <-time.After(interval):
+		case <-s.ctx.Done():
+			return storage.ErrClosed
+		}
+		interval *= 2
+	}
+}
+
+func (s *Storage) key(fd storage.FileDesc) string {
+	return s.prefix + fd.String()
+}
+
+type storageLock struct {
+	s *Storage
+}
+
+func (l *storageLock) Unlock() {
+	s := l.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lock == l {
+		s.lock = nil
+	}
+}
+
+// Lock locks the storage, within the process only.
+func (s *Storage) Lock() (storage.Locker, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, storage.ErrClosed
+	}
+	if s.lock != nil {
+		return nil, storage.ErrLocked
+	}
+	s.lock = &storageLock{s}
+	return s.lock, nil
+}
+
+// Log does nothing.
+func (*Storage) Log(string) {}
+
+func (s *Storage) SetMeta(fd storage.FileDesc) error {
+	if !storage.FileDescOk(fd) {
+		return storage.ErrInvalidFile
+	}
+	data := []byte(fd.String() + "\n")
+	sum := md5.Sum(data)
+	return s.do(func(ctx context.Context) error {
+		return s.b.Put(ctx, s.prefix+metaName, data, sum[:])
+	})
+}
+
+func (s *Storage) GetMeta() (storage.FileDesc, error) {
+	var data []byte
+	err := s.do(func(ctx context.Context) error {
+		r, err := s.b.Get(ctx, s.prefix+metaName, 0, -1)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		data, err = io.ReadAll(r)
+		return err
+	})
+	if err != nil {
+		return storage.FileDesc{}, err
+	}
+	fd, ok := storage.ParseFileDesc(strings.TrimSuffix(string(data), "\n"))
+	if !ok || fd.Type != storage.TypeManifest {
+		return storage.FileDesc{}, &storage.ErrCorrupted{Err: fmt.Errorf("objstore: invalid %s: %q", metaName, data)}
+	}
+	if _, err := s.size(fd); err != nil {
+		return storage.FileDesc{}, err
+	}
+	return fd, nil
+}
+
+func (s *Storage) List(ft storage.FileType) ([]storage.FileDesc, error) {
+	var keys []string
+	err := s.do(func(ctx context.Context) (err error) {
+		keys, err = s.b.List(ctx, s.prefix)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var fds []storage.FileDesc
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, s.prefix)
+		if strings.Contains(name, "/") {
+			continue
+		}
+		if fd, ok := storage.ParseFileDesc(name); ok && fd.Type&ft != 0 {
+			fds = append(fds, fd)
+		}
+	}
+	return fds, nil
+}
+
+func (s *Storage) size(fd storage.FileDesc) (size int64, err error) {
+	err = s.do(func(ctx context.Context) (err error) {
+		size, err = s.b.Size(ctx, s.key(fd))
+		return err
+	})
+	return size, err
+}
+
+func (s *Storage) Open(fd storage.FileDesc) (storage.Reader, error) {
+	if !storage.FileDescOk(fd) {
+		return nil, storage.ErrInvalidFile
+	}
+	size, err := s.size(fd)
+	if err != nil {
+		return nil, err
+	}
+	return &reader{s: s, key: s.key(fd), size: size}, nil
+}
+
+// Create returns a writer uploading the file, which only appears once the
+// writer is closed. The upload of the file is resumed if a previous one
+// was interrupted.
+func (s *Storage) Create(fd storage.FileDesc) (storage.Writer, error) {
+	if !storage.FileDescOk(fd) {
+		return nil, storage.ErrInvalidFile
+	}
+	if s.ctx.Err() != nil {
+		return nil, storage.ErrClosed
+	}
+	return &writer{s: s, key: s.key(fd)}, nil
+}
+
+// Abort discards the file being written by the writer. The parts already
+// uploaded are kept, to resume the upload when the file is created again.
+func (s *Storage) Abort(w storage.Writer) error {
+	ow, ok := w.(*writer)
+	if !ok || ow.s != s {
+		return errors.New("objstore: writer of another storage")
+	}
+	ow.buf = nil
+	ow.err = errWriterClosed
+	return nil
+}
+
+func (s *Storage) Remove(fd storage.FileDesc) error {
+	if !storage.FileDescOk(fd) {
+		return storage.ErrInvalidFile
+	}
+	if _, err := s.size(fd); err != nil {
+		return err
+	}
+	return s.do(func(ctx context.Context) error {
+		return s.b.Delete(ctx, s.key(fd))
+	})
+}
+
+// Rename copies the file within the bucket, and deletes the old one.
+func (s *Storage) Rename(oldfd, newfd storage.FileDesc) error {
+	if !storage.FileDescOk(oldfd) || !storage.FileDescOk(newfd) {
+		return storage.ErrInvalidFile
+	}
+	if oldfd == newfd {
+		return nil
+	}
+	if err := s.do(func(ctx context.Context) error {
+		return s.b.Copy(ctx, s.key(oldfd), s.key(newfd))
+	}); err != nil {
+		return err
+	}
+	return s.do(func(ctx context.Context) error {
+		return s.b.Delete(ctx, s.key(oldfd))
+	})
+}
+
+// Close closes the storage, cancelling the requests in flight. It doesn't
+// close the bucket.
+func (s *Storage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.cancel()
+	return nil
+}
+
+type reader struct {
+	s    *Storage
+	key  string
+	size int64
+	off  int64
+	// The body of a request for the rest of the object, for sequential
+	// reads, at bodyOff.
+	body    io.ReadCloser
+	bodyOff int64
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.off >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil || r.bodyOff != r.off {
+		r.closeBody()
+		err := r.s.do(func(ctx context.Context) (err error) {
+			r.body, err = r.s.b.Get(ctx, r.key, r.off, -1)
+			return err
+		})
+		if err != nil {
+			return 0, err
+		}
+		r.bodyOff = r.off
+	}
+	n, err := r.body.Read(p)
+	r.off += int64(n)
+	r.bodyOff = r.off
+	if err == io.EOF {
+		r.closeBody()
+		if r.off < r.size {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	return n, err
+}
+
+func (r *reader) closeBody() {
+	if r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+}
+
+func (r *reader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	n := int64(len(p))
+	if off+n > r.size {
+		n = r.size - off
+	}
+	err := r.s.do(func(ctx context.Context) error {
+		body, err := r.s.b.Get(ctx, r.key, off, n)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		_, err = io.ReadFull(body, p[:n])
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if int(n) < len(p) {
+		return int(n), io.EOF
+	}
+	return int(n), nil
+}
+
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("objstore: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("objstore: negative position")
+	}
+	r.off = offset
+	return offset, nil
+}
+
+func (r *reader) Close() error {
+	r.closeBody()
+	return nil
+}
+
+type writer struct {
+	s    *Storage
+	key  string
+	buf  []byte
+	size int64
+	err  error
+
+	// The multipart upload, once the first part is written, and the parts
+	// uploaded by a previous attempt.
+	id      string
+	parts   []Part
+	resumed map[int]Part
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.buf = append(w.buf, p...)
+	for len(w.buf) >= w.s.partSize {
+		if err := w.uploadPart(w.buf[:w.s.partSize]); err != nil {
+			w.err = err
+			return 0, err
+		}
+		w.buf = append(w.buf[:0], w.buf[w.s.partSize:]...)
+	}
+	return len(p), nil
+}
+
+// Sync does nothing, the file is only stored once the writer is closed.
+func (w *writer) Sync() error {
+	return w.err
+}
+
+// Starts the multipart upload, resuming one in progress if any.
+func (w *writer) start() error {
+	s := w.s
+	var ids []string
+	if err := s.do(func(ctx context.Context) (err error) {
+		ids, err = s.b.Uploads(ctx, w.key)
+		return err
+	}); err != nil {
+		return err
+	}
+	for _, id := range ids[min(len(ids), 1):] {
+		if err := s.do(func(ctx context.Context) error {
+			return s.b.AbortUpload(ctx, w.key, id)
+		}); err != nil {
+			return err
+		}
+	}
+	if len(ids) > 0 {
+		var parts []Part
+		if err := s.do(func(ctx context.Context) (err error) {
+			parts, err = s.b.Parts(ctx, w.key, ids[0])
+			return err
+		}); err != nil {
+			return err
+		}
+		w.id = ids[0]
+		w.resumed = make(map[int]Part)
+		for _, p := range parts {
+			w.resumed[p.Number] = p
+		}
+		return nil
+	}
+	return s.do(func(ctx context.Context) (err error) {
+		w.id, err = s.b.CreateUpload(ctx, w.key)
+		return err
+	})
+}
+
+func (w *writer) uploadPart(data []byte) error {
+	if w.id == "" {
+		if err := w.start(); err != nil {
+			return err
+		}
+	}
+	number := len(w.parts) + 1
+	sum := md5.Sum(data)
+	part := Part{Number: number, Size: int64(len(data))}
+	if p, ok := w.resumed[number]; ok && p.Size == part.Size && strings.Trim(p.ETag, `"`) == hex.EncodeToString(sum[:]) {
+		part.ETag = p.ETag
+	} else if err := w.s.do(func(ctx context.Context) (err error) {
+		part.ETag, err = w.s.b.UploadPart(ctx, w.key, w.id, number, data, sum[:])
+		return err
+	}); err != nil {
+		return err
+	}
+	w.parts = append(w.parts, part)
+	w.size += part.Size
+	return nil
+}
+
+func (w *writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = errWriterClosed
+	s := w.s
+	if w.id == "" {
+		data := w.buf
+		sum := md5.Sum(data)
+		w.size += int64(len(data))
+		if err := s.do(func(ctx context.Context) error {
+			return s.b.Put(ctx, w.key, data, sum[:])
+		}); err != nil {
+			return err
+		}
+	} else {
+		if len(w.buf) > 0 {
+			if err := w.uploadPart(w.buf); err != nil {
+				return err
+			}
+		}
+		if err := s.do(func(ctx context.Context) error {
+			return s.b.CompleteUpload(ctx, w.key, w.id, w.parts)
+		}); err != nil {
+			return err
+		}
+	}
+	w.buf = nil
+
+	var size int64
+	if err := s.do(func(ctx context.Context) (err error) {
+		size, err = s.b.Size(ctx, w.key)
+		return err
+	}); err != nil {
+		return err
+	}
+	if size != w.size {
+		return fmt.Errorf("objstore: %s uploaded as %d bytes, want %d", w.key, size, w.size)
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// faultyBucket fails the uploads of parts after a number of them, or
+// corrupts their content.
+type faultyBucket struct {
+	*MemBucket
+	partsLeft int
+	corrupt   bool
+	uploaded  int
+}
+
+func (b *faultyBucket) UploadPart(ctx context.Context, key, id string, number int, data, md5 []byte) (string, error) {
+	if b.partsLeft == 0 {
+		return "", errors.New("connection reset")
+	}
+	b.partsLeft--
+	if b.corrupt {
+		data = append([]byte{data[0] ^ 1}, data[1:]...)
+	}
+	b.uploaded++
+	return b.MemBucket.UploadPart(ctx, key, id, number, data, md5)
+}
+
+func testOptions() *Options {
+	return &Options{Prefix: "db/", PartSize: 100, Retries: 1, RetryInterval: time.Millisecond}
+}
+
+func writeFile(s *Storage, fd storage.FileDesc, data []byte) error {
+	w, err := s.Create(fd)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		s.Abort(w)
+		return err
+	}
+	return w.Close()
+}
+
+func readFile(t *testing.T, s *Storage, fd storage.FileDesc) []byte {
+	t.Helper()
+	r, err := s.Open(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestStorage(t *testing.T) {
+	b := NewMemBucket()
+	s := New(b, testOptions())
+	defer s.Close()
+	small := storage.FileDesc{Type: storage.TypeManifest, Num: 1}
+	large := storage.FileDesc{Type: storage.TypeTable, Num: 2}
+	data := bytes.Repeat([]byte("0123456789"), 25)
+	if err := writeFile(s, small, []byte("manifest")); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile(s, large, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetMeta(small); err != nil {
+		t.Fatal(err)
+	}
+	if fd, err := s.GetMeta(); err != nil || fd != small {
+		t.Fatalf("GetMeta: got %v, %v", fd, err)
+	}
+	if fds, err := s.List(storage.TypeTable); err != nil || fmt.Sprint(fds) != "[000002.ldb]" {
+		t.Fatalf("List: got %v, %v", fds, err)
+	}
+	if got := readFile(t, s, large); !bytes.Equal(got, data) {
+		t.Fatalf("read %q", got)
+	}
+	r, _ := s.Open(large)
+	buf := make([]byte, 20)
+	if n, err := r.ReadAt(buf, 240); n != 10 || err != io.EOF || string(buf[:n]) != "0123456789" {
+		t.Fatalf("ReadAt: got %d %q, %v", n, buf[:n], err)
+	}
+	r.Close()
+
+	moved := storage.FileDesc{Type: storage.TypeTable, Num: 3}
+	if err := s.Rename(large, moved); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Open(large); !os.IsNotExist(err) {
+		t.Fatalf("Open renamed: got %v", err)
+	}
+	if err := s.Remove(moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Remove(moved); !os.IsNotExist(err) {
+		t.Fatalf("Remove removed: got %v", err)
+	}
+
+	l, err := s.Lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Lock(); err != storage.ErrLocked {
+		t.Fatalf("Lock locked: got %v", err)
+	}
+	l.Unlock()
+}
+
+func TestStorageResume(t *testing.T) {
+	b := &faultyBucket{MemBucket: NewMemBucket(), partsLeft: 3}
+	s := New(b, testOptions())
+	defer s.Close()
+	fd := storage.FileDesc{Type: storage.TypeTable, Num: 1}
+	data := bytes.Repeat([]byte("0123456789"), 55)
+	if err := writeFile(s, fd, data); err == nil {
+		t.Fatal("upload succeeded")
+	}
+	if _, err := s.Open(fd); !os.IsNotExist(err) {
+		t.Fatalf("partial file: got %v", err)
+	}
+
+	// Only the parts missing are uploaded.
+	b.partsLeft, b.uploaded = -1, 0
+	if err := writeFile(s, fd, data); err != nil {
+		t.Fatal(err)
+	}
+	if b.uploaded != 3 {
+		t.Fatalf("uploaded %d parts", b.uploaded)
+	}
+	if got := readFile(t, s, fd); !bytes.Equal(got, data) {
+		t.Fatalf("read %q", got)
+	}
+	if ids, _ := b.Uploads(context.Background(), "db/"+fd.String()); len(ids) != 0 {
+		t.Fatalf("uploads left: %v", ids)
+	}
+}
+
+func TestStorageIntegrity(t *testing.T) {
+	b := &faultyBucket{MemBucket: NewMemBucket(), partsLeft: -1, corrupt: true}
+	s := New(b, testOptions())
+	defer s.Close()
+	if err := writeFile(s, storage.FileDesc{Type: storage.TypeTable, Num: 1}, make([]byte, 300)); err == nil {
+		t.Fatal("corrupted upload succeeded")
+	}
+}
+
+func TestBackup(t *testing.T) {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i := 0; i < 100; i++ {
+		db.Put([]byte(fmt.Sprintf("k%03d", i)), bytes.Repeat([]byte{'v'}, 10), nil)
+	}
+	db.CompactRange(util.Range{})
+	db.Put([]byte("last"), []byte("1"), nil)
+
+	s := New(NewMemBucket(), testOptions())
+	defer s.Close()
+	if _, err := db.Backup(s, nil); err != nil {
+		t.Fatal(err)
+	}
+	restored := storage.NewMemStorage()
+	if err := leveldb.RestoreBackup(s, restored, nil); err != nil {
+		t.Fatal(err)
+	}
+	rdb, err := leveldb.Open(restored, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rdb.Close()
+	for _, key := range []string{"k000", "k099", "last"} {
+		if ok, err := rdb.Has([]byte(key), nil); !ok || err != nil {
+			t.Errorf("%s: got %v, %v", key, ok, err)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package opt
+
+import (
+	"time"
+)
+
+// TableReason is the reason a 'sorted table' was created.
+type TableReason uint
+
+func (r TableReason) String() string {
+	switch r {
+	case TableReasonFlush:
+		return "flush"
+	case TableReasonCompaction:
+		return "compaction"
+	case TableReasonTransaction:
+		return "transaction"
+	case TableReasonRecovery:
+		return "recovery"
+	}
+	return "invalid"
+}
+
+const (
+	// TableReasonFlush means the table was created by 'memdb' flush.
+	TableReasonFlush TableReason = iota
+
+	// TableReasonCompaction means the table was created by table compaction.
+	TableReasonCompaction
+
+	// TableReasonTransaction means the table was created by transaction.
+	TableReasonTransaction
+
+	// TableReasonRecovery means the table was created while recovering
+	// journals.
+	TableReasonRecovery
+)
+
+// CompactionReason is the reason a table compaction was started.
+type CompactionReason uint
+
+func (r CompactionReason) String() string {
+	switch r {
+	case CompactionReasonManual:
+		return "manual"
+	case CompactionReasonLevel0:
+		return "level0"
+	case CompactionReasonNonLevel0:
+		return "non-level0"
+	case CompactionReasonSeek:
+		return "seek"
+	}
+	return "invalid"
+}
+
+const (
+	// CompactionReasonManual means the compaction was requested by
+	// CompactRange.
+	CompactionReasonManual CompactionReason = iota
+
+	// CompactionReasonLevel0 means the compaction was triggered by
+	// number of level-0 tables.
+	CompactionReasonLevel0
+
+	// CompactionReasonNonLevel0 means the compaction was triggered by
+	// total size of a level.
+	CompactionReasonNonLevel0
+
+	// CompactionReasonSeek means the compaction was triggered by seeks.
+	CompactionReasonSeek
+)
+
+// TableInfo describes a 'sorted table' file.
+type TableInfo struct {
+	// Num is the table file number.
+	Num int64
+
+	// Level is the level the table belongs to. It is -1 if unknown,
+	// e.g. for deleted table.
+	Level int
+
+	// Size is the table file size. It is zero if unknown.
+	Size int64
+
+	// Reason is why the table was created; only meaningful for table
+	// creation event.
+	Reason TableReason
+
+	// Err is set if the table file removal failed; only meaningful for
+	// table deletion event.
+	Err error
+}
+
+// FlushInfo describes a 'memdb' flush.
+type FlushInfo struct {
+	// Entries is number of entries of the flushed 'memdb'.
+	Entries int
+
+	// Size is the size of the flushed 'memdb'.
+	Size int
+
+	// Level is the level of the resulting table. Only valid for flush end
+	// event.
+	Level int
+
+	// Tables holds the created tables. Only valid for flush end event.
+	Tables []TableInfo
+
+	// Duration is the time spent flushing. Only valid for flush end event.
+	Duration time.Duration
+}
+
+// CompactionInfo describes a table compaction.
+type CompactionInfo struct {
+	// Reason is why the compaction was started.
+	Reason CompactionReason
+
+	// SourceLevel is the level being compacted, its tables will be merged
+	// into SourceLevel+1.
+	SourceLevel int
+
+	// Trivial is true if the compaction only moves a table to the next
+	// level without rewriting it.
+	Trivial bool
+
+	// Inputs holds the input tables.
+	Inputs []TableInfo
+
+	// InputBytes is the sum of input tables size.
+	InputBytes int64
+
+	// Outputs holds the created tables. Only valid for compaction end event.
+	Outputs []TableInfo
+
+	// OutputBytes is the sum of output tables size. Only valid for
+	// compaction end event.
+	OutputBytes int64
+
+	// Duration is the time spent compacting. Only valid for compaction end
+	// event.
+	Duration time.Duration
+}
+
+// RecoveryInfo describes a journal recovery.
+type RecoveryInfo struct {
+	// Journals is number of journal files being recovered.
+	Journals int
+
+	// SeqNum is the last sequence number. Only valid for recovery end
+	// event.
+	SeqNum uint64
+
+	// Duration is the time spent recovering. Only valid for recovery end
+	// event.
+	Duration time.Duration
+
+	// Err is the recovery error, if any. Only valid for recovery end event.
+	Err error
+}
+
+// EventListener is notified of DB lifecycle events. The methods are called
+// synchronously by the goroutine doing the operation, so they must return
+// quickly and must not call back into the DB.
+//
+// NoopEventListener can be embedded to implement only a subset of the
+// methods.
+type EventListener interface {
+	// OnFlushBegin is called before a 'memdb' is flushed.
+	OnFlushBegin(info FlushInfo)
+
+	// OnFlushEnd is called after a 'memdb' flush is committed.
+	OnFlushEnd(info FlushInfo)
+
+	// OnCompactionBegin is called before a table compaction starts.
+	OnCompactionBegin(info CompactionInfo)
+
+	// OnCompactionEnd is called after a table compaction is committed.
+	OnCompactionEnd(info CompactionInfo)
+
+	// OnTableCreated is called after a table file is written.
+	OnTableCreated(info TableInfo)
+
+	// OnTableDeleted is called after an obsolete table file is removed.
+	OnTableDeleted(info TableInfo)
+
+	// OnRecoveryBegin is called before journals are replayed on open.
+	OnRecoveryBegin(info RecoveryInfo)
+
+	// OnRecoveryEnd is called after journals are replayed on open.
+	OnRecoveryEnd(info RecoveryInfo)
+}
+
+// NoopEventListener is an EventListener that does nothing.
+type NoopEventListener struct{}
+
+func (NoopEventListener) OnFlushBegin(info FlushInfo)           {}
+func (NoopEventListener) OnFlushEnd(info FlushInfo)             {}
+func (NoopEventListener) OnCompactionBegin(info CompactionInfo) {}
+func (NoopEventListener) OnCompactionEnd(info CompactionInfo)   {}
+func (NoopEventListener) OnTableCreated(info TableInfo)         {}
+func (NoopEventListener) OnTableDeleted(info TableInfo)         {}
+func (NoopEventListener) OnRecoveryBegin(info RecoveryInfo)     {}
+func (NoopEventListener) OnRecoveryEnd(info RecoveryInfo)       {}
@@ -0,0 +1,57 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package opt
+
+// Logger is a leveled, structured logger receiving the internal events of a
+// DB, such as recovery steps, flushes, compaction decisions and write
+// stalls. Each event has a short message, e.g. "table@compaction", followed
+// by alternating keys and values; keys are strings and values are usually
+// integers, durations, errors or keys.
+//
+// The method set matches *slog.Logger, which may be used directly; other
+// logging libraries, such as zap's SugaredLogger, need a thin adapter.
+// Methods may be called concurrently.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+// LogLevel is the severity of a logged event.
+type LogLevel int
+
+const (
+	// LogDebug is for detailed events, such as version statistics and
+	// compaction planning, useful when diagnosing the DB.
+	LogDebug LogLevel = iota
+
+	// LogInfo is for the normal course of events, such as opening,
+	// flushes and compactions.
+	LogInfo
+
+	// LogWarn is for recoverable problems, such as skipped corrupted
+	// records, write stalls and retried compactions.
+	LogWarn
+
+	// LogError is for errors which stop background work.
+	LogError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogDebug:
+		return "debug"
+	case LogInfo:
+		return "info"
+	case LogWarn:
+		return "warn"
+	case LogError:
+		return "error"
+	}
+	return "unknown"
+}
@@ -9,10 +9,13 @@ package opt
 
 import (
 	"math"
+	"time"
 
 	"github.com/syndtr/goleveldb/leveldb/cache"
 	"github.com/syndtr/goleveldb/leveldb/comparer"
 	"github.com/syndtr/goleveldb/leveldb/filter"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 const (
@@ -29,6 +32,7 @@ var (
 	DefaultCompactionExpandLimitFactor   = 25
 	DefaultCompactionGPOverlapsFactor    = 10
 	DefaultCompactionL0Trigger           = 4
+	DefaultCompactionReadAheadSize       = 2 * MiB
 	DefaultCompactionSourceLimitFactor   = 1
 	DefaultCompactionTableSize           = 2 * MiB
 	DefaultCompactionTableSizeMultiplier = 1.0
@@ -36,10 +40,16 @@ var (
 	DefaultCompactionTotalSizeMultiplier = 10.0
 	DefaultCompressionType               = SnappyCompression
 	DefaultIteratorSamplingRate          = 1 * MiB
+	DefaultMaxFrozenWriteBuffer          = 1
+	DefaultMemtableType                  = SkiplistMemtable
 	DefaultOpenFilesCacher               = LRUCacher
+	DefaultPinIndexAndFilterBlocksBudget = 16 * MiB
+	DefaultPrefetchTrigger               = 2
 	DefaultWriteBuffer                   = 4 * MiB
+	DefaultWriteChunkSize                = 1 * MiB
 	DefaultWriteL0PauseTrigger           = 12
 	DefaultWriteL0SlowdownTrigger        = 8
+	DefaultWriteMergeMaxSize             = 1 * MiB
 	DefaultFilterBaseLg                  = 11
 	DefaultMaxManifestFileSize           = int64(64 * MiB)
 )
@@ -97,10 +107,28 @@ func NewLRU(capacity int) Cacher {
 	return PassthroughCacher(cache.NewLRU(capacity))
 }
 
+// NewStrictLRU creates strict LRU 'passthrough cacher'.
+func NewStrictLRU(capacity int) Cacher {
+	return PassthroughCacher(cache.NewStrictLRU(capacity))
+}
+
+// NewS3FIFO creates S3-FIFO 'passthrough cacher'.
+func NewS3FIFO(capacity int) Cacher {
+	return PassthroughCacher(cache.NewS3FIFO(capacity))
+}
+
 var (
 	// LRUCacher is the LRU-cache algorithm.
 	LRUCacher = CacherFunc(cache.NewLRU)
 
+	// StrictLRUCacher is the LRU-cache algorithm which never exceeds its
+	// capacity.
+	StrictLRUCacher = CacherFunc(cache.NewStrictLRU)
+
+	// S3FIFOCacher is the S3-FIFO cache algorithm, which unlike LRU is
+	// resistant to large scans evicting frequently accessed entries.
+	S3FIFOCacher = CacherFunc(cache.NewS3FIFO)
+
 	// NoCacher is the value to disable caching algorithm.
 	NoCacher = CacherFunc(nil)
 )
@@ -127,6 +155,28 @@ const (
 	nCompression
 )
 
+// Checksum is the 'sorted table' block checksum algorithm to use.
+type Checksum uint
+
+func (c Checksum) String() string {
+	switch c {
+	case DefaultChecksum:
+		return "default"
+	case CRC32CChecksum:
+		return "crc32c"
+	case XXHash64Checksum:
+		return "xxhash64"
+	}
+	return "invalid"
+}
+
+const (
+	DefaultChecksum Checksum = iota
+	CRC32CChecksum
+	XXHash64Checksum
+	nChecksum
+)
+
 // Strict is the DB 'strict level'.
 type Strict uint
 
@@ -173,8 +223,135 @@ const (
 	NoStrict = ^StrictAll
 )
 
+// TablePropertiesCollector collects user-defined properties of a table
+// while it's written. A collector is only used for a single table.
+type TablePropertiesCollector interface {
+	// Add is called for every entry appended to the table, in key order.
+	// For tables written by the DB, key is the user key and deleted
+	// reports whether the entry is a deletion. The arguments must not be
+	// retained after Add returns.
+	Add(key, value []byte, deleted bool)
+
+	// Finish is called after the last entry was added, and adds the
+	// collected properties to props. Names starting with "leveldb." are
+	// reserved.
+	Finish(props map[string][]byte)
+}
+
+// Memtable is the 'memdb' implementation to use.
+type Memtable uint
+
+func (m Memtable) String() string {
+	switch m {
+	case DefaultMemtable:
+		return "default"
+	case SkiplistMemtable:
+		return "skiplist"
+	case HashSkiplistMemtable:
+		return "hash-skiplist"
+	}
+	return "invalid"
+}
+
+const (
+	DefaultMemtable Memtable = iota
+	SkiplistMemtable
+	HashSkiplistMemtable
+	nMemtable
+)
+
+// WriteStallCondition is the DB write stall condition.
+type WriteStallCondition uint
+
+func (c WriteStallCondition) String() string {
+	switch c {
+	case WriteStallNormal:
+		return "normal"
+	case WriteStallDelayed:
+		return "delayed"
+	case WriteStallStopped:
+		return "stopped"
+	}
+	return "invalid"
+}
+
+const (
+	// WriteStallNormal means writes are not throttled.
+	WriteStallNormal WriteStallCondition = iota
+
+	// WriteStallDelayed means writes are being slowed down.
+	WriteStallDelayed
+
+	// WriteStallStopped means writes are paused until compaction catches up.
+	WriteStallStopped
+)
+
+// WriteStallReason is the cause of a write stall condition.
+type WriteStallReason uint
+
+func (r WriteStallReason) String() string {
+	switch r {
+	case WriteStallReasonNone:
+		return "none"
+	case WriteStallReasonL0Files:
+		return "level0-files"
+	case WriteStallReasonMemdb:
+		return "memdb"
+	case WriteStallReasonPendingCompactionBytes:
+		return "pending-compaction-bytes"
+	}
+	return "invalid"
+}
+
+const (
+	// WriteStallReasonNone is used when write isn't stalled.
+	WriteStallReasonNone WriteStallReason = iota
+
+	// WriteStallReasonL0Files means number of level-0 'sorted table' has
+	// reached WriteL0SlowdownTrigger or WriteL0PauseTrigger.
+	WriteStallReasonL0Files
+
+	// WriteStallReasonMemdb means the previous 'memdb' is still being
+	// flushed, so no new 'memdb' can be created.
+	WriteStallReasonMemdb
+
+	// WriteStallReasonPendingCompactionBytes means the estimated number of
+	// bytes the compactions have to rewrite has reached
+	// WritePendingCompactionBytesSlowdownTrigger or
+	// WritePendingCompactionBytesPauseTrigger.
+	WriteStallReasonPendingCompactionBytes
+)
+
+// WriteStallInfo describes a write stall condition transition.
+type WriteStallInfo struct {
+	// Condition is the new condition.
+	Condition WriteStallCondition
+
+	// PrevCondition is the condition before the transition.
+	PrevCondition WriteStallCondition
+
+	// Reason is the cause of the new condition.
+	Reason WriteStallReason
+
+	// Level0Tables is number of level-0 'sorted table' at the time of
+	// the transition.
+	Level0Tables int
+
+	// PendingCompactionBytes is the estimated number of bytes the
+	// compactions have to rewrite at the time of the transition.
+	PendingCompactionBytes int64
+}
+
 // Options holds the optional parameters for the DB at large.
 type Options struct {
+	// AdminLog enables persisting administrative events, such as opening,
+	// closing, manual compactions and repairs, to the storage, if it
+	// implements storage.AdminLogger, so that they survive reopening. See
+	// DB.AdminEvents and ReadAdminLog.
+	//
+	// The default value is false.
+	AdminLog bool
+
 	// AltFilters defines one or more 'alternative filters'.
 	// 'alternative filters' will be used during reads if a filter block
 	// does not match with the 'effective filter'.
@@ -182,14 +359,30 @@ type Options struct {
 	// The default value is nil
 	AltFilters []filter.Filter
 
+	// AmplificationStats enables tracking of the read amplification, that
+	// is tables looked up per Get, and of the write amplification, that is
+	// bytes flushed and compacted per byte written, by level over the last
+	// hour. See DB.Amplification.
+	//
+	// The default value is false.
+	AmplificationStats bool
+
 	// BlockCacher provides cache algorithm for LevelDB 'sorted table' block caching.
-	// Specify NoCacher to disable caching algorithm.
+	// Specify NoCacher to disable caching algorithm. Specify StrictLRUCacher
+	// to never exceed BlockCacheCapacity, even when blocks in use can't be
+	// evicted. Specify S3FIFOCacher to keep full-table scans from evicting
+	// frequently read blocks. Specify a PassthroughCacher, e.g. NewLRU, to
+	// share a cache over multiple DB instances: the capacity is then that of
+	// the cacher, BlockCacheCapacity only enabling it, and a DB evicts only
+	// its own blocks when closed.
 	//
 	// The default value is LRUCacher.
 	BlockCacher Cacher
 
 	// BlockCacheCapacity defines the capacity of the 'sorted table' block caching.
 	// Use -1 for zero, this has same effect as specifying NoCacher to BlockCacher.
+	// The capacity is in bytes and accounts for the per-block bookkeeping
+	// overhead in addition to the block data.
 	//
 	// The default value is 8MiB.
 	BlockCacheCapacity int
@@ -200,18 +393,84 @@ type Options struct {
 	// The default if false.
 	BlockCacheEvictRemoved bool
 
+	// CompressedBlockCacheCapacity defines the capacity of the compressed
+	// block cache, a second block caching tier which holds compressed blocks
+	// as stored in the 'sorted table'. A block evicted from the block cache
+	// is then decompressed from it instead of being read from the file,
+	// which allows caching more blocks at the cost of decompression.
+	// Uncompressed blocks are never held in it. The cache algorithm is
+	// provided by BlockCacher. Zero disables the compressed block cache.
+	//
+	// The default value is 0.
+	CompressedBlockCacheCapacity int
+
+	// BlockChecksum defines the 'sorted table' block checksum algorithm to
+	// use for newly written tables. The algorithm is recorded in the table
+	// footer, so tables written with either algorithm remain readable.
+	// Note that tables using xxHash64 can't be read by versions which
+	// predate it.
+	//
+	// The default value (DefaultChecksum) uses CRC-32C.
+	BlockChecksum Checksum
+
 	// BlockRestartInterval is the number of keys between restart points for
 	// delta encoding of keys.
 	//
 	// The default value is 16.
 	BlockRestartInterval int
 
+	// BlockRestartIntervalPerLevel defines per-level BlockRestartInterval of
+	// 'sorted table' written by compaction into the level. Tables flushed
+	// from memdb use the level-0 value.
+	// Use zero to skip a level.
+	//
+	// The default value is nil.
+	BlockRestartIntervalPerLevel []int
+
 	// BlockSize is the minimum uncompressed size in bytes of each 'sorted table'
 	// block.
 	//
 	// The default value is 4KiB.
 	BlockSize int
 
+	// BlockSizePerLevel defines per-level BlockSize of 'sorted table' written
+	// by compaction into the level, e.g. larger blocks at the bottom level
+	// for better compression and smaller ones at level-0 for faster reads.
+	// Tables flushed from memdb use the level-0 value.
+	// Use zero to skip a level.
+	//
+	// The default value is nil.
+	BlockSizePerLevel []int
+
+	// CloseFlushMemtable defines whether closing the DB first flushes the
+	// memtable to a table, so that the journal isn't replayed when the DB is
+	// opened again.
+	//
+	// The default value is false.
+	CloseFlushMemtable bool
+
+	// CloseSyncJournal defines whether closing the DB first syncs the
+	// journal, so that the writes made without WriteOptions.Sync survive a
+	// crash following the close.
+	//
+	// The default value is false.
+	CloseSyncJournal bool
+
+	// CloseWaitCompaction defines whether closing the DB first waits for the
+	// pending compactions to finish, rather than aborting them.
+	//
+	// The default value is false.
+	CloseWaitCompaction bool
+
+	// CompactionConcurrency defines the number of table compactions a DB
+	// may run at a time. Compactions running together have no input table
+	// in common, and at most one of them compacts level-0, so they never
+	// write overlapping tables. Their commits to the manifest are still
+	// serialized.
+	//
+	// The default value is 1.
+	CompactionConcurrency int
+
 	// CompactionExpandLimitFactor limits compaction size after expanded.
 	// This will be multiplied by table size limit at compaction target level.
 	//
@@ -231,6 +490,44 @@ type Options struct {
 	// The default value is 4.
 	CompactionL0Trigger int
 
+	// CompactionOnlyBlockChecksum defines whether 'sorted table' data block
+	// checksums, if enabled by StrictBlockChecksum, are only verified by
+	// compaction reads. User reads then skip verification, which can be
+	// re-enabled at runtime with DB.SetVerifyBlockChecksum. Corruption is
+	// still caught once the data is compacted.
+	//
+	// The default value is false.
+	CompactionOnlyBlockChecksum bool
+
+	// CompactionPolicy defines when the automatic table compactions may
+	// run, e.g. off-peak or while on AC power, see CompactionWindows. While
+	// it returns false, the compactions wait, unless level-0 holds
+	// CompactionPolicyL0Trigger tables or writes pause on
+	// WritePendingCompactionBytesPauseTrigger. It's called by the
+	// compaction goroutine when compactions are due, and every minute while
+	// they wait, so it must not block.
+	// The memdb flushes and CompactRange aren't restricted.
+	//
+	// The default value is nil, which runs the compactions at any time.
+	CompactionPolicy func(now time.Time) bool
+
+	// CompactionPolicyL0Trigger defines the number of level-0 tables from
+	// which the automatic table compactions run regardless of
+	// CompactionPolicy, so that reads don't degrade and writes don't pause
+	// indefinitely. It must not be greater than WriteL0PauseTrigger.
+	//
+	// The default value is WriteL0SlowdownTrigger.
+	CompactionPolicyL0Trigger int
+
+	// CompactionReadAheadSize defines the read-ahead size of compaction input
+	// 'sorted table' reads. Compaction reads its input tables sequentially,
+	// so reading ahead in large chunks rather than block by block cuts the
+	// number of reads, which helps on spinning disks and network storage.
+	// Use -1 to disable read-ahead.
+	//
+	// The default value is 2MiB.
+	CompactionReadAheadSize int
+
 	// CompactionSourceLimitFactor limits compaction source size. This doesn't apply to
 	// level-0.
 	// This will be multiplied by table size limit at compaction target level.
@@ -281,7 +578,9 @@ type Options struct {
 
 	// Comparer defines a total ordering over the space of []byte keys: a 'less
 	// than' relationship. The same comparison algorithm must be used for reads
-	// and writes over the lifetime of the DB.
+	// and writes over the lifetime of the DB. If all keys have the same
+	// length, comparer.NewFixedLengthComparer gives the same ordering as
+	// the default, with faster comparisons and shorter index separators.
 	//
 	// The default value uses the same ordering as bytes.Compare.
 	Comparer comparer.Comparer
@@ -346,10 +645,30 @@ type Options struct {
 	// filter during transition period.
 	//
 	// A filter is used to reduce disk reads when looking for a specific key.
+	// See filter.NewBloomFilter and filter.NewRibbonFilter. A filter created
+	// with filter.NewPrefixFilter also allows iterators over util.BytesPrefix
+	// ranges to skip tables without such prefix, given the default comparer.
 	//
 	// The default value is nil.
 	Filter filter.Filter
 
+	// EventListener defines a listener that will be notified of DB
+	// lifecycle events, such as flushes, compactions and table file
+	// creation or deletion.
+	//
+	// The default value is nil.
+	EventListener EventListener
+
+	// IndexPartitionSize enables partitioned 'sorted table' index and
+	// filter blocks, and defines the minimum size in bytes of each index
+	// partition. Only the partition holding a key then needs to be read,
+	// rather than the whole index and filter of a large table. Tables with
+	// partitioned index can't be read by versions without this option.
+	// Zero disables partitioning.
+	//
+	// The default value is 0.
+	IndexPartitionSize int
+
 	// IteratorSamplingRate defines approximate gap (in bytes) between read
 	// sampling of an iterator. The samples will be used to determine when
 	// compaction should be triggered.
@@ -359,6 +678,103 @@ type Options struct {
 	// The default is 1MiB.
 	IteratorSamplingRate int
 
+	// JournalArchive defines the storage obsolete journal files are copied
+	// to before being deleted, e.g. a storage.OpenFile directory, for
+	// point-in-time recovery or change data capture, see
+	// leveldb.ListJournalArchive and leveldb.DB.ReplayJournalArchive. The
+	// files are copied as stored, i.e. encrypted if the DB is. Journals are
+	// archived in the background, and each is kept in the DB until
+	// archived: a failed copy is logged and retried, failing neither the
+	// flushes nor the opening of the DB. Archived journals can't be
+	// recycled, see RecycleJournalFiles.
+	//
+	// The default value is nil, which means obsolete journals are deleted.
+	JournalArchive storage.Storage
+
+	// JournalArchiveMaxFiles defines the maximum number of journal files
+	// kept in JournalArchive; the oldest ones are deleted beyond it.
+	//
+	// The default value is 0, which means no limit.
+	JournalArchiveMaxFiles int
+
+	// JournalArchiveMaxSize defines the maximum total size in bytes of the
+	// journal files kept in JournalArchive; the oldest ones are deleted
+	// beyond it, but for the newest one.
+	//
+	// The default value is 0, which means no limit.
+	JournalArchiveMaxSize int64
+
+	// JournalCompression defines the compression of journal records. Each
+	// record, holding one or more merged write batches, is compressed as a
+	// whole; records which don't shrink are written uncompressed. Journals
+	// are readable regardless of this setting.
+	//
+	// The default value (DefaultCompression) means no compression.
+	JournalCompression Compression
+
+	// LatencyHistograms enables latency histograms of Get, Write, iterator
+	// Seek and Next, flushes and compactions, reported by DB.Stats. GetTo
+	// and GetMulti calls count as gets. Each recorded operation costs two
+	// clock reads and a few atomic adds.
+	//
+	// The default value is false.
+	LatencyHistograms bool
+
+	// LockTimeout defines how long opening a DB, e.g. by Open or OpenFile,
+	// waits for the lock of the storage, held e.g. by a process still
+	// exiting, retrying while it's locked. OpenContext and OpenFileContext
+	// also stop waiting when their context is done.
+	//
+	// The default value is 0, which fails at once with an error matching
+	// storage.ErrLocked.
+	LockTimeout time.Duration
+
+	// Logger defines the logger receiving the internal events of the DB.
+	// See Logger for the events format.
+	//
+	// The default value is nil, which means events are written as text
+	// lines to the storage log, i.e. the LOG file of a file-system backed
+	// DB.
+	Logger Logger
+
+	// LogLevel defines the minimum level of the logged events, whether
+	// they are sent to Logger or to the storage log.
+	//
+	// The default value is LogDebug, which means all events are logged.
+	LogLevel LogLevel
+
+	// MaxFrozenWriteBuffer defines maximum number of frozen 'memdb' that
+	// may be queued for flush. Writes are paused once the effective
+	// 'memdb' is full and the queue is full. A higher value absorbs
+	// write bursts when flush momentarily falls behind, at the cost of
+	// memory and journal replay time on recovery.
+	//
+	// The default value is 1.
+	MaxFrozenWriteBuffer int
+
+	// MaxKeySize is the maximum size of the keys written. Writing a larger
+	// key fails with errors.ErrTooLarge, leaving the DB unchanged, so that
+	// pathological keys don't reach the journal and tables.
+	//
+	// The default value is 0, which means no limit.
+	MaxKeySize int
+
+	// MaxSnapshotLifetime defines how long a snapshot may be held. Older
+	// snapshots are released by the DB, so that a forgotten snapshot
+	// doesn't keep the overwritten and deleted entries from being
+	// compacted away, and their use fails with an error matching
+	// errors.ErrSnapshotExpired. The iterators already obtained from them
+	// are unaffected.
+	//
+	// The default value is 0, which means no limit.
+	MaxSnapshotLifetime time.Duration
+
+	// MaxValueSize is the maximum size of the values written, as
+	// MaxKeySize.
+	//
+	// The default value is 0, which means no limit.
+	MaxValueSize int
+
 	// NoSync allows completely disable fsync.
 	//
 	// The default is false.
@@ -381,23 +797,134 @@ type Options struct {
 	// The default value is 200 on MacOS and 500 on other.
 	OpenFilesCacheCapacity int
 
+	// PinIndexAndFilterBlocks allows keeping the index and filter blocks of
+	// each open 'sorted table' in memory for as long as the table is open,
+	// instead of in the block cache where they compete with data blocks.
+	//
+	// The default value is false.
+	PinIndexAndFilterBlocks bool
+
+	// PinIndexAndFilterBlocksBudget limits the total size in bytes of
+	// pinned index and filter blocks. Tables opened once the budget is
+	// used up cache their index and filter blocks as usual.
+	// Use -1 for unlimited.
+	//
+	// The default value is 16MiB.
+	PinIndexAndFilterBlocksBudget int
+
 	// If true then opens DB in read-only mode.
 	//
 	// The default value is false.
 	ReadOnly bool
 
+	// RecycleJournalFiles defines the number of obsolete journal files to
+	// keep for reuse. A new journal then overwrites a kept file in place
+	// rather than creating and growing a new one, which avoids filesystem
+	// metadata updates on journal writes. Journals are then written in a
+	// format that tells records of the current use of a file apart from
+	// those left over by a previous one. The storage must implement
+	// storage.Recycler, otherwise journals are never recycled.
+	//
+	// The default value is 0, which means journal files are not recycled.
+	RecycleJournalFiles int
+
+	// Scheduler limits concurrency of background 'memdb' flushes and table
+	// compactions. A single scheduler can be shared over multiple DB
+	// instances to bound their combined background work, see
+	// util.NewScheduler.
+	//
+	// Each DB runs at most one flush and CompactionConcurrency table
+	// compactions at a time regardless of the scheduler.
+	//
+	// The default value is nil, which means no limit.
+	Scheduler *util.Scheduler
+
+	// SlowOperationThreshold defines the duration above which a Get, a
+	// Write, a 'memdb' flush or a table compaction is logged as a warning
+	// event, along with the tables and levels it touched. Gets include
+	// Has and reads through snapshots and transactions.
+	//
+	// The default value is zero, which means slow operations aren't
+	// logged.
+	SlowOperationThreshold time.Duration
+
 	// Strict defines the DB strict level.
 	Strict Strict
 
+	// TablePropertiesCollectors defines constructors of collectors of
+	// user-defined table properties. Each table written gets its own
+	// collectors, whose properties are stored in the table's properties
+	// block, along with the built-in properties.
+	//
+	// The default value is nil.
+	TablePropertiesCollectors []func() TablePropertiesCollector
+
+	// Tracer defines the tracer starting spans for DB operations, flushes
+	// and compactions. See Tracer for the spans.
+	//
+	// The default value is nil, which means no tracing.
+	Tracer Tracer
+
+	// TrackSnapshots defines whether the stack of the caller is recorded
+	// for each snapshot, and shown by the leveldb.snapshots property and in
+	// the log of the expired snapshots, to find who holds them. It's meant
+	// for debugging, as it slows down the snapshots.
+	//
+	// The default value is false.
+	TrackSnapshots bool
+
+	// WALRetentionSize defines the size of the recent write batches kept in
+	// memory, so that DB.NewWALIterator can return the batches written
+	// since a given sequence number, e.g. to ship them to replicas. The
+	// oldest batches are dropped first; the history starts empty when the
+	// DB is opened.
+	//
+	// The default value is 0, which means no batch is kept, and
+	// DB.NewWALIterator fails.
+	WALRetentionSize int
+
 	// WriteBuffer defines maximum size of a 'memdb' before flushed to
 	// 'sorted table'. 'memdb' is an in-memory DB backed by an on-disk
 	// unsorted journal.
 	//
-	// LevelDB may held up to two 'memdb' at the same time.
+	// LevelDB may held up to MaxFrozenWriteBuffer+1 'memdb' at the same
+	// time.
 	//
 	// The default value is 4MiB.
 	WriteBuffer int
 
+	// WriteBufferShards defines number of shards a 'memdb' is split into,
+	// by user key. Each shard is an independent skiplist, so large writes,
+	// e.g. of many merged batches, are inserted by a goroutine per shard.
+	// Setting this higher than one helps write throughput with many
+	// concurrent writers, but makes iterating the 'memdb' slower.
+	//
+	// The default value is 1.
+	WriteBufferShards int
+
+	// Memtable defines the 'memdb' implementation to use. The
+	// HashSkiplistMemtable keeps a hash index of user keys alongside the
+	// skiplist, which makes lookups of keys recently written cheaper, at
+	// the cost of slower writes. WriteBufferShards is ignored by
+	// HashSkiplistMemtable.
+	//
+	// The default value (DefaultMemtable) uses SkiplistMemtable.
+	Memtable Memtable
+
+	// WriteBufferFilterRatio defines size of 'memdb' bloom filter over user
+	// keys, as a fraction of WriteBuffer. With the filter, lookups of keys
+	// not written to a 'memdb' mostly skip searching it. A ratio of 0.0125
+	// gives about 10 bits per key when entries average 100 bytes.
+	//
+	// The default value is 0, which disables the filter.
+	WriteBufferFilterRatio float64
+
+	// WriteChunkSize defines the size of the commits DB.WriteLarge splits a
+	// large batch into. It's capped by WriteBuffer.
+	//
+	// The default value is 1MiB.
+	WriteChunkSize int
+
 	// WriteL0StopTrigger defines number of 'sorted table' at level-0 that will
 	// pause write.
 	//
@@ -410,11 +937,66 @@ type Options struct {
 	// The default value is 8.
 	WriteL0SlowdownTrigger int
 
+	// WriteMergeMaxSize limits the size of the batch a write merges
+	// concurrent writes into. A small write merges at most an eighth of it,
+	// so that its latency isn't hurt too much.
+	//
+	// The default value is 1MiB.
+	WriteMergeMaxSize int
+
+	// WriteMergeWait defines how long a write which holds the write lock
+	// waits for concurrent writes to merge into it. Waiting adds latency to
+	// every write, but with many concurrent sync writes it trades that for
+	// fewer fsyncs.
+	//
+	// The default value is 0, which means no wait.
+	WriteMergeWait time.Duration
+
+	// WriteMergeNoMixedSync allows disabling merging of sync and non-sync
+	// writes. Merging them makes the non-sync writes wait for fsync of the
+	// journal, which hurts their latency.
+	//
+	// The default value is false.
+	WriteMergeNoMixedSync bool
+
+	// WritePendingCompactionBytesPauseTrigger defines the estimated number
+	// of bytes the compactions have to rewrite, to bring every level within
+	// its size limit, that will pause write. The automatic table
+	// compactions run regardless of CompactionPolicy from it.
+	//
+	// The default value is 0, which means no limit.
+	WritePendingCompactionBytesPauseTrigger int64
+
+	// WritePendingCompactionBytesSlowdownTrigger defines the estimated
+	// number of bytes the compactions have to rewrite that will trigger
+	// write slowdown, see WritePendingCompactionBytesPauseTrigger.
+	//
+	// The default value is 0, which means no limit.
+	WritePendingCompactionBytesSlowdownTrigger int64
+
+	// WriteStallHandler defines a function that will be called each time
+	// the DB enters or leaves write delay and write pause conditions.
+	// The handler is called synchronously by the writer while holding the
+	// write lock, so it must not block and must not write to the DB.
+	//
+	// The default value is nil.
+	WriteStallHandler func(info WriteStallInfo)
+
 	// FilterBaseLg is the log size for filter block to create a bloom filter.
 	//
 	// The default value is 11(as well as 2KB)
 	FilterBaseLg int
 
+	// FullTableFilter allows building a single filter over all keys of a
+	// 'sorted table', instead of one filter per FilterBaseLg of data. A
+	// lookup can then skip the table with a single filter probe, without
+	// reading its index. Tables with full filter are read by older versions
+	// as having no filter. Ignored if IndexPartitionSize is set, since each
+	// index partition then has its own filter.
+	//
+	// The default value is false.
+	FullTableFilter bool
+
 	// MaxManifestFileSize is the maximum size limit of the MANIFEST-****** file.
 	// When the MANIFEST-****** file grows beyond this size, LevelDB will create
 	// a new MANIFEST file.
@@ -423,6 +1005,13 @@ type Options struct {
 	MaxManifestFileSize int64
 }
 
+func (o *Options) GetAdminLog() bool {
+	if o == nil {
+		return false
+	}
+	return o.AdminLog
+}
+
 func (o *Options) GetAltFilters() []filter.Filter {
 	if o == nil {
 		return nil
@@ -430,6 +1019,13 @@ func (o *Options) GetAltFilters() []filter.Filter {
 	return o.AltFilters
 }
 
+func (o *Options) GetAmplificationStats() bool {
+	if o == nil {
+		return false
+	}
+	return o.AmplificationStats
+}
+
 func (o *Options) GetBlockCacher() Cacher {
 	if o == nil || o.BlockCacher == nil {
 		return DefaultBlockCacher
@@ -453,6 +1049,20 @@ func (o *Options) GetBlockCacheEvictRemoved() bool {
 	return o.BlockCacheEvictRemoved
 }
 
+func (o *Options) GetCompressedBlockCacheCapacity() int {
+	if o == nil || o.CompressedBlockCacheCapacity < 0 {
+		return 0
+	}
+	return o.CompressedBlockCacheCapacity
+}
+
+func (o *Options) GetBlockChecksum() Checksum {
+	if o == nil || o.BlockChecksum <= DefaultChecksum || o.BlockChecksum >= nChecksum {
+		return CRC32CChecksum
+	}
+	return o.BlockChecksum
+}
+
 func (o *Options) GetBlockRestartInterval() int {
 	if o == nil || o.BlockRestartInterval <= 0 {
 		return DefaultBlockRestartInterval
@@ -460,6 +1070,13 @@ func (o *Options) GetBlockRestartInterval() int {
 	return o.BlockRestartInterval
 }
 
+func (o *Options) GetLevelBlockRestartInterval(level int) int {
+	if o != nil && level < len(o.BlockRestartIntervalPerLevel) && o.BlockRestartIntervalPerLevel[level] > 0 {
+		return o.BlockRestartIntervalPerLevel[level]
+	}
+	return o.GetBlockRestartInterval()
+}
+
 func (o *Options) GetBlockSize() int {
 	if o == nil || o.BlockSize <= 0 {
 		return DefaultBlockSize
@@ -467,6 +1084,41 @@ func (o *Options) GetBlockSize() int {
 	return o.BlockSize
 }
 
+func (o *Options) GetLevelBlockSize(level int) int {
+	if o != nil && level < len(o.BlockSizePerLevel) && o.BlockSizePerLevel[level] > 0 {
+		return o.BlockSizePerLevel[level]
+	}
+	return o.GetBlockSize()
+}
+
+func (o *Options) GetCloseFlushMemtable() bool {
+	if o == nil {
+		return false
+	}
+	return o.CloseFlushMemtable
+}
+
+func (o *Options) GetCloseSyncJournal() bool {
+	if o == nil {
+		return false
+	}
+	return o.CloseSyncJournal
+}
+
+func (o *Options) GetCloseWaitCompaction() bool {
+	if o == nil {
+		return false
+	}
+	return o.CloseWaitCompaction
+}
+
+func (o *Options) GetCompactionConcurrency() int {
+	if o == nil || o.CompactionConcurrency <= 0 {
+		return 1
+	}
+	return o.CompactionConcurrency
+}
+
 func (o *Options) GetCompactionExpandLimit(level int) int {
 	factor := DefaultCompactionExpandLimitFactor
 	if o != nil && o.CompactionExpandLimitFactor > 0 {
@@ -490,6 +1142,36 @@ func (o *Options) GetCompactionL0Trigger() int {
 	return o.CompactionL0Trigger
 }
 
+func (o *Options) GetCompactionOnlyBlockChecksum() bool {
+	if o == nil {
+		return false
+	}
+	return o.CompactionOnlyBlockChecksum
+}
+
+func (o *Options) GetCompactionPolicy() func(now time.Time) bool {
+	if o == nil {
+		return nil
+	}
+	return o.CompactionPolicy
+}
+
+func (o *Options) GetCompactionPolicyL0Trigger() int {
+	if o == nil || o.CompactionPolicyL0Trigger == 0 {
+		return o.GetWriteL0SlowdownTrigger()
+	}
+	return o.CompactionPolicyL0Trigger
+}
+
+func (o *Options) GetCompactionReadAheadSize() int {
+	if o == nil || o.CompactionReadAheadSize == 0 {
+		return DefaultCompactionReadAheadSize
+	} else if o.CompactionReadAheadSize < 0 {
+		return 0
+	}
+	return o.CompactionReadAheadSize
+}
+
 func (o *Options) GetCompactionSourceLimit(level int) int {
 	factor := DefaultCompactionSourceLimitFactor
 	if o != nil && o.CompactionSourceLimitFactor > 0 {
@@ -603,6 +1285,13 @@ func (o *Options) GetErrorIfMissing() bool {
 	return o.ErrorIfMissing
 }
 
+func (o *Options) GetEventListener() EventListener {
+	if o == nil || o.EventListener == nil {
+		return NoopEventListener{}
+	}
+	return o.EventListener
+}
+
 func (o *Options) GetFilter() filter.Filter {
 	if o == nil {
 		return nil
@@ -610,6 +1299,13 @@ func (o *Options) GetFilter() filter.Filter {
 	return o.Filter
 }
 
+func (o *Options) GetIndexPartitionSize() int {
+	if o == nil || o.IndexPartitionSize < 0 {
+		return 0
+	}
+	return o.IndexPartitionSize
+}
+
 func (o *Options) GetIteratorSamplingRate() int {
 	if o == nil || o.IteratorSamplingRate == 0 {
 		return DefaultIteratorSamplingRate
@@ -619,6 +1315,90 @@ func (o *Options) GetIteratorSamplingRate() int {
 	return o.IteratorSamplingRate
 }
 
+func (o *Options) GetJournalArchive() storage.Storage {
+	if o == nil {
+		return nil
+	}
+	return o.JournalArchive
+}
+
+func (o *Options) GetJournalArchiveMaxFiles() int {
+	if o == nil || o.JournalArchiveMaxFiles < 0 {
+		return 0
+	}
+	return o.JournalArchiveMaxFiles
+}
+
+func (o *Options) GetJournalArchiveMaxSize() int64 {
+	if o == nil || o.JournalArchiveMaxSize < 0 {
+		return 0
+	}
+	return o.JournalArchiveMaxSize
+}
+
+func (o *Options) GetJournalCompression() Compression {
+	if o == nil || o.JournalCompression <= DefaultCompression || o.JournalCompression >= nCompression {
+		return NoCompression
+	}
+	return o.JournalCompression
+}
+
+func (o *Options) GetLatencyHistograms() bool {
+	if o == nil {
+		return false
+	}
+	return o.LatencyHistograms
+}
+
+func (o *Options) GetLockTimeout() time.Duration {
+	if o == nil || o.LockTimeout <= 0 {
+		return 0
+	}
+	return o.LockTimeout
+}
+
+func (o *Options) GetLogger() Logger {
+	if o == nil {
+		return nil
+	}
+	return o.Logger
+}
+
+func (o *Options) GetLogLevel() LogLevel {
+	if o == nil {
+		return LogDebug
+	}
+	return o.LogLevel
+}
+
+func (o *Options) GetMaxFrozenWriteBuffer() int {
+	if o == nil || o.MaxFrozenWriteBuffer <= 0 {
+		return DefaultMaxFrozenWriteBuffer
+	}
+	return o.MaxFrozenWriteBuffer
+}
+
+func (o *Options) GetMaxKeySize() int {
+	if o == nil || o.MaxKeySize <= 0 {
+		return 0
+	}
+	return o.MaxKeySize
+}
+
+func (o *Options) GetMaxSnapshotLifetime() time.Duration {
+	if o == nil || o.MaxSnapshotLifetime < 0 {
+		return 0
+	}
+	return o.MaxSnapshotLifetime
+}
+
+func (o *Options) GetMaxValueSize() int {
+	if o == nil || o.MaxValueSize <= 0 {
+		return 0
+	}
+	return o.MaxValueSize
+}
+
 func (o *Options) GetNoSync() bool {
 	if o == nil {
 		return false
@@ -649,6 +1429,20 @@ func (o *Options) GetOpenFilesCacheCapacity() int {
 	return o.OpenFilesCacheCapacity
 }
 
+func (o *Options) GetPinIndexAndFilterBlocks() bool {
+	if o == nil {
+		return false
+	}
+	return o.PinIndexAndFilterBlocks
+}
+
+func (o *Options) GetPinIndexAndFilterBlocksBudget() int {
+	if o == nil || o.PinIndexAndFilterBlocksBudget == 0 {
+		return DefaultPinIndexAndFilterBlocksBudget
+	}
+	return o.PinIndexAndFilterBlocksBudget
+}
+
 func (o *Options) GetReadOnly() bool {
 	if o == nil {
 		return false
@@ -656,6 +1450,27 @@ func (o *Options) GetReadOnly() bool {
 	return o.ReadOnly
 }
 
+func (o *Options) GetRecycleJournalFiles() int {
+	if o == nil || o.RecycleJournalFiles < 0 {
+		return 0
+	}
+	return o.RecycleJournalFiles
+}
+
+func (o *Options) GetScheduler() *util.Scheduler {
+	if o == nil {
+		return nil
+	}
+	return o.Scheduler
+}
+
+func (o *Options) GetSlowOperationThreshold() time.Duration {
+	if o == nil || o.SlowOperationThreshold < 0 {
+		return 0
+	}
+	return o.SlowOperationThreshold
+}
+
 func (o *Options) GetStrict(strict Strict) bool {
 	if o == nil || o.Strict == 0 {
 		return DefaultStrict&strict != 0
@@ -663,6 +1478,34 @@ func (o *Options) GetStrict(strict Strict) bool {
 	return o.Strict&strict != 0
 }
 
+func (o *Options) GetTablePropertiesCollectors() []func() TablePropertiesCollector {
+	if o == nil {
+		return nil
+	}
+	return o.TablePropertiesCollectors
+}
+
+func (o *Options) GetTracer() Tracer {
+	if o == nil {
+		return nil
+	}
+	return o.Tracer
+}
+
+func (o *Options) GetTrackSnapshots() bool {
+	if o == nil {
+		return false
+	}
+	return o.TrackSnapshots
+}
+
+func (o *Options) GetWALRetentionSize() int {
+	if o == nil || o.WALRetentionSize < 0 {
+		return 0
+	}
+	return o.WALRetentionSize
+}
+
 func (o *Options) GetWriteBuffer() int {
 	if o == nil || o.WriteBuffer <= 0 {
 		return DefaultWriteBuffer
@@ -670,6 +1513,38 @@ func (o *Options) GetWriteBuffer() int {
 	return o.WriteBuffer
 }
 
+func (o *Options) GetWriteBufferShards() int {
+	if o == nil || o.WriteBufferShards <= 0 {
+		return 1
+	}
+	return o.WriteBufferShards
+}
+
+func (o *Options) GetWriteBufferFilterRatio() float64 {
+	if o == nil || o.WriteBufferFilterRatio <= 0 {
+		return 0
+	}
+	return o.WriteBufferFilterRatio
+}
+
+func (o *Options) GetWriteChunkSize() int {
+	n := DefaultWriteChunkSize
+	if o != nil && o.WriteChunkSize > 0 {
+		n = o.WriteChunkSize
+	}
+	if wb := o.GetWriteBuffer(); n > wb {
+		return wb
+	}
+	return n
+}
+
+func (o *Options) GetMemtable() Memtable {
+	if o == nil || o.Memtable <= DefaultMemtable || o.Memtable >= nMemtable {
+		return DefaultMemtableType
+	}
+	return o.Memtable
+}
+
 func (o *Options) GetWriteL0PauseTrigger() int {
 	if o == nil || o.WriteL0PauseTrigger == 0 {
 		return DefaultWriteL0PauseTrigger
@@ -684,6 +1559,48 @@ func (o *Options) GetWriteL0SlowdownTrigger() int {
 	return o.WriteL0SlowdownTrigger
 }
 
+func (o *Options) GetWriteMergeMaxSize() int {
+	if o == nil || o.WriteMergeMaxSize <= 0 {
+		return DefaultWriteMergeMaxSize
+	}
+	return o.WriteMergeMaxSize
+}
+
+func (o *Options) GetWriteMergeWait() time.Duration {
+	if o == nil || o.WriteMergeWait < 0 {
+		return 0
+	}
+	return o.WriteMergeWait
+}
+
+func (o *Options) GetWriteMergeNoMixedSync() bool {
+	if o == nil {
+		return false
+	}
+	return o.WriteMergeNoMixedSync
+}
+
+func (o *Options) GetWritePendingCompactionBytesPauseTrigger() int64 {
+	if o == nil || o.WritePendingCompactionBytesPauseTrigger < 0 {
+		return 0
+	}
+	return o.WritePendingCompactionBytesPauseTrigger
+}
+
+func (o *Options) GetWritePendingCompactionBytesSlowdownTrigger() int64 {
+	if o == nil || o.WritePendingCompactionBytesSlowdownTrigger < 0 {
+		return 0
+	}
+	return o.WritePendingCompactionBytesSlowdownTrigger
+}
+
+func (o *Options) GetWriteStallHandler() func(info WriteStallInfo) {
+	if o == nil {
+		return nil
+	}
+	return o.WriteStallHandler
+}
+
 func (o *Options) GetFilterBaseLg() int {
 	if o == nil || o.FilterBaseLg <= 0 {
 		return DefaultFilterBaseLg
@@ -691,6 +1608,13 @@ func (o *Options) GetFilterBaseLg() int {
 	return o.FilterBaseLg
 }
 
+func (o *Options) GetFullTableFilter() bool {
+	if o == nil {
+		return false
+	}
+	return o.FullTableFilter
+}
+
 // ReadOptions holds the optional parameters for 'read operation'. The
 // 'read operation' includes Get, Find and NewIterator.
 type ReadOptions struct {
@@ -704,6 +1628,37 @@ type ReadOptions struct {
 	// Strict will be OR'ed with global DB 'strict level' unless StrictOverride
 	// is present. Currently only StrictReader that has effect here.
 	Strict Strict
+
+	// SkipBlockChecksum allows skipping checksum verification of the 'sorted
+	// table' data blocks read by this 'read operation', even if
+	// StrictBlockChecksum is set. This speeds up bulk reads of data which is
+	// trusted, e.g. recently verified.
+	//
+	// The default value is false.
+	SkipBlockChecksum bool
+
+	// ReadAheadSize defines the read-ahead size of iterators for this 'read
+	// operation'. If greater than zero, 'sorted table' data blocks are read
+	// in chunks of at least this size, which speeds up sequential scans on
+	// high latency storage. Blocks found in the block cache are not read.
+	//
+	// The default value is 0.
+	ReadAheadSize int
+
+	// PrefetchBlocks defines the number of 'sorted table' data blocks an
+	// iterator reads in the background ahead of its position, once it is
+	// detected to be scanning forward sequentially, so that Next rarely
+	// waits for I/O. Zero disables prefetching.
+	//
+	// The default value is 0.
+	PrefetchBlocks int
+
+	// PrefetchTrigger defines the number of consecutive data blocks an
+	// iterator must read before it is considered to be scanning
+	// sequentially, and starts prefetching.
+	//
+	// The default value is 2.
+	PrefetchTrigger int
 }
 
 func (ro *ReadOptions) GetDontFillCache() bool {
@@ -713,6 +1668,34 @@ func (ro *ReadOptions) GetDontFillCache() bool {
 	return ro.DontFillCache
 }
 
+func (ro *ReadOptions) GetSkipBlockChecksum() bool {
+	if ro == nil {
+		return false
+	}
+	return ro.SkipBlockChecksum
+}
+
+func (ro *ReadOptions) GetReadAheadSize() int {
+	if ro == nil || ro.ReadAheadSize < 0 {
+		return 0
+	}
+	return ro.ReadAheadSize
+}
+
+func (ro *ReadOptions) GetPrefetchBlocks() int {
+	if ro == nil || ro.PrefetchBlocks < 0 {
+		return 0
+	}
+	return ro.PrefetchBlocks
+}
+
+func (ro *ReadOptions) GetPrefetchTrigger() int {
+	if ro == nil || ro.PrefetchTrigger <= 0 {
+		return DefaultPrefetchTrigger
+	}
+	return ro.PrefetchTrigger
+}
+
 func (ro *ReadOptions) GetStrict(strict Strict) bool {
 	if ro == nil {
 		return false
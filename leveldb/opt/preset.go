@@ -0,0 +1,170 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package opt
+
+import (
+	"github.com/syndtr/goleveldb/leveldb/comparer"
+	"github.com/syndtr/goleveldb/leveldb/filter"
+)
+
+// Bits per key of the bloom filter of the presets.
+const presetFilterBits = 10
+
+// ForSSD returns options tuned for a DB on solid-state storage, with
+// plenty of memory: larger memdb and caches, and bloom filters, for both
+// write and read throughput.
+func ForSSD() *Options {
+	return &Options{
+		BlockCacheCapacity:     64 * MiB,
+		CompactionTableSize:    8 * MiB,
+		CompactionTotalSize:    64 * MiB,
+		Filter:                 filter.NewBloomFilter(presetFilterBits),
+		OpenFilesCacheCapacity: 1000,
+		WriteBuffer:            32 * MiB,
+		MaxFrozenWriteBuffer:   2,
+	}
+}
+
+// ForHDD returns options tuned for a DB on rotational disks, where seeks
+// are expensive: larger blocks and tables, long compaction read ahead, bloom
+// filters to avoid the reads of absent keys, and no compaction triggered by
+// seeks.
+func ForHDD() *Options {
+	return &Options{
+		BlockCacheCapacity:      32 * MiB,
+		BlockSize:               64 * KiB,
+		CompactionReadAheadSize: 8 * MiB,
+		CompactionTableSize:     32 * MiB,
+		CompactionTotalSize:     256 * MiB,
+		DisableSeeksCompaction:  true,
+		Filter:                  filter.NewBloomFilter(presetFilterBits),
+		WriteBuffer:             64 * MiB,
+	}
+}
+
+// LowMemory returns options for a DB on a memory constrained host, e.g. an
+// embedded device: small memdb, caches and tables, at the cost of
+// throughput.
+func LowMemory() *Options {
+	return &Options{
+		BlockCacheCapacity:      1 * MiB,
+		CompactionReadAheadSize: 256 * KiB,
+		CompactionTableSize:     1 * MiB,
+		CompactionTotalSize:     5 * MiB,
+		OpenFilesCacheCapacity:  64,
+		WriteBuffer:             1 * MiB,
+	}
+}
+
+// BulkLoad returns options for loading a large amount of data, e.g. the
+// initial import of a DB: a large memdb, and level-0 triggers high enough
+// that writes are neither slowed down nor paused while compactions catch
+// up. Reads are slower meanwhile; a CompactRange of the whole DB after the
+// load restores them. The DB should be reopened with the options of its
+// regular use afterward.
+func BulkLoad() *Options {
+	return &Options{
+		CompactionL0Trigger:    16,
+		CompactionTableSize:    8 * MiB,
+		DisableSeeksCompaction: true,
+		Filter:                 filter.NewBloomFilter(presetFilterBits),
+		MaxFrozenWriteBuffer:   4,
+		WriteBuffer:            64 * MiB,
+		WriteL0PauseTrigger:    128,
+		WriteL0SlowdownTrigger: 64,
+	}
+}
+
+// Option sets optional parameters, for Open and OpenFile variants taking
+// functional options, see New.
+type Option func(o *Options)
+
+// New returns the options set by the given functional options, applied in
+// order, starting from the defaults.
+func New(opts ...Option) *Options {
+	return (*Options)(nil).With(opts...)
+}
+
+// With returns a copy of the options, which may be nil, with the given
+// functional options applied in order.
+func (o *Options) With(opts ...Option) *Options {
+	no := &Options{}
+	if o != nil {
+		*no = *o
+	}
+	for _, fn := range opts {
+		fn(no)
+	}
+	return no
+}
+
+// WithPreset sets all the options to those of the preset, e.g. ForSSD. It
+// should come first, as it resets the options set before.
+func WithPreset(preset *Options) Option {
+	return func(o *Options) {
+		if preset != nil {
+			*o = *preset
+		} else {
+			*o = Options{}
+		}
+	}
+}
+
+// WithBlockCacheCapacity sets BlockCacheCapacity.
+func WithBlockCacheCapacity(n int) Option {
+	return func(o *Options) { o.BlockCacheCapacity = n }
+}
+
+// WithComparer sets Comparer.
+func WithComparer(cmp comparer.Comparer) Option {
+	return func(o *Options) { o.Comparer = cmp }
+}
+
+// WithCompression sets Compression.
+func WithCompression(c Compression) Option {
+	return func(o *Options) { o.Compression = c }
+}
+
+// WithErrorIfExist sets ErrorIfExist.
+func WithErrorIfExist() Option {
+	return func(o *Options) { o.ErrorIfExist = true }
+}
+
+// WithErrorIfMissing sets ErrorIfMissing.
+func WithErrorIfMissing() Option {
+	return func(o *Options) { o.ErrorIfMissing = true }
+}
+
+// WithFilter sets Filter, e.g. filter.NewBloomFilter(10).
+func WithFilter(f filter.Filter) Option {
+	return func(o *Options) { o.Filter = f }
+}
+
+// WithLogger sets Logger.
+func WithLogger(l Logger) Option {
+	return func(o *Options) { o.Logger = l }
+}
+
+// WithNoSync sets NoSync.
+func WithNoSync() Option {
+	return func(o *Options) { o.NoSync = true }
+}
+
+// WithOpenFilesCacheCapacity sets OpenFilesCacheCapacity.
+func WithOpenFilesCacheCapacity(n int) Option {
+	return func(o *Options) { o.OpenFilesCacheCapacity = n }
+}
+
+// WithReadOnly sets ReadOnly.
+func WithReadOnly() Option {
+	return func(o *Options) { o.ReadOnly = true }
+}
+
+// WithWriteBuffer sets WriteBuffer.
+func WithWriteBuffer(n int) Option {
+	return func(o *Options) { o.WriteBuffer = n }
+}
@@ -0,0 +1,37 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package opt
+
+// Tracer starts spans covering DB operations and background work. It is a
+// small interface, so a tracing library such as OpenTelemetry can be plugged
+// in through a thin adapter without the DB depending on it.
+//
+// The following spans are started, with the given integer attributes:
+//
+//	leveldb.Get         found
+//	leveldb.GetMulti    keys, found
+//	leveldb.Write       batch.records, batch.bytes
+//	leveldb.Iterator    from creation until release
+//	leveldb.Flush       entries, bytes, level, tables, bytes.written
+//	leveldb.Compaction  level, trivial, tables.input, bytes.read,
+//	                    tables.output, bytes.written
+//
+// DB methods take no context, so spans have no parent unless the adapter
+// provides one. Start may be called concurrently.
+type Tracer interface {
+	// Start starts a span with the given name.
+	Start(name string) Span
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	// SetAttribute sets an integer attribute of the span.
+	SetAttribute(key string, value int64)
+
+	// End ends the span. The err is the error of the traced work, if any.
+	End(err error)
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package opt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// InvalidError is the error of Options.Validate, naming the fields of the
+// invalid options.
+type InvalidError struct {
+	// Fields are the names of the fields involved.
+	Fields []string
+	// Reason tells why their values are invalid.
+	Reason string
+}
+
+func (e *InvalidError) Error() string {
+	return "leveldb/opt: invalid " + strings.Join(e.Fields, " and ") + ": " + e.Reason
+}
+
+func invalid(reason string, fields ...string) error {
+	return &InvalidError{Fields: fields, Reason: reason}
+}
+
+// Validate checks the options for invalid values and nonsensical
+// combinations, e.g. a BlockSize larger than the WriteBuffer, which would
+// otherwise be replaced by defaults or ignored. It returns an
+// *InvalidError for the first problem found. The DB is only opened with
+// valid options. Nil options are valid.
+func (o *Options) Validate() error {
+	if o == nil {
+		return nil
+	}
+
+	// Values out of range.
+	for _, f := range []struct {
+		name  string
+		value int
+		min   int
+	}{
+		{"BlockCacheCapacity", o.BlockCacheCapacity, -1},
+		{"BlockRestartInterval", o.BlockRestartInterval, 0},
+		{"BlockSize", o.BlockSize, 0},
+		{"CompactionConcurrency", o.CompactionConcurrency, 0},
+		{"CompactionExpandLimitFactor", o.CompactionExpandLimitFactor, 0},
+		{"CompactionGPOverlapsFactor", o.CompactionGPOverlapsFactor, 0},
+		{"CompactionL0Trigger", o.CompactionL0Trigger, 0},
+		{"CompactionPolicyL0Trigger", o.CompactionPolicyL0Trigger, 0},
+		{"CompactionReadAheadSize", o.CompactionReadAheadSize, -1},
+		{"CompactionSourceLimitFactor", o.CompactionSourceLimitFactor, 0},
+		{"CompactionTableSize", o.CompactionTableSize, 0},
+		{"CompactionTotalSize", o.CompactionTotalSize, 0},
+		{"CompressedBlockCacheCapacity", o.CompressedBlockCacheCapacity, 0},
+		{"FilterBaseLg", o.FilterBaseLg, 0},
+		{"IndexPartitionSize", o.IndexPartitionSize, 0},
+		{"JournalArchiveMaxFiles", o.JournalArchiveMaxFiles, 0},
+		{"MaxFrozenWriteBuffer", o.MaxFrozenWriteBuffer, 0},
+		{"MaxKeySize", o.MaxKeySize, 0},
+		{"MaxValueSize", o.MaxValueSize, 0},
+		{"OpenFilesCacheCapacity", o.OpenFilesCacheCapacity, -1},
+		{"PinIndexAndFilterBlocksBudget", o.PinIndexAndFilterBlocksBudget, -1},
+		{"RecycleJournalFiles", o.RecycleJournalFiles, 0},
+		{"WALRetentionSize", o.WALRetentionSize, 0},
+		{"WriteBuffer", o.WriteBuffer, 0},
+		{"WriteBufferShards", o.WriteBufferShards, 0},
+		{"WriteChunkSize", o.WriteChunkSize, 0},
+		{"WriteL0PauseTrigger", o.WriteL0PauseTrigger, 0},
+		{"WriteL0SlowdownTrigger", o.WriteL0SlowdownTrigger, 0},
+		{"WriteMergeMaxSize", o.WriteMergeMaxSize, 0},
+	} {
+		if f.value < f.min {
+			return invalid(fmt.Sprintf("%d is less than %d", f.value, f.min), f.name)
+		}
+	}
+	if o.JournalArchiveMaxSize < 0 {
+		return invalid("negative size", "JournalArchiveMaxSize")
+	}
+	if o.MaxManifestFileSize < 0 {
+		return invalid("negative size", "MaxManifestFileSize")
+	}
+	if o.WritePendingCompactionBytesPauseTrigger < 0 {
+		return invalid("negative size", "WritePendingCompactionBytesPauseTrigger")
+	}
+	if o.WritePendingCompactionBytesSlowdownTrigger < 0 {
+		return invalid("negative size", "WritePendingCompactionBytesSlowdownTrigger")
+	}
+	if o.LockTimeout < 0 {
+		return invalid("negative duration", "LockTimeout")
+	}
+	if o.MaxSnapshotLifetime < 0 {
+		return invalid("negative duration", "MaxSnapshotLifetime")
+	}
+	if o.CompactionTableSizeMultiplier < 0 {
+		return invalid("negative multiplier", "CompactionTableSizeMultiplier")
+	}
+	if o.CompactionTotalSizeMultiplier < 0 {
+		return invalid("negative multiplier", "CompactionTotalSizeMultiplier")
+	}
+	if r := o.WriteBufferFilterRatio; r < 0 || r >= 1 {
+		return invalid(fmt.Sprintf("ratio %v not in [0, 1)", r), "WriteBufferFilterRatio")
+	}
+	if o.FilterBaseLg > 30 {
+		return invalid(fmt.Sprintf("%d is greater than 30", o.FilterBaseLg), "FilterBaseLg")
+	}
+	if o.Compression >= nCompression {
+		return invalid(fmt.Sprintf("unknown compression %d", o.Compression), "Compression")
+	}
+	if o.JournalCompression >= nCompression {
+		return invalid(fmt.Sprintf("unknown compression %d", o.JournalCompression), "JournalCompression")
+	}
+	if o.BlockChecksum >= nChecksum {
+		return invalid(fmt.Sprintf("unknown checksum %d", o.BlockChecksum), "BlockChecksum")
+	}
+	if o.Memtable >= nMemtable {
+		return invalid(fmt.Sprintf("unknown memtable %d", o.Memtable), "Memtable")
+	}
+	for level, v := range o.BlockSizePerLevel {
+		if v < 0 {
+			return invalid(fmt.Sprintf("negative size at level %d", level), "BlockSizePerLevel")
+		}
+	}
+	for level, v := range o.BlockRestartIntervalPerLevel {
+		if v < 0 {
+			return invalid(fmt.Sprintf("negative interval at level %d", level), "BlockRestartIntervalPerLevel")
+		}
+	}
+	for level, v := range o.CompactionTableSizeMultiplierPerLevel {
+		if v < 0 {
+			return invalid(fmt.Sprintf("negative multiplier at level %d", level), "CompactionTableSizeMultiplierPerLevel")
+		}
+	}
+	for level, v := range o.CompactionTotalSizeMultiplierPerLevel {
+		if v < 0 {
+			return invalid(fmt.Sprintf("negative multiplier at level %d", level), "CompactionTotalSizeMultiplierPerLevel")
+		}
+	}
+
+	// Nonsensical combinations.
+	if o.BlockSize > o.GetWriteBuffer() {
+		return invalid(fmt.Sprintf("block size %d larger than the write buffer %d", o.BlockSize, o.GetWriteBuffer()), "BlockSize", "WriteBuffer")
+	}
+	for level, v := range o.BlockSizePerLevel {
+		if level == 0 && v > o.GetWriteBuffer() {
+			return invalid(fmt.Sprintf("level-0 block size %d larger than the write buffer %d", v, o.GetWriteBuffer()), "BlockSizePerLevel", "WriteBuffer")
+		}
+	}
+	if o.GetWriteL0SlowdownTrigger() > o.GetWriteL0PauseTrigger() {
+		return invalid("writes would pause before slowing down", "WriteL0SlowdownTrigger", "WriteL0PauseTrigger")
+	}
+	if pause := o.GetWritePendingCompactionBytesPauseTrigger(); pause > 0 && o.GetWritePendingCompactionBytesSlowdownTrigger() > pause {
+		return invalid("writes would pause before slowing down", "WritePendingCompactionBytesSlowdownTrigger", "WritePendingCompactionBytesPauseTrigger")
+	}
+	if o.GetCompactionL0Trigger() > o.GetWriteL0PauseTrigger() {
+		return invalid("writes would pause before level-0 compaction triggers", "CompactionL0Trigger", "WriteL0PauseTrigger")
+	}
+	if o.GetCompactionPolicyL0Trigger() > o.GetWriteL0PauseTrigger() {
+		return invalid("writes would pause while compactions wait for the policy", "CompactionPolicyL0Trigger", "WriteL0PauseTrigger")
+	}
+	if o.ErrorIfExist && o.ErrorIfMissing {
+		return invalid("opening would always fail", "ErrorIfExist", "ErrorIfMissing")
+	}
+	if o.ErrorIfExist && o.ReadOnly {
+		return invalid("a read-only DB must exist", "ErrorIfExist", "ReadOnly")
+	}
+	if o.CompressedBlockCacheCapacity > 0 {
+		switch {
+		case o.DisableBlockCache:
+			return invalid("no compressed block cache without block caching", "CompressedBlockCacheCapacity", "DisableBlockCache")
+		case isNoCacher(o.BlockCacher):
+			return invalid("no compressed block cache without a cache algorithm", "CompressedBlockCacheCapacity", "BlockCacher")
+		}
+	}
+	if o.PinIndexAndFilterBlocksBudget != 0 && !o.PinIndexAndFilterBlocks {
+		return invalid("budget set without pinning", "PinIndexAndFilterBlocksBudget", "PinIndexAndFilterBlocks")
+	}
+	if o.JournalArchive == nil {
+		if o.JournalArchiveMaxFiles != 0 {
+			return invalid("retention set without archive", "JournalArchiveMaxFiles", "JournalArchive")
+		}
+		if o.JournalArchiveMaxSize != 0 {
+			return invalid("retention set without archive", "JournalArchiveMaxSize", "JournalArchive")
+		}
+	} else if o.RecycleJournalFiles > 0 {
+		return invalid("archived journals aren't recycled", "RecycleJournalFiles", "JournalArchive")
+	}
+	if o.WriteBufferShards > 1 && o.Memtable == HashSkiplistMemtable {
+		return invalid("the hash skiplist memtable isn't sharded", "WriteBufferShards", "Memtable")
+	}
+	return nil
+}
+
+// Tells whether the cacher is NoCacher, or alike.
+func isNoCacher(c Cacher) bool {
+	f, ok := c.(*cacherFunc)
+	return ok && (f == nil || f.NewFunc == nil)
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package opt
+
+import (
+	"time"
+)
+
+// TimeWindow is a daily window of time, from Start to End, as offsets from
+// midnight in the local time of the clock. A window whose End is before its
+// Start spans midnight, e.g. from 22h to 6h.
+type TimeWindow struct {
+	Start, End time.Duration
+}
+
+// Contains returns whether the time of day of t is within the window, its
+// start included and its end excluded.
+func (w TimeWindow) Contains(t time.Time) bool {
+	h, m, s := t.Clock()
+	d := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second +
+		time.Duration(t.Nanosecond())
+	if w.End < w.Start {
+		return d >= w.Start || d < w.End
+	}
+	return d >= w.Start && d < w.End
+}
+
+// CompactionWindows returns a CompactionPolicy running the compactions
+// within any of the given daily windows, e.g. off-peak hours:
+//
+//	o.CompactionPolicy = opt.CompactionWindows(opt.TimeWindow{Start: 22 * time.Hour, End: 6 * time.Hour})
+func CompactionWindows(windows ...TimeWindow) func(now time.Time) bool {
+	return func(now time.Time) bool {
+		for _, w := range windows {
+			if w.Contains(now) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -23,6 +23,7 @@ func dupOptions(o *opt.Options) *opt.Options {
 }
 
 func (s *session) setOptions(o *opt.Options) {
+	s.uo = dupOptions(o)
 	no := dupOptions(o)
 	// Alternative filters.
 	if filters := o.GetAltFilters(); len(filters) > 0 {
@@ -31,6 +32,15 @@ func (s *session) setOptions(o *opt.Options) {
 			no.AltFilters[i] = &iFilter{filter}
 		}
 	}
+	// Table properties collectors.
+	if collectors := o.GetTablePropertiesCollectors(); len(collectors) > 0 {
+		no.TablePropertiesCollectors = make([]func() opt.TablePropertiesCollector, len(collectors))
+		for i, newCollector := range collectors {
+			no.TablePropertiesCollectors[i] = func() opt.TablePropertiesCollector {
+				return iTablePropertiesCollector{newCollector()}
+			}
+		}
+	}
 	// Comparer.
 	s.icmp = &iComparer{o.GetComparer()}
 	no.Comparer = s.icmp
@@ -39,6 +49,11 @@ func (s *session) setOptions(o *opt.Options) {
 		no.Filter = &iFilter{filter}
 	}
 
+	// Logger.
+	if s.logger = o.GetLogger(); s.logger == nil {
+		s.logger = storageLogger{s.stor}
+	}
+
 	s.o = &cachedOptions{Options: no}
 	s.o.cache()
 }
@@ -0,0 +1,383 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package remote
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	lerrors "github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// ClientOptions holds the optional parameters of a Client.
+type ClientOptions struct {
+	// Timeout bounds each call to the server. Zero means no timeout.
+	Timeout time.Duration
+
+	// PageSize is the number of entries fetched at once by iterators.
+	//
+	// The default is 256.
+	PageSize int
+}
+
+// Client is a KV backed by a remote Server. It's safe for concurrent use.
+type Client struct {
+	conn     grpc.ClientConnInterface
+	timeout  time.Duration
+	pageSize int
+}
+
+// NewClient returns a Client calling the server through the given
+// connection, typically a *grpc.ClientConn. The options may be nil.
+func NewClient(conn grpc.ClientConnInterface, o *ClientOptions) *Client {
+	c := &Client{conn: conn, pageSize: 256}
+	if o != nil {
+		c.timeout = o.Timeout
+		if o.PageSize > 0 {
+			c.pageSize = o.PageSize
+		}
+	}
+	return c
+}
+
+// Converts a gRPC status error back to a DB error.
+func fromStatus(err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	switch st.Code() {
+	case codes.OK:
+		return nil
+	case codes.NotFound:
+		return leveldb.ErrNotFound
+	case codes.DataLoss:
+		return lerrors.NewErrCorrupted(storage.FileDesc{}, errors.New(st.Message()))
+	}
+	if st.Message() == leveldb.ErrClosed.Error() {
+		return leveldb.ErrClosed
+	}
+	if st.Message() == leveldb.ErrReadOnly.Error() {
+		return leveldb.ErrReadOnly
+	}
+	return err
+}
+
+func (c *Client) invoke(method string, req, resp message) error {
+	ctx := context.Background()
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	err := c.conn.Invoke(ctx, "/"+serviceName+"/"+method, req, resp, grpc.ForceCodec(codec{}))
+	return fromStatus(err)
+}
+
+func dontFillCache(ro *opt.ReadOptions) bool {
+	return ro != nil && ro.DontFillCache
+}
+
+func (c *Client) get(snapshot uint64, key []byte, ro *opt.ReadOptions) ([]byte, error) {
+	resp := new(getResponse)
+	if err := c.invoke("Get", &getRequest{key, snapshot, dontFillCache(ro)}, resp); err != nil {
+		return nil, err
+	}
+	if resp.Value == nil {
+		resp.Value = []byte{}
+	}
+	return resp.Value, nil
+}
+
+func (c *Client) has(snapshot uint64, key []byte, ro *opt.ReadOptions) (bool, error) {
+	resp := new(hasResponse)
+	if err := c.invoke("Has", &getRequest{key, snapshot, dontFillCache(ro)}, resp); err != nil {
+		return false, err
+	}
+	return resp.Found, nil
+}
+
+// Get gets the value for the given key. It returns ErrNotFound if the DB
+// does not contain the key.
+func (c *Client) Get(key []byte, ro *opt.ReadOptions) ([]byte, error) {
+	return c.get(0, key, ro)
+}
+
+// Has returns true if the DB does contain the given key.
+func (c *Client) Has(key []byte, ro *opt.ReadOptions) (bool, error) {
+	return c.has(0, key, ro)
+}
+
+// Put sets the value for the given key.
+func (c *Client) Put(key, value []byte, wo *opt.WriteOptions) error {
+	return c.invoke("Put", &putRequest{key, value, wo.GetSync()}, new(empty))
+}
+
+// Delete deletes the value for the given key.
+func (c *Client) Delete(key []byte, wo *opt.WriteOptions) error {
+	return c.invoke("Delete", &deleteRequest{key, wo.GetSync()}, new(empty))
+}
+
+// Write applies the given batch to the DB atomically.
+func (c *Client) Write(batch *leveldb.Batch, wo *opt.WriteOptions) error {
+	return c.invoke("Write", &writeRequest{batch.Dump(), wo.GetSync()}, new(empty))
+}
+
+// NewIterator returns an iterator for a snapshot of the DB, as
+// DB.NewIterator. The snapshot is held by the server until the iterator is
+// released.
+func (c *Client) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	snap, err := c.GetSnapshot()
+	if err != nil {
+		return iterator.NewEmptyIterator(err)
+	}
+	iter := snap.NewIterator(slice, ro).(*remoteIter)
+	iter.snap = snap
+	return iter
+}
+
+// GetSnapshot returns a snapshot of the DB, held by the server until it's
+// released.
+func (c *Client) GetSnapshot() (*Snapshot, error) {
+	resp := new(snapshotResponse)
+	if err := c.invoke("Snapshot", new(empty), resp); err != nil {
+		return nil, err
+	}
+	return &Snapshot{c: c, id: resp.ID}, nil
+}
+
+// Snapshot is a snapshot of a remote DB.
+type Snapshot struct {
+	c  *Client
+	id uint64
+
+	mu       sync.Mutex
+	released bool
+}
+
+func (snap *Snapshot) ok() error {
+	snap.mu.Lock()
+	defer snap.mu.Unlock()
+	if snap.released {
+		return leveldb.ErrSnapshotReleased
+	}
+	return nil
+}
+
+// Get gets the value for the given key. It returns ErrNotFound if the DB
+// does not contain the key.
+func (snap *Snapshot) Get(key []byte, ro *opt.ReadOptions) ([]byte, error) {
+	if err := snap.ok(); err != nil {
+		return nil, err
+	}
+	return snap.c.get(snap.id, key, ro)
+}
+
+// Has returns true if the DB does contain the given key.
+func (snap *Snapshot) Has(key []byte, ro *opt.ReadOptions) (bool, error) {
+	if err := snap.ok(); err != nil {
+		return false, err
+	}
+	return snap.c.has(snap.id, key, ro)
+}
+
+// NewIterator returns an iterator for the snapshot of the DB. The
+// iterator must be released before the snapshot.
+func (snap *Snapshot) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	if err := snap.ok(); err != nil {
+		return iterator.NewEmptyIterator(err)
+	}
+	iter := &remoteIter{c: snap.c, id: snap.id, dontFillCache: dontFillCache(ro)}
+	if slice != nil {
+		iter.start = slice.Start
+		iter.limit, iter.hasLimit = slice.Limit, slice.Limit != nil
+	}
+	return iter
+}
+
+// Release releases the snapshot. Other methods should not be called after
+// the snapshot has been released.
+func (snap *Snapshot) Release() {
+	snap.mu.Lock()
+	defer snap.mu.Unlock()
+	if !snap.released {
+		snap.released = true
+		// The server releases the snapshots on close anyway.
+		snap.c.invoke("ReleaseSnapshot", &releaseSnapshotRequest{snap.id}, new(empty))
+	}
+}
+
+// remoteIter iterates over a remote snapshot, fetching the entries in pages.
+// The page is in key order, whichever direction it was fetched in.
+type remoteIter struct {
+	util.BasicReleaser
+	c             *Client
+	id            uint64
+	snap          *Snapshot // Owned snapshot, if any.
+	start, limit  []byte
+	hasLimit      bool
+	dontFillCache bool
+
+	page []*entry
+	// Whether there are entries before or after the page.
+	moreBefore, moreAfter bool
+	// Position in the page; -1 before the first entry, len(page) after the
+	// last.
+	pos int
+	// Whether the iterator is positioned, or has been released.
+	positioned, released bool
+	err                  error
+}
+
+// Fetches a page starting at from, if set, in the given direction.
+func (i *remoteIter) fetch(from []byte, hasFrom, exclusive, reverse bool) bool {
+	req := &iterateRequest{
+		Start:         i.start,
+		Limit:         i.limit,
+		HasLimit:      i.hasLimit,
+		Snapshot:      i.id,
+		From:          from,
+		HasFrom:       hasFrom,
+		Exclusive:     exclusive,
+		Reverse:       reverse,
+		Count:         uint64(i.c.pageSize),
+		DontFillCache: i.dontFillCache,
+	}
+	resp := new(iterateResponse)
+	if err := i.c.invoke("Iterate", req, resp); err != nil {
+		i.err = err
+		i.page, i.pos = nil, 0
+		return false
+	}
+	i.positioned = true
+	i.page = resp.Entries
+	if reverse {
+		for a, b := 0, len(i.page)-1; a < b; a, b = a+1, b-1 {
+			i.page[a], i.page[b] = i.page[b], i.page[a]
+		}
+		i.moreBefore, i.moreAfter = resp.More, hasFrom
+		i.pos = len(i.page) - 1
+		if len(i.page) == 0 {
+			i.pos = -1
+		}
+	} else {
+		i.moreBefore, i.moreAfter = hasFrom, resp.More
+		i.pos = 0
+	}
+	return i.Valid()
+}
+
+func (i *remoteIter) First() bool {
+	if i.released {
+		i.err = leveldb.ErrIterReleased
+		return false
+	}
+	return i.fetch(nil, false, false, false)
+}
+
+func (i *remoteIter) Last() bool {
+	if i.released {
+		i.err = leveldb.ErrIterReleased
+		return false
+	}
+	return i.fetch(nil, false, false, true)
+}
+
+func (i *remoteIter) Seek(key []byte) bool {
+	if i.released {
+		i.err = leveldb.ErrIterReleased
+		return false
+	}
+	return i.fetch(key, true, false, false)
+}
+
+func (i *remoteIter) Next() bool {
+	switch {
+	case i.released:
+		i.err = leveldb.ErrIterReleased
+		return false
+	case i.err != nil:
+		return false
+	case !i.positioned || i.pos < 0:
+		return i.First()
+	case i.pos+1 < len(i.page):
+		i.pos++
+		return true
+	case i.pos < len(i.page) && i.moreAfter:
+		return i.fetch(i.page[i.pos].Key, true, true, false)
+	}
+	i.pos = len(i.page)
+	return false
+}
+
+func (i *remoteIter) Prev() bool {
+	switch {
+	case i.released:
+		i.err = leveldb.ErrIterReleased
+		return false
+	case i.err != nil:
+		return false
+	case !i.positioned || i.pos >= len(i.page):
+		return i.Last()
+	case i.pos > 0:
+		i.pos--
+		return true
+	case i.pos == 0 && i.moreBefore:
+		return i.fetch(i.page[0].Key, true, true, true)
+	}
+	i.pos = -1
+	return false
+}
+
+func (i *remoteIter) Valid() bool {
+	return !i.released && i.err == nil && i.pos >= 0 && i.pos < len(i.page)
+}
+
+func (i *remoteIter) Key() []byte {
+	if !i.Valid() {
+		return nil
+	}
+	return i.page[i.pos].Key
+}
+
+func (i *remoteIter) Value() []byte {
+	if !i.Valid() {
+		return nil
+	}
+	value := i.page[i.pos].Value
+	if value == nil {
+		value = []byte{}
+	}
+	return value
+}
+
+func (i *remoteIter) Error() error {
+	return i.err
+}
+
+func (i *remoteIter) Release() {
+	if i.released {
+		return
+	}
+	i.released = true
+	i.page = nil
+	if i.snap != nil {
+		i.snap.Release()
+	}
+	i.BasicReleaser.Release()
+}
@@ -0,0 +1,289 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package remote exposes a DB over the network as a gRPC service, and
+// provides a client for it implementing the same KV interface as DB, so that
+// code can switch between an embedded and a remote DB:
+//
+//	gs := grpc.NewServer()
+//	remote.NewServer(db).Register(gs)
+//	go gs.Serve(l)
+//
+//	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(creds))
+//	...
+//	var kv remote.KV = remote.NewClient(conn, nil)
+//
+// The service is described by remote.proto. Its messages are encoded in the
+// protobuf wire format by a codec of this package, registered under the
+// "leveldb-remote" content subtype, rather than by generated code.
+//
+// Iterators and snapshots of the client are backed by snapshots held by the
+// server until they are released, so they must be released as with a DB.
+// Iterators fetch the entries in pages, see ClientOptions.
+package remote
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// KV is the interface of the key/value operations of a DB, see leveldb.KV.
+// It's implemented by *leveldb.DB, its namespaces and *Client.
+type KV = leveldb.KV
+
+var (
+	_ KV = (*leveldb.DB)(nil)
+	_ KV = (*Client)(nil)
+)
+
+const serviceName = "goleveldb.remote.KV"
+
+// Name of the codec, and gRPC content subtype, of the service messages.
+const codecName = "leveldb-remote"
+
+func init() {
+	encoding.RegisterCodec(codec{})
+}
+
+// codec encodes the messages of this package.
+type codec struct{}
+
+func (codec) Name() string { return codecName }
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(message)
+	if !ok {
+		return nil, fmt.Errorf("remote: cannot marshal %T", v)
+	}
+	return marshal(nil, m), nil
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(message)
+	if !ok {
+		return fmt.Errorf("remote: cannot unmarshal %T", v)
+	}
+	return unmarshal(data, m)
+}
+
+// message is a protobuf message, described by its fields.
+type message interface {
+	fields() []field
+}
+
+// field is a message field. The value points to a []byte, bool, uint64 or
+// []*entry.
+type field struct {
+	num   protowire.Number
+	value interface{}
+}
+
+func marshal(b []byte, m message) []byte {
+	for _, f := range m.fields() {
+		switch v := f.value.(type) {
+		case *[]byte:
+			if len(*v) > 0 {
+				b = protowire.AppendTag(b, f.num, protowire.BytesType)
+				b = protowire.AppendBytes(b, *v)
+			}
+		case *bool:
+			if *v {
+				b = protowire.AppendTag(b, f.num, protowire.VarintType)
+				b = protowire.AppendVarint(b, 1)
+			}
+		case *uint64:
+			if *v != 0 {
+				b = protowire.AppendTag(b, f.num, protowire.VarintType)
+				b = protowire.AppendVarint(b, *v)
+			}
+		case *[]*entry:
+			for _, e := range *v {
+				b = protowire.AppendTag(b, f.num, protowire.BytesType)
+				b = protowire.AppendBytes(b, marshal(nil, e))
+			}
+		}
+	}
+	return b
+}
+
+// Unmarshals a message; the bytes fields are copied, unknown fields are
+// skipped.
+func unmarshal(b []byte, m message) error {
+	fields := m.fields()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		var value interface{}
+		for _, f := range fields {
+			if f.num == num {
+				value = f.value
+			}
+		}
+		switch v := value.(type) {
+		case *[]byte:
+			if typ != protowire.BytesType {
+				return fmt.Errorf("remote: field %d: wrong wire type", num)
+			}
+			var data []byte
+			data, n = protowire.ConsumeBytes(b)
+			*v = append([]byte{}, data...)
+		case *bool:
+			if typ != protowire.VarintType {
+				return fmt.Errorf("remote: field %d: wrong wire type", num)
+			}
+			var x uint64
+			x, n = protowire.ConsumeVarint(b)
+			*v = x != 0
+		case *uint64:
+			if typ != protowire.VarintType {
+				return fmt.Errorf("remote: field %d: wrong wire type", num)
+			}
+			*v, n = protowire.ConsumeVarint(b)
+		case *[]*entry:
+			if typ != protowire.BytesType {
+				return fmt.Errorf("remote: field %d: wrong wire type", num)
+			}
+			var data []byte
+			if data, n = protowire.ConsumeBytes(b); n >= 0 {
+				e := new(entry)
+				if err := unmarshal(data, e); err != nil {
+					return err
+				}
+				*v = append(*v, e)
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// The messages, see remote.proto.
+
+type empty struct{}
+
+func (*empty) fields() []field { return nil }
+
+type getRequest struct {
+	Key           []byte
+	Snapshot      uint64
+	DontFillCache bool
+}
+
+func (m *getRequest) fields() []field {
+	return []field{{1, &m.Key}, {2, &m.Snapshot}, {3, &m.DontFillCache}}
+}
+
+type getResponse struct {
+	Value []byte
+}
+
+func (m *getResponse) fields() []field {
+	return []field{{1, &m.Value}}
+}
+
+type hasResponse struct {
+	Found bool
+}
+
+func (m *hasResponse) fields() []field {
+	return []field{{1, &m.Found}}
+}
+
+type putRequest struct {
+	Key, Value []byte
+	Sync       bool
+}
+
+func (m *putRequest) fields() []field {
+	return []field{{1, &m.Key}, {2, &m.Value}, {3, &m.Sync}}
+}
+
+type deleteRequest struct {
+	Key  []byte
+	Sync bool
+}
+
+func (m *deleteRequest) fields() []field {
+	return []field{{1, &m.Key}, {2, &m.Sync}}
+}
+
+type writeRequest struct {
+	Batch []byte // As given by Batch.Dump.
+	Sync  bool
+}
+
+func (m *writeRequest) fields() []field {
+	return []field{{1, &m.Batch}, {2, &m.Sync}}
+}
+
+type iterateRequest struct {
+	Start, Limit []byte
+	HasLimit     bool
+	Snapshot     uint64
+	// The page starts at From, or at the start or end of the range if
+	// HasFrom is false.
+	From      []byte
+	HasFrom   bool
+	Exclusive bool
+	Reverse   bool
+	Count     uint64
+
+	DontFillCache bool
+}
+
+func (m *iterateRequest) fields() []field {
+	return []field{
+		{1, &m.Start}, {2, &m.Limit}, {3, &m.HasLimit}, {4, &m.Snapshot},
+		{5, &m.From}, {6, &m.HasFrom}, {7, &m.Exclusive}, {8, &m.Reverse},
+		{9, &m.Count}, {10, &m.DontFillCache},
+	}
+}
+
+type entry struct {
+	Key, Value []byte
+}
+
+func (m *entry) fields() []field {
+	return []field{{1, &m.Key}, {2, &m.Value}}
+}
+
+type iterateResponse struct {
+	Entries []*entry
+	// More is set if there are entries after the page, in its direction.
+	More bool
+}
+
+func (m *iterateResponse) fields() []field {
+	return []field{{1, &m.Entries}, {2, &m.More}}
+}
+
+type snapshotResponse struct {
+	ID uint64
+}
+
+func (m *snapshotResponse) fields() []field {
+	return []field{{1, &m.ID}}
+}
+
+type releaseSnapshotRequest struct {
+	ID uint64
+}
+
+func (m *releaseSnapshotRequest) fields() []field {
+	return []field{{1, &m.ID}}
+}
@@ -0,0 +1,260 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+func newTestClient(t *testing.T, o *ClientOptions) (*leveldb.DB, *Server, *Client) {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	s, c := newTestServer(t, db, o)
+	return db, s, c
+}
+
+// Serves the given KV to a new client.
+func newTestServer(t *testing.T, kv KV, o *ClientOptions) (*Server, *Client) {
+	l := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer()
+	s := NewServer(kv)
+	s.Register(gs)
+	go gs.Serve(l)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return l.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		gs.Stop()
+		s.Close()
+	})
+	return s, NewClient(conn, o)
+}
+
+func TestClient(t *testing.T) {
+	db, _, c := newTestClient(t, nil)
+
+	if err := c.Put([]byte("foo"), []byte("v1"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put([]byte("empty"), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := db.Get([]byte("foo"), nil); err != nil || string(v) != "v1" {
+		t.Fatalf("db: got %q, %v", v, err)
+	}
+	if v, err := c.Get([]byte("foo"), nil); err != nil || string(v) != "v1" {
+		t.Fatalf("Get: got %q, %v", v, err)
+	}
+	if v, err := c.Get([]byte("empty"), nil); err != nil || v == nil || len(v) != 0 {
+		t.Fatalf("Get empty: got %q, %v", v, err)
+	}
+	if _, err := c.Get([]byte("bar"), nil); err != leveldb.ErrNotFound {
+		t.Fatalf("Get missing: got %v", err)
+	}
+	if ok, err := c.Has([]byte("foo"), nil); err != nil || !ok {
+		t.Fatalf("Has: got %v, %v", ok, err)
+	}
+
+	b := new(leveldb.Batch)
+	b.Put([]byte("bar"), []byte("v2"))
+	b.Delete([]byte("foo"))
+	if err := c.Write(b, nil); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := c.Has([]byte("foo"), nil); err != nil || ok {
+		t.Fatalf("Has deleted: got %v, %v", ok, err)
+	}
+	if err := c.Delete([]byte("bar"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get([]byte("bar"), nil); err != leveldb.ErrNotFound {
+		t.Fatalf("db: got %v", err)
+	}
+}
+
+func TestClientSnapshot(t *testing.T) {
+	_, s, c := newTestClient(t, nil)
+
+	c.Put([]byte("foo"), []byte("v1"), nil)
+	snap, err := c.GetSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Put([]byte("foo"), []byte("v2"), nil)
+	c.Put([]byte("bar"), []byte("v2"), nil)
+
+	if v, err := snap.Get([]byte("foo"), nil); err != nil || string(v) != "v1" {
+		t.Fatalf("Get: got %q, %v", v, err)
+	}
+	if ok, err := snap.Has([]byte("bar"), nil); err != nil || ok {
+		t.Fatalf("Has: got %v, %v", ok, err)
+	}
+	iter := snap.NewIterator(nil, nil)
+	n := 0
+	for iter.Next() {
+		n++
+	}
+	iter.Release()
+	if n != 1 {
+		t.Fatalf("iterated %d entries, want 1", n)
+	}
+
+	snap.Release()
+	if _, err := snap.Get([]byte("foo"), nil); err != leveldb.ErrSnapshotReleased {
+		t.Fatalf("Get released: got %v", err)
+	}
+	if len(s.snapshots) != 0 {
+		t.Fatalf("server holds %d snapshots", len(s.snapshots))
+	}
+}
+
+func TestClientIterator(t *testing.T) {
+	_, s, c := newTestClient(t, &ClientOptions{PageSize: 3})
+
+	const n = 10
+	var keys []string
+	b := new(leveldb.Batch)
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("k%02d", i)
+		keys = append(keys, key)
+		b.Put([]byte(key), []byte("v"+key))
+	}
+	if err := c.Write(b, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(iter iterator.Iterator, want ...string) {
+		t.Helper()
+		if len(want) == 0 {
+			if iter.Valid() {
+				t.Fatalf("got %q, want end", iter.Key())
+			}
+			return
+		}
+		if !iter.Valid() || string(iter.Key()) != want[0] || string(iter.Value()) != "v"+want[0] {
+			t.Fatalf("got %q=%q (valid %v), want %q", iter.Key(), iter.Value(), iter.Valid(), want[0])
+		}
+	}
+
+	iter := c.NewIterator(nil, nil)
+	for i := 0; i < n; i++ {
+		iter.Next()
+		check(iter, keys[i])
+	}
+	iter.Next()
+	check(iter)
+	for i := n - 1; i >= 0; i-- {
+		iter.Prev()
+		check(iter, keys[i])
+	}
+	iter.Prev()
+	check(iter)
+	iter.Next()
+	check(iter, keys[0])
+
+	iter.Seek([]byte("k045"))
+	check(iter, keys[5])
+	iter.Prev()
+	check(iter, keys[4])
+	iter.Prev()
+	check(iter, keys[3])
+	iter.Last()
+	check(iter, keys[n-1])
+	if err := iter.Error(); err != nil {
+		t.Fatal(err)
+	}
+	iter.Release()
+	if len(s.snapshots) != 0 {
+		t.Fatalf("server holds %d snapshots", len(s.snapshots))
+	}
+
+	iter = c.NewIterator(&util.Range{Start: []byte("k03"), Limit: []byte("k07")}, nil)
+	var got []string
+	for iter.Last(); iter.Valid(); iter.Prev() {
+		got = append(got, string(iter.Key()))
+	}
+	iter.Release()
+	if fmt.Sprint(got) != "[k06 k05 k04 k03]" {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestServerNamespace(t *testing.T) {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.Put([]byte("other"), []byte("v"), nil)
+	s, c := newTestServer(t, db.Namespace([]byte("t0/")), &ClientOptions{PageSize: 2})
+
+	for _, key := range []string{"k1", "k2", "k3"} {
+		if err := c.Put([]byte(key), []byte("v"+key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Delete([]byte("k3"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := db.Get([]byte("t0/k1"), nil); err != nil || string(v) != "vk1" {
+		t.Fatalf("db: got %q, %v", v, err)
+	}
+	if ok, err := c.Has([]byte("k2"), nil); err != nil || !ok {
+		t.Fatalf("Has: got %v, %v", ok, err)
+	}
+	if ok, err := c.Has([]byte("other"), nil); err != nil || ok {
+		t.Fatalf("Has outside: got %v, %v", ok, err)
+	}
+
+	snap, err := c.GetSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Put([]byte("k1"), []byte("new"), nil)
+	if v, err := snap.Get([]byte("k1"), nil); err != nil || string(v) != "vk1" {
+		t.Fatalf("snapshot Get: got %q, %v", v, err)
+	}
+	snap.Release()
+
+	iter := c.NewIterator(nil, nil)
+	var got []string
+	for iter.Next() {
+		got = append(got, string(iter.Key())+"="+string(iter.Value()))
+	}
+	if err := iter.Error(); err != nil {
+		t.Fatal(err)
+	}
+	iter.Release()
+	if fmt.Sprint(got) != "[k1=new k2=vk2]" {
+		t.Fatalf("got %v", got)
+	}
+	if len(s.snapshots) != 0 {
+		t.Fatalf("server holds %d snapshots", len(s.snapshots))
+	}
+}
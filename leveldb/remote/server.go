@@ -0,0 +1,314 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package remote
+
+import (
+	"bytes"
+	"context"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Limits of an Iterate page.
+const (
+	maxPageCount = 10000
+	maxPageBytes = 1 << 20
+)
+
+// Server implements the KV service on top of a DB, or a view of it such as
+// a namespace. It's safe for concurrent use.
+type Server struct {
+	db KV
+
+	mu        sync.Mutex
+	snapshots map[uint64]leveldb.SnapshotReader
+	nextID    uint64
+}
+
+// NewServer returns a Server for the given DB, or view of it. Snapshots are
+// served only if it has a GetSnapshot method, as *leveldb.DB and its
+// namespaces do.
+func NewServer(db KV) *Server {
+	return &Server{
+		db:        db,
+		snapshots: make(map[uint64]leveldb.SnapshotReader),
+		nextID:    1,
+	}
+}
+
+// Register registers the KV service on the gRPC server.
+func (s *Server) Register(gs grpc.ServiceRegistrar) {
+	gs.RegisterService(&serviceDesc, s)
+}
+
+// Close releases the snapshots held for the clients. It doesn't close the
+// DB.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, snap := range s.snapshots {
+		snap.Release()
+		delete(s.snapshots, id)
+	}
+	return nil
+}
+
+// Converts a DB error to a gRPC status error.
+func toStatus(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case err == leveldb.ErrNotFound:
+		return status.Error(codes.NotFound, err.Error())
+	case err == leveldb.ErrClosed || err == leveldb.ErrReadOnly:
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.IsCorrupted(err):
+		return status.Error(codes.DataLoss, err.Error())
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
+
+var (
+	errUnknownSnapshot      = status.Error(codes.FailedPrecondition, "remote: unknown snapshot")
+	errSnapshotsUnsupported = status.Error(codes.Unimplemented, "remote: snapshots not supported")
+)
+
+// reader is implemented by both DB and Snapshot.
+type reader interface {
+	leveldb.Reader
+	Has(key []byte, ro *opt.ReadOptions) (bool, error)
+}
+
+// Takes a snapshot of the DB, or of the namespace.
+func (s *Server) getSnapshot() (leveldb.SnapshotReader, error) {
+	switch db := s.db.(type) {
+	case interface {
+		GetSnapshot() (*leveldb.Snapshot, error)
+	}:
+		return db.GetSnapshot()
+	case interface {
+		GetSnapshot() (leveldb.SnapshotReader, error)
+	}:
+		return db.GetSnapshot()
+	}
+	return nil, errSnapshotsUnsupported
+}
+
+// Returns the DB, or the snapshot of the given ID if nonzero.
+func (s *Server) reader(id uint64) (reader, error) {
+	if id == 0 {
+		return s.db, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap, ok := s.snapshots[id]
+	if !ok {
+		return nil, errUnknownSnapshot
+	}
+	return snap, nil
+}
+
+func readOptions(dontFillCache bool) *opt.ReadOptions {
+	if dontFillCache {
+		return &opt.ReadOptions{DontFillCache: true}
+	}
+	return nil
+}
+
+func writeOptions(sync bool) *opt.WriteOptions {
+	if sync {
+		return &opt.WriteOptions{Sync: true}
+	}
+	return nil
+}
+
+func (s *Server) get(ctx context.Context, req *getRequest) (*getResponse, error) {
+	r, err := s.reader(req.Snapshot)
+	if err != nil {
+		return nil, err
+	}
+	value, err := r.Get(req.Key, readOptions(req.DontFillCache))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &getResponse{Value: value}, nil
+}
+
+func (s *Server) has(ctx context.Context, req *getRequest) (*hasResponse, error) {
+	r, err := s.reader(req.Snapshot)
+	if err != nil {
+		return nil, err
+	}
+	found, err := r.Has(req.Key, readOptions(req.DontFillCache))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &hasResponse{Found: found}, nil
+}
+
+func (s *Server) put(ctx context.Context, req *putRequest) (*empty, error) {
+	return &empty{}, toStatus(s.db.Put(req.Key, req.Value, writeOptions(req.Sync)))
+}
+
+func (s *Server) delete(ctx context.Context, req *deleteRequest) (*empty, error) {
+	return &empty{}, toStatus(s.db.Delete(req.Key, writeOptions(req.Sync)))
+}
+
+func (s *Server) write(ctx context.Context, req *writeRequest) (*empty, error) {
+	b := new(leveldb.Batch)
+	if err := b.Load(req.Batch); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &empty{}, toStatus(s.db.Write(b, writeOptions(req.Sync)))
+}
+
+func (s *Server) iterate(ctx context.Context, req *iterateRequest) (*iterateResponse, error) {
+	r, err := s.reader(req.Snapshot)
+	if err != nil {
+		return nil, err
+	}
+	slice := &util.Range{Start: req.Start}
+	if req.HasLimit {
+		slice.Limit = req.Limit
+	}
+	iter := r.NewIterator(slice, readOptions(req.DontFillCache))
+	defer iter.Release()
+
+	count := int(req.Count)
+	if count <= 0 || count > maxPageCount {
+		count = maxPageCount
+	}
+	var ok bool
+	switch {
+	case !req.HasFrom && !req.Reverse:
+		ok = iter.First()
+	case !req.HasFrom:
+		ok = iter.Last()
+	case !req.Reverse:
+		ok = iter.Seek(req.From)
+		if ok && req.Exclusive && bytes.Equal(iter.Key(), req.From) {
+			ok = iter.Next()
+		}
+	default:
+		// The last key before From, or at From if inclusive.
+		if ok = iter.Seek(req.From); !ok {
+			ok = iter.Last()
+		} else if req.Exclusive || !bytes.Equal(iter.Key(), req.From) {
+			ok = iter.Prev()
+		}
+	}
+	resp := new(iterateResponse)
+	for size := 0; ok && len(resp.Entries) < count && size < maxPageBytes; {
+		e := &entry{append([]byte{}, iter.Key()...), append([]byte{}, iter.Value()...)}
+		resp.Entries = append(resp.Entries, e)
+		size += len(e.Key) + len(e.Value)
+		ok = step(iter, req.Reverse)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, toStatus(err)
+	}
+	resp.More = ok
+	return resp, nil
+}
+
+func step(iter iterator.Iterator, reverse bool) bool {
+	if reverse {
+		return iter.Prev()
+	}
+	return iter.Next()
+}
+
+func (s *Server) snapshot(ctx context.Context, req *empty) (*snapshotResponse, error) {
+	snap, err := s.getSnapshot()
+	if err == errSnapshotsUnsupported {
+		return nil, err
+	} else if err != nil {
+		return nil, toStatus(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.nextID
+	s.nextID++
+	s.snapshots[id] = snap
+	return &snapshotResponse{ID: id}, nil
+}
+
+func (s *Server) releaseSnapshot(ctx context.Context, req *releaseSnapshotRequest) (*empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap, ok := s.snapshots[req.ID]
+	if !ok {
+		return nil, errUnknownSnapshot
+	}
+	snap.Release()
+	delete(s.snapshots, req.ID)
+	return &empty{}, nil
+}
+
+// Returns the description of a unary method, as generated by
+// protoc-gen-go-grpc, decoding the request into a new message and calling
+// the given method of the Server.
+func unaryMethod(name string, newReq func() message, call func(s *Server, ctx context.Context, req message) (interface{}, error)) grpc.MethodDesc {
+	fullName := "/" + serviceName + "/" + name
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := newReq()
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return call(srv.(*Server), ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: fullName}
+			return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv.(*Server), ctx, req.(message))
+			})
+		},
+	}
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		unaryMethod("Get", func() message { return new(getRequest) }, func(s *Server, ctx context.Context, req message) (interface{}, error) {
+			return s.get(ctx, req.(*getRequest))
+		}),
+		unaryMethod("Has", func() message { return new(getRequest) }, func(s *Server, ctx context.Context, req message) (interface{}, error) {
+			return s.has(ctx, req.(*getRequest))
+		}),
+		unaryMethod("Put", func() message { return new(putRequest) }, func(s *Server, ctx context.Context, req message) (interface{}, error) {
+			return s.put(ctx, req.(*putRequest))
+		}),
+		unaryMethod("Delete", func() message { return new(deleteRequest) }, func(s *Server, ctx context.Context, req message) (interface{}, error) {
+			return s.delete(ctx, req.(*deleteRequest))
+		}),
+		unaryMethod("Write", func() message { return new(writeRequest) }, func(s *Server, ctx context.Context, req message) (interface{}, error) {
+			return s.write(ctx, req.(*writeRequest))
+		}),
+		unaryMethod("Iterate", func() message { return new(iterateRequest) }, func(s *Server, ctx context.Context, req message) (interface{}, error) {
+			return s.iterate(ctx, req.(*iterateRequest))
+		}),
+		unaryMethod("Snapshot", func() message { return new(empty) }, func(s *Server, ctx context.Context, req message) (interface{}, error) {
+			return s.snapshot(ctx, req.(*empty))
+		}),
+		unaryMethod("ReleaseSnapshot", func() message { return new(releaseSnapshotRequest) }, func(s *Server, ctx context.Context, req message) (interface{}, error) {
+			return s.releaseSnapshot(ctx, req.(*releaseSnapshotRequest))
+		}),
+	},
+	Metadata: "leveldb/remote/remote.proto",
+}
@@ -0,0 +1,400 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package replication
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// FollowerOptions holds the optional parameters of a Follower.
+type FollowerOptions struct {
+	// SeqKey is the key holding the position of the follower in the stream
+	// of the primary.
+	//
+	// The default is "\xff\xffreplication.seq".
+	SeqKey []byte
+
+	// RetryInterval is the delay before connecting again to the primary
+	// after a failure.
+	//
+	// The default is 1 second.
+	RetryInterval time.Duration
+
+	// TempDir is the directory of the temporary copy of the snapshots sent
+	// by the primary.
+	//
+	// The default is os.TempDir().
+	TempDir string
+
+	// Name is the name of the follower reported by the primary, see
+	// Primary.Followers.
+	Name string
+
+	// Logical enables the logical mode: the follower never replaces its
+	// content with a snapshot of the primary, and Run fails with
+	// ErrHistoryTruncated if its position isn't in the history of the
+	// primary.
+	Logical bool
+
+	// Checkpoint is the position of the follower if none is stored under
+	// SeqKey yet, i.e. the sequence number of the last write of the primary
+	// the DB holds, e.g. that of the backup it was seeded from, see
+	// leveldb.Snapshot.Seq. It must be of the current run of the primary.
+	//
+	// The default is zero, which means the follower starts empty.
+	Checkpoint uint64
+}
+
+// DefaultSeqKey is the default FollowerOptions.SeqKey.
+var DefaultSeqKey = []byte("\xff\xffreplication.seq")
+
+// Number of entries deleted per batch when replacing the content of the
+// follower with a snapshot.
+const clearBatchLen = 1000
+
+// Status is the state of a Follower.
+type Status struct {
+	// Connected tells whether the follower is connected to the primary.
+	Connected bool
+
+	// Seq is the sequence number of the last write of the primary applied.
+	Seq uint64
+
+	// CaughtUp is the last time the follower was known to have applied
+	// all the writes of the primary, so that it's at most time.Since
+	// CaughtUp behind. It's zero if it hasn't caught up since started.
+	CaughtUp time.Time
+
+	// Resyncs is the number of snapshots of the primary applied.
+	Resyncs int
+
+	// Err is the error of the last connection, if any.
+	Err error
+}
+
+// Follower applies the writes streamed by a primary to a DB. It's safe for
+// concurrent use.
+type Follower struct {
+	db      *leveldb.DB
+	t       Transport
+	seqKey  []byte
+	retry   time.Duration
+	tempDir string
+	name    string
+	logical bool
+	start   uint64
+
+	// The position in the stream, only changed by Run.
+	epoch, seq uint64
+
+	mu     sync.Mutex
+	status Status
+}
+
+// NewFollower returns a Follower applying the writes of the primary reached
+// through the transport to the given DB. The options may be nil.
+func NewFollower(db *leveldb.DB, t Transport, o *FollowerOptions) *Follower {
+	f := &Follower{
+		db:     db,
+		t:      t,
+		seqKey: DefaultSeqKey,
+		retry:  time.Second,
+	}
+	if o != nil {
+		if len(o.SeqKey) > 0 {
+			f.seqKey = o.SeqKey
+		}
+		if o.RetryInterval > 0 {
+			f.retry = o.RetryInterval
+		}
+		f.tempDir = o.TempDir
+		f.name = o.Name
+		f.logical = o.Logical
+		f.start = o.Checkpoint
+	}
+	return f
+}
+
+// Status returns the state of the follower.
+func (f *Follower) Status() Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.status
+}
+
+func (f *Follower) setStatus(fn func(s *Status)) {
+	f.mu.Lock()
+	fn(&f.status)
+	f.mu.Unlock()
+}
+
+// Run follows the primary until the context is done, connecting again after
+// failures, and returns the error of the context. It returns other errors,
+// which need a fix of the follower or of the primary, e.g. if writing the
+// DB fails, or ErrHistoryTruncated in logical mode. Run must not be called
+// concurrently.
+func (f *Follower) Run(ctx context.Context) error {
+	if err := f.loadSeq(); err != nil {
+		return err
+	}
+	f.setStatus(func(s *Status) { s.Seq = f.seq })
+	for {
+		err := f.follow(ctx)
+		f.setStatus(func(s *Status) {
+			s.Connected = false
+			s.Err = err
+		})
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if _, ok := err.(applyError); ok || err == ErrHistoryTruncated {
+			return err
+		}
+		select {
+		case <-time.After(f.retry):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// An error applying the stream to the DB.
+type applyError struct {
+	err error
+}
+
+func (e applyError) Error() string {
+	return "replication: applying: " + e.err.Error()
+}
+
+func (f *Follower) loadSeq() error {
+	v, err := f.db.Get(f.seqKey, nil)
+	if err == leveldb.ErrNotFound {
+		f.epoch, f.seq = 0, f.start
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(v) != 16 {
+		return fmt.Errorf("replication: invalid value %q of %q", v, f.seqKey)
+	}
+	f.epoch = binary.BigEndian.Uint64(v)
+	f.seq = binary.BigEndian.Uint64(v[8:])
+	return nil
+}
+
+func (f *Follower) seqValue(seq uint64) []byte {
+	v := binary.BigEndian.AppendUint64(nil, f.epoch)
+	return binary.BigEndian.AppendUint64(v, seq)
+}
+
+// Follows the primary over a connection, until it fails.
+func (f *Follower) follow(ctx context.Context) error {
+	conn, err := f.t.Dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	w := bufio.NewWriter(conn)
+	var flags uint64
+	if f.logical {
+		flags |= helloLogical
+	}
+	if err := writeFrame(w, frameHello, []byte(f.name), protocolVersion, f.epoch, f.seq, flags); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	typ, payload, err := readFrame(r)
+	if err != nil {
+		return err
+	}
+	if typ == frameError {
+		return remoteError(payload)
+	}
+	if typ != frameStart {
+		return protocolError("expected start")
+	}
+	var epoch uint64
+	if _, err := readUvarints(payload, &epoch); err != nil {
+		return err
+	}
+	// The primary either resumes from the position of the follower, in
+	// its epoch from now on, or sends a snapshot.
+	f.epoch = epoch
+	f.setStatus(func(s *Status) { s.Connected = true })
+
+	for {
+		typ, payload, err := readFrame(r)
+		if err != nil {
+			return err
+		}
+		if typ == frameError {
+			return remoteError(payload)
+		}
+		var seq uint64
+		if payload, err = readUvarints(payload, &seq); err != nil {
+			return err
+		}
+		switch typ {
+		case frameTable:
+			if f.logical {
+				return protocolError("snapshot sent to a logical follower")
+			}
+			if err := f.resync(r, seq); err != nil {
+				return err
+			}
+			if err := f.ack(w); err != nil {
+				return err
+			}
+		case frameBatch:
+			if err := f.apply(seq, payload); err != nil {
+				return err
+			}
+		case frameHeartbeat:
+			if seq == f.seq {
+				f.setStatus(func(s *Status) { s.CaughtUp = time.Now() })
+			}
+			if err := f.ack(w); err != nil {
+				return err
+			}
+		default:
+			return protocolError(fmt.Sprintf("unexpected frame type %d", typ))
+		}
+	}
+}
+
+// Returns the error sent by the primary.
+func remoteError(payload []byte) error {
+	var code uint64
+	msg, err := readUvarints(payload, &code)
+	if err != nil {
+		return err
+	}
+	if code == errCodeHistoryTruncated {
+		return ErrHistoryTruncated
+	}
+	return fmt.Errorf("replication: primary error %d: %s", code, msg)
+}
+
+// Acknowledges the position of the follower.
+func (f *Follower) ack(w *bufio.Writer) error {
+	if err := writeFrame(w, frameAck, nil, f.seq); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// Applies a batch of the primary, unless already applied.
+func (f *Follower) apply(seq uint64, data []byte) error {
+	b := new(leveldb.Batch)
+	if err := b.Load(data); err != nil {
+		return protocolError(err.Error())
+	}
+	if b.Len() == 0 {
+		return protocolError("empty batch")
+	}
+	last := seq + uint64(b.Len()) - 1
+	if last <= f.seq {
+		return nil
+	}
+	if seq != f.seq+1 {
+		return protocolError(fmt.Sprintf("batch %d following %d", seq, f.seq))
+	}
+	b.Put(f.seqKey, f.seqValue(last))
+	if err := f.db.Write(b, nil); err != nil {
+		return applyError{err}
+	}
+	f.seq = last
+	f.setStatus(func(s *Status) { s.Seq = last })
+	return nil
+}
+
+// Replaces the content of the DB with the snapshot of the primary at the
+// given sequence number, read from the table frames.
+func (f *Follower) resync(r *bufio.Reader, seq uint64) error {
+	tmp, err := os.CreateTemp(f.tempDir, "replication-*.sst")
+	if err != nil {
+		return applyError{err}
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	var size int64
+	for {
+		typ, payload, err := readFrame(r)
+		if err != nil {
+			return err
+		}
+		if typ == frameTableEnd {
+			break
+		}
+		if typ != frameTableChunk {
+			return protocolError(fmt.Sprintf("unexpected frame type %d in table", typ))
+		}
+		if _, err := tmp.Write(payload); err != nil {
+			return applyError{err}
+		}
+		size += int64(len(payload))
+	}
+
+	// The DB is inconsistent until the snapshot is fully applied, so a
+	// crash meanwhile must cause another resync.
+	if err := f.db.Delete(f.seqKey, &opt.WriteOptions{Sync: true}); err != nil {
+		return applyError{err}
+	}
+	f.seq = 0
+	if err := f.clear(); err != nil {
+		return applyError{err}
+	}
+	if _, err := f.db.IngestRocksDB(tmp, size); err != nil {
+		return applyError{err}
+	}
+	if err := f.db.Put(f.seqKey, f.seqValue(seq), &opt.WriteOptions{Sync: true}); err != nil {
+		return applyError{err}
+	}
+	f.seq = seq
+	f.setStatus(func(s *Status) {
+		s.Seq = seq
+		s.Resyncs++
+	})
+	return nil
+}
+
+// Deletes all the entries of the DB.
+func (f *Follower) clear() error {
+	iter := f.db.NewIterator(nil, nil)
+	defer iter.Release()
+	b := new(leveldb.Batch)
+	for iter.Next() {
+		b.Delete(iter.Key())
+		if b.Len() == clearBatchLen {
+			if err := f.db.Write(b, nil); err != nil {
+				return err
+			}
+			b.Reset()
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	return f.db.Write(b, nil)
+}
@@ -0,0 +1,313 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package replication
+
+import (
+	"bufio"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// PrimaryOptions holds the optional parameters of a Primary.
+type PrimaryOptions struct {
+	// HeartbeatInterval is the interval of the heartbeats telling the
+	// followers they're up to date, see Status.CaughtUp.
+	//
+	// The default is 1 second.
+	HeartbeatInterval time.Duration
+}
+
+// Primary streams the writes of a DB to followers. It's safe for concurrent
+// use.
+type Primary struct {
+	db        *leveldb.DB
+	heartbeat time.Duration
+	// The followers of another run of the primary may resume only up to
+	// baseSeq, as the writes after it may have been lost in a crash.
+	epoch   uint64
+	baseSeq uint64
+
+	mu        sync.Mutex
+	closed    bool
+	closeC    chan struct{}
+	listeners map[net.Listener]struct{}
+	conns     map[io.Closer]*FollowerStatus
+}
+
+// FollowerStatus is the state of a follower connected to a Primary.
+type FollowerStatus struct {
+	// Name is the name of the follower, see FollowerOptions.Name.
+	Name string
+
+	// Logical tells whether the follower is in logical mode.
+	Logical bool
+
+	// Acked is the last sequence number the follower acknowledged to have
+	// applied, at AckedAt. It's first the position the follower resumed
+	// from, zero if it needed a snapshot.
+	Acked   uint64
+	AckedAt time.Time
+}
+
+// NewPrimary returns a Primary for the given DB, which must be opened with
+// a WALRetentionSize large enough for the followers to catch up after a
+// disconnection. The options may be nil.
+//
+// NewPrimary must be called before writing to the DB once opened: the
+// followers of a previous run of the primary then resync if they're ahead
+// of the DB, as they may hold writes lost by a crash of the primary.
+func NewPrimary(db *leveldb.DB, o *PrimaryOptions) (*Primary, error) {
+	snap, err := db.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+	p := &Primary{
+		db:        db,
+		heartbeat: time.Second,
+		epoch:     rand.Uint64() | 1,
+		baseSeq:   snap.Seq(),
+		closeC:    make(chan struct{}),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[io.Closer]*FollowerStatus),
+	}
+	if o != nil && o.HeartbeatInterval > 0 {
+		p.heartbeat = o.HeartbeatInterval
+	}
+	return p, nil
+}
+
+// Serve accepts the connections of the listener, serving each in its own
+// goroutine, until the listener fails or the primary is closed. It closes
+// the listener.
+func (p *Primary) Serve(l net.Listener) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		l.Close()
+		return ErrClosed
+	}
+	p.listeners[l] = struct{}{}
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.listeners, l)
+		p.mu.Unlock()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			p.mu.Lock()
+			closed := p.closed
+			p.mu.Unlock()
+			if closed {
+				return ErrClosed
+			}
+			return err
+		}
+		go p.ServeConn(conn)
+	}
+}
+
+// ServeConn streams the writes to the follower of a connection, until the
+// connection fails or the primary is closed, and closes it. It returns nil
+// if the follower hung up, and ErrHistoryTruncated if it's a logical
+// follower which can't resume.
+func (p *Primary) ServeConn(conn io.ReadWriteCloser) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		conn.Close()
+		return ErrClosed
+	}
+	p.conns[conn] = nil
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.conns, conn)
+		p.mu.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	typ, payload, err := readFrame(r)
+	if err != nil {
+		return err
+	}
+	if typ != frameHello {
+		return protocolError("expected hello")
+	}
+	var version, epoch, seq, flags uint64
+	if payload, err = readUvarints(payload, &version); err != nil {
+		return err
+	}
+	if version != protocolVersion {
+		return protocolError("unsupported version")
+	}
+	if payload, err = readUvarints(payload, &epoch, &seq, &flags); err != nil {
+		return err
+	}
+	logical := flags&helloLogical != 0
+	if err := writeFrame(w, frameStart, nil, p.epoch); err != nil {
+		return err
+	}
+
+	var it *leveldb.WALIterator
+	if seq != 0 && (epoch == p.epoch || epoch == 0 || seq <= p.baseSeq) {
+		if it, err = p.db.NewWALIterator(seq); err != nil && err != leveldb.ErrWALTruncated {
+			return err
+		}
+	}
+	fs := &FollowerStatus{Name: string(payload), Logical: logical, AckedAt: time.Now()}
+	if it != nil {
+		fs.Acked = seq
+	}
+	p.mu.Lock()
+	p.conns[conn] = fs
+	p.mu.Unlock()
+
+	// The follower only sends acks; notice when it hangs up.
+	hangupC := make(chan struct{})
+	go func() {
+		defer close(hangupC)
+		for {
+			typ, payload, err := readFrame(r)
+			if err != nil || typ != frameAck {
+				return
+			}
+			var acked uint64
+			if _, err := readUvarints(payload, &acked); err != nil {
+				return
+			}
+			p.mu.Lock()
+			fs.Acked, fs.AckedAt = acked, time.Now()
+			p.mu.Unlock()
+		}
+	}()
+
+	ticker := time.NewTicker(p.heartbeat)
+	defer ticker.Stop()
+	for {
+		if it == nil {
+			if logical {
+				// A logical follower can't take a snapshot.
+				if err := writeFrame(w, frameError, []byte(ErrHistoryTruncated.Error()), errCodeHistoryTruncated); err == nil {
+					w.Flush()
+				}
+				return ErrHistoryTruncated
+			}
+			if it, err = p.sendTable(w); err != nil {
+				return p.connErr(err, hangupC)
+			}
+		}
+		for it.Next() {
+			if err := writeFrame(w, frameBatch, it.Batch().Dump(), it.Seq()); err != nil {
+				return p.connErr(err, hangupC)
+			}
+		}
+		if err := it.Error(); err == leveldb.ErrWALTruncated {
+			// The follower is too far behind.
+			it = nil
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return p.connErr(err, hangupC)
+		}
+
+		select {
+		case <-it.Wait():
+		case <-ticker.C:
+			if err := writeFrame(w, frameHeartbeat, nil, it.LastSeq()); err != nil {
+				return p.connErr(err, hangupC)
+			}
+		case <-hangupC:
+			return nil
+		case <-p.closeC:
+			return ErrClosed
+		}
+	}
+}
+
+// Sends a snapshot of the DB, returning the iterator over the batches
+// written since.
+func (p *Primary) sendTable(w *bufio.Writer) (*leveldb.WALIterator, error) {
+	snap, err := p.db.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Release()
+	it, err := p.db.NewWALIterator(snap.Seq())
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFrame(w, frameTable, nil, snap.Seq()); err != nil {
+		return nil, err
+	}
+	if _, err := snap.ExportRocksDB(chunkWriter{w}, nil); err != nil {
+		return nil, err
+	}
+	if err := writeFrame(w, frameTableEnd, nil); err != nil {
+		return nil, err
+	}
+	return it, nil
+}
+
+// Returns the error of a connection, nil if the follower hung up, or
+// ErrClosed if the primary was closed.
+func (p *Primary) connErr(err error, hangupC <-chan struct{}) error {
+	select {
+	case <-p.closeC:
+		return ErrClosed
+	case <-hangupC:
+		return nil
+	default:
+		return err
+	}
+}
+
+// Followers returns the state of the connected followers.
+func (p *Primary) Followers() []FollowerStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var fs []FollowerStatus
+	for _, s := range p.conns {
+		if s != nil {
+			fs = append(fs, *s)
+		}
+	}
+	sort.Slice(fs, func(i, j int) bool { return fs[i].Name < fs[j].Name })
+	return fs
+}
+
+// Close closes the listeners and the connections. It doesn't close the DB.
+func (p *Primary) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.closeC)
+	for l := range p.listeners {
+		l.Close()
+	}
+	for conn := range p.conns {
+		conn.Close()
+	}
+	return nil
+}
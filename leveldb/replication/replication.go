@@ -0,0 +1,215 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package replication streams the writes of a primary DB to followers,
+// which apply them to their own DB to stay up to date:
+//
+//	// On the primary, opened with a WALRetentionSize.
+//	l, err := net.Listen("tcp", ":7070")
+//	...
+//	p, err := replication.NewPrimary(db, nil)
+//	...
+//	go p.Serve(l)
+//
+//	// On a follower.
+//	f := replication.NewFollower(db, replication.TCPTransport("primary:7070"), nil)
+//	go f.Run(ctx)
+//
+// The primary ships the write batches as committed, read from the WAL
+// history of the DB, see leveldb.DB.NewWALIterator. A new follower, or one
+// whose position is no longer in the history, e.g. after a restart of the
+// primary, first gets a snapshot of the whole DB as a RocksDB SST file,
+// see leveldb.Snapshot.ExportRocksDB; the follower then replaces its
+// content with the file, and applies the batches written since the
+// snapshot. The primary must use the default comparer.
+//
+// The follower stores the position in the stream of the primary it's at,
+// the sequence number of the last write applied, under
+// FollowerOptions.SeqKey, in the same batches as the writes: so that it
+// resumes from there after a restart or a crash, and applies each batch
+// exactly once. Its DB must not be written by other code. The follower
+// acknowledges its position to the primary, see Primary.Followers.
+//
+// In logical mode, see FollowerOptions.Logical, the follower only applies
+// the batches, starting from a checkpoint, e.g. the sequence number of a
+// backup of the primary it was seeded from, see leveldb.Snapshot.Seq. It
+// never replaces its content with a snapshot, and fails with
+// ErrHistoryTruncated if its position isn't in the history of the primary.
+// Its DB may then hold other data.
+//
+// The transport is pluggable: the primary serves any io.ReadWriteCloser,
+// e.g. a TLS connection, and a follower connects through a Transport. The
+// stream isn't authenticated nor encrypted, and an encrypted DB is streamed
+// decrypted, so use a trusted network or a TLS transport.
+package replication
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Transport connects a follower to its primary.
+type Transport interface {
+	Dial(ctx context.Context) (io.ReadWriteCloser, error)
+}
+
+// TransportFunc is a function implementing Transport.
+type TransportFunc func(ctx context.Context) (io.ReadWriteCloser, error)
+
+// Dial calls f.
+func (f TransportFunc) Dial(ctx context.Context) (io.ReadWriteCloser, error) {
+	return f(ctx)
+}
+
+// TCPTransport returns a Transport connecting to the TCP address.
+func TCPTransport(addr string) Transport {
+	return TransportFunc(func(ctx context.Context) (io.ReadWriteCloser, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", addr)
+	})
+}
+
+var (
+	// ErrClosed is returned by the Serve and ServeConn methods of a closed
+	// Primary.
+	ErrClosed = errors.New("replication: closed")
+
+	// ErrHistoryTruncated is returned by a logical follower, and by the
+	// primary serving it, when the position of the follower isn't in the
+	// history of the primary.
+	ErrHistoryTruncated = errors.New("replication: position not in the history of the primary")
+)
+
+// The stream is made of frames: a byte of frame type, the uvarint length of
+// the payload, and the payload:
+//
+//	hello      uvarint version, uvarint epoch and sequence number of the
+//	           follower, zeros if none, uvarint hello flags, name of the
+//	           follower
+//	start      uvarint epoch of the primary
+//	error      uvarint error code, message
+//	ack        uvarint sequence number applied by the follower
+//	table      uvarint sequence number of the snapshot, followed by the
+//	           table chunks and the table end
+//	tableChunk bytes of the SST file
+//	tableEnd   empty
+//	batch      uvarint sequence number of the first record, batch data
+//	heartbeat  uvarint sequence number of the primary
+//
+// The follower sends hello, then the primary start and the rest, but for
+// the acks sent by the follower. The epoch tells apart the runs of the
+// primary, see NewPrimary. An epoch zero, with a sequence number, is a
+// checkpoint given to a new follower, trusted to be of the primary.
+const (
+	frameHello byte = iota + 1
+	frameStart
+	frameError
+	frameAck
+	frameTable
+	frameTableChunk
+	frameTableEnd
+	frameBatch
+	frameHeartbeat
+)
+
+// Hello flags.
+const (
+	helloLogical = 1 << iota
+)
+
+// Error codes.
+const (
+	errCodeHistoryTruncated = 1
+)
+
+const protocolVersion = 2
+
+// Largest frame payload accepted.
+const maxFrameSize = 1 << 30
+
+type protocolError string
+
+func (e protocolError) Error() string {
+	return "replication: protocol error: " + string(e)
+}
+
+// Writes a frame whose payload is the uvarints followed by the data.
+func writeFrame(w *bufio.Writer, typ byte, data []byte, nums ...uint64) error {
+	var hdr [1 + 4*binary.MaxVarintLen64]byte
+	hdr[0] = typ
+	size := len(data)
+	for _, x := range nums {
+		size += uvarintLen(x)
+	}
+	n := 1 + binary.PutUvarint(hdr[1:], uint64(size))
+	for _, x := range nums {
+		n += binary.PutUvarint(hdr[n:], x)
+	}
+	if _, err := w.Write(hdr[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+func uvarintLen(x uint64) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], x)
+}
+
+func readFrame(r *bufio.Reader) (typ byte, payload []byte, err error) {
+	if typ, err = r.ReadByte(); err != nil {
+		return 0, nil, err
+	}
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, nil, noEOF(err)
+	}
+	if size > maxFrameSize {
+		return 0, nil, protocolError(fmt.Sprintf("frame of %d bytes", size))
+	}
+	payload = make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, noEOF(err)
+	}
+	return typ, payload, nil
+}
+
+// Reads the uvarints heading a payload, returning the rest.
+func readUvarints(payload []byte, nums ...*uint64) ([]byte, error) {
+	for _, x := range nums {
+		var n int
+		if *x, n = binary.Uvarint(payload); n <= 0 {
+			return nil, protocolError("invalid frame")
+		}
+		payload = payload[n:]
+	}
+	return payload, nil
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Writes the SST file as table chunks.
+type chunkWriter struct {
+	w *bufio.Writer
+}
+
+func (c chunkWriter) Write(p []byte) (int, error) {
+	if err := writeFrame(c.w, frameTableChunk, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
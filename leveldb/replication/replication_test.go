@@ -0,0 +1,280 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package replication
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func openDB(t *testing.T, o *opt.Options) *leveldb.DB {
+	db, err := leveldb.Open(storage.NewMemStorage(), o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// Starts a primary serving the DB, returning the transport to it.
+func startPrimary(t *testing.T, db *leveldb.DB) (*Primary, Transport) {
+	p, err := NewPrimary(db, &PrimaryOptions{HeartbeatInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- p.Serve(l) }()
+	t.Cleanup(func() {
+		p.Close()
+		if err := <-done; err != ErrClosed {
+			t.Errorf("Serve: got %v", err)
+		}
+	})
+	return p, TCPTransport(l.Addr().String())
+}
+
+// Runs a follower until the returned function is called. The options may
+// be nil.
+func startFollower(t *testing.T, db *leveldb.DB, tr Transport, o *FollowerOptions) (*Follower, func()) {
+	if o == nil {
+		o = &FollowerOptions{}
+	}
+	o.RetryInterval = 10 * time.Millisecond
+	o.TempDir = t.TempDir()
+	f := NewFollower(db, tr, o)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- f.Run(ctx) }()
+	stop := func() {
+		cancel()
+		if err := <-done; err != context.Canceled {
+			t.Errorf("Run: got %v", err)
+		}
+	}
+	return f, stop
+}
+
+func seq(t *testing.T, db *leveldb.DB) uint64 {
+	snap, err := db.GetSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Release()
+	return snap.Seq()
+}
+
+// Waits for the follower to catch up with the primary, and compares their
+// contents.
+func waitSync(t *testing.T, f *Follower, primary, follower *leveldb.DB) {
+	t.Helper()
+	waitCaughtUp(t, f, primary)
+	if got, want := dump(t, follower), dump(t, primary); got != want {
+		t.Fatalf("follower content:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func waitCaughtUp(t *testing.T, f *Follower, primary *leveldb.DB) {
+	t.Helper()
+	want := seq(t, primary)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		s := f.Status()
+		if s.Seq == want && !s.CaughtUp.IsZero() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("follower at %d, want %d: %+v", s.Seq, want, s)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func dump(t *testing.T, db *leveldb.DB) string {
+	var buf bytes.Buffer
+	iter := db.NewIterator(nil, nil)
+	defer iter.Release()
+	for iter.Next() {
+		if !bytes.Equal(iter.Key(), DefaultSeqKey) {
+			fmt.Fprintf(&buf, "%q=%q\n", iter.Key(), iter.Value())
+		}
+	}
+	if err := iter.Error(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func put(t *testing.T, db *leveldb.DB, from, to int, value string) {
+	for i := from; i < to; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("k%04d", i)), []byte(value), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReplication(t *testing.T) {
+	pdb := openDB(t, &opt.Options{WALRetentionSize: opt.KiB})
+	put(t, pdb, 0, 100, "v1")
+	_, tr := startPrimary(t, pdb)
+
+	fdb := openDB(t, nil)
+	fdb.Put([]byte("stale"), []byte("x"), nil)
+	f, stop := startFollower(t, fdb, tr, nil)
+	waitSync(t, f, pdb, fdb)
+	if s := f.Status(); s.Resyncs != 1 || !s.Connected {
+		t.Fatalf("initial sync: got %+v", s)
+	}
+
+	// Streaming.
+	put(t, pdb, 50, 150, "v2")
+	b := new(leveldb.Batch)
+	b.Delete([]byte("k0000"))
+	b.Put([]byte("k0001"), nil)
+	pdb.Write(b, nil)
+	waitSync(t, f, pdb, fdb)
+	stop()
+
+	// Resuming after a disconnection.
+	put(t, pdb, 150, 160, "v3")
+	f, stop = startFollower(t, fdb, tr, nil)
+	waitSync(t, f, pdb, fdb)
+	if s := f.Status(); s.Resyncs != 0 {
+		t.Fatalf("resume: got %+v", s)
+	}
+	stop()
+
+	// Resyncing when too far behind.
+	put(t, pdb, 0, 200, "v4")
+	f, stop = startFollower(t, fdb, tr, nil)
+	waitSync(t, f, pdb, fdb)
+	if s := f.Status(); s.Resyncs != 1 {
+		t.Fatalf("resync: got %+v", s)
+	}
+	stop()
+}
+
+func TestReplicationEpoch(t *testing.T) {
+	pdb := openDB(t, &opt.Options{WALRetentionSize: opt.MiB})
+	put(t, pdb, 0, 10, "v1")
+	_, tr := startPrimary(t, pdb)
+	put(t, pdb, 10, 20, "v2")
+
+	// A follower of another run of the primary, ahead of its start, may
+	// hold lost writes.
+	fdb := openDB(t, nil)
+	pos := make([]byte, 16)
+	pos[7] = 42
+	pos[15] = 15
+	fdb.Put(DefaultSeqKey, pos, nil)
+	f, stop := startFollower(t, fdb, tr, nil)
+	defer stop()
+	waitSync(t, f, pdb, fdb)
+	if s := f.Status(); s.Resyncs != 1 {
+		t.Fatalf("got %+v", s)
+	}
+}
+
+func TestReplicationLogical(t *testing.T) {
+	pdb := openDB(t, &opt.Options{WALRetentionSize: opt.MiB})
+	p, tr := startPrimary(t, pdb)
+	put(t, pdb, 0, 10, "v1")
+
+	// Seed the follower from a backup.
+	snap, err := pdb.GetSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var backup bytes.Buffer
+	if _, err := snap.ExportRocksDB(&backup, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkpoint := snap.Seq()
+	snap.Release()
+	fdb := openDB(t, nil)
+	if _, err := fdb.IngestRocksDB(bytes.NewReader(backup.Bytes()), int64(backup.Len())); err != nil {
+		t.Fatal(err)
+	}
+	fdb.Put([]byte("other"), []byte("x"), nil)
+
+	put(t, pdb, 5, 20, "v2")
+	f, stop := startFollower(t, fdb, tr, &FollowerOptions{Name: "logical", Logical: true, Checkpoint: checkpoint})
+	defer stop()
+	waitCaughtUp(t, f, pdb)
+	if s := f.Status(); s.Resyncs != 0 {
+		t.Fatalf("got %+v", s)
+	}
+	if ok, _ := fdb.Has([]byte("other"), nil); !ok {
+		t.Fatal("other data of the follower deleted")
+	}
+	if got, want := dump(t, fdb), dump(t, pdb); got != want+"\"other\"=\"x\"\n" {
+		t.Fatalf("follower content:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Acks.
+	want := seq(t, pdb)
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		fs := p.Followers()
+		if len(fs) == 1 && fs[0].Acked == want {
+			if fs[0].Name != "logical" || !fs[0].Logical {
+				t.Fatalf("got %+v", fs[0])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got followers %+v, want acked %d", fs, want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// A logical follower without checkpoint can't start.
+	f2 := NewFollower(openDB(t, nil), tr, &FollowerOptions{Logical: true})
+	if err := f2.Run(context.Background()); err != ErrHistoryTruncated {
+		t.Fatalf("logical follower without checkpoint: got %v", err)
+	}
+}
+
+func TestFollowerApply(t *testing.T) {
+	db := openDB(t, nil)
+	f := NewFollower(db, nil, &FollowerOptions{Checkpoint: 10})
+	if err := f.loadSeq(); err != nil {
+		t.Fatal(err)
+	}
+	b := new(leveldb.Batch)
+	b.Put([]byte("a"), []byte("1"))
+	b.Put([]byte("b"), []byte("2"))
+	if err := f.apply(11, b.Dump()); err != nil || f.seq != 12 {
+		t.Fatalf("got seq %d, %v", f.seq, err)
+	}
+	// Applied exactly once.
+	db.Delete([]byte("a"), nil)
+	if err := f.apply(11, b.Dump()); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := db.Has([]byte("a"), nil); ok {
+		t.Fatal("batch applied again")
+	}
+	if _, ok := f.apply(14, b.Dump()).(protocolError); !ok {
+		t.Fatal("batch after a gap applied")
+	}
+
+	f = NewFollower(db, nil, &FollowerOptions{Checkpoint: 10})
+	if err := f.loadSeq(); err != nil || f.seq != 12 {
+		t.Fatalf("reloaded seq %d, %v", f.seq, err)
+	}
+}
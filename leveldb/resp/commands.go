@@ -0,0 +1,586 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package resp
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Executes a command and writes its reply, returning whether the client
+// quits.
+func (c *client) execute(args [][]byte) (quit bool) {
+	name := strings.ToLower(string(args[0]))
+	args = args[1:]
+	if !c.authed && name != "auth" && name != "ping" && name != "quit" {
+		c.writeError("NOAUTH Authentication required.")
+		return false
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		c.writeError("ERR unknown command '" + name + "'")
+		return false
+	}
+	if len(args) < cmd.minArgs || (cmd.maxArgs >= 0 && len(args) > cmd.maxArgs) {
+		c.writeError("ERR wrong number of arguments for '" + name + "' command")
+		return false
+	}
+	if err := cmd.run(c, args); err != nil {
+		c.writeError("ERR " + err.Error())
+	}
+	return name == "quit"
+}
+
+type command struct {
+	// Number of arguments, excluding the command name; maxArgs is -1 if
+	// unbounded.
+	minArgs, maxArgs int
+	run              func(c *client, args [][]byte) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"ping":    {0, 1, (*client).ping},
+		"echo":    {1, 1, (*client).echo},
+		"quit":    {0, 0, (*client).quit},
+		"auth":    {1, 2, (*client).auth},
+		"select":  {1, 1, (*client).selectDB},
+		"command": {0, -1, (*client).command},
+		"get":     {1, 1, (*client).get},
+		"set":     {2, -1, (*client).set},
+		"del":     {1, -1, (*client).del},
+		"exists":  {1, -1, (*client).exists},
+		"expire":  {2, 2, (*client).expire},
+		"pexpire": {2, 2, (*client).pexpire},
+		"ttl":     {1, 1, (*client).ttl},
+		"pttl":    {1, 1, (*client).pttl},
+		"persist": {1, 1, (*client).persist},
+		"scan":    {1, -1, (*client).scan},
+	}
+}
+
+type syntaxError struct{}
+
+func (syntaxError) Error() string { return "syntax error" }
+
+type notIntegerError struct{}
+
+func (notIntegerError) Error() string { return "value is not an integer or out of range" }
+
+func parseInt(b []byte) (int64, error) {
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return 0, notIntegerError{}
+	}
+	return n, nil
+}
+
+func (c *client) ping(args [][]byte) error {
+	if len(args) == 1 {
+		c.writeBulk(args[0])
+	} else {
+		c.writeSimple("PONG")
+	}
+	return nil
+}
+
+func (c *client) echo(args [][]byte) error {
+	c.writeBulk(args[0])
+	return nil
+}
+
+func (c *client) quit(args [][]byte) error {
+	c.writeSimple("OK")
+	return nil
+}
+
+func (c *client) auth(args [][]byte) error {
+	password := args[len(args)-1]
+	if c.s.password == "" {
+		c.writeError("ERR AUTH <password> called without any password configured for the default user.")
+		return nil
+	}
+	if len(args) == 2 && string(args[0]) != "default" ||
+		subtle.ConstantTimeCompare(password, []byte(c.s.password)) != 1 {
+		c.writeError("WRONGPASS invalid username-password pair or user is disabled.")
+		return nil
+	}
+	c.authed = true
+	c.writeSimple("OK")
+	return nil
+}
+
+func (c *client) selectDB(args [][]byte) error {
+	if string(args[0]) != "0" {
+		c.writeError("ERR DB index is out of range")
+		return nil
+	}
+	c.writeSimple("OK")
+	return nil
+}
+
+// COMMAND is called by redis-cli on connect; there are no command docs.
+func (c *client) command(args [][]byte) error {
+	c.writeArrayLen(0)
+	return nil
+}
+
+func (c *client) get(args [][]byte) error {
+	value, _, err := c.s.lookup(args[0])
+	if err != nil {
+		return err
+	}
+	c.writeBulk(value)
+	return nil
+}
+
+func (c *client) set(args [][]byte) error {
+	key, value := args[0], args[1]
+	var (
+		ttl            time.Duration
+		nx, xx, keep   bool
+		haveExpiration bool
+	)
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToUpper(string(args[i])); opt {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "KEEPTTL":
+			keep = true
+		case "EX", "PX":
+			if i+1 == len(args) || haveExpiration {
+				return syntaxError{}
+			}
+			i++
+			n, err := parseInt(args[i])
+			if err != nil {
+				return err
+			}
+			if n <= 0 {
+				c.writeError("ERR invalid expire time in 'set' command")
+				return nil
+			}
+			ttl = time.Duration(n) * time.Millisecond
+			if opt == "EX" {
+				ttl = time.Duration(n) * time.Second
+			}
+			haveExpiration = true
+		default:
+			return syntaxError{}
+		}
+	}
+	if nx && xx || keep && haveExpiration {
+		return syntaxError{}
+	}
+	if c.s.reserved(key) {
+		c.writeError("ERR key is reserved")
+		return nil
+	}
+
+	s := c.s
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if nx || xx {
+		old, _, err := s.get(key)
+		if err != nil {
+			return err
+		}
+		if nx && old != nil || xx && old == nil {
+			c.writeBulk(nil)
+			return nil
+		}
+	}
+	b := new(leveldb.Batch)
+	b.Put(key, value)
+	switch {
+	case haveExpiration:
+		b.Put(s.ttlKey(key), encodeDeadline(deadline(ttl)))
+	case !keep:
+		b.Delete(s.ttlKey(key))
+	}
+	if err := s.db.Write(b, nil); err != nil {
+		return err
+	}
+	c.writeSimple("OK")
+	return nil
+}
+
+func (c *client) del(args [][]byte) error {
+	s := c.s
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	var n int64
+	b := new(leveldb.Batch)
+	for _, key := range args {
+		if s.reserved(key) {
+			continue
+		}
+		value, _, err := s.get(key)
+		if err != nil {
+			return err
+		}
+		if value != nil {
+			n++
+		}
+		b.Delete(key)
+		b.Delete(s.ttlKey(key))
+	}
+	if err := s.db.Write(b, nil); err != nil {
+		return err
+	}
+	c.writeInt(n)
+	return nil
+}
+
+func (c *client) exists(args [][]byte) error {
+	var n int64
+	for _, key := range args {
+		value, _, err := c.s.lookup(key)
+		if err != nil {
+			return err
+		}
+		if value != nil {
+			n++
+		}
+	}
+	c.writeInt(n)
+	return nil
+}
+
+func (c *client) expire(args [][]byte) error {
+	return c.expireIn(args, time.Second)
+}
+
+func (c *client) pexpire(args [][]byte) error {
+	return c.expireIn(args, time.Millisecond)
+}
+
+// Sets the TTL of a key to the given number of units.
+func (c *client) expireIn(args [][]byte, unit time.Duration) error {
+	key := args[0]
+	n, err := parseInt(args[1])
+	if err != nil {
+		return err
+	}
+	ttl := time.Duration(n) * unit
+
+	s := c.s
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	value, _, err := s.get(key)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		c.writeInt(0)
+		return nil
+	}
+	b := new(leveldb.Batch)
+	if ttl <= 0 {
+		b.Delete(key)
+		b.Delete(s.ttlKey(key))
+	} else {
+		b.Put(s.ttlKey(key), encodeDeadline(deadline(ttl)))
+	}
+	if err := s.db.Write(b, nil); err != nil {
+		return err
+	}
+	c.writeInt(1)
+	return nil
+}
+
+func (c *client) ttl(args [][]byte) error {
+	return c.ttlIn(args, time.Second)
+}
+
+func (c *client) pttl(args [][]byte) error {
+	return c.ttlIn(args, time.Millisecond)
+}
+
+// Replies the TTL of a key in the given unit, rounded.
+func (c *client) ttlIn(args [][]byte, unit time.Duration) error {
+	value, dl, err := c.s.lookup(args[0])
+	if err != nil {
+		return err
+	}
+	switch {
+	case value == nil:
+		c.writeInt(-2)
+	case dl == 0:
+		c.writeInt(-1)
+	default:
+		ms := dl - time.Now().UnixMilli()
+		if ms < 0 {
+			ms = 0
+		}
+		u := unit.Milliseconds()
+		c.writeInt((ms + u/2) / u)
+	}
+	return nil
+}
+
+func (c *client) persist(args [][]byte) error {
+	s := c.s
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	value, dl, err := s.get(args[0])
+	if err != nil {
+		return err
+	}
+	if value == nil || dl == 0 {
+		c.writeInt(0)
+		return nil
+	}
+	if err := s.db.Delete(s.ttlKey(args[0]), nil); err != nil {
+		return err
+	}
+	c.writeInt(1)
+	return nil
+}
+
+func (c *client) scan(args [][]byte) error {
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		c.writeError("ERR invalid cursor")
+		return nil
+	}
+	var (
+		pattern []byte
+		count   = 10
+	)
+	for i := 1; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			return syntaxError{}
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			n, err := parseInt(args[i+1])
+			if err != nil {
+				return err
+			}
+			if n < 1 {
+				return syntaxError{}
+			}
+			count = int(n)
+		case "TYPE":
+			if !strings.EqualFold(string(args[i+1]), "string") {
+				// There are only strings.
+				count = 0
+			}
+		default:
+			return syntaxError{}
+		}
+	}
+
+	s := c.s
+	var start []byte
+	if cursor != 0 {
+		var ok bool
+		if start, ok = s.takeCursor(cursor); !ok {
+			c.writeError("ERR invalid cursor")
+			return nil
+		}
+	}
+	iter := s.db.NewIterator(&util.Range{Start: start}, nil)
+	defer iter.Release()
+	var keys [][]byte
+	next := uint64(0)
+	ok := iter.Next()
+	for examined := 0; ok; ok = iter.Next() {
+		if examined == count && count > 0 {
+			next = s.newCursor(append([]byte(nil), iter.Key()...))
+			break
+		}
+		if count == 0 {
+			break
+		}
+		key := iter.Key()
+		if s.reserved(key) {
+			ok = iter.Seek(util.BytesPrefix(s.ttlPrefix).Limit)
+			if !ok {
+				break
+			}
+			key = iter.Key()
+		}
+		examined++
+		if pattern != nil && !match(pattern, key) {
+			continue
+		}
+		dl, err := s.deadline(key)
+		if err != nil {
+			return err
+		}
+		if dl != 0 && dl <= time.Now().UnixMilli() {
+			continue
+		}
+		keys = append(keys, append([]byte(nil), key...))
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	c.writeArrayLen(2)
+	c.writeBulk([]byte(strconv.FormatUint(next, 10)))
+	c.writeArrayLen(len(keys))
+	for _, key := range keys {
+		c.writeBulk(key)
+	}
+	return nil
+}
+
+func (s *Server) reserved(key []byte) bool {
+	return bytes.HasPrefix(key, s.ttlPrefix)
+}
+
+func (s *Server) ttlKey(key []byte) []byte {
+	return append(append([]byte(nil), s.ttlPrefix...), key...)
+}
+
+func encodeDeadline(dl int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(dl))
+}
+
+// Returns the deadline of a key, zero if it has none. A malformed deadline
+// is ignored.
+func (s *Server) deadline(key []byte) (dl int64, err error) {
+	v, err := s.db.Get(s.ttlKey(key), nil)
+	if err == leveldb.ErrNotFound || err == nil && len(v) != 8 {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(v)), nil
+}
+
+// Returns the value of a key and its deadline, or a nil value if the key
+// doesn't exist or has expired.
+func (s *Server) get(key []byte) (value []byte, dl int64, err error) {
+	if s.reserved(key) {
+		return nil, 0, nil
+	}
+	value, err = s.db.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	if dl, err = s.deadline(key); err != nil {
+		return nil, 0, err
+	}
+	if dl != 0 && dl <= time.Now().UnixMilli() {
+		return nil, dl, nil
+	}
+	return value, dl, nil
+}
+
+// As get, deleting the key if it has expired.
+func (s *Server) lookup(key []byte) (value []byte, dl int64, err error) {
+	value, dl, err = s.get(key)
+	if err != nil || value != nil || dl == 0 {
+		return
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	// It may have been set again meanwhile.
+	if value, dl, err = s.get(key); err != nil || value != nil || dl == 0 {
+		return
+	}
+	b := new(leveldb.Batch)
+	b.Delete(key)
+	b.Delete(s.ttlKey(key))
+	return nil, 0, s.db.Write(b, nil)
+}
+
+// Reports whether the key matches the Redis glob-style pattern, supporting
+// *, ?, [...] with ranges and ^ negation, and \ escapes.
+func match(pattern, key []byte) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(key); i++ {
+				if match(pattern[1:], key[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(key) == 0 {
+				return false
+			}
+		case '[':
+			if len(key) == 0 {
+				return false
+			}
+			p := pattern[1:]
+			not := len(p) > 0 && p[0] == '^'
+			if not {
+				p = p[1:]
+			}
+			matched := false
+			for len(p) > 0 && p[0] != ']' {
+				switch {
+				case p[0] == '\\' && len(p) > 1:
+					matched = matched || p[1] == key[0]
+					p = p[2:]
+				case len(p) > 2 && p[1] == '-' && p[2] != ']':
+					lo, hi := p[0], p[2]
+					if lo > hi {
+						lo, hi = hi, lo
+					}
+					matched = matched || lo <= key[0] && key[0] <= hi
+					p = p[3:]
+				default:
+					matched = matched || p[0] == key[0]
+					p = p[1:]
+				}
+			}
+			if matched == not {
+				return false
+			}
+			if len(p) > 0 {
+				p = p[1:] // ']'
+			}
+			pattern, key = p, key[1:]
+			continue
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(key) == 0 || pattern[0] != key[0] {
+				return false
+			}
+		}
+		pattern, key = pattern[1:], key[1:]
+	}
+	return len(key) == 0
+}
@@ -0,0 +1,337 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package resp serves a subset of the Redis protocol (RESP) on top of a DB,
+// so that Redis clients and tools can be pointed at an embedded store:
+//
+//	l, err := net.Listen("tcp", "127.0.0.1:6379")
+//	...
+//	go resp.NewServer(db, nil).Serve(l)
+//
+// The supported commands are GET, SET (with EX, PX, NX, XX and KEEPTTL),
+// DEL, EXISTS, EXPIRE, PEXPIRE, TTL, PTTL, PERSIST, SCAN (with MATCH and
+// COUNT), PING, ECHO, SELECT 0, AUTH and QUIT. Keys and values are stored
+// as is, so the DB may be shared with other code.
+//
+// The DB has no native expiry, so the deadlines of keys are stored under
+// Options.TTLPrefix, and expired keys are deleted when they are accessed.
+// An encrypted DB is served decrypted; use Options.Password, and a
+// listener on a trusted network or wrapped with TLS.
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// Options holds the optional parameters of a Server.
+type Options struct {
+	// TTLPrefix is the prefix of the keys holding the expiry deadlines.
+	// Keys starting with it are hidden from the clients.
+	//
+	// The default is "\xff\xffresp.ttl/".
+	TTLPrefix []byte
+
+	// Password, if set, must be given by the AUTH command before any
+	// command other than PING and QUIT.
+	Password string
+
+	// MaxBulkLen is the largest bulk string accepted from a client.
+	//
+	// The default is 512MiB, as Redis.
+	MaxBulkLen int
+}
+
+// DefaultTTLPrefix is the default Options.TTLPrefix.
+var DefaultTTLPrefix = []byte("\xff\xffresp.ttl/")
+
+// Number of SCAN cursors kept; older ones are dropped.
+const maxCursors = 1024
+
+// Server serves the Redis protocol on top of a DB. It's safe for
+// concurrent use.
+type Server struct {
+	db         *leveldb.DB
+	ttlPrefix  []byte
+	password   string
+	maxBulkLen int
+
+	// Serializes the writes, so that deleting an expired key doesn't race
+	// with setting it again.
+	writeMu sync.Mutex
+
+	mu         sync.Mutex
+	closed     bool
+	listeners  map[net.Listener]struct{}
+	conns      map[net.Conn]struct{}
+	cursors    map[uint64][]byte // SCAN cursor to the key to resume at.
+	nextCursor uint64
+}
+
+// NewServer returns a Server for the given DB. The options may be nil.
+func NewServer(db *leveldb.DB, o *Options) *Server {
+	s := &Server{
+		db:         db,
+		ttlPrefix:  DefaultTTLPrefix,
+		maxBulkLen: 512 << 20,
+		listeners:  make(map[net.Listener]struct{}),
+		conns:      make(map[net.Conn]struct{}),
+		cursors:    make(map[uint64][]byte),
+		nextCursor: 1,
+	}
+	if o != nil {
+		if len(o.TTLPrefix) > 0 {
+			s.ttlPrefix = o.TTLPrefix
+		}
+		s.password = o.Password
+		if o.MaxBulkLen > 0 {
+			s.maxBulkLen = o.MaxBulkLen
+		}
+	}
+	return s
+}
+
+// ErrServerClosed is returned by Serve after Close.
+var ErrServerClosed = errors.New("resp: server closed")
+
+// Serve accepts the connections of the listener, serving each in its own
+// goroutine, until the listener fails or the server is closed. It closes
+// the listener.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves a single connection until the client quits or the
+// connection fails, and closes it.
+func (s *Server) ServeConn(conn net.Conn) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.conns[conn] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	c := &client{
+		s:      s,
+		r:      bufio.NewReader(conn),
+		w:      bufio.NewWriter(conn),
+		authed: s.password == "",
+	}
+	for {
+		args, err := c.readCommand()
+		if err != nil {
+			if err != io.EOF {
+				var perr protocolError
+				if errors.As(err, &perr) {
+					c.writeError("ERR Protocol error: " + string(perr))
+					c.w.Flush()
+				}
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		quit := c.execute(args)
+		// Pipelined commands are answered together.
+		if c.r.Buffered() == 0 || quit {
+			if err := c.w.Flush(); err != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// Close closes the listeners and the connections. It doesn't close the DB.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return nil
+}
+
+// Returns a new SCAN cursor resuming at the given key.
+func (s *Server) newCursor(key []byte) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.cursors) >= maxCursors {
+		// Cursors are increasing, drop the oldest.
+		oldest := s.nextCursor
+		for c := range s.cursors {
+			if c < oldest {
+				oldest = c
+			}
+		}
+		delete(s.cursors, oldest)
+	}
+	c := s.nextCursor
+	s.nextCursor++
+	s.cursors[c] = key
+	return c
+}
+
+// Returns the key to resume the SCAN at, and forgets the cursor.
+func (s *Server) takeCursor(c uint64) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.cursors[c]
+	delete(s.cursors, c)
+	return key, ok
+}
+
+type protocolError string
+
+func (e protocolError) Error() string {
+	return "resp: protocol error: " + string(e)
+}
+
+// client is the state of a connection.
+type client struct {
+	s      *Server
+	r      *bufio.Reader
+	w      *bufio.Writer
+	authed bool
+}
+
+// Reads a line, without its CRLF.
+func (c *client) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && line != "" {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return strings.TrimSuffix(line[:len(line)-1], "\r"), nil
+}
+
+// Reads a command, either an array of bulk strings or an inline command.
+func (c *client) readCommand() ([][]byte, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		var args [][]byte
+		for _, f := range strings.Fields(line) {
+			args = append(args, []byte(f))
+		}
+		return args, nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > 1<<20 {
+		return nil, protocolError("invalid multibulk length")
+	}
+	args := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, protocolError("expected '$', got '" + line + "'")
+		}
+		l, err := strconv.Atoi(line[1:])
+		if err != nil || l < 0 || l > c.s.maxBulkLen {
+			return nil, protocolError("invalid bulk length")
+		}
+		arg := make([]byte, l+2)
+		if _, err := io.ReadFull(c.r, arg); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if arg[l] != '\r' || arg[l+1] != '\n' {
+			return nil, protocolError("bulk string not terminated by CRLF")
+		}
+		args = append(args, arg[:l])
+	}
+	return args, nil
+}
+
+func (c *client) writeSimple(s string) {
+	c.w.WriteString("+" + s + "\r\n")
+}
+
+func (c *client) writeError(s string) {
+	c.w.WriteString("-" + s + "\r\n")
+}
+
+func (c *client) writeInt(n int64) {
+	c.w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (c *client) writeBulk(b []byte) {
+	if b == nil {
+		c.w.WriteString("$-1\r\n")
+		return
+	}
+	c.w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	c.w.Write(b)
+	c.w.WriteString("\r\n")
+}
+
+func (c *client) writeArrayLen(n int) {
+	c.w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+// Deadline of a key, in Unix milliseconds, as stored under the TTL prefix.
+func deadline(ttl time.Duration) int64 {
+	return time.Now().Add(ttl).UnixMilli()
+}
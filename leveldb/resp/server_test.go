@@ -0,0 +1,255 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package resp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// Sends a command and returns its reply, formatted as
+// "+simple", "-error", ":int", "$bulk", "nil", or "[a b ...]" for arrays.
+func (c *testClient) do(args ...string) string {
+	c.t.Helper()
+	cmd := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		cmd += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c.conn, cmd); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.reply()
+}
+
+func (c *testClient) reply() string {
+	c.t.Helper()
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return "nil"
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			c.t.Fatal(err)
+		}
+		return "$" + string(b[:n])
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		elems := make([]string, n)
+		for i := range elems {
+			elems[i] = c.reply()
+		}
+		return "[" + strings.Join(elems, " ") + "]"
+	}
+	return line
+}
+
+func newTestServer(t *testing.T, o *Options) (*leveldb.DB, func() *testClient) {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(db, o)
+	done := make(chan error)
+	go func() { done <- s.Serve(l) }()
+	t.Cleanup(func() {
+		s.Close()
+		if err := <-done; err != ErrServerClosed {
+			t.Errorf("Serve: got %v", err)
+		}
+		db.Close()
+	})
+	return db, func() *testClient {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return &testClient{t, conn, bufio.NewReader(conn)}
+	}
+}
+
+func TestServer(t *testing.T) {
+	db, dial := newTestServer(t, nil)
+	c := dial()
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"PING"}, "+PONG"},
+		{[]string{"get", "a"}, "nil"},
+		{[]string{"set", "a", "1"}, "+OK"},
+		{[]string{"GET", "a"}, "$1"},
+		{[]string{"set", "a", "2", "NX"}, "nil"},
+		{[]string{"set", "b", "2", "XX"}, "nil"},
+		{[]string{"set", "b", ""}, "+OK"},
+		{[]string{"get", "b"}, "$"},
+		{[]string{"exists", "a", "b", "c"}, ":2"},
+		{[]string{"ttl", "a"}, ":-1"},
+		{[]string{"ttl", "c"}, ":-2"},
+		{[]string{"expire", "a", "100"}, ":1"},
+		{[]string{"ttl", "a"}, ":100"},
+		{[]string{"expire", "c", "100"}, ":0"},
+		{[]string{"set", "a", "3", "KEEPTTL"}, "+OK"},
+		{[]string{"ttl", "a"}, ":100"},
+		{[]string{"persist", "a"}, ":1"},
+		{[]string{"persist", "a"}, ":0"},
+		{[]string{"set", "c", "3", "EX", "100"}, "+OK"},
+		{[]string{"set", "c", "3"}, "+OK"},
+		{[]string{"ttl", "c"}, ":-1"},
+		{[]string{"del", "c", "d"}, ":1"},
+		{[]string{"set", "a", "1", "EX", "0"}, "-ERR invalid expire time in 'set' command"},
+		{[]string{"set", "a", "1", "NX", "XX"}, "-ERR syntax error"},
+		{[]string{"get"}, "-ERR wrong number of arguments for 'get' command"},
+		{[]string{"expire", "a", "x"}, "-ERR value is not an integer or out of range"},
+		{[]string{"flushall"}, "-ERR unknown command 'flushall'"},
+		{[]string{"select", "1"}, "-ERR DB index is out of range"},
+	} {
+		if got := c.do(tc.args...); got != tc.want {
+			t.Errorf("%q: got %q, want %q", tc.args, got, tc.want)
+		}
+	}
+
+	// Expiry.
+	c.do("set", "e", "1", "PX", "1")
+	c.do("set", "f", "1")
+	c.do("pexpire", "f", "1")
+	time.Sleep(5 * time.Millisecond)
+	if got := c.do("get", "e"); got != "nil" {
+		t.Errorf("get of expired key: got %q", got)
+	}
+	if got := c.do("exists", "f"); got != ":0" {
+		t.Errorf("exists of expired key: got %q", got)
+	}
+	if ok, _ := db.Has([]byte("e"), nil); ok {
+		t.Error("expired key not deleted")
+	}
+	if ok, _ := db.Has(append(DefaultTTLPrefix, 'e'), nil); ok {
+		t.Error("deadline of expired key not deleted")
+	}
+
+	// Inline commands and pipelining.
+	io.WriteString(c.conn, "ECHO hello\r\nPING\r\n")
+	if got := c.reply() + " " + c.reply(); got != "$hello +PONG" {
+		t.Errorf("inline commands: got %q", got)
+	}
+
+	if got := c.do("quit"); got != "+OK" {
+		t.Errorf("quit: got %q", got)
+	}
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Errorf("connection not closed after quit: %v", err)
+	}
+}
+
+func TestServerScan(t *testing.T) {
+	_, dial := newTestServer(t, nil)
+	c := dial()
+	for i := 0; i < 25; i++ {
+		c.do("set", fmt.Sprintf("k%02d", i), "v")
+	}
+	c.do("set", "other", "v", "EX", "100")
+	c.do("set", "expired", "v", "PX", "1")
+	time.Sleep(5 * time.Millisecond)
+
+	var keys []string
+	cursor := "0"
+	for i := 0; ; i++ {
+		if i > 10 {
+			t.Fatal("scan didn't terminate")
+		}
+		reply := c.do("scan", cursor, "COUNT", "10")
+		fields := strings.Fields(strings.Trim(reply, "[]"))
+		cursor = strings.TrimPrefix(fields[0], "$")
+		for _, f := range fields[1:] {
+			keys = append(keys, strings.Trim(f, "[]$"))
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	if len(keys) != 26 || keys[0] != "k00" || keys[25] != "other" {
+		t.Errorf("scan: got %q", keys)
+	}
+
+	if got := c.do("scan", "0", "MATCH", "k1[0-2]*", "COUNT", "100"); got != "[$0 [$k10 $k11 $k12]]" {
+		t.Errorf("scan with match: got %q", got)
+	}
+	if got := c.do("scan", "12345"); got != "-ERR invalid cursor" {
+		t.Errorf("scan with unknown cursor: got %q", got)
+	}
+}
+
+func TestServerAuth(t *testing.T) {
+	_, dial := newTestServer(t, &Options{Password: "secret"})
+	c := dial()
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"ping"}, "+PONG"},
+		{[]string{"get", "a"}, "-NOAUTH Authentication required."},
+		{[]string{"auth", "wrong"}, "-WRONGPASS invalid username-password pair or user is disabled."},
+		{[]string{"auth", "default", "secret"}, "+OK"},
+		{[]string{"get", "a"}, "nil"},
+	} {
+		if got := c.do(tc.args...); got != tc.want {
+			t.Errorf("%q: got %q, want %q", tc.args, got, tc.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, key string
+		want         bool
+	}{
+		{"*", "", true},
+		{"a*", "abc", true},
+		{"a*c", "abbc", true},
+		{"a*c", "abcd", false},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"[abc]x", "bx", true},
+		{"[^abc]x", "bx", false},
+		{"[a-c]", "b", true},
+		{"[a-c]", "d", false},
+		{`a\*`, "a*", true},
+		{`a\*`, "ab", false},
+		{"h[\\]]", "h]", true},
+	} {
+		if got := match([]byte(tc.pattern), []byte(tc.key)); got != tc.want {
+			t.Errorf("match(%q, %q): got %v", tc.pattern, tc.key, got)
+		}
+	}
+}
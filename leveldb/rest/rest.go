@@ -0,0 +1,407 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package rest provides an http.Handler exposing a DB as a REST API, to
+// wire it quickly into scripts and dashboards:
+//
+//	h := rest.NewHandler(db, &rest.Options{Auth: rest.BearerToken(token)})
+//	http.Handle("/db/", http.StripPrefix("/db", h))
+//
+// The endpoints are:
+//
+//	GET    /kv/{key}       the value of the key, 404 if not found
+//	HEAD   /kv/{key}       whether the key exists
+//	PUT    /kv/{key}       sets the value of the key to the request body
+//	DELETE /kv/{key}       deletes the key
+//	GET    /kv             a page of the entries, see below
+//	POST   /admin/compact  compacts the range given by start and limit
+//	GET    /admin/stats    the DBStats, as JSON
+//	POST   /admin/backup   exports the DB to Options.BackupDir
+//
+// Keys are taken from the path, unescaped, so binary keys must be
+// percent-encoded. Values are sent as is.
+//
+// GET /kv returns the entries in key order, as JSON:
+//
+//	{"entries":[{"key":"Zm9v","value":"YmFy"}],"next":"Zm9w"}
+//
+// Keys and values are base64-encoded, as with DB.Export. The range is given
+// by the start and limit parameters, or by prefix, and the size of the page
+// by count. If there are more entries, next is set and is passed as the
+// cursor parameter to get the next page. The keys_only parameter omits the
+// values.
+//
+// An encrypted DB is served decrypted; use Options.Auth, and TLS.
+package rest
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Access is the kind of access required by a request.
+type Access int
+
+const (
+	// AccessRead is required to get and scan keys.
+	AccessRead Access = iota
+	// AccessWrite is required to put and delete keys.
+	AccessWrite
+	// AccessAdmin is required by the admin endpoints.
+	AccessAdmin
+)
+
+func (a Access) String() string {
+	switch a {
+	case AccessRead:
+		return "read"
+	case AccessWrite:
+		return "write"
+	case AccessAdmin:
+		return "admin"
+	}
+	return fmt.Sprintf("Access(%d)", int(a))
+}
+
+// AuthFunc authorizes a request requiring the given access. It returns a
+// non-nil error to deny the request, which is answered with 401
+// Unauthorized, or 403 Forbidden if the error is ErrForbidden.
+type AuthFunc func(r *http.Request, a Access) error
+
+// ErrForbidden may be returned by an AuthFunc to deny a request from an
+// authenticated client.
+var ErrForbidden = errors.New("rest: forbidden")
+
+var errUnauthorized = errors.New("rest: unauthorized")
+
+// BearerToken returns an AuthFunc allowing any access to the requests
+// bearing the given token in their Authorization header.
+func BearerToken(token string) AuthFunc {
+	want := []byte("Bearer " + token)
+	return func(r *http.Request, a Access) error {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			return errUnauthorized
+		}
+		return nil
+	}
+}
+
+// Options holds the optional parameters of a Handler.
+type Options struct {
+	// Auth authorizes the requests. If nil, all requests are allowed.
+	Auth AuthFunc
+
+	// ReadOnly denies the writes, and the admin endpoints but stats.
+	ReadOnly bool
+
+	// BackupDir is the directory of the backups, written as JSON lines by
+	// DB.Export. If empty, the backup endpoint is disabled.
+	BackupDir string
+
+	// MaxValueSize is the largest value accepted by PUT.
+	//
+	// The default is 64MiB.
+	MaxValueSize int64
+
+	// MaxPageSize is the largest number of entries returned by GET /kv.
+	//
+	// The default is 1000.
+	MaxPageSize int
+}
+
+// Handler serves the REST API of a DB. It's safe for concurrent use.
+type Handler struct {
+	db           *leveldb.DB
+	auth         AuthFunc
+	readOnly     bool
+	backupDir    string
+	maxValueSize int64
+	maxPageSize  int
+	mux          *http.ServeMux
+
+	// Serializes the backups.
+	backupMu sync.Mutex
+}
+
+// NewHandler returns a Handler for the given DB. The options may be nil.
+func NewHandler(db *leveldb.DB, o *Options) *Handler {
+	h := &Handler{
+		db:           db,
+		maxValueSize: 64 << 20,
+		maxPageSize:  1000,
+		mux:          http.NewServeMux(),
+	}
+	if o != nil {
+		h.auth = o.Auth
+		h.readOnly = o.ReadOnly
+		h.backupDir = o.BackupDir
+		if o.MaxValueSize > 0 {
+			h.maxValueSize = o.MaxValueSize
+		}
+		if o.MaxPageSize > 0 {
+			h.maxPageSize = o.MaxPageSize
+		}
+	}
+	h.handle("GET /kv/{key...}", AccessRead, h.get)
+	h.handle("HEAD /kv/{key...}", AccessRead, h.head)
+	h.handle("PUT /kv/{key...}", AccessWrite, h.put)
+	h.handle("DELETE /kv/{key...}", AccessWrite, h.delete)
+	h.handle("GET /kv", AccessRead, h.scan)
+	h.handle("POST /admin/compact", AccessAdmin, h.compact)
+	h.handle("GET /admin/stats", AccessAdmin, h.stats)
+	h.handle("POST /admin/backup", AccessAdmin, h.backup)
+	return h
+}
+
+// ServeHTTP serves a request of the REST API.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) handle(pattern string, a Access, fn func(w http.ResponseWriter, r *http.Request) error) {
+	h.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		if h.auth != nil {
+			if err := h.auth(r, a); err != nil {
+				code := http.StatusUnauthorized
+				if err == ErrForbidden {
+					code = http.StatusForbidden
+				}
+				http.Error(w, err.Error(), code)
+				return
+			}
+		}
+		if h.readOnly && (a == AccessWrite || a == AccessAdmin && r.Method != http.MethodGet) {
+			http.Error(w, "rest: read-only", http.StatusForbidden)
+			return
+		}
+		if err := fn(w, r); err != nil {
+			writeError(w, err)
+		}
+	})
+}
+
+// badRequest is an error caused by the request.
+type badRequest string
+
+func (e badRequest) Error() string {
+	return "rest: " + string(e)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	var br badRequest
+	var mbe *http.MaxBytesError
+	switch {
+	case errors.As(err, &br):
+		code = http.StatusBadRequest
+	case errors.As(err, &mbe):
+		code = http.StatusRequestEntityTooLarge
+	case err == leveldb.ErrNotFound:
+		code = http.StatusNotFound
+	case err == leveldb.ErrClosed || err == leveldb.ErrReadOnly:
+		code = http.StatusServiceUnavailable
+	}
+	http.Error(w, err.Error(), code)
+}
+
+// Writes v as JSON. The error of the write is ignored, as the status is
+// already sent.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (h *Handler) get(w http.ResponseWriter, r *http.Request) error {
+	value, err := h.db.Get([]byte(r.PathValue("key")), nil)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(value)))
+	w.Write(value)
+	return nil
+}
+
+func (h *Handler) head(w http.ResponseWriter, r *http.Request) error {
+	ok, err := h.db.Has([]byte(r.PathValue("key")), nil)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+	}
+	return nil
+}
+
+func (h *Handler) put(w http.ResponseWriter, r *http.Request) error {
+	value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.maxValueSize))
+	if err != nil {
+		return err
+	}
+	if err := h.db.Put([]byte(r.PathValue("key")), value, nil); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h *Handler) delete(w http.ResponseWriter, r *http.Request) error {
+	if err := h.db.Delete([]byte(r.PathValue("key")), nil); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Returns the range given by the start and limit parameters, or by the
+// prefix parameter.
+func queryRange(r *http.Request) (*util.Range, error) {
+	q := r.URL.Query()
+	if q.Has("prefix") {
+		if q.Has("start") || q.Has("limit") {
+			return nil, badRequest("prefix excludes start and limit")
+		}
+		return util.BytesPrefix([]byte(q.Get("prefix"))), nil
+	}
+	slice := new(util.Range)
+	if q.Has("start") {
+		slice.Start = []byte(q.Get("start"))
+	}
+	if q.Has("limit") {
+		slice.Limit = []byte(q.Get("limit"))
+	}
+	return slice, nil
+}
+
+type entry struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value,omitempty"`
+}
+
+type page struct {
+	Entries []entry `json:"entries"`
+	Next    []byte  `json:"next,omitempty"`
+}
+
+func (h *Handler) scan(w http.ResponseWriter, r *http.Request) error {
+	slice, err := queryRange(r)
+	if err != nil {
+		return err
+	}
+	q := r.URL.Query()
+	count := h.maxPageSize
+	if s := q.Get("count"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return badRequest("invalid count")
+		}
+		if n < count {
+			count = n
+		}
+	}
+	keysOnly := q.Has("keys_only")
+
+	iter := h.db.NewIterator(slice, nil)
+	defer iter.Release()
+	ok := iter.First()
+	if s := q.Get("cursor"); s != "" {
+		cursor, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return badRequest("invalid cursor")
+		}
+		ok = iter.Seek(cursor)
+	}
+	p := page{Entries: []entry{}}
+	for ; ok && len(p.Entries) < count; ok = iter.Next() {
+		e := entry{Key: append([]byte{}, iter.Key()...)}
+		if !keysOnly {
+			e.Value = append([]byte{}, iter.Value()...)
+		}
+		p.Entries = append(p.Entries, e)
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	if ok {
+		p.Next = append([]byte{}, iter.Key()...)
+	}
+	writeJSON(w, http.StatusOK, p)
+	return nil
+}
+
+func (h *Handler) compact(w http.ResponseWriter, r *http.Request) error {
+	slice, err := queryRange(r)
+	if err != nil {
+		return err
+	}
+	if err := h.db.CompactRange(*slice); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h *Handler) stats(w http.ResponseWriter, r *http.Request) error {
+	var stats leveldb.DBStats
+	if err := h.db.Stats(&stats); err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, &stats)
+	return nil
+}
+
+type backupResult struct {
+	File    string `json:"file"`
+	Entries int    `json:"entries"`
+}
+
+func (h *Handler) backup(w http.ResponseWriter, r *http.Request) error {
+	if h.backupDir == "" {
+		http.Error(w, "rest: backups disabled", http.StatusNotFound)
+		return nil
+	}
+	h.backupMu.Lock()
+	defer h.backupMu.Unlock()
+
+	name := "backup-" + time.Now().UTC().Format("20060102T150405.000") + ".jsonl"
+	path := filepath.Join(h.backupDir, name)
+	// Written under a temporary name, so that a backup is only seen once
+	// complete.
+	f, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	n, err := h.db.Export(f, leveldb.ExportJSONL, nil)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	writeJSON(w, http.StatusCreated, backupResult{File: name, Entries: n})
+	return nil
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package rest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func newTestHandler(t *testing.T, o *Options) (*leveldb.DB, *Handler) {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, NewHandler(db, o)
+}
+
+func do(t *testing.T, h http.Handler, method, target, body string, header ...string) (int, string) {
+	t.Helper()
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, r)
+	for i := 0; i < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code, rec.Body.String()
+}
+
+func TestHandlerKV(t *testing.T) {
+	db, h := newTestHandler(t, nil)
+
+	if code, _ := do(t, h, "PUT", "/kv/foo/bar", "v1"); code != 204 {
+		t.Fatalf("PUT: got status %d", code)
+	}
+	if v, err := db.Get([]byte("foo/bar"), nil); err != nil || string(v) != "v1" {
+		t.Fatalf("db: got %q, %v", v, err)
+	}
+	if code, body := do(t, h, "GET", "/kv/foo/bar", ""); code != 200 || body != "v1" {
+		t.Fatalf("GET: got %d %q", code, body)
+	}
+	if code, _ := do(t, h, "PUT", "/kv/%00%FF", "bin"); code != 204 {
+		t.Fatalf("PUT binary: got status %d", code)
+	}
+	if v, err := db.Get([]byte("\x00\xff"), nil); err != nil || string(v) != "bin" {
+		t.Fatalf("db: got %q, %v", v, err)
+	}
+	if code, _ := do(t, h, "HEAD", "/kv/foo/bar", ""); code != 200 {
+		t.Fatalf("HEAD: got status %d", code)
+	}
+	if code, _ := do(t, h, "DELETE", "/kv/foo/bar", ""); code != 204 {
+		t.Fatalf("DELETE: got status %d", code)
+	}
+	if code, _ := do(t, h, "GET", "/kv/foo/bar", ""); code != 404 {
+		t.Fatalf("GET deleted: got status %d", code)
+	}
+	if code, _ := do(t, h, "HEAD", "/kv/foo/bar", ""); code != 404 {
+		t.Fatalf("HEAD deleted: got status %d", code)
+	}
+}
+
+func TestHandlerScan(t *testing.T) {
+	db, h := newTestHandler(t, nil)
+	for _, key := range []string{"a", "b1", "b2", "b3", "c"} {
+		db.Put([]byte(key), []byte("v"+key), nil)
+	}
+
+	scan := func(query string) page {
+		t.Helper()
+		code, body := do(t, h, "GET", "/kv?"+query, "")
+		if code != 200 {
+			t.Fatalf("GET /kv?%s: got %d %q", query, code, body)
+		}
+		var p page
+		if err := json.Unmarshal([]byte(body), &p); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	keys := func(p page) (s []string) {
+		for _, e := range p.Entries {
+			s = append(s, string(e.Key))
+		}
+		return
+	}
+
+	p := scan("prefix=b&count=2")
+	if got := strings.Join(keys(p), ","); got != "b1,b2" || string(p.Next) != "b3" {
+		t.Fatalf("got %s, next %q", got, p.Next)
+	}
+	if string(p.Entries[0].Value) != "vb1" {
+		t.Fatalf("got value %q", p.Entries[0].Value)
+	}
+	cursor := url.QueryEscape(base64.StdEncoding.EncodeToString(p.Next))
+	p = scan("prefix=b&count=2&cursor=" + cursor)
+	if got := strings.Join(keys(p), ","); got != "b3" || p.Next != nil {
+		t.Fatalf("got %s, next %q", got, p.Next)
+	}
+	p = scan("start=b2&keys_only")
+	if got := strings.Join(keys(p), ","); got != "b2,b3,c" || p.Entries[0].Value != nil {
+		t.Fatalf("got %s, value %q", got, p.Entries[0].Value)
+	}
+	if code, _ := do(t, h, "GET", "/kv?prefix=b&start=a", ""); code != 400 {
+		t.Fatalf("got status %d", code)
+	}
+	if code, _ := do(t, h, "GET", "/kv?count=x", ""); code != 400 {
+		t.Fatalf("got status %d", code)
+	}
+}
+
+func TestHandlerAdmin(t *testing.T) {
+	dir := t.TempDir()
+	db, h := newTestHandler(t, &Options{BackupDir: dir})
+	db.Put([]byte("foo"), []byte("bar"), nil)
+
+	if code, _ := do(t, h, "POST", "/admin/compact", ""); code != 204 {
+		t.Fatalf("compact: got status %d", code)
+	}
+	code, body := do(t, h, "GET", "/admin/stats", "")
+	var stats leveldb.DBStats
+	if code != 200 || json.Unmarshal([]byte(body), &stats) != nil {
+		t.Fatalf("stats: got %d %q", code, body)
+	}
+
+	code, body = do(t, h, "POST", "/admin/backup", "")
+	var res backupResult
+	if code != 201 || json.Unmarshal([]byte(body), &res) != nil || res.Entries != 1 {
+		t.Fatalf("backup: got %d %q", code, body)
+	}
+	f, err := os.Open(filepath.Join(dir, res.File))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	db2, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db2.Close()
+	if _, err := db2.Import(f, leveldb.ExportJSONL); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := db2.Get([]byte("foo"), nil); err != nil || string(v) != "bar" {
+		t.Fatalf("restored: got %q, %v", v, err)
+	}
+
+	_, h = newTestHandler(t, nil)
+	if code, _ := do(t, h, "POST", "/admin/backup", ""); code != 404 {
+		t.Fatalf("backup disabled: got status %d", code)
+	}
+}
+
+func TestHandlerAuth(t *testing.T) {
+	token := BearerToken("secret")
+	_, h := newTestHandler(t, &Options{
+		Auth: func(r *http.Request, a Access) error {
+			if err := token(r, a); err != nil {
+				return err
+			}
+			if a == AccessAdmin {
+				return ErrForbidden
+			}
+			return nil
+		},
+	})
+	if code, _ := do(t, h, "PUT", "/kv/foo", "bar"); code != 401 {
+		t.Fatalf("no token: got status %d", code)
+	}
+	if code, _ := do(t, h, "PUT", "/kv/foo", "bar", "Authorization", "Bearer wrong"); code != 401 {
+		t.Fatalf("wrong token: got status %d", code)
+	}
+	if code, _ := do(t, h, "PUT", "/kv/foo", "bar", "Authorization", "Bearer secret"); code != 204 {
+		t.Fatalf("token: got status %d", code)
+	}
+	if code, _ := do(t, h, "GET", "/admin/stats", "", "Authorization", "Bearer secret"); code != 403 {
+		t.Fatalf("admin: got status %d", code)
+	}
+
+	_, h = newTestHandler(t, &Options{ReadOnly: true})
+	if code, _ := do(t, h, "PUT", "/kv/foo", "bar"); code != 403 {
+		t.Fatalf("read-only PUT: got status %d", code)
+	}
+	if code, _ := do(t, h, "POST", "/admin/compact", ""); code != 403 {
+		t.Fatalf("read-only compact: got status %d", code)
+	}
+	if code, _ := do(t, h, "GET", "/admin/stats", ""); code != 200 {
+		t.Fatalf("read-only stats: got status %d", code)
+	}
+}
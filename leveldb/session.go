@@ -7,6 +7,7 @@
 package leveldb
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -45,6 +46,10 @@ type session struct {
 	stor     *iStorage
 	storLock storage.Locker
 	o        *cachedOptions
+	uo       *opt.Options // options as given by the user
+	logger   opt.Logger
+	events   eventRing
+	admin    adminJournal
 	icmp     *iComparer
 	tops     *tOps
 
@@ -69,10 +74,26 @@ type session struct {
 
 // Creates new initialized session instance.
 func newSession(stor storage.Storage, o *opt.Options) (s *session, err error) {
+	return newSessionContext(context.Background(), stor, o)
+}
+
+// Creates new initialized session instance, waiting for the lock of the
+// storage until the context is done, see Options.LockTimeout.
+func newSessionContext(ctx context.Context, stor storage.Storage, o *opt.Options) (s *session, err error) {
 	if stor == nil {
 		return nil, os.ErrInvalid
 	}
-	storLock, err := stor.Lock()
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	if err := dbEncryption().validate(); err != nil {
+		return nil, fmt.Errorf("%w: EncryptionVersion and EncryptionKey: %v", ErrEncryption, err)
+	}
+	var storLock storage.Locker
+	err = retryLocked(ctx, o.GetLockTimeout(), func() (err error) {
+		storLock, err = stor.Lock()
+		return
+	})
 	if err != nil {
 		return
 	}
@@ -92,7 +113,6 @@ func newSession(stor storage.Storage, o *opt.Options) (s *session, err error) {
 	s.closeW.Add(1)
 	go s.refLoop()
 	s.setVersion(nil, newVersion(s))
-	s.log("log@legend F·NumFile S·FileSize N·Entry C·BadEntry B·BadBlock Ke·KeyError D·DroppedEntry L·Level Q·SeqNum T·TimeElapsed")
 	return
 }
 
@@ -128,11 +148,11 @@ func (s *session) create() error {
 // Recover a database session; need external synchronization.
 func (s *session) recover() (err error) {
 	defer func() {
-		if os.IsNotExist(err) {
+		if errors.Is(err, os.ErrNotExist) {
 			// Don't return os.ErrNotExist if the underlying storage contains
 			// other files that belong to LevelDB. So the DB won't get trashed.
 			if fds, _ := s.stor.List(storage.TypeAll); len(fds) > 0 {
-				err = &errors.ErrCorrupted{Err: errors.New("database entry point either missing or corrupted")}
+				err = errors.NewErrCorrupted(storage.FileDesc{}, errors.New("database entry point either missing or corrupted"))
 			}
 		}
 	}()
@@ -144,7 +164,7 @@ func (s *session) recover() (err error) {
 
 	reader, err := s.stor.Open(fd)
 	if err != nil {
-		return
+		return errors.NewOpError("open", fd, err)
 	}
 	defer reader.Close()
 
@@ -180,7 +200,7 @@ func (s *session) recover() (err error) {
 			if strict || !errors.IsCorrupted(err) {
 				return
 			}
-			s.logf("manifest error: %v (skipped)", errors.SetFd(err, fd))
+			s.logWarn("manifest@recovery skipped error", "err", errors.SetFd(err, fd))
 		}
 		rec.resetCompPtrs()
 		rec.resetAddedTables()
@@ -219,7 +239,7 @@ func (s *session) commit(r *sessionRecord, trivial bool) (err error) {
 	defer func() {
 		if err != nil {
 			s.abandon <- nv.id
-			s.logf("commit@abandon useless vid D%d", nv.id)
+			s.logDebug("commit@abandon useless version", "vid", nv.id)
 		}
 	}()
 
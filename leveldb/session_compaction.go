@@ -28,7 +28,7 @@ func (s *session) pickMemdbLevel(umin, umax []byte, maxLevel int) int {
 	return v.pickMemdbLevel(umin, umax, maxLevel)
 }
 
-func (s *session) flushMemdb(rec *sessionRecord, mdb *memdb.DB, maxLevel int) (int, error) {
+func (s *session) flushMemdb(rec *sessionRecord, mdb memdb.Memtable, maxLevel int, reason opt.TableReason) (int, error) {
 	// Create sorted table.
 	iter := mdb.NewIterator(nil)
 	defer iter.Release()
@@ -48,50 +48,65 @@ func (s *session) flushMemdb(rec *sessionRecord, mdb *memdb.DB, maxLevel int) (i
 	flushLevel := s.pickMemdbLevel(t.imin.ukey(), t.imax.ukey(), maxLevel)
 	rec.addTableFile(flushLevel, t)
 
-	s.logf("memdb@flush created L%d@%d N·%d S·%s %q:%q", flushLevel, t.fd.Num, n, shortenb(t.size), t.imin, t.imax)
+	s.logInfo("memdb@flush created", "level", flushLevel, "num", t.fd.Num, "entries", n, "size", t.size, "min", t.imin, "max", t.imax)
+	s.o.GetEventListener().OnTableCreated(opt.TableInfo{Num: t.fd.Num, Level: flushLevel, Size: t.size, Reason: reason})
 	return flushLevel, nil
 }
 
 // Pick a compaction based on current state; need external synchronization.
-func (s *session) pickCompaction() *compaction {
+//
+// If skip isn't nil, the compactions it rejects are skipped for the next
+// best ones: the next tables of the level and the other levels needing
+// compaction.
+func (s *session) pickCompaction(skip func(c *compaction) bool) *compaction {
 	v := s.version()
 
-	var sourceLevel int
-	var t0 tFiles
-	var typ int
 	if v.cScore >= 1 {
-		sourceLevel = v.cLevel
-		cptr := s.getCompPtr(sourceLevel)
-		tables := v.levels[sourceLevel]
-		if cptr != nil && sourceLevel > 0 {
-			n := len(tables)
-			if i := sort.Search(n, func(i int) bool {
-				return s.icmp.Compare(tables[i].imax, cptr) > 0
-			}); i < n {
-				t0 = append(t0, tables[i])
+		levels := []int{v.cLevel}
+		if skip != nil {
+			for level, tables := range v.levels {
+				if level != v.cLevel && v.levelScore(level, tables) >= 1 {
+					levels = append(levels, level)
+				}
 			}
 		}
-		if len(t0) == 0 {
-			t0 = append(t0, tables[0])
-		}
-		if sourceLevel == 0 {
-			typ = level0Compaction
-		} else {
-			typ = nonLevel0Compaction
+		for _, sourceLevel := range levels {
+			tables := v.levels[sourceLevel]
+			n := len(tables)
+			start := 0
+			if cptr := s.getCompPtr(sourceLevel); cptr != nil && sourceLevel > 0 {
+				if i := sort.Search(n, func(i int) bool {
+					return s.icmp.Compare(tables[i].imax, cptr) > 0
+				}); i < n {
+					start = i
+				}
+			}
+			typ := nonLevel0Compaction
+			if sourceLevel == 0 {
+				// Level-0 compactions expand to the overlapping tables,
+				// which any other starting table would too.
+				typ, n = level0Compaction, 1
+			}
+			for i := 0; i < n; i++ {
+				t0 := tFiles{tables[(start+i)%len(tables)]}
+				c := newCompaction(s, v, sourceLevel, t0, typ)
+				if skip == nil || !skip(c) {
+					return c
+				}
+			}
 		}
-	} else {
-		if p := atomic.LoadPointer(&v.cSeek); p != nil {
-			ts := (*tSet)(p)
-			sourceLevel = ts.level
-			t0 = append(t0, ts.table)
-			typ = seekCompaction
-		} else {
-			v.release()
-			return nil
+	}
+
+	if p := atomic.LoadPointer(&v.cSeek); p != nil {
+		ts := (*tSet)(p)
+		c := newCompaction(s, v, ts.level, tFiles{ts.table}, seekCompaction)
+		if skip == nil || !skip(c) {
+			return c
 		}
 	}
 
-	return newCompaction(s, v, sourceLevel, t0, typ)
+	v.release()
+	return nil
 }
 
 // Create compaction from given level and range; need external synchronization.
@@ -119,7 +134,7 @@ func (s *session) getCompactionRange(sourceLevel int, umin, umax []byte, noLimit
 		for i, t := range t0 {
 			total += t.size
 			if total >= limit {
-				s.logf("table@compaction limiting F·%d -> F·%d", len(t0), i+1)
+				s.logDebug("table@compaction limiting", "files", len(t0), "limit", i+1)
 				t0 = t0[:i+1]
 				break
 			}
@@ -172,6 +187,19 @@ type compaction struct {
 	snapTPtrs             []int
 }
 
+// Returns the compaction reason to report to the event listener.
+func (c *compaction) reason() opt.CompactionReason {
+	switch c.typ {
+	case level0Compaction:
+		return opt.CompactionReasonLevel0
+	case nonLevel0Compaction:
+		return opt.CompactionReasonNonLevel0
+	case seekCompaction:
+		return opt.CompactionReasonSeek
+	}
+	return opt.CompactionReasonManual
+}
+
 func (c *compaction) save() {
 	c.snapGPI = c.gpi
 	c.snapSeenKey = c.seenKey
@@ -225,9 +253,9 @@ func (c *compaction) expand() {
 			xmin, xmax := exp0.getRange(c.s.icmp)
 			exp1 := vt1.getOverlaps(nil, c.s.icmp, xmin.ukey(), xmax.ukey(), false)
 			if len(exp1) == len(t1) {
-				c.s.logf("table@compaction expanding L%d+L%d (F·%d S·%s)+(F·%d S·%s) -> (F·%d S·%s)+(F·%d S·%s)",
-					c.sourceLevel, c.sourceLevel+1, len(t0), shortenb(t0.size()), len(t1), shortenb(t1.size()),
-					len(exp0), shortenb(exp0.size()), len(exp1), shortenb(exp1.size()))
+				c.s.logDebug("table@compaction expanding", "level", c.sourceLevel,
+					"files", len(t0), "size", t0.size(), "files.next", len(t1), "size.next", t1.size(),
+					"files.expanded", len(exp0), "size.expanded", exp0.size())
 				imin, imax = xmin, xmax
 				t0, t1 = exp0, exp1
 				amin, amax = append(t0, t1...).getRange(c.s.icmp)
@@ -303,6 +331,7 @@ func (c *compaction) newIterator() iterator.Iterator {
 	ro := &opt.ReadOptions{
 		DontFillCache: true,
 		Strict:        opt.StrictOverride,
+		ReadAheadSize: c.s.o.GetCompactionReadAheadSize(),
 	}
 	strict := c.s.o.GetStrict(opt.StrictCompaction)
 	if strict {
@@ -320,7 +349,7 @@ func (c *compaction) newIterator() iterator.Iterator {
 				its = append(its, c.s.tops.newIterator(t, nil, ro))
 			}
 		} else {
-			it := iterator.NewIndexedIterator(tables.newIndexIterator(c.s.tops, c.s.icmp, nil, ro), strict)
+			it := iterator.NewIndexedIterator(tables.newIndexIterator(c.s.tops, c.s.icmp, nil, ro, false), strict)
 			its = append(its, it)
 		}
 	}
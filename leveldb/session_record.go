@@ -32,6 +32,8 @@ const (
 	recAddTable    = 7
 	// 8 was used for large value refs
 	recPrevJournalNum = 9
+	// Only in the manifest of a backup, see DB.Backup.
+	recBackupFingerprint = 10
 )
 
 type cpRecord struct {
@@ -62,6 +64,7 @@ type sessionRecord struct {
 	compPtrs       []cpRecord
 	addedTables    []atRecord
 	deletedTables  []dtRecord
+	fingerprint    []byte
 
 	scratch [binary.MaxVarintLen64]byte
 	err     error
@@ -86,6 +89,11 @@ func (p *sessionRecord) setPrevJournalNum(num int64) {
 	p.prevJournalNum = num
 }
 
+func (p *sessionRecord) setBackupFingerprint(fp []byte) {
+	p.hasRec |= 1 << recBackupFingerprint
+	p.fingerprint = fp
+}
+
 func (p *sessionRecord) setNextFileNum(num int64) {
 	p.hasRec |= 1 << recNextFileNum
 	p.nextFileNum = num
@@ -192,6 +200,10 @@ func (p *sessionRecord) encode(w io.Writer) error {
 		p.putBytes(w, r.imin)
 		p.putBytes(w, r.imax)
 	}
+	if p.has(recBackupFingerprint) {
+		p.putUvarint(w, recBackupFingerprint)
+		p.putBytes(w, p.fingerprint)
+	}
 	return p.err
 }
 
@@ -316,6 +328,11 @@ func (p *sessionRecord) decode(r io.Reader) error {
 			if p.err == nil {
 				p.delTable(level, num)
 			}
+		case recBackupFingerprint:
+			x := p.readBytes("backup-fingerprint", br)
+			if p.err == nil {
+				p.setBackupFingerprint(x)
+			}
 		}
 	}
 
@@ -7,11 +7,14 @@
 package leveldb
 
 import (
+	"bytes"
 	"fmt"
 	"sync/atomic"
 	"time"
 
+	"github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/journal"
+	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/storage"
 )
 
@@ -24,14 +27,80 @@ type dropper struct {
 
 func (d dropper) Drop(err error) {
 	if e, ok := err.(*journal.ErrCorrupted); ok {
-		d.s.logf("journal@drop %s-%d S·%s %q", d.fd.Type, d.fd.Num, shortenb(int64(e.Size)), e.Reason)
+		d.s.logWarn("journal@drop", "type", d.fd.Type, "num", d.fd.Num, "size", e.Size, "reason", e.Reason)
 	} else {
-		d.s.logf("journal@drop %s-%d %q", d.fd.Type, d.fd.Num, err)
+		d.s.logWarn("journal@drop", "type", d.fd.Type, "num", d.fd.Num, "err", err)
 	}
 }
 
-func (s *session) log(v ...interface{})                 { s.stor.Log(fmt.Sprint(v...)) }
-func (s *session) logf(format string, v ...interface{}) { s.stor.Log(fmt.Sprintf(format, v...)) }
+func (s *session) logDebug(msg string, kv ...interface{}) {
+	if s.o.GetLogLevel() <= opt.LogDebug {
+		s.logger.Debug(msg, kv...)
+	}
+}
+
+func (s *session) logInfo(msg string, kv ...interface{}) {
+	s.events.add(opt.LogInfo, msg, kv)
+	if s.o.GetLogLevel() <= opt.LogInfo {
+		s.logger.Info(msg, kv...)
+	}
+}
+
+func (s *session) logWarn(msg string, kv ...interface{}) {
+	s.events.add(opt.LogWarn, msg, kv)
+	if s.o.GetLogLevel() <= opt.LogWarn {
+		s.logger.Warn(msg, kv...)
+	}
+}
+
+func (s *session) logError(msg string, kv ...interface{}) {
+	s.events.add(opt.LogError, msg, kv)
+	if s.o.GetLogLevel() <= opt.LogError {
+		s.logger.Error(msg, kv...)
+	}
+}
+
+// storageLogger is the default logger, writing each event as a text line
+// to the storage log, with its fields in key=value form.
+type storageLogger struct {
+	stor storage.Storage
+}
+
+func (l storageLogger) log(msg string, kv []interface{}) {
+	var buf bytes.Buffer
+	buf.WriteString(msg)
+	writeLogFields(&buf, kv)
+	l.stor.Log(buf.String())
+}
+
+// Writes the given alternating keys and values in key=value form, each
+// preceded by a space.
+func writeLogFields(buf *bytes.Buffer, kv []interface{}) {
+	for i := 0; i < len(kv); i += 2 {
+		buf.WriteByte(' ')
+		fmt.Fprint(buf, kv[i])
+		buf.WriteByte('=')
+		if i+1 == len(kv) {
+			buf.WriteString("<missing>")
+			break
+		}
+		switch v := kv[i+1].(type) {
+		case error:
+			fmt.Fprintf(buf, "%q", v.Error())
+		case string:
+			fmt.Fprintf(buf, "%q", v)
+		case []byte, internalKey:
+			fmt.Fprintf(buf, "%q", v)
+		default:
+			fmt.Fprint(buf, v)
+		}
+	}
+}
+
+func (l storageLogger) Debug(msg string, kv ...interface{}) { l.log(msg, kv) }
+func (l storageLogger) Info(msg string, kv ...interface{})  { l.log(msg, kv) }
+func (l storageLogger) Warn(msg string, kv ...interface{})  { l.log(msg, kv) }
+func (l storageLogger) Error(msg string, kv ...interface{}) { l.log(msg, kv) }
 
 // File utils.
 
@@ -263,6 +332,14 @@ func (s *session) tLen(level int) int {
 	return s.stVersion.tLen(level)
 }
 
+// Returns the estimated number of bytes the compactions of the current
+// version have to rewrite.
+func (s *session) pendingCompactionBytes() int64 {
+	s.vmu.Lock()
+	defer s.vmu.Unlock()
+	return s.stVersion.cPendingBytes
+}
+
 // Set current version to v.
 func (s *session) setVersion(r *sessionRecord, v *version) {
 	s.vmu.Lock()
@@ -285,7 +362,7 @@ func (s *session) setVersion(r *sessionRecord, v *version) {
 			select {
 			case s.deltaCh <- &vDelta{vid: s.stVersion.id, added: added, deleted: deleted}:
 			case <-v.s.closeC:
-				s.log("reference loop already exist")
+				s.logDebug("version@ref reference loop already exited")
 			}
 		}
 		// Release current version.
@@ -405,7 +482,7 @@ func (s *session) newManifest(rec *sessionRecord, v *version) (err error) {
 	fd := storage.FileDesc{Type: storage.TypeManifest, Num: s.allocFileNum()}
 	writer, err := s.stor.Create(fd)
 	if err != nil {
-		return
+		return errors.NewOpError("create", fd, err)
 	}
 	jw := journal.NewWriter(writer)
 
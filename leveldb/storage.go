@@ -5,10 +5,14 @@ import (
 	"crypto/cipher"
 	"encoding/binary"
 	"sync/atomic"
+	"time"
 
+	"github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/storage"
 )
 
+var errRecycleUnsupported = errors.New("leveldb: storage doesn't support recycling")
+
 var (
 	EncryptionVersion int // 0 NONE, 1 XOR, 2 AES
 	EncryptionKey     []byte
@@ -18,6 +22,58 @@ type iStorage struct {
 	storage.Storage
 	read  uint64
 	write uint64
+
+	cipher [3]cipherStat // By file type, see cipherStatIndex.
+}
+
+// cipherStat holds the cumulative time and bytes of the encryption and
+// decryption of a file type.
+type cipherStat struct {
+	encNanos, encBytes uint64
+	decNanos, decBytes uint64
+}
+
+// Temporary files are tables being rebuilt, so are accounted as tables.
+func cipherStatIndex(ft storage.FileType) int {
+	switch ft {
+	case storage.TypeManifest:
+		return 0
+	case storage.TypeJournal:
+		return 1
+	}
+	return 2
+}
+
+func (c *iStorage) addEncrypt(ft storage.FileType, start time.Time, n int) {
+	cs := &c.cipher[cipherStatIndex(ft)]
+	atomic.AddUint64(&cs.encNanos, uint64(time.Since(start)))
+	atomic.AddUint64(&cs.encBytes, uint64(n))
+}
+
+func (c *iStorage) addDecrypt(ft storage.FileType, start time.Time, n int) {
+	cs := &c.cipher[cipherStatIndex(ft)]
+	atomic.AddUint64(&cs.decNanos, uint64(time.Since(start)))
+	atomic.AddUint64(&cs.decBytes, uint64(n))
+}
+
+// CipherStats holds the cumulative time spent encrypting and decrypting the
+// files of a type, and the number of bytes processed. It's zero unless
+// encryption is enabled.
+type CipherStats struct {
+	EncryptDuration time.Duration
+	EncryptBytes    uint64
+	DecryptDuration time.Duration
+	DecryptBytes    uint64
+}
+
+func (c *iStorage) cipherStats(ft storage.FileType) CipherStats {
+	cs := &c.cipher[cipherStatIndex(ft)]
+	return CipherStats{
+		EncryptDuration: time.Duration(atomic.LoadUint64(&cs.encNanos)),
+		EncryptBytes:    atomic.LoadUint64(&cs.encBytes),
+		DecryptDuration: time.Duration(atomic.LoadUint64(&cs.decNanos)),
+		DecryptBytes:    atomic.LoadUint64(&cs.decBytes),
+	}
 }
 
 func (c *iStorage) Open(fd storage.FileDesc) (storage.Reader, error) {
@@ -32,6 +88,25 @@ func (c *iStorage) Create(fd storage.FileDesc) (storage.Writer, error) {
 	return &iStorageWriter{w, c, cipher, 0, fd}, err
 }
 
+// canRecycle returns whether the underlying storage can recycle files.
+func (c *iStorage) canRecycle() bool {
+	_, ok := c.Storage.(storage.Recycler)
+	return ok
+}
+
+func (c *iStorage) Recycle(oldfd, newfd storage.FileDesc) (storage.Writer, error) {
+	rs, ok := c.Storage.(storage.Recycler)
+	if !ok {
+		return nil, errRecycleUnsupported
+	}
+	w, err := rs.Recycle(oldfd, newfd)
+	if err != nil {
+		return nil, err
+	}
+	cipher := newCipher(EncryptionKey)
+	return &iStorageWriter{w, c, cipher, 0, newfd}, nil
+}
+
 func (c *iStorage) reads() uint64 {
 	return atomic.LoadUint64(&c.read)
 }
@@ -42,7 +117,7 @@ func (c *iStorage) writes() uint64 {
 
 // newIStorage returns the given storage wrapped by iStorage.
 func newIStorage(s storage.Storage) *iStorage {
-	return &iStorage{s, 0, 0}
+	return &iStorage{Storage: s}
 }
 
 type iStorageReader struct {
@@ -61,8 +136,10 @@ func (r *iStorageReader) Read(p []byte) (n int, err error) {
 	if n > 0 && r.cipher != nil {
 		// Debug.Printf("Reading: fd={Type:%d, Num:%d}, offset=%d, size=%d, totalRead=%d",
 		// 	r.fd.Type, r.fd.Num, currentOffset, n, atomic.LoadUint64(&r.c.read))
+		start := time.Now()
 		decrypted := r.cipher.DecryptAt(p[:n], currentOffset)
 		copy(p, decrypted)
+		r.c.addDecrypt(r.fd.Type, start, n)
 		r.offset = currentOffset + int64(n)
 		atomic.AddUint64(&r.c.read, uint64(n))
 	}
@@ -77,8 +154,10 @@ func (r *iStorageReader) ReadAt(p []byte, off int64) (n int, err error) {
 	if n > 0 && r.cipher != nil {
 		// Debug.Printf("ReadingAt: fd={Type:%d, Num:%d}, offset=%d, size=%d",
 		// 	r.fd.Type, r.fd.Num, off, n)
+		start := time.Now()
 		decrypted := r.cipher.DecryptAt(p[:n], off)
 		copy(p, decrypted)
+		r.c.addDecrypt(r.fd.Type, start, n)
 		atomic.AddUint64(&r.c.read, uint64(n))
 	}
 	if err != nil {
@@ -99,7 +178,9 @@ func (w *iStorageWriter) Write(p []byte) (n int, err error) {
 	if w.cipher != nil {
 		// Debug.Printf("Writing: fd={Type:%d, Num:%d}, offset=%d, size=%d",
 		// 	w.fd.Type, w.fd.Num, w.offset, len(p))
+		start := time.Now()
 		encrypted := w.cipher.EncryptAt(p, w.offset)
+		w.c.addEncrypt(w.fd.Type, start, len(p))
 		n, err = w.Writer.Write(encrypted)
 		if err != nil {
 			// Debug.Printf("Write error: %v", err)
@@ -125,10 +206,14 @@ type iCipher interface {
 }
 
 func newCipher(key []byte) iCipher {
+	return newCipherVersion(EncryptionVersion, key)
+}
+
+func newCipherVersion(version int, key []byte) iCipher {
 	if key == nil {
 		return nil
 	}
-	switch EncryptionVersion {
+	switch version {
 	case 1:
 		return &xorCipher{key: key}
 	case 2:
@@ -30,6 +30,19 @@ type fileLock interface {
 	release() error
 }
 
+// lockedError is the error of a LOCK file held by another process, or
+// another storage of the process. It matches ErrLocked.
+type lockedError struct {
+	path string
+	err  error
+}
+
+func (e *lockedError) Error() string {
+	return fmt.Sprintf("leveldb/storage: lock %s: %v", e.path, e.err)
+}
+
+func (e *lockedError) Unwrap() []error { return []error{ErrLocked, e.err} }
+
 type fileStorageLock struct {
 	fs *fileStorage
 }
@@ -88,7 +101,7 @@ type fileStorage struct {
 
 // OpenFile returns a new filesystem-backed storage implementation with the given
 // path. This also acquire a file lock, so any subsequent attempt to open the
-// same path will fail.
+// same path will fail, with an error matching ErrLocked, see errors.Is.
 //
 // The storage must be closed after use, by calling Close method.
 func OpenFile(path string, readOnly bool) (Storage, error) {
@@ -106,6 +119,9 @@ func OpenFile(path string, readOnly bool) (Storage, error) {
 
 	flock, err := newFileLock(filepath.Join(path, "LOCK"), readOnly)
 	if err != nil {
+		if isErrLocked(err) {
+			err = &lockedError{path: path, err: err}
+		}
 		return nil, err
 	}
 
@@ -562,6 +578,76 @@ func (fs *fileStorage) Rename(oldfd, newfd FileDesc) error {
 	return rename(filepath.Join(fs.path, fsGenName(oldfd)), filepath.Join(fs.path, fsGenName(newfd)))
 }
 
+func (fs *fileStorage) Recycle(oldfd, newfd FileDesc) (Writer, error) {
+	if !FileDescOk(oldfd) || !FileDescOk(newfd) {
+		return nil, ErrInvalidFile
+	}
+	if fs.readOnly {
+		return nil, errReadOnly
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.open < 0 {
+		return nil, ErrClosed
+	}
+	path := filepath.Join(fs.path, fsGenName(newfd))
+	if oldfd != newfd {
+		if err := rename(filepath.Join(fs.path, fsGenName(oldfd)), path); err != nil {
+			return nil, err
+		}
+	}
+	of, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	fs.open++
+	return &fileWrap{File: of, fs: fs, fd: newfd}, nil
+}
+
+func (fs *fileStorage) AppendAdminLog(rec []byte) error {
+	if fs.readOnly {
+		return errReadOnly
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.open < 0 {
+		return ErrClosed
+	}
+	f, err := os.OpenFile(filepath.Join(fs.path, "ADMIN"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(rec); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (fs *fileStorage) OpenAdminLog() (io.ReadCloser, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.open < 0 {
+		return nil, ErrClosed
+	}
+	return os.Open(filepath.Join(fs.path, "ADMIN"))
+}
+
+func (fs *fileStorage) DiskSpace() (avail, total uint64, err error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.open < 0 {
+		return 0, 0, ErrClosed
+	}
+	return diskSpace(fs.path)
+}
+
 func (fs *fileStorage) Close() error {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
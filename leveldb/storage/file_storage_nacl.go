@@ -30,6 +30,10 @@ func isErrInvalid(err error) bool {
 	return false
 }
 
+func isErrLocked(err error) bool {
+	return false
+}
+
 func syncDir(name string) error {
 	return syscall.ENOTSUP
 }
@@ -0,0 +1,18 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+//go:build !darwin && !dragonfly && !freebsd && !linux
+// +build !darwin,!dragonfly,!freebsd,!linux
+
+package storage
+
+import (
+	"errors"
+)
+
+func diskSpace(path string) (avail, total uint64, err error) {
+	return 0, 0, errors.ErrUnsupported
+}
@@ -8,6 +8,7 @@ package storage
 
 import (
 	"os"
+	"strings"
 )
 
 type plan9FileLock struct {
@@ -50,6 +51,13 @@ func rename(oldpath, newpath string) error {
 	return os.Rename(oldpath, newpath)
 }
 
+func isErrLocked(err error) bool {
+	if patherr, ok := err.(*os.PathError); ok {
+		err = patherr.Err
+	}
+	return err != nil && strings.Contains(err.Error(), "exclusive use file already open")
+}
+
 func syncDir(name string) error {
 	f, err := os.Open(name)
 	if err != nil {
@@ -69,6 +69,10 @@ func rename(oldpath, newpath string) error {
 	return os.Rename(oldpath, newpath)
 }
 
+func isErrLocked(err error) bool {
+	return err == syscall.EAGAIN || err == syscall.EACCES
+}
+
 func syncDir(name string) error {
 	f, err := os.Open(name)
 	if err != nil {
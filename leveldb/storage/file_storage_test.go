@@ -7,6 +7,7 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
@@ -331,6 +332,9 @@ func TestFileStorage_Locking(t *testing.T) {
 	p2, err := OpenFile(temp, false)
 	if err != nil {
 		t.Logf("OpenFile(2): got error: %s (expected)", err)
+		if !errors.Is(err, ErrLocked) {
+			t.Error("OpenFile(2): error doesn't match ErrLocked")
+		}
 	} else {
 		p2.Close()
 		p1.Close()
@@ -400,3 +404,44 @@ func TestFileStorage_ReadOnlyLocking(t *testing.T) {
 	p3.Close()
 	p4.Close()
 }
+
+func TestFileStorage_AdminLog(t *testing.T) {
+	temp := tempDir(t)
+	defer os.RemoveAll(temp)
+
+	stor, err := OpenFile(temp, false)
+	if err != nil {
+		t.Fatal("OpenFile: ", err)
+	}
+	al := stor.(AdminLogger)
+	if _, err := al.OpenAdminLog(); !os.IsNotExist(err) {
+		t.Fatalf("OpenAdminLog on empty log: got error %v", err)
+	}
+	for _, rec := range []string{"one\n", "two\n"} {
+		if err := al.AppendAdminLog([]byte(rec)); err != nil {
+			t.Fatal("AppendAdminLog: ", err)
+		}
+	}
+	stor.Close()
+	if err := al.AppendAdminLog([]byte("three\n")); err != ErrClosed {
+		t.Fatalf("AppendAdminLog on closed storage: got error %v", err)
+	}
+
+	stor, err = OpenFile(temp, true)
+	if err != nil {
+		t.Fatal("OpenFile: ", err)
+	}
+	defer stor.Close()
+	r, err := stor.(AdminLogger).OpenAdminLog()
+	if err != nil {
+		t.Fatal("OpenAdminLog: ", err)
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal("ReadAll: ", err)
+	}
+	if string(b) != "one\ntwo\n" {
+		t.Fatalf("got admin log %q", b)
+	}
+}
@@ -64,6 +64,10 @@ func rename(oldpath, newpath string) error {
 	return os.Rename(oldpath, newpath)
 }
 
+func isErrLocked(err error) bool {
+	return err == syscall.EWOULDBLOCK
+}
+
 func isErrInvalid(err error) bool {
 	if err == os.ErrInvalid {
 		return true
@@ -19,6 +19,8 @@ var (
 
 const (
 	_MOVEFILE_REPLACE_EXISTING = 1
+
+	_ERROR_SHARING_VIOLATION syscall.Errno = 32
 )
 
 type windowsFileLock struct {
@@ -75,4 +77,8 @@ func rename(oldpath, newpath string) error {
 	return moveFileEx(from, to, _MOVEFILE_REPLACE_EXISTING)
 }
 
+func isErrLocked(err error) bool {
+	return err == _ERROR_SHARING_VIOLATION
+}
+
 func syncDir(name string) error { return nil }
@@ -8,6 +8,7 @@ package storage
 
 import (
 	"bytes"
+	"io"
 	"os"
 	"sync"
 )
@@ -37,6 +38,7 @@ type memStorage struct {
 	slock *memStorageLock
 	files map[uint64]*memFile
 	meta  FileDesc
+	admin []byte
 }
 
 // NewMemStorage returns a new memory-backed storage implementation.
@@ -170,6 +172,45 @@ func (ms *memStorage) Rename(oldfd, newfd FileDesc) error {
 	return nil
 }
 
+func (ms *memStorage) Recycle(oldfd, newfd FileDesc) (Writer, error) {
+	if !FileDescOk(oldfd) || !FileDescOk(newfd) {
+		return nil, ErrInvalidFile
+	}
+
+	oldx := packFile(oldfd)
+	newx := packFile(newfd)
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	oldm, exist := ms.files[oldx]
+	if !exist {
+		return nil, os.ErrNotExist
+	}
+	newm, exist := ms.files[newx]
+	if (exist && newm.open) || oldm.open {
+		return nil, errFileOpen
+	}
+	delete(ms.files, oldx)
+	ms.files[newx] = oldm
+	oldm.open = true
+	return &memWriter{memFile: oldm, ms: ms}, nil
+}
+
+func (ms *memStorage) AppendAdminLog(rec []byte) error {
+	ms.mu.Lock()
+	ms.admin = append(ms.admin, rec...)
+	ms.mu.Unlock()
+	return nil
+}
+
+func (ms *memStorage) OpenAdminLog() (io.ReadCloser, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if len(ms.admin) == 0 {
+		return nil, os.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(append([]byte(nil), ms.admin...))), nil
+}
+
 func (*memStorage) Close() error { return nil }
 
 type memFile struct {
@@ -197,9 +238,25 @@ func (mr *memReader) Close() error {
 type memWriter struct {
 	*memFile
 	ms     *memStorage
+	off    int
 	closed bool
 }
 
+func (mw *memWriter) Write(p []byte) (n int, err error) {
+	// A recycled file is overwritten in place.
+	if mw.off < mw.memFile.Len() {
+		n = copy(mw.memFile.Bytes()[mw.off:], p)
+		p = p[n:]
+	}
+	if len(p) > 0 {
+		var nn int
+		nn, err = mw.memFile.Write(p)
+		n += nn
+	}
+	mw.off += n
+	return
+}
+
 func (*memWriter) Sync() error { return nil }
 
 func (mw *memWriter) Close() error {
@@ -56,12 +56,8 @@ type ErrCorrupted struct {
 }
 
 func isCorrupted(err error) bool {
-	switch err.(type) {
-	case *ErrCorrupted:
-		return true
-	default:
-		return false
-	}
+	var cerr *ErrCorrupted
+	return errors.As(err, &cerr)
 }
 
 func (e *ErrCorrupted) Error() string {
@@ -71,6 +67,9 @@ func (e *ErrCorrupted) Error() string {
 	return e.Err.Error()
 }
 
+// Unwrap returns the underlying error.
+func (e *ErrCorrupted) Unwrap() error { return e.Err }
+
 // Syncer is the interface that wraps basic Sync method.
 type Syncer interface {
 	// Sync commits the current contents of the file to stable storage.
@@ -118,6 +117,11 @@ func (fd FileDesc) String() string {
 	}
 }
 
+// ParseFileDesc parses a file name, as returned by FileDesc.String.
+func ParseFileDesc(name string) (fd FileDesc, ok bool) {
+	return fsParseName(name)
+}
+
 // Zero returns true if fd == (FileDesc{}).
 func (fd FileDesc) Zero() bool {
 	return fd == (FileDesc{})
@@ -186,3 +190,47 @@ type Storage interface {
 	// called after the storage has been closed.
 	Close() error
 }
+
+// Recycler is implemented by storages that can reuse an existing file in
+// place of creating a new one.
+type Recycler interface {
+	// Recycle renames file from oldfd to newfd and opens it write-only,
+	// positioned at the start of the file. Unlike Create, the file isn't
+	// truncated; its previous content is overwritten as new data is written.
+	// Returns ErrClosed if the underlying storage is closed.
+	Recycle(oldfd, newfd FileDesc) (Writer, error)
+}
+
+// AtomicCreator is implemented by storages whose created files only appear
+// once their writer is closed, e.g. object storages, so that a file is
+// never seen partially written.
+type AtomicCreator interface {
+	// Abort discards the file being written by a writer of the storage,
+	// instead of closing it. The storage may keep what was written, to
+	// resume the transfer when the file is created again.
+	Abort(w Writer) error
+}
+
+// AdminLogger is implemented by storages that can keep a persistent log of
+// administrative events, such as opening, closing, manual compactions and
+// repairs of the DB, which survives reopening.
+type AdminLogger interface {
+	// AppendAdminLog appends the given record to the administrative log
+	// and syncs it.
+	// Returns ErrClosed if the underlying storage is closed.
+	AppendAdminLog(rec []byte) error
+
+	// OpenAdminLog opens the administrative log read-only.
+	// Returns os.ErrNotExist error if nothing was logged.
+	OpenAdminLog() (io.ReadCloser, error)
+}
+
+// SpaceReporter is implemented by storages that can report the space of
+// the device they're stored on, e.g. for health checks.
+type SpaceReporter interface {
+	// DiskSpace returns the space available to the storage, and the total
+	// space of the device, in bytes.
+	// Returns ErrClosed if the underlying storage is closed, or
+	// errors.ErrUnsupported if the platform can't tell.
+	DiskSpace() (avail, total uint64, err error)
+}
@@ -9,10 +9,13 @@ package leveldb
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"sort"
 	"sync/atomic"
+	"time"
 
 	"github.com/syndtr/goleveldb/leveldb/cache"
+	"github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/storage"
@@ -22,6 +25,10 @@ import (
 
 // tFile holds basic information about a table.
 type tFile struct {
+	// Need 64-bit alignment.
+	reads    int64 // number of reads by gets and iterators
+	lastRead int64 // time of the last read, in Unix nanoseconds
+
 	fd         storage.FileDesc
 	seekLeft   int32
 	size       int64
@@ -48,6 +55,12 @@ func (t *tFile) consumeSeek() int32 {
 	return atomic.AddInt32(&t.seekLeft, -1)
 }
 
+// Records a read of the table by a get or an iterator.
+func (t *tFile) recordRead() {
+	atomic.AddInt64(&t.reads, 1)
+	atomic.StoreInt64(&t.lastRead, time.Now().UnixNano())
+}
+
 // Creates new tFile.
 func newTableFile(fd storage.FileDesc, size int64, imin, imax internalKey) *tFile {
 	f := &tFile{
@@ -282,7 +295,8 @@ func (tf tFiles) getRange(icmp *iComparer) (imin, imax internalKey) {
 }
 
 // Creates iterator index from tables.
-func (tf tFiles) newIndexIterator(tops *tOps, icmp *iComparer, slice *util.Range, ro *opt.ReadOptions) iterator.IteratorIndexer {
+// If recordReads is true, each table iterated is recorded as read.
+func (tf tFiles) newIndexIterator(tops *tOps, icmp *iComparer, slice *util.Range, ro *opt.ReadOptions, recordReads bool) iterator.IteratorIndexer {
 	if slice != nil {
 		var start, limit int
 		if slice.Start != nil {
@@ -301,6 +315,8 @@ func (tf tFiles) newIndexIterator(tops *tOps, icmp *iComparer, slice *util.Range
 		icmp:   icmp,
 		slice:  slice,
 		ro:     ro,
+
+		recordReads: recordReads,
 	})
 }
 
@@ -311,6 +327,8 @@ type tFilesArrayIndexer struct {
 	icmp  *iComparer
 	slice *util.Range
 	ro    *opt.ReadOptions
+
+	recordReads bool
 }
 
 func (a *tFilesArrayIndexer) Search(key []byte) int {
@@ -318,6 +336,9 @@ func (a *tFilesArrayIndexer) Search(key []byte) int {
 }
 
 func (a *tFilesArrayIndexer) Get(i int) iterator.Iterator {
+	if a.recordReads {
+		a.tFiles[i].recordRead()
+	}
 	if i == 0 || i == a.Len()-1 {
 		return a.tops.newIterator(a.tFiles[i], a.slice, a.ro)
 	}
@@ -350,27 +371,39 @@ type tOps struct {
 	evictRemoved bool
 	fileCache    *cache.Cache
 	blockCache   *cache.Cache
-	blockBuffer  *util.BufferPool
+	// Holds compressed blocks read from tables, may be nil.
+	compressedBlockCache *cache.Cache
+	blockBuffer          *util.BufferPool
+	pinBudget            int
+	pinned               int64
 }
 
-// Creates an empty table and returns table writer.
-func (t *tOps) create(tSize int) (*tWriter, error) {
+// Creates an empty table for the given level and returns table writer.
+func (t *tOps) create(level, tSize int) (*tWriter, error) {
 	fd := storage.FileDesc{Type: storage.TypeTable, Num: t.s.allocFileNum()}
 	fw, err := t.s.stor.Create(fd)
 	if err != nil {
-		return nil, err
+		return nil, errors.NewOpError("create", fd, err)
+	}
+	o := t.s.o.Options
+	blockSize, restartInterval := o.GetLevelBlockSize(level), o.GetLevelBlockRestartInterval(level)
+	if blockSize != o.GetBlockSize() || restartInterval != o.GetBlockRestartInterval() {
+		lo := *o
+		lo.BlockSize = blockSize
+		lo.BlockRestartInterval = restartInterval
+		o = &lo
 	}
 	return &tWriter{
 		t:  t,
 		fd: fd,
 		w:  fw,
-		tw: table.NewWriter(fw, t.s.o.Options, t.blockBuffer, tSize),
+		tw: table.NewWriter(fw, o, t.blockBuffer, tSize),
 	}, nil
 }
 
 // Builds table from src iterator.
 func (t *tOps) createFrom(src iterator.Iterator) (f *tFile, n int, err error) {
-	w, err := t.create(0)
+	w, err := t.create(0, 0)
 	if err != nil {
 		return
 	}
@@ -406,6 +439,7 @@ func (t *tOps) open(f *tFile) (ch *cache.Handle, err error) {
 		var r storage.Reader
 		r, err = t.s.stor.Open(f.fd)
 		if err != nil {
+			err = errors.NewOpError("open", f.fd, err)
 			return 0, nil
 		}
 
@@ -420,6 +454,12 @@ func (t *tOps) open(f *tFile) (ch *cache.Handle, err error) {
 			_ = r.Close()
 			return 0, nil
 		}
+		if t.compressedBlockCache != nil {
+			tr.SetCompressedCache(&cache.NamespaceGetter{Cache: t.compressedBlockCache, NS: uint64(f.fd.Num)})
+		}
+		if t.pinBudget != 0 {
+			t.pin(tr)
+		}
 		return 1, tr
 
 	})
@@ -429,25 +469,65 @@ func (t *tOps) open(f *tFile) (ch *cache.Handle, err error) {
 	return
 }
 
+// Copies the table file as stored, i.e. encrypted if the DB is. It's read
+// through the cached reader, as the storage may not allow opening a file
+// twice.
+func (t *tOps) copyFile(f *tFile, w io.Writer) (int64, error) {
+	ch, err := t.open(f)
+	if err != nil {
+		return 0, err
+	}
+	defer ch.Release()
+	ra := ch.Value().(*table.Reader).File()
+	if r, ok := ra.(*iStorageReader); ok {
+		ra = r.Reader
+	}
+	return io.Copy(w, io.NewSectionReader(ra, 0, f.size))
+}
+
+// Pins index and filter blocks of the table, if within budget.
+func (t *tOps) pin(tr *table.Reader) {
+	n := int64(tr.MetaBlocksSize())
+	if pinned := atomic.AddInt64(&t.pinned, n); t.pinBudget > 0 && pinned > int64(t.pinBudget) {
+		atomic.AddInt64(&t.pinned, -n)
+		return
+	}
+	if err := tr.PinMetaBlocks(func() { atomic.AddInt64(&t.pinned, -n) }); err != nil {
+		// Leave the error to be reported by reads.
+		atomic.AddInt64(&t.pinned, -n)
+	}
+}
+
+// Sets result[i] to whether the given table may contain keys[i], according
+// to its filter.
+func (t *tOps) mayContainBatch(f *tFile, keys [][]byte, result []bool) error {
+	ch, err := t.open(f)
+	if err != nil {
+		return err
+	}
+	defer ch.Release()
+	return ch.Value().(*table.Reader).MayContainBatch(keys, result)
+}
+
 // Finds key/value pair whose key is greater than or equal to the
-// given key.
-func (t *tOps) find(f *tFile, key []byte, ro *opt.ReadOptions) (rkey, rvalue []byte, err error) {
+// given key. The value is appended to dst.
+func (t *tOps) find(f *tFile, key []byte, filtered bool, ro *opt.ReadOptions, dst []byte) (rkey, rvalue []byte, err error) {
 	ch, err := t.open(f)
 	if err != nil {
 		return nil, nil, err
 	}
 	defer ch.Release()
-	return ch.Value().(*table.Reader).Find(key, true, ro)
+	return ch.Value().(*table.Reader).FindTo(key, filtered, ro, dst)
 }
 
 // Finds key that is greater than or equal to the given key.
-func (t *tOps) findKey(f *tFile, key []byte, ro *opt.ReadOptions) (rkey []byte, err error) {
+func (t *tOps) findKey(f *tFile, key []byte, filtered bool, ro *opt.ReadOptions) (rkey []byte, err error) {
 	ch, err := t.open(f)
 	if err != nil {
 		return nil, err
 	}
 	defer ch.Release()
-	return ch.Value().(*table.Reader).FindKey(key, true, ro)
+	return ch.Value().(*table.Reader).FindKey(key, filtered, ro)
 }
 
 // Returns approximate offset of the given key.
@@ -471,18 +551,48 @@ func (t *tOps) newIterator(f *tFile, slice *util.Range, ro *opt.ReadOptions) ite
 	return iter
 }
 
+// Returns tables overlapping the slice which may contain keys with the
+// given prefix, according to the filter.
+func (t *tOps) prefixTables(tf tFiles, prefix []byte, slice *util.Range) tFiles {
+	var tables tFiles
+	for _, f := range tf {
+		if t.s.icmp.Compare(f.imax, slice.Start) < 0 || t.s.icmp.Compare(f.imin, slice.Limit) >= 0 {
+			continue
+		}
+		ch, err := t.open(f)
+		if err != nil {
+			// Leave the error to be reported by the iterator.
+			tables = append(tables, f)
+			continue
+		}
+		ok, err := ch.Value().(*table.Reader).MayContainPrefix(prefix, slice)
+		ch.Release()
+		if ok || err != nil {
+			tables = append(tables, f)
+		}
+	}
+	return tables
+}
+
 // Removes table from persistent storage. It waits until
 // no one use the the table.
 func (t *tOps) remove(fd storage.FileDesc) {
 	t.fileCache.Delete(0, uint64(fd.Num), func() {
-		if err := t.s.stor.Remove(fd); err != nil {
-			t.s.logf("table@remove removing @%d %q", fd.Num, err)
+		err := t.s.stor.Remove(fd)
+		if err != nil {
+			t.s.logWarn("table@remove removing", "num", fd.Num, "err", err)
 		} else {
-			t.s.logf("table@remove removed @%d", fd.Num)
+			t.s.logInfo("table@remove removed", "num", fd.Num)
 		}
+		t.s.o.GetEventListener().OnTableDeleted(opt.TableInfo{Num: fd.Num, Level: -1, Err: err})
 		if t.evictRemoved && t.blockCache != nil {
 			t.blockCache.EvictNS(uint64(fd.Num))
 		}
+		// Compressed blocks are only ever read again if the file num is
+		// reused, in which case they are stale.
+		if t.compressedBlockCache != nil {
+			t.compressedBlockCache.EvictNS(uint64(fd.Num))
+		}
 		// Try to reuse file num, useful for discarded transaction.
 		t.s.reuseFileNum(fd.Num)
 	})
@@ -495,6 +605,9 @@ func (t *tOps) close() {
 	if t.blockCache != nil {
 		t.blockCache.Close(false)
 	}
+	if t.compressedBlockCache != nil {
+		t.compressedBlockCache.Close(false)
+	}
 }
 
 // Creates new initialized table ops instance.
@@ -502,7 +615,9 @@ func newTableOps(s *session) *tOps {
 	var (
 		fileCacher  cache.Cacher
 		blockCache  *cache.Cache
+		cBlockCache *cache.Cache
 		blockBuffer *util.BufferPool
+		pinBudget   int
 	)
 	if s.o.GetOpenFilesCacheCapacity() > 0 {
 		fileCacher = s.o.GetOpenFilesCacher().New(s.o.GetOpenFilesCacheCapacity())
@@ -513,17 +628,25 @@ func newTableOps(s *session) *tOps {
 			blockCacher = s.o.GetBlockCacher().New(s.o.GetBlockCacheCapacity())
 		}
 		blockCache = cache.NewCache(blockCacher)
+		if capacity := s.o.GetCompressedBlockCacheCapacity(); capacity > 0 {
+			cBlockCache = cache.NewCache(s.o.GetBlockCacher().New(capacity))
+		}
 	}
 	if !s.o.GetDisableBufferPool() {
 		blockBuffer = util.NewBufferPool(s.o.GetBlockSize() + 5)
 	}
+	if s.o.GetPinIndexAndFilterBlocks() {
+		pinBudget = s.o.GetPinIndexAndFilterBlocksBudget()
+	}
 	return &tOps{
-		s:            s,
-		noSync:       s.o.GetNoSync(),
-		evictRemoved: s.o.GetBlockCacheEvictRemoved(),
-		fileCache:    cache.NewCache(fileCacher),
-		blockCache:   blockCache,
-		blockBuffer:  blockBuffer,
+		s:                    s,
+		noSync:               s.o.GetNoSync(),
+		evictRemoved:         s.o.GetBlockCacheEvictRemoved(),
+		fileCache:            cache.NewCache(fileCacher),
+		blockCache:           blockCache,
+		compressedBlockCache: cBlockCache,
+		blockBuffer:          blockBuffer,
+		pinBudget:            pinBudget,
 	}
 }
 
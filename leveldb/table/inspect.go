@@ -0,0 +1,214 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package table
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// BlockHandle is the location of a block in a table file, excluding its
+// trailer.
+type BlockHandle struct {
+	Offset, Length uint64
+}
+
+// DataBlockInfo describes a data block of a table.
+type DataBlockInfo struct {
+	BlockHandle
+	// Key is the index key of the block, greater or equal to its last key
+	// and less than the first key of the next block.
+	Key []byte
+	// Compression is the compression of the block as stored, "none" or
+	// "snappy".
+	Compression string
+}
+
+// FilterInfo describes the filter of a table.
+type FilterInfo struct {
+	// Name is the name of the filter policy.
+	Name string
+	// Kind is "block" for a filter per 2KiB of data blocks, "full" for a
+	// single filter covering the table, or "partitioned" for a filter per
+	// index partition.
+	Kind string
+	// Blocks is the number of filter blocks and Size their total size.
+	Blocks int
+	Size   uint64
+}
+
+// Layout describes the structure of a table file, for inspection.
+type Layout struct {
+	// ChecksumType is the block checksum algorithm given by the footer,
+	// "crc32c" or "xxhash64".
+	ChecksumType string
+	// MetaIndex and Index are the block handles stored in the footer.
+	MetaIndex, Index BlockHandle
+	// MetaEntries maps the metaindex keys to their block handle.
+	MetaEntries map[string]BlockHandle
+	// Partitions holds the index partitions, if the index is partitioned.
+	Partitions []BlockHandle
+	// DataBlocks holds the data blocks, in key order.
+	DataBlocks []DataBlockInfo
+	// Filters holds the filters named in the metaindex, whether or not
+	// the reader was given their filter policy.
+	Filters []FilterInfo
+	// Properties is nil if the table has no properties block.
+	Properties *Properties
+}
+
+// Inspect reads the footer, metaindex, index and properties of the table,
+// and the trailer of each data block. It's meant for debugging, e.g. of
+// corruption reports, and reads the file without going through the caches.
+func (r *Reader) Inspect() (*Layout, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	l := &Layout{
+		MetaIndex:   BlockHandle{r.metaBH.offset, r.metaBH.length},
+		Index:       BlockHandle{r.indexBH.offset, r.indexBH.length},
+		MetaEntries: make(map[string]BlockHandle),
+	}
+	switch r.checksumType {
+	case checksumTypeCRC32C:
+		l.ChecksumType = "crc32c"
+	case checksumTypeXXHash64:
+		l.ChecksumType = "xxhash64"
+	}
+
+	// Metaindex.
+	metaBlock, err := r.readBlock(r.metaBH, true)
+	if err != nil {
+		return nil, err
+	}
+	metaIter := r.newBlockIter(metaBlock, nil, nil, true)
+	partitionedFilter := -1 // Index in l.Filters.
+	for metaIter.Next() {
+		key := string(metaIter.Key())
+		bh, n := decodeBlockHandle(metaIter.Value())
+		if n > 0 {
+			l.MetaEntries[key] = BlockHandle{bh.offset, bh.length}
+		}
+		var filter FilterInfo
+		switch {
+		case strings.HasPrefix(key, partitionedFilterPrefix):
+			filter = FilterInfo{Name: key[len(partitionedFilterPrefix):], Kind: "partitioned"}
+		case strings.HasPrefix(key, fullFilterPrefix):
+			filter = FilterInfo{Name: key[len(fullFilterPrefix):], Kind: "full", Blocks: 1, Size: bh.length}
+		case strings.HasPrefix(key, "filter."):
+			filter = FilterInfo{Name: key[len("filter."):], Kind: "block", Size: bh.length}
+			if b, err := r.readFilterBlock(bh); err == nil {
+				filter.Blocks = b.filtersNum
+				b.Release()
+			}
+		default:
+			continue
+		}
+		if filter.Kind == "partitioned" {
+			partitionedFilter = len(l.Filters)
+		}
+		l.Filters = append(l.Filters, filter)
+	}
+	err = metaIter.Error()
+	metaIter.Release()
+	metaBlock.Release()
+	if err != nil {
+		return nil, err
+	}
+
+	// Index, and its partitions.
+	indexBlock, err := r.readBlock(r.indexBH, true)
+	if err != nil {
+		return nil, err
+	}
+	indexBlocks := []*block{indexBlock}
+	if r.partitioned {
+		iter := r.newBlockIter(indexBlock, nil, nil, true)
+		for iter.Next() {
+			partitionBH, filterBH, ok := decodePartitionHandles(iter.Value())
+			if !ok {
+				iter.Release()
+				indexBlock.Release()
+				return nil, r.newErrCorruptedBH(r.indexBH, "bad index partition handle")
+			}
+			l.Partitions = append(l.Partitions, BlockHandle{partitionBH.offset, partitionBH.length})
+			if partitionedFilter >= 0 && filterBH.length > 0 {
+				l.Filters[partitionedFilter].Blocks++
+				l.Filters[partitionedFilter].Size += filterBH.length
+			}
+		}
+		err = iter.Error()
+		iter.Release()
+		indexBlock.Release()
+		if err != nil {
+			return nil, err
+		}
+		indexBlocks = indexBlocks[:0]
+		for _, bh := range l.Partitions {
+			b, err := r.readBlock(blockHandle{bh.Offset, bh.Length}, true)
+			if err != nil {
+				for _, b := range indexBlocks {
+					b.Release()
+				}
+				return nil, err
+			}
+			indexBlocks = append(indexBlocks, b)
+		}
+	}
+	for _, b := range indexBlocks {
+		if err == nil {
+			err = r.inspectIndexBlock(l, b)
+		}
+		b.Release()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Properties.
+	if r.propsBH.length > 0 {
+		if l.Properties, err = r.readProperties(); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Adds the data blocks indexed by the given index block to the layout.
+func (r *Reader) inspectIndexBlock(l *Layout, b *block) error {
+	iter := r.newBlockIter(b, nil, nil, true)
+	defer iter.Release()
+	var trailer [1]byte
+	for iter.Next() {
+		bh, n := decodeBlockHandle(iter.Value())
+		if n == 0 {
+			return r.newErrCorruptedBH(b.bh, "bad data block handle")
+		}
+		info := DataBlockInfo{
+			BlockHandle: BlockHandle{bh.offset, bh.length},
+			Key:         append([]byte(nil), iter.Key()...),
+		}
+		if _, err := r.reader.ReadAt(trailer[:], int64(bh.offset+bh.length)); err != nil && err != io.EOF {
+			return err
+		}
+		switch trailer[0] {
+		case blockTypeNoCompression:
+			info.Compression = "none"
+		case blockTypeSnappyCompression:
+			info.Compression = "snappy"
+		default:
+			info.Compression = fmt.Sprintf("unknown(%#x)", trailer[0])
+		}
+		l.DataBlocks = append(l.DataBlocks, info)
+	}
+	return iter.Error()
+}
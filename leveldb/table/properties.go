@@ -0,0 +1,126 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package table
+
+import (
+	"encoding/binary"
+	"sort"
+	"strings"
+)
+
+// Metaindex key of the properties block, and the names of the built-in
+// properties stored in it.
+const (
+	propertiesKey = "leveldb.properties"
+
+	propNumEntries    = "leveldb.num.entries"
+	propNumDataBlocks = "leveldb.num.data.blocks"
+	propRawKeySize    = "leveldb.raw.key.size"
+	propRawValueSize  = "leveldb.raw.value.size"
+	propDataSize      = "leveldb.data.size"
+	propSmallestKey   = "leveldb.smallest.key"
+	propLargestKey    = "leveldb.largest.key"
+	propCreationTime  = "leveldb.creation.time"
+)
+
+// Properties holds the properties of a table, as stored in its properties
+// block when the table was written.
+type Properties struct {
+	// NumEntries is the number of entries in the table.
+	NumEntries uint64
+	// NumDataBlocks is the number of data blocks in the table.
+	NumDataBlocks uint64
+	// RawKeySize and RawValueSize are the total size of the keys and values
+	// of the entries, before block encoding and compression.
+	RawKeySize, RawValueSize uint64
+	// DataSize is the total size of the data blocks as stored, that is
+	// after compression.
+	DataSize uint64
+	// SmallestKey and LargestKey are the first and last keys of the table,
+	// or nil if the table is empty.
+	SmallestKey, LargestKey []byte
+	// CreationTime is when the table was written, in seconds since the
+	// Unix epoch.
+	CreationTime int64
+	// User holds the properties added by the table properties collectors.
+	User map[string][]byte
+}
+
+// Encodes the properties into the given block writer, sorted by name.
+func (p *Properties) encode(w *blockWriter) error {
+	props := map[string][]byte{
+		propSmallestKey: p.SmallestKey,
+		propLargestKey:  p.LargestKey,
+	}
+	for name, v := range map[string]uint64{
+		propNumEntries:    p.NumEntries,
+		propNumDataBlocks: p.NumDataBlocks,
+		propRawKeySize:    p.RawKeySize,
+		propRawValueSize:  p.RawValueSize,
+		propDataSize:      p.DataSize,
+		propCreationTime:  uint64(p.CreationTime),
+	} {
+		props[name] = binary.AppendUvarint(nil, v)
+	}
+	for name, v := range p.User {
+		if !strings.HasPrefix(name, "leveldb.") {
+			props[name] = v
+		}
+	}
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := w.append([]byte(name), props[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Decodes a property, returning false if its value is malformed. The value
+// is retained.
+func (p *Properties) decode(name string, value []byte) bool {
+	var dst *uint64
+	switch name {
+	case propSmallestKey:
+		p.SmallestKey = value
+		return true
+	case propLargestKey:
+		p.LargestKey = value
+		return true
+	case propNumEntries:
+		dst = &p.NumEntries
+	case propNumDataBlocks:
+		dst = &p.NumDataBlocks
+	case propRawKeySize:
+		dst = &p.RawKeySize
+	case propRawValueSize:
+		dst = &p.RawValueSize
+	case propDataSize:
+		dst = &p.DataSize
+	case propCreationTime:
+		v, n := binary.Uvarint(value)
+		p.CreationTime = int64(v)
+		return n > 0
+	default:
+		if strings.HasPrefix(name, "leveldb.") {
+			// Unknown built-in property.
+			return true
+		}
+		if p.User == nil {
+			p.User = make(map[string][]byte)
+		}
+		p.User[name] = value
+		return true
+	}
+	var n int
+	*dst, n = binary.Uvarint(value)
+	return n > 0
+}
@@ -13,6 +13,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"unsafe"
 
 	"github.com/golang/snappy"
 
@@ -53,6 +54,14 @@ func max(x, y int) int {
 	return y
 }
 
+// Memory charged to the cache for a cached block or filter block, in
+// addition to its data.
+const (
+	blockCacheOverhead           = int(unsafe.Sizeof(block{})) + cache.NodeOverhead
+	filterBlockCacheOverhead     = int(unsafe.Sizeof(filterBlock{})) + cache.NodeOverhead
+	compressedBlockCacheOverhead = int(unsafe.Sizeof(compressedBlock{})) + cache.NodeOverhead
+)
+
 type block struct {
 	bpool          *util.BufferPool
 	bh             blockHandle
@@ -455,39 +464,219 @@ func (i *blockIter) Error() error {
 
 type filterBlock struct {
 	bpool      *util.BufferPool
+	bh         blockHandle
 	data       []byte
 	oOffset    int
 	baseLg     uint
 	filtersNum int
 }
 
-func (b *filterBlock) contains(filter filter.Filter, offset uint64, key []byte) bool {
+// Returns the filter data for the given data block offset, and whether it
+// is valid; empty filter data means no keys.
+func (b *filterBlock) get(offset uint64) ([]byte, bool) {
 	i := int(offset >> b.baseLg)
 	if i < b.filtersNum {
 		o := b.data[b.oOffset+i*4:]
 		n := int(binary.LittleEndian.Uint32(o))
 		m := int(binary.LittleEndian.Uint32(o[4:]))
-		if n < m && m <= b.oOffset {
-			return filter.Contains(b.data[n:m], key)
-		} else if n == m {
-			return false
+		if n <= m && m <= b.oOffset {
+			return b.data[n:m], true
 		}
 	}
+	return nil, false
+}
+
+func (b *filterBlock) contains(filter filter.Filter, offset uint64, key []byte) bool {
+	if data, ok := b.get(offset); ok {
+		return len(data) > 0 && filter.Contains(data, key)
+	}
+	return true
+}
+
+// Same as contains, for all the given keys at once.
+func (b *filterBlock) containsBatch(f filter.Filter, offset uint64, keys [][]byte, result []bool) {
+	data, ok := b.get(offset)
+	for i := range keys {
+		result[i] = !ok || len(data) > 0
+	}
+	if ok && len(data) > 0 {
+		filter.ContainsBatch(f, data, keys, result)
+	}
+}
+
+func (b *filterBlock) containsPrefix(filter prefixFilter, offset uint64, prefix []byte) bool {
+	if data, ok := b.get(offset); ok {
+		return len(data) > 0 && filter.ContainsPrefix(data, prefix)
+	}
 	return true
 }
 
+// prefixFilter is implemented by filters which also hold key prefixes.
+type prefixFilter interface {
+	ContainsPrefix(filter, prefix []byte) bool
+}
+
 func (b *filterBlock) Release() {
 	b.bpool.Put(b.data)
 	b.bpool = nil
 	b.data = nil
 }
 
+// readAhead buffers reads of an iterator, reading ahead at least size
+// bytes at a time. It is not safe for concurrent use, which is fine as
+// iterators aren't either.
+type readAhead struct {
+	f    io.ReaderAt
+	size int
+	buf  []byte
+	off  int64
+}
+
+// Returns f read through the read-ahead buffer, or f itself if ra is nil.
+func (ra *readAhead) readerAt(f io.ReaderAt) io.ReaderAt {
+	if ra == nil {
+		return f
+	}
+	ra.f = f
+	return ra
+}
+
+func (ra *readAhead) ReadAt(p []byte, off int64) (int, error) {
+	if off >= ra.off && off+int64(len(p)) <= ra.off+int64(len(ra.buf)) {
+		return copy(p, ra.buf[off-ra.off:]), nil
+	}
+	if len(p) >= ra.size {
+		return ra.f.ReadAt(p, off)
+	}
+	if cap(ra.buf) < ra.size {
+		ra.buf = make([]byte, ra.size)
+	}
+	n, err := ra.f.ReadAt(ra.buf[:ra.size], off)
+	ra.buf, ra.off = ra.buf[:n], off
+	if n >= len(p) {
+		// Reading past the end of the file is expected.
+		return copy(p, ra.buf), nil
+	}
+	ra.buf = ra.buf[:0]
+	if err == nil {
+		err = io.ErrUnexpectedEOF
+	}
+	return 0, err
+}
+
+// prefetcher reads data blocks ahead of an iterator in the background,
+// once the iterator is detected to be scanning forward sequentially.
+type prefetcher struct {
+	tr *Reader
+	// Options
+	depth, trigger int
+	verifyChecksum bool
+	fillCache      bool
+
+	// Number of consecutive data blocks read, and end of the last one.
+	seq  int
+	next uint64
+	// Requests for the data blocks following the current one, in order.
+	pending []*prefetchReq
+}
+
+type prefetchReq struct {
+	bh   blockHandle
+	done chan struct{}
+	b    *block
+	rel  util.Releaser
+	err  error
+}
+
+func (p *prefetcher) start(bh blockHandle) {
+	req := &prefetchReq{bh: bh, done: make(chan struct{})}
+	p.pending = append(p.pending, req)
+	go func() {
+		defer close(req.done)
+		p.tr.mu.RLock()
+		defer p.tr.mu.RUnlock()
+		if p.tr.err != nil {
+			req.err = p.tr.err
+			return
+		}
+		req.b, req.rel, req.err = p.tr.readBlockCached(bh, p.verifyChecksum, p.fillCache)
+	}()
+}
+
+// Returns the request for the given data block, if prefetched, and updates
+// sequential scan detection. Requests for other data blocks are dropped.
+func (p *prefetcher) get(bh blockHandle) *prefetchReq {
+	var req *prefetchReq
+	if len(p.pending) > 0 && p.pending[0].bh == bh {
+		req = p.pending[0]
+		p.pending = p.pending[1:]
+	} else {
+		p.release()
+	}
+	if req != nil || bh.offset == p.next {
+		p.seq++
+	} else {
+		p.seq = 0
+	}
+	p.next = bh.offset + bh.length + blockTrailerLen
+	return req
+}
+
+func (p *prefetcher) release() {
+	for _, req := range p.pending {
+		go func(req *prefetchReq) {
+			<-req.done
+			if req.rel != nil {
+				req.rel.Release()
+			}
+		}(req)
+	}
+	p.pending = nil
+}
+
 type indexIter struct {
 	*blockIter
 	tr    *Reader
 	slice *util.Range
 	// Options
-	fillCache bool
+	verifyChecksum bool
+	fillCache      bool
+	ra             *readAhead
+	pf             *prefetcher
+	// Whether the prefetcher is shared with other index iterators, that is
+	// those of other index partitions.
+	pfShared bool
+}
+
+// Prefetches data blocks following the current index position.
+func (i *indexIter) prefetch() {
+	if i.dir != dirForward {
+		return
+	}
+	p := i.pf
+	offset := i.offset
+	for k := 0; k < p.depth && offset < i.offsetLimit; k++ {
+		_, value, _, n, err := i.block.entry(offset)
+		if err != nil || n == 0 {
+			return
+		}
+		offset += n
+		if k < len(p.pending) {
+			continue
+		}
+		bh, m := decodeBlockHandle(value)
+		if m == 0 {
+			return
+		}
+		p.start(bh)
+	}
+}
+
+func (i *indexIter) Release() {
+	if i.pf != nil && !i.pfShared {
+		i.pf.release()
+	}
+	i.blockIter.Release()
 }
 
 func (i *indexIter) Get() iterator.Iterator {
@@ -504,7 +693,85 @@ func (i *indexIter) Get() iterator.Iterator {
 	if i.slice != nil && (i.blockIter.isFirst() || i.blockIter.isLast()) {
 		slice = i.slice
 	}
-	return i.tr.getDataIterErr(dataBH, slice, i.tr.verifyChecksum, i.fillCache)
+	if i.pf != nil {
+		req := i.pf.get(dataBH)
+		if i.pf.seq >= i.pf.trigger {
+			i.prefetch()
+		}
+		if req != nil {
+			<-req.done
+			if req.err != nil {
+				return iterator.NewEmptyIterator(req.err)
+			}
+			return i.tr.newBlockIter(req.b, req.rel, slice, false)
+		}
+	}
+	return i.tr.getDataIterErr(i.ra.readerAt(i.tr.reader), dataBH, slice, i.verifyChecksum, i.fillCache)
+}
+
+// partitionIter iterates over the top-level index of a partitioned index.
+// Its Get returns either an iterator over data blocks of the partition, or
+// if index is true, an iterator over the partition itself.
+type partitionIter struct {
+	*blockIter
+	tr    *Reader
+	slice *util.Range
+	// Options
+	verifyChecksum bool
+	fillCache      bool
+	strict         bool
+	index          bool
+	ra             *readAhead
+	pf             *prefetcher
+}
+
+func (i *partitionIter) Get() iterator.Iterator {
+	value := i.Value()
+	if value == nil {
+		return nil
+	}
+	partitionBH, _, ok := decodePartitionHandles(value)
+	if !ok {
+		return iterator.NewEmptyIterator(i.tr.newErrCorruptedBH(i.tr.indexBH, "bad index partition handle"))
+	}
+
+	var slice *util.Range
+	if i.slice != nil && (i.blockIter.isFirst() || i.blockIter.isLast()) {
+		slice = i.slice
+	}
+	if i.index {
+		// The reader lock is already held by the caller.
+		return i.tr.getPartitionIter(partitionBH, slice, i.fillCache)
+	}
+
+	i.tr.mu.RLock()
+	defer i.tr.mu.RUnlock()
+	if i.tr.err != nil {
+		return iterator.NewEmptyIterator(i.tr.err)
+	}
+	partition := i.tr.getPartitionIter(partitionBH, slice, i.fillCache)
+	bi, ok := partition.(*blockIter)
+	if !ok {
+		return partition
+	}
+	index := &indexIter{
+		blockIter:      bi,
+		tr:             i.tr,
+		slice:          slice,
+		verifyChecksum: i.verifyChecksum,
+		fillCache:      i.fillCache,
+		ra:             i.ra,
+		pf:             i.pf,
+		pfShared:       true,
+	}
+	return iterator.NewIndexedIterator(index, i.strict)
+}
+
+func (i *partitionIter) Release() {
+	if i.pf != nil {
+		i.pf.release()
+	}
+	i.blockIter.Release()
 }
 
 // Reader is a table reader.
@@ -513,6 +780,7 @@ type Reader struct {
 	fd     storage.FileDesc
 	reader io.ReaderAt
 	cache  *cache.NamespaceGetter
+	ccache *cache.NamespaceGetter
 	err    error
 	bpool  *util.BufferPool
 	// Options
@@ -520,11 +788,18 @@ type Reader struct {
 	cmp            comparer.Comparer
 	filter         filter.Filter
 	verifyChecksum bool
+	checksumType   byte
 
 	dataEnd                   int64
 	metaBH, indexBH, filterBH blockHandle
+	propsBH                   blockHandle
 	indexBlock                *block
 	filterBlock               *filterBlock
+	unpin                     func()
+	// Whether the index and filter are partitioned, and whether the filter
+	// covers the whole table.
+	partitioned, partitionedFilter bool
+	fullFilter                     bool
 }
 
 func (r *Reader) blockKind(bh blockHandle) string {
@@ -537,12 +812,16 @@ func (r *Reader) blockKind(bh blockHandle) string {
 		if r.filterBH.length > 0 {
 			return "filter-block"
 		}
+	case r.propsBH.offset:
+		if r.propsBH.length > 0 {
+			return "properties-block"
+		}
 	}
 	return "data-block"
 }
 
 func (r *Reader) newErrCorrupted(pos, size int64, kind, reason string) error {
-	return &errors.ErrCorrupted{Fd: r.fd, Err: &ErrCorrupted{Pos: pos, Size: size, Kind: kind, Reason: reason}}
+	return errors.NewErrCorruptedAt(r.fd, pos, &ErrCorrupted{Pos: pos, Size: size, Kind: kind, Reason: reason})
 }
 
 func (r *Reader) newErrCorruptedBH(bh blockHandle, reason string) error {
@@ -554,68 +833,152 @@ func (r *Reader) fixErrCorruptedBH(bh blockHandle, err error) error {
 		cerr.Pos = int64(bh.offset)
 		cerr.Size = int64(bh.length)
 		cerr.Kind = r.blockKind(bh)
-		return &errors.ErrCorrupted{Fd: r.fd, Err: cerr}
+		return errors.NewErrCorruptedAt(r.fd, cerr.Pos, cerr)
 	}
 	return err
 }
 
-func (r *Reader) readRawBlock(bh blockHandle, verifyChecksum bool) ([]byte, error) {
+// Reads a block as stored in the file, that is with its compression type
+// but without checksum.
+func (r *Reader) readStoredBlock(f io.ReaderAt, bh blockHandle, verifyChecksum bool) ([]byte, error) {
 	data := r.bpool.Get(int(bh.length + blockTrailerLen))
-	if _, err := r.reader.ReadAt(data, int64(bh.offset)); err != nil && err != io.EOF {
+	if _, err := f.ReadAt(data, int64(bh.offset)); err != nil && err != io.EOF {
 		return nil, err
 	}
 
 	if verifyChecksum {
 		n := bh.length + 1
 		checksum0 := binary.LittleEndian.Uint32(data[n:])
-		checksum1 := util.NewCRC(data[:n]).Value()
+		checksum1 := blockChecksum(r.checksumType, data[:n])
 		if checksum0 != checksum1 {
 			r.bpool.Put(data)
 			return nil, r.newErrCorruptedBH(bh, fmt.Sprintf("checksum mismatch, want=%#x got=%#x", checksum0, checksum1))
 		}
 	}
+	return data[:bh.length+1], nil
+}
 
+// Decodes a block as stored in the file. If pooled is true, the stored
+// block buffer belongs to the buffer pool and is reused or put back.
+func (r *Reader) decodeStoredBlock(bh blockHandle, data []byte, pooled bool) ([]byte, error) {
+	put := func(b []byte) {
+		if pooled {
+			r.bpool.Put(b)
+		}
+	}
 	switch data[bh.length] {
 	case blockTypeNoCompression:
+		if !pooled {
+			return append(r.bpool.Get(int(bh.length))[:0], data[:bh.length]...), nil
+		}
 		data = data[:bh.length]
 	case blockTypeSnappyCompression:
 		decLen, err := snappy.DecodedLen(data[:bh.length])
 		if err != nil {
-			r.bpool.Put(data)
+			put(data)
 			return nil, r.newErrCorruptedBH(bh, err.Error())
 		}
 		decData := r.bpool.Get(decLen)
 		decData, err = snappy.Decode(decData, data[:bh.length])
-		r.bpool.Put(data)
+		put(data)
 		if err != nil {
 			r.bpool.Put(decData)
 			return nil, r.newErrCorruptedBH(bh, err.Error())
 		}
 		data = decData
 	default:
-		r.bpool.Put(data)
+		put(data)
 		return nil, r.newErrCorruptedBH(bh, fmt.Sprintf("unknown compression type %#x", data[bh.length]))
 	}
 	return data, nil
 }
 
-func (r *Reader) readBlock(bh blockHandle, verifyChecksum bool) (*block, error) {
-	data, err := r.readRawBlock(bh, verifyChecksum)
+func (r *Reader) readRawBlock(bh blockHandle, verifyChecksum bool) ([]byte, error) {
+	data, err := r.readStoredBlock(r.reader, bh, verifyChecksum)
 	if err != nil {
 		return nil, err
 	}
+	return r.decodeStoredBlock(bh, data, true)
+}
+
+func (r *Reader) newBlock(bh blockHandle, data []byte) *block {
 	restartsLen := int(binary.LittleEndian.Uint32(data[len(data)-4:]))
-	b := &block{
+	return &block{
 		bpool:          r.bpool,
 		bh:             bh,
 		data:           data,
 		restartsLen:    restartsLen,
 		restartsOffset: len(data) - (restartsLen+1)*4,
 	}
-	return b, nil
+}
+
+func (r *Reader) readBlock(bh blockHandle, verifyChecksum bool) (*block, error) {
+	data, err := r.readRawBlock(bh, verifyChecksum)
+	if err != nil {
+		return nil, err
+	}
+	return r.newBlock(bh, data), nil
+}
+
+// compressedBlock is a block as stored in the file, held by the compressed
+// block cache.
+type compressedBlock []byte
+
+// Reads a block through the compressed block cache, if any.
+func (r *Reader) readBlockCompressedCached(f io.ReaderAt, bh blockHandle, verifyChecksum, fillCache bool) (*block, error) {
+	if r.ccache == nil {
+		stored, err := r.readStoredBlock(f, bh, verifyChecksum)
+		if err != nil {
+			return nil, err
+		}
+		data, err := r.decodeStoredBlock(bh, stored, true)
+		if err != nil {
+			return nil, err
+		}
+		return r.newBlock(bh, data), nil
+	}
+
+	if ch := r.ccache.Get(bh.offset, nil); ch != nil {
+		stored, ok := ch.Value().(compressedBlock)
+		if !ok {
+			ch.Release()
+			return nil, errors.New("leveldb/table: inconsistent block type")
+		}
+		data, err := r.decodeStoredBlock(bh, stored, false)
+		ch.Release()
+		if err != nil {
+			return nil, err
+		}
+		return r.newBlock(bh, data), nil
+	}
+
+	stored, err := r.readStoredBlock(f, bh, verifyChecksum)
+	if err != nil {
+		return nil, err
+	}
+	// Only blocks with verified checksum are cached, as the compressed block
+	// cache is trusted.
+	if fillCache && verifyChecksum && stored[bh.length] != blockTypeNoCompression {
+		cb := append(compressedBlock(nil), stored...)
+		if ch := r.ccache.Get(bh.offset, func() (size int, value cache.Value) {
+			return cap(cb) + compressedBlockCacheOverhead, cb
+		}); ch != nil {
+			ch.Release()
+		}
+	}
+	data, err := r.decodeStoredBlock(bh, stored, true)
+	if err != nil {
+		return nil, err
+	}
+	return r.newBlock(bh, data), nil
 }
 
 func (r *Reader) readBlockCached(bh blockHandle, verifyChecksum, fillCache bool) (*block, util.Releaser, error) {
+	return r.readBlockCachedFrom(r.reader, bh, verifyChecksum, fillCache)
+}
+
+// Reads a block through the block cache, reading from f on miss.
+func (r *Reader) readBlockCachedFrom(f io.ReaderAt, bh blockHandle, verifyChecksum, fillCache bool) (*block, util.Releaser, error) {
 	if r.cache != nil {
 		var (
 			err error
@@ -624,11 +987,11 @@ func (r *Reader) readBlockCached(bh blockHandle, verifyChecksum, fillCache bool)
 		if fillCache {
 			ch = r.cache.Get(bh.offset, func() (size int, value cache.Value) {
 				var b *block
-				b, err = r.readBlock(bh, verifyChecksum)
+				b, err = r.readBlockCompressedCached(f, bh, verifyChecksum, true)
 				if err != nil {
 					return 0, nil
 				}
-				return cap(b.data), b
+				return cap(b.data) + blockCacheOverhead, b
 			})
 		} else {
 			ch = r.cache.Get(bh.offset, nil)
@@ -645,7 +1008,7 @@ func (r *Reader) readBlockCached(bh blockHandle, verifyChecksum, fillCache bool)
 		}
 	}
 
-	b, err := r.readBlock(bh, verifyChecksum)
+	b, err := r.readBlockCompressedCached(f, bh, verifyChecksum, fillCache)
 	return b, b, err
 }
 
@@ -665,6 +1028,7 @@ func (r *Reader) readFilterBlock(bh blockHandle) (*filterBlock, error) {
 	}
 	b := &filterBlock{
 		bpool:      r.bpool,
+		bh:         bh,
 		data:       data,
 		oOffset:    oOffset,
 		baseLg:     uint(data[n-1]),
@@ -686,7 +1050,7 @@ func (r *Reader) readFilterBlockCached(bh blockHandle, fillCache bool) (*filterB
 				if err != nil {
 					return 0, nil
 				}
-				return cap(b.data), b
+				return cap(b.data) + filterBlockCacheOverhead, b
 			})
 		} else {
 			ch = r.cache.Get(bh.offset, nil)
@@ -714,6 +1078,80 @@ func (r *Reader) getIndexBlock(fillCache bool) (b *block, rel util.Releaser, err
 	return r.indexBlock, util.NoopReleaser{}, nil
 }
 
+func (r *Reader) getPartitionIter(partitionBH blockHandle, slice *util.Range, fillCache bool) iterator.Iterator {
+	b, rel, err := r.readBlockCached(partitionBH, true, fillCache)
+	if err != nil {
+		return iterator.NewEmptyIterator(err)
+	}
+	return r.newBlockIter(b, rel, slice, true)
+}
+
+// Returns an iterator over data block handles, which goes through the index
+// partitions if the index is partitioned. The reader lock must be held.
+func (r *Reader) newIndexIter(fillCache bool) (iterator.Iterator, error) {
+	indexBlock, rel, err := r.getIndexBlock(fillCache)
+	if err != nil {
+		return nil, err
+	}
+	index := r.newBlockIter(indexBlock, rel, nil, true)
+	if !r.partitioned {
+		return index, nil
+	}
+	top := &partitionIter{
+		blockIter: index,
+		tr:        r,
+		fillCache: fillCache,
+		strict:    true,
+		index:     true,
+	}
+	return iterator.NewIndexedIterator(top, true), nil
+}
+
+// Reports whether the full filter, or the filter of the index partition
+// that may contain the key, matches. The reader lock must be held.
+func (r *Reader) mayContain(key []byte) (bool, error) {
+	if r.fullFilter {
+		filterBlock, frel, err := r.getFilterBlock(true)
+		if err != nil {
+			if errors.IsCorrupted(err) {
+				return true, nil
+			}
+			return false, err
+		}
+		defer frel.Release()
+		// Full filter has a single filter data for all keys.
+		return filterBlock.contains(r.filter, 0, key), nil
+	}
+
+	indexBlock, rel, err := r.getIndexBlock(true)
+	if err != nil {
+		return false, err
+	}
+	top := r.newBlockIter(indexBlock, rel, nil, true)
+	defer top.Release()
+	if !top.Seek(key) {
+		return false, top.Error()
+	}
+	_, filterBH, ok := decodePartitionHandles(top.Value())
+	if !ok {
+		r.err = r.newErrCorruptedBH(r.indexBH, "bad index partition handle")
+		return false, r.err
+	}
+	if filterBH.length == 0 {
+		return true, nil
+	}
+	filterBlock, frel, err := r.readFilterBlockCached(filterBH, true)
+	if err != nil {
+		if errors.IsCorrupted(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	defer frel.Release()
+	// Partition filter has a single filter data for all its keys.
+	return filterBlock.contains(r.filter, 0, key), nil
+}
+
 func (r *Reader) getFilterBlock(fillCache bool) (*filterBlock, util.Releaser, error) {
 	if r.filterBlock == nil {
 		return r.readFilterBlockCached(r.filterBH, fillCache)
@@ -761,15 +1199,15 @@ func (r *Reader) newBlockIter(b *block, bReleaser util.Releaser, slice *util.Ran
 	return bi
 }
 
-func (r *Reader) getDataIter(dataBH blockHandle, slice *util.Range, verifyChecksum, fillCache bool) iterator.Iterator {
-	b, rel, err := r.readBlockCached(dataBH, verifyChecksum, fillCache)
+func (r *Reader) getDataIter(f io.ReaderAt, dataBH blockHandle, slice *util.Range, verifyChecksum, fillCache bool) iterator.Iterator {
+	b, rel, err := r.readBlockCachedFrom(f, dataBH, verifyChecksum, fillCache)
 	if err != nil {
 		return iterator.NewEmptyIterator(err)
 	}
 	return r.newBlockIter(b, rel, slice, false)
 }
 
-func (r *Reader) getDataIterErr(dataBH blockHandle, slice *util.Range, verifyChecksum, fillCache bool) iterator.Iterator {
+func (r *Reader) getDataIterErr(f io.ReaderAt, dataBH blockHandle, slice *util.Range, verifyChecksum, fillCache bool) iterator.Iterator {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
@@ -777,7 +1215,7 @@ func (r *Reader) getDataIterErr(dataBH blockHandle, slice *util.Range, verifyChe
 		return iterator.NewEmptyIterator(r.err)
 	}
 
-	return r.getDataIter(dataBH, slice, verifyChecksum, fillCache)
+	return r.getDataIter(f, dataBH, slice, verifyChecksum, fillCache)
 }
 
 // NewIterator creates an iterator from the table.
@@ -808,16 +1246,54 @@ func (r *Reader) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.It
 	if err != nil {
 		return iterator.NewEmptyIterator(err)
 	}
+	verifyChecksum := r.verifyDataChecksum(ro)
+	var ra *readAhead
+	if size := ro.GetReadAheadSize(); size > 0 {
+		ra = &readAhead{size: size}
+	}
+	var pf *prefetcher
+	if depth := ro.GetPrefetchBlocks(); depth > 0 {
+		pf = &prefetcher{
+			tr:             r,
+			depth:          depth,
+			trigger:        ro.GetPrefetchTrigger(),
+			verifyChecksum: verifyChecksum,
+			fillCache:      fillCache,
+		}
+	}
+	if r.partitioned {
+		strict := opt.GetStrict(r.o, ro, opt.StrictReader)
+		top := &partitionIter{
+			blockIter:      r.newBlockIter(indexBlock, rel, slice, true),
+			tr:             r,
+			slice:          slice,
+			verifyChecksum: verifyChecksum,
+			fillCache:      fillCache,
+			strict:         strict,
+			ra:             ra,
+			pf:             pf,
+		}
+		return iterator.NewIndexedIterator(top, strict)
+	}
 	index := &indexIter{
-		blockIter: r.newBlockIter(indexBlock, rel, slice, true),
-		tr:        r,
-		slice:     slice,
-		fillCache: !ro.GetDontFillCache(),
+		blockIter:      r.newBlockIter(indexBlock, rel, slice, true),
+		tr:             r,
+		slice:          slice,
+		verifyChecksum: verifyChecksum,
+		fillCache:      fillCache,
+		ra:             ra,
+		pf:             pf,
 	}
 	return iterator.NewIndexedIterator(index, opt.GetStrict(r.o, ro, opt.StrictReader))
 }
 
-func (r *Reader) find(key []byte, filtered bool, ro *opt.ReadOptions, noValue bool) (rkey, value []byte, err error) {
+// Whether data block checksums are verified by a read with the given
+// options; need the reader lock.
+func (r *Reader) verifyDataChecksum(ro *opt.ReadOptions) bool {
+	return r.verifyChecksum && !ro.GetSkipBlockChecksum()
+}
+
+func (r *Reader) find(key []byte, filtered bool, ro *opt.ReadOptions, noValue bool, dst []byte) (rkey, value []byte, err error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
@@ -826,13 +1302,19 @@ func (r *Reader) find(key []byte, filtered bool, ro *opt.ReadOptions, noValue bo
 		return
 	}
 
-	indexBlock, rel, err := r.getIndexBlock(true)
+	if filtered && r.filter != nil && (r.partitionedFilter || r.fullFilter) {
+		var ok bool
+		if ok, err = r.mayContain(key); err != nil {
+			return
+		} else if !ok {
+			return nil, nil, ErrNotFound
+		}
+	}
+
+	index, err := r.newIndexIter(true)
 	if err != nil {
 		return
 	}
-	defer rel.Release()
-
-	index := r.newBlockIter(indexBlock, nil, nil, true)
 	defer index.Release()
 
 	if !index.Seek(key) {
@@ -849,7 +1331,7 @@ func (r *Reader) find(key []byte, filtered bool, ro *opt.ReadOptions, noValue bo
 	}
 
 	// The filter should only used for exact match.
-	if filtered && r.filter != nil {
+	if filtered && r.filter != nil && !r.partitionedFilter && !r.fullFilter {
 		filterBlock, frel, ferr := r.getFilterBlock(true)
 		if ferr == nil {
 			if !filterBlock.contains(r.filter, dataBH.offset, key) {
@@ -862,7 +1344,7 @@ func (r *Reader) find(key []byte, filtered bool, ro *opt.ReadOptions, noValue bo
 		}
 	}
 
-	data := r.getDataIter(dataBH, nil, r.verifyChecksum, !ro.GetDontFillCache())
+	data := r.getDataIter(r.reader, dataBH, nil, r.verifyDataChecksum(ro), !ro.GetDontFillCache())
 	if !data.Seek(key) {
 		data.Release()
 		if err = data.Error(); err != nil {
@@ -883,7 +1365,7 @@ func (r *Reader) find(key []byte, filtered bool, ro *opt.ReadOptions, noValue bo
 			return nil, nil, r.err
 		}
 
-		data = r.getDataIter(dataBH, nil, r.verifyChecksum, !ro.GetDontFillCache())
+		data = r.getDataIter(r.reader, dataBH, nil, r.verifyDataChecksum(ro), !ro.GetDontFillCache())
 		if !data.Next() {
 			data.Release()
 			if err = data.Error(); err == nil {
@@ -896,18 +1378,195 @@ func (r *Reader) find(key []byte, filtered bool, ro *opt.ReadOptions, noValue bo
 	// Key doesn't use block buffer, no need to copy the buffer.
 	rkey = data.Key()
 	if !noValue {
-		if r.bpool == nil {
+		if r.bpool == nil && dst == nil {
 			value = data.Value()
 		} else {
 			// Value does use block buffer, and since the buffer will be
 			// recycled, it need to be copied.
-			value = append([]byte(nil), data.Value()...)
+			value = append(dst, data.Value()...)
 		}
 	}
 	data.Release()
 	return
 }
 
+// MayContainPrefix reports whether the table may contain a key with the
+// given prefix within the given slice, according to the filter. The prefix
+// is passed to the filter as is, while the slice is in the table key space.
+// It returns true if the filter doesn't hold key prefixes.
+//
+// It is safe to modify the contents of the arguments after MayContainPrefix
+// returns.
+func (r *Reader) MayContainPrefix(prefix []byte, slice *util.Range) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.err != nil {
+		return false, r.err
+	}
+	pf, ok := r.filter.(prefixFilter)
+	if !ok {
+		return true, nil
+	}
+
+	var filterBlock *filterBlock
+	if !r.partitionedFilter {
+		b, rel, err := r.getFilterBlock(true)
+		if err != nil {
+			if errors.IsCorrupted(err) {
+				return true, nil
+			}
+			return false, err
+		}
+		defer rel.Release()
+		if r.fullFilter {
+			return b.containsPrefix(pf, 0, prefix), nil
+		}
+		filterBlock = b
+	}
+
+	// Check filters of all data blocks, or index partitions, within the
+	// slice.
+	var index iterator.Iterator
+	if r.partitionedFilter {
+		indexBlock, rel, err := r.getIndexBlock(true)
+		if err != nil {
+			return false, err
+		}
+		index = r.newBlockIter(indexBlock, rel, nil, true)
+	} else {
+		var err error
+		if index, err = r.newIndexIter(true); err != nil {
+			return false, err
+		}
+	}
+	defer index.Release()
+
+	var valid bool
+	if slice != nil && slice.Start != nil {
+		valid = index.Seek(slice.Start)
+	} else {
+		valid = index.First()
+	}
+	for ; valid; valid = index.Next() {
+		if r.partitionedFilter {
+			_, filterBH, ok := decodePartitionHandles(index.Value())
+			if !ok || filterBH.length == 0 {
+				return true, nil
+			}
+			b, rel, err := r.readFilterBlockCached(filterBH, true)
+			if err != nil {
+				if errors.IsCorrupted(err) {
+					return true, nil
+				}
+				return false, err
+			}
+			contains := b.containsPrefix(pf, 0, prefix)
+			rel.Release()
+			if contains {
+				return true, nil
+			}
+		} else {
+			dataBH, n := decodeBlockHandle(index.Value())
+			if n == 0 || filterBlock.containsPrefix(pf, dataBH.offset, prefix) {
+				return true, nil
+			}
+		}
+		if slice != nil && slice.Limit != nil && r.cmp.Compare(index.Key(), slice.Limit) >= 0 {
+			break
+		}
+	}
+	return false, index.Error()
+}
+
+// MayContainBatch sets result[i] to whether the table may contain keys[i],
+// according to the filter, probing the filter of a data block, or of the
+// whole table, once for all the keys it covers, see filter.BatchFilter.
+// The result must be at least as long as keys. It reports true for all the
+// keys if the table has no filter.
+//
+// It is safe to modify the contents of the arguments after MayContainBatch
+// returns.
+func (r *Reader) MayContainBatch(keys [][]byte, result []bool) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.err != nil {
+		return r.err
+	}
+	for i := range keys {
+		result[i] = true
+	}
+	if r.filter == nil {
+		return nil
+	}
+	if r.partitionedFilter {
+		// Each key may be in a different index partition, with a filter
+		// of its own.
+		for i, key := range keys {
+			ok, err := r.mayContain(key)
+			if err != nil {
+				return err
+			}
+			result[i] = ok
+		}
+		return nil
+	}
+
+	filterBlock, rel, err := r.getFilterBlock(true)
+	if err != nil {
+		if errors.IsCorrupted(err) {
+			return nil
+		}
+		return err
+	}
+	defer rel.Release()
+	if r.fullFilter {
+		filterBlock.containsBatch(r.filter, 0, keys, result)
+		return nil
+	}
+
+	// Probe the keys of each data block together, keys of the same block
+	// being consecutive if sorted.
+	index, err := r.newIndexIter(true)
+	if err != nil {
+		return err
+	}
+	defer index.Release()
+	var (
+		start  int
+		offset uint64
+	)
+	for i, key := range keys {
+		if !index.Seek(key) {
+			if err := index.Error(); err != nil {
+				return err
+			}
+			// Past the last key of the table.
+			if start < i {
+				filterBlock.containsBatch(r.filter, offset, keys[start:i], result[start:i])
+			}
+			result[i] = false
+			start = i + 1
+			continue
+		}
+		dataBH, n := decodeBlockHandle(index.Value())
+		if n == 0 {
+			r.err = r.newErrCorruptedBH(r.indexBH, "bad data block handle")
+			return r.err
+		}
+		if start < i && dataBH.offset != offset {
+			filterBlock.containsBatch(r.filter, offset, keys[start:i], result[start:i])
+			start = i
+		}
+		offset = dataBH.offset
+	}
+	if start < len(keys) {
+		filterBlock.containsBatch(r.filter, offset, keys[start:], result[start:])
+	}
+	return nil
+}
+
 // Find finds key/value pair whose key is greater than or equal to the
 // given key. It returns ErrNotFound if the table doesn't contain
 // such pair.
@@ -919,7 +1578,18 @@ func (r *Reader) find(key []byte, filtered bool, ro *opt.ReadOptions, noValue bo
 // own copy.
 // It is safe to modify the contents of the argument after Find returns.
 func (r *Reader) Find(key []byte, filtered bool, ro *opt.ReadOptions) (rkey, value []byte, err error) {
-	return r.find(key, filtered, ro, false)
+	return r.find(key, filtered, ro, false, nil)
+}
+
+// FindTo is like Find, but appends the value to dst and returns the
+// updated slice as value, which avoids allocating if dst has enough
+// capacity.
+func (r *Reader) FindTo(key []byte, filtered bool, ro *opt.ReadOptions, dst []byte) (rkey, value []byte, err error) {
+	if dst == nil {
+		// Non-nil, so that the value is always copied.
+		dst = []byte{}
+	}
+	return r.find(key, filtered, ro, false, dst)
 }
 
 // FindKey finds key that is greater than or equal to the given key.
@@ -932,7 +1602,7 @@ func (r *Reader) Find(key []byte, filtered bool, ro *opt.ReadOptions) (rkey, val
 // own copy.
 // It is safe to modify the contents of the argument after Find returns.
 func (r *Reader) FindKey(key []byte, filtered bool, ro *opt.ReadOptions) (rkey []byte, err error) {
-	rkey, _, err = r.find(key, filtered, ro, true)
+	rkey, _, err = r.find(key, filtered, ro, true, nil)
 	return
 }
 
@@ -951,7 +1621,7 @@ func (r *Reader) Get(key []byte, ro *opt.ReadOptions) (value []byte, err error)
 		return
 	}
 
-	rkey, value, err := r.find(key, false, ro, false)
+	rkey, value, err := r.find(key, false, ro, false, nil)
 	if err == nil && r.cmp.Compare(rkey, key) != 0 {
 		value = nil
 		err = ErrNotFound
@@ -971,13 +1641,10 @@ func (r *Reader) OffsetOf(key []byte) (offset int64, err error) {
 		return
 	}
 
-	indexBlock, rel, err := r.readBlockCached(r.indexBH, true, true)
+	index, err := r.newIndexIter(true)
 	if err != nil {
 		return
 	}
-	defer rel.Release()
-
-	index := r.newBlockIter(indexBlock, nil, nil, true)
 	defer index.Release()
 	if index.Seek(key) {
 		dataBH, n := decodeBlockHandle(index.Value())
@@ -995,6 +1662,162 @@ func (r *Reader) OffsetOf(key []byte) (offset int64, err error) {
 	return
 }
 
+// Properties returns the properties stored in the table's properties block.
+// It returns ErrNotFound if the table has no properties block, such as
+// tables written before properties were introduced.
+func (r *Reader) Properties() (*Properties, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.propsBH.length == 0 {
+		return nil, ErrNotFound
+	}
+	return r.readProperties()
+}
+
+func (r *Reader) readProperties() (*Properties, error) {
+	b, err := r.readBlock(r.propsBH, true)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Release()
+	props := &Properties{}
+	iter := r.newBlockIter(b, nil, nil, true)
+	defer iter.Release()
+	for iter.Next() {
+		value := append([]byte(nil), iter.Value()...)
+		if !props.decode(string(iter.Key()), value) {
+			return nil, r.newErrCorruptedBH(r.propsBH, fmt.Sprintf("bad property %q", iter.Key()))
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return props, nil
+}
+
+// MetaBlocksSize returns the size in bytes of the index and filter blocks.
+func (r *Reader) MetaBlocksSize() int {
+	n := int(r.indexBH.length)
+	if r.filter != nil && !r.partitionedFilter {
+		n += int(r.filterBH.length)
+	}
+	return n
+}
+
+// PinMetaBlocks reads the index and filter blocks and keeps them in memory,
+// bypassing the cache, until the reader is released. The unpin function,
+// if not nil, is called when the reader is released. On error, no block
+// is pinned and the unpin function is never called.
+//
+// It is not safe to call PinMetaBlocks concurrently with other methods.
+func (r *Reader) PinMetaBlocks(unpin func()) error {
+	if r.err != nil {
+		return r.err
+	}
+	pinnedIndex := false
+	if r.indexBlock == nil {
+		b, err := r.readBlock(r.indexBH, true)
+		if err != nil {
+			return err
+		}
+		r.indexBlock, pinnedIndex = b, true
+	}
+	if r.filter != nil && !r.partitionedFilter && r.filterBlock == nil {
+		b, err := r.readFilterBlock(r.filterBH)
+		if err != nil {
+			// Nothing stays pinned on error, as none is accounted for.
+			if pinnedIndex {
+				r.indexBlock.Release()
+				r.indexBlock = nil
+			}
+			return err
+		}
+		r.filterBlock = b
+	}
+	r.unpin = unpin
+	return nil
+}
+
+// SetCompressedCache sets the compressed block cache, which holds compressed
+// blocks as stored in the file, to be used when a block isn't found in the
+// block cache. It must be called before the reader is used.
+func (r *Reader) SetCompressedCache(cache *cache.NamespaceGetter) {
+	r.ccache = cache
+}
+
+// SetVerifyChecksum sets whether data block checksums are verified, which
+// initially follows the StrictBlockChecksum strict flag. Disabling it speeds
+// up bulk reads of a table that is known to be intact, e.g. one that was
+// just verified; reads with ReadOptions.SkipBlockChecksum never verify data
+// block checksums either way. It is safe to call concurrently with reads;
+// iterators already created keep the setting they were created with.
+func (r *Reader) SetVerifyChecksum(verify bool) {
+	r.mu.Lock()
+	r.verifyChecksum = verify
+	r.mu.Unlock()
+}
+
+// File returns the reader of the table file given to NewReader, e.g. to
+// copy the file while the Reader holds it open. It must not be used after
+// the Reader is released.
+func (r *Reader) File() io.ReaderAt {
+	return r.reader
+}
+
+// CachedBlock identifies a block of a table held in the block cache.
+type CachedBlock struct {
+	Offset, Length uint64
+	Filter         bool
+}
+
+// GetCachedBlock returns the identity of the block held by the given block
+// cache value, or false if the value is not a block.
+func GetCachedBlock(v cache.Value) (CachedBlock, bool) {
+	switch b := v.(type) {
+	case *block:
+		return CachedBlock{Offset: b.bh.offset, Length: b.bh.length}, true
+	case *filterBlock:
+		return CachedBlock{Offset: b.bh.offset, Length: b.bh.length, Filter: true}, true
+	}
+	return CachedBlock{}, false
+}
+
+// LoadBlock reads the given block into the block cache, if it is not
+// already there. It does nothing if the table has no block cache.
+//
+// It is safe to call LoadBlock concurrently.
+func (r *Reader) LoadBlock(cb CachedBlock) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.err != nil {
+		return r.err
+	}
+	if r.cache == nil {
+		return nil
+	}
+
+	// Always verify checksum, so a stale block identity can't put garbage
+	// into the block cache.
+	bh := blockHandle{offset: cb.Offset, length: cb.Length}
+	var rel util.Releaser
+	var err error
+	if cb.Filter {
+		_, rel, err = r.readFilterBlockCached(bh, true)
+	} else {
+		_, rel, err = r.readBlockCached(bh, true, true)
+	}
+	if err != nil {
+		return err
+	}
+	rel.Release()
+	return nil
+}
+
 // Release implements util.Releaser.
 // It also close the file if it is an io.Closer.
 func (r *Reader) Release() {
@@ -1012,6 +1835,10 @@ func (r *Reader) Release() {
 		r.filterBlock.Release()
 		r.filterBlock = nil
 	}
+	if r.unpin != nil {
+		r.unpin()
+		r.unpin = nil
+	}
 	r.reader = nil
 	r.cache = nil
 	r.bpool = nil
@@ -1052,6 +1879,13 @@ func NewReader(f io.ReaderAt, size int64, fd storage.FileDesc, cache *cache.Name
 		return r, nil
 	}
 
+	// Decode the block checksum type.
+	r.checksumType = footer[footerLen-len(magic)-1]
+	if r.checksumType != checksumTypeCRC32C && r.checksumType != checksumTypeXXHash64 {
+		r.err = r.newErrCorrupted(footerPos, footerLen, "table-footer", fmt.Sprintf("unknown checksum type %#x", r.checksumType))
+		return r, nil
+	}
+
 	var n int
 	// Decode the metaindex block handle.
 	r.metaBH, n = decodeBlockHandle(footer[:])
@@ -1084,7 +1918,29 @@ func NewReader(f io.ReaderAt, size int64, fd storage.FileDesc, cache *cache.Name
 	metaIter := r.newBlockIter(metaBlock, nil, nil, true)
 	for metaIter.Next() {
 		key := string(metaIter.Key())
-		if !strings.HasPrefix(key, "filter.") {
+		partitionedFilter, fullFilter := false, false
+		switch {
+		case key == partitionedIndexKey:
+			r.partitioned = true
+			continue
+		case key == propertiesKey:
+			if propsBH, n := decodeBlockHandle(metaIter.Value()); n > 0 {
+				r.propsBH = propsBH
+				if int64(propsBH.offset) < r.dataEnd {
+					r.dataEnd = int64(propsBH.offset)
+				}
+			}
+			continue
+		case strings.HasPrefix(key, partitionedFilterPrefix):
+			key = key[len(partitionedFilterPrefix)-len("filter."):]
+			partitionedFilter = true
+		case strings.HasPrefix(key, fullFilterPrefix):
+			key = key[len(fullFilterPrefix)-len("filter."):]
+			fullFilter = true
+		case !strings.HasPrefix(key, "filter."):
+			continue
+		}
+		if r.filter != nil {
 			continue
 		}
 		fn := key[7:]
@@ -1099,14 +1955,18 @@ func NewReader(f io.ReaderAt, size int64, fd storage.FileDesc, cache *cache.Name
 			}
 		}
 		if r.filter != nil {
+			if partitionedFilter {
+				r.partitionedFilter = true
+				continue
+			}
 			filterBH, n := decodeBlockHandle(metaIter.Value())
 			if n == 0 {
 				continue
 			}
 			r.filterBH = filterBH
+			r.fullFilter = fullFilter
 			// Update data end.
 			r.dataEnd = int64(filterBH.offset)
-			break
 		}
 	}
 	metaIter.Release()
@@ -1122,7 +1982,7 @@ func NewReader(f io.ReaderAt, size int64, fd storage.FileDesc, cache *cache.Name
 			}
 			return nil, err
 		}
-		if r.filter != nil {
+		if r.filter != nil && !r.partitionedFilter {
 			r.filterBlock, err = r.readFilterBlock(r.filterBH)
 			if err != nil {
 				if !errors.IsCorrupted(err) {
@@ -0,0 +1,533 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package table
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/golang/snappy"
+
+	"github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+/*
+RocksDB tables:
+
+RocksDB's BlockBasedTable format is an extension of the table format above.
+Keys are internal keys, the user key followed by 8 bytes of sequence number
+and type, as in LevelDB. Tables written by format version 1 or later have a
+longer footer:
+
+      +------------------- 41-bytes -------------------+
+     /                                                  \
+    +----------+------------------------+--------------------+------+-------------------+-----------------+
+    | checksum | metaindex block handle / index block handle / ---- | version (4-bytes) | magic (8-bytes) |
+    +----------+------------------------+--------------------+------+-------------------+-----------------+
+
+    The checksum type is zero for none, one for CRC-32C and three for the
+    low 32-bit of xxHash64.
+
+Format version 4 and later delta encode the index values: an index entry
+sharing a key prefix with its preceding entry has no value length, and its
+value is the difference of its block size with the preceding block's, as a
+zig-zag varint; the block offset follows the preceding block.
+
+The properties block, named "rocksdb.properties" in the metaindex, holds
+integer properties as varints. Tables written by RocksDB's SstFileWriter
+have all sequence numbers zero and an external_sst_file.version property.
+*/
+
+const (
+	rocksDBFooterLen = 53
+	rocksDBMagic     = "\xf7\xcf\xf4\x85\xb7\x41\xe2\x88"
+
+	// Format version written by RocksDBWriter, the oldest still written by
+	// RocksDB. It only differs from older ones in the block format of
+	// compressions other than snappy.
+	rocksDBFormatVersion = 2
+
+	rocksDBChecksumNone     = 0
+	rocksDBChecksumCRC32C   = 1
+	rocksDBChecksumXXHash64 = 3
+
+	rocksDBPropertiesKey = "rocksdb.properties"
+	rocksDBRangeDelKey   = "rocksdb.range_del"
+
+	// Internal key types.
+	rocksDBTypeDeletion       = 0x0
+	rocksDBTypeValue          = 0x1
+	rocksDBTypeSingleDeletion = 0x7
+
+	// Index types; only binary search indexes are read.
+	rocksDBIndexTypeBinarySearch = 0
+	rocksDBIndexTypeHashSearch   = 1
+)
+
+// RocksDBWriter writes a table in RocksDB's BlockBasedTable format, as
+// written by RocksDB's SstFileWriter, so that it can be ingested by a
+// RocksDB using the default bytewise comparator. The table has no filter.
+//
+// RocksDBWriter is not safe for concurrent use.
+type RocksDBWriter struct {
+	tw         Writer
+	dataBlock  blockWriter
+	indexBlock blockWriter
+	blockSize  int
+	nEntries   uint64
+	nBlocks    uint64
+	rawKeys    uint64
+	rawValues  uint64
+	dataSize   uint64
+	prevKey    []byte
+	ikScratch  []byte
+	scratch    [50]byte
+}
+
+// NewRocksDBWriter creates a new RocksDB table writer for the file. It uses
+// the compression, block size and restart interval of the options.
+func NewRocksDBWriter(f io.Writer, o *opt.Options) *RocksDBWriter {
+	w := &RocksDBWriter{
+		tw: Writer{
+			writer:       f,
+			compression:  o.GetCompression(),
+			checksumType: checksumTypeCRC32C,
+		},
+		blockSize: o.GetBlockSize(),
+	}
+	w.dataBlock.restartInterval = o.GetBlockRestartInterval()
+	w.dataBlock.scratch = w.scratch[20:]
+	w.indexBlock.restartInterval = 1
+	w.indexBlock.scratch = w.scratch[20:]
+	return w
+}
+
+// Append appends key/value pair to the table. The keys passed must be in
+// increasing bytewise order.
+//
+// It is safe to modify the contents of the arguments after Append returns.
+func (w *RocksDBWriter) Append(key, value []byte) error {
+	if w.tw.err != nil {
+		return w.tw.err
+	}
+	if w.nEntries > 0 && bytes.Compare(w.prevKey, key) >= 0 {
+		w.tw.err = fmt.Errorf("leveldb/table: RocksDBWriter: keys are not in increasing order: %q, %q", w.prevKey, key)
+		return w.tw.err
+	}
+	w.prevKey = append(w.prevKey[:0], key...)
+
+	// Sequence number zero, as written by SstFileWriter.
+	w.ikScratch = append(append(w.ikScratch[:0], key...), rocksDBTypeValue, 0, 0, 0, 0, 0, 0, 0)
+	if err := w.dataBlock.append(w.ikScratch, value); err != nil {
+		w.tw.err = err
+		return err
+	}
+	w.nEntries++
+	w.rawKeys += uint64(len(w.ikScratch))
+	w.rawValues += uint64(len(value))
+	if w.dataBlock.bytesLen() >= w.blockSize {
+		if err := w.finishBlock(); err != nil {
+			w.tw.err = err
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes the data block, indexed by its last key.
+func (w *RocksDBWriter) finishBlock() error {
+	if err := w.dataBlock.finish(); err != nil {
+		return err
+	}
+	bh, err := w.tw.writeBlock(&w.dataBlock.buf, w.tw.compression)
+	if err != nil {
+		return err
+	}
+	w.nBlocks++
+	w.dataSize += bh.length + blockTrailerLen
+	n := encodeBlockHandle(w.scratch[:20], bh)
+	if err := w.indexBlock.append(w.dataBlock.prevKey, w.scratch[:n]); err != nil {
+		return err
+	}
+	w.dataBlock.reset()
+	return nil
+}
+
+// EntriesLen returns number of entries added so far.
+func (w *RocksDBWriter) EntriesLen() int {
+	return int(w.nEntries)
+}
+
+// Close will finalize the table. Calling Append is not possible after
+// Close.
+func (w *RocksDBWriter) Close() error {
+	if w.tw.err != nil {
+		return w.tw.err
+	}
+	if w.dataBlock.nEntries > 0 || w.nEntries == 0 {
+		if err := w.finishBlock(); err != nil {
+			w.tw.err = err
+			return err
+		}
+	}
+
+	// Write the index block.
+	if err := w.indexBlock.finish(); err != nil {
+		return err
+	}
+	indexBH, err := w.tw.writeBlock(&w.indexBlock.buf, w.tw.compression)
+	if err != nil {
+		w.tw.err = err
+		return err
+	}
+
+	// Write the properties block, using the data block buffer.
+	compression := "NoCompression"
+	if w.tw.compression == opt.SnappyCompression {
+		compression = "Snappy"
+	}
+	var globalSeqno, version [8]byte
+	binary.LittleEndian.PutUint32(version[:], 2)
+	props := map[string][]byte{
+		"rocksdb.comparator":                     []byte("leveldb.BytewiseComparator"),
+		"rocksdb.compression":                    []byte(compression),
+		"rocksdb.external_sst_file.global_seqno": globalSeqno[:],
+		"rocksdb.external_sst_file.version":      version[:4],
+	}
+	for name, v := range map[string]uint64{
+		"rocksdb.data.size":       w.dataSize,
+		"rocksdb.index.size":      indexBH.length + blockTrailerLen,
+		"rocksdb.num.data.blocks": w.nBlocks,
+		"rocksdb.num.entries":     w.nEntries,
+		"rocksdb.deleted.keys":    0,
+		"rocksdb.raw.key.size":    w.rawKeys,
+		"rocksdb.raw.value.size":  w.rawValues,
+		"rocksdb.format.version":  rocksDBFormatVersion,
+	} {
+		props[name] = binary.AppendUvarint(nil, v)
+	}
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w.dataBlock.restartInterval = 1
+	for _, name := range names {
+		if err := w.dataBlock.append([]byte(name), props[name]); err != nil {
+			return err
+		}
+	}
+	if err := w.dataBlock.finish(); err != nil {
+		return err
+	}
+	propsBH, err := w.tw.writeBlock(&w.dataBlock.buf, opt.NoCompression)
+	if err != nil {
+		w.tw.err = err
+		return err
+	}
+	w.dataBlock.reset()
+
+	// Write the metaindex block.
+	n := encodeBlockHandle(w.scratch[:20], propsBH)
+	if err := w.dataBlock.append([]byte(rocksDBPropertiesKey), w.scratch[:n]); err != nil {
+		return err
+	}
+	if err := w.dataBlock.finish(); err != nil {
+		return err
+	}
+	metaindexBH, err := w.tw.writeBlock(&w.dataBlock.buf, opt.NoCompression)
+	if err != nil {
+		w.tw.err = err
+		return err
+	}
+
+	// Write the table footer.
+	var footer [rocksDBFooterLen]byte
+	footer[0] = rocksDBChecksumCRC32C
+	n = 1 + encodeBlockHandle(footer[1:], metaindexBH)
+	encodeBlockHandle(footer[n:], indexBH)
+	binary.LittleEndian.PutUint32(footer[rocksDBFooterLen-len(rocksDBMagic)-4:], rocksDBFormatVersion)
+	copy(footer[rocksDBFooterLen-len(rocksDBMagic):], rocksDBMagic)
+	if _, err := w.tw.writer.Write(footer[:]); err != nil {
+		w.tw.err = err
+		return err
+	}
+
+	w.tw.err = errors.New("leveldb/table: writer is closed")
+	return nil
+}
+
+// Reads the blocks of a RocksDB table.
+type rocksDBReader struct {
+	r            io.ReaderAt
+	checksumType int
+}
+
+func (r *rocksDBReader) corrupted(bh blockHandle, kind, reason string) error {
+	return errors.NewErrCorruptedAt(storage.FileDesc{}, int64(bh.offset), &ErrCorrupted{Pos: int64(bh.offset), Size: int64(bh.length), Kind: kind, Reason: reason})
+}
+
+// Reads, verifies and uncompresses a block, returning its entries and
+// restart points.
+func (r *rocksDBReader) readBlock(bh blockHandle, kind string) (entries []byte, restarts map[uint32]bool, err error) {
+	data := make([]byte, bh.length+blockTrailerLen)
+	if _, err := r.r.ReadAt(data, int64(bh.offset)); err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+	n := bh.length + 1
+	want := binary.LittleEndian.Uint32(data[n:])
+	switch r.checksumType {
+	case rocksDBChecksumCRC32C:
+		if util.NewCRC(data[:n]).Value() != want {
+			return nil, nil, r.corrupted(bh, kind, "checksum mismatch")
+		}
+	case rocksDBChecksumXXHash64:
+		if uint32(util.XXHash64(data[:n])) != want {
+			return nil, nil, r.corrupted(bh, kind, "checksum mismatch")
+		}
+	}
+	switch data[bh.length] {
+	case blockTypeNoCompression:
+		data = data[:bh.length]
+	case blockTypeSnappyCompression:
+		if data, err = snappy.Decode(nil, data[:bh.length]); err != nil {
+			return nil, nil, r.corrupted(bh, kind, err.Error())
+		}
+	default:
+		return nil, nil, fmt.Errorf("leveldb/table: unsupported RocksDB compression type %#x", data[bh.length])
+	}
+
+	if len(data) < 4 {
+		return nil, nil, r.corrupted(bh, kind, "block too short")
+	}
+	nRestarts := binary.LittleEndian.Uint32(data[len(data)-4:])
+	// The high bit is set if the block has a hash index, which is only
+	// written for blocks smaller than 64KiB.
+	if nRestarts&(1<<31) != 0 && len(data) <= 1<<16 {
+		return nil, nil, errors.New("leveldb/table: unsupported RocksDB data block hash index")
+	}
+	end := len(data) - 4 - int(nRestarts)*4
+	if nRestarts == 0 || end < 0 {
+		return nil, nil, r.corrupted(bh, kind, "bad restart points")
+	}
+	restarts = make(map[uint32]bool, nRestarts)
+	for i := 0; i < int(nRestarts); i++ {
+		restarts[binary.LittleEndian.Uint32(data[end+i*4:])] = true
+	}
+	return data[:end], restarts, nil
+}
+
+// Calls fn with each entry of the block, in order. If deltaValues is set,
+// the values of entries sharing a key prefix are unprefixed zig-zag varints,
+// as in delta encoded indexes.
+func (r *rocksDBReader) walkBlock(bh blockHandle, kind string, deltaValues bool, fn func(key, value []byte, delta bool) error) error {
+	entries, restarts, err := r.readBlock(bh, kind)
+	if err != nil {
+		return err
+	}
+	var key []byte
+	for off := 0; off < len(entries); {
+		if restarts[uint32(off)] {
+			key = key[:0]
+		}
+		shared, n := binary.Uvarint(entries[off:])
+		nonShared, m := binary.Uvarint(entries[off+max(n, 0):])
+		if n <= 0 || m <= 0 || shared > uint64(len(key)) {
+			return r.corrupted(bh, kind, "bad entry")
+		}
+		off += n + m
+		delta := deltaValues && shared > 0
+		var valueLen uint64
+		if !deltaValues {
+			if valueLen, n = binary.Uvarint(entries[off:]); n <= 0 {
+				return r.corrupted(bh, kind, "bad entry")
+			}
+			off += n
+		}
+		if uint64(len(entries)-off) < nonShared {
+			return r.corrupted(bh, kind, "bad entry")
+		}
+		key = append(key[:shared], entries[off:off+int(nonShared)]...)
+		off += int(nonShared)
+		if deltaValues {
+			// The value is the rest of the varints.
+			valueLen = uint64(len(entries) - off)
+			if delta {
+				_, n = binary.Varint(entries[off:])
+			} else {
+				_, n = decodeBlockHandle(entries[off:])
+			}
+			if n > 0 {
+				valueLen = uint64(n)
+			}
+		}
+		if uint64(len(entries)-off) < valueLen {
+			return r.corrupted(bh, kind, "bad entry")
+		}
+		if err := fn(key, entries[off:off+int(valueLen)], delta); err != nil {
+			return err
+		}
+		off += int(valueLen)
+	}
+	return nil
+}
+
+// ReadRocksDBTable reads a table in RocksDB's BlockBasedTable format, as
+// written by RocksDB with default options or by its SstFileWriter, and calls
+// fn with the newest entry of each user key, in bytewise key order. Deleted
+// is set if the entry is a deletion.
+//
+// Tables with a partitioned index, range deletions, merge operands, user
+// timestamps, or with a format version, compression or checksum unknown to
+// this package are not supported. Filters are ignored. The arguments of fn
+// are only valid until it returns.
+func ReadRocksDBTable(f io.ReaderAt, size int64, fn func(key, value []byte, deleted bool) error) error {
+	if size < footerLen {
+		return errors.NewErrCorrupted(storage.FileDesc{}, &ErrCorrupted{Pos: 0, Size: size, Kind: "footer", Reason: "file too short"})
+	}
+	footer := make([]byte, rocksDBFooterLen)
+	if size < rocksDBFooterLen {
+		footer = footer[:footerLen]
+	}
+	if _, err := f.ReadAt(footer, size-int64(len(footer))); err != nil && err != io.EOF {
+		return err
+	}
+	r := &rocksDBReader{r: f}
+	var handles []byte
+	switch string(footer[len(footer)-len(magic):]) {
+	case magic:
+		// LevelDB footer, as written by format version 0. A nonzero
+		// checksum type is an xxHash64 table of this package.
+		legacy := footer[len(footer)-footerLen:]
+		handles = legacy
+		r.checksumType = rocksDBChecksumCRC32C
+		if legacy[footerLen-len(magic)-1] == checksumTypeXXHash64 {
+			r.checksumType = rocksDBChecksumXXHash64
+		}
+	case rocksDBMagic:
+		if len(footer) != rocksDBFooterLen {
+			return errors.New("leveldb/table: bad RocksDB table footer")
+		}
+		if version := binary.LittleEndian.Uint32(footer[rocksDBFooterLen-len(rocksDBMagic)-4:]); version > 5 {
+			return fmt.Errorf("leveldb/table: unsupported RocksDB format version %d", version)
+		}
+		switch r.checksumType = int(footer[0]); r.checksumType {
+		case rocksDBChecksumNone, rocksDBChecksumCRC32C, rocksDBChecksumXXHash64:
+		default:
+			return fmt.Errorf("leveldb/table: unsupported RocksDB checksum type %d", r.checksumType)
+		}
+		handles = footer[1:]
+	default:
+		return errors.New("leveldb/table: not a RocksDB table (bad magic number)")
+	}
+	metaBH, n := decodeBlockHandle(handles)
+	if n == 0 {
+		return errors.New("leveldb/table: bad RocksDB table footer")
+	}
+	indexBH, m := decodeBlockHandle(handles[n:])
+	if m == 0 {
+		return errors.New("leveldb/table: bad RocksDB table footer")
+	}
+
+	// Metaindex and properties.
+	var propsBH blockHandle
+	err := r.walkBlock(metaBH, "metaindex-block", false, func(key, value []byte, _ bool) error {
+		switch string(key) {
+		case rocksDBPropertiesKey:
+			if propsBH, n = decodeBlockHandle(value); n == 0 {
+				return r.corrupted(metaBH, "metaindex-block", "bad properties block handle")
+			}
+		case rocksDBRangeDelKey:
+			return errors.New("leveldb/table: unsupported RocksDB range deletions")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var deltaValues bool
+	if propsBH.length > 0 {
+		err := r.walkBlock(propsBH, "properties-block", false, func(key, value []byte, _ bool) error {
+			switch string(key) {
+			case "rocksdb.index.value.is.delta.encoded":
+				v, _ := binary.Uvarint(value)
+				deltaValues = v != 0
+			case "rocksdb.block.based.table.index.type":
+				if len(value) != 4 {
+					return r.corrupted(propsBH, "properties-block", "bad index type")
+				}
+				switch t := binary.LittleEndian.Uint32(value); t {
+				case rocksDBIndexTypeBinarySearch, rocksDBIndexTypeHashSearch:
+				default:
+					return fmt.Errorf("leveldb/table: unsupported RocksDB index type %d", t)
+				}
+			case "rocksdb.timestamp_size":
+				if v, _ := binary.Uvarint(value); v != 0 {
+					return errors.New("leveldb/table: unsupported RocksDB user timestamps")
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Index, and data blocks.
+	var (
+		dataBHs []blockHandle
+		prev    blockHandle
+	)
+	err = r.walkBlock(indexBH, "index-block", deltaValues, func(_, value []byte, delta bool) error {
+		if delta {
+			d, _ := binary.Varint(value)
+			prev = blockHandle{prev.offset + prev.length + blockTrailerLen, uint64(int64(prev.length) + d)}
+		} else if prev, n = decodeBlockHandle(value); n == 0 {
+			return r.corrupted(indexBH, "index-block", "bad data block handle")
+		}
+		dataBHs = append(dataBHs, prev)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var (
+		prevKey []byte
+		seen    bool
+	)
+	for _, bh := range dataBHs {
+		err := r.walkBlock(bh, "data-block", false, func(ikey, value []byte, _ bool) error {
+			if len(ikey) < 8 {
+				return r.corrupted(bh, "data-block", "bad internal key")
+			}
+			key := ikey[:len(ikey)-8]
+			// Older versions of a key follow the newest.
+			if seen && bytes.Equal(prevKey, key) {
+				return nil
+			}
+			prevKey, seen = append(prevKey[:0], key...), true
+			switch t := ikey[len(ikey)-8]; t {
+			case rocksDBTypeValue:
+				return fn(key, value, false)
+			case rocksDBTypeDeletion, rocksDBTypeSingleDeletion:
+				return fn(key, nil, true)
+			default:
+				return fmt.Errorf("leveldb/table: unsupported RocksDB entry type %#x", t)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
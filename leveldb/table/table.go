@@ -9,6 +9,8 @@ package table
 
 import (
 	"encoding/binary"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 /*
@@ -33,14 +35,35 @@ Table data structure:
 
     Each block followed by a 5-bytes trailer contains compression type and checksum.
 
+Partitioned index:
+
+Index block may be partitioned, in which case the metaindex block has an
+"index.partitioned" entry. Index partitions are written between data blocks,
+each followed by an optional filter block covering all keys of the data
+blocks it indexes. The index block then becomes a top-level index; its key
+is the last key of the partition and its value is the partition block handle,
+followed by the filter block handle if any. The filter name is kept in the
+metaindex block with "partitionedfilter." prefix.
+
+Full filter:
+
+Filter block may also hold a single filter data covering all keys of the
+table, in which case its name is kept in the metaindex block with
+"fullfilter." prefix instead of "filter.".
+
+    +--------------+-----+--------------+-----------------+--------------+-----+-----------------+-------------+--------+
+    | data block 1 | ... | data block n | index partition | filter block | ... | metaindex block | index block | footer |
+    +--------------+-----+--------------+-----------------+--------------+-----+-----------------+-------------+--------+
+
 Table block trailer:
 
     +---------------------------+-------------------+
     | compression type (1-byte) | checksum (4-byte) |
     +---------------------------+-------------------+
 
-    The checksum is a CRC-32 computed using Castagnoli's polynomial. Compression
-    type also included in the checksum.
+    The checksum is a CRC-32 computed using Castagnoli's polynomial, or the
+    low 32-bit of xxHash64, as given by the table footer. Compression type
+    also included in the checksum.
 
 Table footer:
 
@@ -52,6 +75,10 @@ Table footer:
 
     The magic are first 64-bit of SHA-1 sum of "http://code.google.com/p/leveldb/".
 
+    The last byte before the magic is the block checksum type: zero for
+    CRC-32C, one for the low 32-bit of xxHash64. Block handles take at most
+    36 bytes, so older tables always have zero there.
+
 NOTE: All fixed-length integer are little-endian.
 */
 
@@ -147,12 +174,31 @@ const (
 
 	magic = "\x57\xfb\x80\x8b\x24\x75\x47\xdb"
 
+	// Metaindex keys of partitioned index and filter, and of full filter.
+	partitionedIndexKey     = "index.partitioned"
+	partitionedFilterPrefix = "partitionedfilter."
+	fullFilterPrefix        = "fullfilter."
+
 	// The block type gives the per-block compression format.
 	// These constants are part of the file format and should not be changed.
 	blockTypeNoCompression     = 0
 	blockTypeSnappyCompression = 1
+
+	// The checksum type gives the block checksum algorithm, stored in the
+	// footer. These constants are part of the file format and should not
+	// be changed.
+	checksumTypeCRC32C   = 0
+	checksumTypeXXHash64 = 1
 )
 
+// Computes the block checksum of the given type.
+func blockChecksum(checksumType byte, b []byte) uint32 {
+	if checksumType == checksumTypeXXHash64 {
+		return uint32(util.XXHash64(b))
+	}
+	return util.NewCRC(b).Value()
+}
+
 type blockHandle struct {
 	offset, length uint64
 }
@@ -166,6 +212,24 @@ func decodeBlockHandle(src []byte) (blockHandle, int) {
 	return blockHandle{offset, length}, n + m
 }
 
+// Decodes the top-level index entry value of a partitioned index. The
+// filter block handle is zero if the partition has no filter.
+func decodePartitionHandles(src []byte) (partitionBH, filterBH blockHandle, ok bool) {
+	partitionBH, n := decodeBlockHandle(src)
+	if n == 0 {
+		return
+	}
+	if n < len(src) {
+		var m int
+		filterBH, m = decodeBlockHandle(src[n:])
+		if m == 0 {
+			return
+		}
+	}
+	ok = true
+	return
+}
+
 func encodeBlockHandle(dst []byte, b blockHandle) int {
 	n := binary.PutUvarint(dst, b.offset)
 	m := binary.PutUvarint(dst[n:], b.length)
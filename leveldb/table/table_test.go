@@ -8,10 +8,17 @@ package table
 
 import (
 	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	"github.com/syndtr/goleveldb/leveldb/cache"
+	"github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/filter"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/storage"
@@ -35,6 +42,48 @@ func (t tableWrapper) TestNewIterator(slice *util.Range) iterator.Iterator {
 	return t.Reader.NewIterator(slice, nil)
 }
 
+type countingReaderAt struct {
+	*bytes.Reader
+	reads int
+}
+
+func (r *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.reads++
+	return r.Reader.ReadAt(p, off)
+}
+
+// failingReaderAt fails the reads at the given offset.
+type failingReaderAt struct {
+	*bytes.Reader
+	off int64
+}
+
+func (r *failingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off == r.off {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return r.Reader.ReadAt(p, off)
+}
+
+type recordingReaderAt struct {
+	*bytes.Reader
+	mu    sync.Mutex
+	reads map[int64]int
+}
+
+func (r *recordingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	r.reads[off]++
+	r.mu.Unlock()
+	return r.Reader.ReadAt(p, off)
+}
+
+func (r *recordingReaderAt) readsOf(off int64) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reads[off]
+}
+
 var _ = testutil.Defer(func() {
 	Describe("Table", func() {
 		Describe("approximate offset test", func() {
@@ -127,5 +176,578 @@ var _ = testutil.Defer(func() {
 				})
 			}))
 		})
+
+		Describe("partitioned index read test", func() {
+			o := &opt.Options{
+				BlockSize:            256,
+				BlockRestartInterval: 3,
+				IndexPartitionSize:   64,
+				Filter:               filter.NewBloomFilter(10),
+			}
+			Build := func(kv testutil.KeyValue) testutil.DB {
+				buf := &bytes.Buffer{}
+
+				// Building the table.
+				tw := NewWriter(buf, o, nil, 0)
+				kv.Iterate(func(i int, key, value []byte) {
+					Expect(tw.Append(key, value)).ShouldNot(HaveOccurred())
+				})
+				tw.Close()
+
+				// Opening the table.
+				ns := &cache.NamespaceGetter{Cache: cache.NewCache(cache.NewLRU(16 * opt.KiB))}
+				tr, _ := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), storage.FileDesc{}, ns, nil, o)
+				return tableWrapper{tr}
+			}
+
+			testutil.AllKeyValueTesting(nil, Build, nil, nil)
+			kv := testutil.KeyValue_Generate(nil, 200, 1, 1, 10, 16, 64)
+			It("should partition index and filter", func() {
+				tr := Build(*kv).(tableWrapper).Reader
+				Expect(tr.partitioned).Should(BeTrue())
+				Expect(tr.partitionedFilter).Should(BeTrue())
+				indexBlock, err := tr.readBlock(tr.indexBH, true)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(indexBlock.restartsLen).Should(BeNumerically(">", 1))
+
+				kv.Iterate(func(i int, key, value []byte) {
+					rkey, rvalue, err := tr.Find(key, true, nil)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(rkey).Should(Equal(key))
+					Expect(rvalue).Should(Equal(value))
+
+					_, err = tr.OffsetOf(key)
+					Expect(err).ShouldNot(HaveOccurred())
+				})
+			})
+		})
+
+		Describe("full filter read test", func() {
+			o := &opt.Options{
+				BlockSize:            256,
+				BlockRestartInterval: 3,
+				Filter:               filter.NewBloomFilter(10),
+				FullTableFilter:      true,
+			}
+			Build := func(kv testutil.KeyValue) testutil.DB {
+				buf := &bytes.Buffer{}
+
+				// Building the table.
+				tw := NewWriter(buf, o, nil, 0)
+				kv.Iterate(func(i int, key, value []byte) {
+					Expect(tw.Append(key, value)).ShouldNot(HaveOccurred())
+				})
+				tw.Close()
+
+				// Opening the table.
+				tr, _ := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), storage.FileDesc{}, nil, nil, o)
+				return tableWrapper{tr}
+			}
+
+			testutil.AllKeyValueTesting(nil, Build, nil, nil)
+			kv := testutil.KeyValue_Generate(nil, 200, 1, 1, 10, 16, 64)
+			It("should have a single filter", func() {
+				tr := Build(*kv).(tableWrapper).Reader
+				Expect(tr.fullFilter).Should(BeTrue())
+				Expect(tr.filterBlock.filtersNum).Should(Equal(1))
+
+				kv.Iterate(func(i int, key, value []byte) {
+					rkey, rvalue, err := tr.Find(key, true, nil)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(rkey).Should(Equal(key))
+					Expect(rvalue).Should(Equal(value))
+				})
+				var misses int
+				for i := 0; i < 1000; i++ {
+					key := []byte(fmt.Sprintf("missing%d", i))
+					if _, _, err := tr.Find(key, true, nil); err == ErrNotFound {
+						misses++
+					}
+				}
+				Expect(misses).Should(BeNumerically(">", 950))
+			})
+		})
+
+		Describe("xxHash64 checksum test", func() {
+			o := &opt.Options{
+				BlockSize:            256,
+				BlockRestartInterval: 3,
+				BlockChecksum:        opt.XXHash64Checksum,
+				Strict:               opt.StrictBlockChecksum,
+			}
+			write := func(kv testutil.KeyValue) []byte {
+				buf := &bytes.Buffer{}
+				tw := NewWriter(buf, o, nil, 0)
+				kv.Iterate(func(i int, key, value []byte) {
+					Expect(tw.Append(key, value)).ShouldNot(HaveOccurred())
+				})
+				Expect(tw.Close()).ShouldNot(HaveOccurred())
+				return buf.Bytes()
+			}
+			Build := func(kv testutil.KeyValue) testutil.DB {
+				b := write(kv)
+				tr, _ := NewReader(bytes.NewReader(b), int64(len(b)), storage.FileDesc{}, nil, nil, o)
+				return tableWrapper{tr}
+			}
+
+			testutil.AllKeyValueTesting(nil, Build, nil, nil)
+			kv := testutil.KeyValue_Generate(nil, 200, 1, 1, 10, 16, 64)
+			It("should record the checksum type and detect corruption", func() {
+				b := write(*kv)
+				Expect(b[len(b)-len(magic)-1]).Should(Equal(byte(checksumTypeXXHash64)))
+
+				// Corrupt the first data block.
+				b[0] ^= 0xff
+				tr, err := NewReader(bytes.NewReader(b), int64(len(b)), storage.FileDesc{}, nil, nil, o)
+				Expect(err).ShouldNot(HaveOccurred())
+				key, _ := kv.Index(0)
+				_, _, err = tr.Find(key, true, nil)
+				Expect(errors.IsCorrupted(err)).Should(BeTrue())
+			})
+		})
+
+		Describe("properties test", func() {
+			o := &opt.Options{
+				BlockSize:   256,
+				Compression: opt.SnappyCompression,
+				TablePropertiesCollectors: []func() opt.TablePropertiesCollector{
+					func() opt.TablePropertiesCollector { return &countCollector{} },
+				},
+			}
+			kv := testutil.KeyValue_Generate(nil, 200, 1, 1, 10, 16, 64)
+			buf := &bytes.Buffer{}
+			tw := NewWriter(buf, o, nil, 0)
+			var rawKeySize, rawValueSize uint64
+			kv.Iterate(func(i int, key, value []byte) {
+				rawKeySize += uint64(len(key))
+				rawValueSize += uint64(len(value))
+				Expect(tw.Append(key, value)).ShouldNot(HaveOccurred())
+			})
+			Expect(tw.Close()).ShouldNot(HaveOccurred())
+			blocks := tw.BlocksLen()
+			b := buf.Bytes()
+			tr, err := NewReader(bytes.NewReader(b), int64(len(b)), storage.FileDesc{}, nil, nil, o)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Build := func(kv testutil.KeyValue) testutil.DB {
+				return tableWrapper{tr}
+			}
+			testutil.KeyValueTesting(nil, *kv, nil, Build, nil)
+
+			It("should read back the table properties", func() {
+				props, err := tr.Properties()
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(props.NumEntries).Should(BeNumerically("==", kv.Len()))
+				Expect(props.NumDataBlocks).Should(BeNumerically("==", blocks))
+				Expect(props.RawKeySize).Should(Equal(rawKeySize))
+				Expect(props.RawValueSize).Should(Equal(rawValueSize))
+				Expect(props.DataSize).Should(BeNumerically(">", 0))
+				Expect(props.DataSize).Should(BeNumerically("<", rawKeySize+rawValueSize))
+				first, _ := kv.Index(0)
+				last, _ := kv.Index(kv.Len() - 1)
+				Expect(props.SmallestKey).Should(Equal(first))
+				Expect(props.LargestKey).Should(Equal(last))
+				Expect(props.CreationTime).Should(BeNumerically(">", 0))
+				Expect(props.User).Should(Equal(map[string][]byte{"count": []byte(fmt.Sprint(kv.Len()))}))
+
+				offset, err := tr.OffsetOf([]byte("\xff\xff\xff"))
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(offset).Should(BeNumerically("==", props.DataSize))
+			})
+		})
+
+		Describe("pin test", func() {
+			It("should pin nothing when the filter block fails to read", func() {
+				o := &opt.Options{Filter: filter.NewBloomFilter(10)}
+				kv := testutil.KeyValue_Generate(nil, 200, 1, 1, 10, 16, 64)
+				buf := &bytes.Buffer{}
+				tw := NewWriter(buf, o, nil, 0)
+				kv.Iterate(func(i int, key, value []byte) {
+					Expect(tw.Append(key, value)).ShouldNot(HaveOccurred())
+				})
+				Expect(tw.Close()).ShouldNot(HaveOccurred())
+				b := buf.Bytes()
+				r := &failingReaderAt{Reader: bytes.NewReader(b), off: -1}
+				ns := &cache.NamespaceGetter{Cache: cache.NewCache(cache.NewLRU(16 * opt.KiB))}
+				tr, err := NewReader(r, int64(len(b)), storage.FileDesc{}, ns, nil, o)
+				Expect(err).ShouldNot(HaveOccurred())
+				r.off = int64(tr.filterBH.offset)
+
+				unpinned := false
+				Expect(tr.PinMetaBlocks(func() { unpinned = true })).Should(HaveOccurred())
+				Expect(tr.indexBlock).Should(BeNil())
+				Expect(tr.filterBlock).Should(BeNil())
+				tr.Release()
+				Expect(unpinned).Should(BeFalse())
+			})
+		})
+
+		Describe("metaindex test", func() {
+			kv := testutil.KeyValue_Generate(nil, 200, 1, 1, 10, 16, 64)
+			for _, c := range []struct {
+				name string
+				o    *opt.Options
+				keys []string
+			}{
+				{"filter", &opt.Options{Filter: filter.NewBloomFilter(10)},
+					[]string{"filter.leveldb.BuiltinBloomFilter", "leveldb.properties"}},
+				{"full filter", &opt.Options{Filter: filter.NewBloomFilter(10), FullTableFilter: true},
+					[]string{"fullfilter.leveldb.BuiltinBloomFilter", "leveldb.properties"}},
+				{"partitioned index", &opt.Options{Filter: filter.NewBloomFilter(10), IndexPartitionSize: 64},
+					[]string{"index.partitioned", "leveldb.properties", "partitionedfilter.leveldb.BuiltinBloomFilter"}},
+			} {
+				c := c
+				It("should sort the keys with a "+c.name, func() {
+					buf := &bytes.Buffer{}
+					tw := NewWriter(buf, c.o, nil, 0)
+					kv.Iterate(func(i int, key, value []byte) {
+						Expect(tw.Append(key, value)).ShouldNot(HaveOccurred())
+					})
+					Expect(tw.Close()).ShouldNot(HaveOccurred())
+					tr, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), storage.FileDesc{}, nil, nil, c.o)
+					Expect(err).ShouldNot(HaveOccurred())
+
+					metaBlock, err := tr.readBlock(tr.metaBH, true)
+					Expect(err).ShouldNot(HaveOccurred())
+					iter := tr.newBlockIter(metaBlock, nil, nil, true)
+					defer iter.Release()
+					var keys []string
+					for iter.Next() {
+						keys = append(keys, string(iter.Key()))
+					}
+					Expect(iter.Error()).ShouldNot(HaveOccurred())
+					Expect(keys).Should(Equal(c.keys))
+					// As a reader seeking the filter would.
+					Expect(iter.Seek([]byte(c.keys[0]))).Should(BeTrue())
+					Expect(string(iter.Key())).Should(Equal(c.keys[0]))
+				})
+			}
+		})
+
+		Describe("batch filter test", func() {
+			kv := testutil.KeyValue_Generate(nil, 200, 1, 1, 10, 16, 64)
+			for _, c := range []struct {
+				name string
+				o    *opt.Options
+			}{
+				{"filter", &opt.Options{BlockSize: 256, Filter: filter.NewBloomFilter(10)}},
+				{"full filter", &opt.Options{Filter: filter.NewBlockedBloomFilter(10), FullTableFilter: true}},
+				{"partitioned index", &opt.Options{Filter: filter.NewBlockedBloomFilter(10), IndexPartitionSize: 64}},
+			} {
+				c := c
+				It("should match the keys with a "+c.name, func() {
+					buf := &bytes.Buffer{}
+					tw := NewWriter(buf, c.o, nil, 0)
+					kv.Iterate(func(i int, key, value []byte) {
+						Expect(tw.Append(key, value)).ShouldNot(HaveOccurred())
+					})
+					Expect(tw.Close()).ShouldNot(HaveOccurred())
+					tr, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), storage.FileDesc{}, nil, nil, c.o)
+					Expect(err).ShouldNot(HaveOccurred())
+
+					var keys [][]byte
+					kv.Iterate(func(i int, key, value []byte) {
+						keys = append(keys, key, append(append([]byte{}, key...), "\xff\xff"...))
+					})
+					keys = append(keys, []byte("\xff\xff\xff"))
+					result := make([]bool, len(keys))
+					Expect(tr.MayContainBatch(keys, result)).ShouldNot(HaveOccurred())
+					var missing int
+					for i := 0; i < len(keys)-1; i += 2 {
+						Expect(result[i]).Should(BeTrue(), "key %q", keys[i])
+						if !result[i+1] {
+							missing++
+						}
+					}
+					Expect(missing).Should(BeNumerically(">", kv.Len()*9/10))
+					Expect(result[len(keys)-1]).Should(BeFalse())
+				})
+			}
+		})
+
+		Describe("inspect test", func() {
+			kv := testutil.KeyValue_Generate(nil, 200, 1, 1, 10, 16, 64)
+			build := func(o *opt.Options) (*Reader, int) {
+				buf := &bytes.Buffer{}
+				tw := NewWriter(buf, o, nil, 0)
+				kv.Iterate(func(i int, key, value []byte) {
+					Expect(tw.Append(key, value)).ShouldNot(HaveOccurred())
+				})
+				Expect(tw.Close()).ShouldNot(HaveOccurred())
+				b := buf.Bytes()
+				tr, err := NewReader(bytes.NewReader(b), int64(len(b)), storage.FileDesc{}, nil, nil, o)
+				Expect(err).ShouldNot(HaveOccurred())
+				return tr, tw.BlocksLen()
+			}
+
+			It("should describe the table layout", func() {
+				tr, blocks := build(&opt.Options{
+					BlockSize:   256,
+					Compression: opt.SnappyCompression,
+					Filter:      filter.NewBloomFilter(10),
+				})
+				l, err := tr.Inspect()
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(l.ChecksumType).Should(Equal("crc32c"))
+				Expect(l.Partitions).Should(BeEmpty())
+				Expect(l.DataBlocks).Should(HaveLen(blocks))
+				Expect(l.DataBlocks[0].Offset).Should(BeZero())
+				Expect(l.DataBlocks[0].Compression).Should(Equal("snappy"))
+				last, _ := kv.Index(kv.Len() - 1)
+				Expect(bytes.Compare(l.DataBlocks[blocks-1].Key, last)).Should(BeNumerically(">=", 0))
+				Expect(l.Filters).Should(Equal([]FilterInfo{{
+					Name:   "leveldb.BuiltinBloomFilter",
+					Kind:   "block",
+					Blocks: tr.filterBlock.filtersNum,
+					Size:   tr.filterBH.length,
+				}}))
+				Expect(l.MetaEntries).Should(HaveKeyWithValue("leveldb.properties", BlockHandle{tr.propsBH.offset, tr.propsBH.length}))
+				Expect(l.Index).Should(Equal(BlockHandle{tr.indexBH.offset, tr.indexBH.length}))
+				Expect(l.Properties.NumEntries).Should(BeNumerically("==", kv.Len()))
+			})
+
+			It("should describe a partitioned index", func() {
+				tr, blocks := build(&opt.Options{
+					BlockSize:          256,
+					Compression:        opt.NoCompression,
+					IndexPartitionSize: 64,
+					Filter:             filter.NewBloomFilter(10),
+				})
+				l, err := tr.Inspect()
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(len(l.Partitions)).Should(BeNumerically(">", 1))
+				Expect(l.DataBlocks).Should(HaveLen(blocks))
+				Expect(l.DataBlocks[0].Compression).Should(Equal("none"))
+				Expect(l.Filters).Should(HaveLen(1))
+				Expect(l.Filters[0].Kind).Should(Equal("partitioned"))
+				Expect(l.Filters[0].Blocks).Should(Equal(len(l.Partitions)))
+			})
+		})
+
+		Describe("read-ahead test", func() {
+			o := &opt.Options{
+				BlockSize:            256,
+				BlockRestartInterval: 3,
+			}
+			kv := testutil.KeyValue_Generate(nil, 200, 1, 1, 10, 16, 64)
+			buf := &bytes.Buffer{}
+			tw := NewWriter(buf, o, nil, 0)
+			kv.Iterate(func(i int, key, value []byte) {
+				Expect(tw.Append(key, value)).ShouldNot(HaveOccurred())
+			})
+			tw.Close()
+
+			It("should read ahead when iterating", func() {
+				f := &countingReaderAt{Reader: bytes.NewReader(buf.Bytes())}
+				tr, err := NewReader(f, int64(buf.Len()), storage.FileDesc{}, nil, nil, o)
+				Expect(err).ShouldNot(HaveOccurred())
+				blocks := tw.BlocksLen()
+
+				for _, size := range []int{0, 4096} {
+					f.reads = 0
+					iter := tr.NewIterator(nil, &opt.ReadOptions{ReadAheadSize: size})
+					var n int
+					for iter.Next() {
+						key, value := kv.Index(n)
+						Expect(iter.Key()).Should(Equal(key))
+						Expect(iter.Value()).Should(Equal(value))
+						n++
+					}
+					Expect(iter.Error()).ShouldNot(HaveOccurred())
+					iter.Release()
+					Expect(n).Should(Equal(kv.Len()))
+					if size == 0 {
+						Expect(f.reads).Should(BeNumerically(">=", blocks))
+					} else {
+						Expect(f.reads).Should(BeNumerically("<", blocks/4))
+					}
+				}
+
+				// Backward iteration still works, though it doesn't benefit.
+				iter := tr.NewIterator(nil, &opt.ReadOptions{ReadAheadSize: 4096})
+				n := kv.Len()
+				for iter.Last(); iter.Valid(); iter.Prev() {
+					n--
+					key, _ := kv.Index(n)
+					Expect(iter.Key()).Should(Equal(key))
+				}
+				Expect(iter.Error()).ShouldNot(HaveOccurred())
+				iter.Release()
+				Expect(n).Should(Equal(0))
+			})
+		})
+
+		Describe("prefetch test", func() {
+			o := &opt.Options{
+				BlockSize:            256,
+				BlockRestartInterval: 3,
+			}
+			kv := testutil.KeyValue_Generate(nil, 200, 1, 1, 10, 16, 64)
+			buf := &bytes.Buffer{}
+			tw := NewWriter(buf, o, nil, 0)
+			kv.Iterate(func(i int, key, value []byte) {
+				Expect(tw.Append(key, value)).ShouldNot(HaveOccurred())
+			})
+			tw.Close()
+
+			It("should prefetch data blocks when scanning", func() {
+				f := &recordingReaderAt{Reader: bytes.NewReader(buf.Bytes()), reads: make(map[int64]int)}
+				tr, err := NewReader(f, int64(buf.Len()), storage.FileDesc{}, nil, nil, o)
+				Expect(err).ShouldNot(HaveOccurred())
+
+				// Data block offsets, in order.
+				var offsets []int64
+				kv.Iterate(func(i int, key, value []byte) {
+					offset, err := tr.OffsetOf(key)
+					Expect(err).ShouldNot(HaveOccurred())
+					if len(offsets) == 0 || offsets[len(offsets)-1] != offset {
+						offsets = append(offsets, offset)
+					}
+				})
+				Expect(len(offsets)).Should(BeNumerically(">", 8))
+
+				iter := tr.NewIterator(nil, &opt.ReadOptions{PrefetchBlocks: 4})
+				var n, block int
+				for iter.Next() {
+					key, value := kv.Index(n)
+					Expect(iter.Key()).Should(Equal(key))
+					Expect(iter.Value()).Should(Equal(value))
+					n++
+
+					offset, err := tr.OffsetOf(key)
+					Expect(err).ShouldNot(HaveOccurred())
+					if offset != offsets[block] {
+						block++
+						Expect(offset).Should(Equal(offsets[block]))
+						if block == 3 {
+							// Sequential scan detected, following blocks
+							// are read ahead of the iterator.
+							for _, offset := range offsets[4:8] {
+								Eventually(func() int { return f.readsOf(offset) }).Should(Equal(1))
+							}
+						}
+					}
+				}
+				Expect(iter.Error()).ShouldNot(HaveOccurred())
+				iter.Release()
+				Expect(n).Should(Equal(kv.Len()))
+				for _, offset := range offsets {
+					Expect(f.readsOf(offset)).Should(Equal(1))
+				}
+
+				// Seeking around drops prefetched blocks.
+				iter = tr.NewIterator(nil, &opt.ReadOptions{PrefetchBlocks: 4, PrefetchTrigger: 1})
+				for i := 0; i < kv.Len(); i += 17 {
+					key, value := kv.Index(i)
+					Expect(iter.Seek(key)).Should(BeTrue())
+					Expect(iter.Value()).Should(Equal(value))
+					Expect(iter.Next()).Should(Equal(i+1 < kv.Len()))
+				}
+				Expect(iter.Error()).ShouldNot(HaveOccurred())
+				iter.Release()
+			})
+		})
+
+		Describe("RocksDB test", func() {
+			kv := testutil.KeyValue_Generate(nil, 300, 1, 1, 10, 16, 64)
+			read := func(b []byte) (keys, values [][]byte) {
+				err := ReadRocksDBTable(bytes.NewReader(b), int64(len(b)), func(key, value []byte, deleted bool) error {
+					Expect(deleted).Should(BeFalse())
+					keys = append(keys, append([]byte(nil), key...))
+					values = append(values, append([]byte(nil), value...))
+					return nil
+				})
+				Expect(err).ShouldNot(HaveOccurred())
+				return
+			}
+
+			It("should read back the tables it writes", func() {
+				buf := &bytes.Buffer{}
+				tw := NewRocksDBWriter(buf, &opt.Options{BlockSize: 256, Compression: opt.SnappyCompression})
+				kv.Iterate(func(i int, key, value []byte) {
+					Expect(tw.Append(key, value)).ShouldNot(HaveOccurred())
+				})
+				Expect(tw.Close()).ShouldNot(HaveOccurred())
+
+				b := buf.Bytes()
+				Expect(b[len(b)-len(rocksDBMagic):]).Should(Equal([]byte(rocksDBMagic)))
+				Expect(b[len(b)-rocksDBFooterLen]).Should(Equal(byte(rocksDBChecksumCRC32C)))
+				keys, values := read(b)
+				Expect(keys).Should(HaveLen(kv.Len()))
+				for i := range keys {
+					key, value := kv.Index(i)
+					Expect(keys[i]).Should(Equal(key))
+					Expect(values[i]).Should(Equal(value))
+				}
+
+				b[0] ^= 0xff
+				err := ReadRocksDBTable(bytes.NewReader(b), int64(len(b)), func(key, value []byte, deleted bool) error { return nil })
+				Expect(errors.IsCorrupted(err)).Should(BeTrue())
+
+				tw = NewRocksDBWriter(&bytes.Buffer{}, &opt.Options{})
+				Expect(tw.Append([]byte("b"), nil)).ShouldNot(HaveOccurred())
+				Expect(tw.Append([]byte("a"), nil)).Should(HaveOccurred())
+			})
+
+			It("should read LevelDB tables, keeping the newest entry of each key", func() {
+				buf := &bytes.Buffer{}
+				tw := NewWriter(buf, &opt.Options{BlockSize: 64}, nil, 0)
+				ikey := func(key string, seq byte, t byte) []byte {
+					return append([]byte(key), t, seq, 0, 0, 0, 0, 0, 0)
+				}
+				// The internal keys are ordered bytewise here, so that the
+				// newer entry of a is a deletion.
+				Expect(tw.Append(ikey("a", 3, rocksDBTypeDeletion), nil)).ShouldNot(HaveOccurred())
+				Expect(tw.Append(ikey("a", 1, rocksDBTypeValue), []byte("old"))).ShouldNot(HaveOccurred())
+				Expect(tw.Append(ikey("b", 2, rocksDBTypeValue), []byte("new"))).ShouldNot(HaveOccurred())
+				Expect(tw.Close()).ShouldNot(HaveOccurred())
+
+				var got []string
+				b := buf.Bytes()
+				err := ReadRocksDBTable(bytes.NewReader(b), int64(len(b)), func(key, value []byte, deleted bool) error {
+					got = append(got, fmt.Sprintf("%s=%s/%v", key, value, deleted))
+					return nil
+				})
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(got).Should(Equal([]string{"a=/true", "b=new/false"}))
+			})
+
+			It("should decode delta encoded index values", func() {
+				// Entries a => (0, 10), ab => +2 and ac => -1, one restart.
+				block := []byte{0, 1, 'a', 0, 10, 1, 1, 'b', 4, 1, 1, 'c', 1}
+				block = binary.LittleEndian.AppendUint32(block, 0)
+				block = binary.LittleEndian.AppendUint32(block, 1)
+				block = append(block, blockTypeNoCompression)
+				block = binary.LittleEndian.AppendUint32(block, util.NewCRC(block).Value())
+
+				r := &rocksDBReader{r: bytes.NewReader(block), checksumType: rocksDBChecksumCRC32C}
+				var got []string
+				err := r.walkBlock(blockHandle{0, uint64(len(block) - blockTrailerLen)}, "index-block", true, func(key, value []byte, delta bool) error {
+					if delta {
+						d, _ := binary.Varint(value)
+						got = append(got, fmt.Sprintf("%s%+d", key, d))
+					} else {
+						bh, _ := decodeBlockHandle(value)
+						got = append(got, fmt.Sprintf("%s%v", key, bh))
+					}
+					return nil
+				})
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(got).Should(Equal([]string{"a{0 10}", "ab+2", "ac-1"}))
+			})
+		})
 	})
 })
+
+type countCollector struct {
+	n int
+}
+
+func (c *countCollector) Add(key, value []byte, deleted bool) {
+	c.n++
+}
+
+func (c *countCollector) Finish(props map[string][]byte) {
+	props["count"] = []byte(fmt.Sprint(c.n))
+	props["leveldb.reserved"] = nil
+}
@@ -11,6 +11,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"sort"
+	"time"
 
 	"github.com/golang/snappy"
 
@@ -134,6 +136,12 @@ func (w *filterWriter) finish() error {
 	return w.buf.WriteByte(byte(w.baseLg))
 }
 
+func (w *filterWriter) reset() {
+	w.buf.Reset()
+	w.nKeys = 0
+	w.offsets = w.offsets[:0]
+}
+
 func (w *filterWriter) generate() {
 	// Record offset.
 	w.offsets = append(w.offsets, uint32(w.buf.Len()))
@@ -149,10 +157,13 @@ type Writer struct {
 	writer io.Writer
 	err    error
 	// Options
-	cmp         comparer.Comparer
-	filter      filter.Filter
-	compression opt.Compression
-	blockSize   int
+	cmp           comparer.Comparer
+	filter        filter.Filter
+	compression   opt.Compression
+	checksumType  byte
+	blockSize     int
+	partitionSize int
+	fullFilter    bool
 
 	bpool       *util.BufferPool
 	dataBlock   blockWriter
@@ -161,6 +172,13 @@ type Writer struct {
 	pendingBH   blockHandle
 	offset      uint64
 	nEntries    int
+	// Built-in properties, and collectors of user-defined ones.
+	props      Properties
+	collectors []opt.TablePropertiesCollector
+	// Top-level index and number of data blocks in finished index
+	// partitions; only used if the index is partitioned.
+	topIndexBlock     blockWriter
+	partitionedBlocks int
 	// Scratch allocated enough for 5 uvarint. Block writer should not use
 	// first 20-bytes since it will be used to encode block handle, which
 	// then passed to the block writer itself.
@@ -189,7 +207,7 @@ func (w *Writer) writeBlock(buf *util.Buffer, compression opt.Compression) (bh b
 
 	// Calculate the checksum.
 	n := len(b) - 4
-	checksum := util.NewCRC(b[:n]).Value()
+	checksum := blockChecksum(w.checksumType, b[:n])
 	binary.LittleEndian.PutUint32(b[n:], checksum)
 
 	// Write the buffer to the file.
@@ -226,6 +244,45 @@ func (w *Writer) flushPendingBH(key []byte) error {
 	w.dataBlock.prevKey = w.dataBlock.prevKey[:0]
 	// Clear pending block handle.
 	w.pendingBH = blockHandle{}
+	// Finish the index partition if partition size target reached.
+	if w.partitionSize > 0 && w.indexBlock.bytesLen() >= w.partitionSize {
+		return w.finishPartition()
+	}
+	return nil
+}
+
+// Writes the index partition along with its filter, and appends them to
+// the top-level index.
+func (w *Writer) finishPartition() error {
+	if err := w.indexBlock.finish(); err != nil {
+		return err
+	}
+	partitionBH, err := w.writeBlock(&w.indexBlock.buf, w.compression)
+	if err != nil {
+		return err
+	}
+	var filterBH blockHandle
+	if w.filter != nil {
+		if err := w.filterBlock.finish(); err != nil {
+			return err
+		}
+		filterBH, err = w.writeBlock(&w.filterBlock.buf, opt.NoCompression)
+		if err != nil {
+			return err
+		}
+		w.filterBlock.reset()
+	}
+	var handles [40]byte
+	n := encodeBlockHandle(handles[:], partitionBH)
+	if filterBH.length > 0 {
+		n += encodeBlockHandle(handles[n:], filterBH)
+	}
+	// The last key of the partition is also the top-level index key.
+	if err := w.topIndexBlock.append(w.indexBlock.prevKey, handles[:n]); err != nil {
+		return err
+	}
+	w.partitionedBlocks += w.indexBlock.nEntries
+	w.indexBlock.reset()
 	return nil
 }
 
@@ -238,10 +295,15 @@ func (w *Writer) finishBlock() error {
 		return err
 	}
 	w.pendingBH = bh
+	w.props.NumDataBlocks++
+	w.props.DataSize += bh.length + blockTrailerLen
 	// Reset the data block.
 	w.dataBlock.reset()
-	// Flush the filter block.
-	w.filterBlock.flush(w.offset)
+	// Flush the filter block. Partition and full filters cover the whole
+	// partition or table, so there is nothing to flush.
+	if w.partitionSize == 0 && !w.fullFilter {
+		w.filterBlock.flush(w.offset)
+	}
 	return nil
 }
 
@@ -267,6 +329,15 @@ func (w *Writer) Append(key, value []byte) error {
 	}
 	// Add key to the filter block.
 	w.filterBlock.add(key)
+	// Collect the properties.
+	if w.nEntries == 0 {
+		w.props.SmallestKey = append([]byte(nil), key...)
+	}
+	w.props.RawKeySize += uint64(len(key))
+	w.props.RawValueSize += uint64(len(value))
+	for _, c := range w.collectors {
+		c.Add(key, value, false)
+	}
 
 	// Finish the data block if block size target reached.
 	if w.dataBlock.bytesLen() >= w.blockSize {
@@ -281,7 +352,7 @@ func (w *Writer) Append(key, value []byte) error {
 
 // BlocksLen returns number of blocks written so far.
 func (w *Writer) BlocksLen() int {
-	n := w.indexBlock.nEntries
+	n := w.indexBlock.nEntries + w.partitionedBlocks
 	if w.pendingBH.length > 0 {
 		// Includes the pending block.
 		n++
@@ -316,6 +387,10 @@ func (w *Writer) Close() error {
 	if w.err != nil {
 		return w.err
 	}
+	if w.nEntries > 0 {
+		// The last key, before the index flush resets it.
+		w.props.LargestKey = append([]byte(nil), w.dataBlock.prevKey...)
+	}
 
 	// Write the last data block. Or empty data block if there
 	// aren't any data blocks at all.
@@ -328,24 +403,62 @@ func (w *Writer) Close() error {
 	if err := w.flushPendingBH(nil); err != nil {
 		return err
 	}
+	if w.partitionSize > 0 && w.indexBlock.nEntries > 0 {
+		if err := w.finishPartition(); err != nil {
+			w.err = err
+			return w.err
+		}
+	}
 
 	// Write the filter block.
 	var filterBH blockHandle
-	if err := w.filterBlock.finish(); err != nil {
-		return err
-	}
-	if buf := &w.filterBlock.buf; buf.Len() > 0 {
-		filterBH, w.err = w.writeBlock(buf, opt.NoCompression)
-		if w.err != nil {
-			return w.err
+	if w.partitionSize == 0 {
+		if err := w.filterBlock.finish(); err != nil {
+			return err
+		}
+		if buf := &w.filterBlock.buf; buf.Len() > 0 {
+			filterBH, w.err = w.writeBlock(buf, opt.NoCompression)
+			if w.err != nil {
+				return w.err
+			}
 		}
 	}
 
-	// Write the metaindex block.
+	// Write the properties block.
+	propsBH, err := w.writeProperties()
+	if err != nil {
+		w.err = err
+		return w.err
+	}
+
+	// Write the metaindex block, its keys in order.
+	type metaEntry struct {
+		key string
+		bh  blockHandle
+	}
+	meta := []metaEntry{{propertiesKey, propsBH}}
+	if w.partitionSize > 0 {
+		meta = append(meta, metaEntry{key: partitionedIndexKey})
+		if w.filter != nil {
+			meta = append(meta, metaEntry{key: partitionedFilterPrefix + w.filter.Name()})
+		}
+	}
 	if filterBH.length > 0 {
-		key := []byte("filter." + w.filter.Name())
-		n := encodeBlockHandle(w.scratch[:20], filterBH)
-		if err := w.dataBlock.append(key, w.scratch[:n]); err != nil {
+		prefix := "filter."
+		if w.fullFilter {
+			prefix = fullFilterPrefix
+		}
+		meta = append(meta, metaEntry{prefix + w.filter.Name(), filterBH})
+	}
+	sort.Slice(meta, func(i, j int) bool { return meta[i].key < meta[j].key })
+	for _, e := range meta {
+		var value []byte
+		// The partitioned index is flagged by its key alone.
+		if e.key != partitionedIndexKey {
+			n := encodeBlockHandle(w.scratch[:20], e.bh)
+			value = w.scratch[:n]
+		}
+		if err := w.dataBlock.append([]byte(e.key), value); err != nil {
 			return err
 		}
 	}
@@ -359,10 +472,14 @@ func (w *Writer) Close() error {
 	}
 
 	// Write the index block.
-	if err := w.indexBlock.finish(); err != nil {
+	indexBlock := &w.indexBlock
+	if w.partitionSize > 0 {
+		indexBlock = &w.topIndexBlock
+	}
+	if err := indexBlock.finish(); err != nil {
 		return err
 	}
-	indexBH, err := w.writeBlock(&w.indexBlock.buf, w.compression)
+	indexBH, err := w.writeBlock(&indexBlock.buf, w.compression)
 	if err != nil {
 		w.err = err
 		return w.err
@@ -375,6 +492,7 @@ func (w *Writer) Close() error {
 	}
 	n := encodeBlockHandle(footer, metaindexBH)
 	encodeBlockHandle(footer[n:], indexBH)
+	footer[footerLen-len(magic)-1] = w.checksumType
 	copy(footer[footerLen-len(magic):], magic)
 	if _, err := w.writer.Write(footer); err != nil {
 		w.err = err
@@ -386,6 +504,32 @@ func (w *Writer) Close() error {
 	return nil
 }
 
+// Writes the properties block, using the data block buffer.
+func (w *Writer) writeProperties() (blockHandle, error) {
+	w.props.NumEntries = uint64(w.nEntries)
+	w.props.CreationTime = time.Now().Unix()
+	if len(w.collectors) > 0 {
+		w.props.User = make(map[string][]byte)
+		for _, c := range w.collectors {
+			c.Finish(w.props.User)
+		}
+	}
+	restartInterval := w.dataBlock.restartInterval
+	w.dataBlock.restartInterval = 1
+	defer func() {
+		w.dataBlock.restartInterval = restartInterval
+	}()
+	if err := w.props.encode(&w.dataBlock); err != nil {
+		return blockHandle{}, err
+	}
+	if err := w.dataBlock.finish(); err != nil {
+		return blockHandle{}, err
+	}
+	bh, err := w.writeBlock(&w.dataBlock.buf, opt.NoCompression)
+	w.dataBlock.reset()
+	return bh, err
+}
+
 // NewWriter creates a new initialized table writer for the file.
 //
 // Table writer is not safe for concurrent use.
@@ -403,11 +547,17 @@ func NewWriter(f io.Writer, o *opt.Options, pool *util.BufferPool, size int) *Wr
 		cmp:             o.GetComparer(),
 		filter:          o.GetFilter(),
 		compression:     o.GetCompression(),
+		checksumType:    checksumTypeCRC32C,
 		blockSize:       o.GetBlockSize(),
+		partitionSize:   o.GetIndexPartitionSize(),
+		fullFilter:      o.GetFullTableFilter() && o.GetIndexPartitionSize() == 0,
 		comparerScratch: make([]byte, 0),
 		bpool:           pool,
 		dataBlock:       blockWriter{buf: *util.NewBuffer(bufBytes)},
 	}
+	if o.GetBlockChecksum() == opt.XXHash64Checksum {
+		w.checksumType = checksumTypeXXHash64
+	}
 	// data block
 	w.dataBlock.restartInterval = o.GetBlockRestartInterval()
 	// The first 20-bytes are used for encoding block handle.
@@ -415,11 +565,18 @@ func NewWriter(f io.Writer, o *opt.Options, pool *util.BufferPool, size int) *Wr
 	// index block
 	w.indexBlock.restartInterval = 1
 	w.indexBlock.scratch = w.scratch[20:]
+	w.topIndexBlock.restartInterval = 1
+	w.topIndexBlock.scratch = w.scratch[20:]
 	// filter block
+	for _, newCollector := range o.GetTablePropertiesCollectors() {
+		w.collectors = append(w.collectors, newCollector())
+	}
 	if w.filter != nil {
 		w.filterBlock.generator = w.filter.NewGenerator()
 		w.filterBlock.baseLg = uint(o.GetFilterBaseLg())
-		w.filterBlock.flush(0)
+		if w.partitionSize == 0 && !w.fullFilter {
+			w.filterBlock.flush(0)
+		}
 	}
 	return w
 }
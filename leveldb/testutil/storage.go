@@ -161,6 +161,10 @@ func (err emulatedError) Error() string {
 	return fmt.Sprintf("emulated storage error: %v", err.err)
 }
 
+func (err emulatedError) Unwrap() error {
+	return err.err
+}
+
 type storageLock struct {
 	s *Storage
 	l storage.Locker
@@ -501,6 +505,33 @@ func (s *Storage) Rename(oldfd, newfd storage.FileDesc) (err error) {
 	return
 }
 
+func (s *Storage) Recycle(oldfd, newfd storage.FileDesc) (w storage.Writer, err error) {
+	rs, ok := s.Storage.(storage.Recycler)
+	if !ok {
+		return nil, fmt.Errorf("testutil: storage doesn't support recycling")
+	}
+	err = s.emulateError(ModeRename, oldfd.Type)
+	if err == nil {
+		s.stall(ModeRename, oldfd.Type)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		s.assertOpen(oldfd)
+		s.assertOpen(newfd)
+		s.countNB(ModeRename, oldfd.Type, 0)
+		w, err = rs.Recycle(oldfd, newfd)
+	}
+	if err != nil {
+		s.logI("file recycle failed, oldfd=%s newfd=%s err=%v", oldfd, newfd, err)
+	} else {
+		s.logI("file recycled, oldfd=%s newfd=%s", oldfd, newfd)
+		s.opens[packFile(newfd)] = true
+		w = &writer{s, newfd, w}
+	}
+	return
+}
+
 func (s *Storage) ForceRename(oldfd, newfd storage.FileDesc) (err error) {
 	s.countNB(ModeRename, oldfd.Type, 0)
 	if err = s.Storage.Rename(oldfd, newfd); err != nil {
@@ -0,0 +1,134 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package typed
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec encodes and decodes the keys or values of a Store.
+type Codec[T any] interface {
+	// Append appends the encoding of v to dst and returns the extended
+	// buffer.
+	Append(dst []byte, v T) ([]byte, error)
+	// Decode decodes b, which it must not retain.
+	Decode(b []byte) (T, error)
+}
+
+// ErrInvalidVarint is returned when decoding an invalid varint.
+var ErrInvalidVarint = errors.New("typed: invalid varint")
+
+type stringCodec struct{}
+
+func (stringCodec) Append(dst []byte, v string) ([]byte, error) { return append(dst, v...), nil }
+func (stringCodec) Decode(b []byte) (string, error)             { return string(b), nil }
+
+// String returns the codec of strings, as their bytes. The encodings sort
+// as the strings.
+func String() Codec[string] { return stringCodec{} }
+
+type bytesCodec struct{}
+
+func (bytesCodec) Append(dst []byte, v []byte) ([]byte, error) { return append(dst, v...), nil }
+func (bytesCodec) Decode(b []byte) ([]byte, error)             { return append([]byte{}, b...), nil }
+
+// Bytes returns the codec of byte slices, as is. The decoded slices are
+// copies.
+func Bytes() Codec[[]byte] { return bytesCodec{} }
+
+type varintCodec struct{}
+
+func (varintCodec) Append(dst []byte, v int64) ([]byte, error) {
+	return binary.AppendVarint(dst, v), nil
+}
+
+func (varintCodec) Decode(b []byte) (int64, error) {
+	v, n := binary.Varint(b)
+	if n != len(b) {
+		return 0, ErrInvalidVarint
+	}
+	return v, nil
+}
+
+// Varint returns the codec of signed integers, as zig-zag varints. The
+// encodings don't sort as the integers, see Uint64 for keys.
+func Varint() Codec[int64] { return varintCodec{} }
+
+type uvarintCodec struct{}
+
+func (uvarintCodec) Append(dst []byte, v uint64) ([]byte, error) {
+	return binary.AppendUvarint(dst, v), nil
+}
+
+func (uvarintCodec) Decode(b []byte) (uint64, error) {
+	v, n := binary.Uvarint(b)
+	if n != len(b) {
+		return 0, ErrInvalidVarint
+	}
+	return v, nil
+}
+
+// Uvarint returns the codec of unsigned integers, as varints. The
+// encodings don't sort as the integers, see Uint64 for keys.
+func Uvarint() Codec[uint64] { return uvarintCodec{} }
+
+type uint64Codec struct{}
+
+func (uint64Codec) Append(dst []byte, v uint64) ([]byte, error) {
+	return binary.BigEndian.AppendUint64(dst, v), nil
+}
+
+func (uint64Codec) Decode(b []byte) (uint64, error) {
+	if len(b) != 8 {
+		return 0, errors.New("typed: invalid uint64 length")
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// Uint64 returns the codec of unsigned integers, as 8 big-endian bytes.
+// The encodings sort as the integers, as the keys of a Store should.
+func Uint64() Codec[uint64] { return uint64Codec{} }
+
+type jsonCodec[T any] struct{}
+
+func (jsonCodec[T]) Append(dst []byte, v T) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append(dst, b...), nil
+}
+
+func (jsonCodec[T]) Decode(b []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(b, &v)
+	return v, err
+}
+
+// JSON returns the codec of values of type T, as JSON, see encoding/json.
+func JSON[T any]() Codec[T] { return jsonCodec[T]{} }
+
+type protoCodec[M proto.Message] struct{}
+
+func (protoCodec[M]) Append(dst []byte, m M) ([]byte, error) {
+	return proto.MarshalOptions{Deterministic: true}.MarshalAppend(dst, m)
+}
+
+func (protoCodec[M]) Decode(b []byte) (M, error) {
+	var zero M
+	m := zero.ProtoReflect().Type().New().Interface().(M)
+	return m, proto.Unmarshal(b, m)
+}
+
+// Proto returns the codec of protobuf messages of type M, a pointer to a
+// generated message struct, in the wire format, with deterministic field
+// order.
+func Proto[M proto.Message]() Codec[M] { return protoCodec[M]{} }
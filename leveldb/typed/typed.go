@@ -0,0 +1,213 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package typed provides typed access to the entries of a DB, their keys
+// and values encoded by codecs:
+//
+//	type User struct {
+//		Name string
+//		Age  int
+//	}
+//
+//	users := typed.NewPrefixed(db, []byte("users/"), typed.Uint64(), typed.JSON[User]())
+//	err := users.Put(42, User{Name: "Alice", Age: 30}, nil)
+//	...
+//	u, err := users.Get(42, nil)
+//	...
+//	iter := users.NewIterator(nil, nil, nil)
+//	for iter.Next() {
+//		fmt.Println(iter.Key(), iter.Value().Name)
+//	}
+//	iter.Release()
+//	err = iter.Error()
+//
+// Keys iterate in the order of their encodings, so the codec of the keys
+// should encode in order when ranges are used, as String and Uint64 do.
+package typed
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Store is a typed view of the entries of a DB, or of those under a key
+// prefix. It's safe for concurrent use, as the DB.
+type Store[K, V any] struct {
+	db     *leveldb.DB
+	prefix []byte
+	keys   Codec[K]
+	values Codec[V]
+}
+
+// New returns a Store over all the entries of the DB.
+func New[K, V any](db *leveldb.DB, keys Codec[K], values Codec[V]) *Store[K, V] {
+	return NewPrefixed(db, nil, keys, values)
+}
+
+// NewPrefixed returns a Store over the entries of the DB under the given
+// key prefix, which the keys of the store exclude.
+func NewPrefixed[K, V any](db *leveldb.DB, prefix []byte, keys Codec[K], values Codec[V]) *Store[K, V] {
+	return &Store[K, V]{
+		db:     db,
+		prefix: append([]byte(nil), prefix...),
+		keys:   keys,
+		values: values,
+	}
+}
+
+// DB returns the DB of the store.
+func (s *Store[K, V]) DB() *leveldb.DB {
+	return s.db
+}
+
+func (s *Store[K, V]) key(k K) ([]byte, error) {
+	return s.keys.Append(append([]byte(nil), s.prefix...), k)
+}
+
+func (s *Store[K, V]) encode(k K, v V) (key, value []byte, err error) {
+	if key, err = s.key(k); err != nil {
+		return nil, nil, err
+	}
+	value, err = s.values.Append(nil, v)
+	return key, value, err
+}
+
+// Get gets the value for the given key. It returns leveldb.ErrNotFound if
+// the DB does not contain the key.
+func (s *Store[K, V]) Get(k K, ro *opt.ReadOptions) (v V, err error) {
+	key, err := s.key(k)
+	if err != nil {
+		return v, err
+	}
+	b, err := s.db.Get(key, ro)
+	if err != nil {
+		return v, err
+	}
+	return s.values.Decode(b)
+}
+
+// Has returns true if the DB does contain the given key.
+func (s *Store[K, V]) Has(k K, ro *opt.ReadOptions) (bool, error) {
+	key, err := s.key(k)
+	if err != nil {
+		return false, err
+	}
+	return s.db.Has(key, ro)
+}
+
+// Put sets the value for the given key.
+func (s *Store[K, V]) Put(k K, v V, wo *opt.WriteOptions) error {
+	key, value, err := s.encode(k, v)
+	if err != nil {
+		return err
+	}
+	return s.db.Put(key, value, wo)
+}
+
+// Delete deletes the value for the given key.
+func (s *Store[K, V]) Delete(k K, wo *opt.WriteOptions) error {
+	key, err := s.key(k)
+	if err != nil {
+		return err
+	}
+	return s.db.Delete(key, wo)
+}
+
+// BatchPut appends the put of the value for the given key to the batch,
+// e.g. to write atomically to several stores.
+func (s *Store[K, V]) BatchPut(b *leveldb.Batch, k K, v V) error {
+	key, value, err := s.encode(k, v)
+	if err != nil {
+		return err
+	}
+	b.Put(key, value)
+	return nil
+}
+
+// BatchDelete appends the delete of the given key to the batch.
+func (s *Store[K, V]) BatchDelete(b *leveldb.Batch, k K) error {
+	key, err := s.key(k)
+	if err != nil {
+		return err
+	}
+	b.Delete(key)
+	return nil
+}
+
+// NewIterator returns an iterator over the entries of the store, in key
+// order, from a consistent snapshot of the DB. The keys start at start,
+// included, and end before limit, either nil for no bound.
+//
+// The iterator must be released after use.
+func (s *Store[K, V]) NewIterator(start, limit *K, ro *opt.ReadOptions) *Iterator[K, V] {
+	it := &Iterator[K, V]{s: s}
+	r := util.BytesPrefix(s.prefix)
+	if len(s.prefix) == 0 {
+		r = &util.Range{}
+	}
+	if start != nil {
+		if r.Start, it.err = s.key(*start); it.err != nil {
+			return it
+		}
+	}
+	if limit != nil {
+		if r.Limit, it.err = s.key(*limit); it.err != nil {
+			return it
+		}
+	}
+	it.iter = s.db.NewIterator(r, ro)
+	return it
+}
+
+// Iterator iterates over the entries of a Store. It's not safe for
+// concurrent use.
+type Iterator[K, V any] struct {
+	s    *Store[K, V]
+	iter iterator.Iterator
+	key  K
+	val  V
+	err  error
+}
+
+// Next moves the iterator to the next entry, decoding it, and tells
+// whether there is one. It returns false at the end of the entries or on
+// an error, see Error.
+func (it *Iterator[K, V]) Next() bool {
+	if it.err != nil || it.iter == nil || !it.iter.Next() {
+		return false
+	}
+	if it.key, it.err = it.s.keys.Decode(it.iter.Key()[len(it.s.prefix):]); it.err != nil {
+		return false
+	}
+	if it.val, it.err = it.s.values.Decode(it.iter.Value()); it.err != nil {
+		return false
+	}
+	return true
+}
+
+// Key returns the key of the current entry.
+func (it *Iterator[K, V]) Key() K { return it.key }
+
+// Value returns the value of the current entry.
+func (it *Iterator[K, V]) Value() V { return it.val }
+
+// Error returns the error of the iteration, if any, including the errors
+// decoding the entries.
+func (it *Iterator[K, V]) Error() error {
+	if it.err != nil || it.iter == nil {
+		return it.err
+	}
+	return it.iter.Error()
+}
+
+// Release releases the iterator.
+func (it *Iterator[K, V]) Release() {
+	if it.iter != nil {
+		it.iter.Release()
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2012, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package typed
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+type user struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func openDB(t *testing.T) *leveldb.DB {
+	t.Helper()
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func testCodec[T any](t *testing.T, c Codec[T], values []T, equal func(a, b T) bool) {
+	t.Helper()
+	for _, v := range values {
+		b, err := c.Append([]byte("x"), v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := c.Decode(b[1:])
+		if err != nil || !equal(got, v) {
+			t.Errorf("%v: got %v, %v", v, got, err)
+		}
+	}
+}
+
+func equal[T comparable](a, b T) bool { return a == b }
+
+func TestCodecs(t *testing.T) {
+	testCodec(t, String(), []string{"", "foo"}, equal[string])
+	testCodec(t, Bytes(), [][]byte{{}, []byte("foo")}, func(a, b []byte) bool { return string(a) == string(b) })
+	testCodec(t, Varint(), []int64{0, -1, math.MinInt64, math.MaxInt64}, equal[int64])
+	testCodec(t, Uvarint(), []uint64{0, 1, math.MaxUint64}, equal[uint64])
+	testCodec(t, Uint64(), []uint64{0, 1, math.MaxUint64}, equal[uint64])
+	testCodec(t, JSON[user](), []user{{}, {"Alice", 30}}, equal[user])
+	testCodec(t, Proto[*wrapperspb.StringValue](), []*wrapperspb.StringValue{wrapperspb.String(""), wrapperspb.String("foo")},
+		func(a, b *wrapperspb.StringValue) bool { return a.GetValue() == b.GetValue() })
+
+	if _, err := Varint().Decode([]byte{0x80}); err != ErrInvalidVarint {
+		t.Errorf("truncated varint: got %v", err)
+	}
+	if _, err := Uvarint().Decode([]byte{1, 2}); err != ErrInvalidVarint {
+		t.Errorf("trailing bytes: got %v", err)
+	}
+	if _, err := Uint64().Decode([]byte{1}); err == nil {
+		t.Error("short uint64 decoded")
+	}
+}
+
+func TestStore(t *testing.T) {
+	db := openDB(t)
+	users := NewPrefixed(db, []byte("users/"), Uint64(), JSON[user]())
+	names := NewPrefixed(db, []byte("names/"), String(), Uint64())
+	db.Put([]byte("other"), []byte("x"), nil)
+
+	b := new(leveldb.Batch)
+	for i, name := range []string{"alice", "bob", "carol", "dave"} {
+		if err := users.BatchPut(b, uint64(i), user{name, 20 + i}); err != nil {
+			t.Fatal(err)
+		}
+		if err := names.BatchPut(b, name, uint64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Write(b, nil); err != nil {
+		t.Fatal(err)
+	}
+	if u, err := users.Get(1, nil); err != nil || u != (user{"bob", 21}) {
+		t.Fatalf("get: got %v, %v", u, err)
+	}
+	if err := users.Delete(3, nil); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := users.Has(3, nil); ok || err != nil {
+		t.Fatalf("has deleted: got %v, %v", ok, err)
+	}
+	if _, err := users.Get(3, nil); err != leveldb.ErrNotFound {
+		t.Fatalf("get deleted: got %v", err)
+	}
+	if err := users.Put(3, user{"eve", 40}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	list := func(it *Iterator[uint64, user]) string {
+		t.Helper()
+		defer it.Release()
+		var res []string
+		for it.Next() {
+			res = append(res, fmt.Sprintf("%d:%s", it.Key(), it.Value().Name))
+		}
+		if err := it.Error(); err != nil {
+			t.Fatal(err)
+		}
+		return strings.Join(res, " ")
+	}
+	if got := list(users.NewIterator(nil, nil, nil)); got != "0:alice 1:bob 2:carol 3:eve" {
+		t.Fatalf("all: got %q", got)
+	}
+	start, limit := uint64(1), uint64(3)
+	if got := list(users.NewIterator(&start, &limit, nil)); got != "1:bob 2:carol" {
+		t.Fatalf("range: got %q", got)
+	}
+
+	// A value the codec can't decode.
+	db.Put([]byte("names/zed"), []byte("x"), nil)
+	it := names.NewIterator(nil, nil, nil)
+	for it.Next() {
+	}
+	if it.Error() == nil {
+		t.Fatal("invalid value decoded")
+	}
+	it.Release()
+}
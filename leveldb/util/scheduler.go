@@ -0,0 +1,187 @@
+// Copyright (c) 2014, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package util
+
+import (
+	"fmt"
+	"sync"
+)
+
+// JobKind is the kind of a background job.
+type JobKind int
+
+const (
+	// JobFlush is a 'memdb' flush.
+	JobFlush JobKind = iota
+
+	// JobCompaction is a table compaction.
+	JobCompaction
+)
+
+func (k JobKind) String() string {
+	switch k {
+	case JobFlush:
+		return "flush"
+	case JobCompaction:
+		return "compaction"
+	}
+	return "invalid"
+}
+
+// Scheduler limits the number of concurrently running background jobs.
+// A single scheduler may be shared by multiple DB instances, bounding
+// their combined background work.
+//
+// Flush jobs have priority over compaction jobs: no new compaction job
+// will be started while a flush job is waiting for a slot.
+//
+// A Scheduler is safe for concurrent use.
+type Scheduler struct {
+	mu             sync.Mutex
+	maxJobs        int
+	maxFlushes     int
+	maxCompactions int
+	flushes        int
+	compactions    int
+	flushWaiting   int
+	notifyC        chan struct{}
+}
+
+// NewScheduler creates a new scheduler. The maxJobs limits total number of
+// running jobs, while maxFlushes and maxCompactions limit number of running
+// jobs of each kind. Zero or negative value means unlimited.
+func NewScheduler(maxJobs, maxFlushes, maxCompactions int) *Scheduler {
+	return &Scheduler{
+		maxJobs:        maxJobs,
+		maxFlushes:     maxFlushes,
+		maxCompactions: maxCompactions,
+		notifyC:        make(chan struct{}),
+	}
+}
+
+func (s *Scheduler) canRun(kind JobKind) bool {
+	if s.maxJobs > 0 && s.flushes+s.compactions >= s.maxJobs {
+		return false
+	}
+	switch kind {
+	case JobFlush:
+		return s.maxFlushes <= 0 || s.flushes < s.maxFlushes
+	case JobCompaction:
+		if s.flushWaiting > 0 {
+			return false
+		}
+		return s.maxCompactions <= 0 || s.compactions < s.maxCompactions
+	}
+	panic("leveldb/util: invalid job kind")
+}
+
+// TryAcquire acquires a slot for a job of the given kind without blocking.
+// It returns false if no slot available.
+func (s *Scheduler) TryAcquire(kind JobKind) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.canRun(kind) {
+		return false
+	}
+	if kind == JobFlush {
+		s.flushes++
+	} else {
+		s.compactions++
+	}
+	return true
+}
+
+// Acquire acquires a slot for a job of the given kind, blocking until a
+// slot is available or cancel is closed. It returns false if canceled.
+func (s *Scheduler) Acquire(kind JobKind, cancel <-chan struct{}) bool {
+	waiting := false
+	defer func() {
+		if waiting {
+			s.mu.Lock()
+			s.flushWaiting--
+			s.mu.Unlock()
+			s.notify()
+		}
+	}()
+	for {
+		s.mu.Lock()
+		notifyC := s.notifyC
+		if s.canRun(kind) {
+			if kind == JobFlush {
+				s.flushes++
+			} else {
+				s.compactions++
+			}
+			s.mu.Unlock()
+			return true
+		}
+		if kind == JobFlush && !waiting {
+			waiting = true
+			s.flushWaiting++
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-notifyC:
+		case <-cancel:
+			return false
+		}
+	}
+}
+
+// Release releases a slot previously acquired for a job of the given kind.
+func (s *Scheduler) Release(kind JobKind) {
+	s.mu.Lock()
+	if kind == JobFlush {
+		if s.flushes <= 0 {
+			s.mu.Unlock()
+			panic("leveldb/util: releasing unacquired flush job")
+		}
+		s.flushes--
+	} else {
+		if s.compactions <= 0 {
+			s.mu.Unlock()
+			panic("leveldb/util: releasing unacquired compaction job")
+		}
+		s.compactions--
+	}
+	s.mu.Unlock()
+	s.notify()
+}
+
+// Notify returns a channel that will be closed once a slot might become
+// available. The channel should be obtained before calling TryAcquire.
+func (s *Scheduler) Notify() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.notifyC
+}
+
+func (s *Scheduler) notify() {
+	s.mu.Lock()
+	close(s.notifyC)
+	s.notifyC = make(chan struct{})
+	s.mu.Unlock()
+}
+
+// Running returns number of running jobs of the given kind.
+func (s *Scheduler) Running(kind JobKind) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if kind == JobFlush {
+		return s.flushes
+	}
+	return s.compactions
+}
+
+func (s *Scheduler) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fmt.Sprintf("Scheduler{Flushes:%d/%d Compactions:%d/%d Jobs:%d/%d FlushWaiting:%d}",
+		s.flushes, s.maxFlushes, s.compactions, s.maxCompactions,
+		s.flushes+s.compactions, s.maxJobs, s.flushWaiting)
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2014, Suryandaru Triandana <syndtr@gmail.com>
+// All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduler_Limits(t *testing.T) {
+	s := NewScheduler(2, 1, 1)
+	if !s.TryAcquire(JobFlush) {
+		t.Fatal("cannot acquire flush job")
+	}
+	if s.TryAcquire(JobFlush) {
+		t.Fatal("flush job limit exceeded")
+	}
+	if !s.TryAcquire(JobCompaction) {
+		t.Fatal("cannot acquire compaction job")
+	}
+	if s.TryAcquire(JobCompaction) {
+		t.Fatal("compaction job limit exceeded")
+	}
+	s.Release(JobFlush)
+	s.Release(JobCompaction)
+	if n := s.Running(JobFlush) + s.Running(JobCompaction); n != 0 {
+		t.Fatalf("invalid running jobs count, want=0 got=%d", n)
+	}
+}
+
+func TestScheduler_FlushPriority(t *testing.T) {
+	s := NewScheduler(1, 0, 0)
+	if !s.TryAcquire(JobCompaction) {
+		t.Fatal("cannot acquire compaction job")
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		if s.Acquire(JobFlush, nil) {
+			close(acquired)
+		}
+	}()
+
+	// Wait until the flush is queued, then compaction shouldn't be able
+	// to jump ahead.
+	for i := 0; ; i++ {
+		s.mu.Lock()
+		waiting := s.flushWaiting
+		s.mu.Unlock()
+		if waiting > 0 {
+			break
+		}
+		if i > 1000 {
+			t.Fatal("flush job is not waiting")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	s.Release(JobCompaction)
+	if s.TryAcquire(JobCompaction) {
+		t.Fatal("compaction job acquired while flush job is waiting")
+	}
+
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("flush job not acquired")
+	}
+	s.Release(JobFlush)
+	if !s.TryAcquire(JobCompaction) {
+		t.Fatal("cannot acquire compaction job")
+	}
+}
+
+func TestScheduler_Cancel(t *testing.T) {
+	s := NewScheduler(0, 1, 0)
+	s.Acquire(JobFlush, nil)
+	cancel := make(chan struct{})
+	close(cancel)
+	if s.Acquire(JobFlush, cancel) {
+		t.Fatal("canceled acquire should fail")
+	}
+	if !s.TryAcquire(JobCompaction) {
+		t.Fatal("canceled flush shouldn't block compaction")
+	}
+}
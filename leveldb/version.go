@@ -7,11 +7,13 @@
 package leveldb
 
 import (
-	"fmt"
+	"bytes"
 	"sync/atomic"
 	"time"
 	"unsafe"
 
+	"github.com/syndtr/goleveldb/leveldb/comparer"
+	"github.com/syndtr/goleveldb/leveldb/filter"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/util"
@@ -34,6 +36,10 @@ type version struct {
 	cLevel int
 	cScore float64
 
+	// Estimated number of bytes the compactions have to rewrite to bring
+	// every level score below 1, initialized by computeCompaction().
+	cPendingBytes int64
+
 	cSeek unsafe.Pointer
 
 	closing  bool
@@ -59,7 +65,7 @@ func (v *version) incref() {
 		case v.s.refCh <- &vTask{vid: v.id, files: v.levels, created: time.Now()}:
 			// We can use v.levels directly here since it is immutable.
 		case <-v.s.closeC:
-			v.s.log("reference loop already exist")
+			v.s.logDebug("version@ref reference loop already exited")
 		}
 	}
 }
@@ -75,7 +81,7 @@ func (v *version) releaseNB() {
 	case v.s.relCh <- &vTask{vid: v.id, files: v.levels, created: time.Now()}:
 		// We can use v.levels directly here since it is immutable.
 	case <-v.s.closeC:
-		v.s.log("reference loop already exist")
+		v.s.logDebug("version@ref reference loop already exited")
 	}
 
 	v.released = true
@@ -138,7 +144,76 @@ func (v *version) walkOverlapping(aux tFiles, ikey internalKey, f func(level int
 	}
 }
 
-func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue bool) (value []byte, tcomp bool, err error) {
+// If touched isn't nil, the tables looked up are appended to it.
+func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue bool, dst []byte, touched *[]tSet) (value []byte, tcomp bool, err error) {
+	return v.lookup(aux, ikey, ro, noValue, dst, touched, nil)
+}
+
+// Gets the values of the given keys, as get does, but probing the filter of
+// each table once for all the keys it may contain. The values, and errors,
+// are set at the index of their key.
+func (v *version) getMulti(ikeys []internalKey, ro *opt.ReadOptions, values [][]byte, errs []error) (tcomp bool) {
+	// The keys each table may contain, in walk order.
+	type tableKeys struct {
+		t *tFile
+		i []int
+	}
+	var (
+		tables []*tableKeys
+		byFile = make(map[*tFile]*tableKeys)
+	)
+	for i, ikey := range ikeys {
+		v.walkOverlapping(nil, ikey, func(level int, t *tFile) bool {
+			tk := byFile[t]
+			if tk == nil {
+				tk = &tableKeys{t: t}
+				byFile[t] = tk
+				tables = append(tables, tk)
+			}
+			tk.i = append(tk.i, i)
+			return true
+		}, nil)
+	}
+
+	// Whether the filter of a table contains a key, if probed.
+	type keyTable struct {
+		i int
+		t *tFile
+	}
+	contains := make(map[keyTable]bool)
+	var (
+		keys   [][]byte
+		result []bool
+	)
+	for _, tk := range tables {
+		keys, result = keys[:0], result[:0]
+		for _, i := range tk.i {
+			keys = append(keys, ikeys[i])
+			result = append(result, false)
+		}
+		if err := v.s.tops.mayContainBatch(tk.t, keys, result); err != nil {
+			// Leave it to the lookups.
+			continue
+		}
+		for j, i := range tk.i {
+			contains[keyTable{i, tk.t}] = result[j]
+		}
+	}
+
+	for i, ikey := range ikeys {
+		var tc bool
+		values[i], tc, errs[i] = v.lookup(nil, ikey, ro, false, nil, nil, func(t *tFile) (bool, bool) {
+			c, probed := contains[keyTable{i, t}]
+			return c, probed
+		})
+		tcomp = tcomp || tc
+	}
+	return
+}
+
+// Same as get, but the filters of the tables for which probed reports a
+// result aren't probed again.
+func (v *version) lookup(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue bool, dst []byte, touched *[]tSet, probed func(t *tFile) (contains, ok bool)) (value []byte, tcomp bool, err error) {
 	if v.closing {
 		return nil, false, ErrClosed
 	}
@@ -155,6 +230,8 @@ func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue
 		zseq   uint64
 		zkt    keyType
 		zval   []byte
+		// Whether zval isn't at the end of dst.
+		zmoved bool
 	)
 
 	err = ErrNotFound
@@ -162,6 +239,10 @@ func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue
 	// Since entries never hop across level, finding key/value
 	// in smaller level make later levels irrelevant.
 	v.walkOverlapping(aux, ikey, func(level int, t *tFile) bool {
+		if touched != nil {
+			*touched = append(*touched, tSet{level, t})
+		}
+		t.recordRead()
 		if sampleSeeks && level >= 0 && !tseek {
 			if tset == nil {
 				tset = &tSet{level, t}
@@ -170,14 +251,31 @@ func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue
 			}
 		}
 
+		filtered := true
+		if probed != nil {
+			if contains, ok := probed(t); ok {
+				if !contains {
+					return true
+				}
+				filtered = false
+			}
+		}
+
 		var (
 			fikey, fval []byte
 			ferr        error
+			fmoved      bool
 		)
 		if noValue {
-			fikey, ferr = v.s.tops.findKey(t, ikey, ro)
+			fikey, ferr = v.s.tops.findKey(t, ikey, filtered, ro)
 		} else {
-			fikey, fval, ferr = v.s.tops.find(t, ikey, ro)
+			fdst := dst
+			if level <= 0 && zfound {
+				// Don't overwrite the value found so far, as it may be
+				// the newest.
+				fdst, fmoved = zval[len(zval):], true
+			}
+			fikey, fval, ferr = v.s.tops.find(t, ikey, filtered, ro, fdst)
 		}
 
 		switch ferr {
@@ -198,6 +296,7 @@ func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue
 						zseq = fseq
 						zkt = fkt
 						zval = fval
+						zmoved = fmoved
 					}
 				} else {
 					switch fkt {
@@ -222,6 +321,9 @@ func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue
 			switch zkt {
 			case keyTypeVal:
 				value = zval
+				if zmoved {
+					value = append(dst, zval...)
+				}
 				err = nil
 			case keyTypeDel:
 			default:
@@ -257,16 +359,46 @@ func (v *version) sampleSeek(ikey internalKey) (tcomp bool) {
 	return
 }
 
+// Returns the prefix shared by all keys within the given internal key
+// slice, if it can be checked against the prefix filter.
+func (v *version) slicePrefix(slice *util.Range) []byte {
+	if slice == nil || slice.Start == nil || slice.Limit == nil {
+		return nil
+	}
+	f, ok := v.s.o.GetFilter().(*iFilter)
+	if !ok {
+		return nil
+	}
+	pf, ok := f.Filter.(filter.PrefixFilter)
+	if !ok || v.s.icmp.uName() != comparer.DefaultComparer.Name() {
+		return nil
+	}
+	start, limit := internalKey(slice.Start).ukey(), internalKey(slice.Limit).ukey()
+	prefix := pf.Prefix(start)
+	if prefix == nil {
+		return nil
+	}
+	if plimit := util.BytesPrefix(prefix).Limit; plimit != nil && bytes.Compare(limit, plimit) > 0 {
+		return nil
+	}
+	return prefix
+}
+
 func (v *version) getIterators(slice *util.Range, ro *opt.ReadOptions) (its []iterator.Iterator) {
 	strict := opt.GetStrict(v.s.o.Options, ro, opt.StrictReader)
+	prefix := v.slicePrefix(slice)
 	for level, tables := range v.levels {
+		if prefix != nil {
+			tables = v.s.tops.prefixTables(tables, prefix, slice)
+		}
 		if level == 0 {
 			// Merge all level zero files together since they may overlap.
 			for _, t := range tables {
+				t.recordRead()
 				its = append(its, v.s.tops.newIterator(t, slice, ro))
 			}
 		} else if len(tables) != 0 {
-			its = append(its, iterator.NewIndexedIterator(tables.newIndexIterator(v.s.tops, v.s.icmp, slice, ro), strict))
+			its = append(its, iterator.NewIndexedIterator(tables.newIndexIterator(v.s.tops, v.s.icmp, slice, ro, true), strict))
 		}
 	}
 	return
@@ -352,34 +484,49 @@ func (v *version) pickMemdbLevel(umin, umax []byte, maxLevel int) (level int) {
 	return
 }
 
+// Returns the compaction score of the given level; the level needs
+// compaction when the score is at least 1.
+func (v *version) levelScore(level int, tables tFiles) float64 {
+	if level == 0 {
+		// We treat level-0 specially by bounding the number of files
+		// instead of number of bytes for two reasons:
+		//
+		// (1) With larger write-buffer sizes, it is nice not to do too
+		// many level-0 compaction.
+		//
+		// (2) The files in level-0 are merged on every read and
+		// therefore we wish to avoid too many files when the individual
+		// file size is small (perhaps because of a small write-buffer
+		// setting, or very high compression ratios, or lots of
+		// overwrites/deletions).
+		return float64(len(tables)) / float64(v.s.o.GetCompactionL0Trigger())
+	}
+	return float64(tables.size()) / float64(v.s.o.GetCompactionTotalSize(level))
+}
+
 func (v *version) computeCompaction() {
 	// Precomputed best level for next compaction
 	bestLevel := int(-1)
 	bestScore := float64(-1)
 
 	statFiles := make([]int, len(v.levels))
-	statSizes := make([]string, len(v.levels))
-	statScore := make([]string, len(v.levels))
+	statSizes := make([]int64, len(v.levels))
+	statScore := make([]float64, len(v.levels))
 	statTotSize := int64(0)
+	pendingBytes := int64(0)
 
 	for level, tables := range v.levels {
-		var score float64
 		size := tables.size()
-		if level == 0 {
-			// We treat level-0 specially by bounding the number of files
-			// instead of number of bytes for two reasons:
-			//
-			// (1) With larger write-buffer sizes, it is nice not to do too
-			// many level-0 compaction.
-			//
-			// (2) The files in level-0 are merged on every read and
-			// therefore we wish to avoid too many files when the individual
-			// file size is small (perhaps because of a small write-buffer
-			// setting, or very high compression ratios, or lots of
-			// overwrites/deletions).
-			score = float64(len(tables)) / float64(v.s.o.GetCompactionL0Trigger())
-		} else {
-			score = float64(size) / float64(v.s.o.GetCompactionTotalSize(level))
+		score := v.levelScore(level, tables)
+
+		// Level-0 is compacted as a whole, other levels down to their
+		// total size.
+		if score >= 1 {
+			if level == 0 {
+				pendingBytes += size
+			} else {
+				pendingBytes += size - v.s.o.GetCompactionTotalSize(level)
+			}
 		}
 
 		if score > bestScore {
@@ -388,15 +535,16 @@ func (v *version) computeCompaction() {
 		}
 
 		statFiles[level] = len(tables)
-		statSizes[level] = shortenb(size)
-		statScore[level] = fmt.Sprintf("%.2f", score)
+		statSizes[level] = size
+		statScore[level] = score
 		statTotSize += size
 	}
 
 	v.cLevel = bestLevel
 	v.cScore = bestScore
+	v.cPendingBytes = pendingBytes
 
-	v.s.logf("version@stat F·%v S·%s%v Sc·%v", statFiles, shortenb(statTotSize), statSizes, statScore)
+	v.s.logDebug("version@stat", "files", statFiles, "size", statTotSize, "sizes", statSizes, "scores", statScore, "pending", pendingBytes)
 }
 
 func (v *version) needCompaction() bool {
@@ -337,8 +337,8 @@ func main() {
 		atomic.StoreUint32(&fail, 1)
 		atomic.StoreUint32(&done, 1)
 		log.Printf("FATAL: "+format, v...)
-		if err != nil && errors.IsCorrupted(err) {
-			cerr := err.(*errors.ErrCorrupted)
+		var cerr *errors.ErrCorrupted
+		if err != nil && errors.As(err, &cerr) {
 			if !cerr.Fd.Zero() && cerr.Fd.Type == storage.TypeTable {
 				log.Print("FATAL: corruption detected, scanning...")
 				corrupted, serr := tstor.scanTable(storage.FileDesc{Type: storage.TypeTable, Num: cerr.Fd.Num}, false)
@@ -540,7 +540,7 @@ func main() {
 								getStat.record(1)
 
 								if checksum0, checksum1 := dataChecksum(v2); checksum0 != checksum1 {
-									err := &errors.ErrCorrupted{Fd: storage.FileDesc{Type: 0xff, Num: 0}, Err: fmt.Errorf("v2: %x: checksum mismatch: %v vs %v", v2, checksum0, checksum1)}
+									err := errors.NewErrCorrupted(storage.FileDesc{Type: 0xff, Num: 0}, fmt.Errorf("v2: %x: checksum mismatch: %v vs %v", v2, checksum0, checksum1))
 									fatalf(err, "[%02d] READER #%d.%d K%d snap.Get: %v\nk1: %x\n -> k2: %x", ns, snapwi, ri, n, err, k1, k2)
 								}
 